@@ -3,13 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	gracefully "github.com/tj/go-gracefully"
 	"github.com/travisjeffery/jocko/jocko"
 	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/jocko/structs"
 	"github.com/travisjeffery/jocko/log"
 	"github.com/travisjeffery/jocko/protocol"
 	"github.com/uber/jaeger-lib/metrics"
@@ -29,34 +37,222 @@ var (
 
 	brokerCfg = config.DefaultConfig()
 
+	metricsAddr string
+	adminAddr   string
+
+	// version is set when releasing.
+	version = "dev"
+
 	topicCfg = struct {
 		BrokerAddr        string
 		Topic             string
 		Partitions        int32
 		ReplicationFactor int
 	}{}
+
+	importCfg = struct {
+		BrokerAddr      string
+		FromBrokers     []string
+		Topics          []string
+		TopicRegex      string
+		PreserveOffsets bool
+	}{}
+
+	consumeCfg = struct {
+		BrokerAddr string
+		Topic      string
+		Partitions []int
+		From       string
+		Until      string
+	}{}
+
+	shadowCfg = struct {
+		BrokerAddr       string
+		ShadowBrokers    []string
+		OffsetSyncsTopic string
+		Group            string
+		Commit           bool
+	}{}
+
+	logDirsCfg = struct {
+		BrokerAddr string
+		BrokerID   int32
+		Throttle   int64
+	}{}
+
+	doctorCfg = struct {
+		Peers        []string
+		MaxClockSkew time.Duration
+		Timeout      time.Duration
+	}{}
+
+	assignmentsCfg = struct {
+		BrokerAddr string
+		File       string
+		Format     string
+		DryRun     bool
+	}{}
 )
 
 func init() {
 	brokerCmd := &cobra.Command{Use: "broker", Short: "Run a Jocko broker", Run: run}
 	brokerCmd.Flags().StringVar(&brokerCfg.RaftAddr, "raft-addr", "127.0.0.1:9093", "Address for Raft to bind and advertise on")
 	brokerCmd.Flags().StringVar(&brokerCfg.DataDir, "data-dir", "/tmp/jocko", "A comma separated list of directories under which to store log files")
+	brokerCmd.Flags().StringSliceVar(&brokerCfg.LogDirs, "log-dirs", nil, "Directories to spread partition logs across, usually one per disk, defaults to data in the data dir")
 	brokerCmd.Flags().StringVar(&brokerCfg.Addr, "broker-addr", "0.0.0.0:9092", "Address for broker to bind on")
 	brokerCmd.Flags().StringVar(&brokerCfg.SerfLANConfig.MemberlistConfig.BindAddr, "serf-addr", "0.0.0.0:9094", "Address for Serf to bind on") // TODO: can set addr alone or need to set bind port separately?
 	brokerCmd.Flags().StringSliceVar(&brokerCfg.StartJoinAddrsLAN, "join", nil, "Address of an broker serf to join at start time. Can be specified multiple times.")
 	brokerCmd.Flags().StringSliceVar(&brokerCfg.StartJoinAddrsWAN, "join-wan", nil, "Address of an broker serf to join -wan at start time. Can be specified multiple times.")
 	brokerCmd.Flags().Int32Var(&brokerCfg.ID, "id", 0, "Broker ID")
+	brokerCmd.Flags().StringVar(&brokerCfg.ClusterID, "cluster-id", "", "ID of the cluster sent to clients in metadata, the same on every broker")
+	brokerCmd.Flags().StringVar(&brokerCfg.Rack, "rack", "", "Rack the broker is in, sent to clients in metadata")
+	brokerCmd.Flags().BoolVar(&brokerCfg.ControlledShutdown, "controlled-shutdown", brokerCfg.ControlledShutdown, "Ask the controller to move partition leadership off the broker before it shuts down")
+	brokerCmd.Flags().IntVar(&brokerCfg.ControlledShutdownMaxRetries, "controlled-shutdown-max-retries", brokerCfg.ControlledShutdownMaxRetries, "Number of times to retry a controlled shutdown before shutting down anyway")
+	brokerCmd.Flags().IntVar(&brokerCfg.GroupRebalanceHistorySize, "group-rebalance-history-size", brokerCfg.GroupRebalanceHistorySize, "Number of each group's last rebalances kept for the admin API, 0 disables keeping them")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ControlledShutdownRetryBackoff, "controlled-shutdown-retry-backoff", brokerCfg.ControlledShutdownRetryBackoff, "Time to wait between controlled shutdown retries")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ShutdownStageTimeout, "shutdown-stage-timeout", brokerCfg.ShutdownStageTimeout, "Time each stage of the broker's shutdown gets before it moves on to the next")
+	brokerCmd.Flags().IntVar(&brokerCfg.ProduceHandlers, "produce-handlers", brokerCfg.ProduceHandlers, "Number of produce requests handled at once")
+	brokerCmd.Flags().IntVar(&brokerCfg.FetchHandlers, "fetch-handlers", brokerCfg.FetchHandlers, "Number of fetch requests handled at once")
+	brokerCmd.Flags().IntVar(&brokerCfg.AdminHandlers, "admin-handlers", brokerCfg.AdminHandlers, "Number of metadata and admin requests handled at once")
+	brokerCmd.Flags().Int32Var(&brokerCfg.ReplicaFetchMaxBytes, "replica-fetch-max-bytes", brokerCfg.ReplicaFetchMaxBytes, "Number of bytes of each partition followers fetch at a time, raised for batches bigger than it")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ReplicaFetchBackoff, "replica-fetch-backoff", brokerCfg.ReplicaFetchBackoff, "Time followers wait to fetch again after a fetch failed, doubled each time in a row")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ReplicaFetchBackoffMax, "replica-fetch-backoff-max", brokerCfg.ReplicaFetchBackoffMax, "Longest time followers wait to fetch again after their fetches from a leader failed in a row")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ReplicaFetchWaitMax, "replica-fetch-wait-max", brokerCfg.ReplicaFetchWaitMax, "Time leaders wait for messages before answering followers' fetches without them")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ReplicaHighWatermarkCheckpointInterval, "replica-high-watermark-checkpoint-interval", brokerCfg.ReplicaHighWatermarkCheckpointInterval, "Interval partitions' high watermarks are checkpointed to the data dir at, 0 only checkpoints them on shutdown")
+	brokerCmd.Flags().DurationVar(&brokerCfg.LeaderLeaseDuration, "leader-lease-duration", brokerCfg.LeaderLeaseDuration, "Duration of the leases the controller grants leaders on their partitions, which they stop serving once it runs out, 0 turns leases off")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ReplicaLagTimeMax, "replica-lag-time-max", brokerCfg.ReplicaLagTimeMax, "Duration a follower can go without catching up with its leader before it's taken out of the isr")
+	brokerCmd.Flags().Int64Var(&brokerCfg.ReplicaSnapshotMinBytes, "replica-snapshot-min-bytes", brokerCfg.ReplicaSnapshotMinBytes, "Number of bytes of their leader's sealed segments followers have to be behind by to copy the segments whole rather than fetch them, 0 to disable")
+	brokerCmd.Flags().BoolVar(&brokerCfg.OrderingAudit, "ordering-audit", false, "Check the offsets, producer sequences and high watermarks of partitions only go up, logging and counting any that don't")
+	brokerCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, disabled if empty")
+	brokerCmd.Flags().StringVar(&adminAddr, "admin-addr", "", "Address to serve the admin HTTP API on, disabled if empty")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ConsistencyCheckInterval, "consistency-check-interval", time.Hour, "Interval between checks of the partition logs in the log dirs against the replicas the broker is assigned, 0 disables them")
+	brokerCmd.Flags().BoolVar(&brokerCfg.FixOrphanedLogs, "fix-orphaned-logs", false, "Remove logs the consistency check finds orphaned twice in a row")
+	brokerCmd.Flags().BoolVar(&brokerCfg.PublishLeaderChanges, "publish-leader-changes", false, "Publish partitions' leadership changes to the compacted __leader_changes topic")
+	brokerCmd.Flags().Int32Var(&brokerCfg.TransactionStateNumPartitions, "transaction-state-num-partitions", 50, "Number of partitions of the __transaction_state topic, set when it's created")
+	brokerCmd.Flags().DurationVar(&brokerCfg.TransactionMaxTimeout, "transaction-max-timeout", 15*time.Minute, "Longest timeout producers can give their transactions")
+	brokerCmd.Flags().DurationVar(&brokerCfg.TransactionAbortTimedOutInterval, "transaction-abort-timed-out-interval", 10*time.Second, "Interval between aborts of the transactions that have run past their timeouts")
+	brokerCmd.Flags().StringVar(&brokerCfg.DelegationTokenSecretKey, "delegation-token-secret-key", "", "Key delegation tokens' HMACs are made with, the same on every broker, tokens are disabled if empty")
+	brokerCmd.Flags().DurationVar(&brokerCfg.DelegationTokenMaxLifetime, "delegation-token-max-lifetime", brokerCfg.DelegationTokenMaxLifetime, "Longest delegation tokens can be renewed for from when they're created")
+	brokerCmd.Flags().DurationVar(&brokerCfg.DelegationTokenExpiryTime, "delegation-token-expiry-time", brokerCfg.DelegationTokenExpiryTime, "How long delegation tokens last when created or renewed without a renew period")
+	brokerCmd.Flags().DurationVar(&brokerCfg.DelegationTokenExpiryCheckInterval, "delegation-token-expiry-check-interval", brokerCfg.DelegationTokenExpiryCheckInterval, "Interval between the controller's removals of expired delegation tokens")
+	brokerCmd.Flags().StringSliceVar(&brokerCfg.ShadowBrokers, "shadow-brokers", nil, "Bootstrap addresses of a Kafka cluster to dual-write produces to and verify against, disabled if empty")
+	brokerCmd.Flags().IntVar(&brokerCfg.ShadowQueueSize, "shadow-queue-size", brokerCfg.ShadowQueueSize, "Number of record sets that can wait to be forwarded to the shadowed cluster before more are dropped")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ShadowVerifyInterval, "shadow-verify-interval", brokerCfg.ShadowVerifyInterval, "Interval between comparisons of the checksums of the record sets forwarded to the shadowed cluster")
+	brokerCmd.Flags().StringVar(&brokerCfg.ShadowTopics, "shadow-topics", "", "Regular expression matching the topics whose produces are forwarded to the shadowed cluster, including topics created later, all topics if empty")
+	brokerCmd.Flags().StringVar(&brokerCfg.ShadowOffsetSyncsTopic, "shadow-offset-syncs-topic", brokerCfg.ShadowOffsetSyncsTopic, "Topic on the shadowed cluster to write the offset syncs consumers' offsets are translated with to, disabled if empty")
+	brokerCmd.Flags().BoolVar(&brokerCfg.KeyBloomFilters, "key-bloom-filters", false, "Keep bloom filters of the keys in each segment of compacted topics' logs in memory to speed up compaction")
+	brokerCmd.Flags().StringVar(&brokerCfg.SerfLANConfig.SnapshotPath, "serf-snapshot-path", "", "Path to persist Serf's snapshot to so the broker can rejoin quickly after restarting, defaults to serf/local.snapshot in the data dir")
+	brokerCmd.Flags().BoolVar(&brokerCfg.SerfLANConfig.RejoinAfterLeave, "serf-rejoin-after-leave", false, "Rejoin the cluster using the Serf snapshot even after leaving gracefully")
+	brokerCmd.Flags().DurationVar(&brokerCfg.SerfLANConfig.MemberlistConfig.ProbeInterval, "serf-probe-interval", brokerCfg.SerfLANConfig.MemberlistConfig.ProbeInterval, "Interval between Serf failure detection probes")
+	brokerCmd.Flags().DurationVar(&brokerCfg.SerfLANConfig.MemberlistConfig.ProbeTimeout, "serf-probe-timeout", brokerCfg.SerfLANConfig.MemberlistConfig.ProbeTimeout, "Time to wait for an ack from a probed node, should be around the 99th percentile RTT")
+	brokerCmd.Flags().DurationVar(&brokerCfg.SerfLANConfig.MemberlistConfig.GossipInterval, "serf-gossip-interval", brokerCfg.SerfLANConfig.MemberlistConfig.GossipInterval, "Interval between Serf gossip rounds")
+	brokerCmd.Flags().IntVar(&brokerCfg.SerfLANConfig.MemberlistConfig.GossipNodes, "serf-gossip-nodes", brokerCfg.SerfLANConfig.MemberlistConfig.GossipNodes, "Number of random nodes to gossip to each round (fanout)")
+	brokerCmd.Flags().IntVar(&brokerCfg.SerfLANConfig.MemberlistConfig.SuspicionMult, "serf-suspicion-mult", brokerCfg.SerfLANConfig.MemberlistConfig.SuspicionMult, "Multiplier for how long a suspect node is given to refute before being declared dead")
+	brokerCmd.Flags().IntVar(&brokerCfg.ClientSocket.SendBufferBytes, "socket-send-buffer-bytes", 0, "Send buffer size for client connections, 0 uses the OS default")
+	brokerCmd.Flags().IntVar(&brokerCfg.ClientSocket.ReceiveBufferBytes, "socket-receive-buffer-bytes", 0, "Receive buffer size for client connections, 0 uses the OS default")
+	brokerCmd.Flags().BoolVar(&brokerCfg.ClientSocket.DisableNoDelay, "socket-disable-no-delay", false, "Clear TCP_NODELAY on client connections so Nagle's algorithm batches small writes")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ClientSocket.KeepAlive, "socket-keep-alive", 0, "Keep-alive period for client connections, 0 uses the OS default")
+	brokerCmd.Flags().IntVar(&brokerCfg.SocketRequestMaxBytes, "socket-request-max-bytes", brokerCfg.SocketRequestMaxBytes, "Largest request size in bytes the broker will read, 0 for no limit")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ReconcileInterval, "reconcile-interval", brokerCfg.ReconcileInterval, "Interval between the controller's reconciles of the cluster's membership")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ReconcileMaxBackoff, "reconcile-max-backoff", brokerCfg.ReconcileMaxBackoff, "Longest the controller backs off before reconciling again while reconciles keep failing")
+	brokerCmd.Flags().DurationVar(&brokerCfg.FailedNodeTTL, "failed-node-ttl", brokerCfg.FailedNodeTTL, "How long a broker can be failed before its replicas are moved and it is deregistered, 0 to never deregister failed brokers")
+	brokerCmd.Flags().BoolVar(&brokerCfg.AutoLeaderRebalance, "auto-leader-rebalance", brokerCfg.AutoLeaderRebalance, "Move partition leadership back to preferred replicas once they're in sync")
+	brokerCmd.Flags().IntVar(&brokerCfg.PartitionFailureThreshold, "partition-failure-threshold", brokerCfg.PartitionFailureThreshold, "Number of consecutive log errors before a partition's replica is taken offline, 0 to never take replicas offline")
+	brokerCmd.Flags().DurationVar(&brokerCfg.PartitionFailureCooldown, "partition-failure-cooldown", brokerCfg.PartitionFailureCooldown, "How long an offline replica's log is left before it's tried again")
+	brokerCmd.Flags().Int32Var(&brokerCfg.NumPartitions, "num-partitions", brokerCfg.NumPartitions, "Number of partitions of topics created without one")
+	brokerCmd.Flags().IntVar(&brokerCfg.DefaultReplicationFactor, "default-replication-factor", brokerCfg.DefaultReplicationFactor, "Replication factor of topics created without one")
+	brokerCmd.Flags().BoolVar(&brokerCfg.AllowLiveGroupOffsetReset, "allow-live-group-offset-reset", brokerCfg.AllowLiveGroupOffsetReset, "Allow resetting the offsets of groups that have active members")
+	brokerCmd.Flags().DurationVar(&brokerCfg.OffsetsRetention, "offsets-retention", brokerCfg.OffsetsRetention, "How long offsets committed by groups are kept once the groups are empty")
+	brokerCmd.Flags().DurationVar(&brokerCfg.OffsetsRetentionCheckInterval, "offsets-retention-check-interval", brokerCfg.OffsetsRetentionCheckInterval, "Interval between removals of groups' expired offsets")
+	brokerCmd.Flags().IntVar(&brokerCfg.OffsetMetadataMaxBytes, "offset-metadata-max-bytes", brokerCfg.OffsetMetadataMaxBytes, "Max length of the metadata groups commit with their offsets")
+	brokerCmd.Flags().DurationVar(&brokerCfg.GroupEmptyRetention, "group-empty-retention", brokerCfg.GroupEmptyRetention, "How long groups can go without members or offsets committed before they're deleted, 0 keeps them")
+	brokerCmd.Flags().DurationVar(&brokerCfg.GroupMinSessionTimeout, "group-min-session-timeout", brokerCfg.GroupMinSessionTimeout, "Shortest session timeout group members can join with")
+	brokerCmd.Flags().DurationVar(&brokerCfg.GroupMaxSessionTimeout, "group-max-session-timeout", brokerCfg.GroupMaxSessionTimeout, "Longest session timeout group members can join with")
+	brokerCmd.Flags().IntVar(&brokerCfg.GroupMaxSize, "group-max-size", brokerCfg.GroupMaxSize, "Most members a group can have, 0 for no limit")
+	brokerCmd.Flags().IntVar(&brokerCfg.ReplicaSocket.SendBufferBytes, "replica-socket-send-buffer-bytes", 0, "Send buffer size for inter-broker connections, 0 uses the OS default")
+	brokerCmd.Flags().IntVar(&brokerCfg.ReplicaSocket.ReceiveBufferBytes, "replica-socket-receive-buffer-bytes", 0, "Receive buffer size for inter-broker connections, 0 uses the OS default")
+	brokerCmd.Flags().BoolVar(&brokerCfg.ReplicaSocket.DisableNoDelay, "replica-socket-disable-no-delay", false, "Clear TCP_NODELAY on inter-broker connections so Nagle's algorithm batches small writes")
+	brokerCmd.Flags().DurationVar(&brokerCfg.ReplicaSocket.KeepAlive, "replica-socket-keep-alive", 30*time.Second, "Keep-alive period for inter-broker connections")
 
 	topicCmd := &cobra.Command{Use: "topic", Short: "Manage topics"}
 	createTopicCmd := &cobra.Command{Use: "create", Short: "Create a topic", Run: createTopic}
 	createTopicCmd.Flags().StringVar(&topicCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address for Broker to bind on")
 	createTopicCmd.Flags().StringVar(&topicCfg.Topic, "topic", "", "Name of topic to create")
-	createTopicCmd.Flags().Int32Var(&topicCfg.Partitions, "partitions", 1, "Number of partitions")
-	createTopicCmd.Flags().IntVar(&topicCfg.ReplicationFactor, "replication-factor", 1, "Replication factor")
+	createTopicCmd.Flags().Int32Var(&topicCfg.Partitions, "partitions", -1, "Number of partitions, -1 for the broker's default")
+	createTopicCmd.Flags().IntVar(&topicCfg.ReplicationFactor, "replication-factor", -1, "Replication factor, -1 for the broker's default")
+
+	deleteTopicCmd := &cobra.Command{Use: "delete", Short: "Delete a topic", Run: deleteTopic}
+	deleteTopicCmd.Flags().StringVar(&topicCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address for Broker to bind on")
+	deleteTopicCmd.Flags().StringVar(&topicCfg.Topic, "topic", "", "Name of topic to delete")
+
+	createPartitionsCmd := &cobra.Command{Use: "create-partitions", Short: "Grow a topic's partition count", Run: createPartitions}
+	createPartitionsCmd.Flags().StringVar(&topicCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address for Broker to bind on")
+	createPartitionsCmd.Flags().StringVar(&topicCfg.Topic, "topic", "", "Name of topic to add partitions to")
+	createPartitionsCmd.Flags().Int32Var(&topicCfg.Partitions, "partitions", 1, "Number of partitions the topic should have")
+
+	logDirsCmd := &cobra.Command{Use: "log-dirs", Short: "Manage brokers' log dirs"}
+	describeLogDirsCmd := &cobra.Command{Use: "describe", Short: "Describe a broker's log dirs and the logs being moved between them", Run: describeLogDirs}
+	describeLogDirsCmd.Flags().StringVar(&logDirsCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address of the broker to describe")
+	rebalanceLogDirsCmd := &cobra.Command{Use: "rebalance", Short: "Move a broker's largest logs between its log dirs to even out their free space", Run: rebalanceLogDirs}
+	rebalanceLogDirsCmd.Flags().StringVar(&logDirsCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address of the controller")
+	rebalanceLogDirsCmd.Flags().Int32Var(&logDirsCfg.BrokerID, "broker-id", 0, "ID of the broker to rebalance")
+	rebalanceLogDirsCmd.Flags().Int64Var(&logDirsCfg.Throttle, "throttle", 0, "Rate to move logs at in bytes per second, 0 for no limit")
+
+	assignmentsCmd := &cobra.Command{Use: "assignments", Short: "Manage where partitions' replicas are placed with a declarative spec"}
+	exportAssignmentsCmd := &cobra.Command{Use: "export", Short: "Export every partition's replicas and leader to a spec", Run: exportAssignments}
+	exportAssignmentsCmd.Flags().StringVar(&assignmentsCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address of a broker in the cluster")
+	exportAssignmentsCmd.Flags().StringVar(&assignmentsCfg.File, "file", "", "File to write the spec to, stdout if empty")
+	exportAssignmentsCmd.Flags().StringVar(&assignmentsCfg.Format, "format", "yaml", "Format of the spec, yaml or json")
+	applyAssignmentsCmd := &cobra.Command{Use: "apply", Short: "Reassign the partitions whose replicas differ in a spec, printing the changes", Run: applyAssignments}
+	applyAssignmentsCmd.Flags().StringVar(&assignmentsCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address of a broker in the cluster")
+	applyAssignmentsCmd.Flags().StringVar(&assignmentsCfg.File, "file", "", "YAML or JSON spec to apply, partitions left out of it aren't moved")
+	applyAssignmentsCmd.Flags().BoolVar(&assignmentsCfg.DryRun, "dry-run", false, "Only print the changes the spec would make")
+
+	importCmd := &cobra.Command{Use: "import", Short: "Import topics' records from a Kafka cluster", Run: importTopics}
+	importCmd.Flags().StringVar(&importCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address of a broker to import into")
+	importCmd.Flags().StringSliceVar(&importCfg.FromBrokers, "from-brokers", nil, "Bootstrap addresses of the Kafka cluster to import from")
+	importCmd.Flags().StringSliceVar(&importCfg.Topics, "topics", nil, "Topics to import, created with as many partitions if they don't exist")
+	importCmd.Flags().StringVar(&importCfg.TopicRegex, "topic-regex", "", "Regular expression matching more of the Kafka cluster's topics to import, looked up when the import starts")
+	importCmd.Flags().BoolVar(&importCfg.PreserveOffsets, "preserve-offsets", false, "Give records the offsets they had in Kafka, filling gaps with empty batches, and carry on from where an earlier import got to")
+
+	consumeCmd := &cobra.Command{Use: "consume", Short: "Consume a topic's records, printing them, until where --until says to stop", Run: consume}
+	consumeCmd.Flags().StringVar(&consumeCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address of a broker in the cluster")
+	consumeCmd.Flags().StringVar(&consumeCfg.Topic, "topic", "", "Topic to consume")
+	consumeCmd.Flags().IntSliceVar(&consumeCfg.Partitions, "partitions", nil, "Partitions to consume, all of the topic's if there are none")
+	consumeCmd.Flags().StringVar(&consumeCfg.From, "from", "earliest", "Where to start consuming: earliest, latest, an offset or an RFC 3339 time")
+	consumeCmd.Flags().StringVar(&consumeCfg.Until, "until", "", "Where to stop consuming, before the record at it: latest, an offset or an RFC 3339 time, or partition=position pairs like 0=100,1=latest. Consumes until interrupted if unset")
+
+	shadowCmd := &cobra.Command{Use: "shadow", Short: "Fail over to a shadowed Kafka cluster"}
+	translateOffsetsCmd := &cobra.Command{Use: "translate-offsets", Short: "Translate a group's committed offsets to the shadowed cluster's, printing them", Run: translateOffsets}
+	translateOffsetsCmd.Flags().StringVar(&shadowCfg.BrokerAddr, "broker-addr", "0.0.0.0:9092", "Address of a broker in the cluster")
+	translateOffsetsCmd.Flags().StringSliceVar(&shadowCfg.ShadowBrokers, "shadow-brokers", nil, "Bootstrap addresses of the shadowed Kafka cluster")
+	translateOffsetsCmd.Flags().StringVar(&shadowCfg.OffsetSyncsTopic, "offset-syncs-topic", brokerCfg.ShadowOffsetSyncsTopic, "Topic on the shadowed cluster the brokers write offset syncs to")
+	translateOffsetsCmd.Flags().StringVar(&shadowCfg.Group, "group", "", "Group whose offsets to translate")
+	translateOffsetsCmd.Flags().BoolVar(&shadowCfg.Commit, "commit", false, "Commit the translated offsets for the group on the shadowed cluster, the group mustn't have members there")
+
+	doctorCmd := &cobra.Command{Use: "doctor", Short: "Check a stopped broker's data dir and config, printing a health report", Run: doctor}
+	// takes the broker's flags so it's run with the same ones
+	doctorCmd.Flags().AddFlagSet(brokerCmd.Flags())
+	doctorCmd.Flags().StringSliceVar(&doctorCfg.Peers, "peers", nil, "Admin API addresses of brokers to compare the clock with")
+	doctorCmd.Flags().DurationVar(&doctorCfg.MaxClockSkew, "max-clock-skew", time.Second, "How far the clock can be off the peers' clocks")
+	doctorCmd.Flags().DurationVar(&doctorCfg.Timeout, "timeout", 5*time.Second, "Time to wait for each peer and for the raft store's lock")
 
 	cli.AddCommand(brokerCmd)
+	cli.AddCommand(doctorCmd)
 	cli.AddCommand(topicCmd)
+	cli.AddCommand(logDirsCmd)
+	cli.AddCommand(assignmentsCmd)
+	cli.AddCommand(importCmd)
+	cli.AddCommand(consumeCmd)
+	cli.AddCommand(shadowCmd)
 	topicCmd.AddCommand(createTopicCmd)
+	topicCmd.AddCommand(deleteTopicCmd)
+	topicCmd.AddCommand(createPartitionsCmd)
+	logDirsCmd.AddCommand(describeLogDirsCmd)
+	logDirsCmd.AddCommand(rebalanceLogDirsCmd)
+	assignmentsCmd.AddCommand(exportAssignmentsCmd)
+	assignmentsCmd.AddCommand(applyAssignmentsCmd)
+	shadowCmd.AddCommand(translateOffsetsCmd)
 }
 
 func run(cmd *cobra.Command, args []string) {
@@ -90,25 +286,62 @@ func run(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
-	broker, err := jocko.NewBroker(brokerCfg, tracer, logger)
+	brokerMetrics := jocko.NewMetrics()
+	if metricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+				fmt.Fprintf(os.Stderr, "error serving metrics: %v\n", err)
+			}
+		}()
+	}
+
+	brokerCfg.Version = version
+	broker, err := jocko.NewBroker(brokerCfg, brokerMetrics, tracer, logger)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error starting broker: %v\n", err)
 		os.Exit(1)
 	}
 
-	srv := jocko.NewServer(brokerCfg, broker, nil, tracer, closer.Close, logger)
+	srv := jocko.NewServer(brokerCfg, broker, brokerMetrics, tracer, closer.Close, logger)
+
+	if adminAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/v1/connections", srv.AdminHandler())
+		mux.Handle("/", broker.AdminHandler())
+		go func() {
+			if err := http.ListenAndServe(adminAddr, mux); err != nil {
+				fmt.Fprintf(os.Stderr, "error serving admin api: %v\n", err)
+			}
+		}()
+	}
+
 	if err := srv.Start(context.Background()); err != nil {
 		fmt.Fprintf(os.Stderr, "error starting server: %v\n", err)
 		os.Exit(1)
 	}
 
-	defer srv.Shutdown()
-
 	gracefully.Timeout = 10 * time.Second
 	gracefully.Shutdown()
 
-	if err := broker.Shutdown(); err != nil {
-		fmt.Fprintf(os.Stderr, "error shutting down store: %v\n", err)
+	// shuts the broker down once the server's stopped accepting conns
+	if err := srv.Shutdown(); err != nil {
+		fmt.Fprintf(os.Stderr, "error shutting down broker: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doctor(cmd *cobra.Command, args []string) {
+	report := jocko.Doctor(brokerCfg, jocko.DoctorConfig{
+		Peers:        doctorCfg.Peers,
+		MaxClockSkew: doctorCfg.MaxClockSkew,
+		Timeout:      doctorCfg.Timeout,
+	})
+	for _, c := range report.Checks {
+		fmt.Printf("[%s] %s: %s\n", c.Status, c.Name, c.Detail)
+	}
+	if !report.Healthy() {
 		os.Exit(1)
 	}
 }
@@ -146,3 +379,283 @@ func createTopic(cmd *cobra.Command, args []string) {
 func main() {
 	cli.Execute()
 }
+
+func deleteTopic(cmd *cobra.Command, args []string) {
+	conn, err := jocko.Dial("tcp", topicCfg.BrokerAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to broker: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := conn.DeleteTopics(&protocol.DeleteTopicsRequest{
+		Topics: []string{topicCfg.Topic},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error with request to broker: %v\n", err)
+		os.Exit(1)
+	}
+	for _, topicErrCode := range resp.TopicErrorCodes {
+		if topicErrCode.ErrorCode != protocol.ErrNone.Code() {
+			err := protocol.Errs[topicErrCode.ErrorCode]
+			fmt.Fprintf(os.Stderr, "error code: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("deleting topic: %v\n", topicCfg.Topic)
+}
+
+func createPartitions(cmd *cobra.Command, args []string) {
+	conn, err := jocko.Dial("tcp", topicCfg.BrokerAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to broker: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := conn.CreatePartitions(&protocol.CreatePartitionsRequest{
+		Topics: []*protocol.CreatePartitionsTopic{{
+			Topic: topicCfg.Topic,
+			Count: topicCfg.Partitions,
+		}},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error with request to broker: %v\n", err)
+		os.Exit(1)
+	}
+	for _, topicErrCode := range resp.TopicErrorCodes {
+		if topicErrCode.ErrorCode != protocol.ErrNone.Code() {
+			err := protocol.Errs[topicErrCode.ErrorCode]
+			fmt.Fprintf(os.Stderr, "error code: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("topic %v now has %d partitions\n", topicCfg.Topic, topicCfg.Partitions)
+}
+
+func importTopics(cmd *cobra.Command, args []string) {
+	if len(importCfg.FromBrokers) == 0 || (len(importCfg.Topics) == 0 && importCfg.TopicRegex == "") {
+		fmt.Fprintf(os.Stderr, "--from-brokers and --topics or --topic-regex are required\n")
+		os.Exit(1)
+	}
+	var topicPattern *regexp.Regexp
+	if importCfg.TopicRegex != "" {
+		var err error
+		if topicPattern, err = regexp.Compile(importCfg.TopicRegex); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing --topic-regex: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	importer := jocko.NewImporter(jocko.ImporterConfig{
+		FromBrokers:     importCfg.FromBrokers,
+		Brokers:         []string{importCfg.BrokerAddr},
+		Topics:          importCfg.Topics,
+		TopicPattern:    topicPattern,
+		PreserveOffsets: importCfg.PreserveOffsets,
+	}, jocko.NewDialer("jocko-import"), log.New())
+	imported, err := importer.Import(context.Background())
+	for _, p := range imported {
+		fmt.Printf("%v-%d: offsets %d to %d, %d record sets, %d gaps, %d skipped\n", p.Topic, p.Partition, p.StartOffset, p.EndOffset, p.RecordSets, p.Gaps, p.Skipped)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error importing: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func consume(cmd *cobra.Command, args []string) {
+	if consumeCfg.Topic == "" {
+		fmt.Fprintln(os.Stderr, "--topic is required")
+		os.Exit(1)
+	}
+	from, err := jocko.ParseConsumePosition(consumeCfg.From)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing --from: %v\n", err)
+		os.Exit(1)
+	}
+	config := jocko.ConsumerConfig{
+		Brokers: []string{consumeCfg.BrokerAddr},
+		Topic:   consumeCfg.Topic,
+		From:    from,
+	}
+	if consumeCfg.Until != "" {
+		if config.Until, config.PartitionUntil, err = jocko.ParsePartitionPositions(consumeCfg.Until); err != nil {
+			fmt.Fprintf(os.Stderr, "error parsing --until: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, p := range consumeCfg.Partitions {
+		config.Partitions = append(config.Partitions, int32(p))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		cancel()
+	}()
+	consumer := jocko.NewConsumer(config, jocko.NewDialer("jocko-consume"))
+	defer consumer.Close()
+	err = consumer.Consume(ctx, func(r jocko.ConsumedRecord) error {
+		fmt.Printf("%v-%d@%d\t%s\t%s\n", r.Topic, r.Partition, r.Offset, r.Key, r.Value)
+		return nil
+	})
+	if err != nil && err != context.Canceled {
+		fmt.Fprintf(os.Stderr, "error consuming: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func translateOffsets(cmd *cobra.Command, args []string) {
+	if shadowCfg.Group == "" || len(shadowCfg.ShadowBrokers) == 0 {
+		fmt.Fprintln(os.Stderr, "--group and --shadow-brokers are required")
+		os.Exit(1)
+	}
+	translator := jocko.NewShadowOffsetTranslator(shadowCfg.ShadowBrokers, shadowCfg.OffsetSyncsTopic, jocko.NewDialer("jocko-translate-offsets"), 30*time.Second)
+	defer translator.Close()
+	offsets, err := translator.TranslateGroup([]string{shadowCfg.BrokerAddr}, shadowCfg.Group)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error translating offsets: %v\n", err)
+		os.Exit(1)
+	}
+	for _, o := range offsets {
+		if !o.Translated {
+			fmt.Printf("%v-%d: %d, no offset syncs before it\n", o.Topic, o.Partition, o.Offset)
+			continue
+		}
+		fmt.Printf("%v-%d: %d -> %d\n", o.Topic, o.Partition, o.Offset, o.ShadowOffset)
+	}
+	if !shadowCfg.Commit {
+		return
+	}
+	if err := translator.Commit(shadowCfg.Group, offsets); err != nil {
+		fmt.Fprintf(os.Stderr, "error committing offsets: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func describeLogDirs(cmd *cobra.Command, args []string) {
+	conn, err := jocko.Dial("tcp", logDirsCfg.BrokerAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to broker: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := conn.DescribeLogDirs(&protocol.DescribeLogDirsRequest{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error with request to broker: %v\n", err)
+		os.Exit(1)
+	}
+	for _, res := range resp.Results {
+		if res.ErrorCode != protocol.ErrNone.Code() {
+			fmt.Printf("%v: %v\n", res.LogDir, protocol.Errs[res.ErrorCode])
+			continue
+		}
+		fmt.Printf("%v:\n", res.LogDir)
+		for _, t := range res.Topics {
+			for _, p := range t.Partitions {
+				future := ""
+				if p.IsFuture {
+					future = " (moving here)"
+				}
+				fmt.Printf("  %v-%d: %d bytes, %d lag%s\n", t.Topic, p.Partition, p.Size, p.OffsetLag, future)
+			}
+		}
+	}
+}
+
+func rebalanceLogDirs(cmd *cobra.Command, args []string) {
+	conn, err := jocko.Dial("tcp", logDirsCfg.BrokerAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to broker: %v\n", err)
+		os.Exit(1)
+	}
+
+	// a new value for the config starts a rebalance
+	request := strconv.FormatInt(time.Now().UnixNano(), 10)
+	throttle := strconv.FormatInt(logDirsCfg.Throttle, 10)
+	resp, err := conn.IncrementalAlterConfigs(&protocol.IncrementalAlterConfigsRequest{
+		Resources: []protocol.IncrementalAlterConfigsResource{{
+			Type: int8(structs.BrokerConfigResource),
+			Name: strconv.Itoa(int(logDirsCfg.BrokerID)),
+			Entries: []protocol.IncrementalAlterConfigsEntry{
+				{Name: "log.dirs.rebalance.throttle.bytes", Operation: protocol.ConfigOperationSet, Value: &throttle},
+				{Name: "log.dirs.rebalance", Operation: protocol.ConfigOperationSet, Value: &request},
+			},
+		}},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error with request to broker: %v\n", err)
+		os.Exit(1)
+	}
+	for _, res := range resp.Resources {
+		if res.ErrorCode != protocol.ErrNone.Code() {
+			fmt.Fprintf(os.Stderr, "error code: %v\n", protocol.Errs[res.ErrorCode])
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("rebalancing log dirs of broker %d, run log-dirs describe against it to follow the moves\n", logDirsCfg.BrokerID)
+}
+
+func exportAssignments(cmd *cobra.Command, args []string) {
+	spec, err := jocko.ExportAssignments(jocko.NewDialer("jocko-assignments"), assignmentsCfg.BrokerAddr, 30*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error exporting assignments: %v\n", err)
+		os.Exit(1)
+	}
+	b, err := spec.Marshal(assignmentsCfg.Format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding spec: %v\n", err)
+		os.Exit(1)
+	}
+	if assignmentsCfg.File == "" {
+		os.Stdout.Write(b)
+		return
+	}
+	if err := ioutil.WriteFile(assignmentsCfg.File, b, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing spec: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func applyAssignments(cmd *cobra.Command, args []string) {
+	if assignmentsCfg.File == "" {
+		fmt.Fprintf(os.Stderr, "--file is required\n")
+		os.Exit(1)
+	}
+	b, err := ioutil.ReadFile(assignmentsCfg.File)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading spec: %v\n", err)
+		os.Exit(1)
+	}
+	spec, err := jocko.ParseAssignmentSpec(b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing spec: %v\n", err)
+		os.Exit(1)
+	}
+	changes, err := jocko.ApplyAssignments(jocko.NewDialer("jocko-assignments"), assignmentsCfg.BrokerAddr, spec, assignmentsCfg.DryRun, 30*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error applying spec: %v\n", err)
+		os.Exit(1)
+	}
+	if len(changes) == 0 {
+		fmt.Println("partitions already match the spec")
+		return
+	}
+	failed := false
+	for _, c := range changes {
+		if c.Err != nil {
+			failed = true
+			fmt.Printf("%v: %v\n", c, c.Err)
+			continue
+		}
+		fmt.Println(c)
+	}
+	if assignmentsCfg.DryRun {
+		fmt.Printf("%d partitions would be reassigned\n", len(changes))
+		return
+	}
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Printf("reassigning %d partitions\n", len(changes))
+}
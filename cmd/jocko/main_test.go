@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokerSerfFlags(t *testing.T) {
+	cmd, _, err := cli.Find([]string{"broker"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Flags().Parse([]string{
+		"--serf-snapshot-path", "/var/lib/jocko/serf.snapshot",
+		"--serf-rejoin-after-leave",
+		"--serf-probe-interval", "3s",
+		"--serf-probe-timeout", "750ms",
+		"--serf-gossip-interval", "400ms",
+		"--serf-gossip-nodes", "5",
+		"--serf-suspicion-mult", "6",
+	}))
+
+	// the broker creates its serf with this config
+	serfCfg := brokerCfg.SerfLANConfig
+	require.Equal(t, "/var/lib/jocko/serf.snapshot", serfCfg.SnapshotPath)
+	require.True(t, serfCfg.RejoinAfterLeave)
+	require.Equal(t, 3*time.Second, serfCfg.MemberlistConfig.ProbeInterval)
+	require.Equal(t, 750*time.Millisecond, serfCfg.MemberlistConfig.ProbeTimeout)
+	require.Equal(t, 400*time.Millisecond, serfCfg.MemberlistConfig.GossipInterval)
+	require.Equal(t, 5, serfCfg.MemberlistConfig.GossipNodes)
+	require.Equal(t, 6, serfCfg.MemberlistConfig.SuspicionMult)
+}
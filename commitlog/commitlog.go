@@ -1,7 +1,9 @@
 package commitlog
 
 import (
+	"bytes"
 	"encoding/binary"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -9,13 +11,17 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 	"github.com/pkg/errors"
 )
 
 var (
-	ErrSegmentNotFound = errors.New("segment not found")
-	Encoding           = binary.BigEndian
+	ErrSegmentNotFound  = errors.New("segment not found")
+	ErrOffsetOutOfRange = errors.New("offset out of range")
+	Encoding            = binary.BigEndian
 )
 
 type CleanupPolicy string
@@ -26,6 +32,9 @@ const (
 
 	LogFileSuffix   = ".log"
 	IndexFileSuffix = ".index"
+
+	// LogStartOffsetFile is the file in the log's directory its start offset is persisted to.
+	LogStartOffsetFile = "log-start-offset"
 )
 
 type CommitLog struct {
@@ -35,6 +44,17 @@ type CommitLog struct {
 	mu             sync.RWMutex
 	segments       []*Segment
 	vActiveSegment atomic.Value
+	// appendMu blocks appends while the log is switched to a new directory.
+	appendMu sync.Mutex
+
+	// logStartOffset is the offset of the first message readers can see, the messages before
+	// it have been deleted. It's guarded by mu.
+	logStartOffset int64
+	// epochs is the leader epoch cache, the epochs the log's messages were appended in ordered by
+	// epoch. It's guarded by mu.
+	epochs []EpochEntry
+	// lastFlush is when Append last synced the active segment, it's guarded by appendMu.
+	lastFlush time.Time
 }
 
 type Options struct {
@@ -44,6 +64,32 @@ type Options struct {
 	MaxSegmentBytes int64
 	MaxLogBytes     int64
 	CleanupPolicy   CleanupPolicy
+	// FlushInterval is how long appended messages can go without being synced to disk, Append
+	// syncs the active segment once it's been this long since the last sync. Zero leaves flushing
+	// to the OS, the segment's only synced when it's rolled or the log's closed.
+	FlushInterval time.Duration
+	// Metrics is used to track the log's disk metrics. If nil, metrics are discarded.
+	Metrics *Metrics
+	// KeyBloomFilters keeps a filter of each segment's message keys in memory, so compaction and
+	// key lookups can skip the segments that definitely don't have a key. Rolled segments' keys
+	// are kept as bloom filters.
+	KeyBloomFilters bool
+}
+
+// Metrics tracks how the log's disk is doing so slow or failing disks can be spotted.
+type Metrics struct {
+	// FlushLatency is the time taken to fsync a segment in seconds.
+	FlushLatency metrics.Histogram
+	// RecoveryTime is the time taken to open the log's existing segments in seconds.
+	RecoveryTime metrics.Histogram
+	// IndexRebuilds counts the segment indexes rebuilt from their log files.
+	IndexRebuilds metrics.Counter
+}
+
+var nopMetrics = &Metrics{
+	FlushLatency:  discard.NewHistogram(),
+	RecoveryTime:  discard.NewHistogram(),
+	IndexRebuilds: discard.NewCounter(),
 }
 
 func New(opts Options) (*CommitLog, error) {
@@ -59,24 +105,27 @@ func New(opts Options) (*CommitLog, error) {
 		opts.CleanupPolicy = DeleteCleanupPolicy
 	}
 
-	var cleaner Cleaner
-	if opts.CleanupPolicy == DeleteCleanupPolicy {
-		cleaner = NewDeleteCleaner(opts.MaxLogBytes)
-	} else {
-		cleaner = NewCompactCleaner()
+	if opts.Metrics == nil {
+		opts.Metrics = nopMetrics
 	}
 
 	path, _ := filepath.Abs(opts.Path)
 	l := &CommitLog{
-		Options: opts,
-		name:    filepath.Base(path),
-		cleaner: cleaner,
+		Options:   opts,
+		name:      filepath.Base(path),
+		cleaner:   newCleaner(opts.CleanupPolicy, opts.MaxLogBytes),
+		lastFlush: time.Now(),
 	}
 
 	if err := l.init(); err != nil {
 		return nil, err
 	}
 
+	// the log may have been moved here by a broker that stopped before it removed the old dir
+	if err := finishMove(l.Path); err != nil {
+		return nil, err
+	}
+
 	if err := l.open(); err != nil {
 		return nil, err
 	}
@@ -84,6 +133,30 @@ func New(opts Options) (*CommitLog, error) {
 	return l, nil
 }
 
+func newCleaner(policy CleanupPolicy, maxLogBytes int64) Cleaner {
+	if policy == DeleteCleanupPolicy {
+		return NewDeleteCleaner(maxLogBytes)
+	}
+	return NewCompactCleaner()
+}
+
+// Configure updates the log's segment size, retention, and cleanup policy from opts, its other
+// options are ignored. The changes take effect when the next segment is split off.
+func (l *CommitLog) Configure(opts Options) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if opts.MaxSegmentBytes != 0 {
+		l.MaxSegmentBytes = opts.MaxSegmentBytes
+	}
+	if opts.CleanupPolicy != "" {
+		l.CleanupPolicy = opts.CleanupPolicy
+	}
+	l.MaxLogBytes = opts.MaxLogBytes
+	l.FlushInterval = opts.FlushInterval
+	l.KeyBloomFilters = opts.KeyBloomFilters
+	l.cleaner = newCleaner(l.CleanupPolicy, l.MaxLogBytes)
+}
+
 func (l *CommitLog) init() error {
 	err := os.MkdirAll(l.Path, 0755)
 	if err != nil {
@@ -93,6 +166,9 @@ func (l *CommitLog) init() error {
 }
 
 func (l *CommitLog) open() error {
+	defer func(start time.Time) {
+		l.Metrics.RecoveryTime.Observe(time.Since(start).Seconds())
+	}(time.Now())
 	files, err := ioutil.ReadDir(l.Path)
 	if err != nil {
 		return errors.Wrap(err, "read dir failed")
@@ -102,7 +178,7 @@ func (l *CommitLog) open() error {
 		if strings.HasSuffix(file.Name(), IndexFileSuffix) {
 			_, err := os.Stat(filepath.Join(l.Path, strings.Replace(file.Name(), IndexFileSuffix, LogFileSuffix, 1)))
 			if os.IsNotExist(err) {
-				if err := os.Remove(file.Name()); err != nil {
+				if err := os.Remove(filepath.Join(l.Path, file.Name())); err != nil {
 					return err
 				}
 			} else if err != nil {
@@ -118,6 +194,9 @@ func (l *CommitLog) open() error {
 			if err != nil {
 				return err
 			}
+			if segment.IndexRebuilt {
+				l.Metrics.IndexRebuilds.Add(1)
+			}
 			l.segments = append(l.segments, segment)
 		}
 	}
@@ -128,31 +207,81 @@ func (l *CommitLog) open() error {
 		}
 		l.segments = append(l.segments, segment)
 	}
+	if err := l.filterKeys(l.segments); err != nil {
+		return err
+	}
 	l.vActiveSegment.Store(l.segments[len(l.segments)-1])
+	if l.logStartOffset, err = readLogStartOffset(l.Path); err != nil {
+		return err
+	}
+	if l.epochs, err = readLeaderEpochs(l.Path); err != nil {
+		return err
+	}
 	return nil
 }
 
 func (l *CommitLog) Append(b []byte) (offset int64, err error) {
+	offset, _, err = l.AppendTimed(b)
+	return offset, err
+}
+
+// AppendTiming is how long an append took to write the message set to the active segment and,
+// if it was due, to sync the segment to disk.
+type AppendTiming struct {
+	Write time.Duration
+	// Sync is zero if the segment wasn't synced.
+	Sync time.Duration
+}
+
+// AppendTimed appends the message set like Append, also returning how long writing and syncing
+// it took so slow appends can be put down to the disk's writes or its syncs.
+func (l *CommitLog) AppendTimed(b []byte) (offset int64, timing AppendTiming, err error) {
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+	start := time.Now()
 	ms := MessageSet(b)
 	if l.checkSplit() {
 		if err := l.split(); err != nil {
-			return offset, err
+			return offset, timing, err
 		}
 	}
 	position := l.activeSegment().Position
-	offset = l.activeSegment().NextOffset
-	ms.PutOffset(offset)
+	offset = l.activeSegment().NextOffset()
+	// each entry's given its base offset and indexed, v2 record batches take an offset for each
+	// of their records
+	entries := ms.Entries()
+	if len(entries) == 0 {
+		entries = []MessageSet{ms}
+	}
+	index := make([]Entry, len(entries))
+	next := offset
+	for i, entry := range entries {
+		entry.PutOffset(next)
+		index[i] = Entry{Offset: next, Position: position}
+		next += entry.OffsetCount()
+		position += int64(len(entry))
+	}
 	if _, err := l.activeSegment().Write(ms); err != nil {
-		return offset, err
+		return offset, timing, err
 	}
-	e := Entry{
-		Offset:   offset,
-		Position: position,
+	for _, e := range index {
+		if err := l.activeSegment().Index.WriteEntry(e); err != nil {
+			return offset, timing, err
+		}
 	}
-	if err := l.activeSegment().Index.WriteEntry(e); err != nil {
-		return offset, err
+	timing.Write = time.Since(start)
+	l.mu.RLock()
+	flushInterval := l.FlushInterval
+	l.mu.RUnlock()
+	if flushInterval > 0 && time.Since(l.lastFlush) >= flushInterval {
+		start = time.Now()
+		if err := l.sync(l.activeSegment()); err != nil {
+			return offset, timing, err
+		}
+		l.lastFlush = time.Now()
+		timing.Sync = l.lastFlush.Sub(start)
 	}
-	return offset, nil
+	return offset, timing, nil
 }
 
 func (l *CommitLog) Read(p []byte) (n int, err error) {
@@ -162,23 +291,176 @@ func (l *CommitLog) Read(p []byte) (n int, err error) {
 }
 
 func (l *CommitLog) NewestOffset() int64 {
-	return l.activeSegment().NextOffset
+	return l.activeSegment().NextOffset()
 }
 
+// OldestOffset returns the offset of the first message in the log, either the first segment's
+// base offset or the log start offset if messages have been deleted past it.
 func (l *CommitLog) OldestOffset() int64 {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	return l.segments[0].BaseOffset
+	if l.segments[0].BaseOffset > l.logStartOffset {
+		return l.segments[0].BaseOffset
+	}
+	return l.logStartOffset
+}
+
+// OffsetForTimestamp returns the offset and timestamp of the first message set whose timestamp is
+// at least the given one, looked up with the segments' time indexes. The timestamp of a v2 record
+// batch is its max timestamp, so the batch returned may hold earlier records. It returns false if
+// no message set's that late.
+func (l *CommitLog) OffsetForTimestamp(timestamp int64) (TimeEntry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, segment := range l.segments {
+		e, ok := segment.TimeIndex.Lookup(timestamp)
+		if !ok {
+			continue
+		}
+		// the deleted messages before the log start offset are skipped
+		if e.Offset < l.logStartOffset {
+			e.Offset = l.logStartOffset
+		}
+		return e, true
+	}
+	return TimeEntry{}, false
+}
+
+// LatestForKey returns the latest message set before the given offset with a message with the
+// key, for looking keys up in compacted logs. The segments are read newest first, skipping those
+// whose key bloom filters show they don't have the key. Message sets whose keys can't be read,
+// like compressed batches, aren't matched. It returns false if no message set has the key.
+func (l *CommitLog) LatestForKey(key []byte, before int64) (MessageSet, bool, error) {
+	h := Hash(key)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		segment := l.segments[i]
+		if segment.BaseOffset >= before || !segment.mayContainHash(h) {
+			continue
+		}
+		var latest MessageSet
+		ss := NewSegmentScanner(segment)
+		for {
+			ms, err := ss.Scan()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			if ms.Offset() >= before {
+				break
+			}
+			keys, ok := ms.Keys()
+			if !ok {
+				continue
+			}
+			for _, k := range keys {
+				if bytes.Equal(k, key) {
+					latest = ms
+					break
+				}
+			}
+		}
+		if latest == nil {
+			continue
+		}
+		// the earlier segments only have older messages, which are deleted too
+		if latest.Offset() < l.logStartOffset {
+			break
+		}
+		return latest, true, nil
+	}
+	return nil, false, nil
+}
+
+// DeleteRecords advances the log's start offset to offset, hiding the messages before it, and
+// deletes the segments holding only messages before it. The start offset's persisted so it
+// holds when the log's reopened.
+func (l *CommitLog) DeleteRecords(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if offset > l.NewestOffset() {
+		return ErrOffsetOutOfRange
+	}
+	if offset <= l.logStartOffset {
+		return nil
+	}
+	if err := writeLogStartOffset(l.Path, offset); err != nil {
+		return err
+	}
+	l.logStartOffset = offset
+	segments := make([]*Segment, 0, len(l.segments))
+	for i, segment := range l.segments {
+		// the active segment's kept to append to
+		if i < len(l.segments)-1 && l.segments[i+1].BaseOffset <= offset {
+			if err := segment.Delete(); err != nil {
+				return err
+			}
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	l.segments = segments
+	return nil
+}
+
+func readLogStartOffset(dir string) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, LogStartOffsetFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "read log start offset failed")
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// writeLogStartOffset writes the offset to a temporary file that's then renamed so the
+// persisted offset is never partly written.
+func writeLogStartOffset(dir string, offset int64) error {
+	path := filepath.Join(dir, LogStartOffsetFile)
+	if err := ioutil.WriteFile(path+".tmp", []byte(strconv.FormatInt(offset, 10)), 0666); err != nil {
+		return errors.Wrap(err, "write log start offset failed")
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Size returns the number of bytes in the log's segments.
+func (l *CommitLog) Size() int64 {
+	var size int64
+	for _, segment := range l.Segments() {
+		size += segment.Size()
+	}
+	return size
 }
 
 func (l *CommitLog) activeSegment() *Segment {
 	return l.vActiveSegment.Load().(*Segment)
 }
 
+// Sync flushes the active segment to disk.
+func (l *CommitLog) Sync() error {
+	return l.sync(l.activeSegment())
+}
+
+func (l *CommitLog) sync(segment *Segment) error {
+	start := time.Now()
+	if err := segment.Sync(); err != nil {
+		return err
+	}
+	l.Metrics.FlushLatency.Observe(time.Since(start).Seconds())
+	return nil
+}
+
 func (l *CommitLog) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	for _, segment := range l.segments {
+		if err := l.sync(segment); err != nil {
+			return err
+		}
 		if err := segment.Close(); err != nil {
 			return err
 		}
@@ -210,6 +492,56 @@ func (l *CommitLog) Truncate(offset int64) error {
 	return nil
 }
 
+// TruncateTo removes the messages from offset on, along with the leader epochs that started
+// with them, so the next message appended is given offset, or the base offset of the record
+// batch holding it since batches are removed whole. Followers truncate the messages they
+// have past where their log diverges from their leader's.
+func (l *CommitLog) TruncateTo(offset int64) error {
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if offset >= l.NewestOffset() {
+		return nil
+	}
+	segments := l.segments
+	for len(segments) != 0 && segments[len(segments)-1].BaseOffset >= offset {
+		if err := segments[len(segments)-1].Delete(); err != nil {
+			return err
+		}
+		segments = segments[:len(segments)-1]
+	}
+	if len(segments) == 0 {
+		segment, err := NewSegment(l.Path, offset, l.MaxSegmentBytes)
+		if err != nil {
+			return err
+		}
+		segments = append(segments, segment)
+		if err := l.filterKeys(segments); err != nil {
+			return err
+		}
+	} else {
+		truncated, err := segments[len(segments)-1].truncateTo(offset)
+		if err != nil {
+			return err
+		}
+		offset = truncated
+	}
+	l.segments = segments
+	l.vActiveSegment.Store(segments[len(segments)-1])
+	epochs := l.epochs
+	for len(epochs) != 0 && epochs[len(epochs)-1].StartOffset >= offset {
+		epochs = epochs[:len(epochs)-1]
+	}
+	if len(epochs) != len(l.epochs) {
+		if err := writeLeaderEpochs(l.Path, epochs); err != nil {
+			return err
+		}
+		l.epochs = epochs
+	}
+	return nil
+}
+
 func (l *CommitLog) Segments() []*Segment {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -221,19 +553,56 @@ func (l *CommitLog) checkSplit() bool {
 }
 
 func (l *CommitLog) split() error {
-	segment, err := NewSegment(l.Path, l.NewestOffset(), l.MaxSegmentBytes)
+	// flush the segment being rolled since nothing will be written to it anymore
+	if err := l.sync(l.activeSegment()); err != nil {
+		return err
+	}
+	l.mu.RLock()
+	maxSegmentBytes := l.MaxSegmentBytes
+	l.mu.RUnlock()
+	segment, err := NewSegment(l.Path, l.NewestOffset(), maxSegmentBytes)
 	if err != nil {
 		return err
 	}
 	l.mu.Lock()
 	segments := append(l.segments, segment)
+	if err := l.filterKeys(segments); err != nil {
+		l.mu.Unlock()
+		return err
+	}
 	segments, err = l.cleaner.Clean(segments)
 	if err != nil {
 		l.mu.Unlock()
 		return err
 	}
+	// the segments rewritten by compaction read their keys again
+	if err := l.filterKeys(segments); err != nil {
+		l.mu.Unlock()
+		return err
+	}
 	l.segments = segments
 	l.mu.Unlock()
-	l.vActiveSegment.Store(segment)
+	// compaction replaces the new segment too
+	l.vActiveSegment.Store(segments[len(segments)-1])
+	return nil
+}
+
+// filterKeys starts tracking the keys of the segments that don't have key filters if the log has
+// them, and seals the filters of the segments before the last, the active segment, since they
+// won't be written to.
+func (l *CommitLog) filterKeys(segments []*Segment) error {
+	if !l.KeyBloomFilters {
+		return nil
+	}
+	for i, segment := range segments {
+		if segment.keys == nil {
+			if err := segment.trackKeys(); err != nil {
+				return err
+			}
+		}
+		if i < len(segments)-1 {
+			segment.keys.seal()
+		}
+	}
 	return nil
 }
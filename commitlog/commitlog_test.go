@@ -3,13 +3,18 @@ package commitlog_test
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/go-kit/kit/metrics"
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/protocol"
 )
 
 var (
@@ -56,6 +61,80 @@ func TestNewCommitLog(t *testing.T) {
 	}
 }
 
+func TestCommitLogMetrics(t *testing.T) {
+	var err error
+	l := setup(t)
+	defer cleanup(t, l)
+
+	for _, exp := range msgSets {
+		_, err = l.Append(exp)
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	rebuilds := new(counter)
+	flushes := new(histogram)
+	recoveries := new(histogram)
+	open := func(maxSegmentBytes int64, flushInterval time.Duration) *commitlog.CommitLog {
+		l, err := commitlog.New(commitlog.Options{
+			Path:            l.Path,
+			MaxSegmentBytes: maxSegmentBytes,
+			MaxLogBytes:     30,
+			FlushInterval:   flushInterval,
+			Metrics: &commitlog.Metrics{
+				FlushLatency:  flushes,
+				RecoveryTime:  recoveries,
+				IndexRebuilds: rebuilds,
+			},
+		})
+		require.NoError(t, err)
+		return l
+	}
+	// the indexes were closed cleanly so they're up to date
+	l = open(6, 0)
+	require.Equal(t, float64(0), rebuilds.value)
+	require.Equal(t, 1, recoveries.observations)
+
+	require.NoError(t, l.Sync())
+	require.Equal(t, 1, flushes.observations)
+	require.NoError(t, l.Close())
+
+	require.NoError(t, os.Remove(l.Segments()[0].Index.Name()))
+	flushes.observations = 0
+	// big segments so the appends don't roll the log, which syncs it too
+	l = open(1024, time.Nanosecond)
+	require.Equal(t, float64(1), rebuilds.value)
+
+	// each append syncs the log since the flush interval's always passed
+	for _, exp := range msgSets {
+		_, timing, err := l.AppendTimed(exp)
+		require.NoError(t, err)
+		require.NotZero(t, timing.Sync)
+	}
+	require.Equal(t, len(msgSets), flushes.observations)
+	require.NoError(t, l.Close())
+
+	// without a flush interval appends aren't synced
+	l = open(1024, 0)
+	_, timing, err := l.AppendTimed(msgSets[0])
+	require.NoError(t, err)
+	require.Zero(t, timing.Sync)
+}
+
+type counter struct {
+	value float64
+}
+
+func (c *counter) With(labelValues ...string) metrics.Counter { return c }
+func (c *counter) Add(delta float64)                          { c.value += delta }
+
+type histogram struct {
+	observations int
+}
+
+func (h *histogram) With(labelValues ...string) metrics.Histogram { return h }
+func (h *histogram) Observe(value float64)                        { h.observations++ }
+
 func BenchmarkCommitLog(b *testing.B) {
 	var err error
 	l := setup(b)
@@ -110,6 +189,54 @@ func TestTruncate(t *testing.T) {
 	}
 }
 
+func TestCommitLogTruncateTo(t *testing.T) {
+	var err error
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: int64(2 * msgSets[0].Size()),
+		MaxLogBytes:     -1,
+	})
+	defer cleanup(t, l)
+
+	require.NoError(t, l.AssignEpoch(1, 0))
+	for i := 0; i < 5; i++ {
+		_, err = l.Append(commitlog.NewMessageSet(0, msgs...))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.AssignEpoch(2, 5))
+	_, err = l.Append(commitlog.NewMessageSet(0, msgs...))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(l.Segments()))
+
+	// truncating past the newest offset does nothing
+	require.NoError(t, l.TruncateTo(7))
+	require.Equal(t, int64(6), l.NewestOffset())
+
+	// the later segments are deleted and the one holding the offset's truncated
+	require.NoError(t, l.TruncateTo(3))
+	require.Equal(t, int64(3), l.NewestOffset())
+	require.Equal(t, 2, len(l.Segments()))
+	require.Equal(t, int32(1), l.LatestEpoch())
+
+	// appends carry on from the offset
+	offset, err := l.Append(commitlog.NewMessageSet(0, msgs...))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), offset)
+	maxBytes := msgSets[0].Size()
+	r, err := l.NewReader(2, 2*maxBytes)
+	require.NoError(t, err)
+	p := make([]byte, 2*maxBytes)
+	_, err = io.ReadFull(r, p)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), commitlog.MessageSet(p).Offset())
+	require.Equal(t, int64(3), commitlog.MessageSet(p[maxBytes:]).Offset())
+
+	// the truncated epochs stay gone when the log's reopened
+	require.NoError(t, l.Close())
+	l, err = commitlog.New(commitlog.Options{Path: l.Path, MaxSegmentBytes: int64(2 * maxBytes), MaxLogBytes: -1})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), l.LatestEpoch())
+}
+
 func TestCleaner(t *testing.T) {
 	var err error
 	l := setup(t)
@@ -132,6 +259,430 @@ func TestCleaner(t *testing.T) {
 	}
 }
 
+func TestCommitLogConfigure(t *testing.T) {
+	var err error
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: 6,
+		MaxLogBytes:     -1,
+	})
+	defer cleanup(t, l)
+
+	for i := 0; i < 2; i++ {
+		for _, msgSet := range msgSets {
+			_, err = l.Append(msgSet)
+			require.NoError(t, err)
+		}
+	}
+	// no retention so nothing's cleaned
+	require.Equal(t, 4, len(l.Segments()))
+
+	l.Configure(commitlog.Options{MaxLogBytes: 30})
+	require.Equal(t, int64(6), l.MaxSegmentBytes)
+	require.Equal(t, commitlog.CleanupPolicy(commitlog.DeleteCleanupPolicy), l.CleanupPolicy)
+
+	for _, msgSet := range msgSets {
+		_, err = l.Append(msgSet)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, len(l.Segments()))
+}
+
+func TestCommitLogSize(t *testing.T) {
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: 6,
+		MaxLogBytes:     -1,
+	})
+	defer cleanup(t, l)
+
+	require.Equal(t, int64(0), l.Size())
+	var size int64
+	for _, msgSet := range msgSets {
+		_, err := l.Append(msgSet)
+		require.NoError(t, err)
+		size += int64(len(msgSet))
+	}
+	// the messages are split across segments
+	require.True(t, len(l.Segments()) > 1)
+	require.Equal(t, size, l.Size())
+}
+
+func TestCommitLogMove(t *testing.T) {
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: 6,
+		MaxLogBytes:     -1,
+	})
+	defer cleanup(t, l)
+	old := l.Path
+
+	for _, msgSet := range msgSets {
+		_, err := l.Append(msgSet)
+		require.NoError(t, err)
+	}
+	// appends carry on while the log's being moved
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := l.Append(msgSets[0]); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	// and so do reads, the old segments aren't closed under them
+	stop, read := make(chan struct{}), make(chan error)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				read <- nil
+				return
+			default:
+			}
+			r, err := l.NewReader(0, msgSets[0].Size())
+			if err != nil {
+				read <- err
+				return
+			}
+			if _, err := r.Read(make([]byte, msgSets[0].Size())); err != nil {
+				read <- err
+				return
+			}
+		}
+	}()
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("commitlogtest%d", rand.Int63()))
+	require.NoError(t, l.Move(path, 0))
+	require.NoError(t, <-done)
+	close(stop)
+	require.NoError(t, <-read)
+	require.Equal(t, path, l.Path)
+	_, err := os.Stat(old)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(commitlog.FuturePath(path))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = l.Append(msgSets[1])
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	l, err = commitlog.New(commitlog.Options{Path: path, MaxSegmentBytes: 6, MaxLogBytes: -1})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(msgSets)+101), l.NewestOffset())
+	r, err := l.NewReader(0, msgSets[0].Size())
+	require.NoError(t, err)
+	p := make([]byte, msgSets[0].Size())
+	_, err = r.Read(p)
+	require.NoError(t, err)
+	act := commitlog.MessageSet(p)
+	require.Equal(t, int64(0), act.Offset())
+	require.Equal(t, msgSets[0].Payload(), act.Payload())
+}
+
+func TestCommitLogMoveThrottled(t *testing.T) {
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: 6,
+		MaxLogBytes:     -1,
+	})
+	defer cleanup(t, l)
+	for _, msgSet := range msgSets {
+		_, err := l.Append(msgSet)
+		require.NoError(t, err)
+	}
+	// the copy's limited to a tenth of the log a second
+	start := time.Now()
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("commitlogtest%d", rand.Int63()))
+	require.NoError(t, l.Move(path, l.Size()*10))
+	require.True(t, time.Since(start) >= 90*time.Millisecond)
+	require.Equal(t, int64(len(msgSets)), l.NewestOffset())
+}
+
+func TestCommitLogRecoverMove(t *testing.T) {
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: 6,
+		MaxLogBytes:     -1,
+	})
+	defer cleanup(t, l)
+	old := l.Path
+	for _, msgSet := range msgSets {
+		_, err := l.Append(msgSet)
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	// a move stopped after it switched over to the copy, before it removed the old dir
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("commitlogtest%d", rand.Int63()))
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "the-topic-0")
+	require.NoError(t, os.MkdirAll(path, 0755))
+	files, err := ioutil.ReadDir(old)
+	require.NoError(t, err)
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join(old, f.Name()))
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(path, f.Name()), b, 0666))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(path, "moved-from"), []byte(old), 0666))
+	// and one stopped before it switched over
+	require.NoError(t, os.MkdirAll(commitlog.FuturePath(filepath.Join(dir, "the-topic-1")), 0755))
+
+	from, err := commitlog.MovedFrom(path)
+	require.NoError(t, err)
+	require.Equal(t, old, from)
+	l, err = commitlog.New(commitlog.Options{Path: path, MaxSegmentBytes: 6, MaxLogBytes: -1})
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, int64(len(msgSets)), l.NewestOffset())
+	_, err = os.Stat(old)
+	require.True(t, os.IsNotExist(err))
+	from, err = commitlog.MovedFrom(path)
+	require.NoError(t, err)
+	require.Equal(t, "", from)
+
+	require.NoError(t, commitlog.RemoveFutures(dir))
+	_, err = os.Stat(commitlog.FuturePath(filepath.Join(dir, "the-topic-1")))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+}
+
+func TestCommitLogDeleteRecords(t *testing.T) {
+	var err error
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: 6,
+		MaxLogBytes:     -1,
+	})
+	defer cleanup(t, l)
+
+	for i := 0; i < 4; i++ {
+		_, err = l.Append(commitlog.NewMessageSet(0, msgs...))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 4, len(l.Segments()))
+
+	require.Equal(t, commitlog.ErrOffsetOutOfRange, l.DeleteRecords(5))
+	require.NoError(t, l.DeleteRecords(2))
+	require.Equal(t, int64(2), l.OldestOffset())
+	// the segments before the start offset are deleted
+	require.Equal(t, int64(2), l.Segments()[0].BaseOffset)
+	// moving the start offset back does nothing
+	require.NoError(t, l.DeleteRecords(1))
+	require.Equal(t, int64(2), l.OldestOffset())
+
+	// the start offset holds when the log's reopened
+	require.NoError(t, l.Close())
+	l, err = commitlog.New(commitlog.Options{Path: l.Path, MaxSegmentBytes: 6, MaxLogBytes: -1})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), l.OldestOffset())
+	require.Equal(t, int64(4), l.NewestOffset())
+
+	// and readers start from it
+	maxBytes := msgSets[0].Size()
+	r, err := l.NewReader(0, maxBytes)
+	require.NoError(t, err)
+	p := make([]byte, maxBytes)
+	_, err = r.Read(p)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), commitlog.MessageSet(p).Offset())
+}
+
+func TestCommitLogLeaderEpochs(t *testing.T) {
+	var err error
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: 6,
+		MaxLogBytes:     -1,
+	})
+	defer cleanup(t, l)
+
+	epoch, offset := l.EndOffsetForEpoch(0)
+	require.Equal(t, int32(-1), epoch)
+	require.Equal(t, int64(-1), offset)
+
+	require.NoError(t, l.AssignEpoch(1, 0))
+	for i := 0; i < 2; i++ {
+		_, err = l.Append(commitlog.NewMessageSet(0, msgs...))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.AssignEpoch(3, 2))
+	_, err = l.Append(commitlog.NewMessageSet(0, msgs...))
+	require.NoError(t, err)
+	// older epochs are ignored
+	require.NoError(t, l.AssignEpoch(2, 3))
+	require.Equal(t, int32(3), l.LatestEpoch())
+
+	tests := []struct {
+		epoch     int32
+		endEpoch  int32
+		endOffset int64
+	}{
+		{epoch: 0, endEpoch: -1, endOffset: -1},
+		{epoch: 1, endEpoch: 1, endOffset: 2},
+		{epoch: 2, endEpoch: 1, endOffset: 2},
+		{epoch: 3, endEpoch: 3, endOffset: 3},
+		{epoch: 4, endEpoch: 3, endOffset: 3},
+	}
+	check := func() {
+		for _, test := range tests {
+			epoch, offset := l.EndOffsetForEpoch(test.epoch)
+			require.Equal(t, test.endEpoch, epoch, "epoch %d", test.epoch)
+			require.Equal(t, test.endOffset, offset, "epoch %d", test.epoch)
+		}
+	}
+	check()
+
+	// the cache holds when the log's reopened
+	require.NoError(t, l.Close())
+	l, err = commitlog.New(commitlog.Options{Path: l.Path, MaxSegmentBytes: 6, MaxLogBytes: -1})
+	require.NoError(t, err)
+	check()
+
+	// epochs starting past a new epoch's start offset were truncated and are replaced
+	require.NoError(t, l.AssignEpoch(5, 2))
+	epoch, offset = l.EndOffsetForEpoch(3)
+	require.Equal(t, int32(1), epoch)
+	require.Equal(t, int64(2), offset)
+}
+
+func TestCommitLogRecordBatchOffsets(t *testing.T) {
+	var err error
+	l := setupWithOptions(t, commitlog.Options{MaxSegmentBytes: 1 << 20, MaxLogBytes: -1})
+	defer cleanup(t, l)
+
+	batch := func(n int) []byte {
+		b := &protocol.RecordBatch{LastOffsetDelta: int32(n - 1), ProducerID: -1}
+		for i := 0; i < n; i++ {
+			b.Records = append(b.Records, protocol.Record{OffsetDelta: int32(i), Value: []byte("value")})
+		}
+		return b.Bytes()
+	}
+	// each record of a v2 batch takes an offset, batches appended together are each stamped
+	offset, err := l.Append(batch(3))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+	offset, err = l.Append(append(batch(2), batch(1)...))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), offset)
+	require.Equal(t, int64(6), l.NewestOffset())
+
+	read := func(offset int64) []int64 {
+		r, err := l.NewReader(offset, 1<<20)
+		require.NoError(t, err)
+		p, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		batches, err := protocol.ReadRecordBatches(p)
+		require.NoError(t, err)
+		var offsets []int64
+		for _, b := range batches {
+			offsets = append(offsets, b.BaseOffset)
+		}
+		return offsets
+	}
+	// reading from an offset inside a batch starts with the batch holding it
+	require.Equal(t, []int64{0, 3, 5}, read(1))
+	require.Equal(t, []int64{3, 5}, read(3))
+	require.Equal(t, []int64{5}, read(5))
+
+	// the offsets are recovered when the log's reopened
+	require.NoError(t, l.Close())
+	l, err = commitlog.New(commitlog.Options{Path: l.Path, MaxSegmentBytes: 1 << 20, MaxLogBytes: -1})
+	require.NoError(t, err)
+	require.Equal(t, int64(6), l.NewestOffset())
+	require.Equal(t, []int64{3, 5}, read(4))
+
+	// truncating inside a batch removes the whole batch
+	require.NoError(t, l.TruncateTo(4))
+	require.Equal(t, int64(3), l.NewestOffset())
+	require.Equal(t, []int64{0}, read(0))
+}
+
+func TestCommitLogRecordHeaders(t *testing.T) {
+	var err error
+	l := setupWithOptions(t, commitlog.Options{MaxSegmentBytes: 1 << 20, MaxLogBytes: -1})
+	defer cleanup(t, l)
+
+	records := []protocol.Record{
+		{Key: []byte("key"), Value: []byte("value"), Headers: []protocol.RecordHeader{{Key: "trace-id", Value: []byte("abc")}, {Key: "null"}}},
+		{OffsetDelta: 1, Value: []byte("another value")},
+	}
+	_, err = l.Append((&protocol.RecordBatch{LastOffsetDelta: 1, ProducerID: -1, Records: records}).Bytes())
+	require.NoError(t, err)
+
+	read := func() []protocol.Record {
+		r, err := l.NewReader(0, 1<<20)
+		require.NoError(t, err)
+		p, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		batches, err := protocol.ReadRecordBatches(p)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(batches))
+		return batches[0].Records
+	}
+	require.Equal(t, records, read())
+
+	// the headers are kept on disk with their records
+	require.NoError(t, l.Close())
+	l, err = commitlog.New(commitlog.Options{Path: l.Path, MaxSegmentBytes: 1 << 20, MaxLogBytes: -1})
+	require.NoError(t, err)
+	require.Equal(t, records, read())
+}
+
+func TestCommitLogOffsetForTimestamp(t *testing.T) {
+	var err error
+	// small segments so the batches span several
+	l := setupWithOptions(t, commitlog.Options{MaxSegmentBytes: 100, MaxLogBytes: -1})
+	defer cleanup(t, l)
+
+	batch := func(n int, maxTimestamp int64) []byte {
+		b := &protocol.RecordBatch{LastOffsetDelta: int32(n - 1), FirstTimestamp: maxTimestamp, MaxTimestamp: maxTimestamp, ProducerID: -1}
+		for i := 0; i < n; i++ {
+			b.Records = append(b.Records, protocol.Record{OffsetDelta: int32(i), Value: []byte("value")})
+		}
+		return b.Bytes()
+	}
+	for _, b := range [][]byte{batch(2, 100), batch(1, 300), batch(3, 200), batch(1, 400)} {
+		_, err = l.Append(b)
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 1)
+
+	lookup := func(timestamp int64) (int64, int64) {
+		e, ok := l.OffsetForTimestamp(timestamp)
+		if !ok {
+			return -1, -1
+		}
+		return e.Offset, e.Timestamp
+	}
+	verify := func() {
+		offset, ts := lookup(50)
+		require.Equal(t, int64(0), offset)
+		require.Equal(t, int64(100), ts)
+		// the batch at 3 has an earlier timestamp than the one before it so isn't indexed
+		offset, ts = lookup(150)
+		require.Equal(t, int64(2), offset)
+		require.Equal(t, int64(300), ts)
+		offset, _ = lookup(250)
+		require.Equal(t, int64(2), offset)
+		offset, ts = lookup(350)
+		require.Equal(t, int64(6), offset)
+		require.Equal(t, int64(400), ts)
+		offset, _ = lookup(500)
+		require.Equal(t, int64(-1), offset)
+	}
+	verify()
+
+	// the time indexes are rebuilt when the log's reopened
+	require.NoError(t, l.Close())
+	l, err = commitlog.New(commitlog.Options{Path: l.Path, MaxSegmentBytes: 100, MaxLogBytes: -1})
+	require.NoError(t, err)
+	verify()
+
+	// truncating removes the truncated batches' timestamps
+	require.NoError(t, l.TruncateTo(6))
+	offset, _ := lookup(350)
+	require.Equal(t, int64(-1), offset)
+	offset, _ = lookup(250)
+	require.Equal(t, int64(2), offset)
+}
+
 func check(t require.TestingT, got, want []byte) {
 	if !bytes.Equal(got, want) {
 		t.Errorf("got = %s, want %s", string(got), string(want))
@@ -156,6 +707,43 @@ func setupWithOptions(t require.TestingT, opts commitlog.Options) *commitlog.Com
 }
 
 func cleanup(t require.TestingT, l *commitlog.CommitLog) {
+	// the log's closed first since its open files can't be removed on windows, tests that closed
+	// it already get an error that's ignored
+	l.Close()
 	os.RemoveAll(l.Path)
-	os.MkdirAll(l.Path, 0755)
+}
+
+func TestVerify(t *testing.T) {
+	l := setupWithOptions(t, commitlog.Options{MaxSegmentBytes: 1024, MaxLogBytes: -1})
+	defer cleanup(t, l)
+	for _, ms := range msgSets {
+		_, err := l.Append(ms)
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	segments, problems, err := commitlog.Verify(l.Path)
+	require.NoError(t, err)
+	require.Equal(t, 1, segments)
+	require.Empty(t, problems)
+
+	// an index that doesn't match its log is rebuilt when the log's opened
+	index := filepath.Join(l.Path, fmt.Sprintf("%020d%s", 0, commitlog.IndexFileSuffix))
+	require.NoError(t, os.Truncate(index, 8))
+	_, problems, err = commitlog.Verify(l.Path)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(problems))
+	require.True(t, problems[0].Recoverable)
+
+	// but a partial message set at the end of the log isn't fixed
+	f, err := os.OpenFile(filepath.Join(l.Path, fmt.Sprintf("%020d%s", 0, commitlog.LogFileSuffix)), os.O_APPEND|os.O_WRONLY, 0666)
+	require.NoError(t, err)
+	_, err = f.Write(msgSets[0][:5])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, problems, err = commitlog.Verify(l.Path)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(problems))
+	require.False(t, problems[0].Recoverable)
+	require.Contains(t, problems[0].Detail, "partial message set")
 }
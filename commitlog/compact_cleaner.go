@@ -24,14 +24,28 @@ func (c *CompactCleaner) Clean(segments []*Segment) (cleaned []*Segment, err err
 	var ms MessageSet
 	var offset int64
 
-	// build the map of keys to their latest offsets
-	for _, segment := range segments {
+	// build the map of keys to their latest offsets. Only the keys that may have earlier messages
+	// are mapped, those only in one message are kept whatever, so with key bloom filters the
+	// segments that definitely don't have a key are skipped rather than mapping every key.
+	c.m = make(map[uint64]int64)
+	for i, segment := range segments {
 		ss = NewSegmentScanner(segment)
+		// the keys seen earlier in the segment
+		seen := make(map[uint64]struct{})
 
 		for ms, err = ss.Scan(); err == nil; ms, err = ss.Scan() {
 			offset = ms.Offset()
-			for _, msg := range ms.Messages() {
-				c.m[Hash(msg.Key())] = offset
+			keys, ok := ms.Keys()
+			if !ok {
+				continue
+			}
+			for _, key := range keys {
+				h := Hash(key)
+				_, dup := seen[h]
+				seen[h] = struct{}{}
+				if dup || mayContainHash(segments[:i], h) {
+					c.m[h] = offset
+				}
 			}
 		}
 	}
@@ -46,10 +60,12 @@ func (c *CompactCleaner) Clean(segments []*Segment) (cleaned []*Segment, err err
 		}
 
 		for ms, err = ss.Scan(); err == nil; ms, err = ss.Scan() {
-			var retain bool
 			offset = ms.Offset()
-			for _, msg := range ms.Messages() {
-				if c.m[Hash(msg.Key())] <= offset {
+			keys, ok := ms.Keys()
+			// message sets whose keys can't be read are kept
+			retain := !ok
+			for _, key := range keys {
+				if c.m[Hash(key)] <= offset {
 					retain = true
 				}
 			}
@@ -71,6 +87,16 @@ func (c *CompactCleaner) Clean(segments []*Segment) (cleaned []*Segment, err err
 	return cleaned, nil
 }
 
+// mayContainHash returns whether any of the segments may have a message with the key's hash.
+func mayContainHash(segments []*Segment, h uint64) bool {
+	for _, segment := range segments {
+		if segment.mayContainHash(h) {
+			return true
+		}
+	}
+	return false
+}
+
 func Hash(b []byte) uint64 {
 	h := xxhash.New()
 	if _, err := h.Write(b); err != nil {
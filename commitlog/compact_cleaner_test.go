@@ -1,7 +1,6 @@
 package commitlog_test
 
 import (
-	"os"
 	"testing"
 	"time"
 
@@ -18,34 +17,31 @@ func TestCompactCleaner(t *testing.T) {
 	msgSets = append(msgSets, newMessageSet(0, &protocol.Message{
 		Key:       []byte("travisjeffery"),
 		Value:     []byte("one tj"),
-		MagicByte: 2,
+		MagicByte: 1,
 		Timestamp: time.Now(),
 	}))
 
 	msgSets = append(msgSets, newMessageSet(1, &protocol.Message{
 		Key:       []byte("another"),
 		Value:     []byte("one another"),
-		MagicByte: 2,
+		MagicByte: 1,
 		Timestamp: time.Now(),
 	}))
 
 	msgSets = append(msgSets, newMessageSet(2, &protocol.Message{
 		Key:       []byte("travisjeffery"),
 		Value:     []byte("two tj"),
-		MagicByte: 2,
+		MagicByte: 1,
 		Timestamp: time.Now(),
 	}))
 
 	msgSets = append(msgSets, newMessageSet(3, &protocol.Message{
 		Key:       []byte("again another"),
 		Value:     []byte("again another"),
-		MagicByte: 2,
+		MagicByte: 1,
 		Timestamp: time.Now(),
 	}))
 
-	path := os.TempDir()
-	defer os.RemoveAll(path)
-
 	l := setupWithOptions(t, commitlog.Options{
 		MaxSegmentBytes: int64(len(msgSets[0]) + len(msgSets[1])),
 		MaxLogBytes:     1000,
@@ -87,19 +83,66 @@ func TestCompactCleaner(t *testing.T) {
 	req.Equal(1, count)
 
 	scanner = commitlog.NewSegmentScanner(cleaned[1])
-	count = 0
+	var keys []string
 	for {
 		ms, err = scanner.Scan()
 		if err != nil {
 			break
 		}
 		req.Equal(1, len(ms.Messages()))
-		req.Equal([]byte("travisjeffery"), ms.Messages()[0].Key())
-		req.Equal([]byte("two tj"), ms.Messages()[0].Value())
-		count++
+		keys = append(keys, string(ms.Messages()[0].Key()))
 	}
-	req.Equal(1, count)
+	// both of the segment's messages are their keys' latest, and keep their offsets
+	req.Equal([]string{"travisjeffery", "again another"}, keys)
+	req.Equal(int64(4), cleaned[1].NextOffset())
+
+}
 
+func TestCompactCleanerKeyBloomFilters(t *testing.T) {
+	req := require.New(t)
+	// small segments so each holds a couple of batches
+	l := setupWithOptions(t, commitlog.Options{
+		MaxSegmentBytes: 100,
+		MaxLogBytes:     -1,
+		CleanupPolicy:   commitlog.CompactCleanupPolicy,
+		KeyBloomFilters: true,
+	})
+	defer cleanup(t, l)
+
+	for _, key := range []string{"a", "b", "a", "c", "d", "b"} {
+		batch := &protocol.RecordBatch{ProducerID: -1, Records: []protocol.Record{{Key: []byte(key), Value: []byte("value")}}}
+		_, err := l.Append(batch.Bytes())
+		req.NoError(err)
+	}
+	segments := l.Segments()
+	req.Equal(3, len(segments))
+	// compaction dropped a at 0 when the last segment was split off
+	req.True(segments[0].MayContainKey([]byte("b")))
+	req.False(segments[0].MayContainKey([]byte("a")))
+	req.False(segments[0].MayContainKey([]byte("d")))
+	req.True(segments[1].MayContainKey([]byte("a")))
+	req.True(segments[1].MayContainKey([]byte("c")))
+	req.False(segments[1].MayContainKey([]byte("b")))
+	// the active segment's keys are tracked as they're written
+	req.True(segments[2].MayContainKey([]byte("d")))
+	req.True(segments[2].MayContainKey([]byte("b")))
+	req.False(segments[2].MayContainKey([]byte("a")))
+
+	cleaned, err := commitlog.NewCompactCleaner().Clean(segments)
+	req.NoError(err)
+	var keys []string
+	var offsets []int64
+	for _, segment := range cleaned {
+		scanner := commitlog.NewSegmentScanner(segment)
+		for ms, err := scanner.Scan(); err == nil; ms, err = scanner.Scan() {
+			k, ok := ms.Keys()
+			req.True(ok)
+			keys = append(keys, string(k[0]))
+			offsets = append(offsets, ms.Offset())
+		}
+	}
+	req.Equal([]string{"a", "c", "d", "b"}, keys)
+	req.Equal([]int64{2, 3, 4, 5}, offsets)
 }
 
 func newMessageSet(offset uint64, pmsgs ...*protocol.Message) commitlog.MessageSet {
@@ -1,8 +1,6 @@
 package commitlog_test
 
 import (
-	"fmt"
-	"os"
 	"testing"
 	"time"
 
@@ -19,35 +17,31 @@ func TestDeleteCleaner(t *testing.T) {
 	msgSets = append(msgSets, newMessageSet(0, &protocol.Message{
 		Key:       []byte("travisjeffery"),
 		Value:     []byte("one tj"),
-		MagicByte: 2,
+		MagicByte: 1,
 		Timestamp: time.Now(),
 	}))
 
 	msgSets = append(msgSets, newMessageSet(1, &protocol.Message{
 		Key:       []byte("another"),
 		Value:     []byte("one another"),
-		MagicByte: 2,
+		MagicByte: 1,
 		Timestamp: time.Now(),
 	}))
 
 	msgSets = append(msgSets, newMessageSet(2, &protocol.Message{
 		Key:       []byte("travisjeffery"),
 		Value:     []byte("two tj"),
-		MagicByte: 2,
+		MagicByte: 1,
 		Timestamp: time.Now(),
 	}))
 
 	msgSets = append(msgSets, newMessageSet(3, &protocol.Message{
 		Key:       []byte("again another"),
 		Value:     []byte("again another"),
-		MagicByte: 2,
+		MagicByte: 1,
 		Timestamp: time.Now(),
 	}))
 
-	path := os.TempDir()
-	fmt.Println(path)
-	defer os.RemoveAll(path)
-
 	l := setupWithOptions(t, commitlog.Options{
 		MaxSegmentBytes: int64(len(msgSets[0]) + len(msgSets[1])),
 		MaxLogBytes:     1000,
@@ -8,8 +8,6 @@ import (
 	"sync"
 
 	"github.com/pkg/errors"
-
-	"github.com/tysontate/gommap"
 )
 
 var (
@@ -28,7 +26,7 @@ const (
 
 type Index struct {
 	options
-	mmap     gommap.MMap
+	mmap     []byte
 	file     *os.File
 	mu       sync.RWMutex
 	position int64
@@ -87,7 +85,7 @@ func NewIndex(opts options) (idx *Index, err error) {
 		return nil, err
 	}
 
-	idx.mmap, err = gommap.Map(idx.file.Fd(), gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED)
+	idx.mmap, err = mapIndex(idx.file)
 	if err != nil {
 		return nil, errors.Wrap(err, "mmap file failed")
 	}
@@ -133,6 +131,19 @@ func (idx *Index) ReadEntryAtLogOffset(e *Entry, logOffset int64) error {
 	return idx.ReadEntryAtFileOffset(e, logOffset*entryWidth)
 }
 
+// entryAt returns the entry at the given byte offset of the Index file, even if it's past the
+// entries written since the Index was truncated.
+func (idx *Index) entryAt(fileOffset int64) Entry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var rel relEntry
+	rel.Offset = int32(Encoding.Uint32(idx.mmap[fileOffset+offsetOffset:]))
+	rel.Position = int32(Encoding.Uint32(idx.mmap[fileOffset+positionOffset:]))
+	var e Entry
+	rel.fill(&e, idx.baseOffset)
+	return e
+}
+
 func (idx *Index) ReadAt(p []byte, offset int64) (n int, err error) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
@@ -156,10 +167,10 @@ func (idx *Index) WriteAt(p []byte, offset int64) (n int) {
 func (idx *Index) Sync() error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
-	if err := idx.file.Sync(); err != nil {
+	if err := syncFile(idx.file); err != nil {
 		return errors.Wrap(err, "file sync failed")
 	}
-	if err := idx.mmap.Sync(gommap.MS_SYNC); err != nil {
+	if err := syncIndex(idx.file, idx.mmap, idx.position); err != nil {
 		return errors.Wrap(err, "mmap sync failed")
 	}
 	return nil
@@ -85,6 +85,7 @@ func TestIndexScanner(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer os.Remove(path)
+	defer idx.Close()
 
 	stat, err := idx.file.Stat()
 	if err != nil {
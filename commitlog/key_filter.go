@@ -0,0 +1,168 @@
+package commitlog
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	// bloomBitsPerKey and bloomHashes give bloom filters a false positive rate of about 1%.
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+
+	// the attributes and record count of v2 record batches
+	attributesPos   = 21
+	recordsCountPos = 57
+	compressionMask = 0x07
+)
+
+// keyFilter tracks the keys of a segment's messages so segments that don't have a key can be
+// skipped. The keys' hashes are kept while the segment's active and replaced with a bloom filter
+// once it's rolled, since they won't change.
+type keyFilter struct {
+	mu     sync.RWMutex
+	hashes map[uint64]struct{}
+	bloom  *bloomFilter
+	// any is whether the segment has messages whose keys weren't read, like compressed batches,
+	// so it may have any key.
+	any bool
+}
+
+func newKeyFilter() *keyFilter {
+	return &keyFilter{hashes: make(map[uint64]struct{})}
+}
+
+// add adds the keys of the message set's entry.
+func (f *keyFilter) add(ms MessageSet) {
+	keys, ok := ms.Keys()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !ok {
+		f.any = true
+		return
+	}
+	for _, key := range keys {
+		h := Hash(key)
+		if f.bloom != nil {
+			f.bloom.add(h)
+		} else {
+			f.hashes[h] = struct{}{}
+		}
+	}
+}
+
+// seal replaces the hashes with a bloom filter sized for them.
+func (f *keyFilter) seal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bloom != nil {
+		return
+	}
+	f.bloom = newBloomFilter(len(f.hashes))
+	for h := range f.hashes {
+		f.bloom.add(h)
+	}
+	f.hashes = nil
+}
+
+// mayContain returns false if the segment definitely doesn't have a message with the key's hash.
+func (f *keyFilter) mayContain(h uint64) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.any {
+		return true
+	}
+	if f.bloom != nil {
+		return f.bloom.mayContain(h)
+	}
+	_, ok := f.hashes[h]
+	return ok
+}
+
+// bloomFilter is a bloom filter over key hashes, its bits are picked by double hashing the two
+// halves of the hash.
+type bloomFilter struct {
+	bits []uint64
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	n := (keys*bloomBitsPerKey + 63) / 64
+	if n == 0 {
+		n = 1
+	}
+	return &bloomFilter{bits: make([]uint64, n)}
+}
+
+func (f *bloomFilter) add(h uint64) {
+	m := uint64(len(f.bits) * 64)
+	h1, h2 := h&0xffffffff, h>>32
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) mayContain(h uint64) bool {
+	m := uint64(len(f.bits) * 64)
+	h1, h2 := h&0xffffffff, h>>32
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns the keys of the message set's entry, the keys of a v2 record batch's records or
+// of older messages. It returns false if they can't be read, like when they're compressed.
+func (ms MessageSet) Keys() ([][]byte, bool) {
+	if len(ms) < msgSetHeaderLen+5 {
+		return nil, false
+	}
+	if int8(ms[magicPos]) < 2 {
+		var keys [][]byte
+		for _, msg := range ms.Messages() {
+			if msg.Attributes()&compressionMask != 0 {
+				return nil, false
+			}
+			keys = append(keys, msg.Key())
+		}
+		return keys, true
+	}
+	if len(ms) < recordBatchLen || Encoding.Uint16(ms[attributesPos:])&compressionMask != 0 {
+		return nil, false
+	}
+	count := int32(Encoding.Uint32(ms[recordsCountPos:]))
+	b := ms[recordBatchLen:]
+	var keys [][]byte
+	for i := int32(0); i < count; i++ {
+		length, n := binary.Varint(b)
+		if n <= 0 || length < 0 || int64(len(b)-n) < length {
+			return nil, false
+		}
+		record := b[n : n+int(length)]
+		b = b[n+int(length):]
+		// attributes, then the timestamp and offset deltas before the key
+		if len(record) < 1 {
+			return nil, false
+		}
+		r := record[1:]
+		for j := 0; j < 2; j++ {
+			if _, n = binary.Varint(r); n <= 0 {
+				return nil, false
+			}
+			r = r[n:]
+		}
+		keyLen, n := binary.Varint(r)
+		if n <= 0 || int64(len(r)-n) < keyLen {
+			return nil, false
+		}
+		var key []byte
+		if keyLen >= 0 {
+			key = r[n : n+int(keyLen)]
+		}
+		keys = append(keys, key)
+	}
+	return keys, true
+}
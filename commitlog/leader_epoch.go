@@ -0,0 +1,120 @@
+package commitlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// LeaderEpochFile is the file in the log's directory its leader epoch cache is checkpointed to.
+	LeaderEpochFile = "leader-epoch-checkpoint"
+
+	leaderEpochFileVersion = 0
+)
+
+// EpochEntry is the offset of the first message the partition's leader appended in an epoch.
+type EpochEntry struct {
+	Epoch       int32
+	StartOffset int64
+}
+
+// AssignEpoch records that messages from offset on were appended in epoch. Epochs older than the
+// latest are ignored and entries at or past offset, left by messages that have since been
+// truncated, are replaced. The cache's checkpointed so it holds when the log's reopened.
+func (l *CommitLog) AssignEpoch(epoch int32, offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	epochs := l.epochs
+	if n := len(epochs); n != 0 && epochs[n-1].Epoch >= epoch {
+		return nil
+	}
+	for len(epochs) != 0 && epochs[len(epochs)-1].StartOffset >= offset {
+		epochs = epochs[:len(epochs)-1]
+	}
+	epochs = append(epochs[:len(epochs):len(epochs)], EpochEntry{Epoch: epoch, StartOffset: offset})
+	if err := writeLeaderEpochs(l.Path, epochs); err != nil {
+		return err
+	}
+	l.epochs = epochs
+	return nil
+}
+
+// LatestEpoch returns the latest epoch messages were appended in, -1 if none have been.
+func (l *CommitLog) LatestEpoch() int32 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.epochs) == 0 {
+		return -1
+	}
+	return l.epochs[len(l.epochs)-1].Epoch
+}
+
+// EndOffsetForEpoch returns the largest epoch up to the given one and the offset after its last
+// message, which is the start offset of the next epoch or the newest offset if it's the latest.
+// It returns -1 for both if the epoch's older than every epoch in the cache.
+func (l *CommitLog) EndOffsetForEpoch(epoch int32) (int32, int64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	// the first entry for an epoch after the requested one
+	i := sort.Search(len(l.epochs), func(i int) bool { return l.epochs[i].Epoch > epoch })
+	if i == 0 {
+		return -1, -1
+	}
+	if i == len(l.epochs) {
+		return l.epochs[i-1].Epoch, l.NewestOffset()
+	}
+	return l.epochs[i-1].Epoch, l.epochs[i].StartOffset
+}
+
+// readLeaderEpochs reads the leader epoch cache checkpointed in dir. The file's a version line,
+// an entry count line, then a line of epoch and start offset per entry.
+func readLeaderEpochs(dir string) ([]EpochEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, LeaderEpochFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read leader epochs failed")
+	}
+	r := bufio.NewReader(bytes.NewReader(b))
+	var version, n int
+	if _, err := fmt.Fscanln(r, &version); err != nil {
+		return nil, errors.Wrap(err, "read leader epochs version failed")
+	}
+	if version != leaderEpochFileVersion {
+		return nil, errors.Errorf("unknown leader epochs version %d", version)
+	}
+	if _, err := fmt.Fscanln(r, &n); err != nil {
+		return nil, errors.Wrap(err, "read leader epochs count failed")
+	}
+	epochs := make([]EpochEntry, n)
+	for i := range epochs {
+		if _, err := fmt.Fscanln(r, &epochs[i].Epoch, &epochs[i].StartOffset); err != nil {
+			return nil, errors.Wrap(err, "read leader epoch failed")
+		}
+	}
+	return epochs, nil
+}
+
+// writeLeaderEpochs writes the epochs to a temporary file that's then renamed so the checkpoint
+// is never partly written.
+func writeLeaderEpochs(dir string, epochs []EpochEntry) error {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, leaderEpochFileVersion)
+	fmt.Fprintln(&b, len(epochs))
+	for _, e := range epochs {
+		fmt.Fprintln(&b, e.Epoch, e.StartOffset)
+	}
+	path := filepath.Join(dir, LeaderEpochFile)
+	if err := ioutil.WriteFile(path+".tmp", b.Bytes(), 0666); err != nil {
+		return errors.Wrap(err, "write leader epochs failed")
+	}
+	return os.Rename(path+".tmp", path)
+}
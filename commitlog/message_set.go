@@ -4,6 +4,16 @@ const (
 	offsetPos       = 0
 	sizePos         = 8
 	msgSetHeaderLen = 12
+
+	// the magic byte and last offset delta of v2 record batches, which start like message sets
+	magicPos           = 16
+	lastOffsetDeltaPos = 23
+	maxTimestampPos    = 35
+	recordBatchLen     = 61
+
+	// the timestamp of v1 messages, after their crc, magic byte and attributes
+	messageTimestampPos = 18
+	messageV1Len        = 26
 )
 
 type MessageSet []byte
@@ -32,6 +42,45 @@ func (ms MessageSet) Size() int32 {
 	return int32(Encoding.Uint32(ms[sizePos:sizePos+4]) + msgSetHeaderLen)
 }
 
+// OffsetCount returns how many offsets the message set's entry takes. v2 record batches take one
+// for each offset up to their last offset delta, older message sets one.
+func (ms MessageSet) OffsetCount() int64 {
+	if len(ms) < recordBatchLen || int8(ms[magicPos]) < 2 {
+		return 1
+	}
+	delta := int32(Encoding.Uint32(ms[lastOffsetDeltaPos : lastOffsetDeltaPos+4]))
+	if delta < 0 {
+		return 1
+	}
+	return int64(delta) + 1
+}
+
+// Timestamp returns the timestamp of the message set's entry, the max timestamp of v2 record
+// batches and the timestamp of v1 messages, or -1 for v0 messages which don't have one.
+func (ms MessageSet) Timestamp() int64 {
+	switch {
+	case len(ms) >= recordBatchLen && int8(ms[magicPos]) >= 2:
+		return int64(Encoding.Uint64(ms[maxTimestampPos : maxTimestampPos+8]))
+	case len(ms) >= messageV1Len && ms[magicPos] == 1:
+		return int64(Encoding.Uint64(ms[messageTimestampPos : messageTimestampPos+8]))
+	}
+	return -1
+}
+
+// Entries splits the message set into its entries, leaving out a partial one at its end.
+func (ms MessageSet) Entries() []MessageSet {
+	var entries []MessageSet
+	for len(ms) >= msgSetHeaderLen {
+		size := int64(Encoding.Uint32(ms[sizePos:sizePos+4])) + msgSetHeaderLen
+		if size > int64(len(ms)) {
+			break
+		}
+		entries = append(entries, ms[:size])
+		ms = ms[size:]
+	}
+	return entries
+}
+
 func (ms MessageSet) Payload() []byte {
 	return ms[msgSetHeaderLen:]
 }
@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/protocol"
 )
 
 func TestMessageSet(t *testing.T) {
@@ -33,3 +34,25 @@ func TestMessages(t *testing.T) {
 	req.Equal(commitlog.Message(emptyMessage), msgs[0])
 	req.Equal(commitlog.Message(emptyV1Message), msgs[1])
 }
+
+func TestMessageSetKeys(t *testing.T) {
+	req := require.New(t)
+	v1 := newMessageSet(0, &protocol.Message{Key: []byte("key"), Value: []byte("value"), MagicByte: 1})
+	keys, ok := v1.Keys()
+	req.True(ok)
+	req.Equal([][]byte{[]byte("key")}, keys)
+
+	batch := &protocol.RecordBatch{LastOffsetDelta: 1, ProducerID: -1, Records: []protocol.Record{
+		{OffsetDelta: 0, Key: []byte("first"), Value: []byte("value")},
+		{OffsetDelta: 1, Value: []byte("no key")},
+	}}
+	v2 := commitlog.MessageSet(batch.Bytes())
+	keys, ok = v2.Keys()
+	req.True(ok)
+	req.Equal([][]byte{[]byte("first"), nil}, keys)
+
+	// compressed records aren't read
+	v2[22] |= 1
+	_, ok = v2.Keys()
+	req.False(ok)
+}
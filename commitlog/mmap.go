@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package commitlog
+
+import (
+	"os"
+
+	"github.com/tysontate/gommap"
+)
+
+// mapIndex maps the index file into memory, so entries written to it are written to the file.
+func mapIndex(f *os.File) ([]byte, error) {
+	return gommap.Map(f.Fd(), gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED)
+}
+
+// syncIndex flushes the index's mapped entries to its file.
+func syncIndex(f *os.File, m []byte, size int64) error {
+	return gommap.MMap(m).Sync(gommap.MS_SYNC)
+}
@@ -0,0 +1,29 @@
+package commitlog
+
+import (
+	"io"
+	"os"
+)
+
+// mapIndex reads the index file into memory. Windows can't map the file with gommap, and a mapped
+// file can't be truncated there, so entries are kept in memory and written to the file when
+// they're synced.
+func mapIndex(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m := make([]byte, fi.Size())
+	if _, err := f.ReadAt(m, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return m, nil
+}
+
+// syncIndex writes the index's size bytes of entries to its file.
+func syncIndex(f *os.File, m []byte, size int64) error {
+	if _, err := f.WriteAt(m[:size], 0); err != nil {
+		return err
+	}
+	return syncFile(f)
+}
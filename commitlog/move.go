@@ -0,0 +1,259 @@
+package commitlog
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	futureSuffix = ".future"
+	// movedFromFile is written to a moved log's directory before it's switched to and names the
+	// directory it was moved from, it's removed once that's been removed.
+	movedFromFile = "moved-from"
+)
+
+// FuturePath returns the directory a log being moved to path is copied into until it's switched
+// over.
+func FuturePath(path string) string {
+	return path + futureSuffix
+}
+
+// Move moves the log to the directory at path. The segments are copied to the future path while
+// the log is still being appended to, then the copies are caught up and the log switched over to
+// them with appends blocked, so appends only wait on what was written during the first copy. The
+// old directory is removed once the log has switched. Readers hold the log's read lock while they
+// read so the old segments aren't closed under them.
+//
+// A broker that stops mid-move is left with either the future directory, which is stale and
+// removed with RemoveFutures, or both directories, in which case the new one has the moved-from
+// file and the old one's removed when the log's opened.
+//
+// bytesPerSec limits the rate the segments are first copied at so the move doesn't starve the
+// disk, zero doesn't limit it. Catching up isn't limited since appends are blocked while it runs.
+func (l *CommitLog) Move(path string, bytesPerSec int64) error {
+	future := FuturePath(path)
+	if err := os.RemoveAll(future); err != nil {
+		return errors.Wrap(err, "remove future dir failed")
+	}
+	if err := os.MkdirAll(future, 0755); err != nil {
+		return errors.Wrap(err, "mkdir failed")
+	}
+	copied := make(map[*Segment]int64)
+	t := newThrottle(bytesPerSec)
+	for _, segment := range l.Segments() {
+		n, err := copySegment(segment, future, 0, t)
+		if err != nil {
+			os.RemoveAll(future)
+			return err
+		}
+		copied[segment] = n
+	}
+
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := l.catchUp(future, path, copied)
+	if err != nil {
+		os.RemoveAll(future)
+		return err
+	}
+	for _, segment := range l.segments {
+		if err := segment.Close(); err != nil {
+			return err
+		}
+	}
+	l.Path = path
+	l.name = filepath.Base(path)
+	l.segments = segments
+	l.vActiveSegment.Store(segments[len(segments)-1])
+	return finishMove(path)
+}
+
+// MovedFrom returns the directory the log at path was moved from if the move stopped before it
+// was removed, or "" if there's no such move.
+func MovedFrom(path string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(path, movedFromFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "read moved from file failed")
+	}
+	return string(b), nil
+}
+
+// finishMove removes the directory the log at path was moved from, if it's still around, and
+// then the moved-from file.
+func finishMove(path string) error {
+	old, err := MovedFrom(path)
+	if err != nil || old == "" {
+		return err
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return errors.Wrap(err, "remove old dir failed")
+	}
+	if err := os.Remove(filepath.Join(path, movedFromFile)); err != nil {
+		return errors.Wrap(err, "remove moved from file failed")
+	}
+	return nil
+}
+
+// RemoveFutures removes the future directories in dir left by moves that stopped before they
+// switched over, their logs are still in the directories they were being moved from.
+func RemoveFutures(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+futureSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return errors.Wrap(err, "remove future dir failed")
+		}
+	}
+	return nil
+}
+
+// catchUp copies what's been written to the log since the first copy and opens the copied
+// segments at path. The caller must hold the log's locks.
+func (l *CommitLog) catchUp(future, path string, copied map[*Segment]int64) ([]*Segment, error) {
+	current := make(map[int64]bool)
+	for _, segment := range l.segments {
+		// segments split off or replaced by the cleaner since the first copy are copied whole
+		if _, err := copySegment(segment, future, copied[segment], nil); err != nil {
+			return nil, err
+		}
+		current[segment.BaseOffset] = true
+	}
+	// and the copies of segments the cleaner deleted are removed
+	for segment := range copied {
+		if current[segment.BaseOffset] {
+			continue
+		}
+		if err := os.Remove(SegmentLogPath(future, segment.BaseOffset)); err != nil {
+			return nil, errors.Wrap(err, "remove segment copy failed")
+		}
+	}
+	if l.logStartOffset != 0 {
+		if err := writeLogStartOffset(future, l.logStartOffset); err != nil {
+			return nil, err
+		}
+	}
+	if len(l.epochs) != 0 {
+		if err := writeLeaderEpochs(future, l.epochs); err != nil {
+			return nil, err
+		}
+	}
+	// the old directory's stale once the copy's renamed into place, this marks it as such
+	if err := writeSynced(filepath.Join(future, movedFromFile), []byte(l.Path)); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return nil, errors.Wrap(err, "remove dir failed")
+	}
+	if err := os.Rename(future, path); err != nil {
+		return nil, errors.Wrap(err, "rename future dir failed")
+	}
+	segments := make([]*Segment, 0, len(l.segments))
+	for _, segment := range l.segments {
+		s, err := NewSegment(path, segment.BaseOffset, l.MaxSegmentBytes)
+		if err != nil {
+			for _, s := range segments {
+				s.Close()
+			}
+			return nil, err
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
+
+// copySegment copies the segment's log from the given position to the end into its copy in dir
+// and returns the position copied up to. The copy's limited by the throttle unless it's nil.
+func copySegment(segment *Segment, dir string, from int64, t *throttle) (int64, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if from == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(SegmentLogPath(dir, segment.BaseOffset), flag, 0666)
+	if err != nil {
+		return 0, errors.Wrap(err, "open file failed")
+	}
+	defer f.Close()
+	size := segment.Size()
+	var w io.Writer = f
+	if t != nil {
+		w = &throttledWriter{w: f, t: t}
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(segment, from, size-from)); err != nil {
+		return 0, errors.Wrap(err, "copy segment failed")
+	}
+	if err := syncFile(f); err != nil {
+		return 0, errors.Wrap(err, "sync failed")
+	}
+	return size, f.Close()
+}
+
+// throttle limits the rate bytes are written at across writers.
+type throttle struct {
+	bytesPerSec int64
+	start       time.Time
+	written     int64
+}
+
+// newThrottle returns a throttle limiting writes to bytesPerSec, or nil if it's zero.
+func newThrottle(bytesPerSec int64) *throttle {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &throttle{bytesPerSec: bytesPerSec, start: time.Now()}
+}
+
+// wait records that n bytes were written and sleeps until writing them is within the rate.
+func (t *throttle) wait(n int) {
+	t.written += int64(n)
+	due := time.Duration(float64(t.written) / float64(t.bytesPerSec) * float64(time.Second))
+	if wait := due - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// throttledWriter is a writer limited by a throttle.
+type throttledWriter struct {
+	w io.Writer
+	t *throttle
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.t.wait(n)
+	return n, err
+}
+
+// writeSynced writes the file and syncs it to disk.
+func writeSynced(path string, b []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "create file failed")
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return errors.Wrap(err, "write file failed")
+	}
+	if err := syncFile(f); err != nil {
+		return errors.Wrap(err, "sync failed")
+	}
+	return f.Close()
+}
+
+// SegmentLogPath returns the path of the log of the segment with the base offset in dir.
+func SegmentLogPath(dir string, baseOffset int64) string {
+	return filepath.Join(dir, fmt.Sprintf(fileFormat, baseOffset, logSuffix))
+}
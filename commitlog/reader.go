@@ -15,8 +15,11 @@ type Reader struct {
 func (r *Reader) Read(p []byte) (n int, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	// the log's segments aren't closed, by a move, while it's read locked
+	r.cl.mu.RLock()
+	defer r.cl.mu.RUnlock()
 
-	segments := r.cl.Segments()
+	segments := r.cl.segments
 	segment := segments[r.idx]
 
 	var readSize int
@@ -43,7 +46,13 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 }
 
 func (l *CommitLog) NewReader(offset int64, maxBytes int32) (io.Reader, error) {
-	s, idx := findSegment(l.Segments(), offset)
+	// the messages before the log start offset have been deleted even if their segment hasn't
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if offset < l.logStartOffset {
+		offset = l.logStartOffset
+	}
+	s, idx := findSegment(l.segments, offset)
 	if s == nil {
 		return nil, ErrSegmentNotFound
 	}
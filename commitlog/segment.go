@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 )
@@ -17,19 +18,34 @@ const (
 	logSuffix     = ".log"
 	cleanedSuffix = ".cleaned"
 	indexSuffix   = ".index"
+	// TimeIndexFileSuffix is the suffix of segments' time index files.
+	TimeIndexFileSuffix = ".timeindex"
 )
 
 type Segment struct {
+	// next is the offset the next message set written gets. It's written atomically with the
+	// segment locked, and read atomically with NextOffset by readers that don't lock it. It's
+	// first so it's 64-bit aligned for atomic access on 32-bit platforms.
+	next       int64
 	writer     io.Writer
 	reader     io.Reader
 	log        *os.File
 	Index      *Index
+	TimeIndex  *TimeIndex
 	BaseOffset int64
-	NextOffset int64
 	Position   int64
 	maxBytes   int64
 	path       string
 	suffix     string
+	// IndexRebuilt is whether the segment's index was missing, corrupt, or didn't match its log
+	// when the segment was opened, so it had to be rebuilt from the log.
+	IndexRebuilt bool
+	// keys tracks the keys of the segment's messages when the log has key bloom filters.
+	keys *keyFilter
+	// checksum is the CRC-32C of the segment's log, worked out when followers copy the segment
+	// whole. checksummed is cleared when the log's written or truncated.
+	checksum    uint32
+	checksummed bool
 
 	sync.Mutex
 }
@@ -42,7 +58,7 @@ func NewSegment(path string, baseOffset, maxBytes int64, args ...interface{}) (*
 	s := &Segment{
 		maxBytes:   maxBytes,
 		BaseOffset: baseOffset,
-		NextOffset: baseOffset,
+		next:       baseOffset,
 		path:       path,
 		suffix:     suffix,
 	}
@@ -57,11 +73,11 @@ func NewSegment(path string, baseOffset, maxBytes int64, args ...interface{}) (*
 	return s, err
 }
 
-// SetupIndex creates and initializes an Index.
+// SetupIndex creates and initializes an Index and a TimeIndex.
 // Initialization is:
 // - Sanity check of the loaded Index
-// - Truncates the Index (clears it)
-// - Reads the log file from the beginning and re-initializes the Index
+// - Truncates the indexes (clears them)
+// - Reads the log file from the beginning and re-initializes the indexes
 func (s *Segment) SetupIndex() (err error) {
 	s.Index, err = NewIndex(options{
 		path:       s.indexPath(),
@@ -70,14 +86,22 @@ func (s *Segment) SetupIndex() (err error) {
 	if err != nil {
 		return err
 	}
+	s.TimeIndex, err = newTimeIndex(s.timeIndexPath(), s.BaseOffset)
+	if err != nil {
+		return err
+	}
 	return s.BuildIndex()
 }
 
 func (s *Segment) BuildIndex() (err error) {
-	if err = s.Index.SanityCheck(); err != nil {
+	// the index is always rebuilt from the log, but the entries it had are kept to tell whether
+	// it was up to date
+	indexed := s.Index.position
+	rebuilt := s.Index.SanityCheck() != nil
+	if err := s.Index.TruncateEntries(0); err != nil {
 		return err
 	}
-	if err := s.Index.TruncateEntries(0); err != nil {
+	if err := s.TimeIndex.truncateTo(s.BaseOffset); err != nil {
 		return err
 	}
 
@@ -90,6 +114,7 @@ func (s *Segment) BuildIndex() (err error) {
 
 	nextOffset := s.BaseOffset
 	position := int64(0)
+	entries := int64(0)
 
 loop:
 	for {
@@ -109,51 +134,92 @@ loop:
 		if err != nil {
 			break loop
 		}
-
-		// Reset the buffer to not get an overflow
-		b.Truncate(0)
-
+		ms := MessageSet(b.Bytes())
+
+		// the entries are given the offsets they were appended with, which skip the offsets
+		// of compacted messages. Entries appended along with another before each was given
+		// its own offset follow the one before it.
+		offset := ms.Offset()
+		if offset < nextOffset {
+			offset = nextOffset
+		}
 		entry := Entry{
-			Offset:   nextOffset,
+			Offset:   offset,
 			Position: position,
 		}
+		fileOffset := entries * entryWidth
+		if !rebuilt && (fileOffset >= indexed || s.Index.entryAt(fileOffset) != entry) {
+			rebuilt = true
+		}
 		err = s.Index.WriteEntry(entry)
 		if err != nil {
 			break loop
 		}
-
-		position += size + msgSetHeaderLen
-		nextOffset++
-
-		_, err = s.log.Seek(size, 1)
+		err = s.TimeIndex.maybeWriteEntry(ms.Timestamp(), offset)
 		if err != nil {
 			break loop
 		}
+
+		position += size + msgSetHeaderLen
+		nextOffset = offset + ms.OffsetCount()
+		entries++
+
+		// Reset the buffer to not get an overflow
+		b.Truncate(0)
 	}
 	if err == io.EOF {
-		s.NextOffset = nextOffset
+		atomic.StoreInt64(&s.next, nextOffset)
 		s.Position = position
+		s.IndexRebuilt = rebuilt || s.Index.position != indexed
 		return nil
 	}
 	return err
 }
 
+// NextOffset returns the offset the next message set written to the segment gets.
+func (s *Segment) NextOffset() int64 {
+	return atomic.LoadInt64(&s.next)
+}
+
 func (s *Segment) IsFull() bool {
 	s.Lock()
 	defer s.Unlock()
 	return s.Position >= s.maxBytes
 }
 
+// Size returns the number of bytes in the segment's log.
+func (s *Segment) Size() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.Position
+}
+
 // Write writes a byte slice to the log at the current position.
-// It increments the offset as well as sets the position to the new tail.
+// It sets the next offset past the offsets of the message sets in p, which must have been set,
+// adds their timestamps to the time index, as well as sets the position to the new tail.
 func (s *Segment) Write(p []byte) (n int, err error) {
 	s.Lock()
 	defer s.Unlock()
+	s.checksummed = false
 	n, err = s.writer.Write(p)
 	if err != nil {
 		return n, errors.Wrap(err, "log write failed")
 	}
-	s.NextOffset++
+	entries := MessageSet(p).Entries()
+	if len(entries) == 0 {
+		atomic.AddInt64(&s.next, 1)
+	}
+	for _, ms := range entries {
+		if next := ms.Offset() + ms.OffsetCount(); next > s.NextOffset() {
+			atomic.StoreInt64(&s.next, next)
+		}
+		if err := s.TimeIndex.maybeWriteEntry(ms.Timestamp(), ms.Offset()); err != nil {
+			return n, err
+		}
+		if s.keys != nil {
+			s.keys.add(ms)
+		}
+	}
 	s.Position += int64(n)
 	return n, nil
 }
@@ -170,12 +236,28 @@ func (s *Segment) ReadAt(p []byte, off int64) (n int, err error) {
 	return s.log.ReadAt(p, off)
 }
 
+// Sync commits the segment's log and indexes to disk.
+func (s *Segment) Sync() error {
+	s.Lock()
+	defer s.Unlock()
+	if err := syncFile(s.log); err != nil {
+		return errors.Wrap(err, "log sync failed")
+	}
+	if err := s.TimeIndex.Sync(); err != nil {
+		return err
+	}
+	return s.Index.Sync()
+}
+
 func (s *Segment) Close() error {
 	s.Lock()
 	defer s.Unlock()
 	if err := s.log.Close(); err != nil {
 		return err
 	}
+	if err := s.TimeIndex.Close(); err != nil {
+		return err
+	}
 	return s.Index.Close()
 }
 
@@ -198,6 +280,9 @@ func (s *Segment) Replace(old *Segment) (err error) {
 	if err = os.Rename(s.indexPath(), old.indexPath()); err != nil {
 		return err
 	}
+	if err = os.Rename(s.timeIndexPath(), old.timeIndexPath()); err != nil {
+		return err
+	}
 	s.suffix = ""
 	log, err := os.OpenFile(s.logPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -209,23 +294,102 @@ func (s *Segment) Replace(old *Segment) (err error) {
 	return s.SetupIndex()
 }
 
-// findEntry returns the nearest entry whose offset is greater than or equal to the given offset.
+// trackKeys reads the keys of the segment's messages so it can tell which it may have, and
+// keeps track of those written to it.
+func (s *Segment) trackKeys() error {
+	keys := newKeyFilter()
+	ss := NewSegmentScanner(s)
+	for {
+		ms, err := ss.Scan()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		keys.add(ms)
+	}
+	s.Lock()
+	s.keys = keys
+	s.Unlock()
+	return nil
+}
+
+// MayContainKey returns false if the segment definitely doesn't have a message with the key,
+// which it only knows if the log has key bloom filters.
+func (s *Segment) MayContainKey(key []byte) bool {
+	return s.mayContainHash(Hash(key))
+}
+
+func (s *Segment) mayContainHash(h uint64) bool {
+	s.Lock()
+	keys := s.keys
+	s.Unlock()
+	return keys == nil || keys.mayContain(h)
+}
+
+// findEntry returns the entry holding the given offset, the last whose offset is less than or
+// equal to it since v2 record batches hold several offsets.
 func (s *Segment) findEntry(offset int64) (e *Entry, err error) {
 	s.Lock()
 	defer s.Unlock()
 	e = &Entry{}
-	n := int(s.Index.bytes / entryWidth)
+	n := int(s.Index.position / entryWidth)
+	if n == 0 {
+		return nil, errors.New("entry not found")
+	}
 	idx := sort.Search(n, func(i int) bool {
 		_ = s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth))
-		return e.Offset >= offset || e.Offset == 0
+		return e.Offset > offset
 	})
-	if idx == n {
-		return nil, errors.New("entry not found")
+	if idx > 0 {
+		idx--
 	}
 	_ = s.Index.ReadEntryAtFileOffset(e, int64(idx*entryWidth))
 	return e, nil
 }
 
+// truncateTo removes the segment's messages from offset on, which must be at least its base
+// offset. A record batch holding offset is removed whole, so the next message appended to the
+// segment is given the offset it returns, offset or the removed batch's base offset.
+func (s *Segment) truncateTo(offset int64) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	e := &Entry{}
+	n := int(s.Index.position / entryWidth)
+	idx := sort.Search(n, func(i int) bool {
+		_ = s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth))
+		return e.Offset >= offset
+	})
+	if idx > 0 {
+		_ = s.Index.ReadEntryAtFileOffset(e, int64((idx-1)*entryWidth))
+		header := make([]byte, recordBatchLen)
+		read, _ := s.log.ReadAt(header, e.Position)
+		if e.Offset+MessageSet(header[:read]).OffsetCount() > offset {
+			idx--
+			offset = e.Offset
+		}
+	}
+	s.checksummed = false
+	position := s.Position
+	if idx < n {
+		_ = s.Index.ReadEntryAtFileOffset(e, int64(idx*entryWidth))
+		position = e.Position
+	}
+	if err := s.log.Truncate(position); err != nil {
+		return 0, errors.Wrap(err, "log truncate failed")
+	}
+	if err := s.Index.TruncateEntries(idx); err != nil {
+		return 0, err
+	}
+	if err := s.TimeIndex.truncateTo(offset); err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&s.next, offset)
+	s.Position = position
+	return offset, nil
+}
+
 // Delete closes the segment and then deletes its log and index files.
 func (s *Segment) Delete() error {
 	if err := s.Close(); err != nil {
@@ -239,6 +403,9 @@ func (s *Segment) Delete() error {
 	if err := os.Remove(s.Index.Name()); err != nil {
 		return err
 	}
+	if err := os.Remove(s.TimeIndex.Name()); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -280,3 +447,7 @@ func (s *Segment) logPath() string {
 func (s *Segment) indexPath() string {
 	return filepath.Join(s.path, fmt.Sprintf(fileFormat, s.BaseOffset, indexSuffix+s.suffix))
 }
+
+func (s *Segment) timeIndexPath() string {
+	return filepath.Join(s.path, fmt.Sprintf(fileFormat, s.BaseOffset, TimeIndexFileSuffix+s.suffix))
+}
@@ -1,6 +1,7 @@
 package commitlog_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -29,3 +30,11 @@ func TestSegmentScanner(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, msgSets[0], ms)
 }
+
+// The next offset's read atomically, so it's first in the segment to be 64-bit aligned on 32-bit
+// platforms like arm.
+func TestSegmentNextOffsetAlignment(t *testing.T) {
+	field := reflect.TypeOf((*commitlog.Segment)(nil)).Elem().Field(0)
+	require.Equal(t, "next", field.Name)
+	require.Zero(t, field.Offset)
+}
@@ -0,0 +1,192 @@
+package commitlog
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotDir is the directory in a log's that segments copied whole from a leader are
+// downloaded to before they're appended to the log.
+const snapshotDir = "snapshot"
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SealedSegment describes a segment that's no longer appended to, so followers far behind can
+// copy its log whole rather than fetching its messages.
+type SealedSegment struct {
+	BaseOffset int64
+	// NextOffset is the base offset of the segment after it, which is past its last message's
+	// offset if compaction removed the messages at its end.
+	NextOffset int64
+	// Size is the size of the segment's log.
+	Size int64
+	// Checksum is the CRC-32C of the segment's log.
+	Checksum uint32
+}
+
+// SealedSegments returns the segments before the active segment. Their checksums are worked out
+// the first time they're asked for and kept while the segments aren't written to.
+func (l *CommitLog) SealedSegments() ([]SealedSegment, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	sealed := make([]SealedSegment, 0, len(l.segments)-1)
+	for i, segment := range l.segments[:len(l.segments)-1] {
+		checksum, size, err := segment.sum()
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, SealedSegment{
+			BaseOffset: segment.BaseOffset,
+			NextOffset: l.segments[i+1].BaseOffset,
+			Size:       size,
+			Checksum:   checksum,
+		})
+	}
+	return sealed, nil
+}
+
+// sum returns the checksum and size of the segment's log.
+func (s *Segment) sum() (uint32, int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.checksummed {
+		return s.checksum, s.Position, nil
+	}
+	h := crc32.New(castagnoliTable)
+	if _, err := io.Copy(h, io.NewSectionReader(s.log, 0, s.Position)); err != nil {
+		return 0, 0, errors.Wrap(err, "read file failed")
+	}
+	s.checksum, s.checksummed = h.Sum32(), true
+	return s.checksum, s.Position, nil
+}
+
+// ReadSegment reads the log of the sealed segment with the base offset into p from the position.
+// It returns ErrSegmentNotFound if there isn't such a sealed segment, like if it's been deleted
+// since it was listed.
+func (l *CommitLog) ReadSegment(baseOffset, position int64, p []byte) (int, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, segment := range l.segments[:len(l.segments)-1] {
+		if segment.BaseOffset != baseOffset {
+			continue
+		}
+		n, err := segment.ReadAt(p, position)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+	return 0, ErrSegmentNotFound
+}
+
+// Epochs returns the log's leader epoch cache.
+func (l *CommitLog) Epochs() []EpochEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]EpochEntry(nil), l.epochs...)
+}
+
+// SnapshotDir returns the directory segments copied whole from a leader are downloaded to, at
+// the paths SegmentLogPath gives, before they're appended with AppendSegments. It's removed and
+// made empty so downloads that were cut off don't linger.
+func (l *CommitLog) SnapshotDir() (string, error) {
+	l.mu.RLock()
+	dir := filepath.Join(l.Path, snapshotDir)
+	l.mu.RUnlock()
+	if err := os.RemoveAll(dir); err != nil {
+		return "", errors.Wrap(err, "remove snapshot dir failed")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "mkdir failed")
+	}
+	return dir, nil
+}
+
+// AppendSegments appends the sealed segments downloaded to dir, in order, after checking their
+// logs' sizes and checksums, then removes dir. The first must start at the log's newest offset,
+// or past it when the log's messages are all before the leader's earliest and so are deleted.
+// The log's active segment is sealed, or dropped if it's empty, and a new one starts at the last
+// segment's next offset. The segments' indexes are built from their logs.
+func (l *CommitLog) AppendSegments(dir string, sealed []SealedSegment) error {
+	if len(sealed) == 0 {
+		return os.RemoveAll(dir)
+	}
+	for _, s := range sealed {
+		if err := checkSealedSegment(dir, s); err != nil {
+			return err
+		}
+	}
+
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	newest := l.NewestOffset()
+	if sealed[0].BaseOffset < newest {
+		return errors.Errorf("segments start at offset %d, before the log's newest offset %d", sealed[0].BaseOffset, newest)
+	}
+	segments := l.segments
+	active := segments[len(segments)-1]
+	switch {
+	case sealed[0].BaseOffset > newest:
+		for _, segment := range segments {
+			if err := segment.Delete(); err != nil {
+				return err
+			}
+		}
+		segments = nil
+	case active.Size() == 0:
+		if err := active.Delete(); err != nil {
+			return err
+		}
+		segments = segments[:len(segments)-1]
+	default:
+		if err := l.sync(active); err != nil {
+			return err
+		}
+	}
+	for _, s := range sealed {
+		if err := os.Rename(SegmentLogPath(dir, s.BaseOffset), SegmentLogPath(l.Path, s.BaseOffset)); err != nil {
+			return errors.Wrap(err, "rename segment failed")
+		}
+		segment, err := NewSegment(l.Path, s.BaseOffset, l.MaxSegmentBytes)
+		if err != nil {
+			return err
+		}
+		segment.checksum, segment.checksummed = s.Checksum, true
+		segments = append(segments, segment)
+	}
+	active, err := NewSegment(l.Path, sealed[len(sealed)-1].NextOffset, l.MaxSegmentBytes)
+	if err != nil {
+		return err
+	}
+	segments = append(segments, active)
+	if err := l.filterKeys(segments); err != nil {
+		return err
+	}
+	l.segments = segments
+	l.vActiveSegment.Store(active)
+	return os.RemoveAll(dir)
+}
+
+// checkSealedSegment checks the size and checksum of the downloaded segment's log.
+func checkSealedSegment(dir string, s SealedSegment) error {
+	f, err := os.Open(SegmentLogPath(dir, s.BaseOffset))
+	if err != nil {
+		return errors.Wrap(err, "open file failed")
+	}
+	defer f.Close()
+	h := crc32.New(castagnoliTable)
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return errors.Wrap(err, "read file failed")
+	}
+	if n != s.Size || h.Sum32() != s.Checksum {
+		return errors.Errorf("segment %d is %d bytes with checksum %08x, expected %d bytes with checksum %08x", s.BaseOffset, n, h.Sum32(), s.Size, s.Checksum)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin
+// +build !darwin
+
+package commitlog
+
+import "os"
+
+// syncFile commits the file's writes to disk.
+func syncFile(f *os.File) error {
+	return f.Sync()
+}
@@ -0,0 +1,16 @@
+package commitlog
+
+import (
+	"os"
+	"syscall"
+)
+
+// syncFile commits the file's writes to disk. fsync on macOS only hands them to the drive, which
+// can keep them in its cache, so the drive's asked to flush them with F_FULLFSYNC. Filesystems
+// that don't support it, like network ones, fall back to fsync.
+func syncFile(f *os.File) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_FULLFSYNC, 0); errno == 0 {
+		return nil
+	}
+	return f.Sync()
+}
@@ -0,0 +1,112 @@
+package commitlog
+
+import (
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	timestampWidth = 8
+	timeEntryWidth = timestampWidth + offsetWidth
+)
+
+// TimeIndex maps a segment's timestamps to the offsets of its entries so offsets can be looked
+// up by time. An entry's only added for a message set whose timestamp is later than all those
+// before it in the segment, so both the timestamps and the offsets of its entries increase. Like
+// the segment's offset index it's rebuilt from the log when the segment's opened.
+type TimeIndex struct {
+	file       *os.File
+	baseOffset int64
+
+	mu      sync.RWMutex
+	entries []TimeEntry
+}
+
+// TimeEntry is the offset of the first message set of a segment with the timestamp.
+type TimeEntry struct {
+	Timestamp int64
+	Offset    int64
+}
+
+func newTimeIndex(path string, baseOffset int64) (*TimeIndex, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file failed")
+	}
+	return &TimeIndex{file: file, baseOffset: baseOffset}, nil
+}
+
+// maybeWriteEntry adds an entry for the message set at the offset if its timestamp's later than
+// the index's latest. Message sets without timestamps, of v0 messages, have timestamp -1.
+func (idx *TimeIndex) maybeWriteEntry(timestamp, offset int64) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if timestamp < 0 || (len(idx.entries) > 0 && timestamp <= idx.entries[len(idx.entries)-1].Timestamp) {
+		return nil
+	}
+	b := make([]byte, timeEntryWidth)
+	Encoding.PutUint64(b, uint64(timestamp))
+	Encoding.PutUint32(b[timestampWidth:], uint32(offset-idx.baseOffset))
+	if _, err := idx.file.WriteAt(b, int64(len(idx.entries)*timeEntryWidth)); err != nil {
+		return errors.Wrap(err, "time index write failed")
+	}
+	idx.entries = append(idx.entries, TimeEntry{Timestamp: timestamp, Offset: offset})
+	return nil
+}
+
+// Lookup returns the entry of the first message set whose timestamp is at least the given one,
+// or false if the segment has none.
+func (idx *TimeIndex) Lookup(timestamp int64) (TimeEntry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].Timestamp >= timestamp
+	})
+	if i == len(idx.entries) {
+		return TimeEntry{}, false
+	}
+	return idx.entries[i], true
+}
+
+// MaxTimestamp returns the latest timestamp of the segment's message sets, or -1 if none have
+// timestamps.
+func (idx *TimeIndex) MaxTimestamp() int64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if len(idx.entries) == 0 {
+		return -1
+	}
+	return idx.entries[len(idx.entries)-1].Timestamp
+}
+
+// truncateTo removes the entries from offset on.
+func (idx *TimeIndex) truncateTo(offset int64) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	n := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].Offset >= offset
+	})
+	if err := idx.file.Truncate(int64(n * timeEntryWidth)); err != nil {
+		return errors.Wrap(err, "time index truncate failed")
+	}
+	idx.entries = idx.entries[:n]
+	return nil
+}
+
+func (idx *TimeIndex) Sync() error {
+	if err := syncFile(idx.file); err != nil {
+		return errors.Wrap(err, "file sync failed")
+	}
+	return nil
+}
+
+func (idx *TimeIndex) Close() error {
+	return idx.file.Close()
+}
+
+func (idx *TimeIndex) Name() string {
+	return idx.file.Name()
+}
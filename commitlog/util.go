@@ -9,7 +9,7 @@ import (
 func findSegment(segments []*Segment, offset int64) (*Segment, int) {
 	n := len(segments)
 	idx := sort.Search(n, func(i int) bool {
-		return segments[i].NextOffset > offset
+		return segments[i].NextOffset() > offset
 	})
 	if idx == n {
 		return nil, idx
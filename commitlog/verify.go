@@ -0,0 +1,161 @@
+package commitlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SegmentProblem is something wrong with a segment's files found by Verify.
+type SegmentProblem struct {
+	// Path is the path of the file with the problem.
+	Path   string
+	Detail string
+	// Recoverable is whether the broker fixes the problem itself when it opens the log, like an
+	// index that doesn't match its log, which is rebuilt from it.
+	Recoverable bool
+}
+
+func (p SegmentProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Detail)
+}
+
+// Verify checks the segments of the log in the dir without opening it, which would rebuild
+// their indexes, so it can be run against a stopped broker's logs. It returns how many segments
+// it checked and what's wrong with them: logs ending with a partial message set, like from a
+// crash mid-write, which later appends would be written after, message sets with offsets before
+// their segment's base offset, and indexes that don't match their logs.
+func Verify(dir string) (int, []SegmentProblem, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "read dir failed")
+	}
+	var baseOffsets []int64
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), LogFileSuffix) {
+			continue
+		}
+		baseOffset, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), LogFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		baseOffsets = append(baseOffsets, baseOffset)
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool { return baseOffsets[i] < baseOffsets[j] })
+	var problems []SegmentProblem
+	for _, baseOffset := range baseOffsets {
+		p, err := verifySegment(dir, baseOffset)
+		if err != nil {
+			return 0, nil, err
+		}
+		problems = append(problems, p...)
+	}
+	return len(baseOffsets), problems, nil
+}
+
+// verifySegment reads the segment's log, working out its index entries like BuildIndex does,
+// and compares them with its index.
+func verifySegment(dir string, baseOffset int64) ([]SegmentProblem, error) {
+	logPath := SegmentLogPath(dir, baseOffset)
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file failed")
+	}
+	defer f.Close()
+
+	var problems []SegmentProblem
+	var entries []Entry
+	r := bufio.NewReader(f)
+	header := make([]byte, msgSetHeaderLen)
+	nextOffset := baseOffset
+	position := int64(0)
+	for {
+		n, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			break
+		}
+		var size int64
+		if err == nil {
+			size = int64(Encoding.Uint32(header[sizePos:]))
+			ms := make(MessageSet, msgSetHeaderLen+size)
+			copy(ms, header)
+			var m int
+			m, err = io.ReadFull(r, ms[msgSetHeaderLen:])
+			n += m
+			if err == nil {
+				offset := ms.Offset()
+				if offset < baseOffset {
+					problems = append(problems, SegmentProblem{
+						Path:   logPath,
+						Detail: fmt.Sprintf("message set at position %d has offset %d, before the segment's base offset", position, offset),
+					})
+				}
+				if offset < nextOffset {
+					offset = nextOffset
+				}
+				entries = append(entries, Entry{Offset: offset, Position: position})
+				nextOffset = offset + ms.OffsetCount()
+				position += int64(n)
+				continue
+			}
+		}
+		if err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, errors.Wrap(err, "read file failed")
+		}
+		problems = append(problems, SegmentProblem{
+			Path:   logPath,
+			Detail: fmt.Sprintf("partial message set at position %d, %d bytes past the last whole one", position, n),
+		})
+		break
+	}
+
+	return append(problems, verifyIndex(filepath.Join(dir, fmt.Sprintf(fileFormat, baseOffset, indexSuffix)), baseOffset, entries)...), nil
+}
+
+// verifyIndex compares the index with the entries worked out from its log. The index is
+// preallocated while the segment's open, so the zeroed entries past the log's are fine.
+func verifyIndex(path string, baseOffset int64, entries []Entry) []SegmentProblem {
+	problem := func(format string, args ...interface{}) []SegmentProblem {
+		return []SegmentProblem{{Path: path, Detail: fmt.Sprintf(format, args...), Recoverable: true}}
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if len(entries) == 0 {
+			return nil
+		}
+		return problem("index is missing")
+	}
+	if err != nil {
+		return problem("reading index failed: %v", err)
+	}
+	if len(b)%entryWidth != 0 {
+		return problem("index is %d bytes, which isn't a whole number of entries", len(b))
+	}
+	if len(b)/entryWidth < len(entries) {
+		return problem("index has %d entries, its log has %d message sets", len(b)/entryWidth, len(entries))
+	}
+	for i, want := range entries {
+		var got Entry
+		relEntry{
+			Offset:   int32(Encoding.Uint32(b[i*entryWidth+offsetOffset:])),
+			Position: int32(Encoding.Uint32(b[i*entryWidth+positionOffset:])),
+		}.fill(&got, baseOffset)
+		if got != want {
+			return problem("index entry %d is offset %d at position %d, its log has offset %d at position %d", i, got.Offset, got.Position, want.Offset, want.Position)
+		}
+	}
+	for _, c := range b[len(entries)*entryWidth:] {
+		if c != 0 {
+			return problem("index has entries past the end of its log")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package jocko
+
+import (
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// ACLs are stored in the state store so they replicate through raft and are restored with it.
+// They're managed with the admin APIs but aren't enforced, brokers don't authorize requests.
+
+func (b *Broker) handleCreateAcls(ctx *Context, req *protocol.CreateAclsRequest) *protocol.CreateAclsResponse {
+	sp := span(ctx, b.tracer, "create acls")
+	defer sp.Finish()
+	resp := new(protocol.CreateAclsResponse)
+	resp.APIVersion = req.Version()
+	resp.Results = make([]protocol.AclCreationResult, len(req.Creations))
+	isController := b.isController()
+	sp.LogKV("is controller", isController)
+	readOnly := b.readOnly()
+	var acls []structs.Acl
+	// indexes are the indexes of the creations of the valid acls
+	var indexes []int
+	for i, c := range req.Creations {
+		err := protocol.ErrNone
+		switch {
+		case !isController:
+			err = protocol.ErrNotController
+		case readOnly:
+			err = errReadOnly
+		case !validAclCreation(c):
+			err = protocol.ErrInvalidRequest
+		}
+		if err != protocol.ErrNone {
+			resp.Results[i].ErrorCode = err.Code()
+			continue
+		}
+		acls = append(acls, structs.Acl{
+			ResourceType:   c.ResourceType,
+			ResourceName:   c.ResourceName,
+			PatternType:    c.PatternType,
+			Principal:      c.Principal,
+			Host:           c.Host,
+			Operation:      c.Operation,
+			PermissionType: c.PermissionType,
+		})
+		indexes = append(indexes, i)
+	}
+	if len(acls) == 0 {
+		return resp
+	}
+	res, err := b.raftApply(structs.CreateAclsRequestType, structs.CreateAclsRequest{Acls: acls})
+	if err == nil {
+		err, _ = res.(error)
+	}
+	if err != nil {
+		code := protocol.ErrUnknown.WithErr(err).Code()
+		for _, i := range indexes {
+			resp.Results[i].ErrorCode = code
+		}
+		return resp
+	}
+	b.recordConfigChange(ctx, structs.AclsChange, "", structs.ConfigChange{Operation: "CreateAcls", AclsCreated: acls})
+	return resp
+}
+
+func (b *Broker) handleDeleteAcls(ctx *Context, req *protocol.DeleteAclsRequest) *protocol.DeleteAclsResponse {
+	sp := span(ctx, b.tracer, "delete acls")
+	defer sp.Finish()
+	resp := new(protocol.DeleteAclsResponse)
+	resp.APIVersion = req.Version()
+	resp.FilterResults = make([]protocol.DeleteAclsFilterResult, len(req.Filters))
+	isController := b.isController()
+	sp.LogKV("is controller", isController)
+	readOnly := b.readOnly()
+	var filters []structs.AclFilter
+	// indexes are the indexes of the request's valid filters
+	var indexes []int
+	for i, f := range req.Filters {
+		err := protocol.ErrNone
+		switch {
+		case !isController:
+			err = protocol.ErrNotController
+		case readOnly:
+			err = errReadOnly
+		case !validAclFilter(f):
+			err = protocol.ErrInvalidRequest
+		}
+		if err != protocol.ErrNone {
+			resp.FilterResults[i].ErrorCode = err.Code()
+			continue
+		}
+		filters = append(filters, aclFilter(f))
+		indexes = append(indexes, i)
+	}
+	if len(filters) == 0 {
+		return resp
+	}
+	res, err := b.raftApply(structs.DeleteAclsRequestType, structs.DeleteAclsRequest{Filters: filters})
+	if err == nil {
+		err, _ = res.(error)
+	}
+	if err != nil {
+		code := protocol.ErrUnknown.WithErr(err).Code()
+		for _, i := range indexes {
+			resp.FilterResults[i].ErrorCode = code
+		}
+		return resp
+	}
+	deleted := res.([][]*structs.Acl)
+	var deletedAcls []structs.Acl
+	for j, i := range indexes {
+		for _, acl := range deleted[j] {
+			deletedAcls = append(deletedAcls, *acl)
+			resp.FilterResults[i].MatchingAcls = append(resp.FilterResults[i].MatchingAcls, protocol.DeleteAclsMatchingAcl{
+				ResourceType:   acl.ResourceType,
+				ResourceName:   acl.ResourceName,
+				PatternType:    acl.PatternType,
+				Principal:      acl.Principal,
+				Host:           acl.Host,
+				Operation:      acl.Operation,
+				PermissionType: acl.PermissionType,
+			})
+		}
+	}
+	if len(deletedAcls) > 0 {
+		b.recordConfigChange(ctx, structs.AclsChange, "", structs.ConfigChange{Operation: "DeleteAcls", AclsDeleted: deletedAcls})
+	}
+	return resp
+}
+
+func (b *Broker) handleDescribeAcls(ctx *Context, req *protocol.DescribeAclsRequest) *protocol.DescribeAclsResponse {
+	sp := span(ctx, b.tracer, "describe acls")
+	defer sp.Finish()
+	resp := new(protocol.DescribeAclsResponse)
+	resp.APIVersion = req.Version()
+	if !validAclFilter(req.Filter) {
+		resp.ErrorCode = protocol.ErrInvalidRequest.Code()
+		return resp
+	}
+	_, acls, err := b.fsm.State().GetAcls(aclFilter(req.Filter))
+	if err != nil {
+		resp.ErrorCode = protocol.ErrUnknown.WithErr(err).Code()
+		return resp
+	}
+	// acls are grouped by the resource patterns they apply to
+	type resourceKey struct {
+		resourceType int8
+		name         string
+		patternType  int8
+	}
+	resources := make(map[resourceKey]int)
+	for _, acl := range acls {
+		key := resourceKey{acl.ResourceType, acl.ResourceName, acl.PatternType}
+		i, ok := resources[key]
+		if !ok {
+			i = len(resp.Resources)
+			resources[key] = i
+			resp.Resources = append(resp.Resources, protocol.AclResource{
+				ResourceType: acl.ResourceType,
+				ResourceName: acl.ResourceName,
+				PatternType:  acl.PatternType,
+			})
+		}
+		resp.Resources[i].Acls = append(resp.Resources[i].Acls, protocol.AclDescription{
+			Principal:      acl.Principal,
+			Host:           acl.Host,
+			Operation:      acl.Operation,
+			PermissionType: acl.PermissionType,
+		})
+	}
+	return resp
+}
+
+// validAclCreation returns whether the ACL can be created: its enums must be known and specific
+// rather than any, and its resource pattern literal or prefixed.
+func validAclCreation(c protocol.AclCreation) bool {
+	return c.ResourceType > protocol.ResourceTypeAny && c.ResourceType <= protocol.ResourceTypeDelegationToken &&
+		(c.PatternType == protocol.PatternTypeLiteral || c.PatternType == protocol.PatternTypePrefixed) &&
+		c.Operation > protocol.AclOperationAny && c.Operation <= protocol.AclOperationIdempotentWrite &&
+		c.PermissionType > protocol.AclPermissionAny && c.PermissionType <= protocol.AclPermissionAllow &&
+		c.ResourceName != "" && c.Principal != "" && c.Host != ""
+}
+
+// validAclFilter returns whether the filter's enums are known, they can be any.
+func validAclFilter(f protocol.AclFilter) bool {
+	return f.ResourceType > protocol.ResourceTypeUnknown && f.ResourceType <= protocol.ResourceTypeDelegationToken &&
+		f.PatternType > protocol.PatternTypeUnknown && f.PatternType <= protocol.PatternTypePrefixed &&
+		f.Operation > protocol.AclOperationUnknown && f.Operation <= protocol.AclOperationIdempotentWrite &&
+		f.PermissionType > protocol.AclPermissionUnknown && f.PermissionType <= protocol.AclPermissionAllow
+}
+
+func aclFilter(f protocol.AclFilter) structs.AclFilter {
+	return structs.AclFilter{
+		ResourceType:   f.ResourceType,
+		ResourceName:   f.ResourceName,
+		PatternType:    f.PatternType,
+		Principal:      f.Principal,
+		Host:           f.Host,
+		Operation:      f.Operation,
+		PermissionType: f.PermissionType,
+	}
+}
@@ -0,0 +1,85 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_Acls(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	literal := protocol.AclCreation{
+		ResourceType:   protocol.ResourceTypeTopic,
+		ResourceName:   "the-topic",
+		PatternType:    protocol.PatternTypeLiteral,
+		Principal:      "User:alice",
+		Host:           "*",
+		Operation:      protocol.AclOperationRead,
+		PermissionType: protocol.AclPermissionAllow,
+	}
+	prefixed := protocol.AclCreation{
+		ResourceType:   protocol.ResourceTypeTopic,
+		ResourceName:   "the-",
+		PatternType:    protocol.PatternTypePrefixed,
+		Principal:      "User:bob",
+		Host:           "*",
+		Operation:      protocol.AclOperationWrite,
+		PermissionType: protocol.AclPermissionDeny,
+	}
+	invalid := literal
+	invalid.Operation = protocol.AclOperationAny
+	create := b.handleCreateAcls(ctx, &protocol.CreateAclsRequest{APIVersion: 1, Creations: []protocol.AclCreation{literal, prefixed, invalid}})
+	require.Equal(t, []protocol.AclCreationResult{
+		{ErrorCode: protocol.ErrNone.Code()},
+		{ErrorCode: protocol.ErrNone.Code()},
+		{ErrorCode: protocol.ErrInvalidRequest.Code()},
+	}, create.Results)
+
+	name := "the-topic"
+	describe := b.handleDescribeAcls(ctx, &protocol.DescribeAclsRequest{APIVersion: 1, Filter: protocol.AclFilter{
+		ResourceType:   protocol.ResourceTypeTopic,
+		ResourceName:   &name,
+		PatternType:    protocol.PatternTypeMatch,
+		Operation:      protocol.AclOperationAny,
+		PermissionType: protocol.AclPermissionAny,
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), describe.ErrorCode)
+	require.Equal(t, []protocol.AclResource{
+		{
+			ResourceType: protocol.ResourceTypeTopic,
+			ResourceName: "the-topic",
+			PatternType:  protocol.PatternTypeLiteral,
+			Acls:         []protocol.AclDescription{{Principal: "User:alice", Host: "*", Operation: protocol.AclOperationRead, PermissionType: protocol.AclPermissionAllow}},
+		},
+		{
+			ResourceType: protocol.ResourceTypeTopic,
+			ResourceName: "the-",
+			PatternType:  protocol.PatternTypePrefixed,
+			Acls:         []protocol.AclDescription{{Principal: "User:bob", Host: "*", Operation: protocol.AclOperationWrite, PermissionType: protocol.AclPermissionDeny}},
+		},
+	}, describe.Resources)
+
+	principal := "User:bob"
+	del := b.handleDeleteAcls(ctx, &protocol.DeleteAclsRequest{APIVersion: 1, Filters: []protocol.AclFilter{
+		{ResourceType: protocol.ResourceTypeAny, PatternType: protocol.PatternTypeAny, Principal: &principal, Operation: protocol.AclOperationAny, PermissionType: protocol.AclPermissionAny},
+		{ResourceType: protocol.ResourceTypeUnknown, PatternType: protocol.PatternTypeAny, Operation: protocol.AclOperationAny, PermissionType: protocol.AclPermissionAny},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), del.FilterResults[0].ErrorCode)
+	require.Equal(t, 1, len(del.FilterResults[0].MatchingAcls))
+	require.Equal(t, "the-", del.FilterResults[0].MatchingAcls[0].ResourceName)
+	require.Equal(t, protocol.ErrInvalidRequest.Code(), del.FilterResults[1].ErrorCode)
+
+	describe = b.handleDescribeAcls(ctx, &protocol.DescribeAclsRequest{APIVersion: 1, Filter: protocol.AclFilter{
+		ResourceType:   protocol.ResourceTypeAny,
+		PatternType:    protocol.PatternTypeAny,
+		Operation:      protocol.AclOperationAny,
+		PermissionType: protocol.AclPermissionAny,
+	}})
+	require.Equal(t, 1, len(describe.Resources))
+	require.Equal(t, "the-topic", describe.Resources[0].ResourceName)
+}
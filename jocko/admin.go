@@ -0,0 +1,344 @@
+package jocko
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// AdminHandler returns the handler of the broker's admin HTTP API. It's for operating the
+// broker itself, like inspecting and clearing the state it keeps about its partitions, things
+// the Kafka protocol has no requests for.
+func (b *Broker) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/producers", b.adminProducers)
+	mux.HandleFunc("/v1/consistency", b.adminConsistency)
+	mux.HandleFunc("/v1/read-only", b.adminReadOnly)
+	mux.HandleFunc("/v1/shadow", b.adminShadow)
+	mux.HandleFunc("/v1/keys", b.adminKeys)
+	mux.HandleFunc("/v1/groups/rebalances", b.adminGroupRebalances)
+	mux.HandleFunc("/v1/groups/lag", b.adminGroupLag)
+	mux.HandleFunc("/v1/brokers", b.adminBrokers)
+	mux.HandleFunc("/v1/brokers/lifecycle", b.adminBrokerLifecycles)
+	mux.HandleFunc("/v1/topics", b.adminTopics)
+	mux.HandleFunc("/v1/topics/deletions", b.adminTopicDeletions)
+	mux.HandleFunc("/v1/raft", b.adminRaft)
+	mux.HandleFunc("/v1/configs/history", b.adminConfigHistory)
+	mux.HandleFunc("/v1/clock", b.adminClock)
+	mux.HandleFunc("/v1/quotas", b.adminQuotas)
+	mux.HandleFunc("/v1/transactions", b.adminTransactions)
+	return mux
+}
+
+// adminProducers describes the idempotent producers of a partition hosted by this broker on GET
+// and expires a producer's state on DELETE.
+//
+//	GET /v1/producers?topic=<topic>&partition=<partition>
+//	DELETE /v1/producers?topic=<topic>&partition=<partition>&producer_id=<producer id>
+func (b *Broker) adminProducers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	topic := q.Get("topic")
+	partition, err := strconv.ParseInt(q.Get("partition"), 10, 32)
+	if topic == "" || err != nil {
+		http.Error(w, "topic and partition are required", http.StatusBadRequest)
+		return
+	}
+	if replica, err := b.replicaLookup.Replica(topic, int32(partition)); err != nil || replica == nil {
+		http.Error(w, "partition isn't hosted by this broker", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, struct {
+			Topic     string          `json:"topic"`
+			Partition int32           `json:"partition"`
+			Producers []producerState `json:"producers"`
+		}{topic, int32(partition), b.producers.describe(topic, int32(partition))})
+	case http.MethodDelete:
+		producerID, err := strconv.ParseInt(q.Get("producer_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "producer_id is required", http.StatusBadRequest)
+			return
+		}
+		if !b.producers.expire(topic, int32(partition), producerID) {
+			http.Error(w, "producer has no state in the partition", http.StatusNotFound)
+			return
+		}
+		b.logger.Info("expired producer state", log.String("topic", topic), log.Int64("partition", partition), log.Int64("producer id", producerID))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type adminClockResponse struct {
+	Time time.Time `json:"time"`
+}
+
+// adminClock returns the broker's time, for comparing brokers' clocks like jocko doctor does.
+//
+//	GET /v1/clock
+func (b *Broker) adminClock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminJSON(w, adminClockResponse{Time: time.Now()})
+}
+
+// adminShadow describes the shadowing of the partitions produced to on this broker, if it's
+// dual-writing to an external cluster.
+//
+//	GET /v1/shadow
+func (b *Broker) adminShadow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if b.shadow == nil {
+		http.Error(w, "broker isn't shadowing a cluster", http.StatusNotFound)
+		return
+	}
+	writeAdminJSON(w, struct {
+		Partitions []ShadowPartitionStatus `json:"partitions"`
+	}{b.shadow.Status()})
+}
+
+// adminKeys returns the latest record with the key in a partition of a compacted topic that this
+// broker leads, with its key, value and header values base64 encoded.
+//
+//	GET /v1/keys?topic=<topic>&partition=<partition>&key=<key>
+func (b *Broker) adminKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	topic := q.Get("topic")
+	partition, err := strconv.ParseInt(q.Get("partition"), 10, 32)
+	if topic == "" || err != nil {
+		http.Error(w, "topic and partition are required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := q["key"]; !ok {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+	record, perr := b.lookupKey(topic, int32(partition), []byte(q.Get("key")))
+	switch perr.Code() {
+	case protocol.ErrNone.Code():
+	case protocol.ErrUnknownTopicOrPartition.Code():
+		http.Error(w, "partition isn't hosted by this broker", http.StatusNotFound)
+		return
+	case protocol.ErrNotLeaderForPartition.Code(), protocol.ErrReplicaNotAvailable.Code():
+		http.Error(w, "broker isn't the partition's leader", http.StatusServiceUnavailable)
+		return
+	case protocol.ErrInvalidRequest.Code():
+		http.Error(w, perr.Error(), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, perr.Error(), http.StatusInternalServerError)
+		return
+	}
+	if record == nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	writeAdminJSON(w, struct {
+		Topic     string `json:"topic"`
+		Partition int32  `json:"partition"`
+		*keyRecord
+	}{topic, int32(partition), record})
+}
+
+// adminGroupRebalances describes the last rebalances of a group this broker coordinates, most
+// recent first: why each started, how long it took, the members that joined and left, and how
+// their assignments changed.
+//
+//	GET /v1/groups/rebalances?group=<group>
+func (b *Broker) adminGroupRebalances(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		http.Error(w, "group is required", http.StatusBadRequest)
+		return
+	}
+	_, g, err := b.fsm.State().GetGroup(group)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if g == nil {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	if b.groupCoordinator(group, g) != protocol.ErrNone {
+		http.Error(w, "broker isn't the group's coordinator", http.StatusServiceUnavailable)
+		return
+	}
+	writeAdminJSON(w, struct {
+		Group      string           `json:"group"`
+		Rebalances []groupRebalance `json:"rebalances"`
+	}{group, b.rebalances.describe(group)})
+}
+
+// adminGroupLag returns the lag of the groups, or of every group if none are given, on the
+// partitions this broker leads. Summing what every broker returns gives the groups' whole lag.
+//
+//	GET /v1/groups/lag[?group=<group>...]
+func (b *Broker) adminGroupLag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	lags, err := b.groupLags(r.URL.Query()["group"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, struct {
+		Groups []groupLag `json:"groups"`
+	}{lags})
+}
+
+// AdminHandler returns the handler of the server's admin HTTP API, for inspecting and closing
+// the server's client conns.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/connections", s.adminConnections)
+	mux.HandleFunc("/v1/connections/drain", s.adminDrainConnections)
+	return mux
+}
+
+// adminConnections lists the open conns with their stats on GET and closes a conn on DELETE.
+//
+//	GET /v1/connections
+//	DELETE /v1/connections?id=<connection id>
+func (s *Server) adminConnections(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, struct {
+			Connections []connectionStats `json:"connections"`
+		}{s.conns.list()})
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		if !s.conns.kill(id) {
+			http.Error(w, "connection isn't open", http.StatusNotFound)
+			return
+		}
+		s.logger.Info("killed connection", log.Uint64("connection id", id))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminDrainConnections closes the client conns over the drain period, like when rotating the
+// load balancers or certificates in front of the broker, leaving the conns from other brokers
+// open. The broker has one listener for clients and brokers, so the conns are told apart by
+// whether they've sent inter-broker requests. Conns are closed once they've responded to their
+// pending requests, and period defaults to closing them all right away.
+//
+//	POST /v1/connections/drain[?period=<duration>]
+func (s *Server) adminDrainConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var period time.Duration
+	if p := r.URL.Query().Get("period"); p != "" {
+		var err error
+		if period, err = time.ParseDuration(p); err != nil || period < 0 {
+			http.Error(w, "period must be a duration", http.StatusBadRequest)
+			return
+		}
+	}
+	n := s.conns.drain(period)
+	s.logger.Info("draining connections", log.Int("connections", n), log.Duration("period", period))
+	writeAdminJSON(w, struct {
+		Connections int `json:"connections"`
+	}{n})
+}
+
+// adminConsistency checks the partition logs in the broker's log dirs against the replicas it's
+// assigned on GET, and removes the orphaned logs it finds too on POST.
+//
+//	GET /v1/consistency
+//	POST /v1/consistency
+func (b *Broker) adminConsistency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := b.checkConsistency()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		for i := range report.Orphaned {
+			b.removeOrphanedLog(&report.Orphaned[i])
+		}
+	}
+	writeAdminJSON(w, report)
+}
+
+// adminReadOnly describes whether the cluster is in read-only mode on GET and turns it on or off
+// on PUT. It's set through raft so PUTs must go to the controller.
+//
+//	GET /v1/read-only
+//	PUT /v1/read-only?enabled=<true|false>
+func (b *Broker) adminReadOnly(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(w, "enabled is required", http.StatusBadRequest)
+			return
+		}
+		if !b.isController() {
+			http.Error(w, "broker isn't the controller", http.StatusServiceUnavailable)
+			return
+		}
+		if err := b.setReadOnly(enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b.logger.Info("set read-only mode", log.Bool("enabled", enabled))
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminJSON(w, struct {
+		ReadOnly bool `json:"read_only"`
+	}{b.readOnly()})
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,965 @@
+package jocko
+
+import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/testutil/retry"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_AdminProducers(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	for _, batch := range [][]byte{testRecordBatch(7, 1, 0, 2), testRecordBatch(7, 1, 3, 0), testRecordBatch(9, 0, 0, 0)} {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: batch}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	describe := func() []producerState {
+		resp, err := http.Get(srv.URL + "/v1/producers?topic=the-topic&partition=0")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body struct {
+			Producers []producerState `json:"producers"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.Producers
+	}
+	producers := describe()
+	require.Equal(t, 2, len(producers))
+	require.Equal(t, int64(7), producers[0].ProducerID)
+	require.Equal(t, int16(1), producers[0].ProducerEpoch)
+	require.Equal(t, int32(3), producers[0].LastSequence)
+	require.Equal(t, int64(3), producers[0].LastOffset)
+	require.Equal(t, int64(9), producers[1].ProducerID)
+
+	expire := func(query string) int {
+		req, err := http.NewRequest(http.MethodDelete, srv.URL+"/v1/producers?"+query, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusNoContent, expire("topic=the-topic&partition=0&producer_id=7"))
+	require.Equal(t, http.StatusNotFound, expire("topic=the-topic&partition=0&producer_id=7"))
+	require.Equal(t, http.StatusNotFound, expire("topic=the-topic&partition=1&producer_id=9"))
+	require.Equal(t, http.StatusBadRequest, expire("topic=the-topic&partition=0"))
+	producers = describe()
+	require.Equal(t, 1, len(producers))
+	require.Equal(t, int64(9), producers[0].ProducerID)
+}
+
+func TestBroker_AdminConsistency(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	// a topic assigned to the broker without it having opened a log, and a log of a topic that
+	// doesn't exist.
+	_, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{
+		Topic: structs.Topic{Topic: "no-log", Partitions: map[int32][]int32{0: {b.config.ID}}},
+	})
+	require.NoError(t, err)
+	orphan := filepath.Join(b.logDirs()[0], "ghost-0")
+	require.NoError(t, os.MkdirAll(orphan, 0755))
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	check := func(method string) consistencyReport {
+		req, err := http.NewRequest(method, srv.URL+"/v1/consistency", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var report consistencyReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return report
+	}
+	report := check(http.MethodGet)
+	require.Equal(t, []orphanedLog{{Path: orphan, Topic: "ghost", Partition: 0}}, report.Orphaned)
+	require.Equal(t, []missingReplica{{Topic: "no-log", Partition: 0}}, report.Missing)
+	_, err = os.Stat(orphan)
+	require.NoError(t, err)
+
+	report = check(http.MethodPost)
+	require.Equal(t, 1, len(report.Orphaned))
+	require.True(t, report.Orphaned[0].Removed)
+	_, err = os.Stat(orphan)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, 0, len(check(http.MethodGet).Orphaned))
+}
+
+func TestBroker_AdminReadOnly(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createTopic := func(topic string) int16 {
+		resp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             topic,
+			NumPartitions:     1,
+			ReplicationFactor: 1,
+		}}})
+		return resp.TopicErrorCodes[0].ErrorCode
+	}
+	produce := func() int16 {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: testRecordBatch(-1, -1, -1, 0)}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	fetch := func() int16 {
+		resp := b.handleFetch(ctx, &protocol.FetchRequest{ReplicaID: -1, Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: 0, MaxBytes: 1000}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	require.Equal(t, protocol.ErrNone.Code(), createTopic("the-topic"))
+	require.Equal(t, protocol.ErrNone.Code(), produce())
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	readOnly := func(method, query string) (int, bool) {
+		req, err := http.NewRequest(method, srv.URL+"/v1/read-only"+query, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body struct {
+			ReadOnly bool `json:"read_only"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		}
+		return resp.StatusCode, body.ReadOnly
+	}
+	code, enabled := readOnly(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, code)
+	require.False(t, enabled)
+	code, _ = readOnly(http.MethodPut, "?enabled=maybe")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// produces and topic changes are rejected while fetches are still served
+	code, enabled = readOnly(http.MethodPut, "?enabled=true")
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, enabled)
+	require.Equal(t, protocol.ErrNotEnoughReplicas.Code(), produce())
+	require.Equal(t, protocol.ErrNotEnoughReplicas.Code(), createTopic("another-topic"))
+	require.Equal(t, protocol.ErrNone.Code(), fetch())
+
+	code, enabled = readOnly(http.MethodPut, "?enabled=false")
+	require.Equal(t, http.StatusOK, code)
+	require.False(t, enabled)
+	require.Equal(t, protocol.ErrNone.Code(), produce())
+	require.Equal(t, protocol.ErrNone.Code(), createTopic("another-topic"))
+}
+
+func TestBroker_AdminKeys(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.KeyBloomFilters = true
+	})
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}, {
+		Topic:             "not-compacted",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	for _, code := range createResp.TopicErrorCodes {
+		require.Equal(t, protocol.ErrNone.Code(), code.ErrorCode)
+	}
+	alterResp := b.handleAlterConfigs(ctx, &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+		{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+			{Name: "cleanup.policy", Value: strPtr("compact")},
+		}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), alterResp.Resources[0].ErrorCode)
+	batch := func(records ...protocol.Record) []byte {
+		b := &protocol.RecordBatch{LastOffsetDelta: int32(len(records) - 1), FirstTimestamp: 1000, ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1}
+		for i := range records {
+			records[i].OffsetDelta = int32(i)
+			records[i].TimestampDelta = int64(i)
+		}
+		b.Records = records
+		return b.Bytes()
+	}
+	for _, recordSet := range [][]byte{
+		batch(protocol.Record{Key: []byte("one"), Value: []byte("first")}, protocol.Record{Key: []byte("two"), Value: []byte("first")}),
+		batch(protocol.Record{Key: []byte("one"), Value: []byte("second"), Headers: []protocol.RecordHeader{{Key: "type", Value: []byte("update")}}}),
+		// a tombstone deletes the key
+		batch(protocol.Record{Key: []byte("two")}),
+	} {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	get := func(query string) *http.Response {
+		resp, err := http.Get(srv.URL + "/v1/keys?" + query)
+		require.NoError(t, err)
+		return resp
+	}
+	resp := get("topic=the-topic&partition=0&key=one")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Topic     string `json:"topic"`
+		Partition int32  `json:"partition"`
+		keyRecord
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, "the-topic", body.Topic)
+	require.Equal(t, int64(2), body.Offset)
+	require.Equal(t, int64(1000), body.Timestamp)
+	require.Equal(t, []byte("one"), body.Key)
+	require.Equal(t, []byte("second"), body.Value)
+	require.Equal(t, []keyRecordHeader{{Key: "type", Value: []byte("update")}}, body.Headers)
+
+	for query, code := range map[string]int{
+		"topic=the-topic&partition=0&key=two":     http.StatusNotFound,
+		"topic=the-topic&partition=0&key=three":   http.StatusNotFound,
+		"topic=the-topic&partition=1&key=one":     http.StatusNotFound,
+		"topic=the-topic&partition=0":             http.StatusBadRequest,
+		"topic=not-compacted&partition=0&key=one": http.StatusBadRequest,
+	} {
+		resp := get(query)
+		resp.Body.Close()
+		require.Equal(t, code, resp.StatusCode, query)
+	}
+}
+
+func TestBroker_AdminGroupRebalances(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	join := func(memberID string) string {
+		resp := b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{
+			GroupID:        "the-group",
+			SessionTimeout: 10000,
+			MemberID:       memberID,
+			ProtocolType:   "consumer",
+			GroupProtocols: []*protocol.GroupProtocol{{ProtocolName: "range"}},
+		})
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+		return resp.MemberID
+	}
+	// assignment encodes a consumer protocol assignment of the topic's partitions
+	assignment := func(partitions ...int32) []byte {
+		b := []byte{0, 0, 0, 0, 0, 1, 0, 9}
+		b = append(b, "the-topic"...)
+		b = append(b, 0, 0, 0, byte(len(partitions)))
+		for _, p := range partitions {
+			b = append(b, 0, 0, 0, byte(p))
+		}
+		return append(b, 0xff, 0xff, 0xff, 0xff)
+	}
+	sync := func(leader string, assignments ...protocol.GroupAssignment) {
+		resp := b.handleSyncGroup(ctx, &protocol.SyncGroupRequest{GroupID: "the-group", MemberID: leader, GroupAssignments: assignments})
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	}
+
+	m1 := join("")
+	sync(m1, protocol.GroupAssignment{MemberID: m1, MemberAssignment: assignment(0, 1)})
+	m2 := join("")
+	sync(m1,
+		protocol.GroupAssignment{MemberID: m1, MemberAssignment: assignment(0)},
+		protocol.GroupAssignment{MemberID: m2, MemberAssignment: assignment(1)},
+	)
+	leaveResp := b.handleLeaveGroup(ctx, &protocol.LeaveGroupRequest{GroupID: "the-group", MemberID: m2})
+	require.Equal(t, protocol.ErrNone.Code(), leaveResp.ErrorCode)
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/v1/groups/rebalances?group=the-group")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Group      string           `json:"group"`
+		Rebalances []groupRebalance `json:"rebalances"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, 3, len(body.Rebalances))
+
+	// the member leaving started a rebalance the remaining member hasn't synced yet
+	running := body.Rebalances[0]
+	require.Equal(t, rebalanceMemberLeft, running.Reason)
+	require.Equal(t, []string{m2}, running.Left)
+	require.True(t, running.CompletedAt.IsZero())
+
+	second := body.Rebalances[1]
+	require.Equal(t, rebalanceMemberJoined, second.Reason)
+	require.Equal(t, []string{m2}, second.Joined)
+	require.False(t, second.CompletedAt.IsZero())
+	changes := map[string]assignmentChange{
+		m1: {Member: m1, Removed: []string{"the-topic-1"}},
+		m2: {Member: m2, Added: []string{"the-topic-1"}},
+	}
+	require.Equal(t, 2, len(second.Assignments))
+	for _, c := range second.Assignments {
+		require.Equal(t, changes[c.Member], c)
+	}
+
+	first := body.Rebalances[2]
+	require.Equal(t, []string{m1}, first.Joined)
+	require.Equal(t, []assignmentChange{{Member: m1, Added: []string{"the-topic-0", "the-topic-1"}}}, first.Assignments)
+
+	for query, code := range map[string]int{
+		"group=unknown-group": http.StatusNotFound,
+		"":                    http.StatusBadRequest,
+	} {
+		resp, err := http.Get(srv.URL + "/v1/groups/rebalances?" + query)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, code, resp.StatusCode, query)
+	}
+}
+
+func TestBroker_AdminGroupLag(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	produce := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: testRecordBatch(-1, -1, -1, 4)}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), produce.Responses[0].PartitionResponses[0].ErrorCode)
+	commit := func(group string, offset int64) {
+		resp := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+			APIVersion:   1,
+			GroupID:      group,
+			GenerationID: -1,
+			Topics: []protocol.OffsetCommitTopicRequest{{
+				Topic: "the-topic",
+				// the group's offset for the partition that doesn't exist is left out
+				Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: offset}, {Partition: 1, Offset: offset}},
+			}},
+		})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+	commit("the-group", 2)
+	commit("caught-up-group", 5)
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	lags := func(query string) []groupLag {
+		resp, err := http.Get(srv.URL + "/v1/groups/lag?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body struct {
+			Groups []groupLag `json:"groups"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.Groups
+	}
+	require.Equal(t, []groupLag{{
+		Group:      "the-group",
+		Lag:        3,
+		Partitions: []partitionLag{{Topic: "the-topic", Partition: 0, CommittedOffset: 2, HighWatermark: 5, Lag: 3}},
+	}}, lags("group=the-group"))
+
+	all := lags("")
+	require.Equal(t, 2, len(all))
+	require.Equal(t, "caught-up-group", all[0].Group)
+	require.Equal(t, int64(0), all[0].Lag)
+	require.Equal(t, "the-group", all[1].Group)
+	require.Empty(t, lags("group=unknown-group"))
+}
+
+func TestBroker_AdminBrokerLifecycles(t *testing.T) {
+	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+		cfg.Version = "1.2.3"
+	}, nil)
+	b1 := s1.broker()
+	defer func() {
+		b1.Shutdown()
+		t1()
+	}()
+	s2, t2 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = false
+		cfg.NonVoter = true
+	}, nil)
+	defer t2()
+	b2 := s2.broker()
+	TestJoin(t, s2, s1)
+
+	// the brokers' first starts with their data dirs
+	require.Equal(t, "", b1.startup.PreviousShutdown)
+	srv := httptest.NewServer(b1.AdminHandler())
+	defer srv.Close()
+	type event struct {
+		Type             string    `json:"type"`
+		Time             time.Time `json:"time"`
+		Version          string    `json:"version"`
+		PreviousShutdown string    `json:"previous_shutdown"`
+	}
+	events := func(broker int32) []event {
+		resp, err := http.Get(srv.URL + "/v1/brokers/lifecycle?broker=" + strconv.Itoa(int(broker)))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body struct {
+			Brokers []struct {
+				Broker int32   `json:"broker"`
+				Events []event `json:"events"`
+			} `json:"brokers"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		if len(body.Brokers) == 0 {
+			return nil
+		}
+		require.Equal(t, broker, body.Brokers[0].Broker)
+		return body.Brokers[0].Events
+	}
+	retry.Run(t, func(r *retry.R) {
+		if len(events(b1.config.ID)) != 1 || len(events(b2.config.ID)) != 1 {
+			r.Fatal("startups not recorded")
+		}
+	})
+	started := events(b1.config.ID)[0]
+	require.Equal(t, structs.BrokerStarted, started.Type)
+	require.True(t, b1.startup.Time.Equal(started.Time))
+	require.Equal(t, "1.2.3", started.Version)
+
+	// the broker shutting down without leaving is seen to fail
+	b2.Shutdown()
+	retry.Run(t, func(r *retry.R) {
+		if len(events(b2.config.ID)) != 2 {
+			r.Fatal("failure not recorded")
+		}
+	})
+	require.Equal(t, structs.BrokerFailed, events(b2.config.ID)[1].Type)
+	// and nothing's recorded again on the later reconciles
+	time.Sleep(3 * b1.config.ReconcileInterval)
+	require.Equal(t, 1, len(events(b1.config.ID)))
+	require.Equal(t, 2, len(events(b2.config.ID)))
+
+	// it shut down cleanly, which it sees when started again with its data dir, while a crash
+	// leaves no marker
+	require.Equal(t, structs.ShutdownClean, b2.previousShutdown())
+	require.Equal(t, structs.ShutdownUnclean, b2.previousShutdown())
+}
+
+func TestBroker_AdminTopicDeletions(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	topics := []string{"topic-0", "topic-1", "topic-2"}
+	for _, topic := range topics {
+		resp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             topic,
+			NumPartitions:     2,
+			ReplicationFactor: 1,
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.TopicErrorCodes[0].ErrorCode)
+	}
+
+	// the topics are queued and the request returns right away
+	resp := b.handleDeleteTopics(ctx, &protocol.DeleteTopicsRequest{Topics: append(topics, "unknown-topic")})
+	for i, topic := range topics {
+		require.Equal(t, topic, resp.TopicErrorCodes[i].Topic)
+		require.Equal(t, protocol.ErrNone.Code(), resp.TopicErrorCodes[i].ErrorCode)
+	}
+	require.Equal(t, protocol.ErrUnknownTopicOrPartition.Code(), resp.TopicErrorCodes[3].ErrorCode)
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	type deletion struct {
+		Topic           string `json:"topic"`
+		State           string `json:"state"`
+		Partitions      int    `json:"partitions"`
+		Replicas        int    `json:"replicas"`
+		ReplicasStopped int    `json:"replicas_stopped"`
+	}
+	deletions := func(query string) (int, []deletion) {
+		resp, err := http.Get(srv.URL + "/v1/topics/deletions" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		var body struct {
+			Deletions []deletion `json:"deletions"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body.Deletions
+	}
+	retry.Run(t, func(r *retry.R) {
+		_, ds := deletions("")
+		if len(ds) != len(topics) {
+			r.Fatalf("%d deletions", len(ds))
+		}
+		for _, d := range ds {
+			if d.State != topicDeletionDeleted {
+				r.Fatalf("topic %s %s", d.Topic, d.State)
+			}
+		}
+	})
+	// most recent first
+	_, ds := deletions("")
+	for i, d := range ds {
+		require.Equal(t, deletion{Topic: topics[len(topics)-1-i], State: topicDeletionDeleted, Partitions: 2, Replicas: 2, ReplicasStopped: 2}, d)
+	}
+	for _, topic := range topics {
+		_, t1, err := b.fsm.State().GetTopic(topic)
+		require.NoError(t, err)
+		require.Nil(t, t1)
+	}
+
+	code, ds := deletions("?topic=topic-1")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 1, len(ds))
+	require.Equal(t, "topic-1", ds[0].Topic)
+	code, _ = deletions("?topic=unknown-topic")
+	require.Equal(t, http.StatusNotFound, code)
+}
+
+func TestBroker_AdminRaft(t *testing.T) {
+	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	b1 := s1.broker()
+	defer func() {
+		b1.Shutdown()
+		t1()
+	}()
+	s2, t2 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = false
+		cfg.NonVoter = true
+	}, nil)
+	b2 := s2.broker()
+	defer func() {
+		b2.Shutdown()
+		t2()
+	}()
+	TestJoin(t, s2, s1)
+
+	srv := httptest.NewServer(b1.AdminHandler())
+	defer srv.Close()
+	type body struct {
+		State         string   `json:"state"`
+		Leader        RaftPeer `json:"leader"`
+		LastContact   string   `json:"last_contact"`
+		LeaderChanges int64    `json:"leader_changes"`
+		Servers       []struct {
+			ID       int32  `json:"id"`
+			Suffrage string `json:"suffrage"`
+			Leader   bool   `json:"leader"`
+			Status   string `json:"status"`
+		} `json:"servers"`
+		Stats map[string]string `json:"stats"`
+	}
+	describe := func() body {
+		resp, err := http.Get(srv.URL + "/v1/raft")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var b body
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&b))
+		return b
+	}
+	retry.Run(t, func(r *retry.R) {
+		if len(describe().Servers) != 2 {
+			r.Fatal("broker not added to the raft configuration")
+		}
+	})
+	raft := describe()
+	require.Equal(t, "Leader", raft.State)
+	require.Equal(t, b1.config.ID, raft.Leader.ID)
+	require.Equal(t, "0s", raft.LastContact)
+	require.True(t, raft.LeaderChanges >= 1)
+	require.NotEmpty(t, raft.Stats["commit_index"])
+	for _, s := range raft.Servers {
+		require.Equal(t, "alive", s.Status)
+		require.Equal(t, s.ID == b1.config.ID, s.Leader)
+		if s.ID == b2.config.ID {
+			require.Equal(t, "Nonvoter", s.Suffrage)
+		} else {
+			require.Equal(t, "Voter", s.Suffrage)
+		}
+	}
+
+	resp, err := http.Post(srv.URL+"/v1/raft", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestServer_AdminConnections(t *testing.T) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	s.EnableSASL(testPlainMechanism())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.Start(ctx))
+	defer teardown()
+	defer s.Shutdown()
+
+	conn, err := Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.APIVersions(&protocol.APIVersionsRequest{})
+	require.NoError(t, err)
+	_, err = conn.SaslHandshake(&protocol.SaslHandshakeRequest{APIVersion: 1, Mechanism: "PLAIN"})
+	require.NoError(t, err)
+	_, err = conn.SaslAuthenticate(&protocol.SaslAuthenticateRequest{AuthBytes: []byte("\x00alice\x00secret")})
+	require.NoError(t, err)
+	_, err = conn.APIVersions(&protocol.APIVersionsRequest{})
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(s.AdminHandler())
+	defer srv.Close()
+	list := func() []connectionStats {
+		resp, err := http.Get(srv.URL + "/v1/connections")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body struct {
+			Connections []connectionStats `json:"connections"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.Connections
+	}
+	conns := list()
+	require.Equal(t, 1, len(conns))
+	require.Equal(t, conn.LocalAddr().String(), conns[0].RemoteAddr)
+	require.Equal(t, "alice", conns[0].Principal)
+	require.Equal(t, map[string]uint64{"ApiVersions": 2, "SaslHandshake": 1, "SaslAuthenticate": 1}, conns[0].Requests)
+	require.True(t, conns[0].BytesIn > 0)
+	require.True(t, conns[0].BytesOut > 0)
+
+	kill := func(query string) int {
+		req, err := http.NewRequest(http.MethodDelete, srv.URL+"/v1/connections?"+query, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusBadRequest, kill(""))
+	require.Equal(t, http.StatusNotFound, kill("id=100"))
+	require.Equal(t, http.StatusNoContent, kill("id="+strconv.FormatUint(conns[0].ID, 10)))
+	_, err = conn.APIVersions(&protocol.APIVersionsRequest{})
+	require.Error(t, err)
+	retry.Run(t, func(r *retry.R) {
+		if n := len(list()); n != 0 {
+			r.Fatalf("%d connections still open", n)
+		}
+	})
+}
+
+func TestServer_AdminDrainConnections(t *testing.T) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.Start(ctx))
+	defer teardown()
+	defer s.Shutdown()
+
+	dial := func(clientID string) *Conn {
+		c, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		conn, err := NewConn(c, clientID)
+		require.NoError(t, err)
+		_, err = conn.APIVersions(&protocol.APIVersionsRequest{})
+		require.NoError(t, err)
+		return conn
+	}
+	clients := []*Conn{dial("client-0"), dial("client-1")}
+	broker := dial(brokerClientIDPrefix + "2")
+	for _, c := range append(clients, broker) {
+		defer c.Close()
+	}
+
+	srv := httptest.NewServer(s.AdminHandler())
+	defer srv.Close()
+	drain := func(query string) (int, int) {
+		resp, err := http.Post(srv.URL+"/v1/connections/drain"+query, "", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body struct {
+			Connections int `json:"connections"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		}
+		return resp.StatusCode, body.Connections
+	}
+	code, _ := drain("?period=soon")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, n := drain("?period=100ms")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, len(clients), n)
+
+	// the clients are disconnected and the broker's conn is left open
+	retry.Run(t, func(r *retry.R) {
+		conns := s.conns.list()
+		if len(conns) != 1 {
+			r.Fatalf("%d connections open", len(conns))
+		}
+		if !conns[0].InterBroker {
+			r.Fatal("broker connection closed")
+		}
+	})
+	for _, c := range clients {
+		_, err := c.APIVersions(&protocol.APIVersionsRequest{})
+		require.Error(t, err)
+	}
+	_, err := broker.APIVersions(&protocol.APIVersionsRequest{})
+	require.NoError(t, err)
+}
+
+// testRecordBatch returns a v2 record batch header, without records, from the producer.
+func testRecordBatch(producerID int64, epoch int16, baseSequence, lastOffsetDelta int32) []byte {
+	b := make([]byte, 61)
+	protocol.Encoding.PutUint32(b[8:], uint32(len(b)-12))
+	b[16] = 2
+	protocol.Encoding.PutUint32(b[23:], uint32(lastOffsetDelta))
+	protocol.Encoding.PutUint64(b[43:], uint64(producerID))
+	protocol.Encoding.PutUint16(b[51:], uint16(epoch))
+	protocol.Encoding.PutUint32(b[53:], uint32(baseSequence))
+	protocol.Encoding.PutUint32(b[17:], crc32.Checksum(b[21:], crc32.MakeTable(crc32.Castagnoli)))
+	return b
+}
+
+func TestBroker_AdminConfigHistory(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: withPrincipal(context.Background(), "User:alice", "")}
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	alter := func(ctx *Context, resourceType structs.ConfigResourceType, name string, entries ...protocol.IncrementalAlterConfigsEntry) {
+		resp := b.handleIncrementalAlterConfigs(ctx, &protocol.IncrementalAlterConfigsRequest{Resources: []protocol.IncrementalAlterConfigsResource{{
+			Type:    int8(resourceType),
+			Name:    name,
+			Entries: entries,
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Resources[0].ErrorCode)
+	}
+	retention, clientIDs := "1000", "the-client"
+	alter(ctx, structs.TopicConfigResource, "the-topic", protocol.IncrementalAlterConfigsEntry{Name: "retention.ms", Operation: protocol.ConfigOperationSet, Value: &retention})
+	// nothing changes so nothing's recorded
+	alter(ctx, structs.TopicConfigResource, "the-topic", protocol.IncrementalAlterConfigsEntry{Name: "retention.ms", Operation: protocol.ConfigOperationSet, Value: &retention})
+	bob := &Context{parent: withPrincipal(context.Background(), "User:bob", "")}
+	alter(bob, structs.BrokerConfigResource, "", protocol.IncrementalAlterConfigsEntry{Name: traceClientIDsConfig, Operation: protocol.ConfigOperationSet, Value: &clientIDs})
+	alter(bob, structs.TopicConfigResource, "the-topic", protocol.IncrementalAlterConfigsEntry{Name: "retention.ms", Operation: protocol.ConfigOperationDelete})
+	acl := protocol.AclCreation{
+		ResourceType:   protocol.ResourceTypeTopic,
+		ResourceName:   "the-topic",
+		PatternType:    protocol.PatternTypeLiteral,
+		Principal:      "User:carol",
+		Host:           "*",
+		Operation:      protocol.AclOperationRead,
+		PermissionType: protocol.AclPermissionAllow,
+	}
+	createAcls := b.handleCreateAcls(ctx, &protocol.CreateAclsRequest{APIVersion: 1, Creations: []protocol.AclCreation{acl}})
+	require.Equal(t, protocol.ErrNone.Code(), createAcls.Results[0].ErrorCode)
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	type entry struct {
+		Name   string  `json:"name"`
+		Before *string `json:"before"`
+		After  *string `json:"after"`
+	}
+	type change struct {
+		Kind        string  `json:"kind"`
+		Resource    string  `json:"resource"`
+		Principal   string  `json:"principal"`
+		Operation   string  `json:"operation"`
+		Entries     []entry `json:"entries"`
+		AclsCreated []struct {
+			ResourceName string `json:"resource_name"`
+			Principal    string `json:"principal"`
+		} `json:"acls_created"`
+	}
+	history := func(query string) []change {
+		resp, err := http.Get(srv.URL + "/v1/configs/history" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body struct {
+			Changes []change `json:"changes"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.Changes
+	}
+	changes := history("")
+	require.Equal(t, 4, len(changes))
+	require.Equal(t, change{Kind: structs.TopicConfigChange, Resource: "the-topic", Principal: "User:alice", Operation: "IncrementalAlterConfigs", Entries: []entry{{Name: "retention.ms", After: &retention}}}, changes[0])
+	require.Equal(t, change{Kind: structs.BrokerConfigChange, Principal: "User:bob", Operation: "IncrementalAlterConfigs", Entries: []entry{{Name: traceClientIDsConfig, After: &clientIDs}}}, changes[1])
+	require.Equal(t, []entry{{Name: "retention.ms", Before: &retention}}, changes[2].Entries)
+	require.Equal(t, structs.AclsChange, changes[3].Kind)
+	require.Equal(t, "CreateAcls", changes[3].Operation)
+	require.Equal(t, "User:carol", changes[3].AclsCreated[0].Principal)
+
+	changes = history("?kind=topic&resource=the-topic")
+	require.Equal(t, 2, len(changes))
+	changes = history("?principal=User:bob")
+	require.Equal(t, 2, len(changes))
+	require.Equal(t, structs.BrokerConfigChange, changes[0].Kind)
+	require.Equal(t, 0, len(history("?kind=broker&resource=1")))
+}
+
+func TestBroker_AdminTransactions(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.TransactionStateNumPartitions = 1
+	})
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	transactionalID := "the-txn"
+	init := b.handleInitProducerID(ctx, &protocol.InitProducerIDRequest{TransactionalID: &transactionalID, TransactionTimeout: time.Minute})
+	require.Equal(t, protocol.ErrNone.Code(), init.ErrorCode)
+	add := b.handleAddPartitionsToTxn(ctx, &protocol.AddPartitionsToTxnRequest{
+		TransactionalID: transactionalID,
+		ProducerID:      init.ProducerID,
+		ProducerEpoch:   init.ProducerEpoch,
+		Topics:          []protocol.AddPartitionsToTxnTopic{{Topic: "the-topic", Partitions: []int32{0}}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), add.Results[0].Partitions[0].ErrorCode)
+	produce := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: testTransactionalBatch(init.ProducerID, init.ProducerEpoch, 0)}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), produce.Responses[0].PartitionResponses[0].ErrorCode)
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	list := func() []transactionMetadata {
+		resp, err := http.Get(srv.URL + "/v1/transactions")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body struct {
+			Transactions []transactionMetadata `json:"transactions"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.Transactions
+	}
+	abort := func(query string) int {
+		req, err := http.NewRequest(http.MethodDelete, srv.URL+"/v1/transactions"+query, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	txns := list()
+	require.Equal(t, 1, len(txns))
+	require.Equal(t, transactionalID, txns[0].TransactionalID)
+	require.Equal(t, protocol.TransactionStateOngoing, txns[0].State)
+	require.Equal(t, []txnPartition{{Topic: "the-topic", Partition: 0}}, txns[0].Partitions)
+
+	require.Equal(t, http.StatusBadRequest, abort(""))
+	require.Equal(t, http.StatusNotFound, abort("?transactional_id=other-txn"))
+	require.Equal(t, http.StatusNoContent, abort("?transactional_id=the-txn"))
+
+	// the abort marker's written and the producer's fenced
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	r, err := replica.Log.NewReader(0, math.MaxInt32)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	batches, err := protocol.ReadRecordBatches(buf)
+	require.NoError(t, err)
+	last := batches[len(batches)-1]
+	require.True(t, last.Control())
+	require.Equal(t, init.ProducerID, last.ProducerID)
+	typ, ok := protocol.ControlRecordType(last.Records[0])
+	require.True(t, ok)
+	require.Equal(t, protocol.ControlRecordAbort, typ)
+	txns = list()
+	require.Equal(t, protocol.TransactionStateCompleteAbort, txns[0].State)
+	require.Equal(t, init.ProducerEpoch+1, txns[0].ProducerEpoch)
+	require.Empty(t, txns[0].Partitions)
+
+	// there's nothing left to abort
+	require.Equal(t, http.StatusConflict, abort("?transactional_id=the-txn"))
+	resp, err := http.Post(srv.URL+"/v1/transactions", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
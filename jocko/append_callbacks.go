@@ -0,0 +1,204 @@
+package jocko
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// AppendFunc is called with a record batch produced to a partition of a topic once it's
+// committed, like for an application embedding jocko to index the topic's records as they're
+// written without consuming them from itself.
+type AppendFunc func(topic string, partition int32, batch *protocol.RecordBatch)
+
+// OnAppend registers fn to be called with the v2 record batches produced to the topic's
+// partitions this broker leads once they're committed, when the partition's high watermark has
+// passed them. That's as soon as they're appended if the partition has no followers in its isr,
+// and otherwise once they've been replicated to them, which can be after the producer's been
+// answered, even with acks=all. A partition's batches are passed from its high watermark as of
+// when this broker started leading it, or from the first batch produced to it after its topic's
+// first callback was registered if that's later. They're passed in offset order on the goroutine
+// of the produce or follower fetch that committed them, so fn should be quick and mustn't change
+// them. The records of batches compressed with codecs that aren't supported aren't decoded. It
+// returns a func removing the callback.
+func (b *Broker) OnAppend(topic string, fn AppendFunc) (remove func()) {
+	return b.appendCallbacks.add(topic, fn)
+}
+
+// commitAppends calls the append callbacks with the batches of the partition its high watermark
+// has passed, after a produce or a follower's fetch, and times the acks=all batches it's passed.
+func (b *Broker) commitAppends(replica *Replica) {
+	hw := b.followers.highWatermark(replica.Partition, replica.Log.NewestOffset())
+	b.orderingAudit.committed(replica.Partition.Topic, replica.Partition.ID, func() int64 {
+		return b.followers.highWatermark(replica.Partition, replica.Log.NewestOffset())
+	})
+	b.replicationWaits.committed(replica.Partition.Topic, replica.Partition.ID, hw)
+	b.appendCallbacks.commit(replica.Partition.Topic, replica.Partition.ID, replica.Log, hw)
+}
+
+// appendCallbacks holds the callbacks registered with OnAppend, and how far they've got through
+// the partitions of their topics.
+type appendCallbacks struct {
+	logger log.Logger
+
+	mu         sync.Mutex
+	callbacks  map[string][]*appendCallback
+	partitions map[topicPartition]*appendCursor
+}
+
+// appendCallback wraps an AppendFunc so it can be found to remove it.
+type appendCallback struct {
+	fn AppendFunc
+}
+
+// appendCursor is the offset of the next batch of a partition to pass to the callbacks. The
+// committed batches are read back from the log, rather than kept as they're produced, so they're
+// passed in order even when produces to the partition race. Its lock is held while the callbacks
+// are called.
+type appendCursor struct {
+	mu   sync.Mutex
+	next int64
+}
+
+func newAppendCallbacks(logger log.Logger) *appendCallbacks {
+	return &appendCallbacks{
+		logger:     logger,
+		callbacks:  make(map[string][]*appendCallback),
+		partitions: make(map[topicPartition]*appendCursor),
+	}
+}
+
+func (c *appendCallbacks) add(topic string, fn AppendFunc) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cb := &appendCallback{fn: fn}
+	callbacks := c.callbacks[topic]
+	// copied so the callbacks being called aren't changed under them
+	c.callbacks[topic] = append(callbacks[:len(callbacks):len(callbacks)], cb)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		callbacks := c.callbacks[topic]
+		for i, other := range callbacks {
+			if other != cb {
+				continue
+			}
+			kept := append(append([]*appendCallback(nil), callbacks[:i]...), callbacks[i+1:]...)
+			if len(kept) == 0 {
+				delete(c.callbacks, topic)
+			} else {
+				c.callbacks[topic] = kept
+			}
+			return
+		}
+	}
+}
+
+// start starts following the partition from offset, either its high watermark as this broker
+// becomes its leader or the offset a record set's appended at, unless it's already followed or
+// its topic has no callbacks.
+func (c *appendCallbacks) start(topic string, partition int32, offset int64) {
+	key := topicPartition{topic: topic, partition: partition}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.callbacks[topic]) == 0 {
+		return
+	}
+	if _, ok := c.partitions[key]; !ok {
+		c.partitions[key] = &appendCursor{next: offset}
+	}
+}
+
+// commit calls the callbacks with the partition's batches below its high watermark that they
+// haven't been passed, reading them from its log.
+func (c *appendCallbacks) commit(topic string, partition int32, l CommitLog, highWatermark int64) {
+	key := topicPartition{topic: topic, partition: partition}
+	c.mu.Lock()
+	cursor, ok := c.partitions[key]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	cursor.mu.Lock()
+	defer cursor.mu.Unlock()
+	if cursor.next >= highWatermark {
+		return
+	}
+	r, err := l.NewReader(cursor.next, math.MaxInt32)
+	if err != nil {
+		c.logger.Error("failed to read committed batches", log.String("topic", topic), log.Int32("partition", partition), log.Error("error", err))
+		return
+	}
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	for cursor.next < highWatermark {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return
+		}
+		size := int32(protocol.Encoding.Uint32(header[8:]))
+		if size < 0 {
+			return
+		}
+		entry := make([]byte, 12+int(size))
+		copy(entry, header)
+		if _, err := io.ReadFull(br, entry[12:]); err != nil {
+			return
+		}
+		ms := protocol.RecordSetEntries(entry)
+		if len(ms) == 0 {
+			return
+		}
+		last := ms[0].Offset
+		var batch *protocol.RecordBatch
+		if ms[0].Magic >= 2 {
+			batches, err := protocol.ReadRecordBatches(entry)
+			if err != nil {
+				c.logger.Error("failed to read committed batch", log.String("topic", topic), log.Int32("partition", partition), log.Error("error", err))
+				return
+			}
+			batch = batches[0]
+			last = batch.BaseOffset + int64(batch.LastOffsetDelta)
+		}
+		if last < cursor.next {
+			// the batch the reader started at, already passed
+			continue
+		}
+		if last >= highWatermark {
+			return
+		}
+		cursor.next = last + 1
+		if batch == nil {
+			// message sets of older clients aren't passed to the callbacks
+			continue
+		}
+		c.mu.Lock()
+		callbacks := c.callbacks[topic]
+		c.mu.Unlock()
+		for _, cb := range callbacks {
+			c.call(cb, topic, partition, batch)
+		}
+	}
+}
+
+// call calls the callback, logging it if it panics rather than failing the produce or fetch.
+func (c *appendCallbacks) call(cb *appendCallback, topic string, partition int32, batch *protocol.RecordBatch) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("append callback panicked", log.String("topic", topic), log.Int32("partition", partition), log.Error("error", fmt.Errorf("%v", r)))
+		}
+	}()
+	cb.fn(topic, partition, batch)
+}
+
+// remove stops following the partition once this broker stops leading it, its batches won't be
+// committed by it.
+func (c *appendCallbacks) remove(topic string, partition int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.partitions, topicPartition{topic: topic, partition: partition})
+}
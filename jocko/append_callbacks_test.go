@@ -0,0 +1,104 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_OnAppend(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+
+	produce := func(acks int16, recordSet []byte) {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{Acks: acks, TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+	// produced before there are callbacks
+	produce(1, testRecordBatchWithHeaders("type", "before"))
+
+	var offsets []int64
+	var values []string
+	removePanicking := b.OnAppend("the-topic", func(topic string, partition int32, batch *protocol.RecordBatch) {
+		panic("callback failed")
+	})
+	remove := b.OnAppend("the-topic", func(topic string, partition int32, batch *protocol.RecordBatch) {
+		require.Equal(t, "the-topic", topic)
+		require.Equal(t, int32(0), partition)
+		offsets = append(offsets, batch.BaseOffset)
+		for _, r := range batch.Records {
+			values = append(values, string(r.Headers[0].Value))
+		}
+	})
+	b.OnAppend("other-topic", func(topic string, partition int32, batch *protocol.RecordBatch) {
+		t.Fatal("called for another topic")
+	})
+
+	// without followers the batches are committed as soon as they're appended, and a panicking
+	// callback doesn't stop the rest
+	produce(-1, testRecordBatchWithHeaders("type", "order", "refund"))
+	produce(1, testRecordBatchWithHeaders("type", "order"))
+	require.Equal(t, []int64{1, 3}, offsets)
+	require.Equal(t, []string{"order", "refund", "order"}, values)
+
+	// batches are passed once the high watermark passes them, as followers replicate them
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	first, err := replica.Log.Append(testRecordBatchWithHeaders("type", "replicated", "replicated"))
+	require.NoError(t, err)
+	_, err = replica.Log.Append(testRecordBatchWithHeaders("type", "replicated"))
+	require.NoError(t, err)
+	b.appendCallbacks.commit("the-topic", 0, replica.Log, first+1)
+	require.Equal(t, []int64{1, 3}, offsets)
+	b.appendCallbacks.commit("the-topic", 0, replica.Log, first+2)
+	require.Equal(t, []int64{1, 3, 4}, offsets)
+	b.appendCallbacks.commit("the-topic", 0, replica.Log, first+3)
+	require.Equal(t, []int64{1, 3, 4, 6}, offsets)
+
+	remove()
+	removePanicking()
+	produce(1, testRecordBatchWithHeaders("type", "after"))
+	require.Equal(t, []int64{1, 3, 4, 6}, offsets)
+}
+
+func TestBroker_OnAppendFromLeading(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+	var offsets []int64
+	b.OnAppend("the-topic", func(topic string, partition int32, batch *protocol.RecordBatch) {
+		offsets = append(offsets, batch.BaseOffset)
+	})
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+
+	// the partition's followed from when the broker started leading it, so batches it didn't
+	// have produced to it, like those it replicated as a follower, are passed once they're
+	// committed
+	replica := addTestFollower(t, b, "the-topic", 0, 100)
+	_, err := replica.Log.Append(testRecordBatchWithHeaders("type", "replicated"))
+	require.NoError(t, err)
+	require.Empty(t, offsets)
+	fetch := b.handleFetch(ctx, &protocol.FetchRequest{ReplicaID: 100, MaxWaitTime: 100, Topics: []*protocol.FetchTopic{{
+		Topic:      "the-topic",
+		Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: 1, MaxBytes: 100}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), fetch.Responses[0].PartitionResponses[0].ErrorCode)
+	require.Equal(t, []int64{0}, offsets)
+}
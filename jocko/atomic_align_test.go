@@ -0,0 +1,17 @@
+package jocko
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAtomicAlignment checks the fields accessed with 64-bit atomics are first in their structs,
+// the only place they're sure to be 64-bit aligned on 32-bit platforms like arm.
+func TestAtomicAlignment(t *testing.T) {
+	var b Broker
+	require.Zero(t, unsafe.Offsetof(b.raftLeaderChanges))
+	var r Replicator
+	require.Zero(t, unsafe.Offsetof(r.highwaterMarkOffset))
+}
@@ -0,0 +1,208 @@
+package jocko
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/fsm"
+)
+
+// Blocking queries let admin API clients watch the cluster's state without polling in a tight
+// loop, like Consul's: a GET passing the index from the X-Jocko-Index header of its last response
+// waits until the state it reads has changed past that index, or for up to the wait, before
+// responding.
+const (
+	adminIndexHeader = "X-Jocko-Index"
+	defaultQueryWait = 5 * time.Minute
+	maxQueryWait     = 10 * time.Minute
+)
+
+// parseBlockingQuery returns the index and wait of the request's blocking query. An index of
+// zero doesn't block.
+func parseBlockingQuery(r *http.Request) (uint64, time.Duration, error) {
+	q := r.URL.Query()
+	var index uint64
+	if v := q.Get("index"); v != "" {
+		var err error
+		if index, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("index must be a raft index")
+		}
+	}
+	wait := defaultQueryWait
+	if v := q.Get("wait"); v != "" {
+		var err error
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			return 0, 0, fmt.Errorf("wait must be a duration, like 30s")
+		}
+	}
+	if wait > maxQueryWait {
+		wait = maxQueryWait
+	}
+	return index, wait, nil
+}
+
+// blockingQuery runs the query, which reads the store and returns the index of what it read,
+// again each time the store changes until the index is past minIndex or the wait's up, then sets
+// the index header to the last run's.
+func (b *Broker) blockingQuery(w http.ResponseWriter, r *http.Request, minIndex uint64, wait time.Duration, query func(state *fsm.Store) (uint64, error)) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		state := b.fsm.State()
+		changeCh := state.ChangeCh()
+		index, err := query(state)
+		if err != nil {
+			return err
+		}
+		if minIndex == 0 || index > minIndex {
+			w.Header().Set(adminIndexHeader, strconv.FormatUint(index, 10))
+			return nil
+		}
+		select {
+		case <-changeCh:
+		case <-state.AbandonCh():
+			// the store was restored from a snapshot, run the query against the new one
+		case <-timer.C:
+			w.Header().Set(adminIndexHeader, strconv.FormatUint(index, 10))
+			return nil
+		case <-b.shutdownCh:
+			w.Header().Set(adminIndexHeader, strconv.FormatUint(index, 10))
+			return nil
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+	}
+}
+
+type adminPartition struct {
+	Partition        int32   `json:"partition"`
+	Leader           int32   `json:"leader"`
+	LeaderEpoch      int32   `json:"leader_epoch"`
+	Replicas         []int32 `json:"replicas"`
+	ISR              []int32 `json:"isr"`
+	AddingReplicas   []int32 `json:"adding_replicas,omitempty"`
+	RemovingReplicas []int32 `json:"removing_replicas,omitempty"`
+}
+
+type adminTopic struct {
+	Topic      string           `json:"topic"`
+	Partitions []adminPartition `json:"partitions"`
+}
+
+// adminTopics returns the topics and their partitions' leaders and replicas, or only the topic's,
+// blocking until they've changed past the index if it's given.
+//
+//	GET /v1/topics[?topic=<topic>][&index=<index>&wait=<duration>]
+func (b *Broker) adminTopics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	minIndex, wait, err := parseBlockingQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	topic := r.URL.Query().Get("topic")
+	var topics []adminTopic
+	err = b.blockingQuery(w, r, minIndex, wait, func(state *fsm.Store) (uint64, error) {
+		index := state.MaxIndex("topics", "partitions")
+		_, ts, err := state.GetTopics()
+		if err != nil {
+			return 0, err
+		}
+		_, ps, err := state.GetPartitions()
+		if err != nil {
+			return 0, err
+		}
+		topics = []adminTopic{}
+		byName := make(map[string]int)
+		for _, t := range ts {
+			if topic != "" && t.Topic != topic {
+				continue
+			}
+			byName[t.Topic] = len(topics)
+			topics = append(topics, adminTopic{Topic: t.Topic, Partitions: []adminPartition{}})
+		}
+		for _, p := range ps {
+			i, ok := byName[p.Topic]
+			if !ok {
+				continue
+			}
+			topics[i].Partitions = append(topics[i].Partitions, adminPartition{
+				Partition:        p.ID,
+				Leader:           p.Leader,
+				LeaderEpoch:      p.LeaderEpoch,
+				Replicas:         p.AR,
+				ISR:              p.ISR,
+				AddingReplicas:   p.AddingReplicas,
+				RemovingReplicas: p.RemovingReplicas,
+			})
+		}
+		return index, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if topic != "" && len(topics) == 0 {
+		http.Error(w, "topic not found", http.StatusNotFound)
+		return
+	}
+	for _, t := range topics {
+		sort.Slice(t.Partitions, func(i, j int) bool { return t.Partitions[i].Partition < t.Partitions[j].Partition })
+	}
+	writeAdminJSON(w, struct {
+		Topics []adminTopic `json:"topics"`
+	}{topics})
+}
+
+type adminBroker struct {
+	ID      int32  `json:"id"`
+	Address string `json:"address"`
+	Status  string `json:"status,omitempty"`
+}
+
+// adminBrokers returns the brokers in the cluster and the status of their health checks,
+// blocking until they've changed past the index if it's given.
+//
+//	GET /v1/brokers[?index=<index>&wait=<duration>]
+func (b *Broker) adminBrokers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	minIndex, wait, err := parseBlockingQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var brokers []adminBroker
+	err = b.blockingQuery(w, r, minIndex, wait, func(state *fsm.Store) (uint64, error) {
+		index, nodes, err := state.GetNodes()
+		if err != nil {
+			return 0, err
+		}
+		brokers = []adminBroker{}
+		for _, n := range nodes {
+			broker := adminBroker{ID: n.Node, Address: n.Address}
+			if n.Check != nil {
+				broker.Status = n.Check.Status
+			}
+			brokers = append(brokers, broker)
+		}
+		return index, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, struct {
+		Brokers []adminBroker `json:"brokers"`
+	}{brokers})
+}
@@ -0,0 +1,89 @@
+package jocko
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_AdminBlockingQueries(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+	createTopic := func(topic string) {
+		resp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             topic,
+			NumPartitions:     2,
+			ReplicationFactor: 1,
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.TopicErrorCodes[0].ErrorCode)
+	}
+	createTopic("the-topic")
+
+	srv := httptest.NewServer(b.AdminHandler())
+	defer srv.Close()
+	type topics struct {
+		Topics []adminTopic `json:"topics"`
+	}
+	get := func(query string, body interface{}) (int, uint64) {
+		resp, err := http.Get(srv.URL + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, 0
+		}
+		index, err := strconv.ParseUint(resp.Header.Get(adminIndexHeader), 10, 64)
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(body))
+		return resp.StatusCode, index
+	}
+
+	var body topics
+	code, index := get("/v1/topics", &body)
+	require.Equal(t, http.StatusOK, code)
+	require.NotZero(t, index)
+	require.Equal(t, 1, len(body.Topics))
+	require.Equal(t, "the-topic", body.Topics[0].Topic)
+	require.Equal(t, 2, len(body.Topics[0].Partitions))
+	require.Equal(t, int32(0), body.Topics[0].Partitions[0].Partition)
+	require.Equal(t, b.config.ID, body.Topics[0].Partitions[0].Leader)
+	require.Equal(t, []int32{b.config.ID}, body.Topics[0].Partitions[0].ISR)
+
+	// nothing's changed so the query waits out its wait
+	code, waited := get("/v1/topics?index="+strconv.FormatUint(index, 10)+"&wait=50ms", &body)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, index, waited)
+
+	// the query returns once the topics change
+	done := make(chan uint64, 1)
+	var changed topics
+	go func() {
+		_, i := get("/v1/topics?index="+strconv.FormatUint(index, 10)+"&wait=1m", &changed)
+		done <- i
+	}()
+	createTopic("another-topic")
+	require.True(t, <-done > index)
+	require.Equal(t, 2, len(changed.Topics))
+
+	code, _ = get("/v1/topics?topic=unknown-topic", &body)
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/v1/topics?index=x", &body)
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("/v1/brokers?wait=x", &body)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	var brokers struct {
+		Brokers []adminBroker `json:"brokers"`
+	}
+	code, index = get("/v1/brokers", &brokers)
+	require.Equal(t, http.StatusOK, code)
+	require.NotZero(t, index)
+	require.Equal(t, 1, len(brokers.Brokers))
+	require.Equal(t, b.config.ID, brokers.Brokers[0].ID)
+}
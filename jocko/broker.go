@@ -6,9 +6,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -26,7 +30,6 @@ import (
 	"github.com/travisjeffery/jocko/jocko/fsm"
 	"github.com/travisjeffery/jocko/jocko/metadata"
 	"github.com/travisjeffery/jocko/jocko/structs"
-	"github.com/travisjeffery/jocko/jocko/util"
 	"github.com/travisjeffery/jocko/log"
 	"github.com/travisjeffery/jocko/protocol"
 )
@@ -58,6 +61,10 @@ func init() {
 
 // Broker represents a broker in a Jocko cluster, like a broker in a Kafka cluster.
 type Broker struct {
+	// raftLeaderChanges counts the changes of the cluster's leader the broker's seen. It's
+	// updated atomically, and first so it's 64-bit aligned on 32-bit platforms.
+	raftLeaderChanges int64
+
 	sync.RWMutex
 	logger log.Logger
 	config *config.Config
@@ -78,40 +85,167 @@ type Broker struct {
 	raftNotifyCh <-chan bool
 	// reconcileCh is used to pass events from the serf handler to the raft leader to update its state.
 	reconcileCh chan serf.Member
-	serf        *serf.Serf
-	fsm         *fsm.FSM
-	eventChLAN  chan serf.Event
-
-	tracer opentracing.Tracer
+	// offlineCh is used to pass partitions whose replicas went offline from the serf handler to
+	// the raft leader to move their leadership.
+	offlineCh chan offlinePartition
+	// rebuilds holds the partitions moved off each failed node that haven't been sent to their
+	// brokers yet. It's only used by the leader loop.
+	rebuilds map[int32]map[topicPartition]bool
+	// electLeadersCh is used to pass ElectLeaders requests to the raft leader to run.
+	electLeadersCh chan *electLeadersRequest
+	// controlledShutdownCh is used to pass ControlledShutdown requests to the raft leader to run.
+	controlledShutdownCh chan *controlledShutdownRequest
+	// reassignmentsCh is used to pass AlterPartitionReassignments requests to the raft leader to run.
+	reassignmentsCh chan *reassignmentsRequest
+	// alterPartitionCh is used to pass AlterPartition requests to the raft leader to run.
+	alterPartitionCh chan *alterPartitionRequest
+	// shuttingDown holds when each broker shutting down asked the controller to move its
+	// partitions. It's only used by the leader loop.
+	shuttingDown map[int32]time.Time
+	// leaderStopCh is closed when the leader loop stops, it's nil while it isn't running.
+	leaderStopCh   chan struct{}
+	leaderStopLock sync.Mutex
+	serf           *serf.Serf
+	fsm            *fsm.FSM
+	eventChLAN     chan serf.Event
+	// breakers stop the logs of partitions that keep failing being used.
+	breakers *partitionBreakers
+	// partitionLocks serialize the handlers that read and change a partition's state.
+	partitionLocks *partitionLocks
+	// producers tracks the idempotent producers of this broker's partitions.
+	producers *producerStates
+	// producerIDs are the producer ids the broker hands out to idempotent producers.
+	producerIDs producerIDs
+	// transactions are the transactions of the transaction state partitions this broker leads.
+	transactions *transactions
+	// groupPartitions are the offsets topic partitions whose groups this broker's loaded.
+	groupPartitions *groupPartitions
+	// txnIndexes tracks the transactions written to this broker's partitions.
+	txnIndexes *txnIndexes
+	// leaderLeases is this broker's lease on the partitions it leads.
+	leaderLeases *leaderLeases
+	// replicaFetchers fetch the partitions this broker follows from their leaders.
+	replicaFetchers *replicaFetchers
+	// followers tracks the offsets the followers of this broker's partitions have replicated to.
+	followers *followerOffsets
+	// replicationWaits times the acks=all batches produced to this broker's partitions until
+	// they're replicated.
+	replicationWaits *replicationWaits
+	// appendCallbacks are called with the batches committed to this broker's partitions.
+	appendCallbacks *appendCallbacks
+	// rebalances keeps the last rebalances of the groups this broker coordinates.
+	rebalances *groupRebalances
+	// sessions keeps when the members of the groups this broker coordinates were last heard from.
+	sessions *groupSessions
+	// topicDeletions is the queue of topics this broker deletes while it's the controller.
+	topicDeletions *topicDeletions
+	// orderingAudit checks the ordering of what's appended to this broker's partitions, nil
+	// unless it's turned on.
+	orderingAudit *orderingAudit
+	// startup is this run of the broker's startup, advertised for the controller to record.
+	startup structs.BrokerLifecycleEvent
+
+	logDirsRebalance logDirsRebalance
+	// interceptors holds the []ProduceInterceptor called with appended batches, replaced as a
+	// whole under interceptorsLock as they're added.
+	interceptors     atomic.Value
+	interceptorsLock sync.Mutex
+	// traceConfig holds the *traceConfig with the broker's current trace configs.
+	traceConfig atomic.Value
+	// quotaConfig holds the *quotaConfig with the broker's current quota configs.
+	quotaConfig atomic.Value
+	// clientRates are the byte rates clients produce and fetch at, for their quotas.
+	clientRates *clientRates
+	// raftObservers are called with the changes to the broker's raft cluster.
+	raftObservers raftObservers
+	// shadow, if set, dual-writes produces to an external cluster.
+	shadow *Shadow
+
+	tracer  opentracing.Tracer
+	metrics *Metrics
+
+	// requests tracks the requests being handled, for shutting down to drain them.
+	requests requestsInFlight
+	// coordinatorsShutdownCh is closed to stop the coordinators' background work, before
+	// shutdownCh stops the rest of the broker's.
+	coordinatorsShutdownCh chan struct{}
 
 	shutdownCh   chan struct{}
 	shutdown     bool
 	shutdownLock sync.Mutex
 }
 
-// New is used to instantiate a new broker.
-func NewBroker(config *config.Config, tracer opentracing.Tracer, logger log.Logger) (*Broker, error) {
+// New is used to instantiate a new broker. Metrics may be nil.
+func NewBroker(config *config.Config, metrics *Metrics, tracer opentracing.Tracer, logger log.Logger) (*Broker, error) {
+	started := time.Now()
 	b := &Broker{
-		config:        config,
-		logger:        logger.With(log.Int32("id", config.ID), log.String("raft addr", config.RaftAddr)),
-		shutdownCh:    make(chan struct{}),
-		eventChLAN:    make(chan serf.Event, 256),
-		brokerLookup:  NewBrokerLookup(),
-		replicaLookup: NewReplicaLookup(),
-		reconcileCh:   make(chan serf.Member, 32),
-		tracer:        tracer,
+		config:          config,
+		logger:          logger.With(log.Int32("id", config.ID), log.String("raft addr", config.RaftAddr)),
+		shutdownCh:      make(chan struct{}),
+		eventChLAN:      make(chan serf.Event, 256),
+		brokerLookup:    NewBrokerLookup(),
+		replicaLookup:   NewReplicaLookup(),
+		reconcileCh:     make(chan serf.Member, 32),
+		offlineCh:       make(chan offlinePartition, 32),
+		electLeadersCh:  make(chan *electLeadersRequest),
+		breakers:        newPartitionBreakers(config.PartitionFailureThreshold, config.PartitionFailureCooldown),
+		partitionLocks:  newPartitionLocks(),
+		producers:       newProducerStates(metrics),
+		transactions:    newTransactions(),
+		groupPartitions: newGroupPartitions(),
+		txnIndexes:      newTxnIndexes(),
+		followers:       newFollowerOffsets(),
+		clientRates:     newClientRates(),
+		tracer:          tracer,
+		metrics:         metrics,
 	}
 
 	if b.logger == nil {
 		return nil, ErrInvalidArgument
 	}
+	b.appendCallbacks = newAppendCallbacks(b.logger)
+	b.coordinatorsShutdownCh = make(chan struct{})
+	b.controlledShutdownCh = make(chan *controlledShutdownRequest)
+	b.reassignmentsCh = make(chan *reassignmentsRequest)
+	b.alterPartitionCh = make(chan *alterPartitionRequest)
+	b.replicationWaits = newReplicationWaits(metrics)
+	b.rebalances = newGroupRebalances(config.GroupRebalanceHistorySize, metrics)
+	b.sessions = newGroupSessions()
+	b.topicDeletions = newTopicDeletions()
+	b.leaderLeases = newLeaderLeases()
+	b.replicaFetchers = newReplicaFetchers(replicaFetcherConfig{
+		MinBytes:    1,
+		MaxWaitTime: int32(config.ReplicaFetchWaitMax / time.Millisecond),
+		Backoff:     config.ReplicaFetchBackoff,
+		MaxBackoff:  config.ReplicaFetchBackoffMax,
+	}, b.dialReplicaFetcher, b.logger)
+	if config.OrderingAudit {
+		b.orderingAudit = newOrderingAudit(b.logger, metrics)
+	}
 
 	b.logger.Info("hello")
 
+	// before raft's replayed and the replicas are started
+	b.removeFutureLogs()
+	if err := b.loadLogDirsRebalanced(); err != nil {
+		b.logger.Error("failed to load log dirs rebalance", log.Error("error", err))
+	}
+	if err := b.loadHighWatermarks(); err != nil {
+		b.logger.Error("failed to load high watermark checkpoint", log.Error("error", err))
+	}
+
+	previousShutdown := b.previousShutdown()
 	if err := b.setupRaft(); err != nil {
 		b.Shutdown()
 		return nil, fmt.Errorf("failed to start raft: %v", err)
 	}
+	b.startup = structs.BrokerLifecycleEvent{
+		Type:             structs.BrokerStarted,
+		Time:             started,
+		Version:          config.Version,
+		PreviousShutdown: previousShutdown,
+		RecoveryDuration: time.Since(started),
+	}
 
 	var err error
 	b.serf, err = b.setupSerf(config.SerfLANConfig, b.eventChLAN, serfLANSnapshot)
@@ -123,87 +257,217 @@ func NewBroker(config *config.Config, tracer opentracing.Tracer, logger log.Logg
 
 	go b.monitorLeadership()
 
+	go b.removeDeletedLogs()
+
+	go b.abortTimedOutTransactions(config.TransactionAbortTimedOutInterval)
+
+	go b.removeExpiredDelegationTokens(config.DelegationTokenExpiryCheckInterval)
+
+	go b.removeExpiredOffsets(config.OffsetsRetentionCheckInterval)
+
+	go b.removeDeadGroups(config.OffsetsRetentionCheckInterval)
+
+	go b.removeTimedOutMembers(groupSessionCheckInterval)
+
+	go b.checkpointHighWatermarks(config.ReplicaHighWatermarkCheckpointInterval)
+
+	go b.renewLeaderLeases(config.LeaderLeaseDuration)
+
+	go b.checkISRs(config.ReplicaLagTimeMax)
+
+	if len(config.ShadowBrokers) > 0 {
+		var topics *regexp.Regexp
+		if config.ShadowTopics != "" {
+			if topics, err = regexp.Compile(config.ShadowTopics); err != nil {
+				b.Shutdown()
+				return nil, fmt.Errorf("invalid shadow topics: %v", err)
+			}
+		}
+		b.shadow = NewShadow(ShadowConfig{
+			Brokers:          config.ShadowBrokers,
+			QueueSize:        config.ShadowQueueSize,
+			VerifyInterval:   config.ShadowVerifyInterval,
+			Topics:           topics,
+			OffsetSyncsTopic: config.ShadowOffsetSyncsTopic,
+		}, NewDialerWithConfig(fmt.Sprintf("jocko-shadow-%d", config.ID), config.ClientSocket), metrics, b.logger)
+		b.AddProduceInterceptor(b.shadow)
+	}
+
+	if config.ConsistencyCheckInterval > 0 {
+		go b.checkConsistencyPeriodically(config.ConsistencyCheckInterval, config.FixOrphanedLogs)
+	}
+
 	return b, nil
 }
 
 // Broker API.
 
-// Run starts a loop to handle requests send back responses.
+// Run starts a loop to handle requests send back responses. Requests are handled by the pool of
+// handlers of their class, concurrently with those of other classes. The loop waits while the
+// class's handlers are busy and their queue's full.
 func (b *Broker) Run(ctx context.Context, requests <-chan *Context, responses chan<- *Context) {
-	var reqCtx *Context
-	var response protocol.ResponseBody
-
+	pools := b.handlerPools()
+	defer func() {
+		for _, p := range pools {
+			p.stop()
+		}
+	}()
 	for {
 		select {
-		case reqCtx = <-requests:
+		case reqCtx := <-requests:
 			queueSpan, ok := reqCtx.Value(requestQueueSpanKey).(opentracing.Span)
 			if ok {
 				queueSpan.Finish()
 			}
-
-			switch req := reqCtx.req.(type) {
-			case *protocol.ProduceRequest:
-				response = b.handleProduce(reqCtx, req)
-			case *protocol.FetchRequest:
-				response = b.handleFetch(reqCtx, req)
-			case *protocol.OffsetsRequest:
-				response = b.handleOffsets(reqCtx, req)
-			case *protocol.MetadataRequest:
-				response = b.handleMetadata(reqCtx, req)
-			case *protocol.LeaderAndISRRequest:
-				response = b.handleLeaderAndISR(reqCtx, req)
-			case *protocol.StopReplicaRequest:
-				response = b.handleStopReplica(reqCtx, req)
-			case *protocol.UpdateMetadataRequest:
-				response = b.handleUpdateMetadata(reqCtx, req)
-			case *protocol.ControlledShutdownRequest:
-				response = b.handleControlledShutdown(reqCtx, req)
-			case *protocol.OffsetCommitRequest:
-				response = b.handleOffsetCommit(reqCtx, req)
-			case *protocol.OffsetFetchRequest:
-				response = b.handleOffsetFetch(reqCtx, req)
-			case *protocol.FindCoordinatorRequest:
-				response = b.handleFindCoordinator(reqCtx, req)
-			case *protocol.JoinGroupRequest:
-				response = b.handleJoinGroup(reqCtx, req)
-			case *protocol.HeartbeatRequest:
-				response = b.handleHeartbeat(reqCtx, req)
-			case *protocol.LeaveGroupRequest:
-				response = b.handleLeaveGroup(reqCtx, req)
-			case *protocol.SyncGroupRequest:
-				response = b.handleSyncGroup(reqCtx, req)
-			case *protocol.DescribeGroupsRequest:
-				response = b.handleDescribeGroups(reqCtx, req)
-			case *protocol.ListGroupsRequest:
-				response = b.handleListGroups(reqCtx, req)
-			case *protocol.SaslHandshakeRequest:
-				response = b.handleSaslHandshake(reqCtx, req)
-			case *protocol.APIVersionsRequest:
-				response = b.handleAPIVersions(reqCtx, req)
-			case *protocol.CreateTopicRequests:
-				response = b.handleCreateTopic(reqCtx, req)
-			case *protocol.DeleteTopicsRequest:
-				response = b.handleDeleteTopics(reqCtx, req)
+			// the broker's shutting down
+			if !b.requests.start() {
+				return
 			}
-
+			pools[requestClass(reqCtx.req)].handle(func() {
+				b.handleRequest(reqCtx, responses)
+			})
 		case <-ctx.Done():
 			return
 		}
+	}
+}
 
-		parentSpan := opentracing.SpanFromContext(reqCtx)
-		queueSpan := b.tracer.StartSpan("broker: queue response", opentracing.ChildOf(parentSpan.Context()))
-		responseCtx := context.WithValue(reqCtx, responseQueueSpanKey, queueSpan)
+// handleRequest handles the request and queues its response.
+func (b *Broker) handleRequest(reqCtx *Context, responses chan<- *Context) {
+	traced := b.traceRequest(reqCtx)
+	if traced {
+		b.traceLog(reqCtx, "handling traced request", "request", reqCtx)
+	}
 
-		responses <- &Context{
-			parent: responseCtx,
-			conn:   reqCtx.conn,
-			header: reqCtx.header,
-			res: &protocol.Response{
-				CorrelationID: reqCtx.header.CorrelationID,
-				Body:          response,
-			},
-		}
+	response := b.handle(reqCtx)
+	b.requests.done()
+
+	if traced {
+		b.traceLog(reqCtx, "handled traced request", "response", response)
+	}
+
+	parentSpan := opentracing.SpanFromContext(reqCtx)
+	queueSpan := b.tracer.StartSpan("broker: queue response", opentracing.ChildOf(parentSpan.Context()))
+	responseCtx := context.WithValue(reqCtx, responseQueueSpanKey, queueSpan)
+
+	responses <- &Context{
+		parent: responseCtx,
+		conn:   reqCtx.conn,
+		header: reqCtx.header,
+		res: &protocol.Response{
+			CorrelationID: reqCtx.header.CorrelationID,
+			Body:          response,
+		},
+	}
+}
+
+// handle returns the response to the request.
+func (b *Broker) handle(reqCtx *Context) protocol.ResponseBody {
+	switch req := reqCtx.req.(type) {
+	case *protocol.ProduceRequest:
+		return b.handleProduce(reqCtx, req)
+	case *protocol.FetchRequest:
+		return b.handleFetch(reqCtx, req)
+	case *protocol.OffsetsRequest:
+		return b.handleOffsets(reqCtx, req)
+	case *protocol.MetadataRequest:
+		return b.handleMetadata(reqCtx, req)
+	case *protocol.LeaderAndISRRequest:
+		return b.handleLeaderAndISR(reqCtx, req)
+	case *protocol.StopReplicaRequest:
+		return b.handleStopReplica(reqCtx, req)
+	case *protocol.UpdateMetadataRequest:
+		return b.handleUpdateMetadata(reqCtx, req)
+	case *protocol.ControlledShutdownRequest:
+		return b.handleControlledShutdown(reqCtx, req)
+	case *protocol.OffsetCommitRequest:
+		return b.handleOffsetCommit(reqCtx, req)
+	case *protocol.OffsetFetchRequest:
+		return b.handleOffsetFetch(reqCtx, req)
+	case *protocol.FindCoordinatorRequest:
+		return b.handleFindCoordinator(reqCtx, req)
+	case *protocol.JoinGroupRequest:
+		return b.handleJoinGroup(reqCtx, req)
+	case *protocol.HeartbeatRequest:
+		return b.handleHeartbeat(reqCtx, req)
+	case *protocol.LeaveGroupRequest:
+		return b.handleLeaveGroup(reqCtx, req)
+	case *protocol.SyncGroupRequest:
+		return b.handleSyncGroup(reqCtx, req)
+	case *protocol.DescribeGroupsRequest:
+		return b.handleDescribeGroups(reqCtx, req)
+	case *protocol.DeleteGroupsRequest:
+		return b.handleDeleteGroups(reqCtx, req)
+	case *protocol.OffsetDeleteRequest:
+		return b.handleOffsetDelete(reqCtx, req)
+	case *protocol.ListGroupsRequest:
+		return b.handleListGroups(reqCtx, req)
+	case *protocol.APIVersionsRequest:
+		return b.handleAPIVersions(reqCtx, req)
+	case *protocol.CreateTopicRequests:
+		return b.handleCreateTopic(reqCtx, req)
+	case *protocol.DeleteTopicsRequest:
+		return b.handleDeleteTopics(reqCtx, req)
+	case *protocol.CreatePartitionsRequest:
+		return b.handleCreatePartitions(reqCtx, req)
+	case *protocol.DeleteRecordsRequest:
+		return b.handleDeleteRecords(reqCtx, req)
+	case *protocol.InitProducerIDRequest:
+		return b.handleInitProducerID(reqCtx, req)
+	case *protocol.OffsetForLeaderEpochRequest:
+		return b.handleOffsetForLeaderEpoch(reqCtx, req)
+	case *protocol.FetchSegmentRequest:
+		return b.handleFetchSegment(reqCtx, req)
+	case *protocol.LeaderLeaseRequest:
+		return b.handleLeaderLease(reqCtx, req)
+	case *protocol.AlterPartitionRequest:
+		return b.handleAlterPartition(reqCtx, req)
+	case *protocol.ElectLeadersRequest:
+		return b.handleElectLeaders(reqCtx, req)
+	case *protocol.AlterPartitionReassignmentsRequest:
+		return b.handleAlterPartitionReassignments(reqCtx, req)
+	case *protocol.ListPartitionReassignmentsRequest:
+		return b.handleListPartitionReassignments(reqCtx, req)
+	case *protocol.DescribeConfigsRequest:
+		return b.handleDescribeConfigs(reqCtx, req)
+	case *protocol.AlterConfigsRequest:
+		return b.handleAlterConfigs(reqCtx, req)
+	case *protocol.IncrementalAlterConfigsRequest:
+		return b.handleIncrementalAlterConfigs(reqCtx, req)
+	case *protocol.AlterReplicaLogDirsRequest:
+		return b.handleAlterReplicaLogDirs(reqCtx, req)
+	case *protocol.DescribeLogDirsRequest:
+		return b.handleDescribeLogDirs(reqCtx, req)
+	case *protocol.ListTransactionsRequest:
+		return b.handleListTransactions(reqCtx, req)
+	case *protocol.DescribeTransactionsRequest:
+		return b.handleDescribeTransactions(reqCtx, req)
+	case *protocol.AddPartitionsToTxnRequest:
+		return b.handleAddPartitionsToTxn(reqCtx, req)
+	case *protocol.AddOffsetsToTxnRequest:
+		return b.handleAddOffsetsToTxn(reqCtx, req)
+	case *protocol.EndTxnRequest:
+		return b.handleEndTxn(reqCtx, req)
+	case *protocol.WriteTxnMarkersRequest:
+		return b.handleWriteTxnMarkers(reqCtx, req)
+	case *protocol.TxnOffsetCommitRequest:
+		return b.handleTxnOffsetCommit(reqCtx, req)
+	case *protocol.CreateAclsRequest:
+		return b.handleCreateAcls(reqCtx, req)
+	case *protocol.DeleteAclsRequest:
+		return b.handleDeleteAcls(reqCtx, req)
+	case *protocol.DescribeAclsRequest:
+		return b.handleDescribeAcls(reqCtx, req)
+	case *protocol.CreateDelegationTokenRequest:
+		return b.handleCreateDelegationToken(reqCtx, req)
+	case *protocol.RenewDelegationTokenRequest:
+		return b.handleRenewDelegationToken(reqCtx, req)
+	case *protocol.ExpireDelegationTokenRequest:
+		return b.handleExpireDelegationToken(reqCtx, req)
+	case *protocol.DescribeDelegationTokenRequest:
+		return b.handleDescribeDelegationToken(reqCtx, req)
 	}
+	return nil
 }
 
 // Join is used to have the broker join the gossip ring.
@@ -230,12 +494,18 @@ func span(ctx context.Context, tracer opentracing.Tracer, op string) opentracing
 	return tracer.StartSpan("broker: "+op, opentracing.ChildOf(parentSpan.Context()))
 }
 
-var apiVersions = &protocol.APIVersionsResponse{APIVersions: protocol.APIVersions}
-
 func (b *Broker) handleAPIVersions(ctx *Context, req *protocol.APIVersionsRequest) *protocol.APIVersionsResponse {
 	sp := span(ctx, b.tracer, "api versions")
 	defer sp.Finish()
-	return apiVersions
+	if !protocol.SupportedVersion(protocol.APIVersionsKey, req.Version()) {
+		// respond with version 0, which every client can read, so it can retry with a version
+		// we support.
+		return &protocol.APIVersionsResponse{
+			ErrorCode:   protocol.ErrUnsupportedVersion.Code(),
+			APIVersions: protocol.APIVersions,
+		}
+	}
+	return &protocol.APIVersionsResponse{APIVersion: req.Version(), APIVersions: protocol.APIVersions}
 }
 
 func (b *Broker) handleCreateTopic(ctx *Context, reqs *protocol.CreateTopicRequests) *protocol.CreateTopicsResponse {
@@ -246,6 +516,7 @@ func (b *Broker) handleCreateTopic(ctx *Context, reqs *protocol.CreateTopicReque
 	resp.TopicErrorCodes = make([]*protocol.TopicErrorCode, len(reqs.Requests))
 	isController := b.isController()
 	sp.LogKV("is controller", isController)
+	readOnly := b.readOnly()
 	for i, req := range reqs.Requests {
 		if !isController {
 			resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
@@ -254,7 +525,22 @@ func (b *Broker) handleCreateTopic(ctx *Context, reqs *protocol.CreateTopicReque
 			}
 			continue
 		}
-		if req.ReplicationFactor > int16(len(b.LANMembers())) {
+		if readOnly {
+			resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
+				Topic:     req.Topic,
+				ErrorCode: errReadOnly.Code(),
+			}
+			continue
+		}
+		req = b.withTopicDefaults(req)
+		if req.NumPartitions <= 0 {
+			resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
+				Topic:     req.Topic,
+				ErrorCode: protocol.ErrInvalidPartitions.Code(),
+			}
+			continue
+		}
+		if req.ReplicationFactor <= 0 || req.ReplicationFactor > int16(len(b.LANMembers())) {
 			resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
 				Topic:     req.Topic,
 				ErrorCode: protocol.ErrInvalidReplicationFactor.Code(),
@@ -277,6 +563,7 @@ func (b *Broker) handleDeleteTopics(ctx *Context, reqs *protocol.DeleteTopicsReq
 	resp.APIVersion = reqs.Version()
 	resp.TopicErrorCodes = make([]*protocol.TopicErrorCode, len(reqs.Topics))
 	isController := b.isController()
+	readOnly := b.readOnly()
 	for i, topic := range reqs.Topics {
 		if !isController {
 			resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
@@ -285,22 +572,403 @@ func (b *Broker) handleDeleteTopics(ctx *Context, reqs *protocol.DeleteTopicsReq
 			}
 			continue
 		}
-		// TODO: this will delete from fsm -- need to delete associated partitions, etc.
-		_, err := b.raftApply(structs.DeregisterTopicRequestType, structs.DeregisterTopicRequest{
-			structs.Topic{
-				Topic: topic,
-			},
-		})
-		if err != nil {
+		if readOnly {
 			resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
 				Topic:     topic,
-				ErrorCode: protocol.ErrUnknown.Code(),
+				ErrorCode: errReadOnly.Code(),
 			}
 			continue
 		}
 		resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
 			Topic:     topic,
-			ErrorCode: protocol.ErrNone.Code(),
+			ErrorCode: b.queueTopicDeletion(topic).Code(),
+		}
+	}
+	return resp
+}
+
+// queueTopicDeletion queues the topic for the controller to delete in the background, its
+// progress is described by the admin API.
+func (b *Broker) queueTopicDeletion(topic string) protocol.Error {
+	_, t, err := b.fsm.State().GetTopic(topic)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	if t == nil {
+		return protocol.ErrUnknownTopicOrPartition
+	}
+	if !b.topicDeletions.add(topic) {
+		return protocol.ErrNotController
+	}
+	return protocol.ErrNone
+}
+
+func (b *Broker) handleDescribeConfigs(ctx *Context, req *protocol.DescribeConfigsRequest) *protocol.DescribeConfigsResponse {
+	sp := span(ctx, b.tracer, "describe configs")
+	defer sp.Finish()
+	resp := new(protocol.DescribeConfigsResponse)
+	resp.APIVersion = req.Version()
+	resp.Resources = make([]protocol.DescribeConfigsResourceResponse, len(req.Resources))
+	state := b.fsm.State()
+	for i, resource := range req.Resources {
+		res := protocol.DescribeConfigsResourceResponse{
+			Type: resource.Type,
+			Name: resource.Name,
+		}
+		switch structs.ConfigResourceType(resource.Type) {
+		case structs.TopicConfigResource:
+			_, topic, err := state.GetTopic(resource.Name)
+			if err != nil {
+				res.ErrorCode = protocol.ErrUnknown.WithErr(err).Code()
+				break
+			}
+			if topic == nil {
+				res.ErrorCode = protocol.ErrUnknownTopicOrPartition.Code()
+				break
+			}
+			res.ConfigEntries = describeTopicConfigs(topic, resource.ConfigNames, req.IncludeSynonyms)
+		case structs.BrokerConfigResource:
+			// brokers only describe their own configs, an empty name describes the cluster defaults
+			if resource.Name != "" && resource.Name != strconv.Itoa(int(b.config.ID)) {
+				res.ErrorCode = protocol.ErrInvalidRequest.Code()
+				break
+			}
+			entries, err := b.describeBrokerConfigs(resource.ConfigNames, req.IncludeSynonyms)
+			if err != nil {
+				res.ErrorCode = protocol.ErrUnknown.WithErr(err).Code()
+				break
+			}
+			res.ConfigEntries = entries
+		default:
+			res.ErrorCode = protocol.ErrInvalidRequest.Code()
+		}
+		resp.Resources[i] = res
+	}
+	return resp
+}
+
+func (b *Broker) handleAlterConfigs(ctx *Context, req *protocol.AlterConfigsRequest) *protocol.AlterConfigsResponse {
+	sp := span(ctx, b.tracer, "alter configs")
+	defer sp.Finish()
+	resp := new(protocol.AlterConfigsResponse)
+	resp.APIVersion = req.Version()
+	resp.Resources = make([]protocol.AlterConfigResourceResponse, len(req.Resources))
+	isController := b.isController()
+	sp.LogKV("is controller", isController)
+	readOnly := b.readOnly()
+	for i, resource := range req.Resources {
+		res := protocol.AlterConfigResourceResponse{
+			Type: resource.Type,
+			Name: resource.Name,
+		}
+		resourceType := structs.ConfigResourceType(resource.Type)
+		var before map[string]string
+		var err protocol.Error
+		switch {
+		case !isController:
+			err = protocol.ErrNotController
+		case readOnly:
+			err = errReadOnly
+		case resourceType == structs.TopicConfigResource:
+			before = b.configEntries(resourceType, resource.Name)
+			err = b.alterTopicConfigs(resource.Name, resource.Entries, req.ValidateOnly)
+		case resourceType == structs.BrokerConfigResource:
+			before = b.configEntries(resourceType, resource.Name)
+			err = b.alterBrokerConfigs(resource.Name, resource.Entries, req.ValidateOnly)
+		default:
+			err = protocol.ErrInvalidRequest
+		}
+		if err == protocol.ErrNone && !req.ValidateOnly {
+			b.recordConfigsChange(ctx, "AlterConfigs", resourceType, resource.Name, before)
+		}
+		res.ErrorCode = err.Code()
+		resp.Resources[i] = res
+	}
+	return resp
+}
+
+func (b *Broker) handleIncrementalAlterConfigs(ctx *Context, req *protocol.IncrementalAlterConfigsRequest) *protocol.IncrementalAlterConfigsResponse {
+	sp := span(ctx, b.tracer, "incremental alter configs")
+	defer sp.Finish()
+	resp := new(protocol.IncrementalAlterConfigsResponse)
+	resp.APIVersion = req.Version()
+	resp.Resources = make([]protocol.AlterConfigResourceResponse, len(req.Resources))
+	isController := b.isController()
+	sp.LogKV("is controller", isController)
+	readOnly := b.readOnly()
+	for i, resource := range req.Resources {
+		res := protocol.AlterConfigResourceResponse{
+			Type: resource.Type,
+			Name: resource.Name,
+		}
+		resourceType := structs.ConfigResourceType(resource.Type)
+		var before map[string]string
+		var err protocol.Error
+		switch {
+		case !isController:
+			err = protocol.ErrNotController
+		case readOnly:
+			err = errReadOnly
+		case resourceType == structs.TopicConfigResource:
+			before = b.configEntries(resourceType, resource.Name)
+			err = b.incrementalAlterTopicConfigs(resource.Name, resource.Entries, req.ValidateOnly)
+		case resourceType == structs.BrokerConfigResource:
+			before = b.configEntries(resourceType, resource.Name)
+			err = b.incrementalAlterBrokerConfigs(resource.Name, resource.Entries, req.ValidateOnly)
+		default:
+			err = protocol.ErrInvalidRequest
+		}
+		if err == protocol.ErrNone && !req.ValidateOnly {
+			b.recordConfigsChange(ctx, "IncrementalAlterConfigs", resourceType, resource.Name, before)
+		}
+		res.ErrorCode = err.Code()
+		resp.Resources[i] = res
+	}
+	return resp
+}
+
+func (b *Broker) handleAlterReplicaLogDirs(ctx *Context, req *protocol.AlterReplicaLogDirsRequest) *protocol.AlterReplicaLogDirsResponse {
+	sp := span(ctx, b.tracer, "alter replica log dirs")
+	defer sp.Finish()
+	resp := new(protocol.AlterReplicaLogDirsResponse)
+	resp.APIVersion = req.Version()
+	for _, dir := range req.Dirs {
+		for _, t := range dir.Topics {
+			res := protocol.AlterReplicaLogDirTopicResult{
+				Topic:      t.Topic,
+				Partitions: make([]protocol.AlterReplicaLogDirPartitionResult, len(t.Partitions)),
+			}
+			for i, id := range t.Partitions {
+				err := b.alterReplicaLogDir(dir.Path, t.Topic, id)
+				if err != protocol.ErrNone {
+					sp.LogKV("topic", t.Topic, "partition", id, "err", err)
+				}
+				res.Partitions[i] = protocol.AlterReplicaLogDirPartitionResult{Partition: id, ErrorCode: err.Code()}
+			}
+			resp.Topics = append(resp.Topics, res)
+		}
+	}
+	return resp
+}
+
+func (b *Broker) handleDeleteRecords(ctx *Context, req *protocol.DeleteRecordsRequest) *protocol.DeleteRecordsResponse {
+	sp := span(ctx, b.tracer, "delete records")
+	defer sp.Finish()
+	resp := new(protocol.DeleteRecordsResponse)
+	resp.APIVersion = req.Version()
+	resp.Topics = make([]protocol.DeleteRecordsTopicResponse, len(req.Topics))
+	readOnly := b.readOnly()
+	for i, t := range req.Topics {
+		tr := protocol.DeleteRecordsTopicResponse{
+			Topic:      t.Topic,
+			Partitions: make([]protocol.DeleteRecordsPartitionResponse, len(t.Partitions)),
+		}
+		for j, p := range t.Partitions {
+			if readOnly {
+				tr.Partitions[j] = protocol.DeleteRecordsPartitionResponse{
+					Partition:    p.Partition,
+					LowWatermark: -1,
+					ErrorCode:    errReadOnly.Code(),
+				}
+				continue
+			}
+			lowWatermark, err := b.deleteRecords(t.Topic, p.Partition, p.Offset)
+			if err != protocol.ErrNone {
+				sp.LogKV("topic", t.Topic, "partition", p.Partition, "err", err)
+			}
+			tr.Partitions[j] = protocol.DeleteRecordsPartitionResponse{
+				Partition:    p.Partition,
+				LowWatermark: lowWatermark,
+				ErrorCode:    err.Code(),
+			}
+		}
+		resp.Topics[i] = tr
+	}
+	return resp
+}
+
+func (b *Broker) handleOffsetForLeaderEpoch(ctx *Context, req *protocol.OffsetForLeaderEpochRequest) *protocol.OffsetForLeaderEpochResponse {
+	sp := span(ctx, b.tracer, "offset for leader epoch")
+	defer sp.Finish()
+	resp := new(protocol.OffsetForLeaderEpochResponse)
+	resp.APIVersion = req.Version()
+	resp.Topics = make([]protocol.OffsetForLeaderEpochTopicResponse, len(req.Topics))
+	for i, t := range req.Topics {
+		tr := protocol.OffsetForLeaderEpochTopicResponse{
+			Topic:      t.Topic,
+			Partitions: make([]protocol.OffsetForLeaderEpochPartitionResponse, len(t.Partitions)),
+		}
+		for j, p := range t.Partitions {
+			epoch, offset, err := b.endOffsetForEpoch(t.Topic, p.Partition, p.CurrentLeaderEpoch, p.LeaderEpoch)
+			if err != protocol.ErrNone {
+				sp.LogKV("topic", t.Topic, "partition", p.Partition, "err", err)
+			}
+			tr.Partitions[j] = protocol.OffsetForLeaderEpochPartitionResponse{
+				ErrorCode:   err.Code(),
+				Partition:   p.Partition,
+				LeaderEpoch: epoch,
+				EndOffset:   offset,
+			}
+		}
+		resp.Topics[i] = tr
+	}
+	return resp
+}
+
+func (b *Broker) handleElectLeaders(ctx *Context, req *protocol.ElectLeadersRequest) *protocol.ElectLeadersResponse {
+	sp := span(ctx, b.tracer, "elect leaders")
+	defer sp.Finish()
+	resp := new(protocol.ElectLeadersResponse)
+	resp.APIVersion = req.Version()
+	results, err := b.electLeaders(req)
+	if err != protocol.ErrNone {
+		sp.LogKV("err", err)
+	}
+	for _, t := range results {
+		for _, p := range t.Partitions {
+			if p.ErrorCode != protocol.ErrNone.Code() {
+				sp.LogKV("topic", t.Topic, "partition", p.Partition, "err", p.ErrorCode)
+			}
+		}
+	}
+	resp.ErrorCode = err.Code()
+	resp.Topics = results
+	return resp
+}
+
+func (b *Broker) handleAlterPartitionReassignments(ctx *Context, req *protocol.AlterPartitionReassignmentsRequest) *protocol.AlterPartitionReassignmentsResponse {
+	sp := span(ctx, b.tracer, "alter partition reassignments")
+	defer sp.Finish()
+	resp := new(protocol.AlterPartitionReassignmentsResponse)
+	resp.APIVersion = req.Version()
+	results, err := b.alterPartitionReassignments(req)
+	if err != protocol.ErrNone {
+		sp.LogKV("err", err)
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	for _, t := range results {
+		for _, p := range t.Partitions {
+			if p.ErrorCode != protocol.ErrNone.Code() {
+				sp.LogKV("topic", t.Topic, "partition", p.Partition, "err", p.ErrorCode)
+			}
+		}
+	}
+	resp.Topics = results
+	return resp
+}
+
+func (b *Broker) handleListPartitionReassignments(ctx *Context, req *protocol.ListPartitionReassignmentsRequest) *protocol.ListPartitionReassignmentsResponse {
+	sp := span(ctx, b.tracer, "list partition reassignments")
+	defer sp.Finish()
+	resp := new(protocol.ListPartitionReassignmentsResponse)
+	resp.APIVersion = req.Version()
+	results, err := b.listPartitionReassignments(req.Topics)
+	if err != protocol.ErrNone {
+		sp.LogKV("err", err)
+	}
+	resp.ErrorCode = err.Code()
+	resp.Topics = results
+	return resp
+}
+
+func (b *Broker) handleDescribeLogDirs(ctx *Context, req *protocol.DescribeLogDirsRequest) *protocol.DescribeLogDirsResponse {
+	sp := span(ctx, b.tracer, "describe log dirs")
+	defer sp.Finish()
+	resp := new(protocol.DescribeLogDirsResponse)
+	resp.APIVersion = req.Version()
+	resp.Results = b.describeLogDirs(req.Topics)
+	return resp
+}
+
+func (b *Broker) handleListTransactions(ctx *Context, req *protocol.ListTransactionsRequest) *protocol.ListTransactionsResponse {
+	sp := span(ctx, b.tracer, "list transactions")
+	defer sp.Finish()
+	resp := new(protocol.ListTransactionsResponse)
+	resp.APIVersion = req.Version()
+	for _, state := range req.StateFilters {
+		if !isTransactionState(state) {
+			resp.UnknownStateFilters = append(resp.UnknownStateFilters, state)
+		}
+	}
+	for _, m := range b.transactionsState() {
+		if !matchesTransactionFilters(req, m) {
+			continue
+		}
+		resp.TransactionStates = append(resp.TransactionStates, protocol.ListTransactionsState{
+			TransactionalID:  m.TransactionalID,
+			ProducerID:       m.ProducerID,
+			TransactionState: m.State,
+		})
+	}
+	resp.ErrorCode = protocol.ErrNone.Code()
+	return resp
+}
+
+func (b *Broker) handleDescribeTransactions(ctx *Context, req *protocol.DescribeTransactionsRequest) *protocol.DescribeTransactionsResponse {
+	sp := span(ctx, b.tracer, "describe transactions")
+	defer sp.Finish()
+	resp := new(protocol.DescribeTransactionsResponse)
+	resp.APIVersion = req.Version()
+	resp.TransactionStates = make([]protocol.DescribeTransactionState, len(req.TransactionalIDs))
+	states := make(map[string]transactionMetadata)
+	for _, m := range b.transactionsState() {
+		states[m.TransactionalID] = m
+	}
+	for i, id := range req.TransactionalIDs {
+		if m, ok := states[id]; ok {
+			resp.TransactionStates[i] = describeTransaction(m)
+			continue
+		}
+		resp.TransactionStates[i] = protocol.DescribeTransactionState{
+			ErrorCode:        protocol.ErrTransactionalIdNotFound.Code(),
+			TransactionalID:  id,
+			TransactionState: protocol.TransactionStateDead,
+			ProducerID:       -1,
+			ProducerEpoch:    -1,
+		}
+	}
+	return resp
+}
+
+// isTransactionState returns whether the state is one transactions can be in.
+func isTransactionState(state string) bool {
+	for _, s := range protocol.TransactionStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Broker) handleCreatePartitions(ctx *Context, req *protocol.CreatePartitionsRequest) *protocol.CreatePartitionsResponse {
+	sp := span(ctx, b.tracer, "create partitions")
+	defer sp.Finish()
+	resp := new(protocol.CreatePartitionsResponse)
+	resp.APIVersion = req.Version()
+	resp.TopicErrorCodes = make([]*protocol.TopicErrorCode, len(req.Topics))
+	isController := b.isController()
+	sp.LogKV("is controller", isController)
+	readOnly := b.readOnly()
+	for i, topic := range req.Topics {
+		if !isController {
+			resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
+				Topic:     topic.Topic,
+				ErrorCode: protocol.ErrNotController.Code(),
+			}
+			continue
+		}
+		if readOnly {
+			resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
+				Topic:     topic.Topic,
+				ErrorCode: errReadOnly.Code(),
+			}
+			continue
+		}
+		err := b.createPartitions(ctx, topic, req.ValidateOnly)
+		resp.TopicErrorCodes[i] = &protocol.TopicErrorCode{
+			Topic:     topic.Topic,
+			ErrorCode: err.Code(),
 		}
 	}
 	return resp
@@ -321,52 +989,76 @@ func (b *Broker) handleLeaderAndISR(ctx *Context, req *protocol.LeaderAndISRRequ
 		}
 	}
 	for i, p := range req.PartitionStates {
-		// TODO: need to replace the replica regardless
-		replica := &Replica{
-			BrokerID: b.config.ID,
-			Partition: structs.Partition{
-				ID:              p.Partition,
-				Partition:       p.Partition,
-				Topic:           p.Topic,
-				ISR:             p.ISR,
-				AR:              p.Replicas,
-				ControllerEpoch: p.ZKVersion,
-				LeaderEpoch:     p.LeaderEpoch,
-				Leader:          p.Leader,
-			},
-			IsLocal: true,
-		}
-		b.replicaLookup.AddReplica(replica)
-
-		if p.Leader == b.config.ID && (replica.Partition.Leader == b.config.ID) {
-			// is command asking this broker to be the new leader for p and this broker is not already the leader for
-
-			if err := b.startReplica(replica); err != protocol.ErrNone {
-				setErr(i, p, err)
-				continue
-			}
-
-			if err := b.becomeLeader(replica, p); err != protocol.ErrNone {
-				setErr(i, p, err)
-				continue
-			}
-		} else if contains(p.Replicas, b.config.ID) && (p.Leader != b.config.ID) {
-			// is command asking this broker to follow leader who it isn't a leader of already
-			if err := b.startReplica(replica); err != protocol.ErrNone {
-				setErr(i, p, err)
-				continue
-			}
-
-			if err := b.becomeFollower(replica, p); err != protocol.ErrNone {
-				setErr(i, p, err)
-				continue
-			}
+		if err := b.changePartitionState(p); err != protocol.ErrNone {
+			setErr(i, p, err)
+			continue
 		}
 		resp.Partitions[i] = &protocol.LeaderAndISRPartition{Partition: p.Partition, Topic: p.Topic, ErrorCode: protocol.ErrNone.Code()}
 	}
 	return resp
 }
 
+// changePartitionState replaces this broker's replica of the partition with one in the state the
+// controller sent, and leads or follows it. The partition's locked so produces to it don't see
+// the state half changed.
+func (b *Broker) changePartitionState(p *protocol.PartitionState) protocol.Error {
+	mu := b.partitionLocks.get(p.Topic, p.Partition)
+	mu.Lock()
+	defer mu.Unlock()
+	// TODO: need to replace the replica regardless
+	replica := &Replica{
+		BrokerID: b.config.ID,
+		Partition: structs.Partition{
+			ID:              p.Partition,
+			Partition:       p.Partition,
+			Topic:           p.Topic,
+			ISR:             p.ISR,
+			AR:              p.Replicas,
+			ControllerEpoch: p.ZKVersion,
+			LeaderEpoch:     p.LeaderEpoch,
+			Leader:          p.Leader,
+		},
+		IsLocal: true,
+	}
+	b.replicaLookup.AddReplica(replica)
+
+	if p.Leader == b.config.ID && (replica.Partition.Leader == b.config.ID) {
+		// is command asking this broker to be the new leader for p and this broker is not already the leader for
+
+		if err := b.startReplica(replica); err != protocol.ErrNone {
+			return err
+		}
+
+		if err := b.becomeLeader(replica, p); err != protocol.ErrNone {
+			return err
+		}
+		switch p.Topic {
+		case TransactionStateTopicName:
+			b.loadTransactions(replica)
+		case OffsetsTopicName:
+			b.loadGroups(replica)
+		}
+	} else if contains(p.Replicas, b.config.ID) && (p.Leader != b.config.ID) {
+		// is command asking this broker to follow leader who it isn't a leader of already.
+		// the coordinators it ran for the partition are given up first, even if it fails to
+		// follow the new leader.
+		switch p.Topic {
+		case TransactionStateTopicName:
+			b.transactions.unload(p.Partition)
+		case OffsetsTopicName:
+			b.unloadGroups(p.Partition)
+		}
+		if err := b.startReplica(replica); err != protocol.ErrNone {
+			return err
+		}
+
+		if err := b.becomeFollower(replica, p); err != protocol.ErrNone {
+			return err
+		}
+	}
+	return protocol.ErrNone
+}
+
 func (b *Broker) handleOffsets(ctx *Context, req *protocol.OffsetsRequest) *protocol.OffsetsResponse {
 	sp := span(ctx, b.tracer, "offsets")
 	defer sp.Finish()
@@ -386,14 +1078,54 @@ func (b *Broker) handleOffsets(ctx *Context, req *protocol.OffsetsRequest) *prot
 				pResp.ErrorCode = protocol.ErrUnknown.Code()
 				continue
 			}
+			// the replica's log isn't open if it couldn't be started, e.g. it was sent the partition
+			// before this broker knew its topic
+			if replica.Log == nil {
+				pResp.ErrorCode = protocol.ErrReplicaNotAvailable.Code()
+				oResp.Responses[i].PartitionResponses = append(oResp.Responses[i].PartitionResponses, pResp)
+				continue
+			}
+			// the replica's offline while its log keeps failing, this also keeps it out of the
+			// isr since the controller checks it's caught up with this
+			if b.breakers.get(t.Topic, p.Partition).isOpen() {
+				pResp.ErrorCode = protocol.ErrKafkaStorageError.Code()
+				oResp.Responses[i].PartitionResponses = append(oResp.Responses[i].PartitionResponses, pResp)
+				continue
+			}
+			// consumers can't see past the high watermark, or the last stable offset for read
+			// committed consumers, followers have the whole log
+			visible := replica.Log.NewestOffset()
+			if req.ReplicaID < 0 {
+				visible = b.followers.highWatermark(replica.Partition, visible)
+			}
+			if protocol.IsolationLevel(req.IsolationLevel) == protocol.ReadCommitted {
+				visible = b.lastStableOffset(t.Topic, p.Partition, visible)
+			}
 			var offset int64
-			if p.Timestamp == -2 {
+			if p.Timestamp >= 0 {
+				var ts int64
+				var ok bool
+				offset, ts, ok = offsetForTimestamp(replica, p.Timestamp)
+				if ok && offset >= visible {
+					offset, ok = -1, false
+				}
+				if ok {
+					pResp.Timestamp = time.Unix(0, ts*int64(time.Millisecond))
+				} else if req.Version() == 0 {
+					oResp.Responses[i].PartitionResponses = append(oResp.Responses[i].PartitionResponses, pResp)
+					continue
+				}
+			} else if p.Timestamp == -2 {
 				offset = replica.Log.OldestOffset()
 			} else {
 				// TODO: this is nil because i'm not sending the leader and isr requests telling the new leader to start the replica and instantiate the log...
-				offset = replica.Log.NewestOffset()
+				offset = visible
+			}
+			if req.Version() == 0 {
+				pResp.Offsets = []int64{offset}
+			} else {
+				pResp.Offset = offset
 			}
-			pResp.Offsets = []int64{offset}
 			oResp.Responses[i].PartitionResponses = append(oResp.Responses[i].PartitionResponses, pResp)
 		}
 	}
@@ -406,10 +1138,22 @@ func (b *Broker) handleProduce(ctx *Context, req *protocol.ProduceRequest) *prot
 	resp := new(protocol.ProduceResponse)
 	resp.APIVersion = req.Version()
 	resp.Responses = make([]*protocol.ProduceTopicResponse, len(req.TopicData))
+	// how long the request waited to be handled, zero if it wasn't read by the server
+	var queue time.Duration
+	if start, ok := ctx.Value(requestStartKey).(time.Time); ok {
+		queue = time.Since(start)
+	}
+	readOnly := b.readOnly()
 	for i, td := range req.TopicData {
 		presps := make([]*protocol.ProducePartitionResponse, len(td.Data))
 		for j, p := range td.Data {
 			presp := &protocol.ProducePartitionResponse{}
+			if readOnly {
+				presp.Partition = p.Partition
+				presp.ErrorCode = errReadOnly.Code()
+				presps[j] = presp
+				continue
+			}
 			state := b.fsm.State()
 			_, t, err := state.GetTopic(td.Topic)
 			if err != nil {
@@ -422,34 +1166,142 @@ func (b *Broker) handleProduce(ctx *Context, req *protocol.ProduceRequest) *prot
 				presps[j] = presp
 				continue
 			}
-			replica, err := b.replicaLookup.Replica(td.Topic, p.Partition)
-			if err != nil || replica == nil || replica.Log == nil {
-				b.logger.Error("produce to partition failed", log.Error("error", err))
-				presp.Partition = p.Partition
-				presp.ErrorCode = protocol.ErrReplicaNotAvailable.Code()
-				presps[j] = presp
-				continue
-			}
-			offset, appendErr := replica.Log.Append(p.RecordSet)
-			if appendErr != nil {
-				b.logger.Error("commitlog/append failed", log.Error("error", err))
-				presp.ErrorCode = protocol.ErrUnknown.Code()
-				presps[j] = presp
-				continue
-			}
-			presp.Partition = p.Partition
-			presp.BaseOffset = offset
-			presp.LogAppendTime = time.Now()
-			presps[j] = presp
+			presps[j] = b.produceToPartition(req, td.Topic, t, p, queue)
 		}
 		resp.Responses[i] = &protocol.ProduceTopicResponse{
 			Topic:              td.Topic,
 			PartitionResponses: presps,
 		}
 	}
+	resp.ThrottleTime = b.throttleProduce(ctx, req)
 	return resp
 }
 
+// produceToPartition appends the partition's batches to its log. The partition's locked while
+// they're checked, appended and recorded so produces to it and changes to its state, like
+// LeaderAndISR requests, are handled one at a time.
+func (b *Broker) produceToPartition(req *protocol.ProduceRequest, topic string, t *structs.Topic, p *protocol.Data, queue time.Duration) *protocol.ProducePartitionResponse {
+	mu := b.partitionLocks.get(topic, p.Partition)
+	mu.Lock()
+	defer mu.Unlock()
+	presp := &protocol.ProducePartitionResponse{}
+	replica, err := b.replicaLookup.Replica(topic, p.Partition)
+	if err != nil || replica == nil || replica.Log == nil {
+		b.logger.Error("produce to partition failed", log.Error("error", err))
+		presp.Partition = p.Partition
+		presp.ErrorCode = protocol.ErrReplicaNotAvailable.Code()
+		return presp
+	}
+	// a leader whose lease ran out may have been deposed without being told
+	if err := b.checkLeaderLease(replica); err != protocol.ErrNone {
+		presp.Partition = p.Partition
+		presp.ErrorCode = err.Code()
+		return presp
+	}
+	// acks=all produces are refused unless the topic's min.insync.replicas are in sync. they're
+	// answered once they're appended here rather than once the high watermark passes them, so
+	// they can still be lost if this broker fails before its followers fetch them.
+	minISR := minInsyncReplicas(t)
+	if req.Acks == -1 && b.inSyncReplicas(replica, time.Now()) < minISR {
+		presp.Partition = p.Partition
+		presp.ErrorCode = protocol.ErrNotEnoughReplicas.Code()
+		return presp
+	}
+	// check the batches before appending since they're written to the log as is
+	if err := protocol.ValidateRecordSet(p.RecordSet); err != protocol.ErrNone {
+		presp.Partition = p.Partition
+		presp.ErrorCode = err.Code()
+		return presp
+	}
+	name, _ := t.Config.GetValue("compression.type").(string)
+	codec, recompress := protocol.CompressionCodec(name)
+	// zstd's only written for clients new enough to fetch it
+	if req.Version() < protocol.ZstdMinProduceVersion && (protocol.HasCompression(p.RecordSet, protocol.CompressionZstd) || recompress && codec == protocol.CompressionZstd) {
+		presp.Partition = p.Partition
+		presp.ErrorCode = protocol.ErrUnsupportedCompressionType.Code()
+		return presp
+	}
+	// batches are re-encoded with the topic's codec, unless it keeps the producer's or
+	// only compresses the batches the producer didn't
+	if recompress {
+		recordSet, err := protocol.Recompress(p.RecordSet, protocol.Recompression{
+			Codec:          codec,
+			Level:          compressionLevel(t.Config, codec),
+			KeepCompressed: t.Config.GetValue("compression.recompression.policy") == "accept",
+		})
+		if err != nil {
+			presp.Partition = p.Partition
+			presp.ErrorCode = protocol.ErrCorruptMessage.Code()
+			return presp
+		}
+		p.RecordSet = recordSet
+	}
+	if max, ok := configInt(t.Config.GetValue("max.message.bytes")); ok && int64(len(p.RecordSet)) > max {
+		presp.Partition = p.Partition
+		presp.ErrorCode = protocol.ErrMessageTooLarge.Code()
+		return presp
+	}
+	// retries of batches idempotent producers already appended are answered with the
+	// offset they were appended at rather than appended again
+	duplicateOffset, seqErr := b.producers.check(topic, p.Partition, p.RecordSet)
+	if seqErr != protocol.ErrNone {
+		presp.Partition = p.Partition
+		presp.ErrorCode = seqErr.Code()
+		return presp
+	}
+	if duplicateOffset >= 0 {
+		presp.Partition = p.Partition
+		presp.BaseOffset = duplicateOffset
+		presp.LogStartOffset = replica.Log.OldestOffset()
+		return presp
+	}
+	cb := b.breakers.get(topic, p.Partition)
+	if !cb.allow() {
+		presp.Partition = p.Partition
+		presp.ErrorCode = protocol.ErrKafkaStorageError.Code()
+		return presp
+	}
+	// the log append time's stamped into the batches so consumers see it too
+	var logAppendTime time.Time
+	if t.Config.GetValue("message.timestamp.type") == "LogAppendTime" {
+		logAppendTime = time.Now()
+		protocol.SetLogAppendTime(p.RecordSet, logAppendTime)
+	}
+	// followers record the epochs of the batches they replicate to find where their logs
+	// diverge from a new leader's
+	protocol.SetPartitionLeaderEpoch(p.RecordSet, replica.Partition.LeaderEpoch)
+	offset, timing, appendErr := appendTimed(replica.Log, p.RecordSet)
+	if appendErr != nil {
+		b.logger.Error("commitlog/append failed", log.Error("error", appendErr))
+		b.logFailed(topic, p.Partition, appendErr)
+		presp.Partition = p.Partition
+		presp.ErrorCode = protocol.ErrKafkaStorageError.Code()
+		return presp
+	}
+	cb.success()
+	b.metrics.produceAppended(topic, p.Partition, queue, timing)
+	if req.Acks == -1 {
+		b.replicationWaits.add(topic, p.Partition, replica.Log.NewestOffset(), time.Now())
+	}
+	b.producers.update(topic, p.Partition, offset, p.RecordSet)
+	b.orderingAudit.appended(topic, p.Partition, p.RecordSet)
+	b.txnIndexes.update(topic, p.Partition, p.RecordSet)
+	b.intercept(topic, p.Partition, p.RecordSet)
+	b.appendCallbacks.start(topic, p.Partition, offset)
+	b.commitAppends(replica)
+	presp.Partition = p.Partition
+	presp.BaseOffset = offset
+	presp.LogStartOffset = replica.Log.OldestOffset()
+	presp.LogAppendTime = logAppendTime
+	// the batch is appended but followers fell behind while it was, so there may be too few
+	// replicas to replicate it. idempotent producers' retries are answered with the offset it was
+	// appended at.
+	if req.Acks == -1 && b.inSyncReplicas(replica, time.Now()) < minISR {
+		presp.ErrorCode = protocol.ErrNotEnoughReplicasAfterAppend.Code()
+	}
+	return presp
+}
+
 func (b *Broker) handleMetadata(ctx *Context, req *protocol.MetadataRequest) *protocol.MetadataResponse {
 	sp := span(ctx, b.tracer, "metadata")
 	defer sp.Finish()
@@ -479,18 +1331,28 @@ func (b *Broker) handleMetadata(ctx *Context, req *protocol.MetadataRequest) *pr
 		if !ok {
 			continue
 		}
-		brokers = append(brokers, &protocol.Broker{
+		broker := &protocol.Broker{
 			NodeID: m.ID.Int32(),
 			Host:   m.Host(),
 			Port:   m.Port(),
-		})
+		}
+		if m.Rack != "" {
+			rack := m.Rack
+			broker.Rack = &rack
+		}
+		brokers = append(brokers, broker)
+	}
+	alive := make(map[int32]bool, len(brokers))
+	for _, broker := range brokers {
+		alive[broker.NodeID] = true
 	}
 	var topicMetadata []*protocol.TopicMetadata
 	topicMetadataFn := func(topic *structs.Topic, err protocol.Error) *protocol.TopicMetadata {
 		if err != protocol.ErrNone {
 			return &protocol.TopicMetadata{
-				TopicErrorCode: err.Code(),
-				Topic:          topic.Topic,
+				TopicErrorCode:            err.Code(),
+				Topic:                     topic.Topic,
+				TopicAuthorizedOperations: protocol.AuthorizedOperationsOmitted,
 			}
 		}
 		partitionMetadata := make([]*protocol.PartitionMetadata, 0, len(topic.Partitions))
@@ -510,21 +1372,30 @@ func (b *Broker) handleMetadata(ctx *Context, req *protocol.MetadataRequest) *pr
 				})
 				continue
 			}
+			var offline []int32
+			for _, id := range p.AR {
+				if !alive[id] {
+					offline = append(offline, id)
+				}
+			}
 			partitionMetadata = append(partitionMetadata, &protocol.PartitionMetadata{
 				PartitionID:        p.ID,
 				PartitionErrorCode: protocol.ErrNone.Code(),
 				Leader:             p.Leader,
+				LeaderEpoch:        p.LeaderEpoch,
 				Replicas:           p.AR,
 				ISR:                p.ISR,
+				OfflineReplicas:    offline,
 			})
 		}
 		return &protocol.TopicMetadata{
-			TopicErrorCode:    protocol.ErrNone.Code(),
-			Topic:             topic.Topic,
-			PartitionMetadata: partitionMetadata,
+			TopicErrorCode:            protocol.ErrNone.Code(),
+			Topic:                     topic.Topic,
+			PartitionMetadata:         partitionMetadata,
+			TopicAuthorizedOperations: protocol.AuthorizedOperationsOmitted,
 		}
 	}
-	if len(req.Topics) == 0 {
+	if req.Topics == nil {
 		// Respond with metadata for all topics
 		// how to handle err here?
 		_, topics, _ := state.GetTopics()
@@ -547,7 +1418,14 @@ func (b *Broker) handleMetadata(ctx *Context, req *protocol.MetadataRequest) *pr
 	}
 	resp := &protocol.MetadataResponse{
 		Brokers:       brokers,
+		ControllerID:  b.controllerID(),
 		TopicMetadata: topicMetadata,
+		// authorized operations aren't sent since brokers don't authorize requests
+		ClusterAuthorizedOperations: protocol.AuthorizedOperationsOmitted,
+	}
+	if b.config.ClusterID != "" {
+		clusterID := b.config.ClusterID
+		resp.ClusterID = &clusterID
 	}
 	resp.APIVersion = req.Version()
 	return resp
@@ -560,6 +1438,15 @@ func (b *Broker) handleFindCoordinator(ctx *Context, req *protocol.FindCoordinat
 	resp := &protocol.FindCoordinatorResponse{}
 	resp.APIVersion = req.Version()
 
+	switch req.CoordinatorType {
+	case protocol.CoordinatorGroup:
+	case protocol.CoordinatorTransaction:
+		return b.findTransactionCoordinator(ctx, resp, req.CoordinatorKey)
+	default:
+		resp.ErrorCode = protocol.ErrInvalidRequest.Code()
+		return resp
+	}
+
 	// TODO: distribute this.
 	state := b.fsm.State()
 
@@ -571,12 +1458,21 @@ func (b *Broker) handleFindCoordinator(ctx *Context, req *protocol.FindCoordinat
 	if err != nil {
 		goto ERROR
 	}
-	i = int32(util.Hash(req.CoordinatorKey) % uint64(len(topic.Partitions)))
+	i = offsetsPartition(req.CoordinatorKey, len(topic.Partitions))
 	_, p, err = state.GetPartition(OffsetsTopicName, i)
 	if err != nil {
 		goto ERROR
 	}
+	if p == nil {
+		resp.ErrorCode = protocol.ErrCoordinatorNotAvailable.Code()
+		return resp
+	}
+	// the partition's offline while its leader fails over, clients retry until there's a new one
 	broker = b.brokerLookup.BrokerByID(raft.ServerID(p.Leader))
+	if broker == nil {
+		resp.ErrorCode = protocol.ErrCoordinatorNotAvailable.Code()
+		return resp
+	}
 
 	resp.Coordinator.NodeID = broker.ID.Int32()
 	resp.Coordinator.Host = broker.Host()
@@ -608,6 +1504,20 @@ func (b *Broker) handleJoinGroup(ctx *Context, r *protocol.JoinGroupRequest) *pr
 		resp.ErrorCode = protocol.ErrUnknown.Code()
 		return resp
 	}
+	if perr := b.groupCoordinator(r.GroupID, group); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
+	sessionTimeout := time.Duration(r.SessionTimeout) * time.Millisecond
+	if sessionTimeout < b.config.GroupMinSessionTimeout || sessionTimeout > b.config.GroupMaxSessionTimeout {
+		resp.ErrorCode = protocol.ErrInvalidSessionTimeout.Code()
+		return resp
+	}
+	// v0 clients rejoin within their session timeout
+	rebalanceTimeout := sessionTimeout
+	if r.Version() >= 1 {
+		rebalanceTimeout = time.Duration(r.RebalanceTimeout) * time.Millisecond
+	}
 	if group == nil {
 		// group doesn't exist so let's create it
 		group = &structs.Group{
@@ -615,14 +1525,79 @@ func (b *Broker) handleJoinGroup(ctx *Context, r *protocol.JoinGroupRequest) *pr
 			Coordinator: b.config.ID,
 		}
 	}
+	group = group.Copy()
+	// the group's taken over if it was registered before its offsets partition moved here
+	group.Coordinator = b.config.ID
+	before := memberAssignments(group)
+	if len(group.Members) == 0 {
+		// the first member picks the group's protocol type
+		group.ProtocolType = r.ProtocolType
+		group.Protocol = ""
+	}
+	if r.ProtocolType != group.ProtocolType {
+		resp.ErrorCode = protocol.ErrInconsistentGroupProtocol.Code()
+		return resp
+	}
+	instanceID := groupInstanceID(r.GroupInstanceID)
+	rejoined := r.MemberID != ""
+	// replaced is the member a restarted static member takes the place of
+	var replaced string
 	if r.MemberID == "" {
 		// for group member IDs -- can replace with something else
 		r.MemberID = uuid.NewV1().String()
-		group.Members[r.MemberID] = structs.Member{ID: r.MemberID}
+		if id, ok := staticMember(group, instanceID); instanceID != "" && ok {
+			// the static member keeps its assignment and leadership under its new id, its old
+			// id's fenced
+			replaced = id
+			group.Members[r.MemberID] = group.Members[id]
+			delete(group.Members, id)
+			if group.LeaderID == id {
+				group.LeaderID = r.MemberID
+			}
+		}
+	} else if perr := checkMember(group, r.MemberID, instanceID); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
+	if _, ok := group.Members[r.MemberID]; !ok && b.config.GroupMaxSize > 0 && len(group.Members) >= b.config.GroupMaxSize {
+		resp.ErrorCode = protocol.ErrGroupMaxSizeReached.Code()
+		return resp
 	}
+	member := group.Members[r.MemberID]
+	metadata := member.Metadata
+	member.ID = r.MemberID
+	member.InstanceID = instanceID
+	member.ClientHost = clientHost(ctx)
+	member.SessionTimeout = sessionTimeout
+	member.RebalanceTimeout = rebalanceTimeout
+	if header := ctx.Header(); header != nil {
+		member.ClientID = header.ClientID
+	}
+	member.Protocols = make([]structs.MemberProtocol, 0, len(r.GroupProtocols))
+	for _, p := range r.GroupProtocols {
+		member.Protocols = append(member.Protocols, structs.MemberProtocol{Name: p.ProtocolName, Metadata: p.ProtocolMetadata})
+	}
+	group.Members[r.MemberID] = member
+	name, ok := groupProtocol(group)
+	if !ok {
+		resp.ErrorCode = protocol.ErrInconsistentGroupProtocol.Code()
+		return resp
+	}
+	// a static member restarting with the same metadata doesn't rebalance a stable group
+	rebalance := replaced == "" || group.State != structs.GroupStateStable || name != group.Protocol
+	setGroupProtocol(group, name)
+	rebalance = rebalance || !bytes.Equal(group.Members[r.MemberID].Metadata, metadata)
 	if group.LeaderID == "" {
 		group.LeaderID = r.MemberID
 	}
+	if rebalance {
+		// members wait for the leader's assignments
+		group.State = structs.GroupStateCompletingRebalance
+	}
+	if perr := b.writeGroup(group); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
 	_, err = b.raftApply(structs.RegisterGroupRequestType, structs.RegisterGroupRequest{
 		Group: *group,
 	})
@@ -631,12 +1606,22 @@ func (b *Broker) handleJoinGroup(ctx *Context, r *protocol.JoinGroupRequest) *pr
 		resp.ErrorCode = protocol.ErrUnknown.Code()
 		return resp
 	}
+	b.sessions.heard(group.Group, r.MemberID, time.Now())
+	if replaced != "" {
+		b.sessions.remove(group.Group, replaced)
+	}
+	if rebalance {
+		b.rebalances.joined(group.Group, r.MemberID, rejoined || replaced != "", before)
+	} else {
+		b.logger.Info("static member rejoined", log.String("group", group.Group), log.String("instance id", instanceID), log.String("member", r.MemberID), log.String("replaced", replaced))
+	}
 
 	resp.GenerationID = 0
+	resp.GroupProtocol = group.Protocol
 	resp.LeaderID = group.LeaderID
 	resp.MemberID = r.MemberID
 	for _, m := range group.Members {
-		resp.Members = append(resp.Members, protocol.Member{MemberID: m.ID, MemberMetadata: m.Metadata})
+		resp.Members = append(resp.Members, protocol.Member{MemberID: m.ID, GroupInstanceID: instanceIDOf(m), MemberMetadata: m.Metadata})
 	}
 
 	return resp
@@ -657,26 +1642,73 @@ func (b *Broker) handleLeaveGroup(ctx *Context, r *protocol.LeaveGroupRequest) *
 		resp.ErrorCode = protocol.ErrUnknown.Code()
 		return resp
 	}
+	if perr := b.groupCoordinator(r.GroupID, group); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
 	if group == nil {
 		resp.ErrorCode = protocol.ErrInvalidGroupId.Code()
 		return resp
 	}
-	if _, ok := group.Members[r.MemberID]; !ok {
-		resp.ErrorCode = protocol.ErrUnknownMemberId.Code()
+
+	group = group.Copy()
+	before := memberAssignments(group)
+	members := r.Members
+	if r.Version() < 3 {
+		members = []protocol.LeaveGroupMember{{MemberID: r.MemberID}}
+	}
+	var left []string
+	for _, m := range members {
+		id, perr := leavingMember(group, m)
+		if r.Version() < 3 && perr != protocol.ErrNone {
+			resp.ErrorCode = perr.Code()
+			return resp
+		}
+		if r.Version() >= 3 {
+			resp.Members = append(resp.Members, protocol.LeaveGroupMemberResponse{
+				MemberID:        m.MemberID,
+				GroupInstanceID: m.GroupInstanceID,
+				ErrorCode:       perr.Code(),
+			})
+		}
+		if perr == protocol.ErrNone {
+			delete(group.Members, id)
+			left = append(left, id)
+		}
+	}
+	if len(left) == 0 {
+		return resp
+	}
+	if perr := b.saveMembersLeft(group, before, left); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
 		return resp
 	}
 
-	delete(group.Members, r.MemberID)
+	return resp
+}
 
-	_, err = b.raftApply(structs.RegisterGroupRequestType, structs.RegisterGroupRequest{
+// saveMembersLeft saves the group once the members that left, by leaving or timing out, have been
+// removed from it, emptying it if they were the last. before is the members' assignments from
+// before they were removed.
+func (b *Broker) saveMembersLeft(group *structs.Group, before map[string][]byte, left []string) protocol.Error {
+	if len(group.Members) == 0 {
+		group.State = structs.GroupStateEmpty
+		group.LeaderID = ""
+	}
+	if perr := b.writeGroup(group); perr != protocol.ErrNone {
+		return perr
+	}
+	_, err := b.raftApply(structs.RegisterGroupRequestType, structs.RegisterGroupRequest{
 		Group: *group,
 	})
 	if err != nil {
-		resp.ErrorCode = protocol.ErrUnknown.Code()
-		return resp
+		return protocol.ErrUnknown.WithErr(err)
 	}
-
-	return resp
+	for i, id := range left {
+		b.sessions.remove(group.Group, id)
+		b.rebalances.left(group.Group, group.ProtocolType, id, before, len(group.Members) == 0 && i == len(left)-1)
+	}
+	return protocol.ErrNone
 }
 
 func (b *Broker) handleSyncGroup(ctx *Context, r *protocol.SyncGroupRequest) *protocol.SyncGroupResponse {
@@ -692,18 +1724,35 @@ func (b *Broker) handleSyncGroup(ctx *Context, r *protocol.SyncGroupRequest) *pr
 		resp.ErrorCode = protocol.ErrUnknown.Code()
 		return resp
 	}
+	if perr := b.groupCoordinator(r.GroupID, group); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
 	if group == nil {
 		resp.ErrorCode = protocol.ErrInvalidGroupId.Code()
 		return resp
 	}
+	if perr := checkMember(group, r.MemberID, groupInstanceID(r.GroupInstanceID)); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
+	b.sessions.heard(group.Group, r.MemberID, time.Now())
 	if group.LeaderID == r.MemberID {
 		// take the assignments from the leader and save them
+		group = group.Copy()
 		for _, ga := range r.GroupAssignments {
-			if m, ok := group.Members[ga.MemberID]; ok {
-				m.Assignment = ga.MemberAssignment
-			} else {
-				panic("sync group: unknown member")
+			m, ok := group.Members[ga.MemberID]
+			if !ok {
+				resp.ErrorCode = protocol.ErrUnknownMemberId.Code()
+				return resp
 			}
+			m.Assignment = ga.MemberAssignment
+			group.Members[ga.MemberID] = m
+		}
+		group.State = structs.GroupStateStable
+		if perr := b.writeGroup(group); perr != protocol.ErrNone {
+			resp.ErrorCode = perr.Code()
+			return resp
 		}
 		_, err = b.raftApply(structs.RegisterGroupRequestType, structs.RegisterGroupRequest{
 			Group: *group,
@@ -712,13 +1761,9 @@ func (b *Broker) handleSyncGroup(ctx *Context, r *protocol.SyncGroupRequest) *pr
 			resp.ErrorCode = protocol.ErrUnknown.Code()
 			return resp
 		}
-	} else {
-		if m, ok := group.Members[r.MemberID]; ok {
-			resp.MemberAssignment = m.Assignment
-		} else {
-			panic("sync group: unknown member")
-		}
+		b.rebalances.synced(group.Group, group.ProtocolType, memberAssignments(group))
 	}
+	resp.MemberAssignment = group.Members[r.MemberID].Assignment
 
 	return resp
 }
@@ -736,10 +1781,21 @@ func (b *Broker) handleHeartbeat(ctx *Context, r *protocol.HeartbeatRequest) *pr
 		resp.ErrorCode = protocol.ErrUnknown.Code()
 		return resp
 	}
+	if perr := b.groupCoordinator(r.GroupID, group); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
 	if group == nil {
 		resp.ErrorCode = protocol.ErrInvalidGroupId.Code()
 		return resp
 	}
+	// members that timed out rejoin, and static members' old instances are fenced once they've
+	// restarted
+	if perr := checkMember(group, r.MemberID, groupInstanceID(r.GroupInstanceID)); perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
+	b.sessions.heard(group.Group, r.MemberID, time.Now())
 	// TODO: need to handle case when rebalance is in process
 
 	resp.ErrorCode = protocol.ErrNone.Code()
@@ -750,10 +1806,21 @@ func (b *Broker) handleHeartbeat(ctx *Context, r *protocol.HeartbeatRequest) *pr
 func (b *Broker) handleFetch(ctx *Context, r *protocol.FetchRequest) *protocol.FetchResponse {
 	sp := span(ctx, b.tracer, "fetch")
 	defer sp.Finish()
-	fresp := &protocol.FetchResponse{
-		Responses: make(protocol.FetchTopicResponses, len(r.Topics)),
-	}
+	fresp := &protocol.FetchResponse{}
 	fresp.APIVersion = r.Version()
+	// fetch sessions aren't kept: consumers asking for a new one get a session id of 0 and carry
+	// on with full fetches, and incremental fetches of a session are refused
+	if r.Version() >= 7 {
+		switch {
+		case r.SessionID != 0:
+			fresp.ErrorCode = protocol.ErrFetchSessionIDNotFound.Code()
+			return fresp
+		case r.SessionEpoch != 0 && r.SessionEpoch != -1:
+			fresp.ErrorCode = protocol.ErrInvalidFetchSessionEpoch.Code()
+			return fresp
+		}
+	}
+	fresp.Responses = make(protocol.FetchTopicResponses, len(r.Topics))
 	received := time.Now()
 	for i, topic := range r.Topics {
 		fr := &protocol.FetchTopicResponse{
@@ -776,6 +1843,24 @@ func (b *Broker) handleFetch(ctx *Context, r *protocol.FetchRequest) *protocol.F
 				}
 				continue
 			}
+			if r.ReplicaID < 0 {
+				if err := b.checkLeaderLease(replica); err != protocol.ErrNone {
+					fr.PartitionResponses[j] = &protocol.FetchPartitionResponse{
+						Partition: p.Partition,
+						ErrorCode: err.Code(),
+					}
+					continue
+				}
+			}
+			if r.Version() >= 9 {
+				if err := checkLeaderEpoch(p.CurrentLeaderEpoch, replica.Partition.LeaderEpoch); err != protocol.ErrNone {
+					fr.PartitionResponses[j] = &protocol.FetchPartitionResponse{
+						Partition: p.Partition,
+						ErrorCode: err.Code(),
+					}
+					continue
+				}
+			}
 			if replica.Log == nil {
 				fr.PartitionResponses[j] = &protocol.FetchPartitionResponse{
 					Partition: p.Partition,
@@ -783,120 +1868,401 @@ func (b *Broker) handleFetch(ctx *Context, r *protocol.FetchRequest) *protocol.F
 				}
 				continue
 			}
-			rdr, rdrErr := replica.Log.NewReader(p.FetchOffset, p.MaxBytes)
-			if rdrErr != nil {
+			// followers fetch from the offset they've replicated up to, clients' replica id is -1
+			if r.ReplicaID >= 0 {
+				b.followers.update(topic.Topic, p.Partition, r.ReplicaID, p.FetchOffset, replica.Log.NewestOffset(), time.Now())
+				b.commitAppends(replica)
+			}
+			if p.FetchOffset < replica.Log.OldestOffset() || p.FetchOffset > replica.Log.NewestOffset() {
 				fr.PartitionResponses[j] = &protocol.FetchPartitionResponse{
 					Partition: p.Partition,
-					ErrorCode: protocol.ErrUnknown.Code(),
+					ErrorCode: protocol.ErrOffsetOutOfRange.Code(),
 				}
 				continue
 			}
-			buf := new(bytes.Buffer)
-			var n int32
-			for n < r.MinBytes {
-				if r.MaxWaitTime != 0 && int32(time.Since(received).Nanoseconds()/1e6) > r.MaxWaitTime {
-					break
-				}
-				// TODO: copy these bytes to outer bytes
-				nn, err := io.Copy(buf, rdr)
-				if err != nil && err != io.EOF {
+			// fetches at the log end offset, like those of followers that have caught up, get no
+			// messages until more are appended
+			var recordSet []byte
+			if p.FetchOffset < replica.Log.NewestOffset() {
+				var readErr protocol.Error
+				recordSet, readErr = b.readFetch(topic.Topic, replica, r, p, received)
+				if readErr != protocol.ErrNone {
 					fr.PartitionResponses[j] = &protocol.FetchPartitionResponse{
 						Partition: p.Partition,
-						ErrorCode: protocol.ErrUnknown.Code(),
+						ErrorCode: readErr.Code(),
 					}
-					break
+					continue
 				}
-				n += int32(nn)
-				if err == io.EOF {
-					break
+			}
+			// the messages the isr has replicated are committed, consumers only get those
+			hw := b.followers.highWatermark(replica.Partition, replica.Log.NewestOffset())
+			if r.ReplicaID < 0 {
+				recordSet = truncateRecordSet(recordSet, hw)
+			}
+			// the offsets before the first of the earliest ongoing transaction are stable
+			stable := b.lastStableOffset(topic.Topic, p.Partition, hw)
+			var aborted []*protocol.AbortedTransaction
+			if r.IsolationLevel == protocol.ReadCommitted {
+				// read committed consumers only get the stable messages, and skip the batches
+				// of the aborted transactions
+				recordSet = truncateRecordSet(recordSet, stable)
+				aborted = b.txnIndexes.abortedTransactions(topic.Topic, p.Partition, p.FetchOffset, stable)
+			}
+			// consumers too old to read zstd compressed batches can't fetch them, followers
+			// replicate them whatever version they fetch with
+			if r.ReplicaID < 0 && r.Version() < protocol.ZstdMinFetchVersion && protocol.HasCompression(recordSet, protocol.CompressionZstd) {
+				fr.PartitionResponses[j] = &protocol.FetchPartitionResponse{
+					Partition: p.Partition,
+					ErrorCode: protocol.ErrUnsupportedCompressionType.Code(),
 				}
+				continue
 			}
 			fr.PartitionResponses[j] = &protocol.FetchPartitionResponse{
-				Partition:     p.Partition,
-				ErrorCode:     protocol.ErrNone.Code(),
-				HighWatermark: replica.Log.NewestOffset() - 1,
-				RecordSet:     buf.Bytes(),
+				Partition:           p.Partition,
+				ErrorCode:           protocol.ErrNone.Code(),
+				HighWatermark:       hw - 1,
+				LastStableOffset:    stable - 1,
+				LogStartOffset:      replica.Log.OldestOffset(),
+				AbortedTransactions: aborted,
+				RecordSet:           recordSet,
+			}
+		}
+		if r.Version() >= 11 {
+			// consumers always fetch from the leaders rather than the replicas near them
+			for _, p := range fr.PartitionResponses {
+				p.PreferredReadReplica = -1
 			}
 		}
 		fresp.Responses[i] = fr
 	}
+	if r.ReplicaID < 0 {
+		fresp.ThrottleTime = b.throttleFetch(ctx, fresp)
+	}
 	return fresp
 }
 
-func (b *Broker) handleSaslHandshake(ctx *Context, req *protocol.SaslHandshakeRequest) *protocol.SaslHandshakeResponse {
-	panic("not implemented: sasl handshake")
-	return nil
+// readFetch reads the messages the fetch asks for from the partition's log, from an offset in it.
+// They're copied from the segments through a buffer on every platform rather than sent from the
+// files with sendfile, so fetches work the same where it isn't available, like windows and macOS.
+func (b *Broker) readFetch(topic string, replica *Replica, r *protocol.FetchRequest, p *protocol.FetchPartition, received time.Time) ([]byte, protocol.Error) {
+	cb := b.breakers.get(topic, p.Partition)
+	if !cb.allow() {
+		return nil, protocol.ErrKafkaStorageError
+	}
+	rdr, rdrErr := replica.Log.NewReader(p.FetchOffset, p.MaxBytes)
+	if rdrErr != nil {
+		// the log's fine if the offset just isn't in it
+		if rdrErr == commitlog.ErrSegmentNotFound {
+			cb.success()
+			return nil, protocol.ErrUnknown
+		}
+		b.logFailed(topic, p.Partition, rdrErr)
+		return nil, protocol.ErrKafkaStorageError
+	}
+	// followers' fetches are limited to whole batches within their max bytes, they raise it
+	// if the first batch doesn't fit. Clients get what's there.
+	limited := r.ReplicaID >= 0 && p.MaxBytes > 0
+	if limited {
+		rdr = io.LimitReader(rdr, int64(p.MaxBytes))
+	}
+	buf := new(bytes.Buffer)
+	var n int32
+	var readErr error
+	for n < r.MinBytes && (!limited || n < p.MaxBytes) {
+		if r.MaxWaitTime != 0 && int32(time.Since(received).Nanoseconds()/1e6) > r.MaxWaitTime {
+			break
+		}
+		// TODO: copy these bytes to outer bytes
+		nn, err := io.Copy(buf, rdr)
+		if err != nil && err != io.EOF {
+			readErr = err
+			break
+		}
+		n += int32(nn)
+		if err == io.EOF {
+			break
+		}
+	}
+	if readErr != nil {
+		b.logFailed(topic, p.Partition, readErr)
+		return nil, protocol.ErrKafkaStorageError
+	}
+	cb.success()
+	recordSet := buf.Bytes()
+	if limited {
+		// drop the batch cut off at the max bytes
+		recordSet = truncateRecordSet(recordSet, math.MaxInt64)
+		if len(recordSet) == 0 && buf.Len() != 0 {
+			return nil, protocol.ErrMessageTooLarge
+		}
+	}
+	return recordSet, protocol.ErrNone
 }
 
 func (b *Broker) handleListGroups(ctx *Context, req *protocol.ListGroupsRequest) *protocol.ListGroupsResponse {
-	sp := span(ctx, b.tracer, "create topic")
+	sp := span(ctx, b.tracer, "list groups")
 	defer sp.Finish()
 	resp := new(protocol.ListGroupsResponse)
 	resp.APIVersion = req.Version()
 	state := b.fsm.State()
 
-	fmt.Println("list")
-	fmt.Println("list")
-	fmt.Println("list")
-
-	_, groups, err := state.GetGroups()
+	// brokers list the groups they coordinate, admin clients ask every broker to list them all
+	_, groups, err := state.GetGroupsByCoordinator(b.config.ID)
 	if err != nil {
 		resp.ErrorCode = protocol.ErrUnknown.Code()
 		return resp
 	}
-	for _, group := range groups {
-		resp.Groups = append(resp.Groups, protocol.ListGroup{
-			GroupID: group.Group,
-			// TODO: add protocol type
-			ProtocolType: "consumer",
-		})
+	for _, group := range groups {
+		groupState := group.State
+		if groupState == "" {
+			groupState = structs.GroupStateEmpty
+		}
+		if !matchesGroupState(req.StatesFilter, groupState) {
+			continue
+		}
+		resp.Groups = append(resp.Groups, protocol.ListGroup{
+			GroupID:      group.Group,
+			ProtocolType: group.ProtocolType,
+			GroupState:   groupState,
+		})
+	}
+	return resp
+}
+
+// matchesGroupState returns whether the group state is one of the filter's states, which are
+// matched ignoring case like Kafka's. Every state matches an empty filter.
+func matchesGroupState(filter []string, state string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, s := range filter {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Broker) handleDescribeGroups(ctx *Context, req *protocol.DescribeGroupsRequest) *protocol.DescribeGroupsResponse {
+	sp := span(ctx, b.tracer, "describe groups")
+	defer sp.Finish()
+	resp := new(protocol.DescribeGroupsResponse)
+	resp.APIVersion = req.Version()
+	state := b.fsm.State()
+
+	for _, id := range req.GroupIDs {
+		group := protocol.Group{GroupID: id}
+		_, g, err := state.GetGroup(id)
+		coordinatorErr := b.groupCoordinator(id, g)
+		switch {
+		case err != nil:
+			group.ErrorCode = protocol.ErrUnknown.Code()
+		case coordinatorErr != protocol.ErrNone:
+			group.ErrorCode = coordinatorErr.Code()
+		case g == nil:
+			// like Kafka, groups that don't exist are described as dead rather than erroring
+			group.ErrorCode = protocol.ErrNone.Code()
+			group.State = structs.GroupStateDead
+		default:
+			group.ErrorCode = protocol.ErrNone.Code()
+			group.State = g.State
+			if group.State == "" {
+				group.State = structs.GroupStateEmpty
+			}
+			group.ProtocolType = g.ProtocolType
+			group.Protocol = g.Protocol
+			group.GroupMembers = make(map[string]*protocol.GroupMember, len(g.Members))
+			for memberID, member := range g.Members {
+				group.GroupMembers[memberID] = &protocol.GroupMember{
+					ClientID:              member.ClientID,
+					ClientHost:            member.ClientHost,
+					GroupMemberMetadata:   member.Metadata,
+					GroupMemberAssignment: member.Assignment,
+				}
+			}
+		}
+		resp.Groups = append(resp.Groups, group)
+	}
+
+	return resp
+}
+
+func (b *Broker) handleDeleteGroups(ctx *Context, req *protocol.DeleteGroupsRequest) *protocol.DeleteGroupsResponse {
+	sp := span(ctx, b.tracer, "delete groups")
+	defer sp.Finish()
+	resp := new(protocol.DeleteGroupsResponse)
+	resp.APIVersion = req.Version()
+	state := b.fsm.State()
+	readOnly := b.readOnly()
+
+	for _, id := range req.GroupsNames {
+		result := protocol.DeleteGroupsResult{GroupID: id}
+		_, group, err := state.GetGroup(id)
+		coordinatorErr := b.groupCoordinator(id, group)
+		switch {
+		case err != nil:
+			result.ErrorCode = protocol.ErrUnknown.Code()
+		case coordinatorErr != protocol.ErrNone:
+			result.ErrorCode = coordinatorErr.Code()
+		case group == nil:
+			result.ErrorCode = protocol.ErrGroupIdNotFound.Code()
+		case readOnly:
+			result.ErrorCode = errReadOnly.Code()
+		case len(group.Members) > 0:
+			result.ErrorCode = protocol.ErrNonEmptyGroup.Code()
+		default:
+			result.ErrorCode = b.deleteGroup(group).Code()
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	return resp
+}
+
+// deleteGroup deletes the group and its committed offsets if it's empty. They're deleted from the
+// store first as members could have joined since, and then tombstoned in the offsets topic.
+func (b *Broker) deleteGroup(group *structs.Group) protocol.Error {
+	id := group.Group
+	if _, err := b.groupReplica(id); err != protocol.ErrNone {
+		return err
+	}
+	res, err := b.raftApply(structs.DeregisterGroupRequestType, structs.DeregisterGroupRequest{Group: id})
+	if err == nil {
+		err, _ = res.(error)
+	}
+	switch err {
+	case nil:
+		b.logger.Info("deleted group", log.String("group", id))
+		if err := b.writeGroupTombstones(group); err != protocol.ErrNone {
+			b.logger.Error("failed to write group tombstones", log.String("group", id), log.Error("error", err))
+		}
+		b.rebalances.remove(id)
+		return protocol.ErrNone
+	case fsm.ErrNonEmptyGroup:
+		return protocol.ErrNonEmptyGroup
+	default:
+		b.logger.Error("failed to delete group", log.String("group", id), log.Error("error", err))
+		return protocol.ErrUnknown.WithErr(err)
+	}
+}
+
+func (b *Broker) handleOffsetDelete(ctx *Context, req *protocol.OffsetDeleteRequest) *protocol.OffsetDeleteResponse {
+	sp := span(ctx, b.tracer, "offset delete")
+	defer sp.Finish()
+	resp := new(protocol.OffsetDeleteResponse)
+	resp.APIVersion = req.Version()
+	state := b.fsm.State()
+
+	_, group, err := state.GetGroup(req.GroupID)
+	coordinatorErr := b.groupCoordinator(req.GroupID, group)
+	switch {
+	case err != nil:
+		resp.ErrorCode = protocol.ErrUnknown.Code()
+		return resp
+	case coordinatorErr != protocol.ErrNone:
+		resp.ErrorCode = coordinatorErr.Code()
+		return resp
+	case group == nil:
+		resp.ErrorCode = protocol.ErrGroupIdNotFound.Code()
+		return resp
+	case b.readOnly():
+		resp.ErrorCode = errReadOnly.Code()
+		return resp
+	case len(group.Members) > 0 && group.ProtocolType != protocol.ConsumerProtocolType:
+		// only consumer groups' subscriptions are known, so other groups' offsets could all be
+		// in use
+		resp.ErrorCode = protocol.ErrNonEmptyGroup.Code()
+		return resp
+	}
+	subscribed := subscribedTopics(group)
+
+	deleted := make(map[string][]int32)
+	for _, t := range req.Topics {
+		tresp := protocol.OffsetDeleteTopicResponse{Topic: t.Topic}
+		_, topic, err := state.GetTopic(t.Topic)
+		for _, p := range t.Partitions {
+			presp := protocol.OffsetDeletePartitionResponse{Partition: p}
+			switch {
+			case err != nil:
+				presp.ErrorCode = protocol.ErrUnknown.Code()
+			case topic == nil, topic.Partitions[p] == nil:
+				presp.ErrorCode = protocol.ErrUnknownTopicOrPartition.Code()
+			case subscribed[t.Topic]:
+				presp.ErrorCode = protocol.ErrGroupSubscribedToTopic.Code()
+			default:
+				deleted[t.Topic] = append(deleted[t.Topic], p)
+			}
+			tresp.Partitions = append(tresp.Partitions, presp)
+		}
+		resp.Topics = append(resp.Topics, tresp)
+	}
+	if len(deleted) == 0 {
+		return resp
+	}
+
+	if perr := b.removeOffsets(req.GroupID, deleted); perr != protocol.ErrNone {
+		b.logger.Error("failed to delete offsets", log.String("group", req.GroupID), log.Error("error", perr))
+		for i, t := range resp.Topics {
+			for j, p := range t.Partitions {
+				if p.ErrorCode == protocol.ErrNone.Code() {
+					resp.Topics[i].Partitions[j].ErrorCode = perr.Code()
+				}
+			}
+		}
+		return resp
 	}
+	b.logger.Info("deleted offsets", log.String("group", req.GroupID))
 	return resp
 }
 
-func (b *Broker) handleDescribeGroups(ctx *Context, req *protocol.DescribeGroupsRequest) *protocol.DescribeGroupsResponse {
-	sp := span(ctx, b.tracer, "create topic")
-	defer sp.Finish()
-	resp := new(protocol.DescribeGroupsResponse)
-	resp.APIVersion = req.Version()
-	state := b.fsm.State()
-
-	fmt.Println("describe")
-	fmt.Println("describe")
-	fmt.Println("describe")
-
-	for _, id := range req.GroupIDs {
-		group := protocol.Group{}
-		_, g, err := state.GetGroup(id)
-		if err != nil {
-			group.ErrorCode = protocol.ErrUnknown.Code()
-			group.GroupID = id
-			resp.Groups = append(resp.Groups, group)
-			return resp
+// subscribedTopics returns the topics the members of the consumer group subscribe to. Members
+// whose metadata can't be decoded are skipped.
+func subscribedTopics(group *structs.Group) map[string]bool {
+	topics := make(map[string]bool)
+	if group.ProtocolType != protocol.ConsumerProtocolType {
+		return topics
+	}
+	for _, m := range group.Members {
+		var subscription protocol.ConsumerProtocolSubscription
+		if err := subscription.Decode(protocol.NewDecoder(m.Metadata)); err != nil {
+			continue
 		}
-		group.GroupID = id
-		group.State = "Stable"
-		group.ProtocolType = "consumer"
-		group.Protocol = "consumer"
-		for id, member := range g.Members {
-			group.GroupMembers[id] = &protocol.GroupMember{
-				ClientID: member.ID,
-				// TODO: ???
-				ClientHost:            "",
-				GroupMemberMetadata:   member.Metadata,
-				GroupMemberAssignment: member.Assignment,
-			}
+		for _, topic := range subscription.Topics {
+			topics[topic] = true
 		}
-		resp.Groups = append(resp.Groups)
-
 	}
+	return topics
+}
 
-	return resp
+// clientHost returns the host the request was sent from, formatted like Kafka's, or "" if it
+// didn't come from a conn.
+func clientHost(ctx *Context) string {
+	conn, ok := ctx.conn.(net.Conn)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return "/" + host
 }
 
 func (b *Broker) handleStopReplica(ctx *Context, req *protocol.StopReplicaRequest) *protocol.StopReplicaResponse {
-	panic("not implemented: stop replica")
-	return nil
+	sp := span(ctx, b.tracer, "stop replica")
+	defer sp.Finish()
+	resp := new(protocol.StopReplicaResponse)
+	resp.Partitions = make([]*protocol.StopReplicaResponsePartition, len(req.Partitions))
+	for i, p := range req.Partitions {
+		err := b.stopReplica(p.Topic, p.Partition, req.DeletePartitions)
+		resp.Partitions[i] = &protocol.StopReplicaResponsePartition{
+			Topic:     p.Topic,
+			Partition: p.Partition,
+			ErrorCode: err.Code(),
+		}
+	}
+	resp.ErrorCode = protocol.ErrNone.Code()
+	return resp
 }
 
 func (b *Broker) handleUpdateMetadata(ctx *Context, req *protocol.UpdateMetadataRequest) *protocol.UpdateMetadataResponse {
@@ -905,35 +2271,145 @@ func (b *Broker) handleUpdateMetadata(ctx *Context, req *protocol.UpdateMetadata
 }
 
 func (b *Broker) handleControlledShutdown(ctx *Context, req *protocol.ControlledShutdownRequest) *protocol.ControlledShutdownResponse {
-	panic("not implemented: controlled shutdown")
-	return nil
+	sp := span(ctx, b.tracer, "controlled shutdown")
+	defer sp.Finish()
+	resp := new(protocol.ControlledShutdownResponse)
+	resp.APIVersion = req.Version()
+	remaining, err := b.controlledShutdown(req.BrokerID)
+	if err != protocol.ErrNone {
+		sp.LogKV("broker", req.BrokerID, "err", err)
+	}
+	resp.ErrorCode = err.Code()
+	resp.PartitionsRemaining = remaining
+	return resp
 }
 
 func (b *Broker) handleOffsetCommit(ctx *Context, req *protocol.OffsetCommitRequest) *protocol.OffsetCommitResponse {
-	panic("not implemented: offset commit")
-	return nil
+	sp := span(ctx, b.tracer, "offset commit")
+	defer sp.Finish()
+
+	resp := new(protocol.OffsetCommitResponse)
+	resp.APIVersion = req.Version()
+
+	_, group, err := b.fsm.State().GetGroup(req.GroupID)
+	perr := b.groupCoordinator(req.GroupID, group)
+	switch {
+	case err != nil:
+		perr = protocol.ErrUnknown.WithErr(err)
+	case perr != protocol.ErrNone:
+		// this broker isn't the group's coordinator
+	case req.GenerationID < 0 && group != nil && len(group.Members) > 0 && !b.config.AllowLiveGroupOffsetReset:
+		// committing from outside the group resets its offsets, and its members would carry on
+		// from where they were and duplicate or skip messages.
+		perr = protocol.ErrNonEmptyGroup
+	case req.GenerationID >= 0 && group == nil:
+		perr = protocol.ErrUnknownMemberId
+	case req.GenerationID >= 0:
+		if _, ok := group.Members[req.MemberID]; !ok {
+			perr = protocol.ErrUnknownMemberId
+		}
+	}
+
+	errs := make(map[topicPartition]protocol.Error)
+	if perr == protocol.ErrNone {
+		now := time.Now()
+		var expireTime time.Time
+		// versions 2 to 4 can give the offsets' retention, -1 for the broker's
+		if req.APIVersion >= 2 && req.APIVersion <= 4 && req.RetentionTime > 0 {
+			expireTime = now.Add(time.Duration(req.RetentionTime) * time.Millisecond)
+		}
+		offsets := make(map[string]map[int32]structs.GroupOffset, len(req.Topics))
+		for _, t := range req.Topics {
+			for _, p := range t.Partitions {
+				if b.offsetMetadataTooLarge(p.Metadata) {
+					errs[topicPartition{topic: t.Topic, partition: p.Partition}] = protocol.ErrOffsetMetadataTooLarge
+					continue
+				}
+				if offsets[t.Topic] == nil {
+					offsets[t.Topic] = make(map[int32]structs.GroupOffset, len(t.Partitions))
+				}
+				offsets[t.Topic][p.Partition] = structs.GroupOffset{Offset: p.Offset, CommitTime: now, ExpireTime: expireTime, Metadata: p.Metadata}
+			}
+		}
+		if len(offsets) > 0 {
+			if perr = b.writeOffsets(req.GroupID, offsets); perr == protocol.ErrNone {
+				_, err = b.raftApply(structs.CommitOffsetsRequestType, structs.CommitOffsetsRequest{
+					Group:       req.GroupID,
+					Coordinator: b.config.ID,
+					Offsets:     offsets,
+				})
+				if err != nil {
+					b.logger.Error("failed to commit offsets", log.Error("error", err))
+					perr = protocol.ErrUnknown.WithErr(err)
+				}
+			}
+		}
+	} else {
+		sp.LogKV("msg", "rejected offset commit", "err", perr)
+	}
+
+	resp.Responses = make([]protocol.OffsetCommitTopicResponse, len(req.Topics))
+	for i, t := range req.Topics {
+		resp.Responses[i].Topic = t.Topic
+		resp.Responses[i].PartitionResponses = make([]protocol.OffsetCommitPartitionResponse, len(t.Partitions))
+		for j, p := range t.Partitions {
+			err, ok := errs[topicPartition{topic: t.Topic, partition: p.Partition}]
+			if !ok {
+				err = perr
+			}
+			resp.Responses[i].PartitionResponses[j] = protocol.OffsetCommitPartitionResponse{
+				Partition: p.Partition,
+				ErrorCode: err.Code(),
+			}
+		}
+	}
+	return resp
 }
 
 func (b *Broker) handleOffsetFetch(ctx *Context, req *protocol.OffsetFetchRequest) *protocol.OffsetFetchResponse {
-	sp := span(ctx, b.tracer, "create topic")
+	sp := span(ctx, b.tracer, "offset fetch")
 	defer sp.Finish()
 
 	resp := new(protocol.OffsetFetchResponse)
 	resp.APIVersion = req.Version()
 	resp.Responses = make([]protocol.OffsetFetchTopicResponse, len(req.Topics))
 
-	// state := b.fsm.State()
-
-	// _, g, err := state.GetGroup(req.GroupID)
-
-	// // If group doesn't exist then create it?
-	// if err != nil {
-	// 	// TODO: handle err
-	// 	panic(err)
-	// }
+	_, group, err := b.fsm.State().GetGroup(req.GroupID)
+	perr := b.groupCoordinator(req.GroupID, group)
+	if err != nil {
+		perr = protocol.ErrUnknown.WithErr(err)
+	}
 
+	now := time.Now()
+	for i, t := range req.Topics {
+		resp.Responses[i].Topic = t.Topic
+		resp.Responses[i].Partitions = make([]protocol.OffsetFetchPartition, len(t.Partitions))
+		for j, p := range t.Partitions {
+			// -1 tells the consumer there's no committed offset and to use its reset policy
+			offset := int64(-1)
+			var metadata *string
+			if group != nil {
+				// expired offsets are gone even if they haven't been removed yet
+				if committed, ok := group.Offsets[t.Topic][p]; ok && !b.offsetExpired(group, committed, now) {
+					offset = committed.Offset
+					metadata = committed.Metadata
+				}
+			}
+			resp.Responses[i].Partitions[j] = protocol.OffsetFetchPartition{
+				Partition: p,
+				Offset:    offset,
+				Metadata:  metadata,
+				ErrorCode: perr.Code(),
+			}
+		}
+	}
 	return resp
+}
 
+// offsetMetadataTooLarge returns true if the metadata committed with an offset is longer than
+// the broker allows.
+func (b *Broker) offsetMetadataTooLarge(metadata *string) bool {
+	return metadata != nil && len(*metadata) > b.config.OffsetMetadataMaxBytes
 }
 
 // isController returns true if this is the cluster controller.
@@ -945,6 +2421,15 @@ func (b *Broker) isLeader() bool {
 	return b.raft.State() == raft.Leader
 }
 
+// controllerID returns the ID of the cluster controller, or -1 if there isn't one.
+func (b *Broker) controllerID() int32 {
+	controller := b.brokerLookup.BrokerByAddr(b.raft.Leader())
+	if controller == nil {
+		return -1
+	}
+	return controller.ID.Int32()
+}
+
 // createPartition is used to add a partition across the cluster.
 func (b *Broker) createPartition(partition structs.Partition) error {
 	_, err := b.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{
@@ -968,16 +2453,19 @@ func (b *Broker) startReplica(replica *Replica) protocol.Error {
 	}
 
 	if replica.Log == nil {
-		log, err := commitlog.New(commitlog.Options{
-			Path:            filepath.Join(b.config.DataDir, "data", fmt.Sprintf("%d", replica.Partition.ID)),
-			MaxSegmentBytes: 1024,
-			MaxLogBytes:     -1,
-			CleanupPolicy:   commitlog.CleanupPolicy(topic.Config.GetValue("cleanup.policy").(string)),
-		})
+		opts := b.logOptions(topic, replica.Partition.ID)
+		if err := b.migrateLegacyLog(topic.Topic, replica.Partition.ID, opts.Path); err != nil {
+			b.logger.Error("failed to migrate legacy replica log", log.Any("replica", replica), log.Error("error", err))
+		}
+		l, err := commitlog.New(opts)
 		if err != nil {
 			return protocol.ErrUnknown.WithErr(err)
 		}
-		replica.Log = log
+		replica.Log = l
+		replica.LogDir = filepath.Dir(opts.Path)
+		if err := b.txnIndexes.load(topic.Topic, replica.Partition.ID, l); err != nil {
+			b.logger.Error("failed to load replica transactions", log.Any("replica", replica), log.Error("error", err))
+		}
 		// TODO: register leader-change listener on r.replica.Partition.id
 	}
 
@@ -986,6 +2474,9 @@ func (b *Broker) startReplica(replica *Replica) protocol.Error {
 
 // createTopic is used to create the topic across the cluster.
 func (b *Broker) createTopic(ctx *Context, topic *protocol.CreateTopicRequest) protocol.Error {
+	if !validTopicName(topic.Topic) {
+		return protocol.ErrInvalidTopicException
+	}
 	state := b.fsm.State()
 	_, t, _ := state.GetTopic(topic.Topic)
 	if t != nil {
@@ -1003,9 +2494,11 @@ func (b *Broker) createTopic(ctx *Context, topic *protocol.CreateTopicRequest) p
 	if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: tt}); err != nil {
 		return protocol.ErrUnknown.WithErr(err)
 	}
+	// from here on failures roll the topic back so it isn't left half-created,
+	// which would make the name unusable
 	for _, partition := range ps {
 		if err := b.createPartition(partition); err != nil {
-			return protocol.ErrUnknown.WithErr(err)
+			return b.rollbackTopic(ctx, topic.Topic, protocol.ErrUnknown.WithErr(err))
 		}
 	}
 	// could move this up maybe and do the iteration once
@@ -1018,35 +2511,249 @@ func (b *Broker) createTopic(ctx *Context, topic *protocol.CreateTopicRequest) p
 		req.PartitionStates = append(req.PartitionStates, &protocol.PartitionState{
 			Topic:     partition.Topic,
 			Partition: partition.ID,
-			// TODO: ControllerEpoch, LeaderEpoch, ZKVersion
-			Leader:   partition.Leader,
-			ISR:      partition.ISR,
-			Replicas: partition.AR,
+			// TODO: ControllerEpoch, ZKVersion
+			LeaderEpoch: partition.LeaderEpoch,
+			Leader:      partition.Leader,
+			ISR:         partition.ISR,
+			Replicas:    partition.AR,
 		})
 	}
 	// TODO: can optimize this
 	for _, broker := range b.brokerLookup.Brokers() {
+		var resp *protocol.LeaderAndISRResponse
 		if broker.ID.Int32() == b.config.ID {
-			errCode := b.handleLeaderAndISR(ctx, req).ErrorCode
-			if protocol.ErrNone.Code() != errCode {
-				panic(fmt.Sprintf("failed handling leader and isr: %d", errCode))
-			}
+			resp = b.handleLeaderAndISR(ctx, req)
 		} else {
-			conn, err := Dial("tcp", broker.BrokerAddr)
+			conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
 			if err != nil {
-				return protocol.ErrUnknown.WithErr(err)
+				return b.rollbackTopic(ctx, topic.Topic, protocol.ErrUnknown.WithErr(err))
 			}
-			_, err = conn.LeaderAndISR(req)
+			resp, err = conn.LeaderAndISR(req)
+			conn.Close()
 			if err != nil {
-				// handle err and responses
-				return protocol.ErrUnknown.WithErr(err)
+				return b.rollbackTopic(ctx, topic.Topic, protocol.ErrUnknown.WithErr(err))
+			}
+		}
+		for _, p := range resp.Partitions {
+			if p.ErrorCode != protocol.ErrNone.Code() {
+				return b.rollbackTopic(ctx, topic.Topic, protocol.Errs[p.ErrorCode])
+			}
+		}
+	}
+	b.publishLeaderChanges(ctx, ps)
+	return protocol.ErrNone
+}
+
+// maxTopicNameLength is the longest a topic's name can be, leaving room in its partitions'
+// directory names for the partition id and suffixes.
+const maxTopicNameLength = 249
+
+// validTopicName returns whether the name can be used for a topic. Names are used in the
+// partitions' directory names so they're limited to ASCII letters and digits, '.', '_', and '-'.
+func validTopicName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > maxTopicNameLength {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// rollbackTopic deletes the partially created topic and returns the error that caused the rollback.
+func (b *Broker) rollbackTopic(ctx *Context, topic string, cause protocol.Error) protocol.Error {
+	b.logger.Error("failed to create topic, rolling back", log.String("topic", topic), log.Error("error", cause))
+	if err := b.deleteTopic(ctx, topic, nil); err != protocol.ErrNone {
+		b.logger.Error("failed to roll back topic", log.String("topic", topic), log.Error("error", err))
+	}
+	return cause
+}
+
+// createPartitions is used to grow the topic's partition count across the cluster.
+func (b *Broker) createPartitions(ctx *Context, topic *protocol.CreatePartitionsTopic, validateOnly bool) protocol.Error {
+	state := b.fsm.State()
+	_, t, err := state.GetTopic(topic.Topic)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	if t == nil {
+		return protocol.ErrUnknownTopicOrPartition
+	}
+	current := int32(len(t.Partitions))
+	if current == 0 || topic.Count <= current {
+		// partitions can only be added, never removed
+		return protocol.ErrInvalidPartitions
+	}
+	replicationFactor := int16(len(t.Partitions[0]))
+	var ps []structs.Partition
+	if topic.Assignment == nil {
+		if replicationFactor > int16(len(b.LANMembers())) {
+			return protocol.ErrInvalidReplicationFactor
+		}
+		ps = b.buildPartitionsFrom(topic.Topic, current, topic.Count-current, replicationFactor)
+	} else {
+		if int32(len(topic.Assignment)) != topic.Count-current {
+			return protocol.ErrInvalidReplicaAssignment
+		}
+		for i, replicas := range topic.Assignment {
+			if !b.validAssignment(replicas, replicationFactor) {
+				return protocol.ErrInvalidReplicaAssignment
+			}
+			id := current + int32(i)
+			ps = append(ps, structs.Partition{
+				Topic:     topic.Topic,
+				ID:        id,
+				Partition: id,
+				Leader:    replicas[0],
+				AR:        replicas,
+				ISR:       replicas,
+			})
+		}
+	}
+	if validateOnly {
+		return protocol.ErrNone
+	}
+	// copy the topic rather than mutating the one held by the state store
+	tt := *t
+	tt.Partitions = make(map[int32][]int32, topic.Count)
+	for id, replicas := range t.Partitions {
+		tt.Partitions[id] = replicas
+	}
+	for _, partition := range ps {
+		tt.Partitions[partition.ID] = partition.AR
+	}
+	// the partitions are registered before the topic lists them so a failure can't leave the
+	// topic with partitions that don't exist, the ones registered are rolled back instead
+	for i, partition := range ps {
+		if err := b.createPartition(partition); err != nil {
+			return b.rollbackPartitions(ps[:i], protocol.ErrUnknown.WithErr(err))
+		}
+	}
+	if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: tt}); err != nil {
+		return b.rollbackPartitions(ps, protocol.ErrUnknown.WithErr(err))
+	}
+	return b.sendLeaderAndISR(ctx, ps)
+}
+
+// rollbackPartitions deregisters the partitions added to a topic and returns the error that
+// caused the rollback.
+func (b *Broker) rollbackPartitions(ps []structs.Partition, cause protocol.Error) protocol.Error {
+	b.logger.Error("failed to create partitions, rolling back", log.Error("error", cause))
+	for _, partition := range ps {
+		if _, err := b.raftApply(structs.DeregisterPartitionRequestType, structs.DeregisterPartitionRequest{Partition: partition}); err != nil {
+			b.logger.Error("failed to roll back partition", log.String("topic", partition.Topic), log.Int32("partition", partition.ID), log.Error("error", err))
+		}
+	}
+	return cause
+}
+
+// validAssignment checks the replicas are distinct, known brokers matching the replication factor.
+func (b *Broker) validAssignment(replicas []int32, replicationFactor int16) bool {
+	if len(replicas) != int(replicationFactor) {
+		return false
+	}
+	seen := make(map[int32]bool, len(replicas))
+	for _, id := range replicas {
+		if seen[id] || b.brokerLookup.BrokerByID(raft.ServerID(id)) == nil {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// sendLeaderAndISR sends the partitions' states to the brokers replicating them. Brokers that
+// have failed are skipped, they're sent their partitions' states once they've recovered.
+func (b *Broker) sendLeaderAndISR(ctx *Context, ps []structs.Partition) protocol.Error {
+	// the changes are committed whether or not every broker's told about them
+	defer b.publishLeaderChanges(ctx, ps)
+	// a broker that can't be reached doesn't keep the rest from being told, the first error's
+	// returned once they've all been sent
+	sendErr := protocol.ErrNone
+	for id, req := range b.leaderAndISRRequests(ps) {
+		if b.isFailed(id) {
+			continue
+		}
+		if err := b.sendLeaderAndISRRequest(ctx, id, req); err != protocol.ErrNone && sendErr == protocol.ErrNone {
+			sendErr = err
+		}
+	}
+	return sendErr
+}
+
+// leaderAndISRRequests returns the requests to send each broker replicating the partitions.
+func (b *Broker) leaderAndISRRequests(ps []structs.Partition) map[int32]*protocol.LeaderAndISRRequest {
+	reqs := make(map[int32]*protocol.LeaderAndISRRequest)
+	for _, partition := range ps {
+		for _, id := range partition.AR {
+			req, ok := reqs[id]
+			if !ok {
+				req = &protocol.LeaderAndISRRequest{
+					ControllerID: b.config.ID,
+					// TODO ControllerEpoch
+				}
+				reqs[id] = req
+			}
+			req.PartitionStates = append(req.PartitionStates, &protocol.PartitionState{
+				Topic:     partition.Topic,
+				Partition: partition.ID,
+				// TODO: ControllerEpoch, ZKVersion
+				LeaderEpoch: partition.LeaderEpoch,
+				Leader:      partition.Leader,
+				ISR:         partition.ISR,
+				Replicas:    partition.AR,
+			})
+		}
+	}
+	return reqs
+}
+
+// sendLeaderAndISRRequest sends the request to the broker, handling it here if it's for this
+// broker.
+func (b *Broker) sendLeaderAndISRRequest(ctx *Context, id int32, req *protocol.LeaderAndISRRequest) protocol.Error {
+	if id == b.config.ID {
+		for _, p := range b.handleLeaderAndISR(ctx, req).Partitions {
+			if p.ErrorCode != protocol.ErrNone.Code() {
+				return protocol.Errs[p.ErrorCode]
 			}
 		}
+		return protocol.ErrNone
+	}
+	broker := b.brokerLookup.BrokerByID(raft.ServerID(id))
+	if broker == nil {
+		return protocol.ErrBrokerNotAvailable
+	}
+	conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	_, err = conn.LeaderAndISR(req)
+	conn.Close()
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
 	}
 	return protocol.ErrNone
 }
 
+// isFailed returns whether the broker is registered with a failing health check.
+func (b *Broker) isFailed(id int32) bool {
+	_, node, err := b.fsm.State().GetNode(id)
+	if err != nil || node == nil || node.Check == nil {
+		return false
+	}
+	return node.Check.Status == structs.HealthCritical
+}
+
 func (b *Broker) buildPartitions(topic string, partitionsCount int32, replicationFactor int16) []structs.Partition {
+	return b.buildPartitionsFrom(topic, 0, partitionsCount, replicationFactor)
+}
+
+// buildPartitionsFrom assigns replicas for partitionsCount partitions with IDs starting at start.
+func (b *Broker) buildPartitionsFrom(topic string, start, partitionsCount int32, replicationFactor int16) []structs.Partition {
 	brokers := b.brokerLookup.Brokers()
 	count := len(brokers)
 
@@ -1059,7 +2766,7 @@ func (b *Broker) buildPartitions(topic string, partitionsCount int32, replicatio
 
 	var partitions []structs.Partition
 
-	for i := int32(0); i < partitionsCount; i++ {
+	for i := start; i < start+partitionsCount; i++ {
 		// TODO: maybe just go next here too
 		r = r.Move(rand.Intn(count))
 		leader := r.Value.(*metadata.Broker)
@@ -1095,7 +2802,8 @@ func (b *Broker) Leave() error {
 	isLeader := b.isLeader()
 	if isLeader && numPeers > 1 {
 		future := b.raft.RemoveServer(raft.ServerID(b.config.ID), 0, 0)
-		if err := future.Error(); err != nil {
+		// raft shuts down once it's removed us
+		if err := b.waitRaftFuture(future); err != nil && err != raft.ErrRaftShutdown {
 			b.logger.Error("failed to remove ourself as raft peer", log.Error("error", err))
 		}
 	}
@@ -1136,7 +2844,132 @@ func (b *Broker) Leave() error {
 	return nil
 }
 
-// Shutdown is used to shutdown the broker, its serf, its raft, and so on.
+// deleteTopic is used to delete the topic and its partitions across the cluster. The brokers
+// replicating the topic's partitions are told to stop and delete them, which they do asynchronously.
+// If progress is set it's called with how many of the partitions' replicas have been stopped as
+// each broker's told.
+func (b *Broker) deleteTopic(ctx *Context, topic string, progress func(partitions, replicas, stopped int)) protocol.Error {
+	state := b.fsm.State()
+	_, t, err := state.GetTopic(topic)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	if t == nil {
+		return protocol.ErrUnknownTopicOrPartition
+	}
+	_, partitions, err := state.PartitionsByTopic(topic)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	// deregistering the topic deregisters its partitions too
+	if _, err := b.raftApply(structs.DeregisterTopicRequestType, structs.DeregisterTopicRequest{Topic: *t}); err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	// group the partitions by the brokers replicating them
+	reqs := make(map[int32]*protocol.StopReplicaRequest)
+	for _, p := range partitions {
+		for _, id := range p.AR {
+			req, ok := reqs[id]
+			if !ok {
+				req = &protocol.StopReplicaRequest{
+					ControllerID:     b.config.ID,
+					DeletePartitions: true,
+				}
+				reqs[id] = req
+			}
+			req.Partitions = append(req.Partitions, &protocol.StopReplicaPartition{
+				Topic:     p.Topic,
+				Partition: p.Partition,
+			})
+		}
+	}
+	replicas := 0
+	for _, req := range reqs {
+		replicas += len(req.Partitions)
+	}
+	stopped := 0
+	if progress != nil {
+		progress(len(partitions), replicas, stopped)
+	}
+	// the topic's deleted by now, so a broker we can't reach is logged rather than failing the deletion
+	for id, req := range reqs {
+		b.stopReplicas(ctx, map[int32]*protocol.StopReplicaRequest{id: req})
+		stopped += len(req.Partitions)
+		if progress != nil {
+			progress(len(partitions), replicas, stopped)
+		}
+	}
+	b.publishLeaderTombstones(ctx, partitions)
+	return protocol.ErrNone
+}
+
+// stopReplicas sends the StopReplica requests to their brokers, handling the one for this broker
+// here. Brokers that can't be reached are logged.
+func (b *Broker) stopReplicas(ctx *Context, reqs map[int32]*protocol.StopReplicaRequest) {
+	for id, req := range reqs {
+		if id == b.config.ID {
+			b.handleStopReplica(ctx, req)
+			continue
+		}
+		broker := b.brokerLookup.BrokerByID(raft.ServerID(id))
+		if broker == nil {
+			b.logger.Error("trying to stop replicas on unknown broker", log.Int32("broker", id))
+			continue
+		}
+		conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
+		if err != nil {
+			b.logger.Error("failed to dial broker to stop replicas", log.Int32("broker", id), log.Error("error", err))
+			continue
+		}
+		_, err = conn.StopReplica(req)
+		conn.Close()
+		if err != nil {
+			b.logger.Error("failed to stop replicas", log.Int32("broker", id), log.Error("error", err))
+		}
+	}
+}
+
+// stopReplica stops replicating the partition on this broker and removes the replica. If
+// deleteLog is set the replica's commit log is deleted asynchronously.
+func (b *Broker) stopReplica(topic string, partition int32, deleteLog bool) protocol.Error {
+	mu := b.partitionLocks.get(topic, partition)
+	mu.Lock()
+	defer mu.Unlock()
+	replica, err := b.replicaLookup.Replica(topic, partition)
+	if err != nil {
+		// not replicating this partition, nothing to stop
+		return protocol.ErrNone
+	}
+	b.Lock()
+	if replica.Replicator != nil {
+		if err := replica.Replicator.Close(); err != nil {
+			b.Unlock()
+			return protocol.ErrUnknown.WithErr(err)
+		}
+		replica.Replicator = nil
+	}
+	b.Unlock()
+	b.replicaLookup.RemoveReplica(replica)
+	b.producers.remove(topic, partition)
+	b.txnIndexes.remove(topic, partition)
+	b.followers.remove(topic, partition)
+	b.replicationWaits.remove(topic, partition)
+	b.appendCallbacks.remove(topic, partition)
+	b.orderingAudit.remove(topic, partition)
+	switch topic {
+	case TransactionStateTopicName:
+		b.transactions.unload(partition)
+	case OffsetsTopicName:
+		b.unloadGroups(partition)
+	}
+	if deleteLog && replica.Log != nil {
+		b.deleteReplicaLog(replica)
+	}
+	return protocol.ErrNone
+}
+
+// Shutdown is used to shutdown the broker, its serf, its raft, and so on, in the stages
+// shutdownStages returns. The shutdown's only marked clean if every stage finished in time.
 func (b *Broker) Shutdown() error {
 	b.logger.Info("shutting down broker")
 	b.shutdownLock.Lock()
@@ -1146,21 +2979,9 @@ func (b *Broker) Shutdown() error {
 		return nil
 	}
 	b.shutdown = true
-	close(b.shutdownCh)
-
-	if b.serf != nil {
-		b.serf.Shutdown()
-	}
 
-	if b.raft != nil {
-		b.raftTransport.Close()
-		future := b.raft.Shutdown()
-		if err := future.Error(); err != nil {
-			b.logger.Error("failed to shutdown", log.Error("error", err))
-		}
-		if b.raftStore != nil {
-			b.raftStore.Close()
-		}
+	if b.runShutdownStages(b.shutdownStages()) {
+		b.markCleanShutdown()
 	}
 
 	return nil
@@ -1177,40 +2998,80 @@ func (b *Broker) becomeFollower(replica *Replica, cmd *protocol.PartitionState)
 			return protocol.ErrUnknown.WithErr(err)
 		}
 	}
-	hw := replica.Log.NewestOffset()
-	if err := replica.Log.Truncate(hw); err != nil {
-		return protocol.ErrUnknown.WithErr(err)
-	}
-	broker := b.brokerLookup.BrokerByID(raft.ServerID(cmd.Leader))
-	if broker == nil {
-		return protocol.ErrBrokerNotAvailable
-	}
-	conn, err := NewDialer(fmt.Sprintf("jocko-replicator-%d", b.config.ID)).Dial("tcp", broker.BrokerAddr)
+	// the replicator truncates to the leader and copies its segments over its own connection,
+	// then the replica fetcher fetches the partition with the others followed from the leader
+	conn, err := b.dialReplicaFetcher(cmd.Leader)
 	if err != nil {
+		if err == protocol.ErrBrokerNotAvailable {
+			return protocol.ErrBrokerNotAvailable
+		}
 		return protocol.ErrUnknown.WithErr(err)
 	}
 	logger := b.logger.With(log.Int32("leader", replica.Partition.Leader))
-	r := NewReplicator(ReplicatorConfig{}, replica, conn, logger)
+	topic, partition := replica.Partition.Topic, replica.Partition.ID
+	b.appendCallbacks.remove(topic, partition)
+	b.orderingAudit.remove(topic, partition)
+	r := NewReplicator(ReplicatorConfig{
+		LeaderEpoch:      cmd.LeaderEpoch,
+		MaxBytes:         b.config.ReplicaFetchMaxBytes,
+		Backoff:          b.config.ReplicaFetchBackoff,
+		SnapshotMinBytes: b.config.ReplicaSnapshotMinBytes,
+		Appended: func(offset int64, recordSet []byte) {
+			b.producers.update(topic, partition, offset, recordSet)
+			b.orderingAudit.appended(topic, partition, recordSet)
+			b.txnIndexes.update(topic, partition, recordSet)
+		},
+		breaker: b.breakers.get(topic, partition),
+		failed: func(err error) {
+			b.logFailed(topic, partition, err)
+		},
+		audit: b.orderingAudit,
+	}, replica, conn, logger)
 	replica.Replicator = r
 	if !b.config.DevMode {
-		r.Replicate()
+		b.replicaFetchers.add(cmd.Leader, r)
 	}
 	return protocol.ErrNone
 }
 
+// dialReplicaFetcher connects the replica fetcher to the leader with the id.
+func (b *Broker) dialReplicaFetcher(leader int32) (client, error) {
+	broker := b.brokerLookup.BrokerByID(raft.ServerID(leader))
+	if broker == nil {
+		return nil, protocol.ErrBrokerNotAvailable
+	}
+	return NewDialerWithConfig(fmt.Sprintf("%s%d", replicatorClientIDPrefix, b.config.ID), b.config.ReplicaSocket).Dial("tcp", broker.BrokerAddr)
+}
+
 func (b *Broker) becomeLeader(replica *Replica, cmd *protocol.PartitionState) protocol.Error {
 	b.Lock()
 	defer b.Unlock()
 	if replica.Replicator != nil {
+		hw := b.replicaHighWatermark(replica)
 		if err := replica.Replicator.Close(); err != nil {
 			return protocol.ErrUnknown.WithErr(err)
 		}
 		replica.Replicator = nil
+		b.followers.lead(replica.Partition.Topic, replica.Partition.ID, hw)
 	}
 	replica.Partition.Leader = cmd.Leader
 	replica.Partition.AR = cmd.Replicas
 	replica.Partition.ISR = cmd.ISR
-	replica.Partition.LeaderEpoch = cmd.ZKVersion
+	replica.Partition.LeaderEpoch = cmd.LeaderEpoch
+	b.followers.leading(replica.Partition.Topic, replica.Partition.ID, time.Now())
+	// what's past the high watermark is committed while this broker leads, so the callbacks are
+	// passed it even if nothing's produced to the partition
+	hw := b.followers.highWatermark(replica.Partition, replica.Log.NewestOffset())
+	b.appendCallbacks.start(replica.Partition.Topic, replica.Partition.ID, hw)
+	b.orderingAudit.remove(replica.Partition.Topic, replica.Partition.ID)
+	// the messages this broker appends as leader from here on are in the new epoch
+	if l, ok := replica.Log.(epochLog); ok {
+		if err := l.AssignEpoch(cmd.LeaderEpoch, replica.Log.NewestOffset()); err != nil {
+			return protocol.ErrKafkaStorageError.WithErr(err)
+		}
+	}
+	// the lease has to cover the new leader epoch before the partition's served
+	b.leaderLeases.renewSoon()
 	return protocol.ErrNone
 }
 
@@ -1265,6 +3126,11 @@ func (b *Broker) LANMembers() []serf.Member {
 	return b.serf.Members()
 }
 
+// dialer returns a dialer for inter-broker connections using the replica socket config.
+func (b *Broker) dialer() *Dialer {
+	return NewDialerWithConfig(fmt.Sprintf("%s%d", brokerClientIDPrefix, b.config.ID), b.config.ReplicaSocket)
+}
+
 // Replica
 type Replica struct {
 	BrokerID   int32
@@ -1275,36 +3141,13 @@ type Replica struct {
 	Leo        int64
 	Replicator *Replicator
 	sync.Mutex
+
+	// LogDir is the log dir the replica's log is in and FutureLogDir is the one it's being moved
+	// to, if any. They're guarded by the replica's lock.
+	LogDir       string
+	FutureLogDir string
 }
 
 func (r Replica) String() string {
 	return fmt.Sprintf("replica: %d {broker: %d, leader: %d, hw: %d, leo: %d}", r.Partition.ID, r.BrokerID, r.Partition.Leader, r.Hw, r.Leo)
 }
-
-func (b *Broker) offsetsTopic(ctx *Context) (topic *structs.Topic, err error) {
-	state := b.fsm.State()
-	name := "__consumer_offsets"
-
-	// check if the topic exists already
-	_, topic, err = state.GetTopic(name)
-	if err != nil {
-		return
-	}
-	if topic != nil {
-		return
-	}
-
-	// doesn't exist so let's create it
-	partitions := b.buildPartitions(name, 50, 3)
-	topic = &structs.Topic{
-		Topic:      "__consumer_offsets",
-		Partitions: make(map[int32][]int32),
-	}
-	for _, p := range partitions {
-		topic.Partitions[p.Partition] = p.AR
-	}
-	_, err = b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{
-		Topic: *topic,
-	})
-	return
-}
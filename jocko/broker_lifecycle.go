@@ -0,0 +1,194 @@
+package jocko
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/travisjeffery/jocko/jocko/metadata"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+)
+
+// cleanShutdownFile is written to the data dir once the broker's shut down, so on starting again
+// it can tell whether its previous run crashed instead.
+const cleanShutdownFile = "clean_shutdown"
+
+// previousShutdown returns how the broker's previous run with its data dir ended, removing the
+// clean shutdown marker so this run crashing isn't taken as clean. It's empty for the broker's
+// first start with the data dir, or in dev mode where nothing's kept on disk. It's called before
+// raft's set up, whose state is what tells a previous run happened.
+func (b *Broker) previousShutdown() string {
+	if b.config.DevMode {
+		return ""
+	}
+	path := filepath.Join(b.config.DataDir, cleanShutdownFile)
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Remove(path); err != nil {
+			b.logger.Error("failed to remove clean shutdown marker", log.Error("error", err))
+		}
+		return structs.ShutdownClean
+	}
+	if _, err := os.Stat(filepath.Join(b.config.DataDir, raftState)); err == nil {
+		return structs.ShutdownUnclean
+	}
+	return ""
+}
+
+// markCleanShutdown writes the clean shutdown marker once the broker's shut down.
+func (b *Broker) markCleanShutdown() {
+	if b.config.DevMode {
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(b.config.DataDir, cleanShutdownFile), nil, 0644); err != nil {
+		b.logger.Error("failed to write clean shutdown marker", log.Error("error", err))
+	}
+}
+
+// setLifecycleTags advertises the broker's startup to the cluster, for the controller to record.
+func (b *Broker) setLifecycleTags(tags map[string]string) {
+	tags["started_at"] = b.startup.Time.Format(time.RFC3339Nano)
+	tags["recovery"] = b.startup.RecoveryDuration.String()
+	if b.startup.Version != "" {
+		tags["version"] = b.startup.Version
+	}
+	if b.startup.PreviousShutdown != "" {
+		tags["previous_shutdown"] = b.startup.PreviousShutdown
+	}
+}
+
+// recordLifecycles records the members' lifecycle events, in one raft apply. It runs in the
+// leader loop once the members have been reconciled, after their joining or leaving's been
+// handled so recording doesn't hold that up, and events are recorded once the controller sees
+// them, or once the next controller does if it fails first.
+func (b *Broker) recordLifecycles(members []serf.Member) {
+	events := make(map[int32]structs.BrokerLifecycleEvent)
+	for _, m := range members {
+		meta, ok := metadata.IsBroker(m)
+		if !ok {
+			continue
+		}
+		event, err := b.lifecycleEvent(m, meta)
+		if err != nil {
+			b.logger.Error("leader: failed to get broker lifecycle", log.Any("member", m), log.Error("error", err))
+			continue
+		}
+		if event == nil {
+			continue
+		}
+		b.logger.Info("leader: recording broker lifecycle event", log.Int32("node", meta.ID.Int32()), log.String("type", event.Type), log.String("previous shutdown", event.PreviousShutdown))
+		events[meta.ID.Int32()] = *event
+	}
+	if len(events) == 0 {
+		return
+	}
+	if _, err := b.raftApply(structs.AddBrokerLifecycleEventsRequestType, structs.AddBrokerLifecycleEventsRequest{Events: events}); err != nil {
+		b.logger.Error("leader: failed to record broker lifecycle events", log.Error("error", err))
+	}
+}
+
+// lifecycleEvent returns the member's startup, or its leaving or failing, if it isn't in its
+// broker's lifecycle history yet, nil if there's nothing to record.
+func (b *Broker) lifecycleEvent(m serf.Member, meta *metadata.Broker) (*structs.BrokerLifecycleEvent, error) {
+	_, lifecycle, err := b.fsm.State().GetBrokerLifecycle(meta.ID.Int32())
+	if err != nil {
+		return nil, err
+	}
+	var last *structs.BrokerLifecycleEvent
+	if lifecycle != nil && len(lifecycle.Events) > 0 {
+		last = &lifecycle.Events[len(lifecycle.Events)-1]
+	}
+	switch m.Status {
+	case serf.StatusAlive:
+		if meta.StartedAt.IsZero() || lastStartup(lifecycle).Equal(meta.StartedAt) {
+			return nil, nil
+		}
+		return &structs.BrokerLifecycleEvent{
+			Type:             structs.BrokerStarted,
+			Time:             meta.StartedAt,
+			Version:          meta.Version,
+			PreviousShutdown: meta.PreviousShutdown,
+			RecoveryDuration: meta.RecoveryDuration,
+		}, nil
+	case serf.StatusFailed, serf.StatusLeft:
+		// only a running broker can fail or leave, which also keeps them from being recorded
+		// on every reconcile
+		if last == nil || last.Type != structs.BrokerStarted {
+			return nil, nil
+		}
+		event := &structs.BrokerLifecycleEvent{Type: structs.BrokerFailed, Time: time.Now()}
+		if m.Status == serf.StatusLeft {
+			event.Type = structs.BrokerLeft
+		}
+		return event, nil
+	}
+	return nil, nil
+}
+
+// lastStartup returns when the broker last started as recorded in its history, zero if it
+// hasn't been.
+func lastStartup(lifecycle *structs.BrokerLifecycle) time.Time {
+	if lifecycle == nil {
+		return time.Time{}
+	}
+	for i := len(lifecycle.Events) - 1; i >= 0; i-- {
+		if lifecycle.Events[i].Type == structs.BrokerStarted {
+			return lifecycle.Events[i].Time
+		}
+	}
+	return time.Time{}
+}
+
+// brokerLifecycleEvent is a broker lifecycle event as the admin API returns it.
+type brokerLifecycleEvent struct {
+	Type               string    `json:"type"`
+	Time               time.Time `json:"time"`
+	Version            string    `json:"version,omitempty"`
+	PreviousShutdown   string    `json:"previous_shutdown,omitempty"`
+	RecoveryDurationMs int64     `json:"recovery_duration_ms,omitempty"`
+}
+
+// adminBrokerLifecycles returns the brokers' startups and shutdowns, oldest first, or those of
+// one broker.
+//
+//	GET /v1/brokers/lifecycle[?broker=<broker id>]
+func (b *Broker) adminBrokerLifecycles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, lifecycles, err := b.fsm.State().GetBrokerLifecycles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type brokerLifecycle struct {
+		Broker int32                  `json:"broker"`
+		Events []brokerLifecycleEvent `json:"events"`
+	}
+	resp := struct {
+		Brokers []brokerLifecycle `json:"brokers"`
+	}{Brokers: []brokerLifecycle{}}
+	broker := r.URL.Query().Get("broker")
+	for _, l := range lifecycles {
+		if broker != "" && broker != metadata.NodeID(l.Broker).String() {
+			continue
+		}
+		bl := brokerLifecycle{Broker: l.Broker}
+		for _, e := range l.Events {
+			bl.Events = append(bl.Events, brokerLifecycleEvent{
+				Type:               e.Type,
+				Time:               e.Time,
+				Version:            e.Version,
+				PreviousShutdown:   e.PreviousShutdown,
+				RecoveryDurationMs: int64(e.RecoveryDuration / time.Millisecond),
+			})
+		}
+		resp.Brokers = append(resp.Brokers, bl)
+	}
+	writeAdminJSON(w, resp)
+}
@@ -3,20 +3,33 @@ package jocko
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/hashicorp/consul/testutil/retry"
 	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/require"
 
+	"github.com/travisjeffery/jocko/commitlog"
 	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/jocko/metadata"
 	"github.com/travisjeffery/jocko/jocko/structs"
 	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/mock"
 	"github.com/travisjeffery/jocko/protocol"
 )
 
@@ -56,7 +69,25 @@ func TestBroker_Run(t *testing.T) {
 				}},
 				responses: []*Context{{
 					header: &protocol.RequestHeader{CorrelationID: 1},
-					res:    &protocol.Response{CorrelationID: 1, Body: apiVersions},
+					res:    &protocol.Response{CorrelationID: 1, Body: &protocol.APIVersionsResponse{APIVersions: protocol.APIVersions}},
+				}},
+			},
+		},
+		{
+			name: "api versions unsupported version",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req:    &protocol.APIVersionsRequest{APIVersion: 99},
+				}},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.APIVersionsResponse{
+						ErrorCode:   protocol.ErrUnsupportedVersion.Code(),
+						APIVersions: protocol.APIVersions,
+					}},
 				}},
 			},
 		},
@@ -128,6 +159,360 @@ func TestBroker_Run(t *testing.T) {
 						TopicErrorCodes: []*protocol.TopicErrorCode{{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()}},
 					}}}},
 			},
+			handle: func(t *testing.T, b *Broker, ctx *Context) {
+				if _, ok := ctx.req.(*protocol.DeleteTopicsRequest); !ok {
+					return
+				}
+				// the controller deletes the topic in the background
+				retry.Run(t, func(r *retry.R) {
+					_, partitions, err := b.fsm.State().PartitionsByTopic("the-topic")
+					if err != nil {
+						r.Fatal(err)
+					}
+					if len(partitions) != 0 {
+						r.Fatal("partitions not deleted")
+					}
+					if _, err = b.replicaLookup.Replica("the-topic", 0); err == nil {
+						r.Fatal("replica not removed")
+					}
+				})
+			},
+		},
+		{
+			name: "delete topic unknown topic",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req:    &protocol.DeleteTopicsRequest{Topics: []string{"the-topic"}}},
+				},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.DeleteTopicsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{{Topic: "the-topic", ErrorCode: protocol.ErrUnknownTopicOrPartition.Code()}},
+					}}}},
+			},
+		},
+		{
+			name: "create topic invalid name",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req: &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+						Topic:             "bad/topic",
+						NumPartitions:     1,
+						ReplicationFactor: 1,
+					}, {
+						Topic:             "..",
+						NumPartitions:     1,
+						ReplicationFactor: 1,
+					}}}}},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.CreateTopicsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{
+							{Topic: "bad/topic", ErrorCode: protocol.ErrInvalidTopicException.Code()},
+							{Topic: "..", ErrorCode: protocol.ErrInvalidTopicException.Code()},
+						},
+					}},
+				}},
+			},
+			handle: func(t *testing.T, b *Broker, ctx *Context) {
+				_, topic, err := b.fsm.State().GetTopic("bad/topic")
+				require.NoError(t, err)
+				require.Nil(t, topic)
+			},
+		},
+		{
+			name: "create partitions",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req: &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+						Topic:             "the-topic",
+						NumPartitions:     1,
+						ReplicationFactor: 1,
+					}}}}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					req: &protocol.CreatePartitionsRequest{Topics: []*protocol.CreatePartitionsTopic{
+						{Topic: "the-topic", Count: 3},
+						{Topic: "unknown-topic", Count: 3},
+					}}}, {
+					header: &protocol.RequestHeader{CorrelationID: 3},
+					req: &protocol.CreatePartitionsRequest{Topics: []*protocol.CreatePartitionsTopic{
+						{Topic: "the-topic", Count: 2},
+						{Topic: "the-topic", Count: 4, Assignment: [][]int32{{1}, {1}}},
+					}}},
+				},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.CreateTopicsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()}},
+					}},
+				}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					res: &protocol.Response{CorrelationID: 2, Body: &protocol.CreatePartitionsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{
+							{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()},
+							{Topic: "unknown-topic", ErrorCode: protocol.ErrUnknownTopicOrPartition.Code()},
+						},
+					}},
+				}, {
+					header: &protocol.RequestHeader{CorrelationID: 3},
+					res: &protocol.Response{CorrelationID: 3, Body: &protocol.CreatePartitionsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{
+							{Topic: "the-topic", ErrorCode: protocol.ErrInvalidPartitions.Code()},
+							{Topic: "the-topic", ErrorCode: protocol.ErrInvalidReplicaAssignment.Code()},
+						},
+					}},
+				}},
+			},
+			handle: func(t *testing.T, b *Broker, ctx *Context) {
+				if _, ok := ctx.req.(*protocol.CreatePartitionsRequest); !ok {
+					return
+				}
+				_, topic, err := b.fsm.State().GetTopic("the-topic")
+				require.NoError(t, err)
+				require.Equal(t, 3, len(topic.Partitions))
+				_, partitions, err := b.fsm.State().PartitionsByTopic("the-topic")
+				require.NoError(t, err)
+				require.Equal(t, 3, len(partitions))
+				for _, p := range partitions {
+					replica, err := b.replicaLookup.Replica("the-topic", p.Partition)
+					require.NoError(t, err)
+					require.NotNil(t, replica.Log)
+				}
+			},
+		},
+		{
+			name: "create partitions validate only",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req: &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+						Topic:             "the-topic",
+						NumPartitions:     1,
+						ReplicationFactor: 1,
+					}}}}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					req: &protocol.CreatePartitionsRequest{ValidateOnly: true, Topics: []*protocol.CreatePartitionsTopic{
+						{Topic: "the-topic", Count: 3},
+						{Topic: "the-topic", Count: 1},
+					}}},
+				},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.CreateTopicsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()}},
+					}},
+				}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					res: &protocol.Response{CorrelationID: 2, Body: &protocol.CreatePartitionsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{
+							{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()},
+							{Topic: "the-topic", ErrorCode: protocol.ErrInvalidPartitions.Code()},
+						},
+					}},
+				}},
+			},
+			handle: func(t *testing.T, b *Broker, ctx *Context) {
+				if _, ok := ctx.req.(*protocol.CreatePartitionsRequest); !ok {
+					return
+				}
+				// validating doesn't add the partitions
+				_, topic, err := b.fsm.State().GetTopic("the-topic")
+				require.NoError(t, err)
+				require.Equal(t, 1, len(topic.Partitions))
+				_, partitions, err := b.fsm.State().PartitionsByTopic("the-topic")
+				require.NoError(t, err)
+				require.Equal(t, 1, len(partitions))
+			},
+		},
+		{
+			name: "describe configs",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req: &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+						Topic:             "the-topic",
+						NumPartitions:     1,
+						ReplicationFactor: 1,
+					}}}}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					req: &protocol.DescribeConfigsRequest{Resources: []protocol.DescribeConfigsResource{
+						{Type: int8(structs.TopicConfigResource), Name: "the-topic", ConfigNames: []string{"cleanup.policy", "retention.ms"}},
+						{Type: int8(structs.TopicConfigResource), Name: "unknown-topic"},
+						{Type: int8(structs.BrokerConfigResource), Name: "", ConfigNames: []string{"log.retention.ms"}},
+					}}},
+				},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.CreateTopicsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()}},
+					}},
+				}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					res: &protocol.Response{CorrelationID: 2, Body: &protocol.DescribeConfigsResponse{
+						Resources: []protocol.DescribeConfigsResourceResponse{{
+							Type: int8(structs.TopicConfigResource),
+							Name: "the-topic",
+							ConfigEntries: []protocol.DescribeConfigsEntry{
+								{Name: "cleanup.policy", Value: strPtr("delete"), IsDefault: true},
+								{Name: "retention.ms", Value: strPtr("604800000"), IsDefault: true},
+							},
+						}, {
+							Type:      int8(structs.TopicConfigResource),
+							Name:      "unknown-topic",
+							ErrorCode: protocol.ErrUnknownTopicOrPartition.Code(),
+						}, {
+							Type: int8(structs.BrokerConfigResource),
+							Name: "",
+							ConfigEntries: []protocol.DescribeConfigsEntry{
+								{Name: "log.retention.ms", Value: strPtr("604800000"), IsDefault: true},
+							},
+						}},
+					}},
+				}},
+			},
+		},
+		{
+			name: "alter configs",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req: &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+						Topic:             "the-topic",
+						NumPartitions:     1,
+						ReplicationFactor: 1,
+					}}}}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					req: &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+						{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+							{Name: "retention.bytes", Value: strPtr("1024")},
+							{Name: "cleanup.policy", Value: strPtr("compact")},
+						}},
+						{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+							{Name: "cleanup.policy", Value: strPtr("shred")},
+						}},
+						{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+							{Name: "no.such.config", Value: strPtr("1")},
+						}},
+						{Type: int8(structs.TopicConfigResource), Name: "unknown-topic"},
+					}}},
+				},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.CreateTopicsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()}},
+					}},
+				}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					res: &protocol.Response{CorrelationID: 2, Body: &protocol.AlterConfigsResponse{
+						Resources: []protocol.AlterConfigResourceResponse{
+							{Type: int8(structs.TopicConfigResource), Name: "the-topic", ErrorCode: protocol.ErrNone.Code()},
+							{Type: int8(structs.TopicConfigResource), Name: "the-topic", ErrorCode: protocol.ErrInvalidConfig.Code()},
+							{Type: int8(structs.TopicConfigResource), Name: "the-topic", ErrorCode: protocol.ErrInvalidConfig.Code()},
+							{Type: int8(structs.TopicConfigResource), Name: "unknown-topic", ErrorCode: protocol.ErrUnknownTopicOrPartition.Code()},
+						},
+					}},
+				}},
+			},
+			handle: func(t *testing.T, b *Broker, ctx *Context) {
+				if _, ok := ctx.req.(*protocol.AlterConfigsRequest); !ok {
+					return
+				}
+				_, topic, err := b.fsm.State().GetTopic("the-topic")
+				require.NoError(t, err)
+				require.Equal(t, "1024", fmt.Sprint(topic.Config.GetValue("retention.bytes")))
+				require.Equal(t, "compact", fmt.Sprint(topic.Config.GetValue("cleanup.policy")))
+				replica, err := b.replicaLookup.Replica("the-topic", 0)
+				require.NoError(t, err)
+				l := replica.Log.(*commitlog.CommitLog)
+				require.Equal(t, int64(1024), l.MaxLogBytes)
+				require.Equal(t, commitlog.CompactCleanupPolicy, l.CleanupPolicy)
+			},
+		},
+		{
+			name: "incremental alter configs",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req: &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+						Topic:             "the-topic",
+						NumPartitions:     1,
+						ReplicationFactor: 1,
+					}}}}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					req: &protocol.IncrementalAlterConfigsRequest{Resources: []protocol.IncrementalAlterConfigsResource{
+						{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.IncrementalAlterConfigsEntry{
+							{Name: "retention.bytes", Operation: protocol.ConfigOperationSet, Value: strPtr("1024")},
+							{Name: "leader.replication.throttled.replicas", Operation: protocol.ConfigOperationAppend, Value: strPtr("0:1")},
+							{Name: "leader.replication.throttled.replicas", Operation: protocol.ConfigOperationAppend, Value: strPtr("0:1,1:1")},
+						}},
+						{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.IncrementalAlterConfigsEntry{
+							{Name: "retention.ms", Operation: protocol.ConfigOperationAppend, Value: strPtr("1")},
+						}},
+					}}}, {
+					header: &protocol.RequestHeader{CorrelationID: 3},
+					req: &protocol.IncrementalAlterConfigsRequest{Resources: []protocol.IncrementalAlterConfigsResource{
+						{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.IncrementalAlterConfigsEntry{
+							{Name: "cleanup.policy", Operation: protocol.ConfigOperationSet, Value: strPtr("compact")},
+							{Name: "leader.replication.throttled.replicas", Operation: protocol.ConfigOperationSubtract, Value: strPtr("0:1")},
+						}},
+					}}},
+				},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.CreateTopicsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()}},
+					}},
+				}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					res: &protocol.Response{CorrelationID: 2, Body: &protocol.IncrementalAlterConfigsResponse{
+						Resources: []protocol.AlterConfigResourceResponse{
+							{Type: int8(structs.TopicConfigResource), Name: "the-topic", ErrorCode: protocol.ErrNone.Code()},
+							{Type: int8(structs.TopicConfigResource), Name: "the-topic", ErrorCode: protocol.ErrInvalidConfig.Code()},
+						},
+					}},
+				}, {
+					header: &protocol.RequestHeader{CorrelationID: 3},
+					res: &protocol.Response{CorrelationID: 3, Body: &protocol.IncrementalAlterConfigsResponse{
+						Resources: []protocol.AlterConfigResourceResponse{
+							{Type: int8(structs.TopicConfigResource), Name: "the-topic", ErrorCode: protocol.ErrNone.Code()},
+						},
+					}},
+				}},
+			},
+			handle: func(t *testing.T, b *Broker, ctx *Context) {
+				if _, ok := ctx.req.(*protocol.IncrementalAlterConfigsRequest); !ok {
+					return
+				}
+				_, topic, err := b.fsm.State().GetTopic("the-topic")
+				require.NoError(t, err)
+				// retention.bytes is kept by the later request that doesn't mention it
+				require.Equal(t, "1024", fmt.Sprint(topic.Config.GetValue("retention.bytes")))
+				throttled := fmt.Sprint(topic.Config.GetValue("leader.replication.throttled.replicas"))
+				if ctx.header.CorrelationID == 2 {
+					require.Equal(t, "0:1,1:1", throttled)
+					require.Equal(t, "delete", fmt.Sprint(topic.Config.GetValue("cleanup.policy")))
+				} else {
+					require.Equal(t, "1:1", throttled)
+					require.Equal(t, "compact", fmt.Sprint(topic.Config.GetValue("cleanup.policy")))
+				}
+			},
 		},
 		{
 			name: "offsets",
@@ -197,13 +582,60 @@ func TestBroker_Run(t *testing.T) {
 			},
 			handle: func(t *testing.T, _ *Broker, ctx *Context) {
 				switch res := ctx.res.(*protocol.Response).Body.(type) {
-				// handle timestamp explicitly since we don't know what
-				// it'll be set to
+				// check log append time explicitly since it's left out of the
+				// expected responses
 				case *protocol.ProduceResponse:
 					handleProduceResponse(t, res)
 				}
 			},
 		},
+		{
+			name: "produce corrupt message",
+			args: args{
+				requestCh:  make(chan *Context, 2),
+				responseCh: make(chan *Context, 2),
+				requests: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					req: &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+						Topic:             "the-topic",
+						NumPartitions:     1,
+						ReplicationFactor: 1,
+					}}}}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					req: &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+						Topic: "the-topic",
+						Data: []*protocol.Data{{
+							RecordSet: func() []byte {
+								b := mustEncode(&protocol.MessageSet{Offset: 0, Messages: []*protocol.Message{{Value: []byte("The message.")}}})
+								b[len(b)-1]++
+								return b
+							}()}}}}}},
+				},
+				responses: []*Context{{
+					header: &protocol.RequestHeader{CorrelationID: 1},
+					res: &protocol.Response{CorrelationID: 1, Body: &protocol.CreateTopicsResponse{
+						TopicErrorCodes: []*protocol.TopicErrorCode{{Topic: "the-topic", ErrorCode: protocol.ErrNone.Code()}},
+					}},
+				}, {
+					header: &protocol.RequestHeader{CorrelationID: 2},
+					res: &protocol.Response{CorrelationID: 2, Body: &protocol.ProduceResponse{
+						Responses: []*protocol.ProduceTopicResponse{{
+							Topic:              "the-topic",
+							PartitionResponses: []*protocol.ProducePartitionResponse{{Partition: 0, ErrorCode: protocol.ErrCorruptMessage.Code()}},
+						}},
+					}},
+				}},
+			},
+			handle: func(t *testing.T, b *Broker, ctx *Context) {
+				if _, ok := ctx.req.(*protocol.ProduceRequest); !ok {
+					return
+				}
+				// nothing should've been appended
+				replica, err := b.replicaLookup.Replica("the-topic", 0)
+				require.NoError(t, err)
+				require.Equal(t, int64(0), replica.Log.NewestOffset())
+			},
+		},
 		{
 			name: "fetch",
 			args: args{
@@ -266,8 +698,8 @@ func TestBroker_Run(t *testing.T) {
 			},
 			handle: func(t *testing.T, _ *Broker, ctx *Context) {
 				switch res := ctx.res.(*protocol.Response).Body.(type) {
-				// handle timestamp explicitly since we don't know what
-				// it'll be set to
+				// check log append time explicitly since it's left out of the
+				// expected responses
 				case *protocol.ProduceResponse:
 					handleProduceResponse(t, res)
 				}
@@ -320,19 +752,21 @@ func TestBroker_Run(t *testing.T) {
 					{
 						header: &protocol.RequestHeader{CorrelationID: 3},
 						res: &protocol.Response{CorrelationID: 3, Body: &protocol.MetadataResponse{
-							Brokers: []*protocol.Broker{{NodeID: 1, Host: "localhost", Port: 9092}},
+							Brokers:      []*protocol.Broker{{NodeID: 1, Host: "localhost", Port: 9092}},
+							ControllerID: 1,
 							TopicMetadata: []*protocol.TopicMetadata{
-								{Topic: "the-topic", TopicErrorCode: protocol.ErrNone.Code(), PartitionMetadata: []*protocol.PartitionMetadata{{PartitionErrorCode: protocol.ErrNone.Code(), PartitionID: 0, Leader: 1, Replicas: []int32{1}, ISR: []int32{1}}}},
-								{Topic: "unknown-topic", TopicErrorCode: protocol.ErrUnknownTopicOrPartition.Code()},
+								{Topic: "the-topic", TopicErrorCode: protocol.ErrNone.Code(), PartitionMetadata: []*protocol.PartitionMetadata{{PartitionErrorCode: protocol.ErrNone.Code(), PartitionID: 0, Leader: 1, Replicas: []int32{1}, ISR: []int32{1}}}, TopicAuthorizedOperations: protocol.AuthorizedOperationsOmitted},
+								{Topic: "unknown-topic", TopicErrorCode: protocol.ErrUnknownTopicOrPartition.Code(), TopicAuthorizedOperations: protocol.AuthorizedOperationsOmitted},
 							},
+							ClusterAuthorizedOperations: protocol.AuthorizedOperationsOmitted,
 						}},
 					},
 				},
 			},
 			handle: func(t *testing.T, _ *Broker, ctx *Context) {
 				switch res := ctx.res.(*protocol.Response).Body.(type) {
-				// handle timestamp explicitly since we don't know what
-				// it'll be set to
+				// check log append time explicitly since it's left out of the
+				// expected responses
 				case *protocol.ProduceResponse:
 					handleProduceResponse(t, res)
 				}
@@ -361,8 +795,8 @@ func TestBroker_Run(t *testing.T) {
 			},
 			handle: func(t *testing.T, _ *Broker, ctx *Context) {
 				switch res := ctx.res.(*protocol.Response).Body.(type) {
-				// handle timestamp explicitly since we don't know what
-				// it'll be set to
+				// check log append time explicitly since it's left out of the
+				// expected responses
 				case *protocol.ProduceResponse:
 					handleProduceResponse(t, res)
 				}
@@ -501,25 +935,2077 @@ func TestBroker_Shutdown(t *testing.T) {
 	}
 }
 
-type fields struct {
-	id     int32
-	logger log.Logger
-	logDir string
-}
+func TestBroker_traceRequest(t *testing.T) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	b := s.broker()
+	defer func() {
+		b.Shutdown()
+		teardown()
+	}()
+	retry.Run(t, func(r *retry.R) {
+		if !b.isLeader() {
+			r.Fatal("not leader")
+		}
+	})
 
-func newFields() fields {
-	return fields{
-		logger: log.New(),
-		logDir: "/tmp/jocko/logs",
-		id:     1,
+	produce := func(clientID, topic string) *Context {
+		return &Context{
+			header: &protocol.RequestHeader{ClientID: clientID},
+			req:    &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{Topic: topic}}},
+		}
 	}
+	require.False(t, b.traceRequest(produce("the-client", "the-topic")))
+
+	// set for all brokers
+	require.Equal(t, protocol.ErrNone, b.alterBrokerConfigs("", []protocol.AlterConfigsEntry{
+		{Name: traceClientIDsConfig, Value: strPtr("other-client, the-client")},
+	}, false))
+	require.True(t, b.traceRequest(produce("the-client", "the-topic")))
+	require.False(t, b.traceRequest(produce("another-client", "the-topic")))
+
+	// set for this broker, taking precedence
+	require.Equal(t, protocol.ErrNone, b.alterBrokerConfigs(fmt.Sprint(b.config.ID), []protocol.AlterConfigsEntry{
+		{Name: traceClientIDsConfig, Value: strPtr("")},
+		{Name: traceTopicsConfig, Value: strPtr("the-topic")},
+	}, false))
+	require.False(t, b.traceRequest(produce("the-client", "other-topic")))
+	require.True(t, b.traceRequest(produce("another-client", "the-topic")))
+	// an empty list doesn't trace clients without ids
+	require.False(t, b.traceRequest(produce("", "other-topic")))
 }
 
-func TestBroker_JoinLAN(t *testing.T) {
-	s1, t1 := NewTestServer(t, nil, nil)
-	b1 := s1.broker()
-	defer t1()
-	defer b1.Shutdown()
+func TestBroker_DescribeLogDirs(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     2,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Offset: 0, Messages: []*protocol.Message{{Value: []byte("The message.")}}})
+	require.NoError(t, err)
+	b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 1, RecordSet: recordSet}},
+	}}})
+
+	resp := b.handleDescribeLogDirs(ctx, &protocol.DescribeLogDirsRequest{})
+	require.Equal(t, []protocol.DescribeLogDirsResult{{
+		LogDir: filepath.Join(b.config.DataDir, "data"),
+		Topics: []protocol.DescribeLogDirsTopicResult{{
+			Topic: "the-topic",
+			Partitions: []protocol.DescribeLogDirsPartition{
+				{Partition: 0},
+				{Partition: 1, Size: int64(len(recordSet))},
+			},
+		}},
+	}}, resp.Results)
+
+	resp = b.handleDescribeLogDirs(ctx, &protocol.DescribeLogDirsRequest{Topics: []protocol.DescribeLogDirsTopic{
+		{Topic: "the-topic", Partitions: []int32{1}},
+		{Topic: "unknown-topic", Partitions: []int32{0}},
+	}})
+	require.Equal(t, []protocol.DescribeLogDirsTopicResult{{
+		Topic:      "the-topic",
+		Partitions: []protocol.DescribeLogDirsPartition{{Partition: 1, Size: int64(len(recordSet))}},
+	}}, resp.Results[0].Topics)
+}
+
+func TestBroker_AlterReplicaLogDirs(t *testing.T) {
+	var dirs []string
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.LogDirs = []string{filepath.Join(cfg.DataDir, "disk0"), filepath.Join(cfg.DataDir, "disk1")}
+		dirs = cfg.LogDirs
+	})
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Offset: 0, Messages: []*protocol.Message{{Value: []byte("The message.")}}})
+	require.NoError(t, err)
+	produce := func() {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+	produce()
+
+	resp := b.handleAlterReplicaLogDirs(ctx, &protocol.AlterReplicaLogDirsRequest{Dirs: []protocol.AlterReplicaLogDir{{
+		Path: dirs[1],
+		Topics: []protocol.AlterReplicaLogDirTopic{
+			{Topic: "the-topic", Partitions: []int32{0}},
+			{Topic: "unknown-topic", Partitions: []int32{0}},
+		},
+	}, {
+		Path:   filepath.Join(b.config.DataDir, "disk2"),
+		Topics: []protocol.AlterReplicaLogDirTopic{{Topic: "the-topic", Partitions: []int32{0}}},
+	}}})
+	require.Equal(t, []protocol.AlterReplicaLogDirTopicResult{
+		{Topic: "the-topic", Partitions: []protocol.AlterReplicaLogDirPartitionResult{{Partition: 0, ErrorCode: protocol.ErrNone.Code()}}},
+		{Topic: "unknown-topic", Partitions: []protocol.AlterReplicaLogDirPartitionResult{{Partition: 0, ErrorCode: protocol.ErrReplicaNotAvailable.Code()}}},
+		{Topic: "the-topic", Partitions: []protocol.AlterReplicaLogDirPartitionResult{{Partition: 0, ErrorCode: protocol.ErrLogDirNotFound.Code()}}},
+	}, resp.Topics)
+
+	retry.Run(t, func(r *retry.R) {
+		results := b.handleDescribeLogDirs(ctx, &protocol.DescribeLogDirsRequest{}).Results
+		if len(results[0].Topics) != 0 || len(results[1].Topics) != 1 || results[1].Topics[0].Partitions[0].IsFuture {
+			r.Fatalf("log not moved: %v", results)
+		}
+	})
+	_, err = os.Stat(filepath.Join(dirs[0], "the-topic-0"))
+	require.True(t, os.IsNotExist(err))
+
+	produce()
+	results := b.handleDescribeLogDirs(ctx, &protocol.DescribeLogDirsRequest{}).Results
+	require.Equal(t, []protocol.DescribeLogDirsPartition{{Partition: 0, Size: 2 * int64(len(recordSet))}}, results[1].Topics[0].Partitions)
+}
+
+func TestBroker_RebalanceLogDirs(t *testing.T) {
+	var dirs []string
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.LogDirs = []string{filepath.Join(cfg.DataDir, "disk0"), filepath.Join(cfg.DataDir, "disk1")}
+		dirs = cfg.LogDirs
+	})
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	produceResp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: testRecordBatch(1, 0, 0, 0)}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), produceResp.Responses[0].PartitionResponses[0].ErrorCode)
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	logDir := func() string {
+		replica.Lock()
+		defer replica.Unlock()
+		return replica.LogDir
+	}
+	require.Equal(t, dirs[0], logDir())
+
+	// the log's dir is full so the rebalance moves it to the other
+	full := dirs[0]
+	defer func(free func(string) (int64, error)) { logDirFree = free }(logDirFree)
+	logDirFree = func(dir string) (int64, error) {
+		if dir == full {
+			return 0, nil
+		}
+		return 1 << 20, nil
+	}
+	rebalance := func(request string) protocol.Error {
+		throttle := "1048576"
+		return b.alterBrokerConfigs(strconv.Itoa(int(b.config.ID)), []protocol.AlterConfigsEntry{
+			{Name: logDirsRebalanceConfig, Value: &request},
+			{Name: logDirsRebalanceThrottleConfig, Value: &throttle},
+		}, false)
+	}
+	require.Equal(t, protocol.ErrNone, rebalance("1"))
+	retry.Run(t, func(r *retry.R) {
+		if logDir() != dirs[1] {
+			r.Fatal("log not moved")
+		}
+	})
+	retry.Run(t, func(r *retry.R) {
+		v, err := ioutil.ReadFile(filepath.Join(b.config.DataDir, logDirsRebalancedFile))
+		if err != nil || string(v) != "1" {
+			r.Fatalf("rebalance not persisted: %q, %v", v, err)
+		}
+	})
+
+	// the completed rebalance isn't run again when the config's replayed after a restart
+	full = dirs[1]
+	b.logDirsRebalance.Lock()
+	b.logDirsRebalance.request = ""
+	b.logDirsRebalance.Unlock()
+	require.NoError(t, b.loadLogDirsRebalanced())
+	_, config, err := b.fsm.State().GetConfig(structs.BrokerConfigResource, strconv.Itoa(int(b.config.ID)))
+	require.NoError(t, err)
+	b.observeConfig(config)
+	b.logDirsRebalance.Lock()
+	running := b.logDirsRebalance.running
+	b.logDirsRebalance.Unlock()
+	require.False(t, running)
+	require.Equal(t, dirs[1], logDir())
+
+	// a new request does
+	require.Equal(t, protocol.ErrNone, rebalance("2"))
+	retry.Run(t, func(r *retry.R) {
+		if logDir() != dirs[0] {
+			r.Fatal("log not moved back")
+		}
+	})
+}
+
+func TestBroker_DeleteRecords(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Offset: 0, Messages: []*protocol.Message{{Value: []byte("The message.")}}})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+	}
+
+	resp := b.handleDeleteRecords(ctx, &protocol.DeleteRecordsRequest{Topics: []protocol.DeleteRecordsTopic{
+		{Topic: "the-topic", Partitions: []protocol.DeleteRecordsPartition{{Partition: 0, Offset: 2}}},
+		{Topic: "the-topic", Partitions: []protocol.DeleteRecordsPartition{{Partition: 0, Offset: 10}}},
+		{Topic: "unknown-topic", Partitions: []protocol.DeleteRecordsPartition{{Partition: 0, Offset: 1}}},
+	}})
+	require.Equal(t, []protocol.DeleteRecordsTopicResponse{
+		{Topic: "the-topic", Partitions: []protocol.DeleteRecordsPartitionResponse{{Partition: 0, LowWatermark: 2, ErrorCode: protocol.ErrNone.Code()}}},
+		{Topic: "the-topic", Partitions: []protocol.DeleteRecordsPartitionResponse{{Partition: 0, LowWatermark: -1, ErrorCode: protocol.ErrOffsetOutOfRange.Code()}}},
+		{Topic: "unknown-topic", Partitions: []protocol.DeleteRecordsPartitionResponse{{Partition: 0, LowWatermark: -1, ErrorCode: protocol.ErrUnknownTopicOrPartition.Code()}}},
+	}, resp.Topics)
+
+	// the deleted messages can't be fetched and the earliest offset is the log start offset
+	fetchResp := b.handleFetch(ctx, &protocol.FetchRequest{ReplicaID: -1, MaxWaitTime: 100, Topics: []*protocol.FetchTopic{{
+		Topic:      "the-topic",
+		Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: 1, MaxBytes: 100}},
+	}}})
+	require.Equal(t, protocol.ErrOffsetOutOfRange.Code(), fetchResp.Responses[0].PartitionResponses[0].ErrorCode)
+	offsetsResp := b.handleOffsets(ctx, &protocol.OffsetsRequest{ReplicaID: -1, Topics: []*protocol.OffsetsTopic{{
+		Topic:      "the-topic",
+		Partitions: []*protocol.OffsetsPartition{{Partition: 0, Timestamp: -2, MaxNumOffsets: 1}},
+	}}})
+	require.Equal(t, []int64{2}, offsetsResp.Responses[0].PartitionResponses[0].Offsets)
+
+	// -1 deletes up to the high watermark, which waits on the followers in the isr
+	addTestFollower(t, b, "the-topic", 0, 100)
+	deleteToHighWatermark := func() int64 {
+		resp := b.handleDeleteRecords(ctx, &protocol.DeleteRecordsRequest{Topics: []protocol.DeleteRecordsTopic{
+			{Topic: "the-topic", Partitions: []protocol.DeleteRecordsPartition{{Partition: 0, Offset: -1}}},
+		}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Topics[0].Partitions[0].ErrorCode)
+		return resp.Topics[0].Partitions[0].LowWatermark
+	}
+	followerFetch := func(offset int64) *protocol.FetchPartitionResponse {
+		resp := b.handleFetch(ctx, &protocol.FetchRequest{ReplicaID: 100, MaxWaitTime: 100, Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: offset, MaxBytes: 100}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0]
+	}
+	require.Equal(t, int64(2), deleteToHighWatermark())
+	// and the followers are sent the log start offset to delete their messages up to
+	require.Equal(t, int64(2), followerFetch(2).LogStartOffset)
+	require.Equal(t, int64(2), deleteToHighWatermark())
+	followerFetch(3)
+	require.Equal(t, int64(3), deleteToHighWatermark())
+}
+
+func TestBroker_FetchMaxBytes(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Offset: 0, Messages: []*protocol.Message{{Value: []byte("The message.")}}})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+	}
+
+	fetch := func(replicaID, maxBytes int32) *protocol.FetchPartitionResponse {
+		resp := b.handleFetch(ctx, &protocol.FetchRequest{ReplicaID: replicaID, MinBytes: 1, MaxWaitTime: 100, Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: 0, MaxBytes: maxBytes}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0]
+	}
+	// followers are sent the batches that fit whole
+	p := fetch(100, int32(len(recordSet)+10))
+	require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+	require.Equal(t, len(recordSet), len(p.RecordSet))
+	// and told when the first doesn't so they can fetch more
+	p = fetch(100, int32(len(recordSet)-1))
+	require.Equal(t, protocol.ErrMessageTooLarge.Code(), p.ErrorCode)
+	require.Equal(t, 0, len(p.RecordSet))
+	// consumers are still sent the first batch whatever its size
+	p = fetch(-1, int32(len(recordSet)-1))
+	require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+	require.NotEqual(t, 0, len(p.RecordSet))
+}
+
+func TestBroker_ProduceLogAppendTime(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	alterResp := b.handleAlterConfigs(ctx, &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+		{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+			{Name: "message.timestamp.type", Value: strPtr("LogAppendTime")},
+		}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), alterResp.Resources[0].ErrorCode)
+
+	created := time.Unix(1, 0)
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Messages: []*protocol.Message{{MagicByte: 1, Timestamp: created, Value: []byte("The message.")}}})
+	require.NoError(t, err)
+	resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+	}}})
+	pr := resp.Responses[0].PartitionResponses[0]
+	require.Equal(t, protocol.ErrNone.Code(), pr.ErrorCode)
+	require.False(t, pr.LogAppendTime.IsZero())
+
+	// the stored message has the log append time the response did rather than its create time
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	r, err := replica.Log.NewReader(0, int32(len(recordSet)))
+	require.NoError(t, err)
+	stored := make([]byte, len(recordSet))
+	_, err = io.ReadFull(r, stored)
+	require.NoError(t, err)
+	require.Equal(t, protocol.ErrNone, protocol.ValidateRecordSet(stored))
+	ms := new(protocol.MessageSet)
+	require.NoError(t, ms.Decode(protocol.NewDecoder(stored)))
+	require.Equal(t, pr.LogAppendTime.UnixNano()/int64(time.Millisecond), ms.Messages[0].Timestamp.UnixNano()/int64(time.Millisecond))
+}
+
+func TestBroker_PartitionCircuitBreaker(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.PartitionFailureThreshold = 2
+		cfg.PartitionFailureCooldown = 50 * time.Millisecond
+	})
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	failing := true
+	l := &mock.CommitLog{
+		AppendFunc: func(b []byte) (int64, error) {
+			if failing {
+				return 0, errors.New("input/output error")
+			}
+			return 0, nil
+		},
+		NewReaderFunc: func(offset int64, maxBytes int32) (io.Reader, error) {
+			if failing {
+				return nil, errors.New("input/output error")
+			}
+			return bytes.NewReader(nil), nil
+		},
+		// the log has a message for fetches to read, fetches at its end aren't read
+		NewestOffsetFunc: func() int64 { return 1 },
+		OldestOffsetFunc: func() int64 { return 0 },
+	}
+	replica.Log = l
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Offset: 0, Messages: []*protocol.Message{{Value: []byte("The message.")}}})
+	require.NoError(t, err)
+
+	produce := func() int16 {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	// the log's used until it's failed the threshold times in a row
+	require.Equal(t, protocol.ErrKafkaStorageError.Code(), produce())
+	require.Equal(t, protocol.ErrKafkaStorageError.Code(), produce())
+	require.Equal(t, protocol.ErrKafkaStorageError.Code(), produce())
+	require.Equal(t, 2, len(l.AppendCalls()))
+	// and the replica's offline, so the controller doesn't take it as caught up
+	offsets := func() int16 {
+		resp := b.handleOffsets(ctx, &protocol.OffsetsRequest{ReplicaID: -1, Topics: []*protocol.OffsetsTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.OffsetsPartition{{Partition: 0, Timestamp: -1, MaxNumOffsets: 1}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	require.Equal(t, protocol.ErrKafkaStorageError.Code(), offsets())
+
+	// after the cooldown the log's tried again and used once it works
+	time.Sleep(50 * time.Millisecond)
+	failing = false
+	require.Equal(t, protocol.ErrNone.Code(), produce())
+	require.Equal(t, protocol.ErrNone.Code(), produce())
+	require.Equal(t, 4, len(l.AppendCalls()))
+	require.Equal(t, protocol.ErrNone.Code(), offsets())
+
+	// fetches failing to read the log trip it too, and a failed try after the cooldown opens it
+	// again rather than leaving it stuck trying
+	fetch := func() int16 {
+		resp := b.handleFetch(ctx, &protocol.FetchRequest{ReplicaID: -1, Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: 0, MaxBytes: 100}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	failing = true
+	require.Equal(t, protocol.ErrKafkaStorageError.Code(), fetch())
+	require.Equal(t, protocol.ErrKafkaStorageError.Code(), fetch())
+	require.Equal(t, protocol.ErrKafkaStorageError.Code(), fetch())
+	require.Equal(t, 2, len(l.NewReaderCalls()))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, protocol.ErrKafkaStorageError.Code(), fetch())
+	require.Equal(t, 3, len(l.NewReaderCalls()))
+	time.Sleep(50 * time.Millisecond)
+	failing = false
+	require.Equal(t, protocol.ErrNone.Code(), fetch())
+}
+
+func TestBroker_OffsetForLeaderEpoch(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Offset: 0, Messages: []*protocol.Message{{Value: []byte("The message.")}}})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+	}
+
+	resp := b.handleOffsetForLeaderEpoch(ctx, &protocol.OffsetForLeaderEpochRequest{APIVersion: 2, Topics: []protocol.OffsetForLeaderEpochTopic{
+		{Topic: "the-topic", Partitions: []protocol.OffsetForLeaderEpochPartition{
+			{Partition: 0, CurrentLeaderEpoch: -1, LeaderEpoch: 0},
+			{Partition: 0, CurrentLeaderEpoch: 0, LeaderEpoch: 3},
+			{Partition: 0, CurrentLeaderEpoch: 1, LeaderEpoch: 0},
+		}},
+		{Topic: "unknown-topic", Partitions: []protocol.OffsetForLeaderEpochPartition{{Partition: 0, CurrentLeaderEpoch: -1, LeaderEpoch: 0}}},
+	}})
+	require.Equal(t, []protocol.OffsetForLeaderEpochTopicResponse{
+		{Topic: "the-topic", Partitions: []protocol.OffsetForLeaderEpochPartitionResponse{
+			{ErrorCode: protocol.ErrNone.Code(), Partition: 0, LeaderEpoch: 0, EndOffset: 2},
+			{ErrorCode: protocol.ErrNone.Code(), Partition: 0, LeaderEpoch: 0, EndOffset: 2},
+			{ErrorCode: protocol.ErrUnknownLeaderEpoch.Code(), Partition: 0, LeaderEpoch: -1, EndOffset: -1},
+		}},
+		{Topic: "unknown-topic", Partitions: []protocol.OffsetForLeaderEpochPartitionResponse{
+			{ErrorCode: protocol.ErrUnknownTopicOrPartition.Code(), Partition: 0, LeaderEpoch: -1, EndOffset: -1},
+		}},
+	}, resp.Topics)
+}
+
+func TestBroker_FetchV11(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	produceResp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: testRecordBatchWithHeaders("type", "one")}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), produceResp.Responses[0].PartitionResponses[0].ErrorCode)
+
+	fetch := func(sessionID, sessionEpoch, currentLeaderEpoch int32) *protocol.FetchResponse {
+		return b.handleFetch(ctx, &protocol.FetchRequest{
+			APIVersion:   11,
+			ReplicaID:    -1,
+			MinBytes:     1,
+			MaxBytes:     1 << 20,
+			SessionID:    sessionID,
+			SessionEpoch: sessionEpoch,
+			Topics: []*protocol.FetchTopic{{
+				Topic:      "the-topic",
+				Partitions: []*protocol.FetchPartition{{Partition: 0, CurrentLeaderEpoch: currentLeaderEpoch, MaxBytes: 1 << 20}},
+			}},
+		})
+	}
+	// consumers asking for a session get none and fetch in full
+	resp := fetch(0, 0, 0)
+	require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	require.Equal(t, int32(0), resp.SessionID)
+	p := resp.Responses[0].PartitionResponses[0]
+	require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+	require.Equal(t, int32(-1), p.PreferredReadReplica)
+	require.NotEmpty(t, p.RecordSet)
+
+	// incremental fetches are refused
+	resp = fetch(1, 1, -1)
+	require.Equal(t, protocol.ErrFetchSessionIDNotFound.Code(), resp.ErrorCode)
+	require.Empty(t, resp.Responses)
+	require.Equal(t, protocol.ErrInvalidFetchSessionEpoch.Code(), fetch(0, 1, -1).ErrorCode)
+
+	// consumers with a newer view of the partition's leadership are fenced
+	resp = fetch(0, -1, 1)
+	require.Equal(t, protocol.ErrUnknownLeaderEpoch.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+}
+
+func TestBroker_RecordHeaders(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	records := []protocol.Record{
+		{Value: []byte("one"), Headers: []protocol.RecordHeader{{Key: "trace-id", Value: []byte("abc")}, {Key: "route", Value: []byte("eu")}}},
+		// empty and null header values are kept apart
+		{OffsetDelta: 1, Value: []byte("two"), Headers: []protocol.RecordHeader{{Key: "empty", Value: []byte{}}, {Key: "null"}}},
+		{OffsetDelta: 2, Value: []byte("three")},
+	}
+	batch := &protocol.RecordBatch{LastOffsetDelta: 2, ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1, Records: records}
+	produceResp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: batch.Bytes()}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), produceResp.Responses[0].PartitionResponses[0].ErrorCode)
+
+	// v4 is the first fetch version returning v2 record batches, which have the headers
+	resp := b.handleFetch(ctx, &protocol.FetchRequest{
+		APIVersion: 4,
+		ReplicaID:  -1,
+		MinBytes:   1,
+		MaxBytes:   1 << 20,
+		Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, MaxBytes: 1 << 20}},
+		}},
+	})
+	p := resp.Responses[0].PartitionResponses[0]
+	require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+	batches, err := protocol.ReadRecordBatches(p.RecordSet)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(batches))
+	require.Equal(t, records, batches[0].Records)
+	value, ok := batches[0].Records[0].Header("route")
+	require.True(t, ok)
+	require.Equal(t, []byte("eu"), value)
+	_, ok = batches[0].Records[2].Header("route")
+	require.False(t, ok)
+}
+
+func TestBroker_ProduceCompressionType(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	alterResp := b.handleAlterConfigs(ctx, &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+		{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+			{Name: "compression.type", Value: strPtr("snappy")},
+		}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), alterResp.Resources[0].ErrorCode)
+
+	records := []protocol.Record{{Key: []byte("key"), Value: []byte("value")}}
+	batch := &protocol.RecordBatch{ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1, Records: records}
+	resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: batch.Bytes()}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+
+	// the uncompressed batch is stored compressed with the topic's codec
+	fetchResp := b.handleFetch(ctx, &protocol.FetchRequest{
+		APIVersion: 4,
+		ReplicaID:  -1,
+		MinBytes:   1,
+		MaxBytes:   1 << 20,
+		Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, MaxBytes: 1 << 20}},
+		}},
+	})
+	p := fetchResp.Responses[0].PartitionResponses[0]
+	require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+	batches, err := protocol.ReadRecordBatches(p.RecordSet)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(batches))
+	require.Equal(t, protocol.CompressionSnappy, batches[0].Compression())
+	require.Equal(t, records, batches[0].Records)
+
+	// accepting batches as the producers compressed them only compresses uncompressed ones
+	alterResp = b.handleAlterConfigs(ctx, &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+		{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+			{Name: "compression.type", Value: strPtr("snappy")},
+			{Name: "compression.recompression.policy", Value: strPtr("accept")},
+		}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), alterResp.Resources[0].ErrorCode)
+	lz4 := &protocol.RecordBatch{Attributes: int16(protocol.CompressionLZ4), ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1, Records: records}
+	resp = b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: append(lz4.Bytes(), batch.Bytes()...)}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	fetchResp = b.handleFetch(ctx, &protocol.FetchRequest{
+		APIVersion: 4,
+		ReplicaID:  -1,
+		MinBytes:   1,
+		MaxBytes:   1 << 20,
+		Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: 1, MaxBytes: 1 << 20}},
+		}},
+	})
+	p = fetchResp.Responses[0].PartitionResponses[0]
+	require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+	batches, err = protocol.ReadRecordBatches(p.RecordSet)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(batches))
+	require.Equal(t, protocol.CompressionLZ4, batches[0].Compression())
+	require.Equal(t, protocol.CompressionSnappy, batches[1].Compression())
+
+	// and gzip at the topic's level
+	alterResp = b.handleAlterConfigs(ctx, &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+		{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+			{Name: "compression.type", Value: strPtr("gzip")},
+			{Name: "compression.gzip.level", Value: strPtr("9")},
+		}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), alterResp.Resources[0].ErrorCode)
+	resp = b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: batch.Bytes()}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	fetchResp = b.handleFetch(ctx, &protocol.FetchRequest{
+		APIVersion: 4,
+		ReplicaID:  -1,
+		MinBytes:   1,
+		MaxBytes:   1 << 20,
+		Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: 3, MaxBytes: 1 << 20}},
+		}},
+	})
+	p = fetchResp.Responses[0].PartitionResponses[0]
+	require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+	batches, err = protocol.ReadRecordBatches(p.RecordSet)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(batches))
+	require.Equal(t, protocol.CompressionGZIP, batches[0].Compression())
+	require.Equal(t, records, batches[0].Records)
+
+	// gzip levels are 1 to 9, or -1 for its default
+	alterResp = b.handleAlterConfigs(ctx, &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+		{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+			{Name: "compression.gzip.level", Value: strPtr("10")},
+		}},
+	}})
+	require.NotEqual(t, protocol.ErrNone.Code(), alterResp.Resources[0].ErrorCode)
+
+	// codecs the topic's config doesn't know are refused
+	alterResp = b.handleAlterConfigs(ctx, &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+		{Type: int8(structs.TopicConfigResource), Name: "the-topic", Entries: []protocol.AlterConfigsEntry{
+			{Name: "compression.type", Value: strPtr("brotli")},
+		}},
+	}})
+	require.NotEqual(t, protocol.ErrNone.Code(), alterResp.Resources[0].ErrorCode)
+}
+
+func TestBroker_ProduceZstd(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	for _, topic := range []string{"the-topic", "zstd-topic"} {
+		createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             topic,
+			NumPartitions:     1,
+			ReplicationFactor: 1,
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	}
+	alterResp := b.handleAlterConfigs(ctx, &protocol.AlterConfigsRequest{Resources: []protocol.AlterConfigsResource{
+		{Type: int8(structs.TopicConfigResource), Name: "zstd-topic", Entries: []protocol.AlterConfigsEntry{
+			{Name: "compression.type", Value: strPtr("zstd")},
+		}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), alterResp.Resources[0].ErrorCode)
+
+	records := []protocol.Record{{Key: []byte("key"), Value: []byte("value")}}
+	produce := func(version int16, topic string, c int8) int16 {
+		batch := &protocol.RecordBatch{Attributes: int16(c), ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1, Records: records}
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{APIVersion: version, TopicData: []*protocol.TopicData{{
+			Topic: topic,
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: batch.Bytes()}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	// clients too old for zstd can't produce it, or produce to topics compressed with it
+	require.Equal(t, protocol.ErrUnsupportedCompressionType.Code(), produce(5, "the-topic", protocol.CompressionZstd))
+	require.Equal(t, protocol.ErrUnsupportedCompressionType.Code(), produce(5, "zstd-topic", protocol.CompressionNone))
+	require.Equal(t, protocol.ErrNone.Code(), produce(7, "the-topic", protocol.CompressionZstd))
+	require.Equal(t, protocol.ErrNone.Code(), produce(7, "zstd-topic", protocol.CompressionNone))
+
+	fetch := func(version int16, topic string) *protocol.FetchPartitionResponse {
+		fetchResp := b.handleFetch(ctx, &protocol.FetchRequest{
+			APIVersion: version,
+			ReplicaID:  -1,
+			MinBytes:   1,
+			MaxBytes:   1 << 20,
+			Topics: []*protocol.FetchTopic{{
+				Topic:      topic,
+				Partitions: []*protocol.FetchPartition{{Partition: 0, CurrentLeaderEpoch: -1, MaxBytes: 1 << 20}},
+			}},
+		})
+		return fetchResp.Responses[0].PartitionResponses[0]
+	}
+	for _, topic := range []string{"the-topic", "zstd-topic"} {
+		require.Equal(t, protocol.ErrUnsupportedCompressionType.Code(), fetch(4, topic).ErrorCode)
+		p := fetch(protocol.ZstdMinFetchVersion, topic)
+		require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+		batches, err := protocol.ReadRecordBatches(p.RecordSet)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(batches))
+		require.Equal(t, protocol.CompressionZstd, batches[0].Compression())
+		require.Equal(t, records, batches[0].Records)
+	}
+}
+
+func TestBroker_OffsetsByTimestamp(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+
+	batch := func(firstTimestamp int64, deltas ...int64) []byte {
+		b := &protocol.RecordBatch{LastOffsetDelta: int32(len(deltas) - 1), FirstTimestamp: firstTimestamp, ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1}
+		for i, delta := range deltas {
+			b.Records = append(b.Records, protocol.Record{OffsetDelta: int32(i), TimestampDelta: delta, Value: []byte("value")})
+			b.MaxTimestamp = firstTimestamp + delta
+		}
+		return b.Bytes()
+	}
+	for _, recordSet := range [][]byte{batch(1000, 0, 100, 200), batch(2000, 0)} {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+
+	offsets := func(version int16, timestamp int64) *protocol.PartitionResponse {
+		resp := b.handleOffsets(ctx, &protocol.OffsetsRequest{APIVersion: version, ReplicaID: -1, Topics: []*protocol.OffsetsTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.OffsetsPartition{{Partition: 0, Timestamp: timestamp, MaxNumOffsets: 1}},
+		}}})
+		p := resp.Responses[0].PartitionResponses[0]
+		require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+		return p
+	}
+	// the earliest record at or after the timestamp, inside its batch
+	p := offsets(1, 1050)
+	require.Equal(t, int64(1), p.Offset)
+	require.Equal(t, time.Unix(1, 100*int64(time.Millisecond)), p.Timestamp)
+	require.Equal(t, int64(2), offsets(1, 1200).Offset)
+	p = offsets(1, 1500)
+	require.Equal(t, int64(3), p.Offset)
+	require.Equal(t, time.Unix(2, 0), p.Timestamp)
+	require.Equal(t, int64(0), offsets(1, 0).Offset)
+
+	// nothing's that late
+	p = offsets(1, 3000)
+	require.Equal(t, int64(-1), p.Offset)
+	require.True(t, p.Timestamp.IsZero())
+	require.Equal(t, []int64{1}, offsets(0, 1050).Offsets)
+	require.Empty(t, offsets(0, 3000).Offsets)
+}
+
+func TestBroker_MetadataTopology(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.ClusterID = "the-cluster"
+		cfg.Rack = "rack-1"
+	})
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+
+	// the partition has a replica on a broker that isn't alive
+	_, p, err := b.fsm.State().GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	pp := *p
+	pp.AR, pp.LeaderEpoch = []int32{b.config.ID, 2}, 3
+	_, err = b.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: pp})
+	require.NoError(t, err)
+
+	resp := b.handleMetadata(ctx, &protocol.MetadataRequest{APIVersion: 8, Topics: []string{"the-topic"}})
+	require.Equal(t, int16(8), resp.APIVersion)
+	require.Equal(t, "the-cluster", *resp.ClusterID)
+	require.Equal(t, b.config.ID, resp.ControllerID)
+	require.Equal(t, 1, len(resp.Brokers))
+	require.Equal(t, "rack-1", *resp.Brokers[0].Rack)
+	require.Equal(t, protocol.AuthorizedOperationsOmitted, resp.ClusterAuthorizedOperations)
+	require.Equal(t, 1, len(resp.TopicMetadata))
+	partition := resp.TopicMetadata[0].PartitionMetadata[0]
+	require.Equal(t, []int32{b.config.ID, 2}, partition.Replicas)
+	require.Equal(t, []int32{2}, partition.OfflineReplicas)
+	require.Equal(t, int32(3), partition.LeaderEpoch)
+
+	// the response encodes at every version
+	for version := int16(0); version <= 8; version++ {
+		resp.APIVersion = version
+		_, err := protocol.Encode(resp)
+		require.NoError(t, err)
+	}
+}
+
+func TestBroker_ElectLeaders(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+
+	resp := b.handleElectLeaders(ctx, &protocol.ElectLeadersRequest{APIVersion: 1, Timeout: 10 * time.Second, Topics: []protocol.ElectLeadersTopic{
+		{Topic: "the-topic", Partitions: []int32{0, 1}},
+	}})
+	require.Equal(t, []protocol.ElectLeadersTopicResult{{Topic: "the-topic", Partitions: []protocol.ElectLeadersPartitionResult{
+		{Partition: 0, ErrorCode: protocol.ErrElectionNotNeeded.Code()},
+		{Partition: 1, ErrorCode: protocol.ErrUnknownTopicOrPartition.Code()},
+	}}}, resp.Topics)
+
+	// the partition's led by a broker that's failed and was its only in-sync replica, and its
+	// preferred replica's failed too
+	for _, id := range []int32{2, 3} {
+		_, err := b.raftApply(structs.RegisterNodeRequestType, structs.RegisterNodeRequest{Node: structs.Node{
+			Node:  id,
+			Check: &structs.HealthCheck{Status: structs.HealthCritical},
+		}})
+		require.NoError(t, err)
+	}
+	_, p, err := b.fsm.State().GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	pp := *p
+	pp.Leader, pp.AR, pp.ISR = 2, []int32{3, 2, b.config.ID}, []int32{2}
+	_, err = b.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: pp})
+	require.NoError(t, err)
+
+	// without a timeout it waits up to the broker's own
+	resp = b.handleElectLeaders(ctx, &protocol.ElectLeadersRequest{APIVersion: 1})
+	require.Equal(t, []protocol.ElectLeadersTopicResult{{Topic: "the-topic", Partitions: []protocol.ElectLeadersPartitionResult{
+		{Partition: 0, ErrorCode: protocol.ErrPreferredLeaderNotAvailable.Code()},
+	}}}, resp.Topics)
+
+	resp = b.handleElectLeaders(ctx, &protocol.ElectLeadersRequest{APIVersion: 1, ElectionType: protocol.UncleanElection, Timeout: 10 * time.Second, Topics: []protocol.ElectLeadersTopic{
+		{Topic: "the-topic", Partitions: []int32{0}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	require.Equal(t, protocol.ErrNone.Code(), resp.Topics[0].Partitions[0].ErrorCode)
+	_, p, err = b.fsm.State().GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, b.config.ID, p.Leader)
+	require.Equal(t, []int32{b.config.ID}, p.ISR)
+	require.Equal(t, pp.LeaderEpoch+1, p.LeaderEpoch)
+}
+
+func TestBroker_PartitionReassignments(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		// the reassignment mustn't be completed or the failed broker reaped while it's checked
+		cfg.ReconcileInterval = time.Hour
+	})
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	// the partition's reassigned to a broker that's failed so it never catches up
+	_, err := b.raftApply(structs.RegisterNodeRequestType, structs.RegisterNodeRequest{Node: structs.Node{
+		Node:  2,
+		Check: &structs.HealthCheck{Status: structs.HealthCritical},
+	}})
+	require.NoError(t, err)
+	id := b.config.ID
+
+	resp := b.handleAlterPartitionReassignments(ctx, &protocol.AlterPartitionReassignmentsRequest{Timeout: 10 * time.Second, Topics: []protocol.AlterPartitionReassignmentsTopic{
+		{Topic: "the-topic", Partitions: []protocol.AlterPartitionReassignmentsPartition{
+			{Partition: 0, Replicas: []int32{id, 2}},
+			{Partition: 1, Replicas: []int32{id}},
+		}},
+		{Topic: "another-topic", Partitions: []protocol.AlterPartitionReassignmentsPartition{{Partition: 0, Replicas: []int32{id}}}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	require.Equal(t, []protocol.AlterPartitionReassignmentsTopicResponse{
+		{Topic: "the-topic", Partitions: []protocol.AlterPartitionReassignmentsPartitionResponse{
+			{Partition: 0},
+			{Partition: 1, ErrorCode: protocol.ErrUnknownTopicOrPartition.Code()},
+		}},
+		{Topic: "another-topic", Partitions: []protocol.AlterPartitionReassignmentsPartitionResponse{
+			{Partition: 0, ErrorCode: protocol.ErrUnknownTopicOrPartition.Code()},
+		}},
+	}, resp.Topics)
+
+	listResp := b.handleListPartitionReassignments(ctx, &protocol.ListPartitionReassignmentsRequest{})
+	require.Equal(t, protocol.ErrNone.Code(), listResp.ErrorCode)
+	require.Equal(t, []protocol.ListPartitionReassignmentsTopicResponse{{Topic: "the-topic", Partitions: []protocol.OngoingPartitionReassignment{
+		{Partition: 0, Replicas: []int32{id, 2}, AddingReplicas: []int32{2}},
+	}}}, listResp.Topics)
+	_, p, err := b.fsm.State().GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, id, p.Leader)
+	require.Equal(t, []int32{id}, p.ISR)
+
+	// repeated replicas and unknown brokers can't be assigned
+	for _, replicas := range [][]int32{{}, {id, id}, {id, 3}} {
+		resp = b.handleAlterPartitionReassignments(ctx, &protocol.AlterPartitionReassignmentsRequest{Topics: []protocol.AlterPartitionReassignmentsTopic{
+			{Topic: "the-topic", Partitions: []protocol.AlterPartitionReassignmentsPartition{{Partition: 0, Replicas: replicas}}},
+		}})
+		require.Equal(t, protocol.ErrInvalidReplicaAssignment.Code(), resp.Topics[0].Partitions[0].ErrorCode)
+	}
+
+	// cancelling moves the partition back to its original replicas
+	cancel := &protocol.AlterPartitionReassignmentsRequest{Topics: []protocol.AlterPartitionReassignmentsTopic{
+		{Topic: "the-topic", Partitions: []protocol.AlterPartitionReassignmentsPartition{{Partition: 0}}},
+	}}
+	resp = b.handleAlterPartitionReassignments(ctx, cancel)
+	require.Equal(t, protocol.ErrNone.Code(), resp.Topics[0].Partitions[0].ErrorCode)
+	_, p, err = b.fsm.State().GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, []int32{id}, p.AR)
+	require.Empty(t, p.AddingReplicas)
+	listResp = b.handleListPartitionReassignments(ctx, &protocol.ListPartitionReassignmentsRequest{Topics: []protocol.ListPartitionReassignmentsTopic{
+		{Topic: "the-topic", Partitions: []int32{0}},
+	}})
+	require.Empty(t, listResp.Topics)
+
+	resp = b.handleAlterPartitionReassignments(ctx, cancel)
+	require.Equal(t, protocol.ErrNoReassignmentInProgress.Code(), resp.Topics[0].Partitions[0].ErrorCode)
+}
+
+func TestBroker_Transactions(t *testing.T) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	b := s.broker()
+	defer func() {
+		b.Shutdown()
+		teardown()
+	}()
+	ctx := &Context{parent: context.Background()}
+
+	listResp := b.handleListTransactions(ctx, &protocol.ListTransactionsRequest{
+		StateFilters: []string{protocol.TransactionStateOngoing, "Stuck"},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), listResp.ErrorCode)
+	require.Equal(t, []string{"Stuck"}, listResp.UnknownStateFilters)
+	require.Empty(t, listResp.TransactionStates)
+
+	describeResp := b.handleDescribeTransactions(ctx, &protocol.DescribeTransactionsRequest{
+		TransactionalIDs: []string{"the-txn"},
+	})
+	require.Equal(t, 1, len(describeResp.TransactionStates))
+	require.Equal(t, "the-txn", describeResp.TransactionStates[0].TransactionalID)
+	require.Equal(t, protocol.ErrTransactionalIdNotFound.Code(), describeResp.TransactionStates[0].ErrorCode)
+}
+
+func TestBroker_FindTransactionCoordinator(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	resp := b.handleFindCoordinator(ctx, &protocol.FindCoordinatorRequest{
+		APIVersion:      1,
+		CoordinatorKey:  "the-txn",
+		CoordinatorType: protocol.CoordinatorTransaction,
+	})
+	require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	require.Equal(t, b.config.ID, resp.Coordinator.NodeID)
+	// the transaction state topic's created to find the coordinator, not the offsets topic
+	_, topic, err := b.fsm.State().GetTopic(TransactionStateTopicName)
+	require.NoError(t, err)
+	require.NotNil(t, topic)
+	_, topic, err = b.fsm.State().GetTopic("__consumer_offsets")
+	require.NoError(t, err)
+	require.Nil(t, topic)
+
+	resp = b.handleFindCoordinator(ctx, &protocol.FindCoordinatorRequest{
+		APIVersion:      1,
+		CoordinatorKey:  "the-txn",
+		CoordinatorType: 2,
+	})
+	require.Equal(t, protocol.ErrInvalidRequest.Code(), resp.ErrorCode)
+}
+
+func TestBroker_OffsetCommit(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	commit := func(generationID int32, memberID string, offset int64) protocol.Error {
+		resp := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+			APIVersion:   1,
+			GroupID:      "the-group",
+			GenerationID: generationID,
+			MemberID:     memberID,
+			Topics: []protocol.OffsetCommitTopicRequest{{
+				Topic:      "the-topic",
+				Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: offset}},
+			}},
+		})
+		return protocol.Errs[resp.Responses[0].PartitionResponses[0].ErrorCode]
+	}
+	fetch := func() int64 {
+		resp := b.handleOffsetFetch(ctx, &protocol.OffsetFetchRequest{
+			APIVersion: 1,
+			GroupID:    "the-group",
+			Topics:     []protocol.OffsetFetchTopicRequest{{Topic: "the-topic", Partitions: []int32{0}}},
+		})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].Partitions[0].ErrorCode)
+		return resp.Responses[0].Partitions[0].Offset
+	}
+
+	require.Equal(t, int64(-1), fetch())
+
+	// the group's empty so its offsets can be reset
+	require.Equal(t, protocol.ErrNone, commit(-1, "", 5))
+	require.Equal(t, int64(5), fetch())
+
+	join := b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{GroupID: "the-group", SessionTimeout: 10000})
+	require.Equal(t, protocol.ErrNone.Code(), join.ErrorCode)
+
+	// members commit, but resetting the live group's offsets is rejected
+	require.Equal(t, protocol.ErrNone, commit(join.GenerationID, join.MemberID, 7))
+	require.Equal(t, protocol.ErrNonEmptyGroup, commit(-1, "", 0))
+	require.Equal(t, protocol.ErrUnknownMemberId, commit(join.GenerationID, "unknown-member", 0))
+	require.Equal(t, int64(7), fetch())
+
+	b.config.AllowLiveGroupOffsetReset = true
+	require.Equal(t, protocol.ErrNone, commit(-1, "", 0))
+	require.Equal(t, int64(0), fetch())
+}
+
+func TestBroker_OffsetCommitMetadata(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.OffsetMetadataMaxBytes = 8
+	})
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	metadata, tooLarge := "state", "too much state"
+	resp := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+		APIVersion:   1,
+		GroupID:      "the-group",
+		GenerationID: -1,
+		Topics: []protocol.OffsetCommitTopicRequest{{
+			Topic: "the-topic",
+			Partitions: []protocol.OffsetCommitPartitionRequest{
+				{Partition: 0, Offset: 5, Metadata: &metadata},
+				{Partition: 1, Offset: 6},
+				{Partition: 2, Offset: 7, Metadata: &tooLarge},
+			},
+		}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[1].ErrorCode)
+	require.Equal(t, protocol.ErrOffsetMetadataTooLarge.Code(), resp.Responses[0].PartitionResponses[2].ErrorCode)
+
+	fetch := b.handleOffsetFetch(ctx, &protocol.OffsetFetchRequest{
+		APIVersion: 1,
+		GroupID:    "the-group",
+		Topics:     []protocol.OffsetFetchTopicRequest{{Topic: "the-topic", Partitions: []int32{0, 1, 2}}},
+	})
+	partitions := fetch.Responses[0].Partitions
+	require.Equal(t, int64(5), partitions[0].Offset)
+	require.Equal(t, &metadata, partitions[0].Metadata)
+	require.Equal(t, int64(6), partitions[1].Offset)
+	require.Nil(t, partitions[1].Metadata)
+	// the partition with metadata too large wasn't committed
+	require.Equal(t, int64(-1), partitions[2].Offset)
+}
+
+func TestBroker_LoadGroups(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	find := b.handleFindCoordinator(ctx, &protocol.FindCoordinatorRequest{
+		CoordinatorKey:  "the-group",
+		CoordinatorType: protocol.CoordinatorGroup,
+	})
+	require.Equal(t, protocol.ErrNone.Code(), find.ErrorCode)
+	require.Equal(t, b.config.ID, find.Coordinator.NodeID)
+	_, topic, err := b.fsm.State().GetTopic(OffsetsTopicName)
+	require.NoError(t, err)
+	require.Equal(t, commitlog.CompactCleanupPolicy, topic.Config.GetValue("cleanup.policy"))
+
+	metadata := "the-metadata"
+	commit := func(group, memberID string, generationID int32, offset int64) {
+		resp := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+			APIVersion:   1,
+			GroupID:      group,
+			GenerationID: generationID,
+			MemberID:     memberID,
+			Topics: []protocol.OffsetCommitTopicRequest{{
+				Topic:      "the-topic",
+				Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: offset, Metadata: &metadata}},
+			}},
+		})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+	join := b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{GroupID: "the-group", ProtocolType: "consumer", SessionTimeout: 10000})
+	require.Equal(t, protocol.ErrNone.Code(), join.ErrorCode)
+	sync := b.handleSyncGroup(ctx, &protocol.SyncGroupRequest{
+		GroupID:          "the-group",
+		MemberID:         join.MemberID,
+		GroupAssignments: []protocol.GroupAssignment{{MemberID: join.MemberID, MemberAssignment: []byte{1}}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), sync.ErrorCode)
+	commit("the-group", join.MemberID, join.GenerationID, 7)
+	// the deleted group's tombstoned
+	commit("deleted-group", "", -1, 3)
+	deleted := b.handleDeleteGroups(ctx, &protocol.DeleteGroupsRequest{GroupsNames: []string{"deleted-group"}})
+	require.Equal(t, protocol.ErrNone.Code(), deleted.Results[0].ErrorCode)
+
+	// the group's lost its coordinator's state, and is loaded back from its offsets partition
+	partition := offsetsPartition("the-group", len(topic.Partitions))
+	replica, err := b.replicaLookup.Replica(OffsetsTopicName, partition)
+	require.NoError(t, err)
+	b.groupPartitions.unload(partition)
+	_, err = b.raftApply(structs.RegisterGroupRequestType, structs.RegisterGroupRequest{
+		Group: structs.Group{Group: "the-group", Coordinator: b.config.ID + 1},
+	})
+	require.NoError(t, err)
+	fetch := b.handleOffsetFetch(ctx, &protocol.OffsetFetchRequest{
+		APIVersion: 1,
+		GroupID:    "the-group",
+		Topics:     []protocol.OffsetFetchTopicRequest{{Topic: "the-topic", Partitions: []int32{0}}},
+	})
+	require.Equal(t, protocol.ErrCoordinatorLoadInProgress.Code(), fetch.Responses[0].Partitions[0].ErrorCode)
+
+	b.loadGroups(replica)
+	_, group, err := b.fsm.State().GetGroup("the-group")
+	require.NoError(t, err)
+	require.Equal(t, b.config.ID, group.Coordinator)
+	require.Equal(t, structs.GroupStateStable, group.State)
+	require.Equal(t, join.MemberID, group.LeaderID)
+	require.Equal(t, []byte{1}, group.Members[join.MemberID].Assignment)
+	require.Equal(t, 1, len(group.Offsets["the-topic"]))
+	require.Equal(t, int64(7), group.Offsets["the-topic"][0].Offset)
+	// with when it was committed so it still expires
+	require.False(t, group.Offsets["the-topic"][0].CommitTime.IsZero())
+	require.Equal(t, &metadata, group.Offsets["the-topic"][0].Metadata)
+	_, group, err = b.fsm.State().GetGroup("deleted-group")
+	require.NoError(t, err)
+	require.Nil(t, group)
+}
+
+func TestBroker_GroupCoordinatorFailover(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	find := b.handleFindCoordinator(ctx, &protocol.FindCoordinatorRequest{
+		CoordinatorKey:  "the-group",
+		CoordinatorType: protocol.CoordinatorGroup,
+	})
+	require.Equal(t, protocol.ErrNone.Code(), find.ErrorCode)
+	join := b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{GroupID: "the-group", ProtocolType: "consumer", SessionTimeout: 10000})
+	require.Equal(t, protocol.ErrNone.Code(), join.ErrorCode)
+	heartbeat := func() int16 {
+		return b.handleHeartbeat(ctx, &protocol.HeartbeatRequest{GroupID: "the-group", MemberID: join.MemberID}).ErrorCode
+	}
+	require.Equal(t, protocol.ErrNone.Code(), heartbeat())
+
+	_, topic, err := b.fsm.State().GetTopic(OffsetsTopicName)
+	require.NoError(t, err)
+	partition := offsetsPartition("the-group", len(topic.Partitions))
+	replica, err := b.replicaLookup.Replica(OffsetsTopicName, partition)
+	require.NoError(t, err)
+	leaderAndISR := func(leader, leaderEpoch int32) {
+		b.handleLeaderAndISR(ctx, &protocol.LeaderAndISRRequest{PartitionStates: []*protocol.PartitionState{{
+			Topic:       OffsetsTopicName,
+			Partition:   partition,
+			Leader:      leader,
+			LeaderEpoch: leaderEpoch,
+			ISR:         []int32{leader},
+			Replicas:    []int32{b.config.ID, b.config.ID + 1},
+		}}})
+	}
+
+	// the partition's leadership moves to another broker, so clients are sent to find it
+	leaderAndISR(b.config.ID+1, replica.Partition.LeaderEpoch+1)
+	require.Equal(t, protocol.ErrNotCoordinator.Code(), heartbeat())
+	commit := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+		APIVersion:   1,
+		GroupID:      "the-group",
+		GenerationID: join.GenerationID,
+		MemberID:     join.MemberID,
+		Topics: []protocol.OffsetCommitTopicRequest{{
+			Topic:      "the-topic",
+			Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: 1}},
+		}},
+	})
+	require.Equal(t, protocol.ErrNotCoordinator.Code(), commit.Responses[0].PartitionResponses[0].ErrorCode)
+
+	// and back, the broker loads the group again and takes over coordinating it
+	leaderAndISR(b.config.ID, replica.Partition.LeaderEpoch+2)
+	require.Equal(t, protocol.ErrNone.Code(), heartbeat())
+	_, group, err := b.fsm.State().GetGroup("the-group")
+	require.NoError(t, err)
+	require.Equal(t, b.config.ID, group.Coordinator)
+	require.Contains(t, group.Members, join.MemberID)
+}
+
+func TestBroker_DescribeGroups(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background(), header: &protocol.RequestHeader{ClientID: "the-client"}}
+
+	join := func(protocols ...*protocol.GroupProtocol) *protocol.JoinGroupResponse {
+		return b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{
+			GroupID:        "the-group",
+			SessionTimeout: 10000,
+			ProtocolType:   "consumer",
+			GroupProtocols: protocols,
+		})
+	}
+	leader := join(&protocol.GroupProtocol{ProtocolName: "range", ProtocolMetadata: []byte{1}})
+	require.Equal(t, protocol.ErrNone.Code(), leader.ErrorCode)
+	require.Equal(t, "range", leader.GroupProtocol)
+	// the group's protocol is picked from those the member supports
+	follower := join(
+		&protocol.GroupProtocol{ProtocolName: "roundrobin", ProtocolMetadata: []byte{3}},
+		&protocol.GroupProtocol{ProtocolName: "range", ProtocolMetadata: []byte{2}},
+	)
+	require.Equal(t, protocol.ErrNone.Code(), follower.ErrorCode)
+	require.Equal(t, protocol.ErrInconsistentGroupProtocol.Code(), join(&protocol.GroupProtocol{ProtocolName: "roundrobin"}).ErrorCode)
+
+	describe := func(ids ...string) []protocol.Group {
+		resp := b.handleDescribeGroups(ctx, &protocol.DescribeGroupsRequest{GroupIDs: ids})
+		require.Equal(t, len(ids), len(resp.Groups))
+		return resp.Groups
+	}
+	group := describe("the-group")[0]
+	require.Equal(t, structs.GroupStateCompletingRebalance, group.State)
+
+	sync := b.handleSyncGroup(ctx, &protocol.SyncGroupRequest{
+		GroupID:  "the-group",
+		MemberID: leader.MemberID,
+		GroupAssignments: []protocol.GroupAssignment{
+			{MemberID: leader.MemberID, MemberAssignment: []byte{4}},
+			{MemberID: follower.MemberID, MemberAssignment: []byte{5}},
+		},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), sync.ErrorCode)
+	require.Equal(t, []byte{4}, sync.MemberAssignment)
+	sync = b.handleSyncGroup(ctx, &protocol.SyncGroupRequest{GroupID: "the-group", MemberID: follower.MemberID})
+	require.Equal(t, []byte{5}, sync.MemberAssignment)
+
+	groups := describe("the-group", "unknown-group")
+	require.Equal(t, protocol.Group{
+		ErrorCode:    protocol.ErrNone.Code(),
+		GroupID:      "the-group",
+		State:        structs.GroupStateStable,
+		ProtocolType: "consumer",
+		Protocol:     "range",
+		GroupMembers: map[string]*protocol.GroupMember{
+			leader.MemberID:   {ClientID: "the-client", GroupMemberMetadata: []byte{1}, GroupMemberAssignment: []byte{4}},
+			follower.MemberID: {ClientID: "the-client", GroupMemberMetadata: []byte{2}, GroupMemberAssignment: []byte{5}},
+		},
+	}, groups[0])
+	require.Equal(t, protocol.Group{ErrorCode: protocol.ErrNone.Code(), GroupID: "unknown-group", State: structs.GroupStateDead}, groups[1])
+
+	for _, memberID := range []string{leader.MemberID, follower.MemberID} {
+		resp := b.handleLeaveGroup(ctx, &protocol.LeaveGroupRequest{GroupID: "the-group", MemberID: memberID})
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	}
+	group = describe("the-group")[0]
+	require.Equal(t, structs.GroupStateEmpty, group.State)
+	require.Equal(t, 0, len(group.GroupMembers))
+}
+
+func TestBroker_StaticMembership(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	instanceID := func(id string) *string { return &id }
+	join := func(id string, metadata byte) *protocol.JoinGroupResponse {
+		return b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{
+			APIVersion:      5,
+			GroupID:         "the-group",
+			GroupInstanceID: instanceID(id),
+			ProtocolType:    "consumer",
+			SessionTimeout:  10000,
+			GroupProtocols:  []*protocol.GroupProtocol{{ProtocolName: "range", ProtocolMetadata: []byte{metadata}}},
+		})
+	}
+	state := func() *structs.Group {
+		_, group, err := b.fsm.State().GetGroup("the-group")
+		require.NoError(t, err)
+		return group
+	}
+	leader := join("pod-0", 1)
+	require.Equal(t, protocol.ErrNone.Code(), leader.ErrorCode)
+	follower := join("pod-1", 1)
+	require.Equal(t, protocol.ErrNone.Code(), follower.ErrorCode)
+	require.Equal(t, 2, len(follower.Members))
+	sync := b.handleSyncGroup(ctx, &protocol.SyncGroupRequest{
+		APIVersion:      3,
+		GroupID:         "the-group",
+		MemberID:        leader.MemberID,
+		GroupInstanceID: instanceID("pod-0"),
+		GroupAssignments: []protocol.GroupAssignment{
+			{MemberID: leader.MemberID, MemberAssignment: []byte{2}},
+			{MemberID: follower.MemberID, MemberAssignment: []byte{3}},
+		},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), sync.ErrorCode)
+	require.Equal(t, structs.GroupStateStable, state().State)
+
+	// the follower restarting takes its old member's place without rebalancing the group
+	restarted := join("pod-1", 1)
+	require.Equal(t, protocol.ErrNone.Code(), restarted.ErrorCode)
+	require.NotEqual(t, follower.MemberID, restarted.MemberID)
+	group := state()
+	require.Equal(t, structs.GroupStateStable, group.State)
+	require.Equal(t, 2, len(group.Members))
+	require.Equal(t, []byte{3}, group.Members[restarted.MemberID].Assignment)
+	sync = b.handleSyncGroup(ctx, &protocol.SyncGroupRequest{APIVersion: 3, GroupID: "the-group", MemberID: restarted.MemberID, GroupInstanceID: instanceID("pod-1")})
+	require.Equal(t, protocol.ErrNone.Code(), sync.ErrorCode)
+	require.Equal(t, []byte{3}, sync.MemberAssignment)
+
+	// its old instance is fenced
+	heartbeat := b.handleHeartbeat(ctx, &protocol.HeartbeatRequest{APIVersion: 3, GroupID: "the-group", MemberID: follower.MemberID, GroupInstanceID: instanceID("pod-1")})
+	require.Equal(t, protocol.ErrFencedInstanceId.Code(), heartbeat.ErrorCode)
+	sync = b.handleSyncGroup(ctx, &protocol.SyncGroupRequest{APIVersion: 3, GroupID: "the-group", MemberID: follower.MemberID, GroupInstanceID: instanceID("pod-1")})
+	require.Equal(t, protocol.ErrFencedInstanceId.Code(), sync.ErrorCode)
+
+	// the leader restarting keeps the leadership, and restarting with new metadata rebalances
+	restartedLeader := join("pod-0", 4)
+	require.Equal(t, protocol.ErrNone.Code(), restartedLeader.ErrorCode)
+	require.Equal(t, restartedLeader.MemberID, restartedLeader.LeaderID)
+	require.Equal(t, structs.GroupStateCompletingRebalance, state().State)
+
+	// static members can be removed by their instance ids
+	leave := b.handleLeaveGroup(ctx, &protocol.LeaveGroupRequest{
+		APIVersion: 3,
+		GroupID:    "the-group",
+		Members: []protocol.LeaveGroupMember{
+			{GroupInstanceID: instanceID("pod-0")},
+			{MemberID: follower.MemberID, GroupInstanceID: instanceID("pod-1")},
+			{GroupInstanceID: instanceID("pod-2")},
+		},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), leave.ErrorCode)
+	require.Equal(t, []protocol.LeaveGroupMemberResponse{
+		{GroupInstanceID: instanceID("pod-0"), ErrorCode: protocol.ErrNone.Code()},
+		{MemberID: follower.MemberID, GroupInstanceID: instanceID("pod-1"), ErrorCode: protocol.ErrFencedInstanceId.Code()},
+		{GroupInstanceID: instanceID("pod-2"), ErrorCode: protocol.ErrUnknownMemberId.Code()},
+	}, leave.Members)
+	group = state()
+	require.Equal(t, 1, len(group.Members))
+	require.Equal(t, "pod-1", group.Members[restarted.MemberID].InstanceID)
+}
+
+func TestBroker_GroupMaxSize(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.GroupMaxSize = 1
+	})
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	join := func(memberID string) *protocol.JoinGroupResponse {
+		return b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{GroupID: "the-group", MemberID: memberID, ProtocolType: "consumer", SessionTimeout: 10000})
+	}
+	first := join("")
+	require.Equal(t, protocol.ErrNone.Code(), first.ErrorCode)
+	// new members are refused once the group's full, its members can still rejoin
+	require.Equal(t, protocol.ErrGroupMaxSizeReached.Code(), join("").ErrorCode)
+	require.Equal(t, protocol.ErrNone.Code(), join(first.MemberID).ErrorCode)
+}
+
+func TestBroker_ListGroups(t *testing.T) {
+	s, teardown := startTestLeader(t, nil)
+	defer teardown()
+	b := s.broker()
+	reqCtx := &Context{parent: context.Background()}
+
+	join := func(group string) *protocol.JoinGroupResponse {
+		resp := b.handleJoinGroup(reqCtx, &protocol.JoinGroupRequest{GroupID: group, ProtocolType: "consumer", SessionTimeout: 10000})
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+		return resp
+	}
+	stable := join("stable-group")
+	sync := b.handleSyncGroup(reqCtx, &protocol.SyncGroupRequest{GroupID: "stable-group", MemberID: stable.MemberID})
+	require.Equal(t, protocol.ErrNone.Code(), sync.ErrorCode)
+	join("rebalancing-group")
+	commit := b.handleOffsetCommit(reqCtx, &protocol.OffsetCommitRequest{
+		GroupID:      "empty-group",
+		GenerationID: -1,
+		Topics: []protocol.OffsetCommitTopicRequest{{
+			Topic:      "the-topic",
+			Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: 1}},
+		}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), commit.Responses[0].PartitionResponses[0].ErrorCode)
+
+	conn, err := Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	list := func(req *protocol.ListGroupsRequest) []protocol.ListGroup {
+		resp, err := conn.ListGroups(req)
+		require.NoError(t, err)
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+		sort.Slice(resp.Groups, func(i, j int) bool { return resp.Groups[i].GroupID < resp.Groups[j].GroupID })
+		return resp.Groups
+	}
+	require.Equal(t, []protocol.ListGroup{
+		{GroupID: "empty-group"},
+		{GroupID: "rebalancing-group", ProtocolType: "consumer"},
+		{GroupID: "stable-group", ProtocolType: "consumer"},
+	}, list(&protocol.ListGroupsRequest{APIVersion: 1}))
+	require.Equal(t, []protocol.ListGroup{
+		{GroupID: "empty-group", GroupState: structs.GroupStateEmpty},
+		{GroupID: "stable-group", ProtocolType: "consumer", GroupState: structs.GroupStateStable},
+	}, list(&protocol.ListGroupsRequest{APIVersion: 4, StatesFilter: []string{"stable", "Empty"}}))
+	require.Empty(t, list(&protocol.ListGroupsRequest{APIVersion: 4, StatesFilter: []string{structs.GroupStateDead}}))
+}
+
+func TestBroker_DeleteGroups(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	join := b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{GroupID: "the-group", SessionTimeout: 10000})
+	require.Equal(t, protocol.ErrNone.Code(), join.ErrorCode)
+	commit := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+		APIVersion:   1,
+		GroupID:      "the-group",
+		GenerationID: join.GenerationID,
+		MemberID:     join.MemberID,
+		Topics: []protocol.OffsetCommitTopicRequest{{
+			Topic:      "the-topic",
+			Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: 3}},
+		}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), commit.Responses[0].PartitionResponses[0].ErrorCode)
+
+	deleteGroups := func(ids ...string) []protocol.DeleteGroupsResult {
+		resp := b.handleDeleteGroups(ctx, &protocol.DeleteGroupsRequest{GroupsNames: ids})
+		return resp.Results
+	}
+	require.Equal(t, []protocol.DeleteGroupsResult{
+		{GroupID: "the-group", ErrorCode: protocol.ErrNonEmptyGroup.Code()},
+		{GroupID: "unknown-group", ErrorCode: protocol.ErrGroupIdNotFound.Code()},
+	}, deleteGroups("the-group", "unknown-group"))
+
+	leave := b.handleLeaveGroup(ctx, &protocol.LeaveGroupRequest{GroupID: "the-group", MemberID: join.MemberID})
+	require.Equal(t, protocol.ErrNone.Code(), leave.ErrorCode)
+	require.Equal(t, []protocol.DeleteGroupsResult{
+		{GroupID: "the-group", ErrorCode: protocol.ErrNone.Code()},
+	}, deleteGroups("the-group"))
+
+	// the group's offsets went with it
+	fetch := b.handleOffsetFetch(ctx, &protocol.OffsetFetchRequest{
+		APIVersion: 1,
+		GroupID:    "the-group",
+		Topics:     []protocol.OffsetFetchTopicRequest{{Topic: "the-topic", Partitions: []int32{0}}},
+	})
+	require.Equal(t, int64(-1), fetch.Responses[0].Partitions[0].Offset)
+	describe := b.handleDescribeGroups(ctx, &protocol.DescribeGroupsRequest{GroupIDs: []string{"the-group"}})
+	require.Equal(t, structs.GroupStateDead, describe.Groups[0].State)
+}
+
+func TestBroker_OffsetDelete(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	for _, topic := range []string{"the-topic", "old-topic"} {
+		create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             topic,
+			NumPartitions:     2,
+			ReplicationFactor: 1,
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	}
+	metadata, err := protocol.Encode(&protocol.ConsumerProtocolSubscription{Topics: []string{"the-topic"}})
+	require.NoError(t, err)
+	join := b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{
+		GroupID:        "the-group",
+		SessionTimeout: 10000,
+		ProtocolType:   protocol.ConsumerProtocolType,
+		GroupProtocols: []*protocol.GroupProtocol{{ProtocolName: "range", ProtocolMetadata: metadata}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), join.ErrorCode)
+	commit := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+		APIVersion:   1,
+		GroupID:      "the-group",
+		GenerationID: join.GenerationID,
+		MemberID:     join.MemberID,
+		Topics: []protocol.OffsetCommitTopicRequest{
+			{Topic: "the-topic", Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: 3}}},
+			{Topic: "old-topic", Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: 5}, {Partition: 1, Offset: 6}}},
+		},
+	})
+	for _, topic := range commit.Responses {
+		for _, p := range topic.PartitionResponses {
+			require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+		}
+	}
+	fetch := func(topic string, partition int32) int64 {
+		resp := b.handleOffsetFetch(ctx, &protocol.OffsetFetchRequest{
+			APIVersion: 1,
+			GroupID:    "the-group",
+			Topics:     []protocol.OffsetFetchTopicRequest{{Topic: topic, Partitions: []int32{partition}}},
+		})
+		return resp.Responses[0].Partitions[0].Offset
+	}
+
+	// the subscribed topic's offsets are in use so only the old topic's are deleted
+	resp := b.handleOffsetDelete(ctx, &protocol.OffsetDeleteRequest{
+		GroupID: "the-group",
+		Topics: []protocol.OffsetDeleteTopicRequest{
+			{Topic: "the-topic", Partitions: []int32{0}},
+			{Topic: "old-topic", Partitions: []int32{0, 5}},
+		},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	require.Equal(t, []protocol.OffsetDeleteTopicResponse{
+		{Topic: "the-topic", Partitions: []protocol.OffsetDeletePartitionResponse{{Partition: 0, ErrorCode: protocol.ErrGroupSubscribedToTopic.Code()}}},
+		{Topic: "old-topic", Partitions: []protocol.OffsetDeletePartitionResponse{
+			{Partition: 0, ErrorCode: protocol.ErrNone.Code()},
+			{Partition: 5, ErrorCode: protocol.ErrUnknownTopicOrPartition.Code()},
+		}},
+	}, resp.Topics)
+	require.Equal(t, int64(3), fetch("the-topic", 0))
+	require.Equal(t, int64(-1), fetch("old-topic", 0))
+	require.Equal(t, int64(6), fetch("old-topic", 1))
+
+	// once the group's empty its subscribed topics' offsets can be deleted too
+	leave := b.handleLeaveGroup(ctx, &protocol.LeaveGroupRequest{GroupID: "the-group", MemberID: join.MemberID})
+	require.Equal(t, protocol.ErrNone.Code(), leave.ErrorCode)
+	resp = b.handleOffsetDelete(ctx, &protocol.OffsetDeleteRequest{
+		GroupID: "the-group",
+		Topics:  []protocol.OffsetDeleteTopicRequest{{Topic: "the-topic", Partitions: []int32{0}}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), resp.Topics[0].Partitions[0].ErrorCode)
+	require.Equal(t, int64(-1), fetch("the-topic", 0))
+	require.Equal(t, int64(6), fetch("old-topic", 1))
+
+	resp = b.handleOffsetDelete(ctx, &protocol.OffsetDeleteRequest{GroupID: "unknown-group"})
+	require.Equal(t, protocol.ErrGroupIdNotFound.Code(), resp.ErrorCode)
+}
+
+func TestBroker_OffsetRetention(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     2,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	metadata, err := protocol.Encode(&protocol.ConsumerProtocolSubscription{Topics: []string{"the-topic"}})
+	require.NoError(t, err)
+	join := b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{
+		GroupID:        "the-group",
+		SessionTimeout: 10000,
+		ProtocolType:   protocol.ConsumerProtocolType,
+		GroupProtocols: []*protocol.GroupProtocol{{ProtocolName: "range", ProtocolMetadata: metadata}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), join.ErrorCode)
+	// partition 0 is kept for the broker's retention and partition 1 for the request's
+	for _, c := range []struct {
+		partition int32
+		retention int64
+	}{{0, -1}, {1, time.Hour.Nanoseconds() / int64(time.Millisecond)}} {
+		commit := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+			APIVersion:    2,
+			GroupID:       "the-group",
+			GenerationID:  join.GenerationID,
+			MemberID:      join.MemberID,
+			RetentionTime: c.retention,
+			Topics: []protocol.OffsetCommitTopicRequest{
+				{Topic: "the-topic", Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: c.partition, Offset: 3}}},
+			},
+		})
+		require.Equal(t, protocol.ErrNone.Code(), commit.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+	fetch := func(partition int32) int64 {
+		resp := b.handleOffsetFetch(ctx, &protocol.OffsetFetchRequest{
+			APIVersion: 1,
+			GroupID:    "the-group",
+			Topics:     []protocol.OffsetFetchTopicRequest{{Topic: "the-topic", Partitions: []int32{partition}}},
+		})
+		return resp.Responses[0].Partitions[0].Offset
+	}
+
+	// offsets don't expire while the group has members
+	b.config.OffsetsRetention = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, int64(3), fetch(0))
+	_, group, err := b.fsm.State().GetGroup("the-group")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(b.expiredOffsets(group, time.Now())))
+
+	leave := b.handleLeaveGroup(ctx, &protocol.LeaveGroupRequest{GroupID: "the-group", MemberID: join.MemberID})
+	require.Equal(t, protocol.ErrNone.Code(), leave.ErrorCode)
+	require.Equal(t, int64(-1), fetch(0))
+	require.Equal(t, int64(3), fetch(1))
+
+	_, group, err = b.fsm.State().GetGroup("the-group")
+	require.NoError(t, err)
+	expired := b.expiredOffsets(group, time.Now())
+	require.Equal(t, map[string][]int32{"the-topic": {0}}, expired)
+	require.Equal(t, protocol.ErrNone, b.removeOffsets("the-group", expired))
+	_, group, err = b.fsm.State().GetGroup("the-group")
+	require.NoError(t, err)
+	_, ok := group.Offsets["the-topic"][0]
+	require.False(t, ok)
+	require.Equal(t, int64(3), group.Offsets["the-topic"][1].Offset)
+}
+
+func TestBroker_InitProducerID(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	initProducerID := func(req *protocol.InitProducerIDRequest) *protocol.InitProducerIDResponse {
+		resp := b.handleInitProducerID(ctx, req)
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+		require.Equal(t, int16(0), resp.ProducerEpoch)
+		return resp
+	}
+	require.Equal(t, int64(0), initProducerID(&protocol.InitProducerIDRequest{}).ProducerID)
+	// producers sending their ids get new ones
+	require.Equal(t, int64(1), initProducerID(&protocol.InitProducerIDRequest{APIVersion: 3, ProducerID: 0, ProducerEpoch: 0}).ProducerID)
+
+	// the next block's allocated once the broker's handed out its block's ids
+	b.producerIDs.next = producerIDBlockSize
+	require.Equal(t, int64(producerIDBlockSize), initProducerID(&protocol.InitProducerIDRequest{}).ProducerID)
+	_, block, err := b.fsm.State().GetProducerIDBlock(b.config.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(producerIDBlockSize), block.First)
+
+	transactionalID := "the-txn"
+	// transactional producers need timeouts for their transactions
+	resp := b.handleInitProducerID(ctx, &protocol.InitProducerIDRequest{TransactionalID: &transactionalID})
+	require.Equal(t, protocol.ErrInvalidTransactionTimeout.Code(), resp.ErrorCode)
+}
+
+func TestBroker_ProduceIdempotent(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	produce := func(epoch int16, sequence int32) *protocol.ProducePartitionResponse {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: testRecordBatch(5, epoch, sequence, 0)}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0]
+	}
+
+	first := produce(0, 0)
+	require.Equal(t, protocol.ErrNone.Code(), first.ErrorCode)
+	second := produce(0, 1)
+	require.Equal(t, protocol.ErrNone.Code(), second.ErrorCode)
+	next := replica.Log.NewestOffset()
+
+	// retries are answered with the offsets the batches were appended at, without appending them
+	retried := produce(0, 0)
+	require.Equal(t, protocol.ErrNone.Code(), retried.ErrorCode)
+	require.Equal(t, first.BaseOffset, retried.BaseOffset)
+	require.Equal(t, second.BaseOffset, produce(0, 1).BaseOffset)
+	require.Equal(t, next, replica.Log.NewestOffset())
+
+	// sequence numbers must follow the producer's last
+	require.Equal(t, protocol.ErrOutOfOrderSequenceNumber.Code(), produce(0, 3).ErrorCode)
+	require.Equal(t, protocol.ErrNone.Code(), produce(0, 2).ErrorCode)
+	// and start over with new epochs, after which older epochs are fenced
+	require.Equal(t, protocol.ErrOutOfOrderSequenceNumber.Code(), produce(1, 3).ErrorCode)
+	require.Equal(t, protocol.ErrNone.Code(), produce(1, 0).ErrorCode)
+	require.Equal(t, protocol.ErrInvalidProducerEpoch.Code(), produce(0, 3).ErrorCode)
+
+	// expired producers' next batches are taken as their first
+	require.True(t, b.producers.expire("the-topic", 0, 5))
+	require.Equal(t, protocol.ErrNone.Code(), produce(1, 7).ErrorCode)
+}
+
+func TestBroker_ProduceIdempotentConcurrently(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	start := replica.Log.NewestOffset()
+
+	// retries sent before the first's answered are checked after it's appended, so only one is
+	responses := make([]*protocol.ProducePartitionResponse, 10)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+				Topic: "the-topic",
+				Data:  []*protocol.Data{{Partition: 0, RecordSet: testRecordBatch(5, 0, 0, 0)}},
+			}}})
+			responses[i] = resp.Responses[0].PartitionResponses[0]
+		}(i)
+	}
+	wg.Wait()
+	for _, resp := range responses {
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+		require.Equal(t, start, resp.BaseOffset)
+	}
+	require.Equal(t, start+1, replica.Log.NewestOffset())
+}
+
+func TestBroker_PublishLeaderChanges(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.PublishLeaderChanges = true
+	})
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     2,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+
+	_, topic, err := b.fsm.State().GetTopic(LeaderChangesTopicName)
+	require.NoError(t, err)
+	require.NotNil(t, topic)
+	require.Equal(t, "compact", topic.Config.GetValue("cleanup.policy"))
+
+	messages := func() []*protocol.Message {
+		replica, err := b.replicaLookup.Replica(LeaderChangesTopicName, 0)
+		require.NoError(t, err)
+		r, err := replica.Log.NewReader(0, 1<<20)
+		require.NoError(t, err)
+		buf, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		var messages []*protocol.Message
+		for len(buf) > 0 {
+			size := 12 + int(protocol.Encoding.Uint32(buf[8:]))
+			var ms protocol.MessageSet
+			require.NoError(t, ms.Decode(protocol.NewDecoder(buf[:size])))
+			messages = append(messages, ms.Messages...)
+			buf = buf[size:]
+		}
+		return messages
+	}
+	ms := messages()
+	require.Equal(t, 2, len(ms))
+	for i, m := range ms {
+		require.Equal(t, fmt.Sprintf("the-topic-%d", i), string(m.Key))
+		var change LeaderChange
+		require.NoError(t, json.Unmarshal(m.Value, &change))
+		require.Equal(t, "the-topic", change.Topic)
+		require.Equal(t, int32(i), change.Partition)
+		require.Equal(t, b.config.ID, change.Leader)
+		require.Equal(t, []int32{b.config.ID}, change.ISR)
+		require.Equal(t, []int32{b.config.ID}, change.Replicas)
+		require.Equal(t, b.config.ID, change.Controller)
+	}
+
+	// deleting the topic publishes tombstones for its partitions
+	del := b.handleDeleteTopics(ctx, &protocol.DeleteTopicsRequest{Topics: []string{"the-topic"}})
+	require.Equal(t, protocol.ErrNone.Code(), del.TopicErrorCodes[0].ErrorCode)
+	retry.Run(t, func(r *retry.R) {
+		if len(messages()) != 4 {
+			r.Fatal("tombstones not published")
+		}
+	})
+	ms = messages()
+	for _, m := range ms[2:] {
+		require.Contains(t, []string{"the-topic-0", "the-topic-1"}, string(m.Key))
+		require.Nil(t, m.Value)
+	}
+}
+
+func TestBroker_CreateTopicDefaults(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.NumPartitions = 2
+	})
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createTopic := func(topic string, partitions int32, replicationFactor int16) protocol.Error {
+		resp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             topic,
+			NumPartitions:     partitions,
+			ReplicationFactor: replicationFactor,
+		}}})
+		return protocol.Errs[resp.TopicErrorCodes[0].ErrorCode]
+	}
+	numPartitions := func(topic string) int {
+		_, tt, err := b.fsm.State().GetTopic(topic)
+		require.NoError(t, err)
+		return len(tt.Partitions)
+	}
+
+	// the broker's flags are the defaults
+	require.Equal(t, protocol.ErrNone, createTopic("flags", -1, -1))
+	require.Equal(t, 2, numPartitions("flags"))
+
+	// and are overridden by the dynamic configs, for all topics or a namespace's
+	require.Equal(t, protocol.ErrNone, b.alterBrokerConfigs("", []protocol.AlterConfigsEntry{
+		{Name: numPartitionsConfig, Value: strPtr("3")},
+		{Name: "namespace.payments." + numPartitionsConfig, Value: strPtr("4")},
+		{Name: "namespace.payments." + defaultReplicationFactorConfig, Value: strPtr("2")},
+	}, false))
+	require.Equal(t, protocol.ErrNone, createTopic("global", -1, -1))
+	require.Equal(t, 3, numPartitions("global"))
+	require.Equal(t, protocol.ErrNone, createTopic("payments.events", -1, 1))
+	require.Equal(t, 4, numPartitions("payments.events"))
+	// there's only one broker to replicate to
+	require.Equal(t, protocol.ErrInvalidReplicationFactor, createTopic("payments.refunds", 1, -1))
+
+	// given partitions are used as is
+	require.Equal(t, protocol.ErrNone, createTopic("given", 5, 1))
+	require.Equal(t, 5, numPartitions("given"))
+	require.Equal(t, protocol.ErrInvalidPartitions, createTopic("none", 0, 1))
+}
+
+type fields struct {
+	id     int32
+	logger log.Logger
+	logDir string
+}
+
+func newFields() fields {
+	return fields{
+		logger: log.New(),
+		logDir: filepath.Join(os.TempDir(), "jocko", "logs"),
+		id:     1,
+	}
+}
+
+func TestValidateBrokerConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  protocol.Error
+	}{
+		{traceTopicsConfig, "the-topic,other-topic", protocol.ErrNone},
+		{numPartitionsConfig, "3", protocol.ErrNone},
+		{numPartitionsConfig, "0", protocol.ErrInvalidConfig},
+		{numPartitionsConfig, "three", protocol.ErrInvalidConfig},
+		{numPartitionsConfig, "2147483648", protocol.ErrInvalidConfig},
+		{defaultReplicationFactorConfig, "32767", protocol.ErrNone},
+		{defaultReplicationFactorConfig, "32768", protocol.ErrInvalidConfig},
+		{"namespace.payments." + defaultReplicationFactorConfig, "2", protocol.ErrNone},
+		{"namespace.payments." + traceTopicsConfig, "the-topic", protocol.ErrInvalidConfig},
+		{"namespace." + numPartitionsConfig, "3", protocol.ErrInvalidConfig},
+		{logDirsRebalanceThrottleConfig, "-1", protocol.ErrInvalidConfig},
+		{"log.retention.ms", "1000", protocol.ErrNone},
+		{"log.retention.ms", "a week", protocol.ErrInvalidConfig},
+		{"log.cleanup.policy", "shred", protocol.ErrInvalidConfig},
+		{"not.a.config", "1", protocol.ErrInvalidConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			require.Equal(t, tt.want.Code(), validateBrokerConfig(tt.name, tt.value).Code())
+		})
+	}
+}
+
+func TestBroker_CreateTopicRollback(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	// a file where the second partition's log goes fails creating it
+	dir := filepath.Join(b.config.DataDir, "data")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, partitionDirName("bad-topic", 1)), nil, 0644))
+
+	ctx := &Context{parent: context.Background()}
+	resp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "bad-topic",
+		NumPartitions:     2,
+		ReplicationFactor: 1,
+	}, {
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrUnknown.Code(), resp.TopicErrorCodes[0].ErrorCode)
+	require.Equal(t, protocol.ErrNone.Code(), resp.TopicErrorCodes[1].ErrorCode)
+
+	state := b.fsm.State()
+	_, topic, err := state.GetTopic("bad-topic")
+	require.NoError(t, err)
+	require.Nil(t, topic)
+	_, partitions, err := state.PartitionsByTopic("bad-topic")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(partitions))
+	_, err = b.replicaLookup.Replica("bad-topic", 0)
+	require.Error(t, err)
+	_, topic, err = state.GetTopic("the-topic")
+	require.NoError(t, err)
+	require.NotNil(t, topic)
+}
+
+func TestBroker_DeleteTopicLogs(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	create := func() {
+		resp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             "the-topic",
+			NumPartitions:     1,
+			ReplicationFactor: 1,
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.TopicErrorCodes[0].ErrorCode)
+	}
+	path := filepath.Join(b.config.DataDir, "data", partitionDirName("the-topic", 0))
+	create()
+	resp := b.handleDeleteTopics(ctx, &protocol.DeleteTopicsRequest{Topics: []string{"the-topic"}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.TopicErrorCodes[0].ErrorCode)
+	// the log's moved out of the way once the controller's deleted the topic
+	retry.Run(t, func(r *retry.R) {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			r.Fatal("log not moved")
+		}
+	})
+	retry.Run(t, func(r *retry.R) {
+		if _, err := os.Stat(path + deletedLogSuffix); !os.IsNotExist(err) {
+			r.Fatal("deleted log not removed")
+		}
+	})
+
+	// so the topic can be recreated right away
+	create()
+	_, err := os.Stat(path)
+	require.NoError(t, err)
+}
+
+func TestBroker_MigrateLegacyLog(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	// a log written when the directories were named after only the partition id
+	legacy := filepath.Join(b.config.DataDir, "data", "0")
+	l, err := commitlog.New(commitlog.Options{Path: legacy, MaxSegmentBytes: 1024, MaxLogBytes: -1})
+	require.NoError(t, err)
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Offset: 0, Messages: []*protocol.Message{{Value: []byte("The message.")}}})
+	require.NoError(t, err)
+	_, err = l.Append(recordSet)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), replica.Log.NewestOffset())
+	_, err = os.Stat(legacy)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestBroker_JoinLAN(t *testing.T) {
+	s1, t1 := NewTestServer(t, nil, nil)
+	b1 := s1.broker()
+	defer t1()
+	defer b1.Shutdown()
 	s2, t2 := NewTestServer(t, nil, nil)
 	b2 := s2.broker()
 	defer t2()
@@ -611,6 +3097,398 @@ func TestBroker_FailedMember(t *testing.T) {
 	// todo: check have failed checks
 }
 
+func TestBroker_ControlledShutdown(t *testing.T) {
+	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	require.NoError(t, s1.Start(ctx1))
+	defer t1()
+	defer s1.Shutdown()
+
+	s2, t2 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = false
+		cfg.NonVoter = true
+		cfg.ControlledShutdown = true
+	}, nil)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	require.NoError(t, s2.Start(ctx2))
+	defer t2()
+	defer s2.Shutdown()
+
+	TestJoin(t, s2, s1)
+
+	b1, b2 := s1.broker(), s2.broker()
+	id1, id2 := b1.config.ID, b2.config.ID
+	state := b1.fsm.State()
+	retry.Run(t, func(r *retry.R) {
+		_, nodes, err := state.GetNodes()
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if len(nodes) != 2 {
+			r.Fatalf("got %d nodes, want 2", len(nodes))
+		}
+		if b2.controllerID() != id1 {
+			r.Fatal("controller not known")
+		}
+	})
+
+	// the broker shutting down leads a partition, follows another and is the only replica of a
+	// third
+	partitions := []structs.Partition{
+		{Topic: "the-topic", ID: 0, Partition: 0, Leader: id2, AR: []int32{id2, id1}, ISR: []int32{id2, id1}},
+		{Topic: "the-topic", ID: 1, Partition: 1, Leader: id1, AR: []int32{id1, id2}, ISR: []int32{id1, id2}},
+		{Topic: "the-topic", ID: 2, Partition: 2, Leader: id2, AR: []int32{id2}, ISR: []int32{id2}},
+	}
+	topic := structs.Topic{Topic: "the-topic", Partitions: make(map[int32][]int32)}
+	for _, p := range partitions {
+		topic.Partitions[p.ID] = p.AR
+	}
+	_, err := b1.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: topic})
+	require.NoError(t, err)
+	for _, p := range partitions {
+		_, err = b1.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: p})
+		require.NoError(t, err)
+	}
+
+	remaining, err := b2.requestControlledShutdown()
+	require.NoError(t, err)
+	require.Equal(t, []*protocol.ControlledShutdownPartition{{Topic: "the-topic", Partition: 2}}, remaining)
+
+	_, p, err := state.GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, id1, p.Leader)
+	require.Equal(t, []int32{id1}, p.ISR)
+	require.Equal(t, partitions[0].LeaderEpoch+1, p.LeaderEpoch)
+	_, p, err = state.GetPartition("the-topic", 1)
+	require.NoError(t, err)
+	require.Equal(t, id1, p.Leader)
+	require.Equal(t, []int32{id1}, p.ISR)
+	_, p, err = state.GetPartition("the-topic", 2)
+	require.NoError(t, err)
+	require.Equal(t, id2, p.Leader)
+	require.Equal(t, []int32{id2}, p.ISR)
+}
+
+func TestBroker_CompletePartitionReassignment(t *testing.T) {
+	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	require.NoError(t, s1.Start(ctx1))
+	defer t1()
+	defer s1.Shutdown()
+
+	s2, t2 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = false
+		cfg.NonVoter = true
+	}, nil)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	require.NoError(t, s2.Start(ctx2))
+	defer t2()
+	defer s2.Shutdown()
+
+	TestJoin(t, s2, s1)
+
+	b1, b2 := s1.broker(), s2.broker()
+	id1, id2 := b1.config.ID, b2.config.ID
+	state := b1.fsm.State()
+	retry.Run(t, func(r *retry.R) {
+		_, nodes, err := state.GetNodes()
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if len(nodes) != 2 {
+			r.Fatalf("got %d nodes, want 2", len(nodes))
+		}
+	})
+
+	ctx := &Context{parent: context.Background()}
+	partition := structs.Partition{Topic: "the-topic", ID: 0, Partition: 0, Leader: id1, AR: []int32{id1}, ISR: []int32{id1}}
+	_, err := b1.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{
+		Topic: structs.Topic{Topic: "the-topic", Partitions: map[int32][]int32{0: partition.AR}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, b1.updatePartitions([]structs.Partition{partition}))
+	// the other broker needs to know the topic to start its replica
+	retry.Run(t, func(r *retry.R) {
+		if _, topic, _ := b2.fsm.State().GetTopic("the-topic"); topic == nil {
+			r.Fatal("topic not replicated")
+		}
+	})
+
+	// the partition moves to the other broker once it's caught up and is removed from this one
+	resp := b1.handleAlterPartitionReassignments(ctx, &protocol.AlterPartitionReassignmentsRequest{Topics: []protocol.AlterPartitionReassignmentsTopic{
+		{Topic: "the-topic", Partitions: []protocol.AlterPartitionReassignmentsPartition{{Partition: 0, Replicas: []int32{id2}}}},
+	}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.Topics[0].Partitions[0].ErrorCode)
+	retry.Run(t, func(r *retry.R) {
+		_, p, err := state.GetPartition("the-topic", 0)
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if reassigning(p) {
+			r.Fatalf("partition still being reassigned: %v", p.AR)
+		}
+	})
+	_, p, err := state.GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, []int32{id2}, p.AR)
+	require.Equal(t, []int32{id2}, p.ISR)
+	require.Equal(t, id2, p.Leader)
+	require.Equal(t, partition.LeaderEpoch+1, p.LeaderEpoch)
+	_, topic, err := state.GetTopic("the-topic")
+	require.NoError(t, err)
+	require.Equal(t, []int32{id2}, topic.Partitions[0])
+	retry.Run(t, func(r *retry.R) {
+		if _, err := b1.replicaLookup.Replica("the-topic", 0); err == nil {
+			r.Fatal("removed replica not stopped")
+		}
+		replica, err := b2.replicaLookup.Replica("the-topic", 0)
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if replica.Partition.Leader != id2 {
+			r.Fatalf("got leader %d, want %d", replica.Partition.Leader, id2)
+		}
+	})
+}
+
+func TestBroker_ReapFailedMember(t *testing.T) {
+	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+		cfg.FailedNodeTTL = time.Millisecond
+	}, nil)
+	defer t1()
+	defer s1.Shutdown()
+
+	s2, t2 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = false
+		cfg.NonVoter = true
+	}, nil)
+	defer t2()
+
+	s3, t3 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = false
+		cfg.NonVoter = true
+	}, nil)
+	// the replica's rebuilt onto this broker so it has to take the leader and isr request
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	defer cancel3()
+	require.NoError(t, s3.Start(ctx3))
+	defer t3()
+	defer s3.Shutdown()
+
+	TestJoin(t, s2, s1)
+	TestJoin(t, s3, s1)
+
+	b1, id1, id2, id3 := s1.broker(), s1.broker().config.ID, s2.broker().config.ID, s3.broker().config.ID
+	state := b1.fsm.State()
+	retry.Run(t, func(r *retry.R) {
+		_, nodes, err := state.GetNodes()
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if len(nodes) != 3 {
+			r.Fatalf("got %d nodes, want 3", len(nodes))
+		}
+	})
+
+	// the failed broker has a replica that needs to be moved before it's reaped
+	partition := structs.Partition{Topic: "the-topic", ID: 0, Partition: 0, Leader: id1, AR: []int32{id1, id2}, ISR: []int32{id1, id2}}
+	_, err := b1.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{
+		Topic: structs.Topic{Topic: "the-topic", Partitions: map[int32][]int32{0: partition.AR}},
+	})
+	require.NoError(t, err)
+	_, err = b1.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: partition})
+	require.NoError(t, err)
+
+	s2.Shutdown()
+
+	retry.Run(t, func(r *retry.R) {
+		_, node, err := state.GetNode(id2)
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if node != nil {
+			r.Fatal("node not reaped")
+		}
+	})
+
+	_, p, err := state.GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, []int32{id1, id3}, p.AR)
+	// the new replica rejoins the isr once it's caught up
+	require.Contains(t, p.ISR, id1)
+	require.NotContains(t, p.ISR, id2)
+	_, topic, err := state.GetTopic("the-topic")
+	require.NoError(t, err)
+	require.Equal(t, []int32{id1, id3}, topic.Partitions[0])
+}
+
+func TestBroker_RebuildReplicas(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		// rebuilt by the test, reconciling would reap the nodes it registers
+		cfg.ReconcileInterval = time.Hour
+	})
+	defer teardown()
+
+	registerNode := func(id int32, status string) {
+		_, err := b.raftApply(structs.RegisterNodeRequestType, structs.RegisterNodeRequest{Node: structs.Node{
+			Node:  id,
+			Check: &structs.HealthCheck{Status: status},
+		}})
+		require.NoError(t, err)
+	}
+	// 102 is the failed broker leading the partition, 103 is the only broker to move its replica to,
+	// and 104 is in sync but failed too so can't take over leading it
+	registerNode(102, structs.HealthCritical)
+	registerNode(103, structs.HealthPassing)
+	registerNode(104, structs.HealthCritical)
+	id := b.config.ID
+	partition := structs.Partition{Topic: "the-topic", ID: 0, Partition: 0, Leader: 102, LeaderEpoch: 1, AR: []int32{102, id}, ISR: []int32{102, 104, id}}
+	_, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{
+		Topic: structs.Topic{Topic: "the-topic", Partitions: map[int32][]int32{0: partition.AR}},
+	})
+	require.NoError(t, err)
+	_, err = b.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: partition})
+	require.NoError(t, err)
+
+	// 103 isn't a known broker so sending it the partition fails after it's been moved
+	require.Error(t, b.rebuildReplicas(102))
+	state := b.fsm.State()
+	_, p, err := state.GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, []int32{103, id}, p.AR)
+	require.Equal(t, []int32{104, id}, p.ISR)
+	require.Equal(t, id, p.Leader)
+	require.Equal(t, int32(2), p.LeaderEpoch)
+
+	// running it again doesn't move the partition again, it only sends it, skipping 103 now it's failed
+	registerNode(103, structs.HealthCritical)
+	require.NoError(t, b.rebuildReplicas(102))
+	_, p, err = state.GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, []int32{103, id}, p.AR)
+	require.Equal(t, int32(2), p.LeaderEpoch)
+	_, topic, err := state.GetTopic("the-topic")
+	require.NoError(t, err)
+	require.Equal(t, []int32{103, id}, topic.Partitions[0])
+	require.Equal(t, 0, len(b.rebuilds))
+}
+
+func TestBroker_RecoverFailedMember(t *testing.T) {
+	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+		// reconciled by the test
+		cfg.ReconcileInterval = time.Hour
+	}, nil)
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	require.NoError(t, s1.Start(ctx1))
+	defer t1()
+	defer s1.Shutdown()
+
+	s2, t2 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = false
+		cfg.NonVoter = true
+	}, nil)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	require.NoError(t, s2.Start(ctx2))
+	defer t2()
+	defer s2.Shutdown()
+
+	TestJoin(t, s2, s1)
+
+	b1, id1, id2 := s1.broker(), s1.broker().config.ID, s2.broker().config.ID
+	state := b1.fsm.State()
+	retry.Run(t, func(r *retry.R) {
+		passing, err := b1.passingNodes()
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if !passing[id1] || !passing[id2] {
+			r.Fatalf("nodes not passing: %v", passing)
+		}
+	})
+
+	partition := structs.Partition{Topic: "the-topic", ID: 0, Partition: 0, Leader: id2, AR: []int32{id2, id1}, ISR: []int32{id2, id1}}
+	_, err := b1.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{
+		Topic: structs.Topic{Topic: "the-topic", Partitions: map[int32][]int32{0: partition.AR}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, b1.updatePartitions([]structs.Partition{partition}))
+
+	var m2 serf.Member
+	for _, m := range b1.LANMembers() {
+		if meta, ok := metadata.IsBroker(m); ok && meta.ID.Int32() == id2 {
+			m2 = m
+		}
+	}
+
+	// the failed broker's leadership moves to the in-sync replica but it stays assigned
+	require.NoError(t, b1.handleFailedMember(m2))
+	_, p, err := state.GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, id1, p.Leader)
+	require.Equal(t, []int32{id2, id1}, p.AR)
+	require.Equal(t, []int32{id1}, p.ISR)
+
+	// once it's recovered and caught up it rejoins the isr and leads again as the preferred
+	// replica
+	require.NoError(t, b1.reconcile())
+	_, p, err = state.GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, id2, p.Leader)
+	require.Equal(t, []int32{id2, id1}, p.AR)
+	require.Equal(t, []int32{id1, id2}, p.ISR)
+}
+
+func TestBroker_LogEndOffsets(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+
+	ctx := &Context{parent: context.Background()}
+	createResp := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     2,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), createResp.TopicErrorCodes[0].ErrorCode)
+	produceResp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 1, RecordSet: testRecordBatch(1, 0, 0, 0)}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), produceResp.Responses[0].PartitionResponses[0].ErrorCode)
+
+	// the partitions' offsets come back from the one request, those the broker doesn't have are
+	// left out
+	offsets, err := b.logEndOffsets(b.config.ID, []topicPartition{
+		{topic: "the-topic", partition: 0},
+		{topic: "the-topic", partition: 1},
+		{topic: "another-topic", partition: 0},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[topicPartition]int64{
+		{topic: "the-topic", partition: 0}: 0,
+		{topic: "the-topic", partition: 1}: 1,
+	}, offsets)
+}
+
 func TestBroker_LeftMember(t *testing.T) {
 	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
 		cfg.Bootstrap = true
@@ -654,6 +3532,28 @@ func TestBroker_LeftMember(t *testing.T) {
 	})
 }
 
+func TestBroker_ReconcileBackoff(t *testing.T) {
+	b := &Broker{config: &config.Config{ReconcileInterval: time.Second, ReconcileMaxBackoff: 10 * time.Second}}
+	for _, test := range []struct {
+		failures int
+		backoff  time.Duration
+	}{
+		{failures: 1, backoff: 2 * time.Second},
+		{failures: 2, backoff: 4 * time.Second},
+		{failures: 3, backoff: 8 * time.Second},
+		// capped at the max backoff
+		{failures: 4, backoff: 10 * time.Second},
+		{failures: 100, backoff: 10 * time.Second},
+	} {
+		for i := 0; i < 10; i++ {
+			backoff := b.reconcileBackoff(test.failures)
+			if backoff < test.backoff/2 || backoff > test.backoff {
+				t.Fatalf("failures %d: got backoff %s, want between %s and %s", test.failures, backoff, test.backoff/2, test.backoff)
+			}
+		}
+	}
+}
+
 func TestBroker_LeaveLeader(t *testing.T) {
 	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
 		cfg.Bootstrap = true
@@ -683,10 +3583,16 @@ func TestBroker_LeaveLeader(t *testing.T) {
 
 	joinLAN(t, b2, b1)
 	joinLAN(t, b3, b1)
+	// the next leader reaps the brokers it doesn't know are members, so the followers know each
+	// other before the leader leaves rather than it being left to gossip
+	joinLAN(t, b3, b2)
 
 	for _, b := range brokers {
 		retry.Run(t, func(r *retry.R) {
 			r.Check(wantPeers(b, 3))
+			if len(b.LANMembers()) != 3 {
+				r.Fatal("lan members not gossiped")
+			}
 		})
 	}
 
@@ -744,6 +3650,92 @@ func TestBroker_LeaveLeader(t *testing.T) {
 	})
 }
 
+// newTestLeader creates a broker that bootstraps and leads a cluster of its own, with cb
+// applied to its config, and waits for it to register itself. The returned func shuts the
+// broker down and removes its data.
+func newTestLeader(t *testing.T, cb func(cfg *config.Config)) (*Broker, func()) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+		if cb != nil {
+			cb(cfg)
+		}
+	}, nil)
+	b := s.broker()
+	waitForRegistered(t, b)
+	return b, func() {
+		b.Shutdown()
+		teardown()
+	}
+}
+
+// startTestLeader is like newTestLeader but starts the broker's server too, for tests that
+// talk to it over the network or need its replica fetchers and other background work running.
+func startTestLeader(t *testing.T, cb func(cfg *config.Config)) (*Server, func()) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+		if cb != nil {
+			cb(cfg)
+		}
+	}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, s.Start(ctx))
+	waitForRegistered(t, s.broker())
+	return s, func() {
+		s.Shutdown()
+		cancel()
+		teardown()
+	}
+}
+
+// addTestFollower adds the follower to the replicas and isr of the partition the broker leads,
+// like a reassignment does, and returns the replica the controller's LeaderAndISR request
+// replaces the partition's with. The partition's registered with the follower too, so the isr
+// changes the broker asks the controller for keep it in the replicas.
+func addTestFollower(t *testing.T, b *Broker, topic string, partition, follower int32) *Replica {
+	replica, err := b.replicaLookup.Replica(topic, partition)
+	require.NoError(t, err)
+	p := replica.Partition
+	p.AR = append(append([]int32{}, p.AR...), follower)
+	p.ISR = append(append([]int32{}, p.ISR...), follower)
+	_, err = b.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: p})
+	require.NoError(t, err)
+	resp := b.handleLeaderAndISR(&Context{parent: context.Background()}, &protocol.LeaderAndISRRequest{PartitionStates: []*protocol.PartitionState{{
+		Topic:       topic,
+		Partition:   partition,
+		ZKVersion:   p.ControllerEpoch,
+		Leader:      p.Leader,
+		LeaderEpoch: p.LeaderEpoch,
+		ISR:         p.ISR,
+		Replicas:    p.AR,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.Partitions[0].ErrorCode)
+	replica, err = b.replicaLookup.Replica(topic, partition)
+	require.NoError(t, err)
+	return replica
+}
+
+// waitForRegistered waits for the broker to know of itself and for the leader to have reconciled
+// it, its startup's recorded once the nodes that aren't members have been reaped, so the nodes
+// tests register aren't reaped by that first reconcile.
+func waitForRegistered(t *testing.T, b *Broker) {
+	retry.Run(t, func(r *retry.R) {
+		if len(b.brokerLookup.Brokers()) != 1 {
+			r.Fatal("server not added")
+		}
+		_, lifecycle, err := b.fsm.State().GetBrokerLifecycle(b.config.ID)
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if lifecycle == nil || len(lifecycle.Events) == 0 {
+			r.Fatal("server not reconciled")
+		}
+	})
+}
+
 func waitForLeader(t *testing.T, brokers ...*Broker) {
 	retry.Run(t, func(r *retry.R) {
 		var leader *Broker
@@ -783,14 +3775,18 @@ func handleProduceResponse(t *testing.T, res *protocol.ProduceResponse) {
 			if pr.ErrorCode != protocol.ErrNone.Code() {
 				break
 			}
-			if pr.LogAppendTime.IsZero() {
-				t.Error("expected timestamp not to be 0")
+			// topics default to CreateTime so the broker shouldn't assign a timestamp
+			if !pr.LogAppendTime.IsZero() {
+				t.Error("expected timestamp to be 0")
 			}
-			pr.LogAppendTime = time.Time{}
 		}
 	}
 }
 
+func strPtr(s string) *string {
+	return &s
+}
+
 func (s *Server) broker() *Broker {
 	return s.handler.(*Broker)
 }
@@ -0,0 +1,171 @@
+package jocko
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+)
+
+// circuitBreaker stops a partition's log being used once it's failed too many times in a row so
+// a failing disk isn't hit by every request. Once it's been open for the cooldown it lets one
+// request through to try the log again and closes if that succeeds.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// trying is whether a request's been let through to try the log after the cooldown.
+	trying bool
+}
+
+// allow returns whether the log can be used.
+func (cb *circuitBreaker) allow() bool {
+	if cb.threshold <= 0 {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	if cb.trying || time.Since(cb.openedAt) < cb.cooldown {
+		return false
+	}
+	cb.trying = true
+	return true
+}
+
+// isOpen returns whether the breaker's open, whether or not it's cooled down, without letting a
+// request through to try the log.
+func (cb *circuitBreaker) isOpen() bool {
+	if cb.threshold <= 0 {
+		return false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.failures >= cb.threshold
+}
+
+// success records that the log was used fine, closing the breaker.
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+	cb.trying = false
+}
+
+// failure records that the log failed and returns whether that tripped the breaker. A failed try
+// after the cooldown opens the breaker again but doesn't count as tripping it.
+func (cb *circuitBreaker) failure() bool {
+	if cb.threshold <= 0 {
+		return false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.trying = false
+	cb.failures++
+	if cb.failures < cb.threshold {
+		return false
+	}
+	cb.openedAt = time.Now()
+	return cb.failures == cb.threshold
+}
+
+// partitionBreakers holds the circuit breakers of this broker's partitions, each created the
+// first time its partition's looked up.
+type partitionBreakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[topicPartition]*circuitBreaker
+}
+
+func newPartitionBreakers(threshold int, cooldown time.Duration) *partitionBreakers {
+	return &partitionBreakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[topicPartition]*circuitBreaker),
+	}
+}
+
+// get returns the partition's circuit breaker.
+func (pb *partitionBreakers) get(topic string, partition int32) *circuitBreaker {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	cb, ok := pb.breakers[key]
+	if !ok {
+		cb = &circuitBreaker{threshold: pb.threshold, cooldown: pb.cooldown}
+		pb.breakers[key] = cb
+	}
+	return cb
+}
+
+// offlinePartitionEvent is the serf user event a broker sends when its replica of a partition
+// goes offline so the controller can move the partition's leadership.
+const offlinePartitionEvent = "offline-partition"
+
+type offlinePartition struct {
+	Broker    int32
+	Topic     string
+	Partition int32
+}
+
+// logFailed records that the partition's log failed, taking the replica offline and telling the
+// controller if that trips the partition's circuit breaker.
+func (b *Broker) logFailed(topic string, partition int32, err error) {
+	if !b.breakers.get(topic, partition).failure() {
+		return
+	}
+	b.logger.Error("partition's log keeps failing, taking replica offline", log.String("topic", topic), log.Int32("partition", partition), log.Error("error", err))
+	payload, err := json.Marshal(offlinePartition{Broker: b.config.ID, Topic: topic, Partition: partition})
+	if err != nil {
+		b.logger.Error("failed to encode offline partition", log.Error("error", err))
+		return
+	}
+	if err := b.serf.UserEvent(offlinePartitionEvent, payload, false); err != nil {
+		b.logger.Error("failed to send offline partition event", log.Error("error", err))
+	}
+}
+
+// localUserEvent passes offline partitions on to the leader loop if this broker's the controller.
+func (b *Broker) localUserEvent(e serf.UserEvent) {
+	if e.Name != offlinePartitionEvent || !b.isLeader() {
+		return
+	}
+	var p offlinePartition
+	if err := json.Unmarshal(e.Payload, &p); err != nil {
+		b.logger.Error("failed to decode offline partition", log.Error("error", err))
+		return
+	}
+	select {
+	case b.offlineCh <- p:
+	default:
+	}
+}
+
+// handleOfflinePartition takes the broker whose replica went offline out of the partition's isr,
+// moving the partition's leadership to an in-sync replica if it led it. The replica rejoins the
+// isr like any other once it's caught up.
+func (b *Broker) handleOfflinePartition(e offlinePartition) error {
+	_, p, err := b.fsm.State().GetPartition(e.Topic, e.Partition)
+	if err != nil || p == nil {
+		return err
+	}
+	passing, err := b.passingNodes()
+	if err != nil {
+		return err
+	}
+	pp, ok := b.shrinkISR(p, e.Broker, passing)
+	if !ok {
+		return nil
+	}
+	return b.updatePartitions([]structs.Partition{pp})
+}
@@ -0,0 +1,94 @@
+package jocko
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/testutil/retry"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/mock"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := &circuitBreaker{threshold: 2, cooldown: 20 * time.Millisecond}
+	require.True(t, cb.allow())
+	require.False(t, cb.failure())
+	require.True(t, cb.allow())
+	require.True(t, cb.failure())
+	require.False(t, cb.allow())
+	require.True(t, cb.isOpen())
+
+	// one try's let through after the cooldown and a failed try opens it again
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, cb.allow())
+	require.False(t, cb.allow())
+	require.False(t, cb.failure())
+	require.False(t, cb.allow())
+
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, cb.allow())
+	cb.success()
+	require.True(t, cb.allow())
+	require.True(t, cb.allow())
+	require.False(t, cb.isOpen())
+
+	// a zero threshold never opens
+	cb = &circuitBreaker{}
+	for i := 0; i < 10; i++ {
+		require.False(t, cb.failure())
+	}
+	require.True(t, cb.allow())
+}
+
+func TestReplicator_AppendBreaker(t *testing.T) {
+	cb := &circuitBreaker{threshold: 1, cooldown: 20 * time.Millisecond}
+	var mu sync.Mutex
+	var appended [][]byte
+	failing := true
+	l := &mock.CommitLog{
+		AppendFunc: func(b []byte) (int64, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failing {
+				return 0, errors.New("input/output error")
+			}
+			appended = append(appended, b)
+			return int64(len(appended) - 1), nil
+		},
+	}
+	replica := &Replica{Partition: structs.Partition{Topic: "the-topic"}, Log: l}
+	r := NewReplicator(ReplicatorConfig{
+		breaker: cb,
+		failed:  func(error) { cb.failure() },
+	}, replica, nil, log.New())
+	go r.appendMessages()
+	defer r.Close()
+
+	r.msgs <- []byte("one")
+	r.msgs <- []byte("two")
+	// the failing append opens the breaker, it's only tried again after the cooldown
+	retry.Run(t, func(r *retry.R) {
+		if !cb.isOpen() {
+			r.Fatal("breaker not open")
+		}
+	})
+	require.Equal(t, 1, len(l.AppendCalls()))
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+
+	// the messages are appended in order once the log works
+	retry.Run(t, func(r *retry.R) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(appended) != 2 {
+			r.Fatalf("got %d appended, want 2", len(appended))
+		}
+	})
+	require.Equal(t, [][]byte{[]byte("one"), []byte("two")}, appended)
+	require.False(t, cb.isOpen())
+}
@@ -0,0 +1,252 @@
+package jocko
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// clusterClient keeps the metadata of another cluster and conns to its brokers, like a Kafka
+// cluster being shadowed or imported from. It isn't safe for concurrent use.
+type clusterClient struct {
+	bootstrap []string
+	dialer    *Dialer
+	timeout   time.Duration
+
+	brokers    map[int32]string
+	leaders    map[topicPartition]int32
+	conns      map[int32]*Conn
+	controller int32
+}
+
+func newClusterClient(bootstrap []string, dialer *Dialer, timeout time.Duration) *clusterClient {
+	return &clusterClient{
+		bootstrap:  bootstrap,
+		dialer:     dialer,
+		timeout:    timeout,
+		brokers:    make(map[int32]string),
+		leaders:    make(map[topicPartition]int32),
+		conns:      make(map[int32]*Conn),
+		controller: -1,
+	}
+}
+
+// leader returns a conn to the partition's leader, looking the leader up if it isn't known. The
+// conn's deadline is set for a request.
+func (c *clusterClient) leader(tp topicPartition) (*Conn, error) {
+	id, ok := c.leaders[tp]
+	if !ok {
+		if _, err := c.metadata(tp.topic); err != nil {
+			return nil, err
+		}
+		if id, ok = c.leaders[tp]; !ok {
+			return nil, protocol.ErrLeaderNotAvailable
+		}
+	}
+	return c.conn(id)
+}
+
+// controllerConn returns a conn to the cluster's controller, for admin requests.
+func (c *clusterClient) controllerConn() (*Conn, error) {
+	if c.controller < 0 {
+		if _, err := c.metadata(); err != nil {
+			return nil, err
+		}
+		if c.controller < 0 {
+			return nil, protocol.ErrNotController
+		}
+	}
+	return c.conn(c.controller)
+}
+
+// coordinator returns a conn to the group's coordinator.
+func (c *clusterClient) coordinator(group string) (*Conn, error) {
+	conn, err := c.controllerConn()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := conn.FindCoordinator(&protocol.FindCoordinatorRequest{CoordinatorKey: group})
+	if err != nil {
+		c.dropConn(c.controller)
+		c.controller = -1
+		return nil, err
+	}
+	if resp.ErrorCode != protocol.ErrNone.Code() {
+		return nil, protocol.Errs[resp.ErrorCode]
+	}
+	c.brokers[resp.Coordinator.NodeID] = net.JoinHostPort(resp.Coordinator.Host, strconv.Itoa(int(resp.Coordinator.Port)))
+	return c.conn(resp.Coordinator.NodeID)
+}
+
+// failed forgets the partition's leader after a request to it failed so it's looked up again,
+// closing the conn too if the request didn't get a response.
+func (c *clusterClient) failed(tp topicPartition, err error) {
+	id, ok := c.leaders[tp]
+	if !ok {
+		return
+	}
+	delete(c.leaders, tp)
+	if _, ok := err.(protocol.Error); !ok {
+		c.dropConn(id)
+	}
+}
+
+// metadata returns the metadata of the topics, or just the brokers if there are none, from the
+// first bootstrap broker that answers, and keeps the brokers and partition leaders from it.
+func (c *clusterClient) metadata(topics ...string) (*protocol.MetadataResponse, error) {
+	if topics == nil {
+		topics = []string{}
+	}
+	return c.requestMetadata(topics)
+}
+
+// allMetadata returns the metadata of every topic, like metadata.
+func (c *clusterClient) allMetadata() (*protocol.MetadataResponse, error) {
+	return c.requestMetadata(nil)
+}
+
+// requestMetadata asks for the metadata of the topics, nil asking for every topic.
+func (c *clusterClient) requestMetadata(topics []string) (*protocol.MetadataResponse, error) {
+	var err error
+	for _, addr := range c.bootstrap {
+		var conn *Conn
+		if conn, err = c.dialer.Dial("tcp", addr); err != nil {
+			continue
+		}
+		conn.SetDeadline(time.Now().Add(c.timeout))
+		var resp *protocol.MetadataResponse
+		resp, err = conn.Metadata(&protocol.MetadataRequest{APIVersion: 1, Topics: topics})
+		conn.Close()
+		if err != nil {
+			continue
+		}
+		for _, broker := range resp.Brokers {
+			c.brokers[broker.NodeID] = net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+		}
+		c.controller = resp.ControllerID
+		for _, t := range resp.TopicMetadata {
+			for _, p := range t.PartitionMetadata {
+				tp := topicPartition{topic: t.Topic, partition: p.PartitionID}
+				if p.PartitionErrorCode == protocol.ErrNone.Code() && p.Leader >= 0 {
+					c.leaders[tp] = p.Leader
+				} else {
+					delete(c.leaders, tp)
+				}
+			}
+		}
+		return resp, nil
+	}
+	if err == nil {
+		err = protocol.ErrBrokerNotAvailable
+	}
+	return nil, err
+}
+
+// conn returns a conn to the broker with its deadline set, dialing it if there isn't one.
+func (c *clusterClient) conn(id int32) (*Conn, error) {
+	conn, ok := c.conns[id]
+	if !ok {
+		addr, ok := c.brokers[id]
+		if !ok {
+			return nil, protocol.ErrBrokerNotAvailable
+		}
+		var err error
+		if conn, err = c.dialer.Dial("tcp", addr); err != nil {
+			return nil, err
+		}
+		c.conns[id] = conn
+	}
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	return conn, nil
+}
+
+func (c *clusterClient) dropConn(id int32) {
+	if conn, ok := c.conns[id]; ok {
+		conn.Close()
+		delete(c.conns, id)
+	}
+}
+
+// close closes the conns to the cluster's brokers.
+func (c *clusterClient) close() {
+	for id := range c.conns {
+		c.dropConn(id)
+	}
+}
+
+// fetch fetches the partition from the offset, read committed, returning the partition's response.
+func (c *clusterClient) fetch(tp topicPartition, offset int64, maxBytes int32) (*protocol.FetchPartitionResponse, error) {
+	conn, err := c.leader(tp)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := conn.Fetch(&protocol.FetchRequest{
+		APIVersion:     4,
+		ReplicaID:      -1,
+		MinBytes:       1,
+		MaxWaitTime:    500,
+		MaxBytes:       maxBytes,
+		IsolationLevel: protocol.ReadCommitted,
+		Topics: []*protocol.FetchTopic{{
+			Topic:      tp.topic,
+			Partitions: []*protocol.FetchPartition{{Partition: tp.partition, FetchOffset: offset, MaxBytes: maxBytes}},
+		}},
+	})
+	if err != nil {
+		c.failed(tp, err)
+		return nil, err
+	}
+	if len(resp.Responses) != 1 || len(resp.Responses[0].PartitionResponses) != 1 {
+		return nil, protocol.ErrUnknown
+	}
+	p := resp.Responses[0].PartitionResponses[0]
+	if p.ErrorCode != protocol.ErrNone.Code() {
+		c.failed(tp, protocol.Errs[p.ErrorCode])
+		return nil, protocol.Errs[p.ErrorCode]
+	}
+	return p, nil
+}
+
+// offset returns the partition's earliest offset for timestamp -2, its latest for -1, and the
+// first offset with a timestamp at or after the timestamp, in milliseconds, otherwise. It's -1
+// if there's no offset with such a timestamp.
+func (c *clusterClient) offset(tp topicPartition, timestamp int64) (int64, error) {
+	conn, err := c.leader(tp)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := conn.Offsets(&protocol.OffsetsRequest{
+		APIVersion: 1,
+		ReplicaID:  -1,
+		Topics: []*protocol.OffsetsTopic{{
+			Topic:      tp.topic,
+			Partitions: []*protocol.OffsetsPartition{{Partition: tp.partition, Timestamp: timestamp}},
+		}},
+	})
+	if err != nil {
+		c.failed(tp, err)
+		return 0, err
+	}
+	if len(resp.Responses) != 1 || len(resp.Responses[0].PartitionResponses) != 1 {
+		return 0, protocol.ErrUnknown
+	}
+	p := resp.Responses[0].PartitionResponses[0]
+	if p.ErrorCode != protocol.ErrNone.Code() {
+		c.failed(tp, protocol.Errs[p.ErrorCode])
+		return 0, protocol.Errs[p.ErrorCode]
+	}
+	return p.Offset, nil
+}
+
+// produceVersion returns the produce request version to send the record set to a Kafka cluster
+// with. v2 record batches need v3 requests, and older message sets can't be sent with them.
+func produceVersion(recordSet []byte) int16 {
+	for _, e := range protocol.RecordSetEntries(recordSet) {
+		if e.Magic < 2 {
+			return 2
+		}
+	}
+	return 3
+}
@@ -29,7 +29,179 @@ type Config struct {
 	NonVoter          bool
 	RaftAddr          string
 	LeaveDrainTime    time.Duration
+	// ReconcileInterval is how often the controller reconciles the cluster's membership with the
+	// brokers registered in the state store.
 	ReconcileInterval time.Duration
+	// ReconcileMaxBackoff is the longest the controller waits before reconciling again while
+	// reconciling keeps failing, the wait doubling from the interval with each failure.
+	ReconcileMaxBackoff time.Duration
+	ClientSocket        SocketConfig
+	ReplicaSocket       SocketConfig
+	// SocketRequestMaxBytes is the largest request the broker reads, connections sending
+	// larger requests are closed.
+	SocketRequestMaxBytes int
+	// FailedNodeTTL is how long a broker can be failed before the controller moves its replicas
+	// to other brokers and deregisters it. Zero disables reaping failed brokers.
+	FailedNodeTTL time.Duration
+	// LogDirs are the directories to spread the partitions' logs across, usually one per disk.
+	// Defaults to the data directory within DataDir.
+	LogDirs []string
+	// AutoLeaderRebalance moves the leadership of partitions back to their preferred replicas
+	// once they're in sync, like after a failed broker recovers.
+	AutoLeaderRebalance bool
+	// PartitionFailureThreshold is how many times in a row a partition's log can fail before the
+	// broker takes its replica offline and stops using the log. Zero disables this.
+	PartitionFailureThreshold int
+	// PartitionFailureCooldown is how long an offline replica's log is left before it's tried again.
+	PartitionFailureCooldown time.Duration
+	// NumPartitions and DefaultReplicationFactor are used for topics created without them,
+	// unless they're overridden by the num.partitions and default.replication.factor dynamic
+	// configs.
+	NumPartitions            int32
+	DefaultReplicationFactor int
+	// AllowLiveGroupOffsetReset lets consumers outside a group, like admin tools, commit the
+	// group's offsets while it has members. Off by default since the members carry on from the
+	// offsets they had and duplicate or skip messages.
+	AllowLiveGroupOffsetReset bool
+	// GroupMinSessionTimeout and GroupMaxSessionTimeout bound the session timeouts group members
+	// join with, so members aren't expired before they can heartbeat or kept long after they're
+	// gone.
+	GroupMinSessionTimeout time.Duration
+	GroupMaxSessionTimeout time.Duration
+	// GroupMaxSize is the most members a group can have, new members joining a full group are
+	// refused. Zero means groups can have any number of members.
+	GroupMaxSize int
+	// OffsetsRetention is how long the offsets groups commit are kept, from when they're
+	// committed, once their groups are empty. Offsets committed with a retention time of their
+	// own, which older clients send, are kept for that long instead.
+	OffsetsRetention time.Duration
+	// OffsetsRetentionCheckInterval is how often coordinators remove their groups' expired
+	// offsets.
+	OffsetsRetentionCheckInterval time.Duration
+	// OffsetMetadataMaxBytes is the longest metadata groups can commit with their offsets,
+	// partitions committed with longer metadata are refused.
+	OffsetMetadataMaxBytes int
+	// GroupEmptyRetention is how long a group can go empty, without members or offsets
+	// committed, before it's dead and its coordinator deletes it, checked every
+	// OffsetsRetentionCheckInterval. Zero keeps empty groups.
+	GroupEmptyRetention time.Duration
+	// ConsistencyCheckInterval is how often the broker compares the partition logs in its log
+	// dirs with the replicas it's assigned, reporting orphaned logs and missing replicas. Zero
+	// disables the periodic check, it can still be run through the admin API.
+	ConsistencyCheckInterval time.Duration
+	// FixOrphanedLogs removes logs the periodic consistency check finds orphaned twice in a row.
+	FixOrphanedLogs bool
+	// PublishLeaderChanges has the controller publish partitions' leaders to the compacted
+	// __leader_changes topic whenever they change, for systems tracking where partitions are led.
+	PublishLeaderChanges bool
+	// TransactionStateNumPartitions is the number of partitions of the __transaction_state topic
+	// transaction coordinators keep their transactions in, set when it's created.
+	TransactionStateNumPartitions int32
+	// TransactionMaxTimeout is the longest timeout producers can give their transactions.
+	TransactionMaxTimeout time.Duration
+	// TransactionAbortTimedOutInterval is how often coordinators abort the transactions that have
+	// run past their timeouts.
+	TransactionAbortTimedOutInterval time.Duration
+	// DelegationTokenSecretKey is the key delegation tokens' HMACs are made with, it must be the
+	// same on every broker. Delegation tokens are disabled without one.
+	DelegationTokenSecretKey string
+	// DelegationTokenMaxLifetime is the longest delegation tokens can be renewed for, counted
+	// from when they're created.
+	DelegationTokenMaxLifetime time.Duration
+	// DelegationTokenExpiryTime is how long delegation tokens last when they're created or
+	// renewed without a renew period.
+	DelegationTokenExpiryTime time.Duration
+	// DelegationTokenExpiryCheckInterval is how often the controller removes expired tokens.
+	DelegationTokenExpiryCheckInterval time.Duration
+	// ShadowBrokers are the bootstrap addresses of an external Kafka cluster to dual-write
+	// produces to, for validating jocko against it before cutting over. Empty disables shadowing.
+	ShadowBrokers []string
+	// ShadowQueueSize is how many produced record sets can wait to be forwarded to the shadowed
+	// cluster before more are dropped rather than holding up produces.
+	ShadowQueueSize int
+	// ShadowVerifyInterval is how often record sets forwarded to the shadowed cluster are read
+	// back and their checksums compared with the ones jocko wrote.
+	ShadowVerifyInterval time.Duration
+	// ShadowTopics is a regular expression the topics whose produces are forwarded to the
+	// shadowed cluster must match, so topics created later are shadowed without changing the
+	// config. Empty forwards every topic's.
+	ShadowTopics string
+	// ShadowOffsetSyncsTopic is the topic on the shadowed cluster the offset syncs consumers'
+	// offsets are translated with are written to, it has to exist or be auto created. Empty
+	// disables writing them.
+	ShadowOffsetSyncsTopic string
+	// KeyBloomFilters keeps bloom filters of the keys in each segment of compacted topics' logs in
+	// memory, so compaction only maps the keys that may have been written before.
+	KeyBloomFilters bool
+	// ClusterID identifies the cluster to clients in Metadata responses, it should be the same on
+	// every broker. Responses have no cluster id without one.
+	ClusterID string
+	// Rack is the rack the broker's in, advertised to clients in Metadata responses so they can
+	// spread replicas and pick brokers by rack. Empty if the broker isn't in one.
+	Rack string
+	// ControlledShutdown has the broker ask the controller to move the leadership of its
+	// partitions to other replicas before it shuts down, rather than leaving them unavailable
+	// until it's seen to fail.
+	ControlledShutdown bool
+	// ControlledShutdownMaxRetries is how many times the broker retries asking the controller
+	// while it can't be reached or leaves partitions it couldn't move, before shutting down anyway.
+	ControlledShutdownMaxRetries int
+	// ControlledShutdownRetryBackoff is how long the broker waits between those retries.
+	ControlledShutdownRetryBackoff time.Duration
+	// ProduceHandlers, FetchHandlers and AdminHandlers are how many produce, fetch, and metadata
+	// and admin requests are handled at once. Each class has its own handlers so one that's busy
+	// can't hold up the others.
+	ProduceHandlers int
+	FetchHandlers   int
+	AdminHandlers   int
+	// ShutdownStageTimeout is how long each stage of the broker's shutdown, like draining the
+	// requests being handled or flushing the logs, gets before the broker moves on to the next.
+	ShutdownStageTimeout time.Duration
+	// GroupRebalanceHistorySize is how many of the last rebalances of each group the broker
+	// coordinates are kept for the admin API. Zero disables keeping them.
+	GroupRebalanceHistorySize int
+	// ReplicaFetchMaxBytes is how much of each partition followers fetch at a time. A follower
+	// raises it for a partition while the leader has a batch bigger than it.
+	ReplicaFetchMaxBytes int32
+	// ReplicaFetchBackoff is how long followers wait to fetch again after a fetch failed. It's
+	// doubled each time a follower's fetches from a leader fail in a row, up to
+	// ReplicaFetchBackoffMax.
+	ReplicaFetchBackoff    time.Duration
+	ReplicaFetchBackoffMax time.Duration
+	// ReplicaLagTimeMax is how long a follower can go without catching up with its leader before
+	// the leader takes it out of the partition's isr. Followers that catch up are put back in.
+	ReplicaLagTimeMax time.Duration
+	// LeaderLeaseDuration is how long the lease the controller grants a broker on the partitions
+	// it leads lasts. Leaders stop serving produces and consumers' fetches of a partition once
+	// their lease on it runs out, so a leader that's been deposed stops within it even if it
+	// wasn't told. Zero turns leases off.
+	LeaderLeaseDuration time.Duration
+	// ReplicaHighWatermarkCheckpointInterval is how often the partitions' high watermarks are
+	// checkpointed to the data dir so they survive restarts. Zero only checkpoints them on
+	// shutdown.
+	ReplicaHighWatermarkCheckpointInterval time.Duration
+	// ReplicaFetchWaitMax is how long leaders wait for messages before answering followers'
+	// fetches without them.
+	ReplicaFetchWaitMax time.Duration
+	// ReplicaSnapshotMinBytes is how far behind, in bytes of its leader's sealed segments, a
+	// follower has to be to copy those segments whole rather than fetching their messages. Zero
+	// disables copying them.
+	ReplicaSnapshotMinBytes int64
+	// OrderingAudit turns on checking the offsets and producer sequences appended to the
+	// partitions this broker replicates, and their high watermarks, only ever go up, flagging
+	// any that don't. It's meant for staging, to validate replication and idempotence changes.
+	OrderingAudit bool
+	// Version is the version of jocko the broker's running, recorded when it starts.
+	Version string
+}
+
+// SocketConfig holds the TCP options set on connections. Zero buffer sizes and keep-alive
+// periods leave the OS defaults in place, and TCP_NODELAY is set unless DisableNoDelay is.
+type SocketConfig struct {
+	SendBufferBytes    int
+	ReceiveBufferBytes int
+	DisableNoDelay     bool
+	KeepAlive          time.Duration
 }
 
 // DefaultConfig creates/returns a default configuration.
@@ -46,6 +218,55 @@ func DefaultConfig() *Config {
 		RaftConfig:        raft.DefaultConfig(),
 		LeaveDrainTime:    5 * time.Second,
 		ReconcileInterval: 60 * time.Second,
+		FailedNodeTTL:     3 * 24 * time.Hour,
+		ReplicaSocket:     SocketConfig{KeepAlive: 30 * time.Second},
+
+		SocketRequestMaxBytes: 100 * 1024 * 1024,
+		ReconcileMaxBackoff:   10 * time.Minute,
+		AutoLeaderRebalance:   true,
+
+		PartitionFailureThreshold: 5,
+		PartitionFailureCooldown:  30 * time.Second,
+		NumPartitions:             1,
+		DefaultReplicationFactor:  1,
+		ConsistencyCheckInterval:  time.Hour,
+
+		TransactionStateNumPartitions:    50,
+		TransactionMaxTimeout:            15 * time.Minute,
+		TransactionAbortTimedOutInterval: 10 * time.Second,
+
+		DelegationTokenMaxLifetime:         7 * 24 * time.Hour,
+		DelegationTokenExpiryTime:          24 * time.Hour,
+		DelegationTokenExpiryCheckInterval: time.Hour,
+
+		ShadowQueueSize:        10000,
+		ShadowVerifyInterval:   time.Minute,
+		ShadowOffsetSyncsTopic: "jocko-offset-syncs",
+
+		ControlledShutdown:             true,
+		ControlledShutdownMaxRetries:   3,
+		ControlledShutdownRetryBackoff: 5 * time.Second,
+		ShutdownStageTimeout:           30 * time.Second,
+
+		ProduceHandlers: 1,
+		FetchHandlers:   4,
+		AdminHandlers:   1,
+
+		GroupRebalanceHistorySize:     10,
+		GroupMinSessionTimeout:        6 * time.Second,
+		GroupMaxSessionTimeout:        30 * time.Minute,
+		OffsetsRetention:              7 * 24 * time.Hour,
+		OffsetsRetentionCheckInterval: 10 * time.Minute,
+		OffsetMetadataMaxBytes:        4096,
+		GroupEmptyRetention:           7 * 24 * time.Hour,
+
+		ReplicaFetchMaxBytes:                   1024 * 1024,
+		ReplicaFetchBackoff:                    time.Second,
+		ReplicaFetchBackoffMax:                 30 * time.Second,
+		ReplicaFetchWaitMax:                    500 * time.Millisecond,
+		ReplicaHighWatermarkCheckpointInterval: 5 * time.Second,
+		ReplicaLagTimeMax:                      10 * time.Second,
+		ReplicaSnapshotMinBytes:                256 * 1024 * 1024,
 	}
 
 	conf.SerfLANConfig.ReconnectTimeout = 3 * 24 * time.Hour
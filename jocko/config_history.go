@@ -0,0 +1,194 @@
+package jocko
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+)
+
+// configEntries returns the values set on the resource's configs, nil if it isn't a topic or
+// broker or the topic doesn't exist. Configs that aren't set, their defaults applying, are left
+// out.
+func (b *Broker) configEntries(resourceType structs.ConfigResourceType, name string) map[string]string {
+	state := b.fsm.State()
+	entries := make(map[string]string)
+	switch resourceType {
+	case structs.TopicConfigResource:
+		_, t, err := state.GetTopic(name)
+		if err != nil || t == nil {
+			return nil
+		}
+		for k, e := range t.Config {
+			if e.Value != nil {
+				entries[k] = fmt.Sprint(e.Value)
+			}
+		}
+	case structs.BrokerConfigResource:
+		_, config, err := state.GetConfig(structs.BrokerConfigResource, name)
+		if err != nil {
+			return nil
+		}
+		if config != nil {
+			for k, v := range config.Entries {
+				entries[k] = v
+			}
+		}
+	default:
+		return nil
+	}
+	return entries
+}
+
+// diffConfigEntries returns the configs whose values differ before and after, sorted by name.
+func diffConfigEntries(before, after map[string]string) []structs.ConfigChangeEntry {
+	var entries []structs.ConfigChangeEntry
+	for name, v := range before {
+		if w, ok := after[name]; !ok || v != w {
+			entries = append(entries, structs.ConfigChangeEntry{Name: name, Before: configChangeValue(v, true), After: configChangeValue(w, ok)})
+		}
+	}
+	for name, w := range after {
+		if _, ok := before[name]; !ok {
+			entries = append(entries, structs.ConfigChangeEntry{Name: name, After: configChangeValue(w, true)})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+func configChangeValue(v string, ok bool) *string {
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// recordConfigsChange records the change the request made to the resource's configs, given
+// their values before it. Requests that didn't change any values aren't recorded.
+func (b *Broker) recordConfigsChange(ctx *Context, operation string, resourceType structs.ConfigResourceType, name string, before map[string]string) {
+	kind := structs.TopicConfigChange
+	if resourceType == structs.BrokerConfigResource {
+		kind = structs.BrokerConfigChange
+	}
+	entries := diffConfigEntries(before, b.configEntries(resourceType, name))
+	if len(entries) == 0 {
+		return
+	}
+	b.recordConfigChange(ctx, kind, name, structs.ConfigChange{Operation: operation, Entries: entries})
+}
+
+// recordConfigChange adds the change to the history of the resource's changes with who made it
+// and when. The change has been made by then, so failing to record it is only logged.
+func (b *Broker) recordConfigChange(ctx *Context, kind, resource string, change structs.ConfigChange) {
+	change.Time = time.Now()
+	change.Principal = requestPrincipal(ctx).name
+	if _, err := b.raftApply(structs.AddConfigChangeRequestType, structs.AddConfigChangeRequest{
+		Kind:     kind,
+		Resource: resource,
+		Change:   change,
+	}); err != nil {
+		b.logger.Error("failed to record config change", log.String("kind", kind), log.String("resource", resource), log.Error("error", err))
+	}
+}
+
+// configChangeEntry is a config's values before and after a change as the admin API returns it.
+type configChangeEntry struct {
+	Name   string  `json:"name"`
+	Before *string `json:"before"`
+	After  *string `json:"after"`
+}
+
+// configChangeAcl is an ACL created or deleted by a change as the admin API returns it, with
+// Kafka's IDs for its enums.
+type configChangeAcl struct {
+	ResourceType   int8   `json:"resource_type"`
+	ResourceName   string `json:"resource_name"`
+	PatternType    int8   `json:"pattern_type"`
+	Principal      string `json:"principal"`
+	Host           string `json:"host"`
+	Operation      int8   `json:"operation"`
+	PermissionType int8   `json:"permission_type"`
+}
+
+// configChange is a config or ACL change as the admin API returns it.
+type configChange struct {
+	Kind        string              `json:"kind"`
+	Resource    string              `json:"resource"`
+	Time        time.Time           `json:"time"`
+	Principal   string              `json:"principal"`
+	Operation   string              `json:"operation"`
+	Entries     []configChangeEntry `json:"entries,omitempty"`
+	AclsCreated []configChangeAcl   `json:"acls_created,omitempty"`
+	AclsDeleted []configChangeAcl   `json:"acls_deleted,omitempty"`
+}
+
+func configChangeAcls(acls []structs.Acl) []configChangeAcl {
+	var res []configChangeAcl
+	for _, a := range acls {
+		res = append(res, configChangeAcl{
+			ResourceType:   a.ResourceType,
+			ResourceName:   a.ResourceName,
+			PatternType:    a.PatternType,
+			Principal:      a.Principal,
+			Host:           a.Host,
+			Operation:      a.Operation,
+			PermissionType: a.PermissionType,
+		})
+	}
+	return res
+}
+
+// adminConfigHistory returns the changes made to topic and broker configs and to the ACLs, oldest
+// first, with who made them and the configs' values before and after so bad changes can be
+// reverted. They're filtered by the kind of resource (topic, broker or acls), resource name and
+// principal given. Broker configs for all brokers have an empty resource name.
+//
+//	GET /v1/configs/history[?kind=<kind>&resource=<resource>&principal=<principal>]
+func (b *Broker) adminConfigHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, histories, err := b.fsm.State().GetConfigHistories()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	_, filterResource := q["resource"]
+	_, filterPrincipal := q["principal"]
+	changes := []configChange{}
+	for _, h := range histories {
+		if kind != "" && kind != h.Kind || filterResource && q.Get("resource") != h.Resource {
+			continue
+		}
+		for _, c := range h.Changes {
+			if filterPrincipal && q.Get("principal") != c.Principal {
+				continue
+			}
+			change := configChange{
+				Kind:        h.Kind,
+				Resource:    h.Resource,
+				Time:        c.Time,
+				Principal:   c.Principal,
+				Operation:   c.Operation,
+				AclsCreated: configChangeAcls(c.AclsCreated),
+				AclsDeleted: configChangeAcls(c.AclsDeleted),
+			}
+			for _, e := range c.Entries {
+				change.Entries = append(change.Entries, configChangeEntry{Name: e.Name, Before: e.Before, After: e.After})
+			}
+			changes = append(changes, change)
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.Before(changes[j].Time) })
+	writeAdminJSON(w, struct {
+		Changes []configChange `json:"changes"`
+	}{changes})
+}
@@ -0,0 +1,591 @@
+package jocko
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// describeTopicConfigs returns the topic's config entries, limited to names if any are given.
+func describeTopicConfigs(topic *structs.Topic, names []string, includeSynonyms bool) []protocol.DescribeConfigsEntry {
+	all := make([]string, 0, len(topic.Config))
+	for name := range topic.Config {
+		all = append(all, name)
+	}
+	var entries []protocol.DescribeConfigsEntry
+	for _, name := range configNames(all, names) {
+		e, ok := topic.Config[name]
+		if !ok {
+			continue
+		}
+		entry := protocol.DescribeConfigsEntry{
+			Name:      name,
+			Value:     configValue(topic.Config.GetValue(name)),
+			IsDefault: e.Value == nil,
+		}
+		if includeSynonyms {
+			if e.Value != nil {
+				entry.Synonyms = append(entry.Synonyms, protocol.DescribeConfigsSynonym{
+					Name:   name,
+					Value:  configValue(e.Value),
+					Source: protocol.ConfigSourceTopicConfig,
+				})
+			}
+			defaultName := e.ServerDefault
+			if defaultName == "" {
+				defaultName = name
+			}
+			entry.Synonyms = append(entry.Synonyms, protocol.DescribeConfigsSynonym{
+				Name:   defaultName,
+				Value:  configValue(e.Default),
+				Source: protocol.ConfigSourceDefaultConfig,
+			})
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// describeBrokerConfigs returns the broker's config entries, limited to names if any are given.
+// Entries are the broker's static config, the server defaults for topic configs, and any
+// dynamic configs set for all brokers or this broker, in increasing precedence.
+func (b *Broker) describeBrokerConfigs(names []string, includeSynonyms bool) ([]protocol.DescribeConfigsEntry, error) {
+	type value struct {
+		value  string
+		source int8
+	}
+	values := make(map[string][]value)
+	add := func(name, v string, source int8) {
+		// prepend so the value in effect comes first, like kafka orders synonyms
+		values[name] = append([]value{{v, source}}, values[name]...)
+	}
+	readOnly := map[string]bool{}
+	for name, v := range b.staticConfigs() {
+		add(name, v, protocol.ConfigSourceStaticBrokerConfig)
+		readOnly[name] = true
+	}
+	for _, e := range structs.NewTopicConfig() {
+		if e.ServerDefault == "" || e.Default == nil {
+			continue
+		}
+		add(e.ServerDefault, fmt.Sprint(e.Default), protocol.ConfigSourceDefaultConfig)
+	}
+	state := b.fsm.State()
+	for _, dynamic := range []struct {
+		resource string
+		source   int8
+	}{
+		{"", protocol.ConfigSourceDynamicDefaultBrokerConfig},
+		{strconv.Itoa(int(b.config.ID)), protocol.ConfigSourceDynamicBrokerConfig},
+	} {
+		_, config, err := state.GetConfig(structs.BrokerConfigResource, dynamic.resource)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			continue
+		}
+		for name, v := range config.Entries {
+			add(name, v, dynamic.source)
+		}
+	}
+
+	all := make([]string, 0, len(values))
+	for name := range values {
+		all = append(all, name)
+	}
+	var entries []protocol.DescribeConfigsEntry
+	for _, name := range configNames(all, names) {
+		vs, ok := values[name]
+		if !ok {
+			continue
+		}
+		v := vs[0].value
+		entry := protocol.DescribeConfigsEntry{
+			Name:      name,
+			Value:     &v,
+			ReadOnly:  readOnly[name],
+			IsDefault: vs[0].source == protocol.ConfigSourceDefaultConfig,
+		}
+		if includeSynonyms {
+			for _, s := range vs {
+				s := s
+				entry.Synonyms = append(entry.Synonyms, protocol.DescribeConfigsSynonym{
+					Name:   name,
+					Value:  &s.value,
+					Source: s.source,
+				})
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// staticConfigs maps the broker's config to kafka's config names.
+func (b *Broker) staticConfigs() map[string]string {
+	bufferBytes := func(n int) string {
+		// kafka uses -1 for the os default
+		if n == 0 {
+			return "-1"
+		}
+		return strconv.Itoa(n)
+	}
+	// groups without a max size are reported with Kafka's default
+	groupMaxSize := strconv.Itoa(math.MaxInt32)
+	if b.config.GroupMaxSize > 0 {
+		groupMaxSize = strconv.Itoa(b.config.GroupMaxSize)
+	}
+	return map[string]string{
+		"broker.id":                    strconv.Itoa(int(b.config.ID)),
+		"log.dirs":                     strings.Join(b.logDirs(), ","),
+		"listeners":                    "PLAINTEXT://" + b.config.Addr,
+		"socket.send.buffer.bytes":     bufferBytes(b.config.ClientSocket.SendBufferBytes),
+		"socket.receive.buffer.bytes":  bufferBytes(b.config.ClientSocket.ReceiveBufferBytes),
+		"socket.request.max.bytes":     strconv.Itoa(b.config.SocketRequestMaxBytes),
+		"offsets.retention.minutes":    strconv.Itoa(int(b.config.OffsetsRetention / time.Minute)),
+		"offset.metadata.max.bytes":    strconv.Itoa(b.config.OffsetMetadataMaxBytes),
+		"group.min.session.timeout.ms": strconv.FormatInt(int64(b.config.GroupMinSessionTimeout/time.Millisecond), 10),
+		"group.max.session.timeout.ms": strconv.FormatInt(int64(b.config.GroupMaxSessionTimeout/time.Millisecond), 10),
+		"group.max.size":               groupMaxSize,
+	}
+}
+
+// configNames returns the requested names, or all the names sorted if none were requested.
+func configNames(all, requested []string) []string {
+	if len(requested) != 0 {
+		return requested
+	}
+	sort.Strings(all)
+	return all
+}
+
+func configValue(v interface{}) *string {
+	if v == nil {
+		return nil
+	}
+	s := fmt.Sprint(v)
+	return &s
+}
+
+// configInt returns the config value as an int64. Values read from the state store come back as
+// whatever type msgpack decoded them to.
+func configInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// compressionLevel returns the level the topic's config compresses batches at with the codec,
+// 0 for the codec's default.
+func compressionLevel(config structs.TopicConfig, codec int8) int {
+	var level int64
+	switch codec {
+	case protocol.CompressionGZIP:
+		level, _ = configInt(config.GetValue("compression.gzip.level"))
+	case protocol.CompressionZstd:
+		level, _ = configInt(config.GetValue("compression.zstd.level"))
+	}
+	return int(level)
+}
+
+// alterTopicConfigs replaces the topic's configs with the given entries, configs that aren't
+// given go back to their defaults.
+func (b *Broker) alterTopicConfigs(name string, entries []protocol.AlterConfigsEntry, validateOnly bool) protocol.Error {
+	_, t, err := b.fsm.State().GetTopic(name)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	if t == nil {
+		return protocol.ErrUnknownTopicOrPartition
+	}
+	config := structs.NewTopicConfig()
+	for _, entry := range entries {
+		e, ok := config[entry.Name]
+		if !ok {
+			return protocol.ErrInvalidConfig
+		}
+		if entry.Value == nil {
+			continue
+		}
+		v, err := parseTopicConfigValue(e, *entry.Value)
+		if err != nil {
+			return protocol.ErrInvalidConfig.WithErr(err)
+		}
+		config.SetValue(entry.Name, v)
+	}
+	if validateOnly {
+		return protocol.ErrNone
+	}
+	tt := *t
+	tt.Config = config
+	if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: tt}); err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return protocol.ErrNone
+}
+
+// alterBrokerConfigs replaces the dynamic configs for the broker, or all brokers if name is empty.
+func (b *Broker) alterBrokerConfigs(name string, entries []protocol.AlterConfigsEntry, validateOnly bool) protocol.Error {
+	if name != "" {
+		if _, err := strconv.Atoi(name); err != nil {
+			return protocol.ErrInvalidRequest
+		}
+	}
+	static := b.staticConfigs()
+	config := structs.Config{
+		ResourceType: structs.BrokerConfigResource,
+		Resource:     name,
+		Entries:      make(map[string]string),
+	}
+	for _, entry := range entries {
+		// static configs are read-only
+		if _, ok := static[entry.Name]; ok {
+			return protocol.ErrInvalidConfig
+		}
+		if entry.Value == nil {
+			continue
+		}
+		if err := validateBrokerConfig(entry.Name, *entry.Value); err != protocol.ErrNone {
+			return err
+		}
+		config.Entries[entry.Name] = *entry.Value
+	}
+	if validateOnly {
+		return protocol.ErrNone
+	}
+	if _, err := b.raftApply(structs.RegisterConfigRequestType, structs.RegisterConfigRequest{Config: config}); err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return protocol.ErrNone
+}
+
+// incrementalAlterTopicConfigs applies the operations to the topic's configs, configs that aren't
+// given are left as they are.
+func (b *Broker) incrementalAlterTopicConfigs(name string, entries []protocol.IncrementalAlterConfigsEntry, validateOnly bool) protocol.Error {
+	_, t, err := b.fsm.State().GetTopic(name)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	if t == nil {
+		return protocol.ErrUnknownTopicOrPartition
+	}
+	config := make(structs.TopicConfig, len(t.Config))
+	for k, e := range t.Config {
+		config[k] = e
+	}
+	for _, entry := range entries {
+		e, ok := config[entry.Name]
+		if !ok {
+			return protocol.ErrInvalidConfig
+		}
+		if entry.Operation == protocol.ConfigOperationDelete {
+			config.SetValue(entry.Name, nil)
+			continue
+		}
+		if entry.Value == nil {
+			return protocol.ErrInvalidConfig
+		}
+		value := *entry.Value
+		if entry.Operation != protocol.ConfigOperationSet {
+			if !e.List {
+				return protocol.ErrInvalidConfig
+			}
+			var current string
+			if v := config.GetValue(entry.Name); v != nil {
+				current = fmt.Sprint(v)
+			}
+			var perr protocol.Error
+			if value, perr = alterList(current, entry.Operation, value); perr != protocol.ErrNone {
+				return perr
+			}
+		}
+		v, err := parseTopicConfigValue(e, value)
+		if err != nil {
+			return protocol.ErrInvalidConfig.WithErr(err)
+		}
+		config.SetValue(entry.Name, v)
+	}
+	if validateOnly {
+		return protocol.ErrNone
+	}
+	tt := *t
+	tt.Config = config
+	if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: tt}); err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return protocol.ErrNone
+}
+
+// brokerListConfigs are the dynamic broker configs whose values are comma separated lists.
+var brokerListConfigs = map[string]bool{
+	traceClientIDsConfig: true,
+	traceTopicsConfig:    true,
+}
+
+// incrementalAlterBrokerConfigs applies the operations to the dynamic configs for the broker, or
+// all brokers if name is empty.
+func (b *Broker) incrementalAlterBrokerConfigs(name string, entries []protocol.IncrementalAlterConfigsEntry, validateOnly bool) protocol.Error {
+	if name != "" {
+		if _, err := strconv.Atoi(name); err != nil {
+			return protocol.ErrInvalidRequest
+		}
+	}
+	_, current, err := b.fsm.State().GetConfig(structs.BrokerConfigResource, name)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	static := b.staticConfigs()
+	config := structs.Config{
+		ResourceType: structs.BrokerConfigResource,
+		Resource:     name,
+		Entries:      make(map[string]string),
+	}
+	if current != nil {
+		for k, v := range current.Entries {
+			config.Entries[k] = v
+		}
+	}
+	for _, entry := range entries {
+		if _, ok := static[entry.Name]; ok {
+			return protocol.ErrInvalidConfig
+		}
+		switch entry.Operation {
+		case protocol.ConfigOperationDelete:
+			delete(config.Entries, entry.Name)
+		case protocol.ConfigOperationSet:
+			if entry.Value == nil {
+				return protocol.ErrInvalidConfig
+			}
+			if err := validateBrokerConfig(entry.Name, *entry.Value); err != protocol.ErrNone {
+				return err
+			}
+			config.Entries[entry.Name] = *entry.Value
+		default:
+			if entry.Value == nil || !brokerListConfigs[entry.Name] {
+				return protocol.ErrInvalidConfig
+			}
+			v, perr := alterList(config.Entries[entry.Name], entry.Operation, *entry.Value)
+			if perr != protocol.ErrNone {
+				return perr
+			}
+			config.Entries[entry.Name] = v
+		}
+	}
+	if validateOnly {
+		return protocol.ErrNone
+	}
+	if _, err := b.raftApply(structs.RegisterConfigRequestType, structs.RegisterConfigRequest{Config: config}); err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return protocol.ErrNone
+}
+
+// validateBrokerConfig checks the dynamic broker config is one the broker knows and its value
+// parses, like topic configs are checked. Broker defaults for topic configs are checked against
+// the topic config they're the default of.
+func validateBrokerConfig(name, value string) protocol.Error {
+	if err, ok := validateQuotaConfig(name, value); ok {
+		return err
+	}
+	if ns := strings.TrimPrefix(name, namespaceConfigPrefix); ns != name {
+		// namespace.<namespace>.<config>
+		i := strings.Index(ns, ".")
+		if i <= 0 {
+			return protocol.ErrInvalidConfig
+		}
+		name = ns[i+1:]
+		if name != numPartitionsConfig && name != defaultReplicationFactorConfig {
+			return protocol.ErrInvalidConfig
+		}
+	}
+	switch name {
+	case traceClientIDsConfig, traceTopicsConfig, logDirsRebalanceConfig:
+		return protocol.ErrNone
+	case numPartitionsConfig:
+		// requests carry the number of partitions as an int32
+		if n, err := strconv.ParseInt(value, 10, 32); err != nil || n <= 0 {
+			return protocol.ErrInvalidConfig
+		}
+		return protocol.ErrNone
+	case defaultReplicationFactorConfig:
+		// and the replication factor as an int16
+		if n, err := strconv.ParseInt(value, 10, 16); err != nil || n <= 0 {
+			return protocol.ErrInvalidConfig
+		}
+		return protocol.ErrNone
+	case logDirsRebalanceThrottleConfig:
+		if n, err := strconv.ParseInt(value, 10, 64); err != nil || n < 0 {
+			return protocol.ErrInvalidConfig
+		}
+		return protocol.ErrNone
+	}
+	for _, e := range structs.NewTopicConfig() {
+		if e.ServerDefault != name {
+			continue
+		}
+		if _, err := parseTopicConfigValue(e, value); err != nil {
+			return protocol.ErrInvalidConfig.WithErr(err)
+		}
+		return protocol.ErrNone
+	}
+	return protocol.ErrInvalidConfig
+}
+
+// alterList appends the comma separated values to the list, skipping ones it already has, or
+// subtracts them from it.
+func alterList(list string, op int8, values string) (string, protocol.Error) {
+	var items []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	for _, v := range strings.Split(values, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		i := indexOf(items, v)
+		switch op {
+		case protocol.ConfigOperationAppend:
+			if i == -1 {
+				items = append(items, v)
+			}
+		case protocol.ConfigOperationSubtract:
+			if i != -1 {
+				items = append(items[:i], items[i+1:]...)
+			}
+		default:
+			return "", protocol.ErrInvalidRequest
+		}
+	}
+	return strings.Join(items, ","), protocol.ErrNone
+}
+
+func indexOf(items []string, v string) int {
+	for i, item := range items {
+		if item == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseTopicConfigValue parses value into the type of the entry's default.
+func parseTopicConfigValue(e structs.TopicConfigEntry, value string) (interface{}, error) {
+	var v interface{}
+	switch e.Default.(type) {
+	case int:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		v = int(n)
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		v = f
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		v = b
+	default:
+		v = value
+	}
+	if len(e.ValidValues) == 0 {
+		return v, nil
+	}
+	for _, valid := range e.ValidValues {
+		if v == valid {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid value %q for %s", value, e.Name)
+}
+
+// logOptions returns the options for the partition's commit log from its topic's config.
+func (b *Broker) logOptions(topic *structs.Topic, partition int32) commitlog.Options {
+	name := partitionDirName(topic.Topic, partition)
+	dir := b.partitionLogDir(name)
+	opts := commitlog.Options{
+		Path:            filepath.Join(dir, name),
+		MaxSegmentBytes: 1024,
+		MaxLogBytes:     -1,
+		CleanupPolicy:   commitlog.CleanupPolicy(fmt.Sprint(topic.Config.GetValue("cleanup.policy"))),
+		Metrics:         b.metrics.commitLogMetrics(dir),
+	}
+	opts.KeyBloomFilters = b.config.KeyBloomFilters && opts.CleanupPolicy == commitlog.CompactCleanupPolicy
+	// TODO: use the segment.bytes default too, for now only a value that's been set replaces
+	// the small segments we've always used
+	if n, ok := configInt(topic.Config.Get("segment.bytes").Value); ok {
+		opts.MaxSegmentBytes = n
+	}
+	if n, ok := configInt(topic.Config.GetValue("retention.bytes")); ok {
+		opts.MaxLogBytes = n
+	}
+	// flush.ms defaults to never, which is leaving flushing to the OS
+	if n, ok := configInt(topic.Config.Get("flush.ms").Value); ok && n > 0 && n < int64(math.MaxInt64/time.Millisecond) {
+		opts.FlushInterval = time.Duration(n) * time.Millisecond
+	}
+	return opts
+}
+
+// observeConfig is called by the FSM with configs as they're applied, it acts on changes to
+// this broker's dynamic configs.
+func (b *Broker) observeConfig(config *structs.Config) {
+	if config.ResourceType != structs.BrokerConfigResource {
+		return
+	}
+	if config.Resource != "" && config.Resource != strconv.Itoa(int(b.config.ID)) {
+		return
+	}
+	b.loadTraceConfig()
+	b.loadQuotaConfig()
+	b.observeLogDirsRebalance(config)
+}
+
+// configurableLog is implemented by commit logs that can have their options changed while open.
+type configurableLog interface {
+	Configure(commitlog.Options)
+}
+
+// configureReplicas applies the topic's config to the logs of its replicas on this broker. It's
+// called by the FSM as topics are applied so it doesn't take the broker's lock, which is held
+// while dialing other brokers and would stall raft, the logs guard their own options.
+func (b *Broker) configureReplicas(topic *structs.Topic) {
+	for id := range topic.Partitions {
+		replica, err := b.replicaLookup.Replica(topic.Topic, id)
+		if err != nil {
+			continue
+		}
+		if l, ok := replica.Log.(configurableLog); ok {
+			l.Configure(b.logOptions(topic, id))
+		}
+	}
+}
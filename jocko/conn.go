@@ -93,6 +93,48 @@ func (c *Conn) LeaderAndISR(req *protocol.LeaderAndISRRequest) (*protocol.Leader
 	return &resp, nil
 }
 
+// CreatePartitions sends a create partitions request and returns the response.
+func (c *Conn) CreatePartitions(req *protocol.CreatePartitionsRequest) (*protocol.CreatePartitionsResponse, error) {
+	var resp protocol.CreatePartitionsResponse
+	err := c.writeOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteTopics sends a delete topics request and returns the response.
+func (c *Conn) DeleteTopics(req *protocol.DeleteTopicsRequest) (*protocol.DeleteTopicsResponse, error) {
+	var resp protocol.DeleteTopicsResponse
+	err := c.writeOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// StopReplica sends a stop replica request and returns the response.
+func (c *Conn) StopReplica(req *protocol.StopReplicaRequest) (*protocol.StopReplicaResponse, error) {
+	var resp protocol.StopReplicaResponse
+	err := c.writeOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // CreateTopics sends a create topics request and returns the response.
 func (c *Conn) CreateTopics(req *protocol.CreateTopicRequests) (*protocol.CreateTopicsResponse, error) {
 	var resp protocol.CreateTopicsResponse
@@ -107,6 +149,104 @@ func (c *Conn) CreateTopics(req *protocol.CreateTopicRequests) (*protocol.Create
 	return &resp, nil
 }
 
+// DeleteRecords sends a delete records request and returns the response.
+func (c *Conn) DeleteRecords(req *protocol.DeleteRecordsRequest) (*protocol.DeleteRecordsResponse, error) {
+	var resp protocol.DeleteRecordsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OffsetForLeaderEpoch sends an offset for leader epoch request and returns the response.
+func (c *Conn) OffsetForLeaderEpoch(req *protocol.OffsetForLeaderEpochRequest) (*protocol.OffsetForLeaderEpochResponse, error) {
+	var resp protocol.OffsetForLeaderEpochResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ElectLeaders sends an elect leaders request and returns the response.
+// AlterPartition sends an alter partition request and returns the response.
+func (c *Conn) AlterPartition(req *protocol.AlterPartitionRequest) (*protocol.AlterPartitionResponse, error) {
+	var resp protocol.AlterPartitionResponse
+	err := c.writeOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// LeaderLease sends a leader lease request and returns the response.
+func (c *Conn) LeaderLease(req *protocol.LeaderLeaseRequest) (*protocol.LeaderLeaseResponse, error) {
+	var resp protocol.LeaderLeaseResponse
+	err := c.writeOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ControlledShutdown sends a controlled shutdown request and returns the response.
+func (c *Conn) ControlledShutdown(req *protocol.ControlledShutdownRequest) (*protocol.ControlledShutdownResponse, error) {
+	var resp protocol.ControlledShutdownResponse
+	err := c.writeOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Conn) ElectLeaders(req *protocol.ElectLeadersRequest) (*protocol.ElectLeadersResponse, error) {
+	var resp protocol.ElectLeadersResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Offsets sends an offsets request and returns the response.
+func (c *Conn) Offsets(req *protocol.OffsetsRequest) (*protocol.OffsetsResponse, error) {
+	var resp protocol.OffsetsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Fetch sends a fetch request and returns the response.
 func (c *Conn) Fetch(req *protocol.FetchRequest) (*protocol.FetchResponse, error) {
 	var resp protocol.FetchResponse
@@ -121,6 +261,468 @@ func (c *Conn) Fetch(req *protocol.FetchRequest) (*protocol.FetchResponse, error
 	return &resp, nil
 }
 
+// FetchSegment sends a fetch segment request and returns the response.
+func (c *Conn) FetchSegment(req *protocol.FetchSegmentRequest) (*protocol.FetchSegmentResponse, error) {
+	var resp protocol.FetchSegmentResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AlterReplicaLogDirs sends an alter replica log dirs request and returns the response.
+func (c *Conn) AlterReplicaLogDirs(req *protocol.AlterReplicaLogDirsRequest) (*protocol.AlterReplicaLogDirsResponse, error) {
+	var resp protocol.AlterReplicaLogDirsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DescribeLogDirs sends a describe log dirs request and returns the response.
+func (c *Conn) DescribeLogDirs(req *protocol.DescribeLogDirsRequest) (*protocol.DescribeLogDirsResponse, error) {
+	var resp protocol.DescribeLogDirsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// APIVersions sends an api versions request and returns the response.
+func (c *Conn) APIVersions(req *protocol.APIVersionsRequest) (*protocol.APIVersionsResponse, error) {
+	var resp protocol.APIVersionsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SaslHandshake sends a SASL handshake request and returns the response.
+func (c *Conn) SaslHandshake(req *protocol.SaslHandshakeRequest) (*protocol.SaslHandshakeResponse, error) {
+	var resp protocol.SaslHandshakeResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SaslAuthenticate sends a SASL authenticate request and returns the response.
+func (c *Conn) SaslAuthenticate(req *protocol.SaslAuthenticateRequest) (*protocol.SaslAuthenticateResponse, error) {
+	var resp protocol.SaslAuthenticateResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OffsetCommit sends an offset commit request and returns the response.
+func (c *Conn) OffsetCommit(req *protocol.OffsetCommitRequest) (*protocol.OffsetCommitResponse, error) {
+	var resp protocol.OffsetCommitResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OffsetFetch sends an offset fetch request and returns the response.
+func (c *Conn) OffsetFetch(req *protocol.OffsetFetchRequest) (*protocol.OffsetFetchResponse, error) {
+	var resp protocol.OffsetFetchResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// FindCoordinator sends a find coordinator request and returns the response.
+func (c *Conn) FindCoordinator(req *protocol.FindCoordinatorRequest) (*protocol.FindCoordinatorResponse, error) {
+	var resp protocol.FindCoordinatorResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DescribeGroups sends a describe groups request and returns the response.
+func (c *Conn) DescribeGroups(req *protocol.DescribeGroupsRequest) (*protocol.DescribeGroupsResponse, error) {
+	var resp protocol.DescribeGroupsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteGroups sends a delete groups request and returns the response.
+func (c *Conn) DeleteGroups(req *protocol.DeleteGroupsRequest) (*protocol.DeleteGroupsResponse, error) {
+	var resp protocol.DeleteGroupsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OffsetDelete sends an offset delete request and returns the response.
+func (c *Conn) OffsetDelete(req *protocol.OffsetDeleteRequest) (*protocol.OffsetDeleteResponse, error) {
+	var resp protocol.OffsetDeleteResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// InitProducerID sends an init producer id request and returns the response.
+func (c *Conn) InitProducerID(req *protocol.InitProducerIDRequest) (*protocol.InitProducerIDResponse, error) {
+	var resp protocol.InitProducerIDResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Produce sends a produce request and returns the response.
+func (c *Conn) Produce(req *protocol.ProduceRequest) (*protocol.ProduceResponse, error) {
+	var resp protocol.ProduceResponse
+	err := c.writeOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AddPartitionsToTxn sends an add partitions to txn request and returns the response.
+func (c *Conn) AddPartitionsToTxn(req *protocol.AddPartitionsToTxnRequest) (*protocol.AddPartitionsToTxnResponse, error) {
+	var resp protocol.AddPartitionsToTxnResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AddOffsetsToTxn sends an add offsets to txn request and returns the response.
+func (c *Conn) AddOffsetsToTxn(req *protocol.AddOffsetsToTxnRequest) (*protocol.AddOffsetsToTxnResponse, error) {
+	var resp protocol.AddOffsetsToTxnResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// EndTxn sends an end txn request and returns the response.
+func (c *Conn) EndTxn(req *protocol.EndTxnRequest) (*protocol.EndTxnResponse, error) {
+	var resp protocol.EndTxnResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WriteTxnMarkers sends a write txn markers request and returns the response.
+func (c *Conn) WriteTxnMarkers(req *protocol.WriteTxnMarkersRequest) (*protocol.WriteTxnMarkersResponse, error) {
+	var resp protocol.WriteTxnMarkersResponse
+	err := c.writeOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// TxnOffsetCommit sends a txn offset commit request and returns the response.
+func (c *Conn) TxnOffsetCommit(req *protocol.TxnOffsetCommitRequest) (*protocol.TxnOffsetCommitResponse, error) {
+	var resp protocol.TxnOffsetCommitResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateAcls sends a create acls request and returns the response.
+func (c *Conn) CreateAcls(req *protocol.CreateAclsRequest) (*protocol.CreateAclsResponse, error) {
+	var resp protocol.CreateAclsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteAcls sends a delete acls request and returns the response.
+func (c *Conn) DeleteAcls(req *protocol.DeleteAclsRequest) (*protocol.DeleteAclsResponse, error) {
+	var resp protocol.DeleteAclsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DescribeAcls sends a describe acls request and returns the response.
+func (c *Conn) DescribeAcls(req *protocol.DescribeAclsRequest) (*protocol.DescribeAclsResponse, error) {
+	var resp protocol.DescribeAclsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateDelegationToken sends a create delegation token request and returns the response.
+func (c *Conn) CreateDelegationToken(req *protocol.CreateDelegationTokenRequest) (*protocol.CreateDelegationTokenResponse, error) {
+	var resp protocol.CreateDelegationTokenResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RenewDelegationToken sends a renew delegation token request and returns the response.
+func (c *Conn) RenewDelegationToken(req *protocol.RenewDelegationTokenRequest) (*protocol.RenewDelegationTokenResponse, error) {
+	var resp protocol.RenewDelegationTokenResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExpireDelegationToken sends a expire delegation token request and returns the response.
+func (c *Conn) ExpireDelegationToken(req *protocol.ExpireDelegationTokenRequest) (*protocol.ExpireDelegationTokenResponse, error) {
+	var resp protocol.ExpireDelegationTokenResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DescribeDelegationToken sends a describe delegation token request and returns the response.
+func (c *Conn) DescribeDelegationToken(req *protocol.DescribeDelegationTokenRequest) (*protocol.DescribeDelegationTokenResponse, error) {
+	var resp protocol.DescribeDelegationTokenResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Metadata sends a metadata request and returns the response.
+func (c *Conn) Metadata(req *protocol.MetadataRequest) (*protocol.MetadataResponse, error) {
+	var resp protocol.MetadataResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListGroups sends a list groups request and returns the response.
+func (c *Conn) ListGroups(req *protocol.ListGroupsRequest) (*protocol.ListGroupsResponse, error) {
+	var resp protocol.ListGroupsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListTransactions sends a list transactions request and returns the response.
+func (c *Conn) ListTransactions(req *protocol.ListTransactionsRequest) (*protocol.ListTransactionsResponse, error) {
+	var resp protocol.ListTransactionsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DescribeTransactions sends a describe transactions request and returns the response.
+func (c *Conn) DescribeTransactions(req *protocol.DescribeTransactionsRequest) (*protocol.DescribeTransactionsResponse, error) {
+	var resp protocol.DescribeTransactionsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AlterPartitionReassignments sends an alter partition reassignments request and returns the response.
+func (c *Conn) AlterPartitionReassignments(req *protocol.AlterPartitionReassignmentsRequest) (*protocol.AlterPartitionReassignmentsResponse, error) {
+	var resp protocol.AlterPartitionReassignmentsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListPartitionReassignments sends a list partition reassignments request and returns the response.
+func (c *Conn) ListPartitionReassignments(req *protocol.ListPartitionReassignmentsRequest) (*protocol.ListPartitionReassignmentsResponse, error) {
+	var resp protocol.ListPartitionReassignmentsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// IncrementalAlterConfigs sends an incremental alter configs request and returns the response.
+func (c *Conn) IncrementalAlterConfigs(req *protocol.IncrementalAlterConfigsRequest) (*protocol.IncrementalAlterConfigsResponse, error) {
+	var resp protocol.IncrementalAlterConfigsResponse
+	err := c.readOperation(func(deadline time.Time, id int32) error {
+		return c.writeRequest(req)
+	}, func(deadline time.Time, size int) error {
+		return c.readResponse(&resp, size, req.Version())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // AlterConfigs sends an alter configs request and returns the response.
 func (c *Conn) AlterConfigs(req *protocol.AlterConfigsRequest) (*protocol.AlterConfigsResponse, error) {
 	var resp protocol.AlterConfigsResponse
@@ -154,7 +756,10 @@ func (c *Conn) readResponse(resp protocol.VersionedDecoder, size int, version in
 	if err != nil {
 		return err
 	}
-	err = protocol.Decode(b, resp, version)
+	d := protocol.NewDecoder(b)
+	if err = protocol.DecodeResponseHeader(d, resp, version); err == nil {
+		err = resp.Decode(d, version)
+	}
 	c.rbuf.Discard(size)
 	return err
 }
@@ -260,7 +865,8 @@ func (c *Conn) writeDeadline() time.Time {
 func (c *Conn) peekResponseSizeAndID() (int32, int32, error) {
 	b, err := c.rbuf.Peek(8)
 	if err != nil {
-		return 0, 0, nil
+		// the broker closed the conn, like after failed authentication, so stop waiting
+		return 0, 0, err
 	}
 	size, id := protocol.MakeInt32(b[:4]), protocol.MakeInt32(b[4:])
 	return size, id, nil
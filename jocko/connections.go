@@ -0,0 +1,224 @@
+package jocko
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+var connectionKey = contextKey("connection key")
+
+// connection tracks the stats of a client's conn to the server.
+type connection struct {
+	id     uint64
+	conn   net.Conn
+	opened time.Time
+
+	mu           sync.Mutex
+	clientID     string
+	principal    string
+	requests     map[int16]uint64
+	bytesIn      int64
+	bytesOut     int64
+	lastActivity time.Time
+	// interBroker is whether the conn's from another broker.
+	interBroker bool
+	// pending is how many requests have been read that haven't been responded to.
+	pending int
+	// draining is whether the conn's closed once it's responded to its pending requests.
+	draining bool
+}
+
+// connectionStats are a connection's stats as listed by the admin API.
+type connectionStats struct {
+	ID           uint64            `json:"id"`
+	RemoteAddr   string            `json:"remote_addr"`
+	ClientID     string            `json:"client_id"`
+	Principal    string            `json:"principal,omitempty"`
+	Requests     map[string]uint64 `json:"requests"`
+	BytesIn      int64             `json:"bytes_in"`
+	BytesOut     int64             `json:"bytes_out"`
+	ConnectedAt  time.Time         `json:"connected_at"`
+	LastActivity time.Time         `json:"last_activity"`
+	Age          string            `json:"age"`
+	InterBroker  bool              `json:"inter_broker"`
+	Draining     bool              `json:"draining,omitempty"`
+}
+
+// request records the client sent a request of the given size.
+func (c *connection) request(header *protocol.RequestHeader, size int, principal string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clientID = header.ClientID
+	c.principal = principal
+	c.requests[header.APIKey]++
+	c.bytesIn += int64(size)
+	c.lastActivity = time.Now()
+	c.pending++
+}
+
+// response records a response of the given size was written to the client, closing the conn if
+// it's being drained and this was its last pending response.
+func (c *connection) response(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bytesOut += size
+	c.lastActivity = time.Now()
+	if c.pending > 0 {
+		c.pending--
+	}
+	if c.draining && c.pending == 0 {
+		c.conn.Close()
+	}
+}
+
+// setInterBroker records the conn turned out to be from another broker.
+func (c *connection) setInterBroker() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interBroker = true
+}
+
+func (c *connection) isInterBroker() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.interBroker
+}
+
+// closeWhenIdle closes the conn once it's responded to the requests it's read, right away if
+// there aren't any.
+func (c *connection) closeWhenIdle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+	if c.pending == 0 {
+		c.conn.Close()
+	}
+}
+
+func (c *connection) stats(now time.Time) connectionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	requests := make(map[string]uint64, len(c.requests))
+	for key, n := range c.requests {
+		requests[protocol.APIName(key)] = n
+	}
+	return connectionStats{
+		ID:           c.id,
+		RemoteAddr:   c.conn.RemoteAddr().String(),
+		ClientID:     c.clientID,
+		Principal:    c.principal,
+		Requests:     requests,
+		BytesIn:      c.bytesIn,
+		BytesOut:     c.bytesOut,
+		ConnectedAt:  c.opened,
+		LastActivity: c.lastActivity,
+		Age:          now.Sub(c.opened).Round(time.Second).String(),
+		InterBroker:  c.interBroker,
+		Draining:     c.draining,
+	}
+}
+
+// connections are the server's open conns.
+type connections struct {
+	mu    sync.Mutex
+	next  uint64
+	conns map[uint64]*connection
+}
+
+func newConnections() *connections {
+	return &connections{conns: make(map[uint64]*connection)}
+}
+
+func (cs *connections) add(conn net.Conn) *connection {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.next++
+	now := time.Now()
+	c := &connection{
+		id:           cs.next,
+		conn:         conn,
+		opened:       now,
+		requests:     make(map[int16]uint64),
+		lastActivity: now,
+	}
+	cs.conns[c.id] = c
+	return c
+}
+
+func (cs *connections) remove(c *connection) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.conns, c.id)
+}
+
+func (cs *connections) len() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return len(cs.conns)
+}
+
+// list returns the stats of the open conns, oldest first.
+func (cs *connections) list() []connectionStats {
+	cs.mu.Lock()
+	conns := make([]*connection, 0, len(cs.conns))
+	for _, c := range cs.conns {
+		conns = append(conns, c)
+	}
+	cs.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	now := time.Now()
+	stats := make([]connectionStats, len(conns))
+	for i, c := range conns {
+		stats[i] = c.stats(now)
+	}
+	return stats
+}
+
+// kill closes the conn with the id, returning false if there isn't one. The conn's request
+// loop sees it's closed and removes it.
+func (cs *connections) kill(id uint64) bool {
+	cs.mu.Lock()
+	c, ok := cs.conns[id]
+	cs.mu.Unlock()
+	if !ok {
+		return false
+	}
+	c.conn.Close()
+	return true
+}
+
+// drain closes the client conns, leaving those from other brokers open. The conns are closed
+// spread evenly over the period so their clients don't all reconnect at once, each once it's
+// responded to the requests it's read, and those still busy at the end of the period are
+// closed then. It returns how many conns are being drained.
+func (cs *connections) drain(period time.Duration) int {
+	cs.mu.Lock()
+	var conns []*connection
+	for _, c := range cs.conns {
+		if !c.isInterBroker() {
+			conns = append(conns, c)
+		}
+	}
+	cs.mu.Unlock()
+	if len(conns) == 0 {
+		return 0
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	interval := period / time.Duration(len(conns))
+	go func() {
+		start := time.Now()
+		for i, c := range conns {
+			time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+			c.closeWhenIdle()
+		}
+		time.Sleep(time.Until(start.Add(period)))
+		for _, c := range conns {
+			c.conn.Close()
+		}
+	}()
+	return len(conns)
+}
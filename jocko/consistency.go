@@ -0,0 +1,171 @@
+package jocko
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/log"
+)
+
+// consistencyReport is the result of checking the logs in the broker's log dirs against the
+// replicas the FSM assigns the broker.
+type consistencyReport struct {
+	CheckedAt time.Time `json:"checked_at"`
+	// Orphaned are logs of partitions the broker isn't assigned a replica of, like ones left
+	// behind by a broker that stopped while deleting a topic.
+	Orphaned []orphanedLog `json:"orphaned"`
+	// Missing are replicas the broker's assigned that have no log in any of its log dirs.
+	Missing []missingReplica `json:"missing"`
+}
+
+type orphanedLog struct {
+	Path      string `json:"path"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Removed is whether the log was removed by the check.
+	Removed bool `json:"removed"`
+}
+
+type missingReplica struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+}
+
+// parsePartitionDirName returns the topic and partition of the partition directory with the
+// given name, or false if it isn't one.
+func parsePartitionDirName(name string) (string, int32, bool) {
+	i := strings.LastIndex(name, "-")
+	if i <= 0 {
+		return "", 0, false
+	}
+	partition, err := strconv.ParseInt(name[i+1:], 10, 32)
+	if err != nil || partition < 0 {
+		return "", 0, false
+	}
+	return name[:i], int32(partition), true
+}
+
+// checkConsistency compares the partition directories in the broker's log dirs with the replicas
+// the FSM assigns the broker. Directories of deleted logs and of logs being moved aren't
+// partitions' logs yet, or anymore, so they're skipped.
+func (b *Broker) checkConsistency() (*consistencyReport, error) {
+	_, topics, err := b.fsm.State().GetTopics()
+	if err != nil {
+		return nil, err
+	}
+	assigned := make(map[topicPartition]bool)
+	for _, t := range topics {
+		for id, replicas := range t.Partitions {
+			if containsInt32(replicas, b.config.ID) {
+				assigned[topicPartition{topic: t.Topic, partition: id}] = true
+			}
+		}
+	}
+
+	report := &consistencyReport{CheckedAt: time.Now()}
+	found := make(map[topicPartition]bool)
+	for _, dir := range b.logDirs() {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			name := f.Name()
+			if !f.IsDir() || strings.HasSuffix(name, deletedLogSuffix) || strings.HasSuffix(name, commitlog.FuturePath("")) {
+				continue
+			}
+			topic, partition, ok := parsePartitionDirName(name)
+			if !ok {
+				continue
+			}
+			key := topicPartition{topic: topic, partition: partition}
+			found[key] = true
+			if !assigned[key] {
+				report.Orphaned = append(report.Orphaned, orphanedLog{Path: filepath.Join(dir, name), Topic: topic, Partition: partition})
+			}
+		}
+	}
+	for key := range assigned {
+		if !found[key] {
+			report.Missing = append(report.Missing, missingReplica{Topic: key.topic, Partition: key.partition})
+		}
+	}
+	sort.Slice(report.Orphaned, func(i, j int) bool { return report.Orphaned[i].Path < report.Orphaned[j].Path })
+	sort.Slice(report.Missing, func(i, j int) bool {
+		if report.Missing[i].Topic != report.Missing[j].Topic {
+			return report.Missing[i].Topic < report.Missing[j].Topic
+		}
+		return report.Missing[i].Partition < report.Missing[j].Partition
+	})
+
+	if b.metrics != nil {
+		b.metrics.OrphanedLogs.Set(float64(len(report.Orphaned)))
+		b.metrics.MissingReplicas.Set(float64(len(report.Missing)))
+	}
+	return report, nil
+}
+
+// removeOrphanedLog removes the orphaned log like a deleted replica's, unless the broker has a
+// replica of the partition open, in which case the FSM has caught up since the check.
+func (b *Broker) removeOrphanedLog(o *orphanedLog) {
+	if replica, err := b.replicaLookup.Replica(o.Topic, o.Partition); err == nil && replica != nil {
+		return
+	}
+	deleted := o.Path + deletedLogSuffix
+	if err := os.RemoveAll(deleted); err != nil {
+		b.logger.Error("failed to delete orphaned log", log.String("path", deleted), log.Error("error", err))
+		return
+	}
+	if err := os.Rename(o.Path, deleted); err != nil {
+		b.logger.Error("failed to rename orphaned log", log.String("path", o.Path), log.Error("error", err))
+		return
+	}
+	o.Removed = true
+	b.logger.Info("removed orphaned log", log.String("path", o.Path))
+	go b.removeDeletedLog(deleted)
+}
+
+// checkConsistencyPeriodically checks the log dirs every interval, logging what it finds. With
+// fix set it removes orphaned logs, but only once they've been orphaned for a whole interval so
+// a broker whose FSM is still catching up doesn't remove logs it's about to be assigned.
+func (b *Broker) checkConsistencyPeriodically(interval time.Duration, fix bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var orphaned map[string]bool
+	for {
+		select {
+		case <-b.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+		report, err := b.checkConsistency()
+		if err != nil {
+			b.logger.Error("failed to check log dirs consistency", log.Error("error", err))
+			continue
+		}
+		last := orphaned
+		orphaned = make(map[string]bool, len(report.Orphaned))
+		for i := range report.Orphaned {
+			o := &report.Orphaned[i]
+			if fix && last[o.Path] {
+				b.removeOrphanedLog(o)
+			}
+			if !o.Removed {
+				orphaned[o.Path] = true
+				b.logger.Info("found orphaned log", log.String("path", o.Path))
+			}
+		}
+		for _, m := range report.Missing {
+			b.logger.Info("found replica with missing log", log.String("topic", m.Topic), log.Int32("partition", m.Partition))
+		}
+	}
+}
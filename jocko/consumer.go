@@ -0,0 +1,405 @@
+package jocko
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// consumeFetchMaxBytes is the most read from a partition at a time.
+const consumeFetchMaxBytes = 1024 * 1024
+
+// errConsumeCompressed is returned consuming a compressed message set of an older client, or a
+// batch compressed with a codec that isn't supported, since its records can't be read.
+var errConsumeCompressed = errors.New("compressed message sets and batches with unsupported codecs can't be consumed")
+
+type consumePositionKind int8
+
+const (
+	consumePositionUnset consumePositionKind = iota
+	consumePositionOffset
+	consumePositionEarliest
+	consumePositionLatest
+	consumePositionTime
+)
+
+// ConsumePosition is a position in a partition to consume from or until: an offset, the
+// partition's earliest or latest offset, or the first offset with a timestamp at or after a time.
+// The zero value is unset.
+type ConsumePosition struct {
+	kind   consumePositionKind
+	offset int64
+	// timestamp is in milliseconds.
+	timestamp int64
+}
+
+// AtOffset returns the position of the offset.
+func AtOffset(offset int64) ConsumePosition {
+	return ConsumePosition{kind: consumePositionOffset, offset: offset}
+}
+
+// AtEarliest returns the position of a partition's earliest offset.
+func AtEarliest() ConsumePosition {
+	return ConsumePosition{kind: consumePositionEarliest}
+}
+
+// AtLatest returns the position of a partition's latest offset, when consuming starts.
+func AtLatest() ConsumePosition {
+	return ConsumePosition{kind: consumePositionLatest}
+}
+
+// AtTime returns the position of a partition's first offset with a timestamp at or after the
+// time.
+func AtTime(t time.Time) ConsumePosition {
+	return ConsumePosition{kind: consumePositionTime, timestamp: t.UnixNano() / int64(time.Millisecond)}
+}
+
+// IsSet returns whether the position is set.
+func (p ConsumePosition) IsSet() bool {
+	return p.kind != consumePositionUnset
+}
+
+func (p ConsumePosition) String() string {
+	switch p.kind {
+	case consumePositionOffset:
+		return strconv.FormatInt(p.offset, 10)
+	case consumePositionEarliest:
+		return "earliest"
+	case consumePositionLatest:
+		return "latest"
+	case consumePositionTime:
+		return time.Unix(0, p.timestamp*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+// ParseConsumePosition parses a position: earliest, latest or end, an offset, or an RFC 3339
+// time.
+func ParseConsumePosition(s string) (ConsumePosition, error) {
+	switch s {
+	case "earliest", "beginning":
+		return AtEarliest(), nil
+	case "latest", "end":
+		return AtLatest(), nil
+	}
+	if offset, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if offset < 0 {
+			return ConsumePosition{}, fmt.Errorf("offset %d is negative", offset)
+		}
+		return AtOffset(offset), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return ConsumePosition{}, fmt.Errorf("%q isn't earliest, latest, an offset or an RFC 3339 time", s)
+	}
+	return AtTime(t), nil
+}
+
+// ParsePartitionPositions parses positions by partition, like 0=100,1=latest, or one position
+// for every partition. The position for every partition is unset if there isn't one.
+func ParsePartitionPositions(s string) (ConsumePosition, map[int32]ConsumePosition, error) {
+	if !strings.Contains(s, "=") {
+		p, err := ParseConsumePosition(s)
+		return p, nil, err
+	}
+	positions := make(map[int32]ConsumePosition)
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return ConsumePosition{}, nil, fmt.Errorf("%q isn't a partition=position pair", kv)
+		}
+		partition, err := strconv.ParseInt(kv[:i], 10, 32)
+		if err != nil {
+			return ConsumePosition{}, nil, fmt.Errorf("%q isn't a partition", kv[:i])
+		}
+		p, err := ParseConsumePosition(kv[i+1:])
+		if err != nil {
+			return ConsumePosition{}, nil, err
+		}
+		positions[int32(partition)] = p
+	}
+	return ConsumePosition{}, positions, nil
+}
+
+// ConsumerConfig configures a Consumer.
+type ConsumerConfig struct {
+	// Brokers are the bootstrap addresses of the cluster to consume from.
+	Brokers []string
+	Topic   string
+	// Partitions are the topic's partitions to consume, all of them if there are none.
+	Partitions []int32
+	// From is where consuming the partitions starts, their earliest offsets if it's unset.
+	From ConsumePosition
+	// Until is where consuming the partitions ends, before the record at the position. The
+	// partitions are consumed until the context's done if it's unset.
+	Until ConsumePosition
+	// PartitionUntil is where consuming the partitions in it ends, instead of Until.
+	PartitionUntil map[int32]ConsumePosition
+	// Timeout bounds each request to the cluster.
+	Timeout time.Duration
+}
+
+// ConsumedRecord is a record read by a Consumer.
+type ConsumedRecord struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	// Timestamp is zero for the messages of older clients that didn't have timestamps.
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+	Headers   []protocol.RecordHeader
+}
+
+// Consumer reads a topic's partitions, read committed, from a position in each until a position
+// in each, so a batch backfill or test reads the same records each time it's run. The positions
+// are resolved to offsets when consuming starts, so records appended since aren't consumed past
+// latest and a time. It doesn't join a group or commit offsets.
+type Consumer struct {
+	config  ConsumerConfig
+	cluster *clusterClient
+}
+
+// NewConsumer returns a Consumer with the config.
+func NewConsumer(config ConsumerConfig, dialer *Dialer) *Consumer {
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	return &Consumer{
+		config:  config,
+		cluster: newClusterClient(config.Brokers, dialer, config.Timeout),
+	}
+}
+
+// consumedPartition is the state of consuming a partition.
+type consumedPartition struct {
+	tp   topicPartition
+	next int64
+	// end is the offset consuming ends before, or -1 if there isn't one.
+	end int64
+	// untilTimestamp ends consuming at the first record with a timestamp at or after it, or is -1
+	// if it doesn't.
+	untilTimestamp int64
+	done           bool
+}
+
+// reached returns whether the record is at or past where consuming the partition ends.
+func (p *consumedPartition) reached(offset, timestamp int64) bool {
+	return (p.end >= 0 && offset >= p.end) || (p.untilTimestamp >= 0 && timestamp >= p.untilTimestamp)
+}
+
+// Consume calls f with the partitions' records in order, a fetch from each partition in turn,
+// until each is consumed to where it ends, f returns an error, or the context's done.
+func (c *Consumer) Consume(ctx context.Context, f func(ConsumedRecord) error) error {
+	partitions := c.config.Partitions
+	if len(partitions) == 0 {
+		md, err := topicMetadata(c.cluster, c.config.Topic)
+		if err != nil {
+			return err
+		}
+		for _, p := range md.PartitionMetadata {
+			partitions = append(partitions, p.PartitionID)
+		}
+	}
+	var consumed []*consumedPartition
+	for _, partition := range partitions {
+		p := &consumedPartition{tp: topicPartition{topic: c.config.Topic, partition: partition}}
+		if err := c.resolve(p); err != nil {
+			return fmt.Errorf("resolving %s-%d positions: %v", p.tp.topic, p.tp.partition, err)
+		}
+		consumed = append(consumed, p)
+	}
+	for {
+		remaining := 0
+		for _, p := range consumed {
+			if p.done {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := c.consumePartition(p, f); err != nil {
+				return err
+			}
+			if !p.done {
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			return nil
+		}
+	}
+}
+
+// resolve sets where consuming the partition starts and ends.
+func (c *Consumer) resolve(p *consumedPartition) error {
+	var err error
+	switch from := c.config.From; from.kind {
+	case consumePositionOffset:
+		p.next = from.offset
+	case consumePositionLatest:
+		p.next, err = c.cluster.offset(p.tp, -1)
+	case consumePositionTime:
+		p.next, err = c.cluster.offset(p.tp, from.timestamp)
+		// there aren't records from the time on yet
+		if err == nil && p.next < 0 {
+			p.next, err = c.cluster.offset(p.tp, -1)
+		}
+	default:
+		p.next, err = c.cluster.offset(p.tp, -2)
+	}
+	if err != nil {
+		return err
+	}
+
+	until, ok := c.config.PartitionUntil[p.tp.partition]
+	if !ok {
+		until = c.config.Until
+	}
+	p.end, p.untilTimestamp = -1, -1
+	switch until.kind {
+	case consumePositionOffset:
+		p.end = until.offset
+	case consumePositionEarliest:
+		p.end, err = c.cluster.offset(p.tp, -2)
+	case consumePositionLatest:
+		p.end, err = c.cluster.offset(p.tp, -1)
+	case consumePositionTime:
+		p.end, err = c.cluster.offset(p.tp, until.timestamp)
+		if err != nil || p.end >= 0 {
+			break
+		}
+		// there aren't records from the time on yet: if the time's passed, the records up to the
+		// latest offset are the ones before it, otherwise they're consumed until one's from it
+		if until.timestamp <= time.Now().UnixNano()/int64(time.Millisecond) {
+			p.end, err = c.cluster.offset(p.tp, -1)
+		} else {
+			p.untilTimestamp = until.timestamp
+		}
+	}
+	return err
+}
+
+// consumePartition fetches the partition once, calling f with the records fetched before where
+// consuming it ends.
+func (c *Consumer) consumePartition(p *consumedPartition, f func(ConsumedRecord) error) error {
+	if p.end >= 0 && p.next >= p.end {
+		p.done = true
+		return nil
+	}
+	resp, err := c.cluster.fetch(p.tp, p.next, consumeFetchMaxBytes)
+	if err != nil {
+		return err
+	}
+	entries := protocol.RecordSetEntries(resp.RecordSet)
+	if len(entries) == 0 {
+		return nil
+	}
+	aborted := newAbortedTxns(resp.AbortedTransactions)
+	fetched := p.next
+	for _, e := range entries {
+		if e.Magic < 2 {
+			if err := c.consumeMessageSet(p, e, f); err != nil || p.done {
+				return err
+			}
+			continue
+		}
+		batches, err := protocol.ReadRecordBatches(e.Bytes)
+		if err != nil {
+			return err
+		}
+		batch := batches[0]
+		last := batch.BaseOffset + int64(batch.LastOffsetDelta)
+		if last < p.next {
+			continue
+		}
+		if !aborted.skip(batch) {
+			if batch.Compressed() && !protocol.SupportedCompression(batch.Compression()) {
+				return errConsumeCompressed
+			}
+			for _, r := range batch.Records {
+				offset := batch.BaseOffset + int64(r.OffsetDelta)
+				if offset < p.next {
+					continue
+				}
+				timestamp := batch.FirstTimestamp + r.TimestampDelta
+				if batch.LogAppendTime() {
+					timestamp = batch.MaxTimestamp
+				}
+				if p.reached(offset, timestamp) {
+					p.done = true
+					return nil
+				}
+				if err := f(ConsumedRecord{
+					Topic:     p.tp.topic,
+					Partition: p.tp.partition,
+					Offset:    offset,
+					Timestamp: time.Unix(0, timestamp*int64(time.Millisecond)),
+					Key:       r.Key,
+					Value:     r.Value,
+					Headers:   r.Headers,
+				}); err != nil {
+					return err
+				}
+				p.next = offset + 1
+			}
+		}
+		p.next = last + 1
+		if p.end >= 0 && p.next >= p.end {
+			p.done = true
+			return nil
+		}
+	}
+	if p.next == fetched {
+		return fmt.Errorf("fetch at offset %d returned nothing past it", p.next)
+	}
+	return nil
+}
+
+// consumeMessageSet calls f with the message of the entry of an older client.
+func (c *Consumer) consumeMessageSet(p *consumedPartition, e protocol.RecordSetEntry, f func(ConsumedRecord) error) error {
+	if e.Offset < p.next {
+		return nil
+	}
+	set := new(protocol.MessageSet)
+	if err := set.Decode(protocol.NewDecoder(e.Bytes)); err != nil {
+		return err
+	}
+	for _, m := range set.Messages {
+		// the compression codec's in the message's attributes
+		if m.Attributes&0x07 != 0 {
+			return errConsumeCompressed
+		}
+		timestamp := int64(-1)
+		if m.MagicByte > 0 {
+			timestamp = m.Timestamp.UnixNano() / int64(time.Millisecond)
+		}
+		if p.reached(e.Offset, timestamp) {
+			p.done = true
+			return nil
+		}
+		r := ConsumedRecord{Topic: p.tp.topic, Partition: p.tp.partition, Offset: e.Offset, Key: m.Key, Value: m.Value}
+		if timestamp >= 0 {
+			r.Timestamp = m.Timestamp
+		}
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	p.next = e.Offset + 1
+	if p.end >= 0 && p.next >= p.end {
+		p.done = true
+	}
+	return nil
+}
+
+// Close closes the conns to the cluster.
+func (c *Consumer) Close() error {
+	c.cluster.close()
+	return nil
+}
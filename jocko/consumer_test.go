@@ -0,0 +1,116 @@
+package jocko
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestConsumer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, teardown := startTestLeader(t, nil)
+	defer teardown()
+	b := s.broker()
+	reqCtx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     2,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	// each partition gets five records, a batch each, timestamped 1000 to 1004
+	for partition := int32(0); partition < 2; partition++ {
+		for i := 0; i < 5; i++ {
+			batch := &protocol.RecordBatch{
+				FirstTimestamp: int64(1000 + i),
+				MaxTimestamp:   int64(1000 + i),
+				ProducerID:     -1,
+				ProducerEpoch:  -1,
+				BaseSequence:   -1,
+				Records: []protocol.Record{{
+					Key:   []byte(fmt.Sprintf("key-%d", i)),
+					Value: []byte(fmt.Sprintf("value-%d-%d", partition, i)),
+				}},
+			}
+			resp := b.handleProduce(reqCtx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+				Topic: "the-topic",
+				Data:  []*protocol.Data{{Partition: partition, RecordSet: batch.Bytes()}},
+			}}})
+			require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+		}
+	}
+
+	// consume returns the offsets consumed of each partition
+	consume := func(cfg ConsumerConfig) map[int32][]int64 {
+		cfg.Brokers = []string{s.Addr().String()}
+		cfg.Topic = "the-topic"
+		consumer := NewConsumer(cfg, NewDialer("jocko-consume"))
+		defer consumer.Close()
+		consumed := make(map[int32][]int64)
+		err := consumer.Consume(ctx, func(r ConsumedRecord) error {
+			require.Equal(t, fmt.Sprintf("value-%d-%d", r.Partition, r.Offset), string(r.Value))
+			require.Equal(t, int64(1000+r.Offset), r.Timestamp.UnixNano()/int64(time.Millisecond))
+			consumed[r.Partition] = append(consumed[r.Partition], r.Offset)
+			return nil
+		})
+		require.NoError(t, err)
+		return consumed
+	}
+
+	require.Equal(t, map[int32][]int64{
+		0: {0, 1, 2, 3, 4},
+		1: {0, 1, 2, 3, 4},
+	}, consume(ConsumerConfig{Until: AtLatest()}))
+
+	// the end offset is excluded
+	require.Equal(t, map[int32][]int64{
+		0: {1, 2},
+		1: {1, 2},
+	}, consume(ConsumerConfig{From: AtOffset(1), Until: AtOffset(3)}))
+
+	// partitions can end at their own positions
+	require.Equal(t, map[int32][]int64{
+		0: {0},
+		1: {0, 1, 2, 3, 4},
+	}, consume(ConsumerConfig{Until: AtLatest(), PartitionUntil: map[int32]ConsumePosition{0: AtOffset(1)}}))
+
+	// times are resolved to the first offsets with timestamps at or after them
+	require.Equal(t, map[int32][]int64{
+		1: {2, 3},
+	}, consume(ConsumerConfig{
+		Partitions: []int32{1},
+		From:       AtTime(time.Unix(1, 2*int64(time.Millisecond))),
+		Until:      AtTime(time.Unix(1, 4*int64(time.Millisecond))),
+	}))
+
+	// a time in the past without records from it on ends at the latest offset
+	require.Equal(t, map[int32][]int64{
+		0: {3, 4},
+	}, consume(ConsumerConfig{Partitions: []int32{0}, From: AtOffset(3), Until: AtTime(time.Unix(2, 0))}))
+}
+
+func TestParsePartitionPositions(t *testing.T) {
+	until, partitions, err := ParsePartitionPositions("latest")
+	require.NoError(t, err)
+	require.Equal(t, AtLatest(), until)
+	require.Nil(t, partitions)
+
+	until, partitions, err = ParsePartitionPositions("0=100,1=end,2=2018-01-02T15:04:05Z")
+	require.NoError(t, err)
+	require.False(t, until.IsSet())
+	require.Equal(t, map[int32]ConsumePosition{
+		0: AtOffset(100),
+		1: AtLatest(),
+		2: AtTime(time.Date(2018, 1, 2, 15, 4, 5, 0, time.UTC)),
+	}, partitions)
+
+	_, _, err = ParsePartitionPositions("0=tomorrow")
+	require.Error(t, err)
+	_, _, err = ParsePartitionPositions("-1")
+	require.Error(t, err)
+}
@@ -0,0 +1,186 @@
+package jocko
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// controlledShutdownRequest is a ControlledShutdown request passed to the leader loop, which
+// sends the partitions it couldn't move on result.
+type controlledShutdownRequest struct {
+	broker int32
+	result chan controlledShutdownResult
+}
+
+// controlledShutdownResult is the partitions still led by the broker shutting down, err is set
+// if their leadership couldn't be moved at all.
+type controlledShutdownResult struct {
+	remaining []*protocol.ControlledShutdownPartition
+	err       protocol.Error
+}
+
+const (
+	// controlledShutdownTimeout bounds how long ControlledShutdown requests wait for the
+	// controller to move the partitions, the broker retries if it times out.
+	controlledShutdownTimeout = 30 * time.Second
+	// shuttingDownTimeout is how long a broker that asked to shut down is kept from leading
+	// partitions or rejoining isrs. A broker that's still alive after this is taken to have
+	// restarted before it was seen to fail.
+	shuttingDownTimeout = time.Minute
+)
+
+// controlledShutdown passes the broker's request to shut down to the leader loop and waits for
+// the partitions it couldn't move.
+func (b *Broker) controlledShutdown(id int32) ([]*protocol.ControlledShutdownPartition, protocol.Error) {
+	stopCh := b.leaderStop()
+	if stopCh == nil {
+		return nil, protocol.ErrNotController
+	}
+	r := &controlledShutdownRequest{
+		broker: id,
+		result: make(chan controlledShutdownResult, 1),
+	}
+	timer := time.NewTimer(controlledShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case b.controlledShutdownCh <- r:
+	case <-timer.C:
+		return nil, protocol.ErrRequestTimedOut
+	case <-stopCh:
+		return nil, protocol.ErrNotController
+	case <-b.shutdownCh:
+		return nil, protocol.ErrNotController
+	}
+	select {
+	case res := <-r.result:
+		return res.remaining, res.err
+	case <-timer.C:
+		return nil, protocol.ErrRequestTimedOut
+	case <-stopCh:
+		return nil, protocol.ErrNotController
+	case <-b.shutdownCh:
+		return nil, protocol.ErrNotController
+	}
+}
+
+// runControlledShutdown runs in the leader loop, moving the leadership of the partitions the
+// broker leads to their other passing in-sync replicas and taking it out of the isrs of the
+// partitions it follows, so producers and consumers move over before it exits rather than once
+// it's seen to fail. The broker's kept from leading partitions or rejoining isrs until it's
+// failed or left. Partitions without another replica to lead them are returned.
+func (b *Broker) runControlledShutdown(id int32) controlledShutdownResult {
+	if b.shuttingDown == nil {
+		b.shuttingDown = make(map[int32]time.Time)
+	}
+	b.shuttingDown[id] = time.Now()
+	passing, err := b.passingNodes()
+	if err != nil {
+		b.logger.Error("leader: failed to get passing nodes", log.Error("error", err))
+		return controlledShutdownResult{err: protocol.ErrUnknown}
+	}
+	_, partitions, err := b.fsm.State().GetPartitions()
+	if err != nil {
+		b.logger.Error("leader: failed to get partitions", log.Error("error", err))
+		return controlledShutdownResult{err: protocol.ErrUnknown}
+	}
+	var changed []structs.Partition
+	var remaining []*protocol.ControlledShutdownPartition
+	for _, p := range partitions {
+		if !containsInt32(p.ISR, id) {
+			continue
+		}
+		if p.Leader != id && len(p.ISR) == 1 {
+			continue
+		}
+		pp, ok := b.shrinkISR(p, id, passing)
+		if !ok {
+			remaining = append(remaining, &protocol.ControlledShutdownPartition{Topic: p.Topic, Partition: p.ID})
+			continue
+		}
+		changed = append(changed, pp)
+	}
+	if err := b.updatePartitions(changed); err != nil {
+		b.logger.Error("leader: failed to update partitions of broker shutting down", log.Error("error", err), log.Int32("node", id))
+		return controlledShutdownResult{err: protocol.ErrUnknown}
+	}
+	b.logger.Info("leader: moved partitions off broker shutting down", log.Int32("node", id), log.Int("partitions", len(changed)), log.Int("remaining", len(remaining)))
+	return controlledShutdownResult{remaining: remaining, err: protocol.ErrNone}
+}
+
+// isShuttingDown returns whether the broker has asked to shut down and hasn't failed, left or
+// restarted since. It's only used by the leader loop.
+func (b *Broker) isShuttingDown(id int32) bool {
+	since, ok := b.shuttingDown[id]
+	if !ok {
+		return false
+	}
+	if time.Since(since) > shuttingDownTimeout {
+		delete(b.shuttingDown, id)
+		return false
+	}
+	return true
+}
+
+// shutDownControlled asks the controller to move the leadership of the partitions this broker
+// leads before it shuts down, retrying while the controller can't be reached or there are
+// partitions left. It gives up after the configured retries and the broker shuts down anyway.
+func (b *Broker) shutDownControlled() {
+	for i := 0; ; i++ {
+		remaining, err := b.requestControlledShutdown()
+		if err == nil && len(remaining) == 0 {
+			b.logger.Info("controlled shutdown succeeded")
+			return
+		}
+		if err == nil {
+			b.logger.Info("controlled shutdown left partitions to lead", log.Int("partitions", len(remaining)))
+		} else {
+			b.logger.Error("controlled shutdown failed", log.Error("error", err))
+		}
+		if i >= b.config.ControlledShutdownMaxRetries {
+			b.logger.Info("giving up on controlled shutdown", log.Int("retries", i))
+			return
+		}
+		time.Sleep(b.config.ControlledShutdownRetryBackoff)
+	}
+}
+
+// requestControlledShutdown sends the ControlledShutdown request to the controller, or runs it
+// if this broker's the controller.
+func (b *Broker) requestControlledShutdown() ([]*protocol.ControlledShutdownPartition, error) {
+	if b.isController() {
+		remaining, err := b.controlledShutdown(b.config.ID)
+		if err != protocol.ErrNone {
+			return nil, err
+		}
+		return remaining, nil
+	}
+	id := b.controllerID()
+	if id == -1 {
+		return nil, protocol.ErrNotController
+	}
+	broker := b.brokerLookup.BrokerByID(raft.ServerID(id))
+	if broker == nil {
+		return nil, protocol.ErrBrokerNotAvailable
+	}
+	conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	resp, err := conn.ControlledShutdown(&protocol.ControlledShutdownRequest{
+		APIVersion:  2,
+		BrokerID:    b.config.ID,
+		BrokerEpoch: -1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != protocol.ErrNone.Code() {
+		return nil, protocol.Errs[resp.ErrorCode]
+	}
+	return resp.PartitionsRemaining, nil
+}
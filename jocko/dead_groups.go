@@ -0,0 +1,94 @@
+package jocko
+
+import (
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// removeDeadGroups deletes the groups the broker coordinates that have gone empty for the
+// group empty retention every interval, so the groups that are gone don't pile up. Deleted
+// groups are Dead, as groups that don't exist are described. When each group went empty is
+// kept by the loop alone, so a broker that takes over coordinating a group gives it a full
+// retention.
+func (b *Broker) removeDeadGroups(interval time.Duration) {
+	if b.config.GroupEmptyRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	emptySince := make(map[string]time.Time)
+	for {
+		select {
+		case <-b.coordinatorsShutdownCh:
+			return
+		case <-ticker.C:
+		}
+		if b.readOnly() {
+			continue
+		}
+		b.sweepDeadGroups(emptySince, time.Now())
+	}
+}
+
+// sweepDeadGroups deletes the groups the broker coordinates that have been empty for the
+// retention by now, returning how many it deleted. emptySince has when each group was first seen
+// empty, it's updated with the groups that went empty and forgets the ones that didn't stay
+// empty.
+func (b *Broker) sweepDeadGroups(emptySince map[string]time.Time, now time.Time) int {
+	_, groups, err := b.fsm.State().GetGroups()
+	if err != nil {
+		b.logger.Error("failed to get groups", log.Error("error", err))
+		return 0
+	}
+	empty := make(map[string]bool)
+	var removed int
+	for _, group := range groups {
+		if len(group.Members) > 0 || len(group.PendingOffsets) > 0 || b.groupCoordinator(group.Group, group) != protocol.ErrNone {
+			continue
+		}
+		since, ok := emptySince[group.Group]
+		if !ok {
+			since = now
+			emptySince[group.Group] = now
+		}
+		empty[group.Group] = true
+		if now.Sub(lastGroupActivity(group, since)) < b.config.GroupEmptyRetention {
+			continue
+		}
+		// deleting fails if members joined since, and it logs the other failures
+		if b.deleteGroup(group) != protocol.ErrNone {
+			continue
+		}
+		delete(emptySince, group.Group)
+		delete(empty, group.Group)
+		removed++
+		b.logger.Info("removed dead group", log.String("group", group.Group), log.Any("empty since", since))
+		if b.metrics != nil {
+			b.metrics.DeadGroupsRemoved.Add(1)
+		}
+	}
+	for id := range emptySince {
+		if !empty[id] {
+			delete(emptySince, id)
+		}
+	}
+	return removed
+}
+
+// lastGroupActivity returns when the empty group was last used: when it went empty, or when it
+// last committed offsets if that's later, like consumers that assign themselves partitions
+// commit without ever joining.
+func lastGroupActivity(group *structs.Group, emptySince time.Time) time.Time {
+	last := emptySince
+	for _, partitions := range group.Offsets {
+		for _, offset := range partitions {
+			if offset.CommitTime.After(last) {
+				last = offset.CommitTime
+			}
+		}
+	}
+	return last
+}
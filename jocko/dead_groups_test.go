@@ -0,0 +1,53 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_RemoveDeadGroups(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.GroupEmptyRetention = time.Hour
+	})
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	commit := b.handleOffsetCommit(ctx, &protocol.OffsetCommitRequest{
+		APIVersion:   1,
+		GroupID:      "empty-group",
+		GenerationID: -1,
+		Topics: []protocol.OffsetCommitTopicRequest{{
+			Topic:      "the-topic",
+			Partitions: []protocol.OffsetCommitPartitionRequest{{Partition: 0, Offset: 5}},
+		}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), commit.Responses[0].PartitionResponses[0].ErrorCode)
+	join := b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{GroupID: "live-group", ProtocolType: "consumer", SessionTimeout: 10000})
+	require.Equal(t, protocol.ErrNone.Code(), join.ErrorCode)
+	exists := func(id string) bool {
+		_, group, err := b.fsm.State().GetGroup(id)
+		require.NoError(t, err)
+		return group != nil
+	}
+
+	emptySince := make(map[string]time.Time)
+	now := time.Now()
+	require.Equal(t, 0, b.sweepDeadGroups(emptySince, now))
+	require.Equal(t, map[string]time.Time{"empty-group": now}, emptySince)
+	require.Equal(t, 0, b.sweepDeadGroups(emptySince, now.Add(30*time.Minute)))
+	require.True(t, exists("empty-group"))
+
+	// the group's dead once it's been empty for the retention, the group with members isn't
+	require.Equal(t, 1, b.sweepDeadGroups(emptySince, now.Add(61*time.Minute)))
+	require.False(t, exists("empty-group"))
+	require.True(t, exists("live-group"))
+	require.Empty(t, emptySince)
+
+	describe := b.handleDescribeGroups(ctx, &protocol.DescribeGroupsRequest{GroupIDs: []string{"empty-group"}})
+	require.Equal(t, "Dead", describe.Groups[0].State)
+}
@@ -0,0 +1,306 @@
+package jocko
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// Delegation tokens are stored in the state store so every broker can authenticate clients
+// with them, and they're created, renewed and expired through the controller. Clients
+// authenticate with a token over SCRAM, its ID being the username and its base64 HMAC the
+// password, sending the tokenauth extension like Kafka's clients do. Like Kafka, clients must
+// authenticate some other way before managing tokens, and can't with a token.
+
+// userPrincipalType is the type of the principals clients authenticate as.
+const userPrincipalType = "User"
+
+// DelegationTokenMechanisms returns the SCRAM-SHA-256 and SCRAM-SHA-512 mechanisms that
+// authenticate clients with the broker's delegation tokens, to enable on its server.
+func (b *Broker) DelegationTokenMechanisms() []SASLMechanism {
+	var mechanisms []SASLMechanism
+	for _, name := range []string{"SCRAM-SHA-256", "SCRAM-SHA-512"} {
+		m, err := ScramMechanism(name, b.DelegationTokenLookup)
+		if err != nil {
+			panic(err)
+		}
+		mechanisms = append(mechanisms, m)
+	}
+	return mechanisms
+}
+
+// DelegationTokenLookup is a ScramLookup of the broker's unexpired delegation tokens, for
+// embedders combining them with their own SCRAM credentials.
+func (b *Broker) DelegationTokenLookup(username string, tokenAuth bool) (password, principal string, ok bool) {
+	if !tokenAuth || b.config.DelegationTokenSecretKey == "" {
+		return "", "", false
+	}
+	_, token, err := b.fsm.State().GetDelegationToken(username)
+	if err != nil || token == nil || time.Now().After(token.ExpiryTime) {
+		return "", "", false
+	}
+	return base64.StdEncoding.EncodeToString(token.HMAC), parsePrincipal(token.Owner).PrincipalName, true
+}
+
+func (b *Broker) handleCreateDelegationToken(ctx *Context, req *protocol.CreateDelegationTokenRequest) *protocol.CreateDelegationTokenResponse {
+	sp := span(ctx, b.tracer, "create delegation token")
+	defer sp.Finish()
+	resp := new(protocol.CreateDelegationTokenResponse)
+	resp.APIVersion = req.Version()
+	p := requestPrincipal(ctx)
+	if err := b.checkDelegationTokenRequest(p, true); err != protocol.ErrNone {
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	now := time.Now()
+	maxLifetime := b.config.DelegationTokenMaxLifetime
+	if req.MaxLifetime > 0 && req.MaxLifetime < maxLifetime {
+		maxLifetime = req.MaxLifetime
+	}
+	token := structs.DelegationToken{
+		ID:        uuid.NewV4().String(),
+		Owner:     userPrincipalType + ":" + p.name,
+		IssueTime: now,
+		MaxTime:   now.Add(maxLifetime),
+	}
+	token.HMAC = b.delegationTokenHMAC(token.ID)
+	token.ExpiryTime = minTime(now.Add(b.config.DelegationTokenExpiryTime), token.MaxTime)
+	for _, r := range req.Renewers {
+		token.Renewers = append(token.Renewers, r.PrincipalType+":"+r.PrincipalName)
+	}
+	if err := b.registerDelegationToken(token); err != protocol.ErrNone {
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	resp.ErrorCode = protocol.ErrNone.Code()
+	resp.Owner = parsePrincipal(token.Owner)
+	resp.IssueTime = token.IssueTime
+	resp.ExpiryTime = token.ExpiryTime
+	resp.MaxTime = token.MaxTime
+	resp.TokenID = token.ID
+	resp.HMAC = token.HMAC
+	return resp
+}
+
+func (b *Broker) handleRenewDelegationToken(ctx *Context, req *protocol.RenewDelegationTokenRequest) *protocol.RenewDelegationTokenResponse {
+	sp := span(ctx, b.tracer, "renew delegation token")
+	defer sp.Finish()
+	resp := new(protocol.RenewDelegationTokenResponse)
+	resp.APIVersion = req.Version()
+	expiry, err := b.updateDelegationToken(requestPrincipal(ctx), req.HMAC, func(token *structs.DelegationToken, now time.Time) protocol.Error {
+		if now.After(token.ExpiryTime) {
+			return protocol.ErrDelegationTokenExpired
+		}
+		period := req.RenewPeriod
+		if period < 0 {
+			period = b.config.DelegationTokenExpiryTime
+		}
+		token.ExpiryTime = minTime(now.Add(period), token.MaxTime)
+		return b.registerDelegationToken(*token)
+	})
+	resp.ErrorCode = err.Code()
+	resp.ExpiryTime = expiry
+	return resp
+}
+
+func (b *Broker) handleExpireDelegationToken(ctx *Context, req *protocol.ExpireDelegationTokenRequest) *protocol.ExpireDelegationTokenResponse {
+	sp := span(ctx, b.tracer, "expire delegation token")
+	defer sp.Finish()
+	resp := new(protocol.ExpireDelegationTokenResponse)
+	resp.APIVersion = req.Version()
+	expiry, err := b.updateDelegationToken(requestPrincipal(ctx), req.HMAC, func(token *structs.DelegationToken, now time.Time) protocol.Error {
+		if now.After(token.ExpiryTime) {
+			return protocol.ErrDelegationTokenExpired
+		}
+		if req.ExpiryPeriod < 0 {
+			token.ExpiryTime = now
+			return b.deregisterDelegationToken(token.ID)
+		}
+		token.ExpiryTime = minTime(now.Add(req.ExpiryPeriod), token.MaxTime)
+		return b.registerDelegationToken(*token)
+	})
+	resp.ErrorCode = err.Code()
+	resp.ExpiryTime = expiry
+	return resp
+}
+
+func (b *Broker) handleDescribeDelegationToken(ctx *Context, req *protocol.DescribeDelegationTokenRequest) *protocol.DescribeDelegationTokenResponse {
+	sp := span(ctx, b.tracer, "describe delegation token")
+	defer sp.Finish()
+	resp := new(protocol.DescribeDelegationTokenResponse)
+	resp.APIVersion = req.Version()
+	if err := b.checkDelegationTokenRequest(requestPrincipal(ctx), false); err != protocol.ErrNone {
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	_, tokens, err := b.fsm.State().GetDelegationTokens()
+	if err != nil {
+		resp.ErrorCode = protocol.ErrUnknown.WithErr(err).Code()
+		return resp
+	}
+	resp.ErrorCode = protocol.ErrNone.Code()
+	for _, token := range tokens {
+		owner := parsePrincipal(token.Owner)
+		if req.Owners != nil && !containsPrincipal(req.Owners, owner) {
+			continue
+		}
+		t := protocol.DescribedDelegationToken{
+			Owner:      owner,
+			IssueTime:  token.IssueTime,
+			ExpiryTime: token.ExpiryTime,
+			MaxTime:    token.MaxTime,
+			TokenID:    token.ID,
+			HMAC:       token.HMAC,
+			Renewers:   []protocol.DelegationTokenPrincipal{},
+		}
+		for _, r := range token.Renewers {
+			t.Renewers = append(t.Renewers, parsePrincipal(r))
+		}
+		resp.Tokens = append(resp.Tokens, t)
+	}
+	return resp
+}
+
+// checkDelegationTokenRequest returns the error for a request managing tokens, which are
+// sent by clients that authenticated without a token. Writes go through the controller.
+func (b *Broker) checkDelegationTokenRequest(p principal, write bool) protocol.Error {
+	switch {
+	case b.config.DelegationTokenSecretKey == "":
+		return protocol.ErrDelegationTokenAuthDisabled
+	case p.name == "" || p.tokenID != "":
+		return protocol.ErrDelegationTokenRequestNotAllowed
+	case write && !b.isController():
+		return protocol.ErrNotController
+	case write && b.readOnly():
+		return errReadOnly
+	}
+	return protocol.ErrNone
+}
+
+// updateDelegationToken calls update with the token with the HMAC, once it's checked the
+// principal can renew or expire it, and returns its new expiry time.
+func (b *Broker) updateDelegationToken(p principal, mac []byte, update func(token *structs.DelegationToken, now time.Time) protocol.Error) (time.Time, protocol.Error) {
+	if err := b.checkDelegationTokenRequest(p, true); err != protocol.ErrNone {
+		return time.Time{}, err
+	}
+	_, tokens, err := b.fsm.State().GetDelegationTokens()
+	if err != nil {
+		return time.Time{}, protocol.ErrUnknown.WithErr(err)
+	}
+	var token *structs.DelegationToken
+	for _, t := range tokens {
+		if hmac.Equal(t.HMAC, mac) {
+			token = t
+			break
+		}
+	}
+	if token == nil {
+		return time.Time{}, protocol.ErrDelegationTokenNotFound
+	}
+	name := userPrincipalType + ":" + p.name
+	allowed := token.Owner == name
+	for _, r := range token.Renewers {
+		allowed = allowed || r == name
+	}
+	if !allowed {
+		return time.Time{}, protocol.ErrDelegationTokenOwnerMismatch
+	}
+	// the state store's token isn't changed, it's replaced through raft
+	updated := *token
+	if err := update(&updated, time.Now()); err != protocol.ErrNone {
+		return time.Time{}, err
+	}
+	return updated.ExpiryTime, protocol.ErrNone
+}
+
+func (b *Broker) registerDelegationToken(token structs.DelegationToken) protocol.Error {
+	res, err := b.raftApply(structs.RegisterDelegationTokenRequestType, structs.RegisterDelegationTokenRequest{Token: token})
+	if err == nil {
+		err, _ = res.(error)
+	}
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return protocol.ErrNone
+}
+
+func (b *Broker) deregisterDelegationToken(id string) protocol.Error {
+	res, err := b.raftApply(structs.DeregisterDelegationTokenRequestType, structs.DeregisterDelegationTokenRequest{ID: id})
+	if err == nil {
+		err, _ = res.(error)
+	}
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return protocol.ErrNone
+}
+
+// removeExpiredDelegationTokens has the controller remove the tokens that have expired every
+// interval.
+func (b *Broker) removeExpiredDelegationTokens(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+		if !b.isController() {
+			continue
+		}
+		_, tokens, err := b.fsm.State().GetDelegationTokens()
+		if err != nil {
+			b.logger.Error("failed to get delegation tokens", log.Error("error", err))
+			continue
+		}
+		now := time.Now()
+		for _, token := range tokens {
+			if !now.After(token.ExpiryTime) {
+				continue
+			}
+			if err := b.deregisterDelegationToken(token.ID); err != protocol.ErrNone {
+				b.logger.Error("failed to remove expired delegation token", log.String("token id", token.ID), log.Error("error", err))
+			}
+		}
+	}
+}
+
+// delegationTokenHMAC returns the HMAC of the token's ID.
+func (b *Broker) delegationTokenHMAC(id string) []byte {
+	mac := hmac.New(sha512.New, []byte(b.config.DelegationTokenSecretKey))
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+// parsePrincipal parses a principal like User:alice.
+func parsePrincipal(s string) protocol.DelegationTokenPrincipal {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return protocol.DelegationTokenPrincipal{PrincipalType: userPrincipalType, PrincipalName: s}
+	}
+	return protocol.DelegationTokenPrincipal{PrincipalType: s[:i], PrincipalName: s[i+1:]}
+}
+
+func containsPrincipal(ps []protocol.DelegationTokenPrincipal, p protocol.DelegationTokenPrincipal) bool {
+	for _, q := range ps {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
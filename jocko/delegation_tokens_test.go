@@ -0,0 +1,221 @@
+package jocko
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_DelegationTokens(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.DelegationTokenSecretKey = "secret"
+	})
+	defer teardown()
+	alice := &Context{parent: withPrincipal(context.Background(), "alice", "")}
+	bob := &Context{parent: withPrincipal(context.Background(), "bob", "")}
+	carol := &Context{parent: withPrincipal(context.Background(), "carol", "")}
+
+	// clients must authenticate, without a token, to manage tokens
+	create := b.handleCreateDelegationToken(&Context{parent: context.Background()}, &protocol.CreateDelegationTokenRequest{MaxLifetime: -1})
+	require.Equal(t, protocol.ErrDelegationTokenRequestNotAllowed.Code(), create.ErrorCode)
+	create = b.handleCreateDelegationToken(&Context{parent: withPrincipal(context.Background(), "alice", "the-token")}, &protocol.CreateDelegationTokenRequest{MaxLifetime: -1})
+	require.Equal(t, protocol.ErrDelegationTokenRequestNotAllowed.Code(), create.ErrorCode)
+
+	create = b.handleCreateDelegationToken(alice, &protocol.CreateDelegationTokenRequest{
+		Renewers:    []protocol.DelegationTokenPrincipal{{PrincipalType: "User", PrincipalName: "bob"}},
+		MaxLifetime: time.Hour,
+	})
+	require.Equal(t, protocol.ErrNone.Code(), create.ErrorCode)
+	require.Equal(t, protocol.DelegationTokenPrincipal{PrincipalType: "User", PrincipalName: "alice"}, create.Owner)
+	require.Equal(t, create.IssueTime.Add(time.Hour), create.MaxTime)
+	// the expiry time's capped by the max lifetime
+	require.Equal(t, create.MaxTime, create.ExpiryTime)
+	require.NotEmpty(t, create.TokenID)
+	require.NotEmpty(t, create.HMAC)
+
+	password, principal, ok := b.DelegationTokenLookup(create.TokenID, true)
+	require.True(t, ok)
+	require.Equal(t, base64.StdEncoding.EncodeToString(create.HMAC), password)
+	require.Equal(t, "alice", principal)
+	_, _, ok = b.DelegationTokenLookup(create.TokenID, false)
+	require.False(t, ok)
+
+	describe := b.handleDescribeDelegationToken(carol, &protocol.DescribeDelegationTokenRequest{
+		Owners: []protocol.DelegationTokenPrincipal{{PrincipalType: "User", PrincipalName: "alice"}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), describe.ErrorCode)
+	require.Equal(t, 1, len(describe.Tokens))
+	require.Equal(t, create.TokenID, describe.Tokens[0].TokenID)
+	require.Equal(t, []protocol.DelegationTokenPrincipal{{PrincipalType: "User", PrincipalName: "bob"}}, describe.Tokens[0].Renewers)
+	describe = b.handleDescribeDelegationToken(carol, &protocol.DescribeDelegationTokenRequest{Owners: []protocol.DelegationTokenPrincipal{}})
+	require.Equal(t, 0, len(describe.Tokens))
+
+	// only the owner and renewers can renew or expire the token
+	renew := b.handleRenewDelegationToken(carol, &protocol.RenewDelegationTokenRequest{HMAC: create.HMAC, RenewPeriod: time.Minute})
+	require.Equal(t, protocol.ErrDelegationTokenOwnerMismatch.Code(), renew.ErrorCode)
+	renew = b.handleRenewDelegationToken(bob, &protocol.RenewDelegationTokenRequest{HMAC: []byte("unknown"), RenewPeriod: time.Minute})
+	require.Equal(t, protocol.ErrDelegationTokenNotFound.Code(), renew.ErrorCode)
+	renew = b.handleRenewDelegationToken(bob, &protocol.RenewDelegationTokenRequest{HMAC: create.HMAC, RenewPeriod: time.Minute})
+	require.Equal(t, protocol.ErrNone.Code(), renew.ErrorCode)
+	require.True(t, renew.ExpiryTime.Before(create.ExpiryTime))
+
+	expire := b.handleExpireDelegationToken(alice, &protocol.ExpireDelegationTokenRequest{HMAC: create.HMAC, ExpiryPeriod: -1})
+	require.Equal(t, protocol.ErrNone.Code(), expire.ErrorCode)
+	_, token, err := b.fsm.State().GetDelegationToken(create.TokenID)
+	require.NoError(t, err)
+	require.Nil(t, token)
+	_, _, ok = b.DelegationTokenLookup(create.TokenID, true)
+	require.False(t, ok)
+	expire = b.handleExpireDelegationToken(alice, &protocol.ExpireDelegationTokenRequest{HMAC: create.HMAC, ExpiryPeriod: -1})
+	require.Equal(t, protocol.ErrDelegationTokenNotFound.Code(), expire.ErrorCode)
+}
+
+func TestBroker_DelegationTokensDisabled(t *testing.T) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	b := s.broker()
+	defer func() {
+		b.Shutdown()
+		teardown()
+	}()
+	ctx := &Context{parent: withPrincipal(context.Background(), "alice", "")}
+	create := b.handleCreateDelegationToken(ctx, &protocol.CreateDelegationTokenRequest{MaxLifetime: -1})
+	require.Equal(t, protocol.ErrDelegationTokenAuthDisabled.Code(), create.ErrorCode)
+	describe := b.handleDescribeDelegationToken(ctx, &protocol.DescribeDelegationTokenRequest{})
+	require.Equal(t, protocol.ErrDelegationTokenAuthDisabled.Code(), describe.ErrorCode)
+}
+
+func TestServer_DelegationTokenAuth(t *testing.T) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+		cfg.DelegationTokenSecretKey = "secret"
+	}, nil)
+	b := s.broker()
+	s.EnableSASL(append(b.DelegationTokenMechanisms(), testPlainMechanism())...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.Start(ctx))
+	defer teardown()
+	defer s.Shutdown()
+	waitForRegistered(t, b)
+
+	dial := func() *Conn {
+		conn, err := Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		return conn
+	}
+
+	conn := dial()
+	resp, err := conn.SaslHandshake(&protocol.SaslHandshakeRequest{APIVersion: 1, Mechanism: "PLAIN"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"SCRAM-SHA-256", "SCRAM-SHA-512", "PLAIN"}, resp.EnabledMechanisms)
+	authResp, err := conn.SaslAuthenticate(&protocol.SaslAuthenticateRequest{APIVersion: 1, AuthBytes: []byte("\x00alice\x00secret")})
+	require.NoError(t, err)
+	require.Equal(t, protocol.ErrNone.Code(), authResp.ErrorCode)
+	create, err := conn.CreateDelegationToken(&protocol.CreateDelegationTokenRequest{MaxLifetime: -1})
+	require.NoError(t, err)
+	require.Equal(t, protocol.ErrNone.Code(), create.ErrorCode)
+	conn.Close()
+
+	password := base64.StdEncoding.EncodeToString(create.HMAC)
+	for _, mechanism := range []string{"SCRAM-SHA-256", "SCRAM-SHA-512"} {
+		conn = dial()
+		require.NoError(t, testScramAuthenticate(conn, mechanism, create.TokenID, password))
+		// clients authenticated with tokens can't manage them
+		describe, err := conn.DescribeDelegationToken(&protocol.DescribeDelegationTokenRequest{})
+		require.NoError(t, err)
+		require.Equal(t, protocol.ErrDelegationTokenRequestNotAllowed.Code(), describe.ErrorCode)
+		conn.Close()
+	}
+
+	conn = dial()
+	defer conn.Close()
+	require.Error(t, testScramAuthenticate(conn, "SCRAM-SHA-256", create.TokenID, "wrong"))
+}
+
+func TestPBKDF2(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors
+	for _, test := range []struct {
+		iterations int
+		key        string
+	}{
+		{iterations: 1, key: "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{iterations: 4096, key: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	} {
+		require.Equal(t, test.key, hex.EncodeToString(pbkdf2(sha256.New, []byte("password"), []byte("salt"), test.iterations)))
+	}
+}
+
+// testScramAuthenticate authenticates the conn as the token with the SCRAM mechanism.
+func testScramAuthenticate(conn *Conn, mechanism, tokenID, password string) error {
+	h := sha256.New
+	if mechanism == "SCRAM-SHA-512" {
+		h = sha512.New
+	}
+	resp, err := conn.SaslHandshake(&protocol.SaslHandshakeRequest{APIVersion: 1, Mechanism: mechanism})
+	if err != nil {
+		return err
+	}
+	if resp.ErrorCode != protocol.ErrNone.Code() {
+		return protocol.Errs[resp.ErrorCode]
+	}
+	clientFirstBare := "n=" + tokenID + ",r=clientnonce,tokenauth=true"
+	auth, err := conn.SaslAuthenticate(&protocol.SaslAuthenticateRequest{APIVersion: 1, AuthBytes: []byte("n,," + clientFirstBare)})
+	if err != nil {
+		return err
+	}
+	if auth.ErrorCode != protocol.ErrNone.Code() {
+		return protocol.Errs[auth.ErrorCode]
+	}
+	serverFirst := string(auth.AuthBytes)
+	var nonce string
+	var salt []byte
+	var iterations int
+	for _, attr := range strings.Split(serverFirst, ",") {
+		switch attr[:2] {
+		case "r=":
+			nonce = attr[2:]
+		case "s=":
+			salt, _ = base64.StdEncoding.DecodeString(attr[2:])
+		case "i=":
+			fmt.Sscanf(attr[2:], "%d", &iterations)
+		}
+	}
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + ",r=" + nonce
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + withoutProof)
+	salted := pbkdf2(h, []byte(password), salt, iterations)
+	clientKey := hmacSum(h, salted, []byte("Client Key"))
+	storedKey := h()
+	storedKey.Write(clientKey)
+	proof := hmacSum(h, storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	auth, err = conn.SaslAuthenticate(&protocol.SaslAuthenticateRequest{APIVersion: 1, AuthBytes: []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof))})
+	if err != nil {
+		return err
+	}
+	if auth.ErrorCode != protocol.ErrNone.Code() {
+		return protocol.Errs[auth.ErrorCode]
+	}
+	serverSignature := hmacSum(h, hmacSum(h, salted, []byte("Server Key")), authMessage)
+	if string(auth.AuthBytes) != "v="+base64.StdEncoding.EncodeToString(serverSignature) {
+		return fmt.Errorf("bad server signature: %s", auth.AuthBytes)
+	}
+	return nil
+}
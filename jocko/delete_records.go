@@ -0,0 +1,47 @@
+package jocko
+
+import (
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// recordDeleter is implemented by commit logs that can delete their messages up to an offset.
+type recordDeleter interface {
+	DeleteRecords(offset int64) error
+}
+
+// deleteRecords deletes the messages before offset from the partition, which this broker must
+// lead, and returns the partition's new low watermark.
+func (b *Broker) deleteRecords(topic string, partition int32, offset int64) (int64, protocol.Error) {
+	replica, err := b.replicaLookup.Replica(topic, partition)
+	if err != nil {
+		return -1, protocol.ErrUnknownTopicOrPartition
+	}
+	if replica.Partition.Leader != b.config.ID {
+		return -1, protocol.ErrNotLeaderForPartition
+	}
+	if replica.Log == nil {
+		return -1, protocol.ErrReplicaNotAvailable
+	}
+	l, ok := replica.Log.(recordDeleter)
+	if !ok {
+		return -1, protocol.ErrUnknown
+	}
+	// -1 deletes up to the high watermark so the messages the followers haven't replicated
+	// yet are kept
+	if offset == -1 {
+		offset = b.followers.highWatermark(replica.Partition, replica.Log.NewestOffset())
+	}
+	if offset < 0 {
+		return -1, protocol.ErrOffsetOutOfRange
+	}
+	// the followers delete the messages once their fetch responses carry the new log start
+	// offset
+	if err := l.DeleteRecords(offset); err != nil {
+		if err == commitlog.ErrOffsetOutOfRange {
+			return -1, protocol.ErrOffsetOutOfRange
+		}
+		return -1, protocol.ErrUnknown.WithErr(err)
+	}
+	return replica.Log.OldestOffset(), protocol.ErrNone
+}
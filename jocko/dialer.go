@@ -6,6 +6,8 @@ import (
 	"math"
 	"net"
 	"time"
+
+	"github.com/travisjeffery/jocko/jocko/config"
 )
 
 const (
@@ -28,6 +30,12 @@ type Dialer struct {
 	RemoteAddr net.Addr
 	// KeepAlive is the keep-alive period for a network connection.
 	KeepAlive time.Duration
+	// DisableNoDelay enables Nagle's algorithm on the connection, which is disabled by default.
+	DisableNoDelay bool
+	// SendBufferBytes is the size of the socket's send buffer. If 0, the OS default is used.
+	SendBufferBytes int
+	// ReceiveBufferBytes is the size of the socket's receive buffer. If 0, the OS default is used.
+	ReceiveBufferBytes int
 	// FallbackDelay is the duration to wait before spawning a fallback connection. If 0, default duration is 300ms.
 	FallbackDelay time.Duration
 	// Resolver species an alternative resolver to use.
@@ -42,6 +50,12 @@ var (
 	defaultDialer = NewDialer("jocko")
 )
 
+// The client IDs brokers connect to each other with are these prefixes followed by their IDs.
+const (
+	replicatorClientIDPrefix = "jocko-replicator-"
+	brokerClientIDPrefix     = "jocko-broker-"
+)
+
 // NewDialer creates a new dialer.
 func NewDialer(clientID string) *Dialer {
 	return &Dialer{
@@ -51,6 +65,16 @@ func NewDialer(clientID string) *Dialer {
 	}
 }
 
+// NewDialerWithConfig creates a new dialer using the given socket config.
+func NewDialerWithConfig(clientID string, cfg config.SocketConfig) *Dialer {
+	d := NewDialer(clientID)
+	d.DisableNoDelay = cfg.DisableNoDelay
+	d.KeepAlive = cfg.KeepAlive
+	d.SendBufferBytes = cfg.SendBufferBytes
+	d.ReceiveBufferBytes = cfg.ReceiveBufferBytes
+	return d
+}
+
 // Dial creates a connection to the broker on the given network and address on the default dialer.
 func Dial(network, address string) (*Conn, error) {
 	return defaultDialer.Dial(network, address)
@@ -100,11 +124,54 @@ func (d *Dialer) dialContext(ctx context.Context, network, address string) (net.
 			address = net.JoinHostPort(address, port)
 		}
 	}
-	return (&net.Dialer{
+	conn, err := (&net.Dialer{
 		LocalAddr:     d.LocalAddr,
 		FallbackDelay: d.FallbackDelay,
 		KeepAlive:     d.KeepAlive,
 	}).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSocketOptions(conn, config.SocketConfig{
+		SendBufferBytes:    d.SendBufferBytes,
+		ReceiveBufferBytes: d.ReceiveBufferBytes,
+		DisableNoDelay:     d.DisableNoDelay,
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// setSocketOptions applies the socket config to the conn if it's a TCP conn.
+func setSocketOptions(conn net.Conn, cfg config.SocketConfig) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	// set either way so switching an accepted conn to the replica options undoes the client's
+	if err := tcpConn.SetNoDelay(!cfg.DisableNoDelay); err != nil {
+		return err
+	}
+	if cfg.SendBufferBytes > 0 {
+		if err := tcpConn.SetWriteBuffer(cfg.SendBufferBytes); err != nil {
+			return err
+		}
+	}
+	if cfg.ReceiveBufferBytes > 0 {
+		if err := tcpConn.SetReadBuffer(cfg.ReceiveBufferBytes); err != nil {
+			return err
+		}
+	}
+	if cfg.KeepAlive > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tcpConn.SetKeepAlivePeriod(cfg.KeepAlive); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func splitHostPort(s string) (string, string) {
@@ -0,0 +1,38 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestServer_DispatchRequestsFairness(t *testing.T) {
+	s := &Server{
+		shutdownCh:       make(chan struct{}),
+		requestCh:        make(chan *Context),
+		controlRequestCh: make(chan *Context, 32),
+		clientRequestCh:  make(chan *Context, 32),
+	}
+	for i := 0; i < 3*controlRequestsPerClientRequest; i++ {
+		s.controlRequestCh <- &Context{req: &protocol.FetchRequest{ReplicaID: 2}}
+	}
+	for i := 0; i < 2; i++ {
+		s.clientRequestCh <- &Context{req: &protocol.ProduceRequest{}}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.dispatchRequests(ctx)
+
+	var clients []int
+	for i := 0; i < 2*(controlRequestsPerClientRequest+1); i++ {
+		reqCtx := <-s.requestCh
+		if !isControlRequest(reqCtx.req) {
+			clients = append(clients, i)
+		}
+	}
+	// a client request gets through after each run of control requests, the rest are still
+	// preferred
+	require.Equal(t, []int{controlRequestsPerClientRequest, 2*controlRequestsPerClientRequest + 1}, clients)
+}
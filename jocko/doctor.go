@@ -0,0 +1,253 @@
+package jocko
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/jocko/config"
+)
+
+// DoctorStatus is how a check jocko doctor ran went.
+type DoctorStatus string
+
+const (
+	DoctorOK DoctorStatus = "ok"
+	// DoctorWarn is for problems the broker copes with, like indexes it rebuilds when it
+	// starts, or that may be expected, like ports a running broker's bound.
+	DoctorWarn DoctorStatus = "warn"
+	DoctorFail DoctorStatus = "fail"
+)
+
+// DoctorCheck is the result of one of the checks jocko doctor ran.
+type DoctorCheck struct {
+	Name   string       `json:"name"`
+	Status DoctorStatus `json:"status"`
+	Detail string       `json:"detail"`
+}
+
+// DoctorReport is the health report of a broker's data dir and config made by Doctor.
+type DoctorReport struct {
+	Checks []DoctorCheck `json:"checks"`
+}
+
+// Healthy returns true if none of the report's checks failed.
+func (r *DoctorReport) Healthy() bool {
+	for _, c := range r.Checks {
+		if c.Status == DoctorFail {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *DoctorReport) add(name string, status DoctorStatus, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, DoctorCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// DoctorConfig configures the checks Doctor runs beyond the broker's own config.
+type DoctorConfig struct {
+	// Peers are the addresses of the admin APIs of the brokers to compare the clock with.
+	Peers []string
+	// MaxClockSkew is how far the clock can be off the peers' before it's reported.
+	MaxClockSkew time.Duration
+	// Timeout is how long to wait for each peer and for the raft store's lock.
+	Timeout time.Duration
+}
+
+// Doctor checks the data dir and config of a broker, which should be stopped since its logs and
+// raft store are read as they are on disk and its ports are bound: the segments of its logs and
+// their indexes, that its raft store can be opened, that its ports can be bound, and how far its
+// clock is off its peers'. It's a first line of support before filing issues.
+func Doctor(cfg *config.Config, dc DoctorConfig) *DoctorReport {
+	r := new(DoctorReport)
+	doctorDataDir(r, cfg.DataDir)
+	for _, dir := range configuredLogDirs(cfg) {
+		doctorLogDir(r, dir)
+	}
+	doctorRaftStore(r, cfg, dc.Timeout)
+	doctorPort(r, "broker addr", "tcp", cfg.Addr)
+	doctorPort(r, "raft addr", "tcp", cfg.RaftAddr)
+	serfAddr := cfg.SerfLANConfig.MemberlistConfig.BindAddr
+	if _, _, err := net.SplitHostPort(serfAddr); err != nil {
+		serfAddr = net.JoinHostPort(serfAddr, strconv.Itoa(cfg.SerfLANConfig.MemberlistConfig.BindPort))
+	}
+	doctorPort(r, "serf addr", "tcp", serfAddr)
+	doctorPort(r, "serf addr", "udp", serfAddr)
+	client := &http.Client{Timeout: dc.Timeout}
+	for _, peer := range dc.Peers {
+		doctorClockSkew(r, client, peer, dc.MaxClockSkew)
+	}
+	return r
+}
+
+// doctorDataDir checks the data dir exists and can be written to.
+func doctorDataDir(r *DoctorReport, dir string) {
+	const name = "data dir"
+	if _, err := os.Stat(dir); err != nil {
+		r.add(name, DoctorFail, "%v", err)
+		return
+	}
+	f, err := ioutil.TempFile(dir, ".doctor")
+	if err != nil {
+		r.add(name, DoctorFail, "can't be written to: %v", err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	r.add(name, DoctorOK, "%s", dir)
+}
+
+// doctorLogDir verifies the segments of the partition logs in the log dir, leaving out the logs
+// being deleted or moved.
+func doctorLogDir(r *DoctorReport, dir string) {
+	name := "log dir " + dir
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		r.add(name, DoctorWarn, "doesn't exist, it's created when the broker starts")
+		return
+	}
+	if err != nil {
+		r.add(name, DoctorFail, "%v", err)
+		return
+	}
+	var logs, segments int
+	for _, f := range files {
+		if !f.IsDir() || strings.HasSuffix(f.Name(), deletedLogSuffix) || strings.HasSuffix(f.Name(), commitlog.FuturePath("")) {
+			continue
+		}
+		if _, _, ok := parsePartitionDirName(f.Name()); !ok {
+			continue
+		}
+		n, problems, err := commitlog.Verify(filepath.Join(dir, f.Name()))
+		if err != nil {
+			r.add("log "+f.Name(), DoctorFail, "%v", err)
+			continue
+		}
+		for _, p := range problems {
+			status := DoctorFail
+			if p.Recoverable {
+				status = DoctorWarn
+			}
+			r.add("segment", status, "%v", p)
+		}
+		logs++
+		segments += n
+	}
+	r.add(name, DoctorOK, "verified %d segments of %d logs", segments, logs)
+}
+
+// doctorRaftStore checks the raft store can be opened, read-only, and has raft's buckets.
+func doctorRaftStore(r *DoctorReport, cfg *config.Config, timeout time.Duration) {
+	const name = "raft store"
+	if cfg.DevMode {
+		r.add(name, DoctorOK, "dev mode keeps raft's state in memory")
+		return
+	}
+	path := filepath.Join(cfg.DataDir, raftState, "raft.db")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		r.add(name, DoctorWarn, "%s doesn't exist, the broker hasn't run with this data dir", path)
+		return
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout, ReadOnly: true})
+	if err == bolt.ErrTimeout {
+		r.add(name, DoctorWarn, "%s is locked, the broker's probably running", path)
+		return
+	}
+	if err != nil {
+		r.add(name, DoctorFail, "opening %s failed: %v", path, err)
+		return
+	}
+	defer db.Close()
+	var first, last uint64
+	err = db.View(func(tx *bolt.Tx) error {
+		// the buckets raft-boltdb keeps raft's log and stable state in
+		logs, conf := tx.Bucket([]byte("logs")), tx.Bucket([]byte("conf"))
+		if logs == nil || conf == nil {
+			return fmt.Errorf("missing raft's buckets")
+		}
+		c := logs.Cursor()
+		if k, _ := c.First(); k != nil {
+			first = binary.BigEndian.Uint64(k)
+		}
+		if k, _ := c.Last(); k != nil {
+			last = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	if err != nil {
+		r.add(name, DoctorFail, "reading %s failed: %v", path, err)
+		return
+	}
+	r.add(name, DoctorOK, "raft log has indexes %d to %d", first, last)
+}
+
+// doctorPort checks the address can be bound.
+func doctorPort(r *DoctorReport, name, network, addr string) {
+	name = fmt.Sprintf("%s %s/%s", name, addr, network)
+	var err error
+	if network == "udp" {
+		var c net.PacketConn
+		if c, err = net.ListenPacket(network, addr); err == nil {
+			c.Close()
+		}
+	} else {
+		var l net.Listener
+		if l, err = net.Listen(network, addr); err == nil {
+			l.Close()
+		}
+	}
+	if err != nil {
+		// a running broker has its ports bound, other processes shouldn't
+		r.add(name, DoctorWarn, "can't be bound, is the broker or something else using it: %v", err)
+		return
+	}
+	r.add(name, DoctorOK, "can be bound")
+}
+
+// doctorClockSkew compares the clock with the peer's, from its admin API. The peer's time is
+// taken to be from halfway through the request, so the skew's within half the round trip.
+func doctorClockSkew(r *DoctorReport, client *http.Client, peer string, max time.Duration) {
+	name := "clock skew " + peer
+	url := peer
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	start := time.Now()
+	resp, err := client.Get(strings.TrimSuffix(url, "/") + "/v1/clock")
+	if err != nil {
+		r.add(name, DoctorWarn, "can't reach peer: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	rtt := time.Since(start)
+	var clock adminClockResponse
+	if resp.StatusCode != http.StatusOK {
+		r.add(name, DoctorWarn, "peer returned %s", resp.Status)
+		return
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&clock); err != nil {
+		r.add(name, DoctorWarn, "decoding peer's clock failed: %v", err)
+		return
+	}
+	skew := clock.Time.Sub(start.Add(rtt / 2))
+	off := skew
+	if off < 0 {
+		off = -off
+	}
+	status := DoctorOK
+	if off-rtt/2 > max {
+		status = DoctorFail
+	}
+	r.add(name, status, "peer's clock is %v off, give or take %v", skew, rtt/2)
+}
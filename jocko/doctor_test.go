@@ -0,0 +1,70 @@
+package jocko
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/jocko/config"
+)
+
+func TestDoctor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jocko-doctor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.Addr = "127.0.0.1:0"
+	cfg.RaftAddr = "127.0.0.1:0"
+	cfg.SerfLANConfig.MemberlistConfig.BindAddr = "127.0.0.1"
+	cfg.SerfLANConfig.MemberlistConfig.BindPort = 0
+
+	l, err := commitlog.New(commitlog.Options{
+		Path:            filepath.Join(dir, "data", partitionDirName("the-topic", 0)),
+		MaxSegmentBytes: 1024,
+		MaxLogBytes:     -1,
+	})
+	require.NoError(t, err)
+	_, err = l.Append(commitlog.NewMessageSet(0, commitlog.NewMessage([]byte("the-message"))))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	peer := httptest.NewServer(http.HandlerFunc(new(Broker).adminClock))
+	defer peer.Close()
+	dc := DoctorConfig{Peers: []string{peer.URL}, MaxClockSkew: time.Second, Timeout: time.Second}
+
+	statuses := func(r *DoctorReport) map[string]DoctorStatus {
+		m := make(map[string]DoctorStatus)
+		for _, c := range r.Checks {
+			if m[c.Name] != DoctorFail {
+				m[c.Name] = c.Status
+			}
+		}
+		return m
+	}
+	report := Doctor(cfg, dc)
+	require.True(t, report.Healthy(), "%v", report.Checks)
+	checks := statuses(report)
+	require.Equal(t, DoctorOK, checks["data dir"])
+	require.Equal(t, DoctorOK, checks["log dir "+filepath.Join(dir, "data")])
+	require.Equal(t, DoctorOK, checks["clock skew "+peer.URL])
+	// the broker hasn't run with the data dir
+	require.Equal(t, DoctorWarn, checks["raft store"])
+
+	// a crash mid-write left a partial message set at the end of the log
+	f, err := os.OpenFile(filepath.Join(l.Path, "00000000000000000000"+commitlog.LogFileSuffix), os.O_APPEND|os.O_WRONLY, 0666)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	report = Doctor(cfg, dc)
+	require.False(t, report.Healthy())
+	require.Equal(t, DoctorFail, statuses(report)["segment"])
+}
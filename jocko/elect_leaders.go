@@ -0,0 +1,198 @@
+package jocko
+
+import (
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// electLeadersRequest is an ElectLeaders request passed to the leader loop, which sends the
+// results of the elections on result.
+type electLeadersRequest struct {
+	electionType int8
+	// topics is nil to elect the leaders of every partition.
+	topics []protocol.ElectLeadersTopic
+	result chan electLeadersResult
+}
+
+// electLeadersResult is the results of an ElectLeaders request's elections, err is set if they
+// couldn't be run at all.
+type electLeadersResult struct {
+	topics []protocol.ElectLeadersTopicResult
+	err    protocol.Error
+}
+
+// electLeadersTimeout bounds how long ElectLeaders requests wait for their elections, requests
+// are handled one at a time so they mustn't wait for ever even if they ask to.
+const electLeadersTimeout = 30 * time.Second
+
+// electLeaders passes the elections to the leader loop and waits for their results. Every
+// partition gets the error if the elections weren't run, e.g. because this broker isn't the
+// controller or lost leadership while they were waiting.
+func (b *Broker) electLeaders(req *protocol.ElectLeadersRequest) ([]protocol.ElectLeadersTopicResult, protocol.Error) {
+	stopCh := b.leaderStop()
+	if stopCh == nil {
+		return electLeadersErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	}
+	r := &electLeadersRequest{
+		electionType: req.ElectionType,
+		topics:       req.Topics,
+		result:       make(chan electLeadersResult, 1),
+	}
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > electLeadersTimeout {
+		timeout = electLeadersTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case b.electLeadersCh <- r:
+	case <-timer.C:
+		return electLeadersErrors(req.Topics, protocol.ErrRequestTimedOut), protocol.ErrRequestTimedOut
+	case <-stopCh:
+		return electLeadersErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	case <-b.shutdownCh:
+		return electLeadersErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	}
+	select {
+	case res := <-r.result:
+		return res.topics, res.err
+	case <-timer.C:
+		// the elections still go ahead, we just don't know how they went
+		return electLeadersErrors(req.Topics, protocol.ErrRequestTimedOut), protocol.ErrRequestTimedOut
+	case <-stopCh:
+		return electLeadersErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	case <-b.shutdownCh:
+		return electLeadersErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	}
+}
+
+// electLeadersErrors returns results with err for every partition.
+func electLeadersErrors(topics []protocol.ElectLeadersTopic, err protocol.Error) []protocol.ElectLeadersTopicResult {
+	results := make([]protocol.ElectLeadersTopicResult, len(topics))
+	for i, t := range topics {
+		results[i] = protocol.ElectLeadersTopicResult{
+			Topic:      t.Topic,
+			Partitions: make([]protocol.ElectLeadersPartitionResult, len(t.Partitions)),
+		}
+		for j, id := range t.Partitions {
+			results[i].Partitions[j] = protocol.ElectLeadersPartitionResult{Partition: id, ErrorCode: err.Code()}
+		}
+	}
+	return results
+}
+
+// runElections runs the elections in the leader loop, registering the partitions' new
+// leaders and sending them to their replicas.
+func (b *Broker) runElections(r *electLeadersRequest) electLeadersResult {
+	state := b.fsm.State()
+	topics := r.topics
+	if topics == nil {
+		_, partitions, err := state.GetPartitions()
+		if err != nil {
+			// there's no partitions to give the error to so it's only returned to v1+ clients
+			b.logger.Error("leader: failed to get partitions", log.Error("error", err))
+			return electLeadersResult{err: protocol.ErrUnknown}
+		}
+		index := make(map[string]int)
+		for _, p := range partitions {
+			i, ok := index[p.Topic]
+			if !ok {
+				i = len(topics)
+				index[p.Topic] = i
+				topics = append(topics, protocol.ElectLeadersTopic{Topic: p.Topic})
+			}
+			topics[i].Partitions = append(topics[i].Partitions, p.ID)
+		}
+	}
+	passing, err := b.passingNodes()
+	if err != nil {
+		b.logger.Error("leader: failed to get passing nodes", log.Error("error", err))
+		return electLeadersResult{topics: electLeadersErrors(topics, protocol.ErrUnknown), err: protocol.ErrUnknown}
+	}
+	results := electLeadersErrors(topics, protocol.ErrNone)
+	var changed []structs.Partition
+	var elected []*protocol.ElectLeadersPartitionResult
+	for i, t := range topics {
+		for j, id := range t.Partitions {
+			res := &results[i].Partitions[j]
+			_, p, err := state.GetPartition(t.Topic, id)
+			if err != nil {
+				b.logger.Error("leader: failed to get partition", log.Error("error", err))
+				res.ErrorCode = protocol.ErrUnknown.Code()
+				continue
+			}
+			if p == nil {
+				res.ErrorCode = protocol.ErrUnknownTopicOrPartition.Code()
+				continue
+			}
+			pp, perr := electLeader(p, r.electionType, passing)
+			if perr != protocol.ErrNone {
+				res.ErrorCode = perr.Code()
+				continue
+			}
+			changed = append(changed, pp)
+			elected = append(elected, res)
+		}
+	}
+	if err := b.updatePartitions(changed); err != nil {
+		b.logger.Error("leader: failed to update elected partitions", log.Error("error", err))
+		for _, res := range elected {
+			res.ErrorCode = protocol.ErrUnknown.Code()
+		}
+	}
+	return electLeadersResult{topics: results, err: protocol.ErrNone}
+}
+
+// electLeader returns the partition with its new leader. Preferred elections move leadership to
+// the partition's first assigned replica if it's in sync. Unclean elections are for partitions
+// whose leader's gone: they elect a passing in-sync replica if there is one and otherwise any
+// passing replica, which becomes the only replica in sync and may have lost messages.
+func electLeader(p *structs.Partition, electionType int8, passing map[int32]bool) (structs.Partition, protocol.Error) {
+	pp := *p
+	switch electionType {
+	case protocol.PreferredElection:
+		if len(p.AR) == 0 {
+			return pp, protocol.ErrPreferredLeaderNotAvailable
+		}
+		preferred := p.AR[0]
+		if p.Leader == preferred {
+			return pp, protocol.ErrElectionNotNeeded
+		}
+		if !passing[preferred] || !containsInt32(p.ISR, preferred) {
+			return pp, protocol.ErrPreferredLeaderNotAvailable
+		}
+		pp.Leader = preferred
+	case protocol.UncleanElection:
+		if passing[p.Leader] && containsInt32(p.ISR, p.Leader) {
+			return pp, protocol.ErrElectionNotNeeded
+		}
+		leader := int32(-1)
+		for _, id := range withoutInt32(p.ISR, p.Leader) {
+			if passing[id] {
+				leader = id
+				pp.ISR = withoutInt32(p.ISR, p.Leader)
+				break
+			}
+		}
+		if leader == -1 {
+			for _, id := range p.AR {
+				if passing[id] {
+					leader = id
+					pp.ISR = []int32{id}
+					break
+				}
+			}
+		}
+		if leader == -1 {
+			return pp, protocol.ErrEligibleLeadersNotAvailable
+		}
+		pp.Leader = leader
+	default:
+		return pp, protocol.ErrInvalidRequest
+	}
+	pp.LeaderEpoch++
+	return pp, protocol.ErrNone
+}
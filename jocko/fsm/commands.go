@@ -15,6 +15,37 @@ func init() {
 	registerCommand(structs.RegisterPartitionRequestType, (*FSM).applyRegisterPartition)
 	registerCommand(structs.DeregisterPartitionRequestType, (*FSM).applyDeregisterPartition)
 	registerCommand(structs.RegisterGroupRequestType, (*FSM).applyRegisterGroup)
+	registerCommand(structs.RegisterConfigRequestType, (*FSM).applyRegisterConfig)
+	registerCommand(structs.CommitOffsetsRequestType, (*FSM).applyCommitOffsets)
+	registerCommand(structs.DeregisterGroupRequestType, (*FSM).applyDeregisterGroup)
+	registerCommand(structs.DeleteOffsetsRequestType, (*FSM).applyDeleteOffsets)
+	registerCommand(structs.AllocateProducerIDsRequestType, (*FSM).applyAllocateProducerIDs)
+	registerCommand(structs.CommitTxnOffsetsRequestType, (*FSM).applyCommitTxnOffsets)
+	registerCommand(structs.CompleteTxnOffsetsRequestType, (*FSM).applyCompleteTxnOffsets)
+	registerCommand(structs.CreateAclsRequestType, (*FSM).applyCreateAcls)
+	registerCommand(structs.DeleteAclsRequestType, (*FSM).applyDeleteAcls)
+	registerCommand(structs.RegisterDelegationTokenRequestType, (*FSM).applyRegisterDelegationToken)
+	registerCommand(structs.DeregisterDelegationTokenRequestType, (*FSM).applyDeregisterDelegationToken)
+	registerCommand(structs.AddBrokerLifecycleEventsRequestType, (*FSM).applyAddBrokerLifecycleEvents)
+	registerCommand(structs.AddConfigChangeRequestType, (*FSM).applyAddConfigChange)
+}
+
+func (c *FSM) applyRegisterConfig(buf []byte, index uint64) interface{} {
+	var req structs.RegisterConfigRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.EnsureConfig(index, &req.Config); err != nil {
+		c.logger.Error("EnsureConfig failed", log.Error("error", err))
+		return err
+	}
+
+	if c.configObserver != nil {
+		c.configObserver(&req.Config)
+	}
+
+	return nil
 }
 
 func (c *FSM) applyRegisterGroup(buf []byte, index uint64) interface{} {
@@ -31,6 +62,180 @@ func (c *FSM) applyRegisterGroup(buf []byte, index uint64) interface{} {
 	return nil
 }
 
+func (c *FSM) applyCommitOffsets(buf []byte, index uint64) interface{} {
+	var req structs.CommitOffsetsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.CommitOffsets(index, req.Group, req.Coordinator, req.Offsets); err != nil {
+		c.logger.Error("CommitOffsets failed", log.Error("error", err))
+		return err
+	}
+
+	return nil
+}
+
+func (c *FSM) applyDeregisterGroup(buf []byte, index uint64) interface{} {
+	var req structs.DeregisterGroupRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.DeleteEmptyGroup(index, req.Group); err != nil {
+		if err != ErrNonEmptyGroup {
+			c.logger.Error("DeleteEmptyGroup failed", log.Error("error", err))
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (c *FSM) applyDeleteOffsets(buf []byte, index uint64) interface{} {
+	var req structs.DeleteOffsetsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.DeleteOffsets(index, req.Group, req.Offsets); err != nil {
+		c.logger.Error("DeleteOffsets failed", log.Error("error", err))
+		return err
+	}
+
+	return nil
+}
+
+func (c *FSM) applyCommitTxnOffsets(buf []byte, index uint64) interface{} {
+	var req structs.CommitTxnOffsetsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.CommitTxnOffsets(index, req.Group, req.Coordinator, req.ProducerID, req.Offsets); err != nil {
+		c.logger.Error("CommitTxnOffsets failed", log.Error("error", err))
+		return err
+	}
+
+	return nil
+}
+
+func (c *FSM) applyCompleteTxnOffsets(buf []byte, index uint64) interface{} {
+	var req structs.CompleteTxnOffsetsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.CompleteTxnOffsets(index, req.Group, req.ProducerID, req.Committed); err != nil {
+		c.logger.Error("CompleteTxnOffsets failed", log.Error("error", err))
+		return err
+	}
+
+	return nil
+}
+
+func (c *FSM) applyAllocateProducerIDs(buf []byte, index uint64) interface{} {
+	var req structs.AllocateProducerIDsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	block, err := c.state.AllocateProducerIDs(index, req.Broker, req.Size)
+	if err != nil {
+		c.logger.Error("AllocateProducerIDs failed", log.Error("error", err))
+		return err
+	}
+
+	return block
+}
+
+func (c *FSM) applyCreateAcls(buf []byte, index uint64) interface{} {
+	var req structs.CreateAclsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.CreateAcls(index, req.Acls); err != nil {
+		c.logger.Error("CreateAcls failed", log.Error("error", err))
+		return err
+	}
+
+	return nil
+}
+
+func (c *FSM) applyDeleteAcls(buf []byte, index uint64) interface{} {
+	var req structs.DeleteAclsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	deleted, err := c.state.DeleteAcls(index, req.Filters)
+	if err != nil {
+		c.logger.Error("DeleteAcls failed", log.Error("error", err))
+		return err
+	}
+
+	return deleted
+}
+
+func (c *FSM) applyRegisterDelegationToken(buf []byte, index uint64) interface{} {
+	var req structs.RegisterDelegationTokenRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.EnsureDelegationToken(index, &req.Token); err != nil {
+		c.logger.Error("EnsureDelegationToken failed", log.Error("error", err))
+		return err
+	}
+
+	return nil
+}
+
+func (c *FSM) applyDeregisterDelegationToken(buf []byte, index uint64) interface{} {
+	var req structs.DeregisterDelegationTokenRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.DeleteDelegationToken(index, req.ID); err != nil {
+		c.logger.Error("DeleteDelegationToken failed", log.Error("error", err))
+		return err
+	}
+
+	return nil
+}
+
+func (c *FSM) applyAddBrokerLifecycleEvents(buf []byte, index uint64) interface{} {
+	var req structs.AddBrokerLifecycleEventsRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	for broker, event := range req.Events {
+		if err := c.state.AddBrokerLifecycleEvent(index, broker, event); err != nil {
+			c.logger.Error("AddBrokerLifecycleEvent failed", log.Error("error", err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *FSM) applyAddConfigChange(buf []byte, index uint64) interface{} {
+	var req structs.AddConfigChangeRequest
+	if err := structs.Decode(buf, &req); err != nil {
+		panic(fmt.Errorf("failed to decode request: %v", err))
+	}
+
+	if err := c.state.AddConfigChange(index, req.Kind, req.Resource, req.Change); err != nil {
+		c.logger.Error("AddConfigChange failed", log.Error("error", err))
+		return err
+	}
+
+	return nil
+}
+
 func (c *FSM) applyRegisterNode(buf []byte, index uint64) interface{} {
 	var req structs.RegisterNodeRequest
 	if err := structs.Decode(buf, &req); err != nil {
@@ -70,6 +275,10 @@ func (c *FSM) applyRegisterTopic(buf []byte, index uint64) interface{} {
 		return err
 	}
 
+	if c.topicObserver != nil {
+		c.topicObserver(&req.Topic)
+	}
+
 	return nil
 }
 
@@ -132,6 +132,44 @@ func TestRegisterGroup(t *testing.T) {
 	}
 }
 
+func TestRegisterConfig(t *testing.T) {
+	fsm, err := New(log.New(), stdopentracing.GlobalTracer())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req := structs.RegisterConfigRequest{
+		Config: structs.Config{
+			ResourceType: structs.BrokerConfigResource,
+			Resource:     "1",
+			Entries:      map[string]string{"log.retention.ms": "1000"},
+		},
+	}
+	buf, err := structs.Encode(structs.RegisterConfigRequestType, req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp := fsm.Apply(makeLog(buf))
+	if resp != nil {
+		t.Fatalf("resp: %v", resp)
+	}
+
+	_, config, err := fsm.state.GetConfig(structs.BrokerConfigResource, "1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if config == nil {
+		t.Fatalf("config not found")
+	}
+	if config.Entries["log.retention.ms"] != "1000" {
+		t.Fatalf("bad entries: %v", config.Entries)
+	}
+	if config.ModifyIndex != 1 {
+		t.Fatalf("bad index: %d", config.ModifyIndex)
+	}
+}
+
 func makeLog(buf []byte) *raft.Log {
 	return &raft.Log{
 		Index: 1,
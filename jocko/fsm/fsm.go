@@ -1,6 +1,7 @@
 package fsm
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -42,6 +43,14 @@ func registerCommand(msg structs.MessageType, fn unboundCommand) {
 type NodeID int32
 type Tracer opentracing.Tracer
 
+// TopicObserver is called with topics after they're registered, so changes to their configs can
+// be applied outside the state store.
+type TopicObserver func(topic *structs.Topic)
+
+// ConfigObserver is called with configs after they're registered, so brokers can act on changes
+// to their dynamic configs.
+type ConfigObserver func(config *structs.Config)
+
 // FSM implements a finite state machine used with Raft to provide strong consistency.
 type FSM struct {
 	logger    log.Logger
@@ -50,18 +59,27 @@ type FSM struct {
 	state     *Store
 	tracer    opentracing.Tracer
 	nodeID    NodeID
+
+	topicObserver  TopicObserver
+	configObserver ConfigObserver
 }
 
 // New returns a new FSM instance.
 func New(logger log.Logger, args ...interface{}) (*FSM, error) {
 	var nodeID NodeID
 	var tracer Tracer
+	var topicObserver TopicObserver
+	var configObserver ConfigObserver
 	for _, arg := range args {
 		switch a := arg.(type) {
 		case NodeID:
 			nodeID = a
 		case Tracer:
 			tracer = a
+		case TopicObserver:
+			topicObserver = a
+		case ConfigObserver:
+			configObserver = a
 		}
 	}
 	store, err := NewStore(logger, tracer, nodeID)
@@ -74,6 +92,9 @@ func New(logger log.Logger, args ...interface{}) (*FSM, error) {
 		state:  store,
 		tracer: tracer,
 		nodeID: nodeID,
+
+		topicObserver:  topicObserver,
+		configObserver: configObserver,
 	}
 	for msg, fn := range commands {
 		thisFn := fn
@@ -95,7 +116,9 @@ func (c *FSM) Apply(l *raft.Log) interface{} {
 	buf := l.Data
 	msgType := structs.MessageType(buf[0])
 	if fn := c.apply[msgType]; fn != nil {
-		return fn(buf[1:], l.Index)
+		result := fn(buf[1:], l.Index)
+		c.State().notifyChange()
+		return result
 	}
 	return nil
 }
@@ -171,8 +194,12 @@ type Store struct {
 	// abandonCh is used to signal watchers this store has been abandoned
 	// (usually during a restore).
 	abandonCh chan struct{}
-	tracer    opentracing.Tracer
-	nodeID    NodeID
+	// changeCh is closed, and replaced, when a raft log is applied to the store, waking the
+	// blocking queries waiting for it to change.
+	changeLock sync.Mutex
+	changeCh   chan struct{}
+	tracer     opentracing.Tracer
+	nodeID     NodeID
 }
 
 func NewStore(logger log.Logger, args ...interface{}) (*Store, error) {
@@ -194,6 +221,7 @@ func NewStore(logger log.Logger, args ...interface{}) (*Store, error) {
 		schema:    dbSchema,
 		db:        db,
 		abandonCh: make(chan struct{}),
+		changeCh:  make(chan struct{}),
 		logger:    logger,
 	}
 	for _, arg := range args {
@@ -219,6 +247,21 @@ func (s *Store) AbandonCh() <-chan struct{} {
 	return s.abandonCh
 }
 
+// ChangeCh returns a channel that's closed the next time a raft log is applied to the store.
+// Get it before reading the store so changes made in between aren't missed.
+func (s *Store) ChangeCh() <-chan struct{} {
+	s.changeLock.Lock()
+	defer s.changeLock.Unlock()
+	return s.changeCh
+}
+
+func (s *Store) notifyChange() {
+	s.changeLock.Lock()
+	defer s.changeLock.Unlock()
+	close(s.changeCh)
+	s.changeCh = make(chan struct{})
+}
+
 // GetNode is used to retrieve a node by node name ID.
 func (s *Store) GetNode(id int32) (uint64, *structs.Node, error) {
 	sp := s.tracer.StartSpan("store: get node")
@@ -501,6 +544,21 @@ func (s *Store) deleteTopicTxn(tx *memdb.Txn, idx uint64, id string) error {
 		s.logger.Error("failed updating index", log.Error("error", err))
 		return err
 	}
+	// delete the topic's partitions along with it
+	it, err := tx.Get("partitions", "topic", topic.(*structs.Topic).Topic)
+	if err != nil {
+		s.logger.Error("failed partitions lookup", log.Error("error", err))
+		return err
+	}
+	var partitions []*structs.Partition
+	for next := it.Next(); next != nil; next = it.Next() {
+		partitions = append(partitions, next.(*structs.Partition))
+	}
+	for _, p := range partitions {
+		if err := s.deletePartitionTxn(tx, idx, p.Topic, p.Partition); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -549,6 +607,198 @@ func (s *Store) ensureGroupTxn(tx *memdb.Txn, idx uint64, group *structs.Group)
 	return nil
 }
 
+// CommitOffsets is used to commit offsets for a group. The group is registered with the
+// coordinator if it doesn't exist, like for consumers that commit without joining it.
+func (s *Store) CommitOffsets(idx uint64, id string, coordinator int32, offsets map[string]map[int32]structs.GroupOffset) error {
+	sp := s.tracer.StartSpan("store: commit offsets")
+	sp.LogKV("group", id)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	existing, err := tx.First("groups", "id", id)
+	if err != nil {
+		return fmt.Errorf("group lookup failed: %s", err)
+	}
+	// copy the group rather than changing the one in the db
+	group := &structs.Group{Group: id, Coordinator: coordinator, State: structs.GroupStateEmpty}
+	if existing != nil {
+		*group = *existing.(*structs.Group)
+	}
+	committed := make(map[string]map[int32]structs.GroupOffset, len(group.Offsets)+len(offsets))
+	for topic, partitions := range group.Offsets {
+		committed[topic] = make(map[int32]structs.GroupOffset, len(partitions))
+		for partition, offset := range partitions {
+			committed[topic][partition] = offset
+		}
+	}
+	for topic, partitions := range offsets {
+		if committed[topic] == nil {
+			committed[topic] = make(map[int32]structs.GroupOffset, len(partitions))
+		}
+		for partition, offset := range partitions {
+			committed[topic][partition] = offset
+		}
+	}
+	group.Offsets = committed
+
+	if err := s.ensureGroupTxn(tx, idx, group); err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+// DeleteOffsets deletes the group's committed offsets of the given topics' partitions, leaving
+// its others and the group itself. Deleting offsets of a group that doesn't exist, or that the
+// group never committed, does nothing.
+func (s *Store) DeleteOffsets(idx uint64, id string, offsets map[string][]int32) error {
+	sp := s.tracer.StartSpan("store: delete offsets")
+	sp.LogKV("group", id)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	existing, err := tx.First("groups", "id", id)
+	if err != nil {
+		return fmt.Errorf("group lookup failed: %s", err)
+	}
+	if existing == nil {
+		return nil
+	}
+	// copy the group rather than changing the one in the db
+	group := *existing.(*structs.Group)
+	committed := make(map[string]map[int32]structs.GroupOffset, len(group.Offsets))
+	for topic, partitions := range group.Offsets {
+		deleted := make(map[int32]bool, len(offsets[topic]))
+		for _, partition := range offsets[topic] {
+			deleted[partition] = true
+		}
+		for partition, offset := range partitions {
+			if deleted[partition] {
+				continue
+			}
+			if committed[topic] == nil {
+				committed[topic] = make(map[int32]structs.GroupOffset, len(partitions))
+			}
+			committed[topic][partition] = offset
+		}
+	}
+	group.Offsets = committed
+
+	if err := s.ensureGroupTxn(tx, idx, &group); err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+// CommitTxnOffsets is used to commit offsets for a group as part of the producer's transaction.
+// They're kept pending, apart from the group's committed offsets, until CompleteTxnOffsets.
+func (s *Store) CommitTxnOffsets(idx uint64, id string, coordinator int32, producerID int64, offsets map[string]map[int32]structs.GroupOffset) error {
+	sp := s.tracer.StartSpan("store: commit txn offsets")
+	sp.LogKV("group", id, "producer id", producerID)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	existing, err := tx.First("groups", "id", id)
+	if err != nil {
+		return fmt.Errorf("group lookup failed: %s", err)
+	}
+	// copy the group rather than changing the one in the db
+	group := &structs.Group{Group: id, Coordinator: coordinator, State: structs.GroupStateEmpty}
+	if existing != nil {
+		*group = *existing.(*structs.Group)
+	}
+	pending := make(map[int64]map[string]map[int32]structs.GroupOffset, len(group.PendingOffsets)+1)
+	for producer, topics := range group.PendingOffsets {
+		pending[producer] = topics
+	}
+	committed := make(map[string]map[int32]structs.GroupOffset, len(pending[producerID])+len(offsets))
+	for topic, partitions := range pending[producerID] {
+		committed[topic] = make(map[int32]structs.GroupOffset, len(partitions))
+		for partition, offset := range partitions {
+			committed[topic][partition] = offset
+		}
+	}
+	for topic, partitions := range offsets {
+		if committed[topic] == nil {
+			committed[topic] = make(map[int32]structs.GroupOffset, len(partitions))
+		}
+		for partition, offset := range partitions {
+			committed[topic][partition] = offset
+		}
+	}
+	pending[producerID] = committed
+	group.PendingOffsets = pending
+
+	if err := s.ensureGroupTxn(tx, idx, group); err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+// CompleteTxnOffsets is used to commit the offsets the producer's transaction committed for the
+// group, or drop them if the transaction was aborted.
+func (s *Store) CompleteTxnOffsets(idx uint64, id string, producerID int64, committed bool) error {
+	sp := s.tracer.StartSpan("store: complete txn offsets")
+	sp.LogKV("group", id, "producer id", producerID, "committed", committed)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	existing, err := tx.First("groups", "id", id)
+	if err != nil {
+		return fmt.Errorf("group lookup failed: %s", err)
+	}
+	if existing == nil {
+		return nil
+	}
+	// copy the group rather than changing the one in the db
+	group := *existing.(*structs.Group)
+	offsets, ok := group.PendingOffsets[producerID]
+	if !ok {
+		return nil
+	}
+	pending := make(map[int64]map[string]map[int32]structs.GroupOffset, len(group.PendingOffsets))
+	for producer, topics := range group.PendingOffsets {
+		if producer != producerID {
+			pending[producer] = topics
+		}
+	}
+	group.PendingOffsets = pending
+	if committed {
+		merged := make(map[string]map[int32]structs.GroupOffset, len(group.Offsets)+len(offsets))
+		for _, o := range []map[string]map[int32]structs.GroupOffset{group.Offsets, offsets} {
+			for topic, partitions := range o {
+				if merged[topic] == nil {
+					merged[topic] = make(map[int32]structs.GroupOffset, len(partitions))
+				}
+				for partition, offset := range partitions {
+					merged[topic][partition] = offset
+				}
+			}
+		}
+		group.Offsets = merged
+	}
+
+	if err := s.ensureGroupTxn(tx, idx, &group); err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
 // GetGroup is used to get groups.
 func (s *Store) GetGroup(id string) (uint64, *structs.Group, error) {
 	sp := s.tracer.StartSpan("store: get group")
@@ -614,6 +864,35 @@ func (s *Store) GetGroupsByCoordinator(coordinator int32) (uint64, []*structs.Gr
 	return idx, groups, nil
 }
 
+// ErrNonEmptyGroup is returned when deleting a group that still has members.
+var ErrNonEmptyGroup = errors.New("group has members")
+
+// DeleteEmptyGroup deletes the group, and with it the group's committed offsets. It fails with
+// ErrNonEmptyGroup if the group still has members.
+func (s *Store) DeleteEmptyGroup(idx uint64, id string) error {
+	sp := s.tracer.StartSpan("store: delete empty group")
+	sp.LogKV("group", id)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	group, err := tx.First("groups", "id", id)
+	if err != nil {
+		return fmt.Errorf("group lookup failed: %s", err)
+	}
+	if group != nil && len(group.(*structs.Group).Members) > 0 {
+		return ErrNonEmptyGroup
+	}
+	if err := s.deleteGroupTxn(tx, idx, id); err != nil {
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
 // DeleteGroup is used to delete groups.
 func (s *Store) DeleteGroup(idx uint64, group string) error {
 	sp := s.tracer.StartSpan("store: delete group")
@@ -652,6 +931,490 @@ func (s *Store) deleteGroupTxn(tx *memdb.Txn, idx uint64, id string) error {
 	return nil
 }
 
+// EnsureConfig is used to register or update a resource's config.
+func (s *Store) EnsureConfig(idx uint64, config *structs.Config) error {
+	sp := s.tracer.StartSpan("store: ensure config")
+	s.vlog(sp, "config", config)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+	if err := s.ensureConfigTxn(tx, idx, config); err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (s *Store) ensureConfigTxn(tx *memdb.Txn, idx uint64, config *structs.Config) error {
+	config.ID = structs.ConfigID(config.ResourceType, config.Resource)
+
+	existing, err := tx.First("configs", "id", config.ID)
+	if err != nil {
+		return fmt.Errorf("config lookup failed: %s", err)
+	}
+
+	if existing != nil {
+		config.CreateIndex = existing.(*structs.Config).CreateIndex
+		config.ModifyIndex = idx
+	} else {
+		config.CreateIndex = idx
+		config.ModifyIndex = idx
+	}
+
+	if err := tx.Insert("configs", config); err != nil {
+		return fmt.Errorf("failed inserting config: %s", err)
+	}
+
+	if err := tx.Insert("index", &IndexEntry{"configs", idx}); err != nil {
+		return fmt.Errorf("failed updating index: %s", err)
+	}
+
+	return nil
+}
+
+// GetConfig is used to get the config set on a resource.
+func (s *Store) GetConfig(resourceType structs.ConfigResourceType, resource string) (uint64, *structs.Config, error) {
+	sp := s.tracer.StartSpan("store: get config")
+	sp.LogKV("resource type", resourceType, "resource", resource)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "configs")
+
+	config, err := tx.First("configs", "id", structs.ConfigID(resourceType, resource))
+	if err != nil {
+		return 0, nil, fmt.Errorf("config lookup failed: %s", err)
+	}
+	if config != nil {
+		return idx, config.(*structs.Config), nil
+	}
+
+	return idx, nil, nil
+}
+
+// AllocateProducerIDs allocates the broker the block of size producer ids following the last
+// block allocated to any broker.
+func (s *Store) AllocateProducerIDs(idx uint64, broker int32, size int64) (*structs.ProducerIDBlock, error) {
+	sp := s.tracer.StartSpan("store: allocate producer ids")
+	sp.LogKV("broker", broker, "size", size)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid producer id block size: %d", size)
+	}
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	it, err := tx.Get("producer_ids", "id")
+	if err != nil {
+		return nil, fmt.Errorf("producer id block lookup failed: %s", err)
+	}
+	block := &structs.ProducerIDBlock{Broker: broker, First: 0}
+	for next := it.Next(); next != nil; next = it.Next() {
+		b := next.(*structs.ProducerIDBlock)
+		if b.Last >= block.First {
+			block.First = b.Last + 1
+		}
+		if b.Broker == broker {
+			block.CreateIndex = b.CreateIndex
+		}
+	}
+	block.Last = block.First + size - 1
+	if block.CreateIndex == 0 {
+		block.CreateIndex = idx
+	}
+	block.ModifyIndex = idx
+
+	if err := tx.Insert("producer_ids", block); err != nil {
+		return nil, fmt.Errorf("failed inserting producer id block: %s", err)
+	}
+	if err := tx.Insert("index", &IndexEntry{"producer_ids", idx}); err != nil {
+		return nil, fmt.Errorf("failed updating index: %s", err)
+	}
+	tx.Commit()
+	return block, nil
+}
+
+// GetProducerIDBlock returns the block of producer ids last allocated to the broker, nil if it
+// hasn't been allocated any.
+func (s *Store) GetProducerIDBlock(broker int32) (uint64, *structs.ProducerIDBlock, error) {
+	sp := s.tracer.StartSpan("store: get producer id block")
+	sp.LogKV("broker", broker)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "producer_ids")
+
+	block, err := tx.First("producer_ids", "id", broker)
+	if err != nil {
+		return 0, nil, fmt.Errorf("producer id block lookup failed: %s", err)
+	}
+	if block != nil {
+		return idx, block.(*structs.ProducerIDBlock), nil
+	}
+
+	return idx, nil, nil
+}
+
+// CreateAcls stores the ACLs, ones already stored are left as they were.
+func (s *Store) CreateAcls(idx uint64, acls []structs.Acl) error {
+	sp := s.tracer.StartSpan("store: create acls")
+	s.vlog(sp, "acls", acls)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	for i := range acls {
+		acl := acls[i]
+		acl.ID = structs.AclID(acl)
+		existing, err := tx.First("acls", "id", acl.ID)
+		if err != nil {
+			return fmt.Errorf("acl lookup failed: %s", err)
+		}
+		if existing != nil {
+			continue
+		}
+		acl.CreateIndex = idx
+		acl.ModifyIndex = idx
+		if err := tx.Insert("acls", &acl); err != nil {
+			return fmt.Errorf("failed inserting acl: %s", err)
+		}
+	}
+	if err := tx.Insert("index", &IndexEntry{"acls", idx}); err != nil {
+		return fmt.Errorf("failed updating index: %s", err)
+	}
+	tx.Commit()
+	return nil
+}
+
+// DeleteAcls deletes the ACLs matching the filters and returns the ACLs each filter matched.
+func (s *Store) DeleteAcls(idx uint64, filters []structs.AclFilter) ([][]*structs.Acl, error) {
+	sp := s.tracer.StartSpan("store: delete acls")
+	s.vlog(sp, "filters", filters)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	acls, err := getAclsTxn(tx)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([][]*structs.Acl, len(filters))
+	for i, filter := range filters {
+		for _, acl := range acls {
+			if filter.Matches(acl) {
+				deleted[i] = append(deleted[i], acl)
+			}
+		}
+	}
+	for _, matched := range deleted {
+		for _, acl := range matched {
+			// an ACL matched by several filters is only deleted once
+			if err := tx.Delete("acls", acl); err != nil && err != memdb.ErrNotFound {
+				return nil, fmt.Errorf("failed deleting acl: %s", err)
+			}
+		}
+	}
+	if err := tx.Insert("index", &IndexEntry{"acls", idx}); err != nil {
+		return nil, fmt.Errorf("failed updating index: %s", err)
+	}
+	tx.Commit()
+	return deleted, nil
+}
+
+// GetAcls returns the ACLs matching the filter.
+func (s *Store) GetAcls(filter structs.AclFilter) (uint64, []*structs.Acl, error) {
+	sp := s.tracer.StartSpan("store: get acls")
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "acls")
+
+	acls, err := getAclsTxn(tx)
+	if err != nil {
+		return 0, nil, err
+	}
+	var matched []*structs.Acl
+	for _, acl := range acls {
+		if filter.Matches(acl) {
+			matched = append(matched, acl)
+		}
+	}
+	return idx, matched, nil
+}
+
+func getAclsTxn(tx *memdb.Txn) ([]*structs.Acl, error) {
+	it, err := tx.Get("acls", "id")
+	if err != nil {
+		return nil, fmt.Errorf("acl lookup failed: %s", err)
+	}
+	var acls []*structs.Acl
+	for next := it.Next(); next != nil; next = it.Next() {
+		acls = append(acls, next.(*structs.Acl))
+	}
+	return acls, nil
+}
+
+// EnsureDelegationToken registers or updates the delegation token.
+func (s *Store) EnsureDelegationToken(idx uint64, token *structs.DelegationToken) error {
+	sp := s.tracer.StartSpan("store: ensure delegation token")
+	sp.LogKV("id", token.ID)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	existing, err := tx.First("delegation_tokens", "id", token.ID)
+	if err != nil {
+		return fmt.Errorf("delegation token lookup failed: %s", err)
+	}
+	if existing != nil {
+		token.CreateIndex = existing.(*structs.DelegationToken).CreateIndex
+		token.ModifyIndex = idx
+	} else {
+		token.CreateIndex = idx
+		token.ModifyIndex = idx
+	}
+	if err := tx.Insert("delegation_tokens", token); err != nil {
+		return fmt.Errorf("failed inserting delegation token: %s", err)
+	}
+	if err := tx.Insert("index", &IndexEntry{"delegation_tokens", idx}); err != nil {
+		return fmt.Errorf("failed updating index: %s", err)
+	}
+	tx.Commit()
+	return nil
+}
+
+// DeleteDelegationToken deletes the delegation token with the ID.
+func (s *Store) DeleteDelegationToken(idx uint64, id string) error {
+	sp := s.tracer.StartSpan("store: delete delegation token")
+	sp.LogKV("id", id)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	token, err := tx.First("delegation_tokens", "id", id)
+	if err != nil {
+		return fmt.Errorf("delegation token lookup failed: %s", err)
+	}
+	if token == nil {
+		return nil
+	}
+	if err := tx.Delete("delegation_tokens", token); err != nil {
+		return fmt.Errorf("failed deleting delegation token: %s", err)
+	}
+	if err := tx.Insert("index", &IndexEntry{"delegation_tokens", idx}); err != nil {
+		return fmt.Errorf("failed updating index: %s", err)
+	}
+	tx.Commit()
+	return nil
+}
+
+// GetDelegationToken returns the delegation token with the ID, nil if there isn't one.
+func (s *Store) GetDelegationToken(id string) (uint64, *structs.DelegationToken, error) {
+	sp := s.tracer.StartSpan("store: get delegation token")
+	sp.LogKV("id", id)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "delegation_tokens")
+
+	token, err := tx.First("delegation_tokens", "id", id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("delegation token lookup failed: %s", err)
+	}
+	if token != nil {
+		return idx, token.(*structs.DelegationToken), nil
+	}
+	return idx, nil, nil
+}
+
+// GetDelegationTokens returns the delegation tokens.
+func (s *Store) GetDelegationTokens() (uint64, []*structs.DelegationToken, error) {
+	sp := s.tracer.StartSpan("store: get delegation tokens")
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "delegation_tokens")
+
+	it, err := tx.Get("delegation_tokens", "id")
+	if err != nil {
+		return 0, nil, fmt.Errorf("delegation token lookup failed: %s", err)
+	}
+	var tokens []*structs.DelegationToken
+	for next := it.Next(); next != nil; next = it.Next() {
+		tokens = append(tokens, next.(*structs.DelegationToken))
+	}
+	return idx, tokens, nil
+}
+
+// brokerLifecycleEventsKept is how many of each broker's last lifecycle events are kept.
+const brokerLifecycleEventsKept = 32
+
+// AddBrokerLifecycleEvent adds the event to the broker's lifecycle history, dropping its oldest
+// event if it's full.
+func (s *Store) AddBrokerLifecycleEvent(idx uint64, broker int32, event structs.BrokerLifecycleEvent) error {
+	sp := s.tracer.StartSpan("store: add broker lifecycle event")
+	sp.LogKV("broker", broker, "type", event.Type)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	existing, err := tx.First("broker_lifecycles", "id", broker)
+	if err != nil {
+		return fmt.Errorf("broker lifecycle lookup failed: %s", err)
+	}
+	lifecycle := &structs.BrokerLifecycle{Broker: broker}
+	lifecycle.CreateIndex = idx
+	if existing != nil {
+		prev := existing.(*structs.BrokerLifecycle)
+		lifecycle.CreateIndex = prev.CreateIndex
+		lifecycle.Events = prev.Events
+		if len(lifecycle.Events) >= brokerLifecycleEventsKept {
+			lifecycle.Events = lifecycle.Events[len(lifecycle.Events)-brokerLifecycleEventsKept+1:]
+		}
+	}
+	// copied so those reading the history it replaces don't see it change
+	lifecycle.Events = append(lifecycle.Events[:len(lifecycle.Events):len(lifecycle.Events)], event)
+	lifecycle.ModifyIndex = idx
+	if err := tx.Insert("broker_lifecycles", lifecycle); err != nil {
+		return fmt.Errorf("failed inserting broker lifecycle: %s", err)
+	}
+	if err := tx.Insert("index", &IndexEntry{"broker_lifecycles", idx}); err != nil {
+		return fmt.Errorf("failed updating index: %s", err)
+	}
+	tx.Commit()
+	return nil
+}
+
+// GetBrokerLifecycle returns the broker's lifecycle history, nil if it has none.
+func (s *Store) GetBrokerLifecycle(broker int32) (uint64, *structs.BrokerLifecycle, error) {
+	sp := s.tracer.StartSpan("store: get broker lifecycle")
+	sp.LogKV("broker", broker)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "broker_lifecycles")
+
+	lifecycle, err := tx.First("broker_lifecycles", "id", broker)
+	if err != nil {
+		return 0, nil, fmt.Errorf("broker lifecycle lookup failed: %s", err)
+	}
+	if lifecycle != nil {
+		return idx, lifecycle.(*structs.BrokerLifecycle), nil
+	}
+	return idx, nil, nil
+}
+
+// GetBrokerLifecycles returns the brokers' lifecycle histories.
+func (s *Store) GetBrokerLifecycles() (uint64, []*structs.BrokerLifecycle, error) {
+	sp := s.tracer.StartSpan("store: get broker lifecycles")
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "broker_lifecycles")
+
+	it, err := tx.Get("broker_lifecycles", "id")
+	if err != nil {
+		return 0, nil, fmt.Errorf("broker lifecycle lookup failed: %s", err)
+	}
+	var lifecycles []*structs.BrokerLifecycle
+	for next := it.Next(); next != nil; next = it.Next() {
+		lifecycles = append(lifecycles, next.(*structs.BrokerLifecycle))
+	}
+	return idx, lifecycles, nil
+}
+
+// configChangesKept is how many of each resource's last config changes are kept.
+const configChangesKept = 100
+
+// AddConfigChange adds the change to the history of the resource's changes, dropping its oldest
+// change if it's full.
+func (s *Store) AddConfigChange(idx uint64, kind, resource string, change structs.ConfigChange) error {
+	sp := s.tracer.StartSpan("store: add config change")
+	sp.LogKV("kind", kind, "resource", resource, "operation", change.Operation)
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	id := structs.ConfigHistoryID(kind, resource)
+	existing, err := tx.First("config_histories", "id", id)
+	if err != nil {
+		return fmt.Errorf("config history lookup failed: %s", err)
+	}
+	history := &structs.ConfigHistory{ID: id, Kind: kind, Resource: resource}
+	history.CreateIndex = idx
+	if existing != nil {
+		prev := existing.(*structs.ConfigHistory)
+		history.CreateIndex = prev.CreateIndex
+		history.Changes = prev.Changes
+		if len(history.Changes) >= configChangesKept {
+			history.Changes = history.Changes[len(history.Changes)-configChangesKept+1:]
+		}
+	}
+	// copied so those reading the history it replaces don't see it change
+	history.Changes = append(history.Changes[:len(history.Changes):len(history.Changes)], change)
+	history.ModifyIndex = idx
+	if err := tx.Insert("config_histories", history); err != nil {
+		return fmt.Errorf("failed inserting config history: %s", err)
+	}
+	if err := tx.Insert("index", &IndexEntry{"config_histories", idx}); err != nil {
+		return fmt.Errorf("failed updating index: %s", err)
+	}
+	tx.Commit()
+	return nil
+}
+
+// GetConfigHistories returns the histories of the resources' config changes and of the ACLs'.
+func (s *Store) GetConfigHistories() (uint64, []*structs.ConfigHistory, error) {
+	sp := s.tracer.StartSpan("store: get config histories")
+	sp.SetTag("node id", s.nodeID)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "config_histories")
+
+	it, err := tx.Get("config_histories", "id")
+	if err != nil {
+		return 0, nil, fmt.Errorf("config history lookup failed: %s", err)
+	}
+	var histories []*structs.ConfigHistory
+	for next := it.Next(); next != nil; next = it.Next() {
+		histories = append(histories, next.(*structs.ConfigHistory))
+	}
+	return idx, histories, nil
+}
+
 func (s *Store) EnsurePartition(idx uint64, partition *structs.Partition) error {
 	sp := s.tracer.StartSpan("store: ensure partition")
 	s.vlog(sp, "partition", partition)
@@ -739,6 +1502,26 @@ func (s *Store) PartitionsByLeader(leader int32) (uint64, []*structs.Partition,
 	return idx, partitions, nil
 }
 
+// PartitionsByTopic is used to return all partitions for the given topic.
+func (s *Store) PartitionsByTopic(topic string) (uint64, []*structs.Partition, error) {
+	sp := s.tracer.StartSpan("store: partitions by topic")
+	sp.SetTag("topic", topic)
+	defer sp.Finish()
+
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+	idx := maxIndexTxn(tx, "partitions")
+	it, err := tx.Get("partitions", "topic", topic)
+	if err != nil {
+		return 0, nil, err
+	}
+	var partitions []*structs.Partition
+	for next := it.Next(); next != nil; next = it.Next() {
+		partitions = append(partitions, next.(*structs.Partition))
+	}
+	return idx, partitions, nil
+}
+
 func (s *Store) GetPartitions() (uint64, []*structs.Partition, error) {
 	sp := s.tracer.StartSpan("store: get partitions")
 	defer sp.Finish()
@@ -796,6 +1579,11 @@ func (s *Store) deletePartitionTxn(tx *memdb.Txn, idx uint64, topic string, id i
 }
 
 // maxIndex is a helper used to retrieve the highest known index amongst a set of tables in the db.
+// MaxIndex returns the raft index the tables were last changed at.
+func (s *Store) MaxIndex(tables ...string) uint64 {
+	return s.maxIndex(tables...)
+}
+
 func (s *Store) maxIndex(tables ...string) uint64 {
 	tx := s.db.Txn(false)
 	defer tx.Abort()
@@ -1013,12 +1801,122 @@ func groupTableSchema() *memdb.TableSchema {
 	}
 }
 
+func configsTableSchema() *memdb.TableSchema {
+	return &memdb.TableSchema{
+		Name: "configs",
+		Indexes: map[string]*memdb.IndexSchema{
+			"id": &memdb.IndexSchema{
+				Name:         "id",
+				AllowMissing: false,
+				Unique:       true,
+				Indexer: &memdb.StringFieldIndex{
+					Field: "ID",
+				},
+			},
+		},
+	}
+}
+
+// producerIDsTableSchema returns a new table schema used for storing the blocks of producer ids
+// allocated to brokers.
+func producerIDsTableSchema() *memdb.TableSchema {
+	return &memdb.TableSchema{
+		Name: "producer_ids",
+		Indexes: map[string]*memdb.IndexSchema{
+			"id": &memdb.IndexSchema{
+				Name:         "id",
+				AllowMissing: false,
+				Unique:       true,
+				Indexer: &IntFieldIndex{
+					Field: "Broker",
+				},
+			},
+		},
+	}
+}
+
+// aclsTableSchema returns a new table schema used for storing ACLs.
+func aclsTableSchema() *memdb.TableSchema {
+	return &memdb.TableSchema{
+		Name: "acls",
+		Indexes: map[string]*memdb.IndexSchema{
+			"id": &memdb.IndexSchema{
+				Name:         "id",
+				AllowMissing: false,
+				Unique:       true,
+				Indexer: &memdb.StringFieldIndex{
+					Field: "ID",
+				},
+			},
+		},
+	}
+}
+
+// delegationTokensTableSchema returns a new table schema used for storing delegation tokens.
+func delegationTokensTableSchema() *memdb.TableSchema {
+	return &memdb.TableSchema{
+		Name: "delegation_tokens",
+		Indexes: map[string]*memdb.IndexSchema{
+			"id": &memdb.IndexSchema{
+				Name:         "id",
+				AllowMissing: false,
+				Unique:       true,
+				Indexer: &memdb.StringFieldIndex{
+					Field: "ID",
+				},
+			},
+		},
+	}
+}
+
+// brokerLifecyclesTableSchema returns a new table schema used for storing the brokers'
+// lifecycle histories.
+func brokerLifecyclesTableSchema() *memdb.TableSchema {
+	return &memdb.TableSchema{
+		Name: "broker_lifecycles",
+		Indexes: map[string]*memdb.IndexSchema{
+			"id": &memdb.IndexSchema{
+				Name:         "id",
+				AllowMissing: false,
+				Unique:       true,
+				Indexer: &IntFieldIndex{
+					Field: "Broker",
+				},
+			},
+		},
+	}
+}
+
+// configHistoriesTableSchema returns a new table schema used for storing the histories of
+// config and ACL changes.
+func configHistoriesTableSchema() *memdb.TableSchema {
+	return &memdb.TableSchema{
+		Name: "config_histories",
+		Indexes: map[string]*memdb.IndexSchema{
+			"id": &memdb.IndexSchema{
+				Name:         "id",
+				AllowMissing: false,
+				Unique:       true,
+				Indexer: &memdb.StringFieldIndex{
+					Field: "ID",
+				},
+			},
+		},
+	}
+}
+
 func init() {
 	registerSchema(indexTableSchema)
 	registerSchema(nodesTableSchema)
 	registerSchema(topicsTableSchema)
 	registerSchema(partitionsTableSchema)
 	registerSchema(groupTableSchema)
+	registerSchema(configsTableSchema)
+	registerSchema(producerIDsTableSchema)
+	registerSchema(aclsTableSchema)
+	registerSchema(delegationTokensTableSchema)
+	registerSchema(brokerLifecyclesTableSchema)
+	registerSchema(configHistoriesTableSchema)
 
 	e := os.Getenv("JOCKODEBUG")
 	if strings.Contains(e, "fsm=1") {
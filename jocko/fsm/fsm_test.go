@@ -1,8 +1,10 @@
 package fsm
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	stdopentracing "github.com/opentracing/opentracing-go"
 	"github.com/travisjeffery/jocko/jocko/structs"
@@ -61,6 +63,28 @@ func TestStore_Abandon(t *testing.T) {
 	}
 }
 
+func TestStore_ChangeCh(t *testing.T) {
+	s := testStore(t)
+	changeCh := s.ChangeCh()
+	select {
+	case <-changeCh:
+		t.Fatalf("bad")
+	default:
+	}
+	s.notifyChange()
+	select {
+	case <-changeCh:
+	default:
+		t.Fatalf("bad")
+	}
+	// the next change gets a new channel
+	select {
+	case <-s.ChangeCh():
+		t.Fatalf("bad")
+	default:
+	}
+}
+
 func TestStore_DeleteNode(t *testing.T) {
 	s := testStore(t)
 
@@ -136,6 +160,34 @@ func TestStore_RegisterTopic(t *testing.T) {
 	}
 }
 
+func TestStore_DeleteTopicPartitions(t *testing.T) {
+	s := testStore(t)
+
+	testRegisterTopic(t, s, 0, "topic1")
+	testRegisterPartition(t, s, 1, 0, "topic1")
+	testRegisterPartition(t, s, 2, 1, "topic1")
+	testRegisterPartition(t, s, 3, 0, "topic2")
+
+	if _, ps, err := s.PartitionsByTopic("topic1"); err != nil || len(ps) != 2 {
+		t.Fatalf("bad: %#v (err: %s)", ps, err)
+	}
+
+	// delete the topic
+	if err := s.DeleteTopic(4, "topic1"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// check its partitions are gone
+	if idx, ps, err := s.PartitionsByTopic("topic1"); err != nil || len(ps) != 0 || idx != 4 {
+		t.Fatalf("bad: %#v %d (err: %s)", ps, idx, err)
+	}
+
+	// check other topics' partitions are left alone
+	if _, p, err := s.GetPartition("topic2", 0); err != nil || p == nil {
+		t.Fatalf("err: %s, partition: %v", err, p)
+	}
+}
+
 func testRegisterTopic(t *testing.T, s *Store, idx uint64, id string) {
 	if err := s.EnsureTopic(idx, &structs.Topic{Topic: id}); err != nil {
 		t.Fatalf("err: %s", err)
@@ -260,6 +312,342 @@ func TestStore_RegisterGroup(t *testing.T) {
 	}
 }
 
+func TestStore_DeleteEmptyGroup(t *testing.T) {
+	s := testStore(t)
+
+	if err := s.EnsureGroup(1, &structs.Group{Group: "test-group", Coordinator: coordinator, Members: map[string]structs.Member{"member": {ID: "member"}}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.DeleteEmptyGroup(2, "test-group"); err != ErrNonEmptyGroup {
+		t.Fatalf("err: %v", err)
+	}
+	if _, g, err := s.GetGroup("test-group"); err != nil || g == nil {
+		t.Fatalf("err: %s, group: %v", err, g)
+	}
+
+	// the group's offsets are deleted with it
+	if err := s.EnsureGroup(3, &structs.Group{Group: "test-group", Coordinator: coordinator}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.CommitOffsets(4, "test-group", coordinator, map[string]map[int32]structs.GroupOffset{
+		"test-topic": {0: {Offset: 1}},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.DeleteEmptyGroup(5, "test-group"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if idx, g, err := s.GetGroup("test-group"); err != nil || g != nil || idx != 5 {
+		t.Fatalf("bad: %#v %d (err: %s)", g, idx, err)
+	}
+}
+
+func TestStore_AllocateProducerIDs(t *testing.T) {
+	s := testStore(t)
+
+	if _, block, err := s.GetProducerIDBlock(1); err != nil || block != nil {
+		t.Fatalf("err: %s, block: %v", err, block)
+	}
+	// blocks follow the last allocated to any broker
+	for i, exp := range []structs.ProducerIDBlock{
+		{Broker: 1, First: 0, Last: 9},
+		{Broker: 2, First: 10, Last: 19},
+		{Broker: 1, First: 20, Last: 29},
+	} {
+		block, err := s.AllocateProducerIDs(uint64(i+1), exp.Broker, 10)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if block.Broker != exp.Broker || block.First != exp.First || block.Last != exp.Last {
+			t.Fatalf("bad block: %#v, want: %#v", block, exp)
+		}
+	}
+	idx, block, err := s.GetProducerIDBlock(1)
+	if err != nil || block == nil || block.First != 20 || block.CreateIndex != 1 || block.ModifyIndex != 3 || idx != 3 {
+		t.Fatalf("err: %s, block: %#v, idx: %d", err, block, idx)
+	}
+	if _, err := s.AllocateProducerIDs(4, 1, 0); err == nil {
+		t.Fatal("expected error for empty block")
+	}
+}
+
+func TestStore_DeleteOffsets(t *testing.T) {
+	s := testStore(t)
+
+	if err := s.CommitOffsets(1, "test-group", coordinator, map[string]map[int32]structs.GroupOffset{
+		"test-topic":    {0: {Offset: 1}, 1: {Offset: 2}},
+		"another-topic": {0: {Offset: 3}},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.DeleteOffsets(2, "test-group", map[string][]int32{"test-topic": {1, 2}, "another-topic": {0}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	idx, g, err := s.GetGroup("test-group")
+	if err != nil || g == nil || idx != 2 {
+		t.Fatalf("err: %s, group: %v", err, g)
+	}
+	if !reflect.DeepEqual(g.Offsets, map[string]map[int32]structs.GroupOffset{"test-topic": {0: {Offset: 1}}}) {
+		t.Fatalf("bad offsets: %v", g.Offsets)
+	}
+
+	// deleting a missing group's offsets does nothing
+	if err := s.DeleteOffsets(3, "unknown-group", map[string][]int32{"test-topic": {0}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, g, err := s.GetGroup("unknown-group"); err != nil || g != nil {
+		t.Fatalf("err: %s, group: %v", err, g)
+	}
+}
+
+func TestStore_TxnOffsets(t *testing.T) {
+	s := testStore(t)
+
+	if err := s.CommitOffsets(1, "test-group", coordinator, map[string]map[int32]structs.GroupOffset{
+		"test-topic": {0: {Offset: 1}, 1: {Offset: 2}},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// pending offsets aren't committed until the transactions end
+	if err := s.CommitTxnOffsets(2, "test-group", coordinator, 7, map[string]map[int32]structs.GroupOffset{
+		"test-topic": {0: {Offset: 10}},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.CommitTxnOffsets(3, "test-group", coordinator, 7, map[string]map[int32]structs.GroupOffset{
+		"another-topic": {0: {Offset: 20}},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.CommitTxnOffsets(4, "test-group", coordinator, 8, map[string]map[int32]structs.GroupOffset{
+		"test-topic": {1: {Offset: 30}},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, g, err := s.GetGroup("test-group")
+	if err != nil || g == nil {
+		t.Fatalf("err: %s, group: %v", err, g)
+	}
+	if !reflect.DeepEqual(g.Offsets, map[string]map[int32]structs.GroupOffset{"test-topic": {0: {Offset: 1}, 1: {Offset: 2}}}) {
+		t.Fatalf("bad offsets: %v", g.Offsets)
+	}
+	if !reflect.DeepEqual(g.PendingOffsets[7], map[string]map[int32]structs.GroupOffset{"test-topic": {0: {Offset: 10}}, "another-topic": {0: {Offset: 20}}}) {
+		t.Fatalf("bad pending offsets: %v", g.PendingOffsets)
+	}
+
+	// committing the transaction commits its offsets, aborting drops them
+	if err := s.CompleteTxnOffsets(5, "test-group", 7, true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.CompleteTxnOffsets(6, "test-group", 8, false); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	idx, g, err := s.GetGroup("test-group")
+	if err != nil || g == nil || idx != 6 {
+		t.Fatalf("err: %s, group: %v", err, g)
+	}
+	if !reflect.DeepEqual(g.Offsets, map[string]map[int32]structs.GroupOffset{"test-topic": {0: {Offset: 10}, 1: {Offset: 2}}, "another-topic": {0: {Offset: 20}}}) {
+		t.Fatalf("bad offsets: %v", g.Offsets)
+	}
+	if len(g.PendingOffsets) != 0 {
+		t.Fatalf("bad pending offsets: %v", g.PendingOffsets)
+	}
+}
+
+func TestStore_CommitOffsets(t *testing.T) {
+	s := testStore(t)
+
+	// committing registers the group
+	if err := s.CommitOffsets(1, "test-group", coordinator, map[string]map[int32]structs.GroupOffset{
+		"test-topic": {0: {Offset: 1}, 1: {Offset: 2}},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, g, err := s.GetGroup("test-group")
+	if err != nil || g == nil || g.Coordinator != coordinator || g.Offsets["test-topic"][1].Offset != 2 {
+		t.Fatalf("err: %s, group: %v", err, g)
+	}
+
+	// committing again keeps the other partitions' offsets and the group's members
+	if err := s.EnsureGroup(2, &structs.Group{Group: "test-group", Coordinator: coordinator, Members: map[string]structs.Member{"member": {ID: "member"}}, Offsets: g.Offsets}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.CommitOffsets(3, "test-group", coordinator, map[string]map[int32]structs.GroupOffset{
+		"test-topic": {1: {Offset: 5}},
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, committed, err := s.GetGroup("test-group")
+	if err != nil || committed.Offsets["test-topic"][0].Offset != 1 || committed.Offsets["test-topic"][1].Offset != 5 || len(committed.Members) != 1 {
+		t.Fatalf("err: %s, group: %v", err, committed)
+	}
+	// the group read before the commit isn't changed
+	if g.Offsets["test-topic"][1].Offset != 2 {
+		t.Fatalf("group changed in place: %v", g)
+	}
+	if idx := s.maxIndex("groups"); idx != 3 {
+		t.Fatalf("err: %d", idx)
+	}
+}
+
+func TestStore_RegisterConfig(t *testing.T) {
+	s := testStore(t)
+
+	if _, c, err := s.GetConfig(structs.BrokerConfigResource, "1"); err != nil || c != nil {
+		t.Fatalf("err: %s, config: %v", err, c)
+	}
+
+	if err := s.EnsureConfig(1, &structs.Config{ResourceType: structs.BrokerConfigResource, Resource: "1", Entries: map[string]string{"log.retention.ms": "1000"}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// configs are keyed by resource type and name
+	if _, c, err := s.GetConfig(structs.TopicConfigResource, "1"); err != nil || c != nil {
+		t.Fatalf("err: %s, config: %v", err, c)
+	}
+
+	if err := s.EnsureConfig(2, &structs.Config{ResourceType: structs.BrokerConfigResource, Resource: "1", Entries: map[string]string{"log.retention.ms": "2000"}}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if idx, c, err := s.GetConfig(structs.BrokerConfigResource, "1"); err != nil || c == nil || c.Entries["log.retention.ms"] != "2000" || c.CreateIndex != 1 || c.ModifyIndex != 2 || idx != 2 {
+		t.Fatalf("err: %s, config: %v", err, c)
+	}
+}
+
+func TestStore_Acls(t *testing.T) {
+	s := testStore(t)
+
+	literal := structs.Acl{ResourceType: 2, ResourceName: "the-topic", PatternType: structs.AclPatternLiteral, Principal: "User:alice", Host: "*", Operation: 3, PermissionType: 3}
+	prefixed := structs.Acl{ResourceType: 2, ResourceName: "the-", PatternType: structs.AclPatternPrefixed, Principal: "User:bob", Host: "*", Operation: 4, PermissionType: 3}
+	// creating an ACL twice stores it once
+	if err := s.CreateAcls(1, []structs.Acl{literal, prefixed, literal}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	name := "the-topic"
+	idx, acls, err := s.GetAcls(structs.AclFilter{ResourceType: 2, ResourceName: &name, PatternType: structs.AclPatternMatch, Operation: structs.AclAny, PermissionType: structs.AclAny})
+	if err != nil || len(acls) != 2 || idx != 1 {
+		t.Fatalf("err: %s, acls: %v, idx: %d", err, acls, idx)
+	}
+	_, acls, err = s.GetAcls(structs.AclFilter{ResourceType: 2, ResourceName: &name, PatternType: structs.AclPatternLiteral, Operation: structs.AclAny, PermissionType: structs.AclAny})
+	if err != nil || len(acls) != 1 || acls[0].Principal != "User:alice" || acls[0].CreateIndex != 1 {
+		t.Fatalf("err: %s, acls: %v", err, acls)
+	}
+
+	principal := "User:bob"
+	deleted, err := s.DeleteAcls(2, []structs.AclFilter{{ResourceType: structs.AclAny, PatternType: structs.AclAny, Principal: &principal, Operation: structs.AclAny, PermissionType: structs.AclAny}})
+	if err != nil || len(deleted) != 1 || len(deleted[0]) != 1 || deleted[0][0].ResourceName != "the-" {
+		t.Fatalf("err: %s, deleted: %v", err, deleted)
+	}
+	idx, acls, err = s.GetAcls(structs.AclFilter{ResourceType: structs.AclAny, PatternType: structs.AclAny, Operation: structs.AclAny, PermissionType: structs.AclAny})
+	if err != nil || len(acls) != 1 || idx != 2 {
+		t.Fatalf("err: %s, acls: %v, idx: %d", err, acls, idx)
+	}
+}
+
+func TestStore_DelegationTokens(t *testing.T) {
+	s := testStore(t)
+
+	if _, token, err := s.GetDelegationToken("the-token"); err != nil || token != nil {
+		t.Fatalf("err: %s, token: %v", err, token)
+	}
+	issued := time.Now()
+	token := &structs.DelegationToken{ID: "the-token", Owner: "alice", HMAC: []byte("hmac"), IssueTime: issued, ExpiryTime: issued.Add(time.Hour), MaxTime: issued.Add(24 * time.Hour)}
+	if err := s.EnsureDelegationToken(1, token); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	renewed := *token
+	renewed.ExpiryTime = issued.Add(2 * time.Hour)
+	if err := s.EnsureDelegationToken(2, &renewed); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	idx, got, err := s.GetDelegationToken("the-token")
+	if err != nil || got == nil || !got.ExpiryTime.Equal(renewed.ExpiryTime) || got.CreateIndex != 1 || got.ModifyIndex != 2 || idx != 2 {
+		t.Fatalf("err: %s, token: %v, idx: %d", err, got, idx)
+	}
+
+	if err := s.DeleteDelegationToken(3, "the-token"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if idx, tokens, err := s.GetDelegationTokens(); err != nil || len(tokens) != 0 || idx != 3 {
+		t.Fatalf("err: %s, tokens: %v, idx: %d", err, tokens, idx)
+	}
+}
+
+func TestStore_BrokerLifecycles(t *testing.T) {
+	s := testStore(t)
+
+	if _, lifecycle, err := s.GetBrokerLifecycle(1); err != nil || lifecycle != nil {
+		t.Fatalf("err: %s, lifecycle: %v", err, lifecycle)
+	}
+	started := time.Now()
+	if err := s.AddBrokerLifecycleEvent(1, 1, structs.BrokerLifecycleEvent{Type: structs.BrokerStarted, Time: started, Version: "1.0.0"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.AddBrokerLifecycleEvent(2, 1, structs.BrokerLifecycleEvent{Type: structs.BrokerFailed, Time: started.Add(time.Minute)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	idx, lifecycle, err := s.GetBrokerLifecycle(1)
+	if err != nil || lifecycle == nil || len(lifecycle.Events) != 2 || lifecycle.Events[1].Type != structs.BrokerFailed || lifecycle.CreateIndex != 1 || lifecycle.ModifyIndex != 2 || idx != 2 {
+		t.Fatalf("err: %s, lifecycle: %v, idx: %d", err, lifecycle, idx)
+	}
+
+	// the oldest events are dropped once the history's full
+	for i := 0; i < brokerLifecycleEventsKept; i++ {
+		if err := s.AddBrokerLifecycleEvent(uint64(3+i), 2, structs.BrokerLifecycleEvent{Type: structs.BrokerStarted, Time: started.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := s.AddBrokerLifecycleEvent(100, 2, structs.BrokerLifecycleEvent{Type: structs.BrokerLeft}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, lifecycles, err := s.GetBrokerLifecycles()
+	if err != nil || len(lifecycles) != 2 {
+		t.Fatalf("err: %s, lifecycles: %v", err, lifecycles)
+	}
+	events := lifecycles[1].Events
+	if len(events) != brokerLifecycleEventsKept || !events[0].Time.Equal(started.Add(time.Second)) || events[len(events)-1].Type != structs.BrokerLeft {
+		t.Fatalf("events: %v", events)
+	}
+}
+
+func TestStore_ConfigHistories(t *testing.T) {
+	s := testStore(t)
+
+	before, after := "1000", "2000"
+	change := structs.ConfigChange{Principal: "User:alice", Operation: "AlterConfigs", Entries: []structs.ConfigChangeEntry{{Name: "retention.ms", Before: &before, After: &after}}}
+	if err := s.AddConfigChange(1, structs.TopicConfigChange, "the-topic", change); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// the oldest changes are dropped once the history's full
+	for i := 0; i < configChangesKept; i++ {
+		if err := s.AddConfigChange(uint64(2+i), structs.BrokerConfigChange, "", structs.ConfigChange{Operation: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	idx, histories, err := s.GetConfigHistories()
+	if err != nil || len(histories) != 2 || idx != uint64(1+configChangesKept) {
+		t.Fatalf("err: %s, histories: %v, idx: %d", err, histories, idx)
+	}
+	if h := histories[0]; h.ID != "broker/" || len(h.Changes) != configChangesKept || h.Changes[0].Operation != "0" || h.CreateIndex != 2 {
+		t.Fatalf("history: %v", h)
+	}
+	if err := s.AddConfigChange(200, structs.BrokerConfigChange, "", structs.ConfigChange{Operation: "last"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, histories, err = s.GetConfigHistories()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if h := histories[0]; len(h.Changes) != configChangesKept || h.Changes[0].Operation != "1" || h.Changes[configChangesKept-1].Operation != "last" || h.ModifyIndex != 200 {
+		t.Fatalf("history: %v", h)
+	}
+	if h := histories[1]; h.Kind != structs.TopicConfigChange || h.Resource != "the-topic" || !reflect.DeepEqual(h.Changes, []structs.ConfigChange{change}) {
+		t.Fatalf("history: %v", h)
+	}
+}
+
 const (
 	coordinator = int32(1)
 )
@@ -49,8 +49,14 @@ func registerRestorer(msg structs.MessageType, fn restorer) {
 	restorers[msg] = fn
 }
 
-// msgpackHandle is a shared handle for encoding/decoding msgpack payloads
-var msgpackHandle = &codec.MsgpackHandle{}
+// msgpackHandle is a shared handle for encoding/decoding msgpack payloads. It
+// decodes raw strings as strings so interface{} values, like topic config values, keep
+// their types.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.RawToString = true
+	return h
+}()
 
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
 	header := snapshotHeader{
@@ -0,0 +1,93 @@
+package jocko
+
+import (
+	"sort"
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+)
+
+// partitionLag is how far a group's committed offset is behind a partition's high watermark.
+type partitionLag struct {
+	Topic           string `json:"topic"`
+	Partition       int32  `json:"partition"`
+	CommittedOffset int64  `json:"committed_offset"`
+	HighWatermark   int64  `json:"high_watermark"`
+	Lag             int64  `json:"lag"`
+}
+
+// groupLag is a group's lag on the partitions a broker leads.
+type groupLag struct {
+	Group string `json:"group"`
+	// Lag is the sum of the group's lag on the partitions.
+	Lag        int64          `json:"lag"`
+	Partitions []partitionLag `json:"partitions"`
+}
+
+// groupLags returns the lag of the groups, or of every group if there are none, on the
+// partitions they've committed offsets for that this broker leads, since it's their leaders that
+// know their high watermarks. Every broker has the groups' offsets, so a group's whole lag is
+// the sum of what each broker returns. Groups without offsets on the partitions are left out.
+func (b *Broker) groupLags(names []string) ([]groupLag, error) {
+	var groups []*structs.Group
+	if len(names) == 0 {
+		_, all, err := b.fsm.State().GetGroups()
+		if err != nil {
+			return nil, err
+		}
+		groups = all
+	}
+	for _, name := range names {
+		_, group, err := b.fsm.State().GetGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		if group != nil {
+			groups = append(groups, group)
+		}
+	}
+
+	now := time.Now()
+	var lags []groupLag
+	for _, group := range groups {
+		gl := groupLag{Group: group.Group}
+		for topic, partitions := range group.Offsets {
+			for partition, committed := range partitions {
+				if b.offsetExpired(group, committed, now) {
+					continue
+				}
+				replica, err := b.replicaLookup.Replica(topic, partition)
+				if err != nil || replica == nil || replica.Partition.Leader != b.config.ID || replica.Log == nil {
+					continue
+				}
+				hw := b.followers.highWatermark(replica.Partition, replica.Log.NewestOffset())
+				lag := hw - committed.Offset
+				// the committed offset can be past the high watermark, like if the
+				// group committed offsets it was reset to
+				if lag < 0 {
+					lag = 0
+				}
+				gl.Partitions = append(gl.Partitions, partitionLag{
+					Topic:           topic,
+					Partition:       partition,
+					CommittedOffset: committed.Offset,
+					HighWatermark:   hw,
+					Lag:             lag,
+				})
+				gl.Lag += lag
+			}
+		}
+		if len(gl.Partitions) == 0 {
+			continue
+		}
+		sort.Slice(gl.Partitions, func(i, j int) bool {
+			if gl.Partitions[i].Topic != gl.Partitions[j].Topic {
+				return gl.Partitions[i].Topic < gl.Partitions[j].Topic
+			}
+			return gl.Partitions[i].Partition < gl.Partitions[j].Partition
+		})
+		lags = append(lags, gl)
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].Group < lags[j].Group })
+	return lags, nil
+}
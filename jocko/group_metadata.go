@@ -0,0 +1,362 @@
+package jocko
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/jocko/util"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// The offsets topic, OffsetsTopicName, is the compacted internal topic group coordinators
+// persist their groups' metadata and committed offsets in. A group's coordinator is the leader of
+// the partition its id hashes to, which writes the group's changes to the partition before
+// applying them to the store, and loads the partition's groups when it becomes its leader so
+// their coordinator moves with the partition when its leader fails.
+//
+// Each record is a v1 message keyed by a JSON groupRecordKey. The group's metadata record's value
+// is a JSON groupMetadata and its offset records' values are JSON structs.GroupOffsets. Deleting
+// a group or its offsets writes tombstones, records with null values, so compaction removes
+// them. Offsets committed in transactions are kept in the store only.
+const (
+	offsetsTopicNumPartitions = 50
+	// offsetsTopicReplicationFactor is the most replicas the offsets topic's created with.
+	offsetsTopicReplicationFactor = 3
+)
+
+// groupRecordKey is the key of a group's records in the offsets topic.
+type groupRecordKey struct {
+	Group string `json:"group"`
+	// Topic and Partition are set in the keys of offset records, unset in the group metadata
+	// record's.
+	Topic     string `json:"topic,omitempty"`
+	Partition int32  `json:"partition,omitempty"`
+}
+
+// groupMetadata is the value of a group's metadata record in the offsets topic.
+type groupMetadata struct {
+	State        string                    `json:"state"`
+	ProtocolType string                    `json:"protocol_type"`
+	Protocol     string                    `json:"protocol"`
+	LeaderID     string                    `json:"leader_id"`
+	Members      map[string]structs.Member `json:"members"`
+}
+
+// groupPartitions tracks the offsets topic partitions whose groups this broker's loaded.
+type groupPartitions struct {
+	mu     sync.Mutex
+	loaded map[int32]bool
+}
+
+func newGroupPartitions() *groupPartitions {
+	return &groupPartitions{loaded: make(map[int32]bool)}
+}
+
+func (g *groupPartitions) load(partition int32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.loaded[partition] = true
+}
+
+// unload forgets the partition was loaded once this broker stops leading it.
+func (g *groupPartitions) unload(partition int32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.loaded, partition)
+}
+
+func (g *groupPartitions) isLoaded(partition int32) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.loaded[partition]
+}
+
+// offsetsPartition returns the partition of the offsets topic the group hashes to.
+func offsetsPartition(group string, partitions int) int32 {
+	return int32(util.Hash(group) % uint64(partitions))
+}
+
+// offsetsTopic returns the offsets topic, creating it if it doesn't exist.
+func (b *Broker) offsetsTopic(ctx *Context) (*structs.Topic, error) {
+	state := b.fsm.State()
+	_, topic, err := state.GetTopic(OffsetsTopicName)
+	if err != nil || topic != nil {
+		return topic, err
+	}
+	replicationFactor := len(b.LANMembers())
+	if replicationFactor > offsetsTopicReplicationFactor {
+		replicationFactor = offsetsTopicReplicationFactor
+	}
+	ps := b.buildPartitions(OffsetsTopicName, offsetsTopicNumPartitions, int16(replicationFactor))
+	topic = &structs.Topic{
+		Topic:      OffsetsTopicName,
+		Partitions: make(map[int32][]int32, len(ps)),
+		Config:     structs.NewTopicConfig().SetValue("cleanup.policy", commitlog.CompactCleanupPolicy),
+	}
+	for _, p := range ps {
+		topic.Partitions[p.ID] = p.AR
+	}
+	if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: *topic}); err != nil {
+		return nil, err
+	}
+	for _, p := range ps {
+		if err := b.createPartition(p); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.sendLeaderAndISR(ctx, ps); err != protocol.ErrNone {
+		return nil, err
+	}
+	return topic, nil
+}
+
+// groupReplica returns the replica of the group's offsets partition, erroring unless this broker
+// leads it and has loaded its groups. It returns nil if the offsets topic doesn't exist yet, it's
+// created when clients find their group's coordinator, so there's nothing to persist to.
+func (b *Broker) groupReplica(group string) (*Replica, protocol.Error) {
+	_, topic, err := b.fsm.State().GetTopic(OffsetsTopicName)
+	if err != nil {
+		return nil, protocol.ErrUnknown.WithErr(err)
+	}
+	if topic == nil {
+		return nil, protocol.ErrNone
+	}
+	partition := offsetsPartition(group, len(topic.Partitions))
+	replica, rerr := b.replicaLookup.Replica(OffsetsTopicName, partition)
+	if rerr != nil || replica == nil || replica.Log == nil || replica.Partition.Leader != b.config.ID {
+		return nil, protocol.ErrNotCoordinator
+	}
+	if !b.groupPartitions.isLoaded(partition) {
+		return nil, protocol.ErrCoordinatorLoadInProgress
+	}
+	return replica, protocol.ErrNone
+}
+
+// groupCoordinator returns ErrNone if this broker is the group's coordinator. Once the offsets
+// topic exists that's the leader of the group's partition once it's loaded its groups, and other
+// brokers, the partition's old leader included, return ErrNotCoordinator so clients find the
+// new one. Before then it's the group's registered coordinator.
+func (b *Broker) groupCoordinator(id string, group *structs.Group) protocol.Error {
+	replica, err := b.groupReplica(id)
+	if err != protocol.ErrNone {
+		return err
+	}
+	if replica == nil && group != nil && group.Coordinator != b.config.ID {
+		return protocol.ErrNotCoordinator
+	}
+	return protocol.ErrNone
+}
+
+// writeGroupRecords appends the group's records to its offsets partition, if the offsets topic
+// exists.
+func (b *Broker) writeGroupRecords(group string, messages []*protocol.Message) protocol.Error {
+	replica, err := b.groupReplica(group)
+	if err != protocol.ErrNone || replica == nil || len(messages) == 0 {
+		return err
+	}
+	recordSet, eerr := protocol.Encode(&protocol.MessageSet{Messages: messages})
+	if eerr != nil {
+		return protocol.ErrUnknown.WithErr(eerr)
+	}
+	protocol.SetPartitionLeaderEpoch(recordSet, replica.Partition.LeaderEpoch)
+	if _, err := replica.Log.Append(recordSet); err != nil {
+		b.logFailed(replica.Partition.Topic, replica.Partition.ID, err)
+		return protocol.ErrKafkaStorageError.WithErr(err)
+	}
+	return protocol.ErrNone
+}
+
+// groupMessage returns a record of the group's in the offsets topic, a tombstone if value's nil.
+func groupMessage(key groupRecordKey, value interface{}, now time.Time) (*protocol.Message, error) {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	msg := &protocol.Message{MagicByte: 1, Timestamp: now, Key: k}
+	if value != nil {
+		if msg.Value, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// writeGroup persists the group's metadata.
+func (b *Broker) writeGroup(group *structs.Group) protocol.Error {
+	msg, err := groupMessage(groupRecordKey{Group: group.Group}, groupMetadata{
+		State:        group.State,
+		ProtocolType: group.ProtocolType,
+		Protocol:     group.Protocol,
+		LeaderID:     group.LeaderID,
+		Members:      group.Members,
+	}, time.Now())
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return b.writeGroupRecords(group.Group, []*protocol.Message{msg})
+}
+
+// writeOffsets persists the offsets committed by the group.
+func (b *Broker) writeOffsets(group string, offsets map[string]map[int32]structs.GroupOffset) protocol.Error {
+	now := time.Now()
+	var messages []*protocol.Message
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			msg, err := groupMessage(groupRecordKey{Group: group, Topic: topic, Partition: partition}, offset, now)
+			if err != nil {
+				return protocol.ErrUnknown.WithErr(err)
+			}
+			messages = append(messages, msg)
+		}
+	}
+	return b.writeGroupRecords(group, messages)
+}
+
+// writeOffsetTombstones persists the deletion of the group's offsets of the partitions.
+func (b *Broker) writeOffsetTombstones(group string, offsets map[string][]int32) protocol.Error {
+	now := time.Now()
+	var messages []*protocol.Message
+	for topic, partitions := range offsets {
+		for _, partition := range partitions {
+			msg, err := groupMessage(groupRecordKey{Group: group, Topic: topic, Partition: partition}, nil, now)
+			if err != nil {
+				return protocol.ErrUnknown.WithErr(err)
+			}
+			messages = append(messages, msg)
+		}
+	}
+	return b.writeGroupRecords(group, messages)
+}
+
+// writeGroupTombstones persists the deletion of the group and its committed offsets.
+func (b *Broker) writeGroupTombstones(group *structs.Group) protocol.Error {
+	offsets := make(map[string][]int32, len(group.Offsets))
+	for topic, partitions := range group.Offsets {
+		for partition := range partitions {
+			offsets[topic] = append(offsets[topic], partition)
+		}
+	}
+	if err := b.writeOffsetTombstones(group.Group, offsets); err != protocol.ErrNone {
+		return err
+	}
+	msg, err := groupMessage(groupRecordKey{Group: group.Group}, nil, time.Now())
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return b.writeGroupRecords(group.Group, []*protocol.Message{msg})
+}
+
+// loadGroups reads the groups of the offsets partition this broker's become the leader of and
+// replays them into the store with this broker as their coordinator. Until they're loaded the
+// partition's groups get ErrCoordinatorLoadInProgress.
+func (b *Broker) loadGroups(replica *Replica) {
+	partition := replica.Partition.ID
+	loaded := make(map[string]*structs.Group)
+	group := func(id string) *structs.Group {
+		g, ok := loaded[id]
+		if !ok {
+			g = &structs.Group{Group: id, State: structs.GroupStateEmpty, Offsets: make(map[string]map[int32]structs.GroupOffset)}
+			loaded[id] = g
+		}
+		return g
+	}
+	err := readMessages(replica, func(msg *protocol.Message) {
+		var key groupRecordKey
+		if err := json.Unmarshal(msg.Key, &key); err != nil {
+			b.logger.Error("failed to decode group record key", log.String("key", string(msg.Key)), log.Error("error", err))
+			return
+		}
+		switch {
+		case key.Topic == "" && msg.Value == nil:
+			delete(loaded, key.Group)
+		case key.Topic == "":
+			var m groupMetadata
+			if err := json.Unmarshal(msg.Value, &m); err != nil {
+				b.logger.Error("failed to decode group", log.String("group", key.Group), log.Error("error", err))
+				return
+			}
+			g := group(key.Group)
+			g.State = m.State
+			g.ProtocolType = m.ProtocolType
+			g.Protocol = m.Protocol
+			g.LeaderID = m.LeaderID
+			g.Members = m.Members
+		case msg.Value == nil:
+			delete(group(key.Group).Offsets[key.Topic], key.Partition)
+		default:
+			var offset structs.GroupOffset
+			if err := json.Unmarshal(msg.Value, &offset); err != nil {
+				b.logger.Error("failed to decode group offset", log.String("group", key.Group), log.Error("error", err))
+				return
+			}
+			g := group(key.Group)
+			if g.Offsets[key.Topic] == nil {
+				g.Offsets[key.Topic] = make(map[int32]structs.GroupOffset)
+			}
+			g.Offsets[key.Topic][key.Partition] = offset
+		}
+	})
+	if err != nil {
+		b.logger.Error("failed to read groups", log.Int32("partition", partition), log.Error("error", err))
+		return
+	}
+	state := b.fsm.State()
+	_, topic, err := state.GetTopic(OffsetsTopicName)
+	if err != nil || topic == nil {
+		b.logger.Error("failed getting offsets topic", log.Int32("partition", partition), log.Error("error", err))
+		return
+	}
+	// groups registered before the offsets topic existed aren't in it, they're taken over too
+	_, groups, err := state.GetGroups()
+	if err != nil {
+		b.logger.Error("failed getting groups", log.Int32("partition", partition), log.Error("error", err))
+		return
+	}
+	for _, g := range groups {
+		if _, ok := loaded[g.Group]; ok || g.Coordinator == b.config.ID || offsetsPartition(g.Group, len(topic.Partitions)) != partition {
+			continue
+		}
+		g = g.Copy()
+		g.Coordinator = b.config.ID
+		if _, err := b.raftApply(structs.RegisterGroupRequestType, structs.RegisterGroupRequest{Group: *g}); err != nil {
+			b.logger.Error("failed to register group", log.String("group", g.Group), log.Error("error", err))
+			return
+		}
+	}
+	for id, g := range loaded {
+		_, existing, err := state.GetGroup(id)
+		if err != nil {
+			b.logger.Error("failed getting group", log.String("group", id), log.Error("error", err))
+			return
+		}
+		if existing != nil {
+			g.PendingOffsets = existing.PendingOffsets
+		}
+		g.Coordinator = b.config.ID
+		if _, err := b.raftApply(structs.RegisterGroupRequestType, structs.RegisterGroupRequest{Group: *g}); err != nil {
+			b.logger.Error("failed to register group", log.String("group", id), log.Error("error", err))
+			return
+		}
+	}
+	b.groupPartitions.load(partition)
+	b.logger.Info("loaded groups", log.Int32("partition", partition), log.Int("groups", len(loaded)))
+}
+
+// unloadGroups gives up coordinating the groups of the offsets partition once this broker stops
+// leading it, their requests get ErrNotCoordinator until their clients find the new leader.
+func (b *Broker) unloadGroups(partition int32) {
+	b.groupPartitions.unload(partition)
+	_, topic, err := b.fsm.State().GetTopic(OffsetsTopicName)
+	if err != nil || topic == nil {
+		return
+	}
+	unloaded := func(group string) bool {
+		return offsetsPartition(group, len(topic.Partitions)) == partition
+	}
+	b.rebalances.removeMatching(unloaded)
+	b.sessions.removeMatching(unloaded)
+}
@@ -0,0 +1,79 @@
+package jocko
+
+import (
+	"github.com/travisjeffery/jocko/jocko/structs"
+)
+
+// memberProtocols returns the protocols the member supports. Members that joined before their
+// protocols were kept support the group's protocol alone.
+func memberProtocols(group *structs.Group, m structs.Member) []structs.MemberProtocol {
+	if len(m.Protocols) == 0 && group.Protocol != "" {
+		return []structs.MemberProtocol{{Name: group.Protocol, Metadata: m.Metadata}}
+	}
+	return m.Protocols
+}
+
+// groupProtocol returns the protocol the group's members use, picked as Kafka picks it: of the
+// protocols all of them support, the one most of them prefer, ties going to the first by name.
+// So members can move to a new protocol, like from range to cooperative-sticky, one at a time
+// while supporting the old one too. It's false if the members have none in common, and empty
+// if none of them sent any.
+func groupProtocol(group *structs.Group) (string, bool) {
+	var candidates map[string]bool
+	none := true
+	for _, m := range group.Members {
+		supported := make(map[string]bool)
+		for _, p := range memberProtocols(group, m) {
+			supported[p.Name] = true
+			none = false
+		}
+		if candidates == nil {
+			candidates = supported
+			continue
+		}
+		for name := range candidates {
+			if !supported[name] {
+				delete(candidates, name)
+			}
+		}
+	}
+	if none {
+		return "", true
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	votes := make(map[string]int)
+	for _, m := range group.Members {
+		for _, p := range memberProtocols(group, m) {
+			if candidates[p.Name] {
+				votes[p.Name]++
+				break
+			}
+		}
+	}
+	var picked string
+	for name, n := range votes {
+		if picked == "" || n > votes[picked] || n == votes[picked] && name < picked {
+			picked = name
+		}
+	}
+	return picked, true
+}
+
+// setGroupProtocol sets the group's protocol and its members' metadata for it, which is passed
+// through to the leader as they sent it, like the partitions cooperative members own.
+func setGroupProtocol(group *structs.Group, name string) {
+	for id, m := range group.Members {
+		protocols := memberProtocols(group, m)
+		m.Metadata = nil
+		for _, p := range protocols {
+			if p.Name == name {
+				m.Metadata = p.Metadata
+				break
+			}
+		}
+		group.Members[id] = m
+	}
+	group.Protocol = name
+}
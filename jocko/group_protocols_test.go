@@ -0,0 +1,45 @@
+package jocko
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/structs"
+)
+
+func TestGroupProtocol(t *testing.T) {
+	cooperative := structs.MemberProtocol{Name: "cooperative-sticky", Metadata: []byte{1}}
+	rng := structs.MemberProtocol{Name: "range", Metadata: []byte{2}}
+	group := &structs.Group{Members: map[string]structs.Member{
+		"a": {ID: "a", Protocols: []structs.MemberProtocol{cooperative, rng}},
+		"b": {ID: "b", Protocols: []structs.MemberProtocol{rng}},
+	}}
+	// members moving to the cooperative protocol keep using range until all of them support it
+	name, ok := groupProtocol(group)
+	require.True(t, ok)
+	require.Equal(t, "range", name)
+	setGroupProtocol(group, name)
+	require.Equal(t, []byte{2}, group.Members["a"].Metadata)
+
+	group.Members["b"] = structs.Member{ID: "b", Protocols: []structs.MemberProtocol{cooperative, rng}}
+	name, ok = groupProtocol(group)
+	require.True(t, ok)
+	require.Equal(t, "cooperative-sticky", name)
+	setGroupProtocol(group, name)
+	require.Equal(t, "cooperative-sticky", group.Protocol)
+	require.Equal(t, []byte{1}, group.Members["b"].Metadata)
+
+	// members that joined before their protocols were kept support the group's protocol
+	group.Members["c"] = structs.Member{ID: "c", Metadata: []byte{3}}
+	name, ok = groupProtocol(group)
+	require.True(t, ok)
+	require.Equal(t, "cooperative-sticky", name)
+
+	group.Members["d"] = structs.Member{ID: "d", Protocols: []structs.MemberProtocol{{Name: "roundrobin"}}}
+	_, ok = groupProtocol(group)
+	require.False(t, ok)
+
+	name, ok = groupProtocol(&structs.Group{Members: map[string]structs.Member{"a": {ID: "a"}}})
+	require.True(t, ok)
+	require.Equal(t, "", name)
+}
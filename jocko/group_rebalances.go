@@ -0,0 +1,283 @@
+package jocko
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+)
+
+// Reasons groups rebalance for.
+const (
+	rebalanceMemberJoined   = "member joined"
+	rebalanceMemberRejoined = "member rejoined"
+	rebalanceMemberLeft     = "member left"
+)
+
+// groupRebalance is a rebalance of a group, from the first member joining or leaving to the
+// leader syncing the new assignments.
+type groupRebalance struct {
+	// Reason is why the rebalance started, more members may have joined or left while it ran.
+	Reason    string    `json:"reason"`
+	StartedAt time.Time `json:"started_at"`
+	// CompletedAt and DurationMs are zero while the rebalance is running.
+	CompletedAt time.Time `json:"completed_at"`
+	DurationMs  int64     `json:"duration_ms"`
+	Joined      []string  `json:"members_joined,omitempty"`
+	Left        []string  `json:"members_left,omitempty"`
+	// Assignments are the members' assignments that changed.
+	Assignments []assignmentChange `json:"assignment_changes,omitempty"`
+
+	before map[string][]byte
+}
+
+// assignmentChange is how a member's assignment changed in a rebalance. The partitions are
+// listed for consumer groups, whose assignments can be decoded, as topic-partition.
+type assignmentChange struct {
+	Member  string   `json:"member"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// groupRebalances keeps the last rebalances of the groups this broker coordinates, so operators
+// can see why and how a group rebalanced without going through logs across brokers.
+type groupRebalances struct {
+	mu      sync.Mutex
+	size    int
+	metrics *Metrics
+	groups  map[string]*groupRebalanceLog
+}
+
+type groupRebalanceLog struct {
+	// current is the running rebalance, nil if there isn't one.
+	current *groupRebalance
+	// history is the completed rebalances, oldest first.
+	history []groupRebalance
+}
+
+func newGroupRebalances(size int, metrics *Metrics) *groupRebalances {
+	return &groupRebalances{
+		size:    size,
+		metrics: metrics,
+		groups:  make(map[string]*groupRebalanceLog),
+	}
+}
+
+// joined records the member joined the group, starting a rebalance if one isn't running.
+// assignments are the members' assignments before it joined. rejoined is whether the member was
+// already in the group.
+func (r *groupRebalances) joined(group, member string, rejoined bool, assignments map[string][]byte) {
+	reason := rebalanceMemberJoined
+	if rejoined {
+		reason = rebalanceMemberRejoined
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rb := r.start(group, reason, assignments)
+	if rb != nil && !rejoined {
+		rb.Joined = append(rb.Joined, member)
+	}
+}
+
+// left records the member left the group, starting a rebalance if one isn't running. The
+// rebalance is completed if the group's now empty since there's no one left to sync.
+func (r *groupRebalances) left(group, protocolType, member string, assignments map[string][]byte, empty bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rb := r.start(group, rebalanceMemberLeft, assignments)
+	if rb == nil {
+		return
+	}
+	rb.Left = append(rb.Left, member)
+	if !empty {
+		return
+	}
+	if rb = r.complete(group); rb != nil {
+		rb.Assignments = assignmentChanges(protocolType, rb.before, nil)
+	}
+}
+
+// synced records the leader synced the group's new assignments, completing its rebalance.
+func (r *groupRebalances) synced(group, protocolType string, assignments map[string][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rb := r.complete(group)
+	if rb == nil {
+		return
+	}
+	rb.Assignments = assignmentChanges(protocolType, rb.before, assignments)
+}
+
+// start returns the group's running rebalance, starting it if there isn't one. It returns nil if
+// rebalances aren't kept.
+func (r *groupRebalances) start(group, reason string, assignments map[string][]byte) *groupRebalance {
+	if r.size <= 0 {
+		return nil
+	}
+	l, ok := r.groups[group]
+	if !ok {
+		l = new(groupRebalanceLog)
+		r.groups[group] = l
+	}
+	if l.current == nil {
+		l.current = &groupRebalance{Reason: reason, StartedAt: time.Now(), before: assignments}
+		if r.metrics != nil {
+			r.metrics.GroupRebalances.With("group", group, "reason", reason).Add(1)
+		}
+	}
+	return l.current
+}
+
+// complete moves the group's running rebalance into its history, dropping the oldest if it's
+// full, and returns it. It returns nil if there's no running rebalance.
+func (r *groupRebalances) complete(group string) *groupRebalance {
+	l, ok := r.groups[group]
+	if !ok || l.current == nil {
+		return nil
+	}
+	rb := l.current
+	l.current = nil
+	rb.CompletedAt = time.Now()
+	duration := rb.CompletedAt.Sub(rb.StartedAt)
+	rb.DurationMs = int64(duration / time.Millisecond)
+	if r.metrics != nil {
+		r.metrics.GroupRebalanceTime.With("group", group).Observe(duration.Seconds())
+	}
+	if len(l.history) >= r.size {
+		l.history = append(l.history[:0], l.history[len(l.history)-r.size+1:]...)
+	}
+	l.history = append(l.history, *rb)
+	return &l.history[len(l.history)-1]
+}
+
+// describe returns the group's rebalances, most recent first, starting with the running one.
+func (r *groupRebalances) describe(group string) []groupRebalance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.groups[group]
+	if !ok {
+		return nil
+	}
+	var rebalances []groupRebalance
+	if l.current != nil {
+		rebalances = append(rebalances, *l.current)
+	}
+	for i := len(l.history) - 1; i >= 0; i-- {
+		rebalances = append(rebalances, l.history[i])
+	}
+	return rebalances
+}
+
+// remove forgets the group's rebalances, like when it's deleted.
+func (r *groupRebalances) remove(group string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.groups, group)
+}
+
+// removeMatching forgets the rebalances of the groups matching the func, like those whose
+// coordinator's moved to another broker.
+func (r *groupRebalances) removeMatching(match func(group string) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for group := range r.groups {
+		if match(group) {
+			delete(r.groups, group)
+		}
+	}
+}
+
+// assignmentChanges returns how the members' assignments changed, ordered by member. Consumer
+// groups' assignments are compared partition by partition, other groups' members are listed if
+// their assignments changed at all.
+func assignmentChanges(protocolType string, before, after map[string][]byte) []assignmentChange {
+	members := make(map[string]bool)
+	for m := range before {
+		members[m] = true
+	}
+	for m := range after {
+		members[m] = true
+	}
+	var changes []assignmentChange
+	for m := range members {
+		b, a := before[m], after[m]
+		if string(b) == string(a) {
+			continue
+		}
+		change := assignmentChange{Member: m}
+		if protocolType == "consumer" {
+			bp, bok := consumerAssignment(b)
+			ap, aok := consumerAssignment(a)
+			if bok && aok {
+				change.Added = partitionsWithout(ap, bp)
+				change.Removed = partitionsWithout(bp, ap)
+				if len(change.Added) == 0 && len(change.Removed) == 0 {
+					continue
+				}
+			}
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Member < changes[j].Member })
+	return changes
+}
+
+// partitionsWithout returns the partitions of a that aren't in b, sorted.
+func partitionsWithout(a, b map[string]bool) []string {
+	var ps []string
+	for p := range a {
+		if !b[p] {
+			ps = append(ps, p)
+		}
+	}
+	sort.Strings(ps)
+	return ps
+}
+
+// consumerAssignment decodes the partitions, as topic-partition, of a consumer protocol member
+// assignment: a version, then an array of topics and their partitions, then user data. An empty
+// assignment has no partitions. It returns false if the assignment can't be decoded.
+func consumerAssignment(b []byte) (map[string]bool, bool) {
+	partitions := make(map[string]bool)
+	if len(b) == 0 {
+		return partitions, true
+	}
+	if len(b) < 6 {
+		return nil, false
+	}
+	topics := int32(binary.BigEndian.Uint32(b[2:]))
+	b = b[6:]
+	for i := int32(0); i < topics; i++ {
+		if len(b) < 2 {
+			return nil, false
+		}
+		n := int(int16(binary.BigEndian.Uint16(b)))
+		if n < 0 || len(b) < 2+n+4 {
+			return nil, false
+		}
+		topic := string(b[2 : 2+n])
+		b = b[2+n:]
+		count := int32(binary.BigEndian.Uint32(b))
+		b = b[4:]
+		if count < 0 || int64(len(b)) < 4*int64(count) {
+			return nil, false
+		}
+		for j := int32(0); j < count; j++ {
+			partitions[fmt.Sprintf("%s-%d", topic, int32(binary.BigEndian.Uint32(b)))] = true
+			b = b[4:]
+		}
+	}
+	return partitions, true
+}
+
+// memberAssignments returns the assignments of the group's members.
+func memberAssignments(group *structs.Group) map[string][]byte {
+	assignments := make(map[string][]byte, len(group.Members))
+	for id, m := range group.Members {
+		assignments[id] = m.Assignment
+	}
+	return assignments
+}
@@ -0,0 +1,151 @@
+package jocko
+
+import (
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// groupSessionCheckInterval is how often members that have timed out are looked for.
+const groupSessionCheckInterval = time.Second
+
+// groupSessions keeps when the members of the groups this broker coordinates were last heard
+// from, by joining, syncing or heartbeating. It's kept in memory alone, so a broker that takes
+// over coordinating a group gives its members a full timeout to be heard from.
+type groupSessions struct {
+	mu     sync.Mutex
+	groups map[string]map[string]time.Time
+}
+
+func newGroupSessions() *groupSessions {
+	return &groupSessions{groups: make(map[string]map[string]time.Time)}
+}
+
+// heard records that the group's member was heard from.
+func (s *groupSessions) heard(group, member string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members, ok := s.groups[group]
+	if !ok {
+		members = make(map[string]time.Time)
+		s.groups[group] = members
+	}
+	members[member] = now
+}
+
+// lastHeard returns when the group's member was last heard from, recording now if it hasn't been
+// heard from since this broker started coordinating the group.
+func (s *groupSessions) lastHeard(group, member string, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.groups[group][member]; ok {
+		return last
+	}
+	if _, ok := s.groups[group]; !ok {
+		s.groups[group] = make(map[string]time.Time)
+	}
+	s.groups[group][member] = now
+	return now
+}
+
+// remove forgets the group's member, like when it's left.
+func (s *groupSessions) remove(group, member string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.groups[group], member)
+	if len(s.groups[group]) == 0 {
+		delete(s.groups, group)
+	}
+}
+
+// removeMatching forgets the members of the groups matching the func, like those whose
+// coordination moved to another broker.
+func (s *groupSessions) removeMatching(match func(group string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for group := range s.groups {
+		if match(group) {
+			delete(s.groups, group)
+		}
+	}
+}
+
+// memberTimeout returns how long the member can go without being heard from: its rebalance
+// timeout while the group's rebalancing, since it rejoins and syncs then rather than heartbeats,
+// and its session timeout otherwise. It's zero if the member never times out.
+func memberTimeout(group *structs.Group, m structs.Member) time.Duration {
+	switch group.State {
+	case structs.GroupStatePreparingRebalance, structs.GroupStateCompletingRebalance:
+		if m.RebalanceTimeout > 0 {
+			return m.RebalanceTimeout
+		}
+	}
+	return m.SessionTimeout
+}
+
+// timedOutMembers returns the ids of the group's members that haven't been heard from within
+// their timeouts.
+func (b *Broker) timedOutMembers(group *structs.Group, now time.Time) []string {
+	var ids []string
+	for id, m := range group.Members {
+		timeout := memberTimeout(group, m)
+		if timeout <= 0 {
+			continue
+		}
+		if now.Sub(b.sessions.lastHeard(group.Group, id, now)) > timeout {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// removeTimedOutMembers removes the members of the groups the broker coordinates that have
+// timed out every interval, so the rest of their groups rebalance and take over their
+// partitions. The members get UNKNOWN_MEMBER_ID and rejoin if they're still around.
+func (b *Broker) removeTimedOutMembers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.coordinatorsShutdownCh:
+			return
+		case <-ticker.C:
+		}
+		if b.readOnly() {
+			continue
+		}
+		b.expireMembers(time.Now())
+	}
+}
+
+// expireMembers removes the members of the groups the broker coordinates that have timed out
+// by now.
+func (b *Broker) expireMembers(now time.Time) {
+	_, groups, err := b.fsm.State().GetGroups()
+	if err != nil {
+		b.logger.Error("failed to get groups", log.Error("error", err))
+		return
+	}
+	for _, group := range groups {
+		if len(group.Members) == 0 || b.groupCoordinator(group.Group, group) != protocol.ErrNone {
+			continue
+		}
+		ids := b.timedOutMembers(group, now)
+		if len(ids) == 0 {
+			continue
+		}
+		group = group.Copy()
+		before := memberAssignments(group)
+		for _, id := range ids {
+			delete(group.Members, id)
+		}
+		if err := b.saveMembersLeft(group, before, ids); err != protocol.ErrNone {
+			b.logger.Error("failed to remove timed out members", log.String("group", group.Group), log.Error("error", err))
+			continue
+		}
+		b.logger.Info("removed timed out members", log.String("group", group.Group), log.Any("members", ids))
+	}
+}
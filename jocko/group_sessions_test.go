@@ -0,0 +1,55 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_GroupSessionTimeouts(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	join := func(sessionTimeout int32) *protocol.JoinGroupResponse {
+		return b.handleJoinGroup(ctx, &protocol.JoinGroupRequest{
+			APIVersion:       1,
+			GroupID:          "the-group",
+			SessionTimeout:   sessionTimeout,
+			RebalanceTimeout: 60000,
+			ProtocolType:     "consumer",
+			GroupProtocols:   []*protocol.GroupProtocol{{ProtocolName: "range"}},
+		})
+	}
+	// session timeouts have to be within the broker's bounds
+	require.Equal(t, protocol.ErrInvalidSessionTimeout.Code(), join(1000).ErrorCode)
+	require.Equal(t, protocol.ErrInvalidSessionTimeout.Code(), join(int32(time.Hour/time.Millisecond)).ErrorCode)
+
+	resp := join(10000)
+	require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	members := func() int {
+		_, group, err := b.fsm.State().GetGroup("the-group")
+		require.NoError(t, err)
+		return len(group.Members)
+	}
+
+	// the member has its rebalance timeout to sync while the group's rebalancing
+	now := time.Now()
+	b.expireMembers(now.Add(30 * time.Second))
+	require.Equal(t, 1, members())
+
+	sync := b.handleSyncGroup(ctx, &protocol.SyncGroupRequest{GroupID: "the-group", MemberID: resp.MemberID})
+	require.Equal(t, protocol.ErrNone.Code(), sync.ErrorCode)
+
+	// and its session timeout to heartbeat once it's stable
+	b.expireMembers(now.Add(5 * time.Second))
+	require.Equal(t, 1, members())
+	b.expireMembers(time.Now().Add(11 * time.Second))
+	require.Equal(t, 0, members())
+
+	heartbeat := b.handleHeartbeat(ctx, &protocol.HeartbeatRequest{GroupID: "the-group", MemberID: resp.MemberID})
+	require.Equal(t, protocol.ErrUnknownMemberId.Code(), heartbeat.ErrorCode)
+}
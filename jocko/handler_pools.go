@@ -0,0 +1,103 @@
+package jocko
+
+import (
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// handlerClass is a class of requests with its own pool of handlers, so a class that's busy, like
+// with a storm of topic creations, can't take the handlers the others need.
+type handlerClass int
+
+const (
+	produceHandlers handlerClass = iota
+	fetchHandlers
+	// adminHandlers handle metadata and admin requests.
+	adminHandlers
+	// coordinationHandlers handle group and transaction coordination and the controller's
+	// requests. There's one since those handlers update state they've read without locking it.
+	coordinationHandlers
+	handlerClasses
+)
+
+// requestClass returns the class of handlers that handle the request.
+func requestClass(req interface{}) handlerClass {
+	switch req.(type) {
+	case *protocol.ProduceRequest:
+		return produceHandlers
+	case *protocol.FetchRequest, *protocol.FetchSegmentRequest:
+		return fetchHandlers
+	case *protocol.MetadataRequest,
+		*protocol.APIVersionsRequest,
+		*protocol.CreateTopicRequests,
+		*protocol.DeleteTopicsRequest,
+		*protocol.CreatePartitionsRequest,
+		*protocol.DeleteRecordsRequest,
+		*protocol.ElectLeadersRequest,
+		*protocol.AlterPartitionReassignmentsRequest,
+		*protocol.ListPartitionReassignmentsRequest,
+		*protocol.DescribeConfigsRequest,
+		*protocol.AlterConfigsRequest,
+		*protocol.IncrementalAlterConfigsRequest,
+		*protocol.AlterReplicaLogDirsRequest,
+		*protocol.DescribeLogDirsRequest,
+		*protocol.ListGroupsRequest,
+		*protocol.DescribeGroupsRequest,
+		*protocol.ListTransactionsRequest,
+		*protocol.DescribeTransactionsRequest,
+		*protocol.CreateAclsRequest,
+		*protocol.DeleteAclsRequest,
+		*protocol.DescribeAclsRequest,
+		*protocol.CreateDelegationTokenRequest,
+		*protocol.RenewDelegationTokenRequest,
+		*protocol.ExpireDelegationTokenRequest,
+		*protocol.DescribeDelegationTokenRequest:
+		return adminHandlers
+	}
+	return coordinationHandlers
+}
+
+// handlerQueueSize is how many of a class's requests can wait for its handlers before the broker
+// stops reading requests, like Kafka's queued.max.requests.
+const handlerQueueSize = 500
+
+// handlerPool is a class's fixed set of handler goroutines and the queue of requests waiting
+// for them.
+type handlerPool struct {
+	queue chan func()
+}
+
+func newHandlerPool(size int) *handlerPool {
+	if size < 1 {
+		size = 1
+	}
+	p := &handlerPool{queue: make(chan func(), handlerQueueSize)}
+	for i := 0; i < size; i++ {
+		go func() {
+			for f := range p.queue {
+				f()
+			}
+		}()
+	}
+	return p
+}
+
+// handle queues f for one of the pool's handlers, waiting while the queue's full so requests
+// aren't read faster than they're handled.
+func (p *handlerPool) handle(f func()) {
+	p.queue <- f
+}
+
+// stop stops the pool's handlers once they've run what's queued.
+func (p *handlerPool) stop() {
+	close(p.queue)
+}
+
+// handlerPools returns the pools of handlers of each class, sized as configured.
+func (b *Broker) handlerPools() [handlerClasses]*handlerPool {
+	var pools [handlerClasses]*handlerPool
+	pools[produceHandlers] = newHandlerPool(b.config.ProduceHandlers)
+	pools[fetchHandlers] = newHandlerPool(b.config.FetchHandlers)
+	pools[adminHandlers] = newHandlerPool(b.config.AdminHandlers)
+	pools[coordinationHandlers] = newHandlerPool(1)
+	return pools
+}
@@ -0,0 +1,44 @@
+package jocko
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestRequestClass(t *testing.T) {
+	require.Equal(t, produceHandlers, requestClass(&protocol.ProduceRequest{}))
+	require.Equal(t, fetchHandlers, requestClass(&protocol.FetchRequest{}))
+	require.Equal(t, adminHandlers, requestClass(&protocol.CreateTopicRequests{}))
+	require.Equal(t, adminHandlers, requestClass(&protocol.MetadataRequest{}))
+	require.Equal(t, coordinationHandlers, requestClass(&protocol.JoinGroupRequest{}))
+	require.Equal(t, coordinationHandlers, requestClass(&protocol.LeaderAndISRRequest{}))
+}
+
+func TestHandlerPool(t *testing.T) {
+	pool := newHandlerPool(2)
+	defer pool.stop()
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		pool.handle(func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	wg.Wait()
+	require.Equal(t, int32(2), most)
+}
@@ -0,0 +1,198 @@
+package jocko
+
+import (
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+)
+
+// followerOffsets holds the offsets the followers of this broker's partitions last fetched from,
+// the messages before them have been replicated to the followers, along with what's needed to
+// move the partitions' followers in and out of their isrs.
+type followerOffsets struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]map[int32]int64
+	// checkpointed are the partitions' high watermarks as of the broker's last run, or as of
+	// when it followed them before leading them, that followers that haven't fetched since
+	// hold them at.
+	checkpointed map[topicPartition]int64
+	// fetches are the followers' last fetches of the partitions and when they were last caught up
+	// with the leader, so followers that fall behind are taken out of the isrs.
+	fetches map[topicPartition]map[int32]*followerFetch
+	// led are when the broker started leading the partitions, followers that haven't caught up
+	// since count from then.
+	led map[topicPartition]time.Time
+}
+
+// followerFetch is a follower's last fetch of a partition.
+type followerFetch struct {
+	// logEndOffset is the leader's log end offset as of the fetch.
+	logEndOffset int64
+	at           time.Time
+	// caughtUp is when the follower last fetched from the leader's log end offset, or from the
+	// log end offset the leader had at its fetch before.
+	caughtUp time.Time
+}
+
+func newFollowerOffsets() *followerOffsets {
+	return &followerOffsets{
+		partitions:   make(map[topicPartition]map[int32]int64),
+		checkpointed: make(map[topicPartition]int64),
+		fetches:      make(map[topicPartition]map[int32]*followerFetch),
+		led:          make(map[topicPartition]time.Time),
+	}
+}
+
+// update records that the follower fetched the partition from offset now, when the leader's log
+// end offset was logEndOffset.
+func (f *followerOffsets) update(topic string, partition int32, follower int32, offset, logEndOffset int64, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	offsets, ok := f.partitions[key]
+	if !ok {
+		offsets = make(map[int32]int64)
+		f.partitions[key] = offsets
+	}
+	offsets[follower] = offset
+	fetches, ok := f.fetches[key]
+	if !ok {
+		fetches = make(map[int32]*followerFetch)
+		f.fetches[key] = fetches
+	}
+	fetch, ok := fetches[follower]
+	if !ok {
+		fetch = &followerFetch{}
+		fetches[follower] = fetch
+	}
+	if offset >= logEndOffset {
+		fetch.caughtUp = now
+	} else if !fetch.at.IsZero() && offset >= fetch.logEndOffset {
+		// while messages are being produced the follower's never at the log end offset, but it's
+		// keeping up if it's got to where the leader was as of its last fetch
+		fetch.caughtUp = fetch.at
+	}
+	fetch.logEndOffset = logEndOffset
+	fetch.at = now
+}
+
+// leading records that the broker leads the partition as of now, unless it already did.
+func (f *followerOffsets) leading(topic string, partition int32, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	if _, ok := f.led[key]; !ok {
+		f.led[key] = now
+	}
+}
+
+// isr returns the partition's isr with the followers that haven't caught up with the leader for
+// maxLag taken out, and those that have fetched up to the high watermark and are keeping up put
+// in, in the order of the assigned replicas. It returns false if the isr's unchanged.
+func (f *followerOffsets) isr(p structs.Partition, hw int64, maxLag time.Duration, now time.Time) ([]int32, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := topicPartition{topic: p.Topic, partition: p.ID}
+	led, ok := f.led[key]
+	if !ok {
+		return nil, false
+	}
+	keepingUp := func(id int32) bool {
+		caughtUp := led
+		if fetch, ok := f.fetches[key][id]; ok && fetch.caughtUp.After(led) {
+			caughtUp = fetch.caughtUp
+		}
+		return now.Sub(caughtUp) <= maxLag
+	}
+	var isr []int32
+	changed := false
+	for _, id := range p.AR {
+		in := containsInt32(p.ISR, id)
+		switch {
+		case id == p.Leader:
+			in = true
+		case in:
+			in = keepingUp(id)
+		default:
+			offset, fetched := f.partitions[key][id]
+			in = fetched && offset >= hw && keepingUp(id)
+		}
+		if in {
+			isr = append(isr, id)
+		}
+		if in != containsInt32(p.ISR, id) {
+			changed = true
+		}
+	}
+	// replicas being reassigned away are left to the reassignment
+	for _, id := range p.ISR {
+		if !containsInt32(p.AR, id) {
+			isr = append(isr, id)
+		}
+	}
+	return isr, changed
+}
+
+// setCheckpointed sets the partition's high watermark as of the broker's last run.
+func (f *followerOffsets) setCheckpointed(topic string, partition int32, hw int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checkpointed[topicPartition{topic: topic, partition: partition}] = hw
+}
+
+// getCheckpointed returns the partition's checkpointed high watermark, false without one.
+func (f *followerOffsets) getCheckpointed(topic string, partition int32) (int64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hw, ok := f.checkpointed[topicPartition{topic: topic, partition: partition}]
+	return hw, ok
+}
+
+// lead forgets the offsets the partition's followers fetched from when this broker last led it,
+// once it leads it again after following it, and holds its high watermark at the one it knew as
+// a follower until they fetch.
+func (f *followerOffsets) lead(topic string, partition int32, hw int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	delete(f.partitions, key)
+	delete(f.fetches, key)
+	f.checkpointed[key] = hw
+	f.led[key] = time.Now()
+}
+
+// highWatermark returns the partition's high watermark, the offset every replica in its isr has
+// replicated up to, given the leader's log end offset. Followers in the isr that haven't fetched
+// yet hold it at its checkpointed high watermark, or zero without one.
+func (f *followerOffsets) highWatermark(p structs.Partition, logEndOffset int64) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := topicPartition{topic: p.Topic, partition: p.ID}
+	offsets := f.partitions[key]
+	hw := logEndOffset
+	for _, id := range p.ISR {
+		if id == p.Leader {
+			continue
+		}
+		offset, ok := offsets[id]
+		if !ok {
+			offset = f.checkpointed[key]
+		}
+		if offset < hw {
+			hw = offset
+		}
+	}
+	return hw
+}
+
+// remove forgets the partition's followers once its replica's gone from this broker.
+func (f *followerOffsets) remove(topic string, partition int32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	delete(f.partitions, key)
+	delete(f.checkpointed, key)
+	delete(f.fetches, key)
+	delete(f.led, key)
+}
@@ -0,0 +1,192 @@
+package jocko
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travisjeffery/jocko/log"
+)
+
+// hwCheckpointFile is the file in the data dir the high watermarks of the broker's partitions are
+// checkpointed to, so they survive restarts. Without it a restarted leader's high watermark would
+// be held at zero until its followers fetched, hiding the committed messages from consumers.
+const hwCheckpointFile = "replication-offset-checkpoint"
+
+// hwCheckpointVersion is the version of the checkpoint file's format: the version, the number of
+// partitions, then a line with each partition's topic, id and high watermark.
+const hwCheckpointVersion = 0
+
+// replicaHighWatermark returns the partition's high watermark as this broker knows it. Leaders
+// work it out from their followers' fetches, followers have their leader's as of their last fetch,
+// up to the messages they've replicated. b's lock must be held for the replica's replicator.
+func (b *Broker) replicaHighWatermark(replica *Replica) int64 {
+	if replica.Log == nil {
+		return 0
+	}
+	logEndOffset := replica.Log.NewestOffset()
+	if replica.Replicator == nil {
+		return b.followers.highWatermark(replica.Partition, logEndOffset)
+	}
+	hw := replica.Replicator.HighWatermark() + 1
+	if hw <= 0 {
+		// the follower hasn't fetched since it started
+		hw, _ = b.followers.getCheckpointed(replica.Partition.Topic, replica.Partition.ID)
+	}
+	if hw > logEndOffset {
+		hw = logEndOffset
+	}
+	return hw
+}
+
+// checkpointHighWatermarks writes the partitions' high watermarks to the checkpoint file every
+// interval until the broker shuts down, when its logs are flushed it's written a last time.
+func (b *Broker) checkpointHighWatermarks(interval time.Duration) {
+	if interval <= 0 || b.config.DevMode {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+		if err := b.writeHighWatermarks(); err != nil {
+			b.logger.Error("failed to checkpoint high watermarks", log.Error("error", err))
+		}
+	}
+}
+
+// writeHighWatermarks writes the high watermarks of the partitions with logs to the checkpoint
+// file, writing it to a temporary file that's renamed so it's never partly written.
+func (b *Broker) writeHighWatermarks() error {
+	type checkpoint struct {
+		topic     string
+		partition int32
+		hw        int64
+	}
+	var checkpoints []checkpoint
+	b.Lock()
+	for _, replica := range b.replicaLookup.Replicas() {
+		if replica.Log == nil {
+			continue
+		}
+		checkpoints = append(checkpoints, checkpoint{
+			topic:     replica.Partition.Topic,
+			partition: replica.Partition.ID,
+			hw:        b.replicaHighWatermark(replica),
+		})
+	}
+	b.Unlock()
+	sort.Slice(checkpoints, func(i, j int) bool {
+		if checkpoints[i].topic != checkpoints[j].topic {
+			return checkpoints[i].topic < checkpoints[j].topic
+		}
+		return checkpoints[i].partition < checkpoints[j].partition
+	})
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\n%d\n", hwCheckpointVersion, len(checkpoints))
+	for _, c := range checkpoints {
+		fmt.Fprintf(&buf, "%s %d %d\n", c.topic, c.partition, c.hw)
+	}
+	path := filepath.Join(b.config.DataDir, hwCheckpointFile)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// loadHighWatermarks reads the checkpointed high watermarks, which hold the partitions' high
+// watermarks until their followers fetch after the broker starts.
+func (b *Broker) loadHighWatermarks() error {
+	if b.config.DevMode {
+		return nil
+	}
+	v, err := ioutil.ReadFile(filepath.Join(b.config.DataDir, hwCheckpointFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	hws, err := parseHighWatermarks(v)
+	if err != nil {
+		return err
+	}
+	for tp, hw := range hws {
+		b.followers.setCheckpointed(tp.topic, tp.partition, hw)
+	}
+	return nil
+}
+
+// parseHighWatermarks parses the checkpoint file's high watermarks.
+func parseHighWatermarks(v []byte) (map[topicPartition]int64, error) {
+	s := bufio.NewScanner(bytes.NewReader(v))
+	line := func() (string, error) {
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("high watermark checkpoint ended early")
+		}
+		return s.Text(), nil
+	}
+	version, err := line()
+	if err != nil {
+		return nil, err
+	}
+	if version != strconv.Itoa(hwCheckpointVersion) {
+		return nil, fmt.Errorf("unknown high watermark checkpoint version %q", version)
+	}
+	count, err := line()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid high watermark checkpoint count %q", count)
+	}
+	hws := make(map[topicPartition]int64, n)
+	for i := 0; i < n; i++ {
+		l, err := line()
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(l)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid high watermark checkpoint line %q", l)
+		}
+		partition, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid high watermark checkpoint line %q", l)
+		}
+		hw, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid high watermark checkpoint line %q", l)
+		}
+		hws[topicPartition{topic: fields[0], partition: int32(partition)}] = hw
+	}
+	return hws, nil
+}
@@ -0,0 +1,103 @@
+package jocko
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_HighWatermark(t *testing.T) {
+	s, teardown := startTestLeader(t, nil)
+	defer teardown()
+	b := s.broker()
+	reqCtx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	// a follower that hasn't fetched yet holds the high watermark back
+	addTestFollower(t, b, "the-topic", 0, 100)
+	for i := 0; i < 2; i++ {
+		resp := b.handleProduce(reqCtx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{RecordSet: testRecordBatch(-1, -1, -1, 0)}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+	fetch := func(replicaID int32, offset int64) *protocol.FetchPartitionResponse {
+		resp := b.handleFetch(reqCtx, &protocol.FetchRequest{ReplicaID: replicaID, MinBytes: 1, MaxWaitTime: 100, Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: offset, MaxBytes: 1000}},
+		}}})
+		p := resp.Responses[0].PartitionResponses[0]
+		require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+		return p
+	}
+	latest := func() int64 {
+		resp := b.handleOffsets(reqCtx, &protocol.OffsetsRequest{APIVersion: 1, ReplicaID: -1, Topics: []*protocol.OffsetsTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.OffsetsPartition{{Partition: 0, Timestamp: -1}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].Offset
+	}
+
+	// consumers only get the committed messages
+	p := fetch(-1, 0)
+	require.Equal(t, int64(-1), p.HighWatermark)
+	require.Empty(t, p.RecordSet)
+	require.Equal(t, int64(0), latest())
+
+	// the follower's fetch from the log end offset commits the messages
+	p = fetch(100, 2)
+	require.Equal(t, int64(1), p.HighWatermark)
+	p = fetch(-1, 0)
+	require.Equal(t, int64(1), p.HighWatermark)
+	require.Equal(t, 2, len(protocol.RecordSetEntries(p.RecordSet)))
+	require.Equal(t, int64(2), latest())
+
+	// the high watermark's checkpointed to survive restarts
+	require.NoError(t, b.writeHighWatermarks())
+	v, err := ioutil.ReadFile(filepath.Join(b.config.DataDir, hwCheckpointFile))
+	require.NoError(t, err)
+	hws, err := parseHighWatermarks(v)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), hws[topicPartition{topic: "the-topic", partition: 0}])
+}
+
+func TestFollowerOffsets_Checkpointed(t *testing.T) {
+	f := newFollowerOffsets()
+	p := structs.Partition{Topic: "the-topic", ID: 0, Leader: 1, ISR: []int32{1, 2, 3}}
+	f.setCheckpointed("the-topic", 0, 5)
+	require.Equal(t, int64(5), f.highWatermark(p, 10))
+	// the high watermark's never past the log end offset
+	require.Equal(t, int64(4), f.highWatermark(p, 4))
+	f.update("the-topic", 0, 2, 8, 10, time.Now())
+	f.update("the-topic", 0, 3, 7, 10, time.Now())
+	require.Equal(t, int64(7), f.highWatermark(p, 10))
+
+	// leading again forgets the followers' offsets
+	f.lead("the-topic", 0, 6)
+	require.Equal(t, int64(6), f.highWatermark(p, 10))
+}
+
+func TestParseHighWatermarks(t *testing.T) {
+	hws, err := parseHighWatermarks([]byte("0\n2\nthe-topic 0 10\nthe-topic 1 0\n"))
+	require.NoError(t, err)
+	require.Equal(t, map[topicPartition]int64{
+		{topic: "the-topic", partition: 0}: 10,
+		{topic: "the-topic", partition: 1}: 0,
+	}, hws)
+
+	for _, v := range []string{"", "1\n0\n", "0\n2\nthe-topic 0 10\n", "0\n1\nthe-topic x 10\n"} {
+		_, err := parseHighWatermarks([]byte(v))
+		require.Error(t, err, v)
+	}
+}
@@ -0,0 +1,451 @@
+package jocko
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+const (
+	// importFetchMaxBytes is the most read from a partition of the Kafka cluster at a time.
+	importFetchMaxBytes = 1024 * 1024
+	// importMaxRecordSets is the most record sets appended to a partition in a produce request.
+	importMaxRecordSets = 1000
+)
+
+// errImportCompressed is returned importing a compressed message set with its offsets, or a batch
+// compressed with a codec that isn't supported from an offset inside it, since its records would
+// need decompressing.
+var errImportCompressed = errors.New("compressed message sets and partial batches can't be imported with their offsets")
+
+// ImporterConfig configures an Importer.
+type ImporterConfig struct {
+	// FromBrokers are the bootstrap addresses of the Kafka cluster to import from.
+	FromBrokers []string
+	// Brokers are the bootstrap addresses of the jocko cluster to import into.
+	Brokers []string
+	// Topics are imported into topics of the same names, created with as many partitions if they
+	// don't exist.
+	Topics []string
+	// TopicPattern, if set, imports the Kafka cluster's topics whose names it matches too, other
+	// than its internal topics. They're looked up when the import starts, so rerunning it picks
+	// up topics created since.
+	TopicPattern *regexp.Regexp
+	// PreserveOffsets gives the imported records the offsets they had in Kafka, filling the gaps
+	// left by retention, compaction and transaction markers with empty record batches. v2 batches
+	// are appended whole, but compressed message sets of older clients can't be imported. The
+	// partitions must only be written to by the import, which carries on from where it got to
+	// when it's rerun.
+	PreserveOffsets bool
+	// Timeout bounds each request to either cluster.
+	Timeout time.Duration
+}
+
+// ImportedPartition describes what was imported from a partition: the Kafka offsets imported
+// from and up to, the record sets appended to jocko, the offsets filled as gaps and the batches
+// skipped as aborted or control batches.
+type ImportedPartition struct {
+	Topic       string
+	Partition   int32
+	StartOffset int64
+	EndOffset   int64
+	RecordSets  int64
+	Gaps        int64
+	Skipped     int64
+}
+
+// Importer copies topics' records from a Kafka cluster into jocko, keeping their keys, values,
+// timestamps and headers, to migrate historical data. Records are read committed so aborted
+// transactions and the markers ending them aren't imported, and the imported batches have their
+// producers cleared since jocko has no state for Kafka's producers.
+type Importer struct {
+	config ImporterConfig
+	from   *clusterClient
+	to     *clusterClient
+	logger log.Logger
+}
+
+// NewImporter returns an Importer with the config.
+func NewImporter(config ImporterConfig, dialer *Dialer, logger log.Logger) *Importer {
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	return &Importer{
+		config: config,
+		from:   newClusterClient(config.FromBrokers, dialer, config.Timeout),
+		to:     newClusterClient(config.Brokers, dialer, config.Timeout),
+		logger: logger,
+	}
+}
+
+// Import copies the topics' records up to the high watermarks their partitions have when they're
+// reached, a partition at a time, returning what was imported even if it fails part way.
+func (i *Importer) Import(ctx context.Context) ([]ImportedPartition, error) {
+	defer i.from.close()
+	defer i.to.close()
+	var imported []ImportedPartition
+	topics, err := i.topics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up topics to import: %v", err)
+	}
+	for _, topic := range topics {
+		partitions, err := i.ensureTopic(topic)
+		if err != nil {
+			return imported, fmt.Errorf("failed to import topic %s: %v", topic, err)
+		}
+		for partition := int32(0); partition < partitions; partition++ {
+			if err := ctx.Err(); err != nil {
+				return imported, err
+			}
+			ip, err := i.importPartition(ctx, topicPartition{topic: topic, partition: partition})
+			imported = append(imported, ip)
+			if err != nil {
+				return imported, fmt.Errorf("failed to import partition %s-%d: %v", topic, partition, err)
+			}
+			i.logger.Info("imported partition", log.String("topic", topic), log.Int32("partition", partition), log.Int64("start offset", ip.StartOffset), log.Int64("end offset", ip.EndOffset))
+		}
+	}
+	return imported, nil
+}
+
+// topics returns the topics to import, those configured followed by those of the Kafka cluster
+// matching the pattern.
+func (i *Importer) topics() ([]string, error) {
+	topics := append([]string(nil), i.config.Topics...)
+	if i.config.TopicPattern == nil {
+		return topics, nil
+	}
+	seen := make(map[string]bool)
+	for _, topic := range topics {
+		seen[topic] = true
+	}
+	resp, err := i.from.allMetadata()
+	if err != nil {
+		return nil, err
+	}
+	var matched []string
+	for _, t := range resp.TopicMetadata {
+		if t.IsInternal || seen[t.Topic] || !i.config.TopicPattern.MatchString(t.Topic) {
+			continue
+		}
+		matched = append(matched, t.Topic)
+	}
+	sort.Strings(matched)
+	return append(topics, matched...), nil
+}
+
+// ensureTopic creates the topic in jocko if it doesn't exist, returning the number of partitions
+// it has in Kafka.
+func (i *Importer) ensureTopic(topic string) (int32, error) {
+	from, err := topicMetadata(i.from, topic)
+	if err != nil {
+		return 0, err
+	}
+	if from.TopicErrorCode != protocol.ErrNone.Code() {
+		return 0, protocol.Errs[from.TopicErrorCode]
+	}
+	partitions := int32(len(from.PartitionMetadata))
+	to, err := topicMetadata(i.to, topic)
+	if err != nil {
+		return 0, err
+	}
+	switch to.TopicErrorCode {
+	case protocol.ErrNone.Code():
+		if n := int32(len(to.PartitionMetadata)); n < partitions {
+			return 0, fmt.Errorf("topic has %d partitions in jocko, fewer than its %d in kafka", n, partitions)
+		}
+		return partitions, nil
+	case protocol.ErrUnknownTopicOrPartition.Code():
+	default:
+		return 0, protocol.Errs[to.TopicErrorCode]
+	}
+	conn, err := i.to.controllerConn()
+	if err != nil {
+		return 0, err
+	}
+	resp, err := conn.CreateTopics(&protocol.CreateTopicRequests{
+		Requests: []*protocol.CreateTopicRequest{{
+			Topic:             topic,
+			NumPartitions:     partitions,
+			ReplicationFactor: -1,
+		}},
+		Timeout: int32(i.config.Timeout / time.Millisecond),
+	})
+	if err != nil {
+		i.to.dropConn(i.to.controller)
+		return 0, err
+	}
+	for _, code := range resp.TopicErrorCodes {
+		if code.ErrorCode != protocol.ErrNone.Code() {
+			return 0, protocol.Errs[code.ErrorCode]
+		}
+	}
+	i.logger.Info("created topic to import into", log.String("topic", topic), log.Int32("partitions", partitions))
+	// look up the new partitions' leaders
+	_, err = topicMetadata(i.to, topic)
+	return partitions, err
+}
+
+// partitionImport is the state of a partition's import.
+type partitionImport struct {
+	ImportedPartition
+	tp topicPartition
+	// next is the offset jocko will give the next record set appended to the partition, when
+	// preserving offsets.
+	next       int64
+	recordSets [][]byte
+	// offsets are how many offsets jocko gives each record set.
+	offsets []int64
+}
+
+// add adds the record set to be appended, taking the given number of offsets.
+func (p *partitionImport) add(recordSet []byte, offsets int64) {
+	p.recordSets = append(p.recordSets, recordSet)
+	p.offsets = append(p.offsets, offsets)
+	p.next += offsets
+}
+
+func (i *Importer) importPartition(ctx context.Context, tp topicPartition) (ImportedPartition, error) {
+	p := &partitionImport{ImportedPartition: ImportedPartition{Topic: tp.topic, Partition: tp.partition}, tp: tp}
+	start, err := i.from.offset(tp, -2)
+	if err != nil {
+		return p.ImportedPartition, err
+	}
+	end, err := i.from.offset(tp, -1)
+	if err != nil {
+		return p.ImportedPartition, err
+	}
+	offset := start
+	if i.config.PreserveOffsets {
+		if p.next, err = i.to.offset(tp, -1); err != nil {
+			return p.ImportedPartition, err
+		}
+		// carry on from an earlier import
+		if p.next > offset {
+			offset = p.next
+		}
+	}
+	p.StartOffset, p.EndOffset = offset, offset
+	for offset < end {
+		if err := ctx.Err(); err != nil {
+			return p.ImportedPartition, err
+		}
+		resp, err := i.from.fetch(tp, offset, importFetchMaxBytes)
+		if err != nil {
+			return p.ImportedPartition, err
+		}
+		entries := protocol.RecordSetEntries(resp.RecordSet)
+		if len(entries) == 0 {
+			// an open transaction holds the last stable offset back
+			i.logger.Info("stopped importing partition at its last stable offset", log.String("topic", tp.topic), log.Int32("partition", tp.partition), log.Int64("offset", offset))
+			break
+		}
+		aborted := newAbortedTxns(resp.AbortedTransactions)
+		fetched := offset
+		var recordSet []byte
+		for _, e := range entries {
+			last := e.Offset
+			var batch *protocol.RecordBatch
+			if e.Magic >= 2 {
+				batches, err := protocol.ReadRecordBatches(e.Bytes)
+				if err != nil {
+					return p.ImportedPartition, err
+				}
+				batch = batches[0]
+				last = batch.BaseOffset + int64(batch.LastOffsetDelta)
+			}
+			if last < offset {
+				continue
+			}
+			if batch != nil && aborted.skip(batch) {
+				p.Skipped++
+			} else if !i.config.PreserveOffsets {
+				recordSet = append(recordSet, e.Bytes...)
+			} else if err := i.addRecords(p, e, batch, offset); err != nil {
+				return p.ImportedPartition, err
+			}
+			offset = last + 1
+		}
+		if offset == fetched {
+			return p.ImportedPartition, fmt.Errorf("fetch at offset %d returned nothing past it", offset)
+		}
+		if recordSet != nil {
+			protocol.ClearProducer(recordSet)
+			// the offsets it's given aren't checked
+			p.add(recordSet, 0)
+		}
+		if i.config.PreserveOffsets {
+			// the skipped batches at the end are filled too, so the offsets line up wherever the
+			// import stops
+			i.addGaps(p, offset)
+		}
+		if err := i.flush(p); err != nil {
+			return p.ImportedPartition, err
+		}
+		p.EndOffset = offset
+	}
+	return p.ImportedPartition, nil
+}
+
+// addRecords adds the records of the entry from the offset on to be appended with their
+// offsets. Batches are added whole, jocko gives their records the same offsets, unless the offset
+// is inside one when its records from the offset on are added a record set each.
+func (i *Importer) addRecords(p *partitionImport, e protocol.RecordSetEntry, batch *protocol.RecordBatch, from int64) error {
+	if batch == nil {
+		// the compression codec's in the message's attributes, after its crc and magic byte
+		if e.Bytes[12+5]&0x07 != 0 {
+			return errImportCompressed
+		}
+		i.addGaps(p, e.Offset)
+		p.add(append([]byte(nil), e.Bytes...), 1)
+		return nil
+	}
+	if batch.BaseOffset >= from {
+		i.addGaps(p, batch.BaseOffset)
+		recordSet := append([]byte(nil), e.Bytes...)
+		protocol.ClearProducer(recordSet)
+		p.add(recordSet, int64(batch.LastOffsetDelta)+1)
+		return nil
+	}
+	if !protocol.SupportedCompression(batch.Compression()) {
+		return errImportCompressed
+	}
+	for _, r := range batch.Records {
+		offset := batch.BaseOffset + int64(r.OffsetDelta)
+		if offset < from {
+			continue
+		}
+		timestamp := batch.FirstTimestamp + r.TimestampDelta
+		if batch.LogAppendTime() {
+			timestamp = batch.MaxTimestamp
+		}
+		r.TimestampDelta, r.OffsetDelta = 0, 0
+		single := &protocol.RecordBatch{
+			Attributes:     batch.Attributes &^ protocol.RecordBatchTransactional,
+			FirstTimestamp: timestamp,
+			MaxTimestamp:   timestamp,
+			ProducerID:     -1,
+			ProducerEpoch:  -1,
+			BaseSequence:   -1,
+			Records:        []protocol.Record{r},
+		}
+		i.addGaps(p, offset)
+		p.add(single.Bytes(), 1)
+	}
+	return nil
+}
+
+// addGaps adds empty record batches to be appended until jocko's next offset is the offset.
+func (i *Importer) addGaps(p *partitionImport, offset int64) {
+	for p.next < offset {
+		gap := &protocol.RecordBatch{FirstTimestamp: -1, MaxTimestamp: -1, ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1}
+		p.add(gap.Bytes(), 1)
+		p.Gaps++
+	}
+}
+
+// flush appends the partition's record sets to jocko, checking they got the offsets they're
+// meant to if preserving offsets.
+func (i *Importer) flush(p *partitionImport) error {
+	next := p.next
+	for _, offsets := range p.offsets {
+		next -= offsets
+	}
+	for len(p.recordSets) > 0 {
+		n := len(p.recordSets)
+		if n > importMaxRecordSets {
+			n = importMaxRecordSets
+		}
+		data := make([]*protocol.Data, n)
+		version := int16(3)
+		for j, recordSet := range p.recordSets[:n] {
+			data[j] = &protocol.Data{Partition: p.tp.partition, RecordSet: recordSet}
+			if v := produceVersion(recordSet); v < version {
+				version = v
+			}
+		}
+		conn, err := i.to.leader(p.tp)
+		if err != nil {
+			return err
+		}
+		resp, err := conn.Produce(&protocol.ProduceRequest{
+			APIVersion: version,
+			Acks:       -1,
+			Timeout:    i.config.Timeout,
+			TopicData:  []*protocol.TopicData{{Topic: p.tp.topic, Data: data}},
+		})
+		if err != nil {
+			i.to.failed(p.tp, err)
+			return err
+		}
+		for _, t := range resp.Responses {
+			for j, pr := range t.PartitionResponses {
+				if pr.ErrorCode != protocol.ErrNone.Code() {
+					i.to.failed(p.tp, protocol.Errs[pr.ErrorCode])
+					return protocol.Errs[pr.ErrorCode]
+				}
+				if i.config.PreserveOffsets && pr.BaseOffset != next {
+					return fmt.Errorf("record set appended at offset %d rather than %d, was the partition written to during the import?", pr.BaseOffset, next)
+				}
+				next += p.offsets[j]
+			}
+		}
+		p.RecordSets += int64(n)
+		p.recordSets, p.offsets = p.recordSets[n:], p.offsets[n:]
+	}
+	p.recordSets, p.offsets = nil, nil
+	return nil
+}
+
+// topicMetadata returns the topic's metadata from the cluster.
+func topicMetadata(c *clusterClient, topic string) (*protocol.TopicMetadata, error) {
+	resp, err := c.metadata(topic)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range resp.TopicMetadata {
+		if t.Topic == topic {
+			return t, nil
+		}
+	}
+	return nil, protocol.ErrUnknownTopicOrPartition
+}
+
+// abortedTxns tracks the aborted transactions of a read committed fetch to skip their batches,
+// like consumers do.
+type abortedTxns struct {
+	txns      []*protocol.AbortedTransaction
+	producers map[int64]bool
+}
+
+func newAbortedTxns(txns []*protocol.AbortedTransaction) *abortedTxns {
+	txns = append([]*protocol.AbortedTransaction(nil), txns...)
+	sort.Slice(txns, func(i, j int) bool { return txns[i].FirstOffset < txns[j].FirstOffset })
+	return &abortedTxns{txns: txns, producers: make(map[int64]bool)}
+}
+
+// skip returns whether the batch is a control batch or part of an aborted transaction. Batches
+// must be passed in order.
+func (a *abortedTxns) skip(batch *protocol.RecordBatch) bool {
+	last := batch.BaseOffset + int64(batch.LastOffsetDelta)
+	for len(a.txns) > 0 && a.txns[0].FirstOffset <= last {
+		a.producers[a.txns[0].ProducerID] = true
+		a.txns = a.txns[1:]
+	}
+	if batch.Control() {
+		// the abort marker ends the transaction
+		if len(batch.Records) > 0 {
+			if typ, ok := protocol.ControlRecordType(batch.Records[0]); ok && typ == protocol.ControlRecordAbort {
+				delete(a.producers, batch.ProducerID)
+			}
+		}
+		return true
+	}
+	return batch.Transactional() && a.producers[batch.ProducerID]
+}
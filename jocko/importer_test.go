@@ -0,0 +1,164 @@
+package jocko
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestImporter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// a broker stands in for the Kafka cluster being imported from
+	var servers []*Server
+	for i := 0; i < 2; i++ {
+		s, teardown := startTestLeader(t, nil)
+		defer teardown()
+		servers = append(servers, s)
+	}
+	from, to := servers[0].broker(), servers[1].broker()
+	reqCtx := &Context{parent: context.Background()}
+	for _, topic := range []string{"the-topic", "other-topic"} {
+		create := from.handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             topic,
+			NumPartitions:     1,
+			ReplicationFactor: 1,
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	}
+	// produce produces a batch of n records from the i-th on
+	produce := func(topic string, i, n int) {
+		batch := &protocol.RecordBatch{
+			FirstTimestamp: int64(1000 + i),
+			MaxTimestamp:   int64(1000 + i),
+			ProducerID:     -1,
+			ProducerEpoch:  -1,
+			BaseSequence:   -1,
+		}
+		for k := i; k < i+n; k++ {
+			batch.Records = append(batch.Records, protocol.Record{
+				OffsetDelta: int32(k - i),
+				Key:         []byte(fmt.Sprintf("key-%d", k)),
+				Value:       []byte(fmt.Sprintf("value-%d", k)),
+				Headers:     []protocol.RecordHeader{{Key: "header", Value: []byte("value")}},
+			})
+		}
+		batch.LastOffsetDelta = int32(n - 1)
+		resp := from.handleProduce(reqCtx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: topic,
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: batch.Bytes()}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+	for i := 0; i < 3; i++ {
+		produce("the-topic", i, 1)
+		produce("other-topic", i, 1)
+	}
+	// retention's removed the first record
+	del := from.handleDeleteRecords(reqCtx, &protocol.DeleteRecordsRequest{Topics: []protocol.DeleteRecordsTopic{{
+		Topic:      "the-topic",
+		Partitions: []protocol.DeleteRecordsPartition{{Partition: 0, Offset: 1}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), del.Topics[0].Partitions[0].ErrorCode)
+
+	newImporter := func(topic string, preserveOffsets bool) *Importer {
+		return NewImporter(ImporterConfig{
+			FromBrokers:     []string{servers[0].Addr().String()},
+			Brokers:         []string{servers[1].Addr().String()},
+			Topics:          []string{topic},
+			PreserveOffsets: preserveOffsets,
+		}, NewDialer("jocko-import"), log.New())
+	}
+	imported, err := newImporter("the-topic", true).Import(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ImportedPartition{
+		{Topic: "the-topic", Partition: 0, StartOffset: 1, EndOffset: 3, RecordSets: 3, Gaps: 1},
+	}, imported)
+
+	fetch := func(topic string, offset int64) []*protocol.RecordBatch {
+		resp := to.handleFetch(reqCtx, &protocol.FetchRequest{
+			APIVersion: 4,
+			MaxBytes:   1 << 20,
+			MinBytes:   1,
+			Topics: []*protocol.FetchTopic{{
+				Topic:      topic,
+				Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: offset, MaxBytes: 1 << 20}},
+			}},
+		})
+		p := resp.Responses[0].PartitionResponses[0]
+		require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+		batches, err := protocol.ReadRecordBatches(p.RecordSet)
+		require.NoError(t, err)
+		return batches
+	}
+	// the gap's an empty batch, and the records kept their offsets, keys, timestamps and headers
+	batches := fetch("the-topic", 0)
+	require.Equal(t, 3, len(batches))
+	require.Empty(t, batches[0].Records)
+	for i, batch := range batches[1:] {
+		require.Equal(t, int64(i+1), batch.BaseOffset)
+		require.Equal(t, int64(1000+i+1), batch.FirstTimestamp)
+		require.Equal(t, []protocol.Record{{
+			Key:     []byte(fmt.Sprintf("key-%d", i+1)),
+			Value:   []byte(fmt.Sprintf("value-%d", i+1)),
+			Headers: []protocol.RecordHeader{{Key: "header", Value: []byte("value")}},
+		}}, batch.Records)
+	}
+
+	// importing again carries on from where the last import got to, batches of several records
+	// are appended whole
+	produce("the-topic", 3, 2)
+	imported, err = newImporter("the-topic", true).Import(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ImportedPartition{
+		{Topic: "the-topic", Partition: 0, StartOffset: 3, EndOffset: 5, RecordSets: 1},
+	}, imported)
+	replica, err := to.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), replica.Log.NewestOffset())
+	batches = fetch("the-topic", 4)
+	require.Equal(t, 1, len(batches))
+	require.Equal(t, int64(3), batches[0].BaseOffset)
+	require.Equal(t, 2, len(batches[0].Records))
+
+	// topics are looked up by the pattern, here only the-topic which has nothing new
+	importer := newImporter("", true)
+	importer.config.Topics = nil
+	importer.config.TopicPattern = regexp.MustCompile("^the-")
+	imported, err = importer.Import(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ImportedPartition{
+		{Topic: "the-topic", Partition: 0, StartOffset: 5, EndOffset: 5},
+	}, imported)
+
+	// without preserving offsets what's fetched is appended together
+	imported, err = newImporter("other-topic", false).Import(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ImportedPartition{
+		{Topic: "other-topic", Partition: 0, StartOffset: 0, EndOffset: 3, RecordSets: 1},
+	}, imported)
+	require.Equal(t, 3, len(fetch("other-topic", 0)))
+}
+
+func TestAbortedTxns(t *testing.T) {
+	batch := func(offset int64, producerID int64, attributes int16) *protocol.RecordBatch {
+		return &protocol.RecordBatch{BaseOffset: offset, ProducerID: producerID, Attributes: attributes}
+	}
+	abort := batch(3, 1, protocol.RecordBatchTransactional|protocol.RecordBatchControl)
+	abort.Records = []protocol.Record{{Key: []byte{0, 0, 0, byte(protocol.ControlRecordAbort)}}}
+	a := newAbortedTxns([]*protocol.AbortedTransaction{{ProducerID: 1, FirstOffset: 1}})
+
+	require.False(t, a.skip(batch(0, 1, protocol.RecordBatchTransactional)))
+	require.True(t, a.skip(batch(1, 1, protocol.RecordBatchTransactional)))
+	// other producers' batches aren't aborted
+	require.False(t, a.skip(batch(2, 2, protocol.RecordBatchTransactional)))
+	require.False(t, a.skip(batch(2, -1, 0)))
+	// the marker's skipped and ends the aborted transaction
+	require.True(t, a.skip(abort))
+	require.False(t, a.skip(batch(4, 1, protocol.RecordBatchTransactional)))
+}
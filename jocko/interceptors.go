@@ -0,0 +1,105 @@
+package jocko
+
+import (
+	"fmt"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// ProduceInterceptor is called with the record batches appended to partitions by produce
+// requests, like to extract metrics from them without running a consumer. Interceptors are
+// called in the order they're added, on the produce path so they should be quick, and mustn't
+// change the batches.
+type ProduceInterceptor interface {
+	OnAppend(topic string, partition int32, batches []*protocol.RecordBatch)
+}
+
+// ProduceInterceptorFunc adapts a function to a ProduceInterceptor.
+type ProduceInterceptorFunc func(topic string, partition int32, batches []*protocol.RecordBatch)
+
+func (f ProduceInterceptorFunc) OnAppend(topic string, partition int32, batches []*protocol.RecordBatch) {
+	f(topic, partition, batches)
+}
+
+// RecordSetInterceptor is a ProduceInterceptor that's called with the record sets appended to
+// partitions as they were produced instead of their decoded batches, like to forward them
+// elsewhere. The record set mustn't be changed, or kept past the call without copying it.
+type RecordSetInterceptor interface {
+	ProduceInterceptor
+	OnAppendRecordSet(topic string, partition int32, recordSet []byte)
+}
+
+// AddProduceInterceptor adds the interceptor to the end of the broker's chain.
+func (b *Broker) AddProduceInterceptor(i ProduceInterceptor) {
+	b.interceptorsLock.Lock()
+	defer b.interceptorsLock.Unlock()
+	// copied so the produce path can read the chain without locking
+	chain, _ := b.interceptors.Load().([]ProduceInterceptor)
+	b.interceptors.Store(append(chain[:len(chain):len(chain)], i))
+}
+
+// intercept calls the interceptors with the batches of the record set appended to the partition,
+// or the record set itself for RecordSetInterceptors. The record set's only decoded if there are
+// interceptors wanting its batches, and an interceptor that panics is logged rather than failing
+// the produce.
+func (b *Broker) intercept(topic string, partition int32, recordSet []byte) {
+	chain, _ := b.interceptors.Load().([]ProduceInterceptor)
+	var batches []*protocol.RecordBatch
+	decoded := false
+	for _, i := range chain {
+		rs, raw := i.(RecordSetInterceptor)
+		if !raw && !decoded {
+			decoded = true
+			var err error
+			if batches, err = protocol.ReadRecordBatches(recordSet); err != nil {
+				b.logger.Error("failed to read record batches for interceptors", log.String("topic", topic), log.Int32("partition", partition), log.Error("error", err))
+			}
+		}
+		if !raw && len(batches) == 0 {
+			continue
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					b.logger.Error("produce interceptor panicked", log.String("topic", topic), log.Int32("partition", partition), log.Error("error", fmt.Errorf("%v", r)))
+				}
+			}()
+			if raw {
+				rs.OnAppendRecordSet(topic, partition, recordSet)
+			} else {
+				i.OnAppend(topic, partition, batches)
+			}
+		}()
+	}
+}
+
+// RecordHeaderCounter is a ProduceInterceptor counting the records appended to topics by the
+// value of a record header, like an event type or tenant. The counter's labeled with the topic
+// and the header's value, records without the header aren't counted, and neither are the
+// records of batches compressed with codecs that aren't supported since they aren't decoded.
+type RecordHeaderCounter struct {
+	header  string
+	counter metrics.Counter
+}
+
+// NewRecordHeaderCounter returns a RecordHeaderCounter counting records by the header into the
+// counter, which must have topic and value labels.
+func NewRecordHeaderCounter(header string, counter metrics.Counter) *RecordHeaderCounter {
+	return &RecordHeaderCounter{header: header, counter: counter}
+}
+
+func (c *RecordHeaderCounter) OnAppend(topic string, partition int32, batches []*protocol.RecordBatch) {
+	counts := make(map[string]int)
+	for _, batch := range batches {
+		for _, record := range batch.Records {
+			if value, ok := record.Header(c.header); ok {
+				counts[string(value)]++
+			}
+		}
+	}
+	for value, n := range counts {
+		c.counter.With("topic", topic, "value", value).Add(float64(n))
+	}
+}
@@ -0,0 +1,110 @@
+package jocko
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_ProduceInterceptors(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+
+	counter := &testCounter{counts: make(map[[2]string]float64)}
+	var calls []string
+	b.AddProduceInterceptor(ProduceInterceptorFunc(func(topic string, partition int32, batches []*protocol.RecordBatch) {
+		calls = append(calls, "first")
+		panic("interceptor failed")
+	}))
+	b.AddProduceInterceptor(NewRecordHeaderCounter("type", counter))
+	b.AddProduceInterceptor(ProduceInterceptorFunc(func(topic string, partition int32, batches []*protocol.RecordBatch) {
+		calls = append(calls, "last")
+	}))
+
+	produce := func(recordSet []byte) int16 {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	require.Equal(t, protocol.ErrNone.Code(), produce(testRecordBatchWithHeaders("type", "order", "order", "refund", "")))
+	require.Equal(t, protocol.ErrNone.Code(), produce(testRecordBatchWithHeaders("tenant", "acme")))
+	require.Equal(t, protocol.ErrNone.Code(), produce(testRecordBatchWithHeaders("type", "order")))
+
+	// a panicking interceptor doesn't stop the rest of the chain
+	require.Equal(t, []string{"first", "last", "first", "last", "first", "last"}, calls)
+	require.Equal(t, map[[2]string]float64{
+		{"the-topic", "order"}:  3,
+		{"the-topic", "refund"}: 1,
+		{"the-topic", ""}:       1,
+	}, counter.counts)
+}
+
+// testRecordBatchWithHeaders returns a record batch with a record for each value, with the value
+// in the header.
+func testRecordBatchWithHeaders(header string, values ...string) []byte {
+	putVarint := func(b []byte, v int64) []byte {
+		var buf [binary.MaxVarintLen64]byte
+		return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+	}
+	b := testRecordBatch(-1, -1, -1, int32(len(values)-1))
+	for i, v := range values {
+		// attributes, timestamp delta, offset delta, null key and value, then the header
+		rec := putVarint([]byte{0, 0}, int64(i))
+		rec = putVarint(rec, -1)
+		rec = putVarint(rec, -1)
+		rec = putVarint(rec, 1)
+		rec = append(putVarint(rec, int64(len(header))), header...)
+		rec = append(putVarint(rec, int64(len(v))), v...)
+		b = append(putVarint(b, int64(len(rec))), rec...)
+	}
+	protocol.Encoding.PutUint32(b[8:], uint32(len(b)-12))
+	protocol.Encoding.PutUint32(b[57:], uint32(len(values)))
+	protocol.Encoding.PutUint32(b[17:], crc32.Checksum(b[21:], crc32.MakeTable(crc32.Castagnoli)))
+	return b
+}
+
+// testCounter records the counts added to it by its topic and value labels.
+type testCounter struct {
+	mu     sync.Mutex
+	labels []string
+	counts map[[2]string]float64
+	parent *testCounter
+}
+
+func (c *testCounter) With(labelValues ...string) metrics.Counter {
+	return &testCounter{labels: append(c.labels[:len(c.labels):len(c.labels)], labelValues...), parent: c}
+}
+
+func (c *testCounter) Add(delta float64) {
+	root := c
+	for root.parent != nil {
+		root = root.parent
+	}
+	var key [2]string
+	for i := 0; i+1 < len(c.labels); i += 2 {
+		switch c.labels[i] {
+		case "topic":
+			key[0] = c.labels[i+1]
+		case "value":
+			key[1] = c.labels[i+1]
+		}
+	}
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.counts[key] += delta
+}
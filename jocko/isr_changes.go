@@ -0,0 +1,248 @@
+package jocko
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// alterPartitionRequest is an AlterPartition request passed to the leader loop, which sends the
+// partitions' results on result.
+type alterPartitionRequest struct {
+	broker int32
+	topics []protocol.AlterPartitionTopic
+	result chan []protocol.AlterPartitionTopicResponse
+}
+
+// alterPartitionTimeout bounds how long AlterPartition requests wait for the controller to change
+// the isrs, the leader asks again on its next check if it times out.
+const alterPartitionTimeout = 30 * time.Second
+
+// checkISRs changes the isrs of the partitions this broker leads as their followers fall behind
+// or catch up, checking twice a maxLag until the broker shuts down.
+func (b *Broker) checkISRs(maxLag time.Duration) {
+	if maxLag <= 0 {
+		return
+	}
+	ticker := time.NewTicker(maxLag / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+		if err := b.changeISRs(maxLag, time.Now()); err != nil {
+			b.logger.Error("failed to change isrs", log.Error("error", err))
+		}
+	}
+}
+
+// changeISRs asks the controller to take the followers that haven't caught up with this broker
+// for maxLag out of the isrs of the partitions it leads, and to put those that have caught up
+// back in. The controller sends the leader the isrs through LeaderAndISR requests once they're
+// applied through raft.
+func (b *Broker) changeISRs(maxLag time.Duration, now time.Time) error {
+	req := &protocol.AlterPartitionRequest{BrokerID: b.config.ID, BrokerEpoch: -1}
+	topics := make(map[string]int)
+	b.Lock()
+	for _, replica := range b.replicaLookup.Replicas() {
+		p := replica.Partition
+		if p.Leader != b.config.ID || replica.Replicator != nil || replica.Log == nil {
+			continue
+		}
+		hw := b.followers.highWatermark(p, replica.Log.NewestOffset())
+		isr, changed := b.followers.isr(p, hw, maxLag, now)
+		if !changed {
+			continue
+		}
+		i, ok := topics[p.Topic]
+		if !ok {
+			i = len(req.Topics)
+			topics[p.Topic] = i
+			req.Topics = append(req.Topics, protocol.AlterPartitionTopic{Topic: p.Topic})
+		}
+		req.Topics[i].Partitions = append(req.Topics[i].Partitions, protocol.AlterPartitionPartition{
+			Partition:      p.ID,
+			LeaderEpoch:    p.LeaderEpoch,
+			NewISR:         isr,
+			PartitionEpoch: -1,
+		})
+	}
+	b.Unlock()
+	if len(req.Topics) == 0 {
+		return nil
+	}
+	results, err := b.requestAlterPartition(req)
+	if err != nil {
+		return err
+	}
+	for _, t := range results {
+		for _, p := range t.Partitions {
+			if p.ErrorCode != protocol.ErrNone.Code() {
+				b.logger.Info("failed to change isr", log.String("topic", t.Topic), log.Int32("partition", p.Partition), log.Error("error", protocol.Errs[p.ErrorCode]))
+				continue
+			}
+			b.logger.Info("changed isr", log.String("topic", t.Topic), log.Int32("partition", p.Partition), log.Any("isr", p.ISR))
+		}
+	}
+	return nil
+}
+
+// requestAlterPartition sends the AlterPartition request to the controller, or runs it if this
+// broker's the controller.
+func (b *Broker) requestAlterPartition(req *protocol.AlterPartitionRequest) ([]protocol.AlterPartitionTopicResponse, error) {
+	if b.isController() {
+		results, err := b.alterPartition(req.BrokerID, req.Topics)
+		if err != protocol.ErrNone {
+			return nil, err
+		}
+		return results, nil
+	}
+	id := b.controllerID()
+	if id == -1 {
+		return nil, protocol.ErrNotController
+	}
+	broker := b.brokerLookup.BrokerByID(raft.ServerID(id))
+	if broker == nil {
+		return nil, protocol.ErrBrokerNotAvailable
+	}
+	conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	resp, err := conn.AlterPartition(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != protocol.ErrNone.Code() {
+		return nil, protocol.Errs[resp.ErrorCode]
+	}
+	return resp.Topics, nil
+}
+
+func (b *Broker) handleAlterPartition(ctx *Context, req *protocol.AlterPartitionRequest) *protocol.AlterPartitionResponse {
+	sp := span(ctx, b.tracer, "alter partition")
+	defer sp.Finish()
+	resp := &protocol.AlterPartitionResponse{APIVersion: req.Version()}
+	results, err := b.alterPartition(req.BrokerID, req.Topics)
+	if err != protocol.ErrNone {
+		sp.LogKV("broker", req.BrokerID, "err", err)
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	resp.Topics = results
+	return resp
+}
+
+// alterPartition passes the broker's isr changes to the leader loop and waits for their results.
+func (b *Broker) alterPartition(broker int32, topics []protocol.AlterPartitionTopic) ([]protocol.AlterPartitionTopicResponse, protocol.Error) {
+	stopCh := b.leaderStop()
+	if stopCh == nil {
+		return nil, protocol.ErrNotController
+	}
+	r := &alterPartitionRequest{
+		broker: broker,
+		topics: topics,
+		result: make(chan []protocol.AlterPartitionTopicResponse, 1),
+	}
+	timer := time.NewTimer(alterPartitionTimeout)
+	defer timer.Stop()
+	select {
+	case b.alterPartitionCh <- r:
+	case <-timer.C:
+		return nil, protocol.ErrRequestTimedOut
+	case <-stopCh:
+		return nil, protocol.ErrNotController
+	case <-b.shutdownCh:
+		return nil, protocol.ErrNotController
+	}
+	select {
+	case results := <-r.result:
+		return results, protocol.ErrNone
+	case <-timer.C:
+		return nil, protocol.ErrRequestTimedOut
+	case <-stopCh:
+		return nil, protocol.ErrNotController
+	case <-b.shutdownCh:
+		return nil, protocol.ErrNotController
+	}
+}
+
+// runAlterPartition runs in the leader loop, applying the isr changes the broker asked for to
+// the partitions it leads in their current leader epochs. Changes from a deposed leader are
+// fenced, and brokers shutting down aren't put back in isrs.
+func (b *Broker) runAlterPartition(r *alterPartitionRequest) []protocol.AlterPartitionTopicResponse {
+	state := b.fsm.State()
+	var changed []structs.Partition
+	results := make([]protocol.AlterPartitionTopicResponse, len(r.topics))
+	for i, t := range r.topics {
+		results[i] = protocol.AlterPartitionTopicResponse{
+			Topic:      t.Topic,
+			Partitions: make([]protocol.AlterPartitionPartitionResponse, len(t.Partitions)),
+		}
+		for j, p := range t.Partitions {
+			result := protocol.AlterPartitionPartitionResponse{Partition: p.Partition, PartitionEpoch: -1}
+			_, partition, err := state.GetPartition(t.Topic, p.Partition)
+			if err != nil {
+				b.logger.Error("leader: failed to get partition", log.Error("error", err))
+				result.ErrorCode = protocol.ErrUnknown.Code()
+				results[i].Partitions[j] = result
+				continue
+			}
+			if partition == nil {
+				result.ErrorCode = protocol.ErrUnknownTopicOrPartition.Code()
+				results[i].Partitions[j] = result
+				continue
+			}
+			pp := *partition
+			switch {
+			case partition.Leader != r.broker:
+				result.ErrorCode = protocol.ErrNotLeaderForPartition.Code()
+			case p.LeaderEpoch != partition.LeaderEpoch:
+				result.ErrorCode = protocol.ErrFencedLeaderEpoch.Code()
+			case !b.validISR(partition, p.NewISR):
+				result.ErrorCode = protocol.ErrInvalidRequest.Code()
+			default:
+				pp.ISR = p.NewISR
+				changed = append(changed, pp)
+			}
+			result.LeaderID = pp.Leader
+			result.LeaderEpoch = pp.LeaderEpoch
+			result.ISR = pp.ISR
+			results[i].Partitions[j] = result
+		}
+	}
+	if err := b.updatePartitions(changed); err != nil {
+		b.logger.Error("leader: failed to change isrs", log.Error("error", err))
+		for i := range results {
+			for j := range results[i].Partitions {
+				if results[i].Partitions[j].ErrorCode == protocol.ErrNone.Code() {
+					results[i].Partitions[j].ErrorCode = protocol.ErrUnknown.Code()
+				}
+			}
+		}
+	}
+	return results
+}
+
+// validISR returns whether the isr can replace the partition's: it has the leader, and the
+// replicas it adds are assigned the partition and not shutting down.
+func (b *Broker) validISR(p *structs.Partition, isr []int32) bool {
+	if !containsInt32(isr, p.Leader) {
+		return false
+	}
+	for _, id := range isr {
+		if containsInt32(p.ISR, id) {
+			continue
+		}
+		if !containsInt32(p.AR, id) || b.isShuttingDown(id) {
+			return false
+		}
+	}
+	return true
+}
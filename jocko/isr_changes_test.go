@@ -0,0 +1,99 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestFollowerOffsets_ISR(t *testing.T) {
+	f := newFollowerOffsets()
+	p := structs.Partition{Topic: "the-topic", ID: 0, Leader: 1, AR: []int32{1, 2, 3}, ISR: []int32{1, 2}}
+	now := time.Now()
+	maxLag := 10 * time.Second
+
+	// the isr's left be until the broker's known to lead the partition
+	_, changed := f.isr(p, 0, maxLag, now)
+	require.False(t, changed)
+	f.leading("the-topic", 0, now)
+	_, changed = f.isr(p, 0, maxLag, now.Add(maxLag))
+	require.False(t, changed)
+
+	// a follower that hasn't caught up for the max lag is taken out
+	isr, changed := f.isr(p, 0, maxLag, now.Add(maxLag+time.Second))
+	require.True(t, changed)
+	require.Equal(t, []int32{1}, isr)
+
+	// fetching from where the leader's log ended as of its fetch before keeps it in, though
+	// messages were appended since
+	f.update("the-topic", 0, 2, 5, 5, now.Add(time.Second))
+	f.update("the-topic", 0, 2, 5, 8, now.Add(2*time.Second))
+	f.update("the-topic", 0, 2, 8, 10, now.Add(maxLag))
+	_, changed = f.isr(p, 8, maxLag, now.Add(maxLag+time.Second))
+	require.False(t, changed)
+
+	// a follower out of the isr is put back in once it's fetched up to the high watermark and
+	// is keeping up
+	f.update("the-topic", 0, 3, 6, 10, now.Add(maxLag))
+	_, changed = f.isr(p, 8, maxLag, now.Add(maxLag+time.Second))
+	require.False(t, changed)
+	f.update("the-topic", 0, 3, 10, 10, now.Add(maxLag+time.Second))
+	isr, changed = f.isr(p, 8, maxLag, now.Add(maxLag+time.Second))
+	require.True(t, changed)
+	require.Equal(t, []int32{1, 2, 3}, isr)
+}
+
+func TestBroker_AlterPartition(t *testing.T) {
+	s, teardown := startTestLeader(t, nil)
+	defer teardown()
+	b := s.broker()
+	reqCtx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+
+	// the partition's followed by a broker that's failed, so it isn't sent the partition's state
+	_, err := b.raftApply(structs.RegisterNodeRequestType, structs.RegisterNodeRequest{Node: structs.Node{
+		Node:  2,
+		Check: &structs.HealthCheck{Status: structs.HealthCritical},
+	}})
+	require.NoError(t, err)
+	_, p, err := b.fsm.State().GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	pp := *p
+	pp.AR, pp.ISR, pp.LeaderEpoch = []int32{b.config.ID, 2}, []int32{b.config.ID, 2}, 3
+	_, err = b.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: pp})
+	require.NoError(t, err)
+
+	alter := func(broker, leaderEpoch int32, isr []int32) protocol.AlterPartitionPartitionResponse {
+		resp := b.handleAlterPartition(reqCtx, &protocol.AlterPartitionRequest{BrokerID: broker, BrokerEpoch: -1, Topics: []protocol.AlterPartitionTopic{{
+			Topic:      "the-topic",
+			Partitions: []protocol.AlterPartitionPartition{{Partition: 0, LeaderEpoch: leaderEpoch, NewISR: isr, PartitionEpoch: -1}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+		return resp.Topics[0].Partitions[0]
+	}
+
+	// changes from brokers that aren't the leader, or from past leader epochs, are fenced
+	require.Equal(t, protocol.ErrNotLeaderForPartition.Code(), alter(2, 3, []int32{2}).ErrorCode)
+	require.Equal(t, protocol.ErrFencedLeaderEpoch.Code(), alter(b.config.ID, 2, []int32{b.config.ID}).ErrorCode)
+	// the isr has to have the leader
+	require.Equal(t, protocol.ErrInvalidRequest.Code(), alter(b.config.ID, 3, []int32{2}).ErrorCode)
+
+	res := alter(b.config.ID, 3, []int32{b.config.ID})
+	require.Equal(t, protocol.ErrNone.Code(), res.ErrorCode)
+	require.Equal(t, []int32{b.config.ID}, res.ISR)
+	_, p, err = b.fsm.State().GetPartition("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, []int32{b.config.ID}, p.ISR)
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, []int32{b.config.ID}, replica.Partition.ISR)
+}
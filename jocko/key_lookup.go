@@ -0,0 +1,129 @@
+package jocko
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// keyLookupLog is implemented by commit logs that can find the latest message set with a key.
+type keyLookupLog interface {
+	LatestForKey(key []byte, before int64) (commitlog.MessageSet, bool, error)
+}
+
+// keyRecord is the latest record with a key in a partition.
+type keyRecord struct {
+	Offset int64 `json:"offset"`
+	// Timestamp is in milliseconds, -1 for v0 messages which don't have one.
+	Timestamp int64             `json:"timestamp"`
+	Key       []byte            `json:"key"`
+	Value     []byte            `json:"value"`
+	Headers   []keyRecordHeader `json:"headers,omitempty"`
+}
+
+type keyRecordHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// lookupKey returns the latest record with the key in a partition of a compacted topic, which
+// this broker must lead, turning compacted topics into a store that can be queried without
+// consuming them. Only the records below the partition's high watermark and last stable offset
+// are looked at, so those read are committed. It returns nil if there's no record with the key or
+// its latest is a tombstone, which deletes it.
+func (b *Broker) lookupKey(topic string, partition int32, key []byte) (*keyRecord, protocol.Error) {
+	replica, err := b.replicaLookup.Replica(topic, partition)
+	if err != nil {
+		return nil, protocol.ErrUnknownTopicOrPartition
+	}
+	if replica.Partition.Leader != b.config.ID {
+		return nil, protocol.ErrNotLeaderForPartition
+	}
+	if replica.Log == nil {
+		return nil, protocol.ErrReplicaNotAvailable
+	}
+	_, t, err := b.fsm.State().GetTopic(topic)
+	if err != nil {
+		return nil, protocol.ErrUnknown.WithErr(err)
+	}
+	if t == nil {
+		return nil, protocol.ErrUnknownTopicOrPartition
+	}
+	if fmt.Sprint(t.Config.GetValue("cleanup.policy")) != commitlog.CompactCleanupPolicy {
+		return nil, protocol.ErrInvalidRequest.WithErr(fmt.Errorf("topic %s isn't compacted", topic))
+	}
+	l, ok := replica.Log.(keyLookupLog)
+	if !ok {
+		return nil, protocol.ErrUnknown
+	}
+	before := b.followers.highWatermark(replica.Partition, replica.Log.NewestOffset())
+	before = b.lastStableOffset(topic, partition, before)
+	ms, ok, err := l.LatestForKey(key, before)
+	if err != nil {
+		return nil, protocol.ErrUnknown.WithErr(err)
+	}
+	if !ok {
+		return nil, protocol.ErrNone
+	}
+	record, err := latestRecordForKey(ms, key)
+	if err != nil {
+		return nil, protocol.ErrCorruptMessage.WithErr(err)
+	}
+	if record == nil || record.Value == nil {
+		return nil, protocol.ErrNone
+	}
+	return record, protocol.ErrNone
+}
+
+// latestRecordForKey returns the last record with the key in the message set's entry, a v2 record
+// batch or an older message set.
+func latestRecordForKey(ms commitlog.MessageSet, key []byte) (*keyRecord, error) {
+	// the magic byte follows the offset, size and partition leader epoch or crc
+	if len(ms) > 16 && int8(ms[16]) < 2 {
+		set := new(protocol.MessageSet)
+		if err := set.Decode(protocol.NewDecoder(ms)); err != nil {
+			return nil, err
+		}
+		for i := len(set.Messages) - 1; i >= 0; i-- {
+			m := set.Messages[i]
+			if !bytes.Equal(m.Key, key) {
+				continue
+			}
+			timestamp := int64(-1)
+			if m.MagicByte > 0 {
+				timestamp = m.Timestamp.UnixNano() / int64(time.Millisecond)
+			}
+			return &keyRecord{Offset: set.Offset, Timestamp: timestamp, Key: m.Key, Value: m.Value}, nil
+		}
+		return nil, nil
+	}
+	batches, err := protocol.ReadRecordBatches(ms)
+	if err != nil {
+		return nil, err
+	}
+	var latest *keyRecord
+	for _, batch := range batches {
+		for _, r := range batch.Records {
+			if !bytes.Equal(r.Key, key) {
+				continue
+			}
+			timestamp := batch.FirstTimestamp + r.TimestampDelta
+			if batch.LogAppendTime() {
+				timestamp = batch.MaxTimestamp
+			}
+			latest = &keyRecord{
+				Offset:    batch.BaseOffset + int64(r.OffsetDelta),
+				Timestamp: timestamp,
+				Key:       r.Key,
+				Value:     r.Value,
+			}
+			for _, h := range r.Headers {
+				latest.Headers = append(latest.Headers, keyRecordHeader{Key: h.Key, Value: h.Value})
+			}
+		}
+	}
+	return latest, nil
+}
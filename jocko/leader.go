@@ -1,9 +1,11 @@
 package jocko
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -32,7 +34,7 @@ func (b *Broker) setupRaft() (err error) {
 		}
 	}()
 
-	b.fsm, err = fsm.New(b.logger, b.tracer, fsm.NodeID(b.config.ID))
+	b.fsm, err = fsm.New(b.logger, b.tracer, fsm.NodeID(b.config.ID), fsm.TopicObserver(b.configureReplicas), fsm.ConfigObserver(b.observeConfig))
 	if err != nil {
 		return err
 	}
@@ -110,8 +112,22 @@ func (b *Broker) setupRaft() (err error) {
 	b.raftNotifyCh = raftNotifyCh
 
 	// setup raft store
-	b.raft, err = raft.NewRaft(b.config.RaftConfig, b.fsm, logStore, stable, snap, trans)
-	return err
+	b.raft, err = raft.NewRaft(b.config.RaftConfig, b.raftFSM(), logStore, stable, snap, trans)
+	if err != nil {
+		return err
+	}
+
+	// forward the leader and peer changes to the broker's raft observers.
+	observations := make(chan raft.Observation, raftObservationsBuffer)
+	b.raft.RegisterObserver(raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.LeaderObservation, raft.PeerObservation:
+			return true
+		}
+		return false
+	}))
+	go b.observeRaft(observations)
+	return nil
 }
 
 func (b *Broker) monitorLeadership() {
@@ -128,6 +144,7 @@ func (b *Broker) monitorLeadership() {
 					continue
 				}
 				weAreLeaderCh = make(chan struct{})
+				b.setLeaderStopCh(weAreLeaderCh)
 				leaderLoop.Add(1)
 				go func(ch chan struct{}) {
 					defer leaderLoop.Done()
@@ -141,6 +158,7 @@ func (b *Broker) monitorLeadership() {
 					continue
 				}
 				b.logger.Debug("leader: shutting down leader loop")
+				b.setLeaderStopCh(nil)
 				close(weAreLeaderCh)
 				leaderLoop.Wait()
 				weAreLeaderCh = nil
@@ -152,6 +170,22 @@ func (b *Broker) monitorLeadership() {
 	}
 }
 
+// setLeaderStopCh sets the channel that's closed when the leader loop stops, nil while it isn't
+// running.
+func (b *Broker) setLeaderStopCh(ch chan struct{}) {
+	b.leaderStopLock.Lock()
+	defer b.leaderStopLock.Unlock()
+	b.leaderStopCh = ch
+}
+
+// leaderStop returns the channel that's closed when this broker loses leadership, nil if it
+// isn't the leader.
+func (b *Broker) leaderStop() <-chan struct{} {
+	b.leaderStopLock.Lock()
+	defer b.leaderStopLock.Unlock()
+	return b.leaderStopCh
+}
+
 func (b *Broker) revokeLeadership() error {
 	b.resetConsistentReadReady()
 	return nil
@@ -166,19 +200,39 @@ func (b *Broker) establishLeadership() error {
 func (b *Broker) leaderLoop(stopCh chan struct{}) {
 	var reconcileCh chan serf.Member
 	establishedLeader := false
+	// failures is how many times in a row reconciling has failed, the loop backs off while it
+	// keeps failing rather than retrying every interval
+	failures := 0
+	var interval <-chan time.Time
+	// the topic deletions stop with the loop, before another can start them. They're started
+	// here rather than in their goroutine so topics can be queued as soon as the loop runs.
+	var deletions sync.WaitGroup
+	b.topicDeletions.start()
+	deletions.Add(1)
+	go func() {
+		defer deletions.Done()
+		b.runTopicDeletions(stopCh)
+	}()
+	defer deletions.Wait()
 
 RECONCILE:
 	reconcileCh = nil
-	interval := time.After(b.config.ReconcileInterval)
+	interval = time.After(b.config.ReconcileInterval)
 	barrier := b.raft.Barrier(barrierWriteTimeout)
-	if err := barrier.Error(); err != nil {
-		b.logger.Error("leader: failed to wait for barrier", log.Error("error", err))
+	if err := b.waitRaftFuture(barrier); err != nil {
+		failures++
+		backoff := b.reconcileFailed(failures)
+		b.logger.Error("leader: failed to wait for barrier", log.Error("error", err), log.Duration("backoff", backoff))
+		interval = time.After(backoff)
 		goto WAIT
 	}
 
 	if !establishedLeader {
 		if err := b.establishLeadership(); err != nil {
-			b.logger.Error("leader: failedto establish leader", log.Error("error", err))
+			failures++
+			backoff := b.reconcileFailed(failures)
+			b.logger.Error("leader: failedto establish leader", log.Error("error", err), log.Duration("backoff", backoff))
+			interval = time.After(backoff)
 			goto WAIT
 		}
 		establishedLeader = true
@@ -190,9 +244,13 @@ RECONCILE:
 	}
 
 	if err := b.reconcile(); err != nil {
-		b.logger.Error("leader: failed to reconcile", log.Error("error", err))
+		failures++
+		backoff := b.reconcileFailed(failures)
+		b.logger.Error("leader: failed to reconcile", log.Error("error", err), log.Int("failures", failures), log.Duration("backoff", backoff))
+		interval = time.After(backoff)
 		goto WAIT
 	}
+	failures = 0
 
 	reconcileCh = b.reconcileCh
 
@@ -207,10 +265,53 @@ WAIT:
 			goto RECONCILE
 		case member := <-reconcileCh:
 			b.reconcileMember(member)
+			b.recordLifecycles([]serf.Member{member})
+		case p := <-b.offlineCh:
+			if err := b.handleOfflinePartition(p); err != nil {
+				b.logger.Error("leader: failed to handle offline partition", log.Error("error", err), log.Any("partition", p))
+			}
+		case r := <-b.electLeadersCh:
+			r.result <- b.runElections(r)
+		case r := <-b.controlledShutdownCh:
+			r.result <- b.runControlledShutdown(r.broker)
+		case r := <-b.reassignmentsCh:
+			r.result <- b.runReassignments(r)
+		case r := <-b.alterPartitionCh:
+			r.result <- b.runAlterPartition(r)
 		}
 	}
 }
 
+// reconcileFailed records the leader loop failed to reconcile the failures-th time in a row and
+// returns how long to back off before retrying.
+func (b *Broker) reconcileFailed(failures int) time.Duration {
+	if b.metrics != nil {
+		b.metrics.ReconcileFailures.Add(1)
+	}
+	return b.reconcileBackoff(failures)
+}
+
+// reconcileBackoff returns how long to wait before reconciling again after failing the
+// failures-th time in a row. It doubles the reconcile interval for each failure up to the max
+// backoff, jittered so the wait's between half and all of that.
+func (b *Broker) reconcileBackoff(failures int) time.Duration {
+	backoff := b.config.ReconcileInterval
+	max := b.config.ReconcileMaxBackoff
+	if max < backoff {
+		max = backoff
+	}
+	for i := 0; i < failures && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	if half := int64(backoff / 2); half > 0 {
+		backoff = time.Duration(half + rand.Int63n(half+1))
+	}
+	return backoff
+}
+
 // reconcile is used to reconcile the differences between serf membership and what'b reflected in the strongly consistent store.
 func (b *Broker) reconcile() error {
 	members := b.LANMembers()
@@ -225,7 +326,23 @@ func (b *Broker) reconcile() error {
 		}
 		knownMembers[meta.ID.Int32()] = struct{}{}
 	}
-	return b.reconcileReaped(knownMembers)
+	if err := b.reconcileReaped(knownMembers); err != nil {
+		return err
+	}
+	if err := b.reapFailedNodes(); err != nil {
+		return err
+	}
+	b.recordLifecycles(members)
+	if err := b.expandISRs(); err != nil {
+		return err
+	}
+	if err := b.completeReassignments(); err != nil {
+		return err
+	}
+	if b.config.AutoLeaderRebalance {
+		return b.electPreferredLeaders()
+	}
+	return nil
 }
 
 func (b *Broker) reconcileReaped(known map[int32]struct{}) error {
@@ -235,22 +352,463 @@ func (b *Broker) reconcileReaped(known map[int32]struct{}) error {
 		return err
 	}
 	for _, node := range nodes {
-		if _, ok := known[node.ID]; ok {
+		if _, ok := known[node.Node]; ok {
 			continue
 		}
-		member := serf.Member{
-			Tags: map[string]string{
-				"id":   fmt.Sprintf("%b", node.ID),
-				"role": "jocko",
-			},
+		if err := b.handleReapMember(nodeMember(node.Node)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeMember returns a serf member for the node to deregister nodes that aren't serf members.
+func nodeMember(id int32) serf.Member {
+	return serf.Member{
+		Tags: map[string]string{
+			"id":   fmt.Sprintf("%d", id),
+			"role": "jocko",
+		},
+	}
+}
+
+// reapFailedNodes deregisters brokers that have been failed for longer than the failed node TTL,
+// once their replicas have been moved to other brokers.
+func (b *Broker) reapFailedNodes() error {
+	if b.config.FailedNodeTTL <= 0 {
+		return nil
+	}
+	alive := make(map[int32]bool)
+	for _, member := range b.LANMembers() {
+		if meta, ok := metadata.IsBroker(member); ok && member.Status == serf.StatusAlive {
+			alive[meta.ID.Int32()] = true
+		}
+	}
+	_, nodes, err := b.fsm.State().GetNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if node.Node == b.config.ID || alive[node.Node] || node.Check == nil {
+			continue
+		}
+		if node.Check.Status != structs.HealthCritical || node.Check.Since.IsZero() || time.Since(node.Check.Since) < b.config.FailedNodeTTL {
+			continue
+		}
+		if err := b.handleReapMember(nodeMember(node.Node)); err != nil {
+			// try again next time
+			b.logger.Error("leader: failed to reap failed node", log.Int32("node", node.Node), log.Error("error", err))
+		}
+	}
+	return nil
+}
+
+// rebuildReplicas assigns the node's replicas to other passing brokers, which then replicate the
+// partitions from their leaders. It's run until it succeeds, once it has the node's deregistered,
+// so it picks up where a failed run left off: the topics' assignments are registered first and
+// are what the partitions are moved to, and the partitions it's moved are sent to their brokers
+// until they're all sent.
+func (b *Broker) rebuildReplicas(id int32) error {
+	state := b.fsm.State()
+	_, nodes, err := state.GetNodes()
+	if err != nil {
+		return err
+	}
+	var passing []int32
+	for _, n := range nodes {
+		if n.Node != id && n.Check != nil && n.Check.Status == structs.HealthPassing {
+			passing = append(passing, n.Node)
+		}
+	}
+	_, partitions, err := state.GetPartitions()
+	if err != nil {
+		return err
+	}
+	topics := make(map[string]*structs.Topic)
+	changedTopics := make(map[string]bool)
+	var changed []structs.Partition
+	for _, p := range partitions {
+		topic, ok := topics[p.Topic]
+		if !ok {
+			_, t, err := state.GetTopic(p.Topic)
+			if err != nil {
+				return err
+			}
+			if t == nil {
+				continue
+			}
+			// copy the topic and its assignments since they're the store's
+			tt := *t
+			tt.Partitions = make(map[int32][]int32, len(t.Partitions))
+			for k, v := range t.Partitions {
+				tt.Partitions[k] = v
+			}
+			topic = &tt
+			topics[p.Topic] = topic
+		}
+		ar := topic.Partitions[p.ID]
+		if i := indexOfInt32(ar, id); i != -1 {
+			var candidates []int32
+			for _, n := range passing {
+				if !containsInt32(ar, n) {
+					candidates = append(candidates, n)
+				}
+			}
+			if len(candidates) == 0 {
+				return fmt.Errorf("no broker to move replica of %s-%d to", p.Topic, p.ID)
+			}
+			ar = append([]int32(nil), ar...)
+			ar[i] = candidates[rand.Intn(len(candidates))]
+			topic.Partitions[p.ID] = ar
+			changedTopics[p.Topic] = true
+		} else if !containsInt32(p.AR, id) && !containsInt32(p.ISR, id) && p.Leader != id {
+			continue
+		}
+		pp := *p
+		pp.AR = ar
+		pp.ISR = withoutInt32(p.ISR, id)
+		if pp.Leader == id {
+			pp.Leader = -1
+			for _, r := range pp.ISR {
+				if containsInt32(passing, r) {
+					pp.Leader = r
+					break
+				}
+			}
+			if pp.Leader == -1 {
+				return fmt.Errorf("no passing in-sync replica to lead %s-%d", p.Topic, p.ID)
+			}
+			pp.LeaderEpoch++
+		}
+		changed = append(changed, pp)
+	}
+	for name := range changedTopics {
+		if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: *topics[name]}); err != nil {
+			return err
+		}
+	}
+	if b.rebuilds == nil {
+		b.rebuilds = make(map[int32]map[topicPartition]bool)
+	}
+	pending, ok := b.rebuilds[id]
+	if !ok {
+		pending = make(map[topicPartition]bool)
+		b.rebuilds[id] = pending
+	}
+	for _, p := range changed {
+		if _, err := b.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: p}); err != nil {
+			return err
+		}
+		pending[topicPartition{topic: p.Topic, partition: p.ID}] = true
+	}
+	if len(changed) != 0 {
+		b.logger.Info("leader: moved replicas of failed node", log.Int32("node", id), log.Int("partitions", len(changed)))
+	}
+	// send the partitions' current states, they may have changed since they were moved
+	var send []structs.Partition
+	for tp := range pending {
+		_, p, err := state.GetPartition(tp.topic, tp.partition)
+		if err != nil {
+			return err
+		}
+		if p != nil {
+			send = append(send, *p)
+		}
+	}
+	ctx := &Context{parent: context.Background()}
+	if err := b.sendLeaderAndISR(ctx, send); err != protocol.ErrNone {
+		return err
+	}
+	delete(b.rebuilds, id)
+	return nil
+}
+
+// indexOfInt32 returns the index of id in ids, or -1 if it isn't in them.
+func indexOfInt32(ids []int32, id int32) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsInt32(ids []int32, id int32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutInt32 returns a copy of ids without id.
+func withoutInt32(ids []int32, id int32) []int32 {
+	out := make([]int32, 0, len(ids))
+	for _, i := range ids {
+		if i != id {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// passingNodes returns the ids of the brokers whose health checks are passing.
+func (b *Broker) passingNodes() (map[int32]bool, error) {
+	_, nodes, err := b.fsm.State().GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	passing := make(map[int32]bool, len(nodes))
+	for _, n := range nodes {
+		// brokers shutting down aren't given partitions to lead or put back in isrs
+		if n.Check != nil && n.Check.Status == structs.HealthPassing && !b.isShuttingDown(n.Node) {
+			passing[n.Node] = true
+		}
+	}
+	return passing, nil
+}
+
+// shrinkFailedISRs takes the failed broker out of its partitions' isrs and moves the leadership
+// of the partitions it led to in-sync replicas. It stays assigned its replicas so it can catch up
+// and rejoin the isrs if it recovers. Partitions without another in-sync replica are left be
+// rather than risk losing messages by electing a replica that isn't in sync.
+func (b *Broker) shrinkFailedISRs(id int32) error {
+	passing, err := b.passingNodes()
+	if err != nil {
+		return err
+	}
+	_, partitions, err := b.fsm.State().GetPartitions()
+	if err != nil {
+		return err
+	}
+	var changed []structs.Partition
+	for _, p := range partitions {
+		if pp, ok := b.shrinkISR(p, id, passing); ok {
+			changed = append(changed, pp)
+		}
+	}
+	return b.updatePartitions(changed)
+}
+
+// shrinkISR returns the partition with the broker taken out of its isr and, if it led it, its
+// leadership moved to a passing in-sync replica. It returns false if the broker isn't in the isr
+// or there's no replica to lead the partition.
+func (b *Broker) shrinkISR(p *structs.Partition, id int32, passing map[int32]bool) (structs.Partition, bool) {
+	if !containsInt32(p.ISR, id) {
+		return structs.Partition{}, false
+	}
+	pp := *p
+	pp.ISR = withoutInt32(p.ISR, id)
+	if p.Leader != id {
+		return pp, true
+	}
+	for _, r := range pp.ISR {
+		if passing[r] {
+			pp.Leader = r
+			pp.LeaderEpoch++
+			return pp, true
+		}
+	}
+	b.logger.Info("leader: no in-sync replica to lead partition", log.Int32("node", id), log.String("topic", p.Topic), log.Int32("partition", p.ID))
+	return structs.Partition{}, false
+}
+
+// recoverReplicas sends a broker that's recovered the states of the partitions it's assigned so
+// it follows their leaders and catches up, after which it's added back to their isrs.
+func (b *Broker) recoverReplicas(id int32) error {
+	_, partitions, err := b.fsm.State().GetPartitions()
+	if err != nil {
+		return err
+	}
+	var ps []structs.Partition
+	for _, p := range partitions {
+		if containsInt32(p.AR, id) {
+			ps = append(ps, *p)
+		}
+	}
+	req, ok := b.leaderAndISRRequests(ps)[id]
+	if !ok {
+		return nil
+	}
+	ctx := &Context{parent: context.Background()}
+	if err := b.sendLeaderAndISRRequest(ctx, id, req); err != protocol.ErrNone {
+		return err
+	}
+	b.logger.Info("leader: sent recovered node its partitions", log.Int32("node", id), log.Int("partitions", len(ps)))
+	return nil
+}
+
+// expandISRs adds the replicas that have caught up with their leaders back into their
+// partitions' isrs, like the replicas of a broker that failed and has recovered. The log end
+// offsets are requested with one request per broker, the leaders' first so a replica's in sync
+// if it's reached where its leader was, it'd never catch up while messages are being produced
+// otherwise.
+func (b *Broker) expandISRs() error {
+	passing, err := b.passingNodes()
+	if err != nil {
+		return err
+	}
+	_, partitions, err := b.fsm.State().GetPartitions()
+	if err != nil {
+		return err
+	}
+	candidates := make(map[topicPartition][]int32)
+	leaders := make(map[int32][]topicPartition)
+	for _, p := range partitions {
+		if !passing[p.Leader] {
+			continue
+		}
+		tp := topicPartition{topic: p.Topic, partition: p.ID}
+		for _, id := range p.AR {
+			if !containsInt32(p.ISR, id) && passing[id] {
+				candidates[tp] = append(candidates[tp], id)
+			}
+		}
+		if len(candidates[tp]) != 0 {
+			leaders[p.Leader] = append(leaders[p.Leader], tp)
+		}
+	}
+	if len(leaders) == 0 {
+		return nil
+	}
+	leaderOffsets := make(map[topicPartition]int64)
+	for id, tps := range leaders {
+		offsets, err := b.logEndOffsets(id, tps)
+		if err != nil {
+			b.logger.Error("leader: failed to get leader's log end offsets", log.Error("error", err), log.Int32("node", id))
+			continue
+		}
+		for tp, offset := range offsets {
+			leaderOffsets[tp] = offset
+		}
+	}
+	replicas := make(map[int32][]topicPartition)
+	for tp, ids := range candidates {
+		if _, ok := leaderOffsets[tp]; !ok {
+			continue
+		}
+		for _, id := range ids {
+			replicas[id] = append(replicas[id], tp)
 		}
-		if err := b.handleReapMember(member); err != nil {
+	}
+	caughtUp := make(map[topicPartition][]int32)
+	for id, tps := range replicas {
+		offsets, err := b.logEndOffsets(id, tps)
+		if err != nil {
+			b.logger.Debug("leader: failed to get replica's log end offsets", log.Error("error", err), log.Int32("node", id))
+			continue
+		}
+		for tp, offset := range offsets {
+			if offset >= leaderOffsets[tp] {
+				caughtUp[tp] = append(caughtUp[tp], id)
+			}
+		}
+	}
+	var changed []structs.Partition
+	for _, p := range partitions {
+		ids := caughtUp[topicPartition{topic: p.Topic, partition: p.ID}]
+		if len(ids) == 0 {
+			continue
+		}
+		// keep the isr in the order of the assigned replicas
+		sort.Slice(ids, func(i, j int) bool { return indexOfInt32(p.AR, ids[i]) < indexOfInt32(p.AR, ids[j]) })
+		pp := *p
+		pp.ISR = append(append([]int32(nil), p.ISR...), ids...)
+		changed = append(changed, pp)
+	}
+	return b.updatePartitions(changed)
+}
+
+// electPreferredLeaders moves the leadership of partitions back to their preferred replicas, the
+// first they're assigned, once they're in sync so leadership is spread evenly again after a
+// broker recovers.
+func (b *Broker) electPreferredLeaders() error {
+	passing, err := b.passingNodes()
+	if err != nil {
+		return err
+	}
+	_, partitions, err := b.fsm.State().GetPartitions()
+	if err != nil {
+		return err
+	}
+	var changed []structs.Partition
+	for _, p := range partitions {
+		if len(p.AR) == 0 {
+			continue
+		}
+		preferred := p.AR[0]
+		if p.Leader == preferred || !passing[preferred] || !containsInt32(p.ISR, preferred) {
+			continue
+		}
+		pp := *p
+		pp.Leader = preferred
+		pp.LeaderEpoch++
+		changed = append(changed, pp)
+	}
+	return b.updatePartitions(changed)
+}
+
+// updatePartitions registers the partitions' new states and sends them to their replicas.
+func (b *Broker) updatePartitions(ps []structs.Partition) error {
+	if len(ps) == 0 {
+		return nil
+	}
+	for _, p := range ps {
+		if _, err := b.raftApply(structs.RegisterPartitionRequestType, structs.RegisterPartitionRequest{Partition: p}); err != nil {
 			return err
 		}
 	}
+	ctx := &Context{parent: context.Background()}
+	if err := b.sendLeaderAndISR(ctx, ps); err != protocol.ErrNone {
+		return err
+	}
 	return nil
 }
 
+// logEndOffsets returns the offsets of the next messages in the broker's replicas of the
+// partitions, requested in one request. Partitions the broker returned an error for are left out.
+func (b *Broker) logEndOffsets(id int32, tps []topicPartition) (map[topicPartition]int64, error) {
+	req := &protocol.OffsetsRequest{ReplicaID: b.config.ID}
+	topics := make(map[string]*protocol.OffsetsTopic)
+	for _, tp := range tps {
+		t, ok := topics[tp.topic]
+		if !ok {
+			t = &protocol.OffsetsTopic{Topic: tp.topic}
+			topics[tp.topic] = t
+			req.Topics = append(req.Topics, t)
+		}
+		t.Partitions = append(t.Partitions, &protocol.OffsetsPartition{Partition: tp.partition, Timestamp: -1, MaxNumOffsets: 1})
+	}
+	var resp *protocol.OffsetsResponse
+	if id == b.config.ID {
+		resp = b.handleOffsets(&Context{parent: context.Background()}, req)
+	} else {
+		broker := b.brokerLookup.BrokerByID(raft.ServerID(id))
+		if broker == nil {
+			return nil, protocol.ErrBrokerNotAvailable
+		}
+		conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if resp, err = conn.Offsets(req); err != nil {
+			return nil, err
+		}
+	}
+	offsets := make(map[topicPartition]int64)
+	for _, t := range resp.Responses {
+		for _, p := range t.PartitionResponses {
+			if p.ErrorCode != protocol.ErrNone.Code() || len(p.Offsets) == 0 {
+				continue
+			}
+			offsets[topicPartition{topic: t.Topic, partition: p.Partition}] = p.Offsets[0]
+		}
+	}
+	return offsets, nil
+}
+
 func (b *Broker) reconcileMember(m serf.Member) error {
 	var err error
 	switch m.Status {
@@ -281,10 +839,18 @@ func (b *Broker) handleAliveMember(m serf.Member) error {
 	if err != nil {
 		return err
 	}
-	if node != nil {
+	if node != nil && node.Check != nil && node.Check.Status == structs.HealthPassing {
 		// TODO: should still register?
 		return nil
 	}
+	if node != nil {
+		delete(b.shuttingDown, meta.ID.Int32())
+		// the broker failed and has recovered, it's sent its partitions before it's marked
+		// alive so it's retried on the next reconcile if sending them fails
+		if err := b.recoverReplicas(meta.ID.Int32()); err != nil {
+			return err
+		}
+	}
 	b.logger.Info("leader: member joined, marking health alive", log.Any("member", m))
 	req := structs.RegisterNodeRequest{
 		Node: structs.Node{
@@ -301,6 +867,7 @@ func (b *Broker) handleAliveMember(m serf.Member) error {
 				Name:    structs.SerfCheckName,
 				Status:  structs.HealthPassing,
 				Output:  structs.SerfCheckAliveOutput,
+				Since:   time.Now(),
 			},
 		},
 	}
@@ -313,18 +880,48 @@ func (b *Broker) raftApply(t structs.MessageType, msg interface{}) (interface{},
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode request: %v", err)
 	}
+	start := time.Now()
 	future := b.raft.Apply(buf, 30*time.Second)
-	if err := future.Error(); err != nil {
+	if err := b.waitRaftFuture(future); err != nil {
 		return nil, err
 	}
+	b.raftCommitted(start)
 	return future.Response(), nil
 }
 
+// waitRaftFuture waits for the future's error. Raft can shut down without answering futures for
+// entries it's committed, like once it's committed its own removal, so it returns
+// raft.ErrRaftShutdown if raft's shut down before the future's answered.
+func (b *Broker) waitRaftFuture(future raft.Future) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- future.Error()
+	}()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case <-ticker.C:
+			if b.raft.State() == raft.Shutdown {
+				return raft.ErrRaftShutdown
+			}
+		}
+	}
+}
+
 func (b *Broker) handleLeftMember(m serf.Member) error {
 	return b.handleDeregisterMember("left", m)
 }
 
+// handleReapMember deregisters the member once its replicas have been moved to other brokers.
 func (b *Broker) handleReapMember(member serf.Member) error {
+	if meta, ok := metadata.IsBroker(member); ok && meta.ID.Int32() != b.config.ID {
+		if err := b.rebuildReplicas(meta.ID.Int32()); err != nil {
+			return err
+		}
+	}
 	return b.handleDeregisterMember("reaped", member)
 }
 
@@ -343,6 +940,7 @@ func (b *Broker) handleDeregisterMember(reason string, member serf.Member) error
 	if err := b.removeServer(member, meta); err != nil {
 		return err
 	}
+	delete(b.shuttingDown, meta.ID.Int32())
 
 	state := b.fsm.State()
 	_, node, err := state.GetNode(meta.ID.Int32())
@@ -388,14 +986,14 @@ func (b *Broker) joinCluster(m serf.Member, parts *metadata.Broker) error {
 
 	if parts.NonVoter {
 		addFuture := b.raft.AddNonvoter(raft.ServerID(parts.ID), raft.ServerAddress(parts.RaftAddr), 0, 0)
-		if err := addFuture.Error(); err != nil {
+		if err := b.waitRaftFuture(addFuture); err != nil {
 			b.logger.Error("leader: failed to add raft peer", log.Error("error", err))
 			return err
 		}
 	} else {
 		b.logger.Debug("leader: join cluster: add voter", log.Any("member", parts))
 		addFuture := b.raft.AddVoter(raft.ServerID(parts.ID), raft.ServerAddress(parts.RaftAddr), 0, 0)
-		if err := addFuture.Error(); err != nil {
+		if err := b.waitRaftFuture(addFuture); err != nil {
 			b.logger.Error("leader: failed to add raft peer", log.Error("error", err))
 			return err
 		}
@@ -410,6 +1008,17 @@ func (b *Broker) handleFailedMember(m serf.Member) error {
 		return nil
 	}
 
+	// keep when the node failed so it's reaped after it's been failed for the ttl, not after the
+	// last reconcile
+	since := time.Now()
+	_, node, err := b.fsm.State().GetNode(meta.ID.Int32())
+	if err != nil {
+		return err
+	}
+	if node != nil && node.Check != nil && node.Check.Status == structs.HealthCritical && !node.Check.Since.IsZero() {
+		since = node.Check.Since
+	}
+
 	req := structs.RegisterNodeRequest{
 		Node: structs.Node{
 			Node: meta.ID.Int32(),
@@ -419,6 +1028,7 @@ func (b *Broker) handleFailedMember(m serf.Member) error {
 				Name:    structs.SerfCheckName,
 				Status:  structs.HealthCritical,
 				Output:  structs.SerfCheckFailedOutput,
+				Since:   since,
 			},
 		},
 	}
@@ -430,16 +1040,6 @@ func (b *Broker) handleFailedMember(m serf.Member) error {
 
 	state := b.fsm.State()
 
-	_, partitions, err := state.GetPartitions()
-	if err != nil {
-		panic(err)
-	}
-
-	// need to reassign partitions
-	_, partitions, err = state.PartitionsByLeader(meta.ID.Int32())
-	if err != nil {
-		return err
-	}
 	_, nodes, err := state.GetNodes()
 	if err != nil {
 		return err
@@ -453,11 +1053,18 @@ func (b *Broker) handleFailedMember(m serf.Member) error {
 		}
 	}
 
-	// reassign consumer group coordinators
-	_, groups, err := state.GetGroupsByCoordinator(meta.ID.Int32())
+	// reassign consumer group coordinators, unless they're persisted in the offsets topic and
+	// the new leaders of their partitions take them over as they load them
+	_, offsets, err := state.GetTopic(OffsetsTopicName)
 	if err != nil {
 		return err
 	}
+	var groups []*structs.Group
+	if offsets == nil {
+		if _, groups, err = state.GetGroupsByCoordinator(meta.ID.Int32()); err != nil {
+			return err
+		}
+	}
 	for _, group := range groups {
 		i := rand.Intn(len(passing))
 		node := passing[i]
@@ -470,76 +1077,7 @@ func (b *Broker) handleFailedMember(m serf.Member) error {
 		}
 	}
 
-	leaderAndISRReq := &protocol.LeaderAndISRRequest{
-		ControllerID:    b.config.ID,
-		PartitionStates: make([]*protocol.PartitionState, 0, len(partitions)),
-		// TODO: LiveLeaders, ControllerEpoch
-	}
-	for _, p := range partitions {
-		i := rand.Intn(len(passing))
-		// TODO: check that old leader won't be in this list, will have been deregistered removed from fsm
-		node := passing[i]
-
-		// TODO: need to check replication factor
-
-		var ar []int32
-		for _, r := range p.AR {
-			if r != meta.ID.Int32() {
-				ar = append(ar, r)
-			}
-		}
-		var isr []int32
-		for _, r := range p.ISR {
-			if r != meta.ID.Int32() {
-				isr = append(isr, r)
-			}
-		}
-
-		// TODO: need to update epochs
-
-		req := structs.RegisterPartitionRequest{
-			Partition: structs.Partition{
-				Topic:     p.Topic,
-				ID:        p.Partition,
-				Partition: p.Partition,
-				Leader:    node.Node,
-				AR:        ar,
-				ISR:       isr,
-			},
-		}
-		if _, err = b.raftApply(structs.RegisterPartitionRequestType, req); err != nil {
-			return err
-		}
-		// TODO: need to send on leader and isr changes now i think
-		leaderAndISRReq.PartitionStates = append(leaderAndISRReq.PartitionStates, &protocol.PartitionState{
-			Topic:     p.Topic,
-			Partition: p.Partition,
-			// TODO: ControllerEpoch, LeaderEpoch, ZKVersion - lol
-			Leader:   p.Leader,
-			ISR:      p.ISR,
-			Replicas: p.AR,
-		})
-	}
-
-	// TODO: optimize this to send requests to only nodes affected
-	for _, n := range passing {
-		broker := b.brokerLookup.BrokerByID(raft.ServerID(n.Node))
-		if broker == nil {
-			// TODO: this probably shouldn't happen -- likely a root issue to fix
-			b.logger.Error("trying to assign partitions to unknown broker", log.Any("broker", n))
-			continue
-		}
-		conn, err := defaultDialer.Dial("tcp", broker.BrokerAddr)
-		if err != nil {
-			return err
-		}
-		_, err = conn.LeaderAndISR(leaderAndISRReq)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return b.shrinkFailedISRs(meta.ID.Int32())
 }
 
 func (b *Broker) removeServer(m serf.Member, meta *metadata.Broker) error {
@@ -554,7 +1092,7 @@ func (b *Broker) removeServer(m serf.Member, meta *metadata.Broker) error {
 		}
 		b.logger.Info("leader: removing server by id", log.Any("server id", server.ID))
 		future := b.raft.RemoveServer(raft.ServerID(meta.ID), 0, 0)
-		if err := future.Error(); err != nil {
+		if err := b.waitRaftFuture(future); err != nil {
 			b.logger.Error("leader: failed to remove server", log.Error("error", err))
 			return err
 		}
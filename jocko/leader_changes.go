@@ -0,0 +1,181 @@
+package jocko
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// LeaderChangesTopicName is the compacted topic the controller publishes partitions' leaders to
+// when the broker's configured with PublishLeaderChanges, so external systems like schema
+// registries and stream processors placing standby tasks can follow partitions as they move
+// rather than polling Metadata.
+//
+// The topic has a single partition so changes are read in the order they were made. Each record
+// is a v1 message keyed by "<topic>-<partition>" whose value is a JSON LeaderChange. Compaction
+// keeps the latest for each partition, and deleting a topic publishes tombstones, records with
+// null values, for its partitions. Like any produce, changes made while the cluster's read-only
+// aren't published.
+const LeaderChangesTopicName = "__leader_changes"
+
+// leaderChangesReplicationFactor is the most replicas the leader changes topic's created with.
+const leaderChangesReplicationFactor = 3
+
+// LeaderChange is the value of the records in the leader changes topic.
+type LeaderChange struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Leader is the ID of the broker leading the partition, -1 if it's offline.
+	Leader      int32   `json:"leader"`
+	LeaderEpoch int32   `json:"leader_epoch"`
+	ISR         []int32 `json:"isr"`
+	Replicas    []int32 `json:"replicas"`
+	// Controller is the ID of the controller that made the change.
+	Controller int32 `json:"controller"`
+	// Timestamp is when the change was published, in milliseconds since the epoch.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// leaderChangeKey returns the key of the partition's records in the leader changes topic.
+func leaderChangeKey(topic string, partition int32) []byte {
+	return []byte(fmt.Sprintf("%s-%d", topic, partition))
+}
+
+// publishLeaderChanges publishes the partitions' leaders to the leader changes topic, creating
+// it first if needed. The leadership changes are committed by now, so failures are logged
+// rather than failing the change.
+func (b *Broker) publishLeaderChanges(ctx *Context, ps []structs.Partition) {
+	if !b.config.PublishLeaderChanges {
+		return
+	}
+	now := time.Now()
+	var messages []*protocol.Message
+	for _, p := range ps {
+		// the topic's own leaders aren't published, that'd publish changes as it's created
+		if p.Topic == LeaderChangesTopicName {
+			continue
+		}
+		value, err := json.Marshal(LeaderChange{
+			Topic:       p.Topic,
+			Partition:   p.ID,
+			Leader:      p.Leader,
+			LeaderEpoch: p.LeaderEpoch,
+			ISR:         p.ISR,
+			Replicas:    p.AR,
+			Controller:  b.config.ID,
+			Timestamp:   now.UnixNano() / int64(time.Millisecond),
+		})
+		if err != nil {
+			b.logger.Error("failed to marshal leader change", log.Error("error", err))
+			return
+		}
+		messages = append(messages, &protocol.Message{MagicByte: 1, Timestamp: now, Key: leaderChangeKey(p.Topic, p.ID), Value: value})
+	}
+	b.publishLeaderChangeMessages(ctx, messages)
+}
+
+// publishLeaderTombstones publishes tombstones for the deleted topic's partitions so compaction
+// removes their records from the leader changes topic.
+func (b *Broker) publishLeaderTombstones(ctx *Context, ps []*structs.Partition) {
+	if !b.config.PublishLeaderChanges {
+		return
+	}
+	now := time.Now()
+	var messages []*protocol.Message
+	for _, p := range ps {
+		if p.Topic == LeaderChangesTopicName {
+			continue
+		}
+		messages = append(messages, &protocol.Message{MagicByte: 1, Timestamp: now, Key: leaderChangeKey(p.Topic, p.ID)})
+	}
+	b.publishLeaderChangeMessages(ctx, messages)
+}
+
+// publishLeaderChangeMessages produces the messages to the leader changes topic's leader.
+func (b *Broker) publishLeaderChangeMessages(ctx *Context, messages []*protocol.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	p, err := b.leaderChangesPartition(ctx)
+	if err != nil {
+		b.logger.Error("failed to get leader changes topic", log.Error("error", err))
+		return
+	}
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Messages: messages})
+	if err != nil {
+		b.logger.Error("failed to encode leader changes", log.Error("error", err))
+		return
+	}
+	req := &protocol.ProduceRequest{
+		Acks:    1,
+		Timeout: 5 * time.Second,
+		TopicData: []*protocol.TopicData{{
+			Topic: LeaderChangesTopicName,
+			Data:  []*protocol.Data{{Partition: p.ID, RecordSet: recordSet}},
+		}},
+	}
+	var resp *protocol.ProduceResponse
+	if p.Leader == b.config.ID {
+		resp = b.handleProduce(ctx, req)
+	} else {
+		broker := b.brokerLookup.BrokerByID(raft.ServerID(p.Leader))
+		if broker == nil {
+			b.logger.Error("leader changes topic's leader is unknown", log.Int32("leader", p.Leader))
+			return
+		}
+		conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
+		if err != nil {
+			b.logger.Error("failed to dial leader changes topic's leader", log.Int32("leader", p.Leader), log.Error("error", err))
+			return
+		}
+		resp, err = conn.Produce(req)
+		conn.Close()
+		if err != nil {
+			b.logger.Error("failed to publish leader changes", log.Error("error", err))
+			return
+		}
+	}
+	for _, tr := range resp.Responses {
+		for _, pr := range tr.PartitionResponses {
+			if pr.ErrorCode != protocol.ErrNone.Code() {
+				b.logger.Error("failed to publish leader changes", log.Error("error", protocol.Errs[pr.ErrorCode]))
+			}
+		}
+	}
+}
+
+// leaderChangesPartition returns the leader changes topic's partition, creating the topic if it
+// doesn't exist yet.
+func (b *Broker) leaderChangesPartition(ctx *Context) (*structs.Partition, error) {
+	state := b.fsm.State()
+	_, p, err := state.GetPartition(LeaderChangesTopicName, 0)
+	if err != nil || p != nil {
+		return p, err
+	}
+	replicationFactor := len(b.LANMembers())
+	if replicationFactor > leaderChangesReplicationFactor {
+		replicationFactor = leaderChangesReplicationFactor
+	}
+	ps := b.buildPartitions(LeaderChangesTopicName, 1, int16(replicationFactor))
+	topic := structs.Topic{
+		Topic:      LeaderChangesTopicName,
+		Partitions: map[int32][]int32{0: ps[0].AR},
+		Config:     structs.NewTopicConfig().SetValue("cleanup.policy", commitlog.CompactCleanupPolicy),
+	}
+	if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: topic}); err != nil {
+		return nil, err
+	}
+	if err := b.createPartition(ps[0]); err != nil {
+		return nil, err
+	}
+	if err := b.sendLeaderAndISR(ctx, ps); err != protocol.ErrNone {
+		return nil, err
+	}
+	return &ps[0], nil
+}
@@ -0,0 +1,54 @@
+package jocko
+
+import "github.com/travisjeffery/jocko/protocol"
+
+// epochLog is implemented by commit logs that keep a leader epoch cache.
+type epochLog interface {
+	AssignEpoch(epoch int32, offset int64) error
+	LatestEpoch() int32
+	EndOffsetForEpoch(epoch int32) (int32, int64)
+}
+
+// truncatableLog is implemented by commit logs followers can truncate to their leader's.
+type truncatableLog interface {
+	TruncateTo(offset int64) error
+}
+
+// checkLeaderEpoch checks the current leader epoch a client sent against the partition's,
+// fencing clients with a stale or newer view of the partition's leadership unless it's -1.
+func checkLeaderEpoch(currentEpoch, leaderEpoch int32) protocol.Error {
+	switch {
+	case currentEpoch == -1:
+		return protocol.ErrNone
+	case currentEpoch < leaderEpoch:
+		return protocol.ErrFencedLeaderEpoch
+	case currentEpoch > leaderEpoch:
+		return protocol.ErrUnknownLeaderEpoch
+	}
+	return protocol.ErrNone
+}
+
+// endOffsetForEpoch returns the largest epoch up to the given one of the partition, which this
+// broker must lead, and the offset after the epoch's last message, after checking the current
+// leader epoch.
+func (b *Broker) endOffsetForEpoch(topic string, partition, currentEpoch, epoch int32) (int32, int64, protocol.Error) {
+	replica, err := b.replicaLookup.Replica(topic, partition)
+	if err != nil {
+		return -1, -1, protocol.ErrUnknownTopicOrPartition
+	}
+	if replica.Partition.Leader != b.config.ID {
+		return -1, -1, protocol.ErrNotLeaderForPartition
+	}
+	if err := checkLeaderEpoch(currentEpoch, replica.Partition.LeaderEpoch); err != protocol.ErrNone {
+		return -1, -1, err
+	}
+	if replica.Log == nil {
+		return -1, -1, protocol.ErrReplicaNotAvailable
+	}
+	l, ok := replica.Log.(epochLog)
+	if !ok {
+		return -1, -1, protocol.ErrUnknown
+	}
+	endEpoch, offset := l.EndOffsetForEpoch(epoch)
+	return endEpoch, offset, protocol.ErrNone
+}
@@ -0,0 +1,171 @@
+package jocko
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// leaderLeases is the lease the controller granted this broker on the partitions it leads. A
+// leader only serves produces and consumers' fetches of a partition while its lease covers the
+// partition in its current leader epoch, so once the controller moves the partition's leadership
+// the old leader stops serving it within the lease's duration, even if it never gets the
+// LeaderAndISR request telling it so.
+type leaderLeases struct {
+	mu sync.Mutex
+	// expires is when the lease runs out, measured from when its renewal was sent so it runs out
+	// on the broker before the controller's view of it. Times carry the monotonic clock so
+	// changes to the wall clock don't stretch it.
+	expires time.Time
+	epochs  map[topicPartition]int32
+	// renew is sent to to renew the lease right away, like when the broker becomes a leader.
+	renew chan struct{}
+}
+
+func newLeaderLeases() *leaderLeases {
+	return &leaderLeases{
+		epochs: make(map[topicPartition]int32),
+		renew:  make(chan struct{}, 1),
+	}
+}
+
+// grant records the lease the controller granted in answer to the renewal sent at the time.
+func (l *leaderLeases) grant(sent time.Time, resp *protocol.LeaderLeaseResponse) {
+	epochs := make(map[topicPartition]int32, len(resp.Partitions))
+	for _, p := range resp.Partitions {
+		epochs[topicPartition{topic: p.Topic, partition: p.Partition}] = p.LeaderEpoch
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expires = sent.Add(resp.LeaseDuration)
+	l.epochs = epochs
+}
+
+// held returns whether the lease covers the partition in the leader epoch now.
+func (l *leaderLeases) held(topic string, partition, leaderEpoch int32, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !now.Before(l.expires) {
+		return false
+	}
+	epoch, ok := l.epochs[topicPartition{topic: topic, partition: partition}]
+	return ok && epoch == leaderEpoch
+}
+
+// renewSoon has the lease renewed without waiting for the next renewal.
+func (l *leaderLeases) renewSoon() {
+	select {
+	case l.renew <- struct{}{}:
+	default:
+	}
+}
+
+// checkLeaderLease returns ErrNotLeaderForPartition if leases are on and this broker's lease
+// doesn't cover the partition, which it leads, in its leader epoch.
+func (b *Broker) checkLeaderLease(replica *Replica) protocol.Error {
+	if b.config.LeaderLeaseDuration <= 0 {
+		return protocol.ErrNone
+	}
+	if !b.leaderLeases.held(replica.Partition.Topic, replica.Partition.ID, replica.Partition.LeaderEpoch, time.Now()) {
+		return protocol.ErrNotLeaderForPartition
+	}
+	return protocol.ErrNone
+}
+
+// renewLeaderLeases renews this broker's lease with the controller three times a lease duration,
+// so a renewal failing or two doesn't let it run out, until the broker shuts down.
+func (b *Broker) renewLeaderLeases(duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+	ticker := time.NewTicker(duration / 3)
+	defer ticker.Stop()
+	for {
+		sent := time.Now()
+		resp, err := b.requestLeaderLease()
+		if err != nil {
+			b.logger.Debug("failed to renew leader lease", log.Error("error", err))
+		} else {
+			b.leaderLeases.grant(sent, resp)
+		}
+		select {
+		case <-b.shutdownCh:
+			return
+		case <-ticker.C:
+		case <-b.leaderLeases.renew:
+		}
+	}
+}
+
+// requestLeaderLease sends the LeaderLease request to the controller, or grants the lease itself
+// if this broker's the controller.
+func (b *Broker) requestLeaderLease() (*protocol.LeaderLeaseResponse, error) {
+	if b.isController() {
+		resp, err := b.leaderLease(b.config.ID)
+		if err != protocol.ErrNone {
+			return nil, err
+		}
+		return resp, nil
+	}
+	id := b.controllerID()
+	if id == -1 {
+		return nil, protocol.ErrNotController
+	}
+	broker := b.brokerLookup.BrokerByID(raft.ServerID(id))
+	if broker == nil {
+		return nil, protocol.ErrBrokerNotAvailable
+	}
+	conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	resp, err := conn.LeaderLease(&protocol.LeaderLeaseRequest{BrokerID: b.config.ID})
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != protocol.ErrNone.Code() {
+		return nil, protocol.Errs[resp.ErrorCode]
+	}
+	return resp, nil
+}
+
+func (b *Broker) handleLeaderLease(ctx *Context, req *protocol.LeaderLeaseRequest) *protocol.LeaderLeaseResponse {
+	sp := span(ctx, b.tracer, "leader lease")
+	defer sp.Finish()
+	if !b.isController() {
+		return &protocol.LeaderLeaseResponse{APIVersion: req.Version(), ErrorCode: protocol.ErrNotController.Code()}
+	}
+	resp, err := b.leaderLease(req.BrokerID)
+	if err != protocol.ErrNone {
+		sp.LogKV("broker", req.BrokerID, "err", err)
+		return &protocol.LeaderLeaseResponse{APIVersion: req.Version(), ErrorCode: err.Code()}
+	}
+	resp.APIVersion = req.Version()
+	return resp
+}
+
+// leaderLease grants the broker a lease on the partitions it leads as the controller has them,
+// in their current leader epochs. The controller records a partition's new leader before telling
+// the brokers, so once it's moved the old leader's renewals leave it out.
+func (b *Broker) leaderLease(broker int32) (*protocol.LeaderLeaseResponse, protocol.Error) {
+	_, partitions, err := b.fsm.State().GetPartitions()
+	if err != nil {
+		return nil, protocol.ErrUnknown.WithErr(err)
+	}
+	resp := &protocol.LeaderLeaseResponse{LeaseDuration: b.config.LeaderLeaseDuration}
+	for _, p := range partitions {
+		if p.Leader != broker {
+			continue
+		}
+		resp.Partitions = append(resp.Partitions, protocol.LeasedPartition{
+			Topic:       p.Topic,
+			Partition:   p.ID,
+			LeaderEpoch: p.LeaderEpoch,
+		})
+	}
+	return resp, protocol.ErrNone
+}
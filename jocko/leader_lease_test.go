@@ -0,0 +1,80 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/testutil/retry"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestLeaderLeases(t *testing.T) {
+	l := newLeaderLeases()
+	now := time.Now()
+	require.False(t, l.held("the-topic", 0, 0, now))
+
+	l.grant(now, &protocol.LeaderLeaseResponse{
+		LeaseDuration: time.Second,
+		Partitions:    []protocol.LeasedPartition{{Topic: "the-topic", Partition: 0, LeaderEpoch: 2}},
+	})
+	require.True(t, l.held("the-topic", 0, 2, now.Add(500*time.Millisecond)))
+	// the lease doesn't cover other partitions or leader epochs
+	require.False(t, l.held("the-topic", 1, 2, now))
+	require.False(t, l.held("the-topic", 0, 3, now))
+	// the lease runs out its duration after the renewal was sent
+	require.False(t, l.held("the-topic", 0, 2, now.Add(time.Second)))
+
+	// a renewal without the partition ends the lease on it
+	l.grant(now, &protocol.LeaderLeaseResponse{LeaseDuration: time.Second})
+	require.False(t, l.held("the-topic", 0, 2, now))
+}
+
+func TestBroker_LeaderLease(t *testing.T) {
+	s, teardown := startTestLeader(t, func(cfg *config.Config) {
+		cfg.LeaderLeaseDuration = time.Minute
+	})
+	defer teardown()
+	b := s.broker()
+	reqCtx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+
+	// becoming the partition's leader renews the lease to cover it
+	produce := func() int16 {
+		resp := b.handleProduce(reqCtx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{RecordSet: testRecordBatch(-1, -1, -1, 0)}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	retry.Run(t, func(r *retry.R) {
+		if code := produce(); code != protocol.ErrNone.Code() {
+			r.Fatalf("got error code %d", code)
+		}
+	})
+
+	// the controller grants the lease on the partitions the broker leads
+	resp := b.handleLeaderLease(reqCtx, &protocol.LeaderLeaseRequest{BrokerID: b.config.ID})
+	require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	require.Equal(t, time.Minute, resp.LeaseDuration)
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, []protocol.LeasedPartition{{Topic: "the-topic", Partition: 0, LeaderEpoch: replica.Partition.LeaderEpoch}}, resp.Partitions)
+	require.Empty(t, b.handleLeaderLease(reqCtx, &protocol.LeaderLeaseRequest{BrokerID: b.config.ID + 1}).Partitions)
+
+	// once the lease runs out the leader stops serving the partition
+	b.leaderLeases.grant(time.Now().Add(-time.Minute), resp)
+	require.Equal(t, protocol.ErrNotLeaderForPartition.Code(), produce())
+	fetch := b.handleFetch(reqCtx, &protocol.FetchRequest{ReplicaID: -1, MinBytes: 1, MaxWaitTime: 100, Topics: []*protocol.FetchTopic{{
+		Topic:      "the-topic",
+		Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: 0, MaxBytes: 1000}},
+	}}})
+	require.Equal(t, protocol.ErrNotLeaderForPartition.Code(), fetch.Responses[0].PartitionResponses[0].ErrorCode)
+}
@@ -0,0 +1,311 @@
+package jocko
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// logDirs returns the directories the broker keeps its partitions' logs in.
+func (b *Broker) logDirs() []string {
+	return configuredLogDirs(b.config)
+}
+
+// configuredLogDirs returns the log dirs of a broker with the config.
+func configuredLogDirs(cfg *config.Config) []string {
+	if len(cfg.LogDirs) != 0 {
+		return cfg.LogDirs
+	}
+	return []string{filepath.Join(cfg.DataDir, "data")}
+}
+
+// partitionDirName returns the name of the partition's directory within its log dir.
+func partitionDirName(topic string, partition int32) string {
+	return fmt.Sprintf("%s-%d", topic, partition)
+}
+
+// partitionLogDir returns the log dir holding the partition directory with the given name. New
+// partitions go in the log dir with the fewest partitions. If a move stopped before it removed
+// the directory it moved from, the directory's in two log dirs and the one it was moved to is
+// returned.
+func (b *Broker) partitionLogDir(name string) string {
+	dirs := b.logDirs()
+	dir, fewest, found := dirs[0], -1, ""
+	for _, d := range dirs {
+		if _, err := os.Stat(filepath.Join(d, name)); err == nil {
+			if from, _ := commitlog.MovedFrom(filepath.Join(d, name)); from != "" {
+				return d
+			}
+			if found == "" {
+				found = d
+			}
+			continue
+		}
+		files, _ := ioutil.ReadDir(d)
+		if fewest == -1 || len(files) < fewest {
+			dir, fewest = d, len(files)
+		}
+	}
+	if found != "" {
+		return found
+	}
+	return dir
+}
+
+// removeFutureLogs removes the copies left in the log dirs by moves that stopped before they
+// switched over to them.
+func (b *Broker) removeFutureLogs() {
+	for _, dir := range b.logDirs() {
+		if err := commitlog.RemoveFutures(dir); err != nil {
+			b.logger.Error("failed to remove future logs", log.String("log dir", dir), log.Error("error", err))
+		}
+	}
+}
+
+// deletedLogSuffix is appended to the directories of deleted replicas' logs, they're renamed
+// right away so the partition can be recreated and removed in the background.
+const deletedLogSuffix = ".deleted"
+
+// deleteReplicaLog renames the replica's log directory out of the way and removes it in the
+// background. Logs that can't be closed, or aren't in a log dir, are deleted in the background.
+func (b *Broker) deleteReplicaLog(replica *Replica) {
+	path := filepath.Join(replica.LogDir, partitionDirName(replica.Partition.Topic, replica.Partition.ID))
+	l, ok := replica.Log.(io.Closer)
+	if !ok || replica.LogDir == "" {
+		go func() {
+			if err := replica.Log.Delete(); err != nil {
+				b.logger.Error("failed to delete replica log", log.Any("replica", replica), log.Error("error", err))
+			}
+		}()
+		return
+	}
+	if err := l.Close(); err != nil {
+		b.logger.Error("failed to close replica log", log.Any("replica", replica), log.Error("error", err))
+	}
+	deleted := path + deletedLogSuffix
+	// the topic's been recreated and deleted again before the last removal finished
+	if err := os.RemoveAll(deleted); err != nil {
+		b.logger.Error("failed to delete replica log", log.String("path", deleted), log.Error("error", err))
+	}
+	if err := os.Rename(path, deleted); err != nil {
+		b.logger.Error("failed to rename deleted replica log", log.String("path", path), log.Error("error", err))
+		return
+	}
+	go b.removeDeletedLog(deleted)
+}
+
+// removeDeletedLog removes a deleted replica's log directory.
+func (b *Broker) removeDeletedLog(path string) {
+	if err := os.RemoveAll(path); err != nil {
+		b.logger.Error("failed to delete replica log", log.String("path", path), log.Error("error", err))
+	}
+}
+
+// removeDeletedLogs removes the deleted replicas' logs left in the log dirs by a broker that
+// stopped before it finished removing them.
+func (b *Broker) removeDeletedLogs() {
+	for _, dir := range b.logDirs() {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*"+deletedLogSuffix))
+		for _, path := range paths {
+			b.removeDeletedLog(path)
+		}
+	}
+}
+
+// migrateLegacyLog moves the partition's log from where it used to be kept, a directory named
+// after the partition's id in the data dir, to path. Since the old layout didn't include the
+// topic the log's only moved if no other topic has that partition on this broker.
+func (b *Broker) migrateLegacyLog(topic string, partition int32, path string) error {
+	legacy := filepath.Join(b.config.DataDir, "data", strconv.Itoa(int(partition)))
+	if _, err := os.Stat(legacy); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	_, topics, err := b.fsm.State().GetTopics()
+	if err != nil {
+		return err
+	}
+	for _, t := range topics {
+		if t.Topic != topic && containsInt32(t.Partitions[partition], b.config.ID) {
+			return fmt.Errorf("legacy log %s is shared by topics %s and %s", legacy, topic, t.Topic)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(legacy, path); err != nil {
+		return err
+	}
+	b.logger.Info("migrated legacy replica log", log.String("from", legacy), log.String("to", path))
+	return nil
+}
+
+// isLogDir returns whether path is one of the broker's log dirs.
+func (b *Broker) isLogDir(path string) bool {
+	for _, dir := range b.logDirs() {
+		if filepath.Clean(dir) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// movableLog is implemented by commit logs that can be moved to another directory while open.
+type movableLog interface {
+	Move(path string, bytesPerSec int64) error
+}
+
+// alterReplicaLogDir starts moving the local replica of the partition to the log dir at path.
+// The move happens in the background, it's done once the replica's log dir has changed.
+func (b *Broker) alterReplicaLogDir(path, topic string, partition int32) protocol.Error {
+	_, err := b.moveReplicaLog(path, topic, partition, 0)
+	return err
+}
+
+// moveReplicaLog starts moving the local replica of the partition to the log dir at path, copying
+// it at up to bytesPerSec unless that's zero, and returns a channel that's closed once the move's
+// finished. The channel's nil if there's nothing to move.
+func (b *Broker) moveReplicaLog(path, topic string, partition int32, bytesPerSec int64) (<-chan struct{}, protocol.Error) {
+	if !b.isLogDir(path) {
+		return nil, protocol.ErrLogDirNotFound
+	}
+	replica, err := b.replicaLookup.Replica(topic, partition)
+	if err != nil || replica.Log == nil {
+		return nil, protocol.ErrReplicaNotAvailable
+	}
+	l, ok := replica.Log.(movableLog)
+	if !ok {
+		return nil, protocol.ErrKafkaStorageError
+	}
+	replica.Lock()
+	defer replica.Unlock()
+	if replica.LogDir == path || replica.FutureLogDir == path {
+		return nil, protocol.ErrNone
+	}
+	if replica.FutureLogDir != "" {
+		// TODO: cancel the move in progress and start over to the new dir
+		return nil, protocol.ErrInvalidRequest
+	}
+	replica.FutureLogDir = path
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := l.Move(filepath.Join(path, partitionDirName(topic, partition)), bytesPerSec)
+		replica.Lock()
+		if err == nil {
+			replica.LogDir = path
+		}
+		replica.FutureLogDir = ""
+		replica.Unlock()
+		if err != nil {
+			b.logger.Error("failed to move replica log", log.Error("error", err), log.String("log dir", path), log.Any("replica", replica))
+			return
+		}
+		b.logger.Info("moved replica log", log.String("log dir", path), log.Any("replica", replica))
+	}()
+	return done, protocol.ErrNone
+}
+
+// sizedLog is implemented by commit logs that can report their size on disk.
+type sizedLog interface {
+	Size() int64
+}
+
+// describeLogDirs returns the size and lag of the local replicas' logs in each log dir, limited
+// to the given topics and partitions unless they're nil. Logs being moved are also listed in
+// the log dir they're moving to as future logs.
+func (b *Broker) describeLogDirs(topics []protocol.DescribeLogDirsTopic) []protocol.DescribeLogDirsResult {
+	var wanted map[string]map[int32]bool
+	if topics != nil {
+		wanted = make(map[string]map[int32]bool)
+		for _, t := range topics {
+			ps := make(map[int32]bool)
+			for _, p := range t.Partitions {
+				ps[p] = true
+			}
+			wanted[t.Topic] = ps
+		}
+	}
+	// log dir to topic to partitions
+	byDir := make(map[string]map[string][]protocol.DescribeLogDirsPartition)
+	add := func(dir, topic string, p protocol.DescribeLogDirsPartition) {
+		if byDir[dir] == nil {
+			byDir[dir] = make(map[string][]protocol.DescribeLogDirsPartition)
+		}
+		byDir[dir][topic] = append(byDir[dir][topic], p)
+	}
+	for _, replica := range b.replicaLookup.Replicas() {
+		topic, id := replica.Partition.Topic, replica.Partition.ID
+		if wanted != nil && !wanted[topic][id] {
+			continue
+		}
+		if replica.Log == nil {
+			continue
+		}
+		replica.Lock()
+		dir, future := replica.LogDir, replica.FutureLogDir
+		replica.Unlock()
+		p := protocol.DescribeLogDirsPartition{Partition: id}
+		if l, ok := replica.Log.(sizedLog); ok {
+			p.Size = l.Size()
+		}
+		// followers lag behind the high watermark the leader last told them about, leaders
+		// are the high watermark
+		if replica.Replicator != nil {
+			if lag := replica.Replicator.HighWatermark() - (replica.Log.NewestOffset() - 1); lag > 0 {
+				p.OffsetLag = lag
+			}
+		}
+		add(filepath.Clean(dir), topic, p)
+		if future != "" {
+			// the future log's offsets aren't known until it's switched to so only its size
+			// is reported
+			path := commitlog.FuturePath(filepath.Join(future, partitionDirName(topic, id)))
+			add(filepath.Clean(future), topic, protocol.DescribeLogDirsPartition{
+				Partition: id,
+				Size:      dirSize(path),
+				IsFuture:  true,
+			})
+		}
+	}
+	dirs := b.logDirs()
+	results := make([]protocol.DescribeLogDirsResult, len(dirs))
+	for i, dir := range dirs {
+		byTopic := byDir[filepath.Clean(dir)]
+		topics := make([]protocol.DescribeLogDirsTopicResult, 0, len(byTopic))
+		for topic, ps := range byTopic {
+			sort.Slice(ps, func(i, j int) bool { return ps[i].Partition < ps[j].Partition })
+			topics = append(topics, protocol.DescribeLogDirsTopicResult{Topic: topic, Partitions: ps})
+		}
+		sort.Slice(topics, func(i, j int) bool { return topics[i].Topic < topics[j].Topic })
+		results[i] = protocol.DescribeLogDirsResult{
+			ErrorCode: protocol.ErrNone.Code(),
+			LogDir:    dir,
+			Topics:    topics,
+		}
+	}
+	return results
+}
+
+// dirSize returns the number of bytes in the files in dir.
+func dirSize(dir string) int64 {
+	files, _ := ioutil.ReadDir(dir)
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	return size
+}
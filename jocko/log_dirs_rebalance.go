@@ -0,0 +1,234 @@
+package jocko
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// Dynamic broker configs, set with AlterConfigs, to rebalance the broker's partitions across its
+// log dirs. Setting logDirsRebalanceConfig to a value it hasn't had before, like the current time,
+// starts a rebalance. logDirsRebalanceThrottleConfig limits the rate partitions are moved at in
+// bytes per second, it's read before each move so it can be changed while rebalancing.
+const (
+	logDirsRebalanceConfig         = "log.dirs.rebalance"
+	logDirsRebalanceThrottleConfig = "log.dirs.rebalance.throttle.bytes"
+)
+
+// logDirsRebalance tracks the broker's log dirs rebalance so only one runs at a time.
+type logDirsRebalance struct {
+	sync.Mutex
+	// request is the value of logDirsRebalanceConfig the last rebalance was started for, or the
+	// last one completed before the broker started.
+	request string
+	running bool
+}
+
+// logDirPartition is a local replica's log in a log dir.
+type logDirPartition struct {
+	topic     string
+	partition int32
+	size      int64
+}
+
+// logDirUsage is a log dir's free space and the logs in it.
+type logDirUsage struct {
+	dir        string
+	free       int64
+	partitions []logDirPartition
+}
+
+// logDirMove is a move of a partition's log between log dirs.
+type logDirMove struct {
+	logDirPartition
+	from, to string
+}
+
+// planLogDirMoves returns the moves that even out the log dirs' free space. It moves the largest
+// log from the log dir with the least free space to the one with the most that narrows the gap
+// between them, until no log does. Each log's moved at most once.
+func planLogDirMoves(usage []logDirUsage) []logDirMove {
+	if len(usage) < 2 {
+		return nil
+	}
+	free := make(map[string]int64, len(usage))
+	partitions := make(map[string][]logDirPartition, len(usage))
+	for _, u := range usage {
+		free[u.dir] = u.free
+		ps := append([]logDirPartition(nil), u.partitions...)
+		sort.SliceStable(ps, func(i, j int) bool { return ps[i].size > ps[j].size })
+		partitions[u.dir] = ps
+	}
+	var moves []logDirMove
+	for {
+		from, to := usage[0].dir, usage[0].dir
+		for _, u := range usage {
+			if free[u.dir] < free[from] {
+				from = u.dir
+			}
+			if free[u.dir] > free[to] {
+				to = u.dir
+			}
+		}
+		gap := free[to] - free[from]
+		// moving a log narrows the gap if it's smaller than it, the logs are sorted largest first
+		i := sort.Search(len(partitions[from]), func(i int) bool { return partitions[from][i].size < gap })
+		if i == len(partitions[from]) || partitions[from][i].size == 0 {
+			return moves
+		}
+		p := partitions[from][i]
+		partitions[from] = append(partitions[from][:i:i], partitions[from][i+1:]...)
+		free[from] += p.size
+		free[to] -= p.size
+		moves = append(moves, logDirMove{logDirPartition: p, from: from, to: to})
+	}
+}
+
+// observeLogDirsRebalance starts rebalancing the broker's log dirs when it's asked to by a new
+// value of its logDirsRebalanceConfig, one it hasn't completed a rebalance for.
+func (b *Broker) observeLogDirsRebalance(config *structs.Config) {
+	if request, ok := config.Entries[logDirsRebalanceConfig]; ok {
+		b.rebalanceLogDirs(request)
+	}
+}
+
+// rebalanceLogDirs starts moving logs between the broker's log dirs to even out their free space
+// unless it's already rebalanced them for this request or is rebalancing them now. The logs are
+// moved one at a time in the background and show up as future logs in DescribeLogDirs while
+// they're being moved.
+func (b *Broker) rebalanceLogDirs(request string) {
+	b.logDirsRebalance.Lock()
+	defer b.logDirsRebalance.Unlock()
+	if request == b.logDirsRebalance.request {
+		return
+	}
+	if b.logDirsRebalance.running {
+		b.logger.Info("log dirs already being rebalanced", log.String("request", request))
+		return
+	}
+	b.logDirsRebalance.request = request
+	b.logDirsRebalance.running = true
+	go func() {
+		defer func() {
+			b.logDirsRebalance.Lock()
+			b.logDirsRebalance.running = false
+			b.logDirsRebalance.Unlock()
+		}()
+		usage, err := b.logDirUsage()
+		if err != nil {
+			b.logger.Error("failed to get log dirs usage", log.Error("error", err))
+			return
+		}
+		moves := planLogDirMoves(usage)
+		b.logger.Info("rebalancing log dirs", log.String("request", request), log.Int("moves", len(moves)))
+		for i, m := range moves {
+			if !b.moveLogForRebalance(m) {
+				return
+			}
+			b.logger.Info("rebalanced log", log.String("topic", m.topic), log.Int32("partition", m.partition), log.String("from", m.from), log.String("to", m.to), log.Int("moved", i+1), log.Int("moves", len(moves)))
+		}
+		if err := b.writeLogDirsRebalanced(request); err != nil {
+			b.logger.Error("failed to persist log dirs rebalance", log.Error("error", err), log.String("request", request))
+		}
+	}()
+}
+
+// logDirsRebalancedFile is the file in the data dir the request of the last completed log dirs
+// rebalance is persisted to. The configs are replayed from the raft log when the broker starts so
+// without it every restart would rebalance again.
+const logDirsRebalancedFile = "log-dirs-rebalanced"
+
+// loadLogDirsRebalanced reads the request of the last completed log dirs rebalance so it isn't
+// run again.
+func (b *Broker) loadLogDirsRebalanced() error {
+	v, err := ioutil.ReadFile(filepath.Join(b.config.DataDir, logDirsRebalancedFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	b.logDirsRebalance.Lock()
+	b.logDirsRebalance.request = string(v)
+	b.logDirsRebalance.Unlock()
+	return nil
+}
+
+// writeLogDirsRebalanced persists the request of a completed log dirs rebalance, writing it to a
+// temporary file that's renamed so it's never partly written.
+func (b *Broker) writeLogDirsRebalanced(request string) error {
+	path := filepath.Join(b.config.DataDir, logDirsRebalancedFile)
+	if err := ioutil.WriteFile(path+".tmp", []byte(request), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// moveLogForRebalance moves the log, copying it at up to the throttle, and returns false if the
+// broker's shutting down.
+func (b *Broker) moveLogForRebalance(m logDirMove) bool {
+	v, _ := b.dynamicConfig(logDirsRebalanceThrottleConfig)
+	throttle, ok := configInt(v)
+	if !ok || throttle < 0 {
+		throttle = 0
+	}
+	done, err := b.moveReplicaLog(m.to, m.topic, m.partition, throttle)
+	if err != protocol.ErrNone {
+		// the replica may have been moved or removed since the moves were planned
+		b.logger.Info("skipping log dirs rebalance move", log.Error("error", err), log.String("topic", m.topic), log.Int32("partition", m.partition))
+		return true
+	}
+	if done != nil {
+		select {
+		case <-done:
+		case <-b.shutdownCh:
+			return false
+		}
+	}
+	return true
+}
+
+// logDirFree returns the bytes available on the disk holding the log dir, tests fake it to have
+// the log dirs on different disks.
+var logDirFree = diskFree
+
+// logDirUsage returns the free space of the broker's log dirs and the logs in them that aren't
+// being moved.
+func (b *Broker) logDirUsage() ([]logDirUsage, error) {
+	dirs := b.logDirs()
+	usage := make([]logDirUsage, len(dirs))
+	index := make(map[string]int, len(dirs))
+	for i, dir := range dirs {
+		free, err := logDirFree(dir)
+		if err != nil {
+			return nil, err
+		}
+		usage[i] = logDirUsage{dir: dir, free: free}
+		index[filepath.Clean(dir)] = i
+	}
+	for _, replica := range b.replicaLookup.Replicas() {
+		l, ok := replica.Log.(sizedLog)
+		if !ok {
+			continue
+		}
+		replica.Lock()
+		dir, future := replica.LogDir, replica.FutureLogDir
+		replica.Unlock()
+		i, ok := index[filepath.Clean(dir)]
+		if !ok || future != "" {
+			continue
+		}
+		usage[i].partitions = append(usage[i].partitions, logDirPartition{
+			topic:     replica.Partition.Topic,
+			partition: replica.Partition.ID,
+			size:      l.Size(),
+		})
+	}
+	return usage, nil
+}
@@ -0,0 +1,55 @@
+package jocko
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanLogDirMoves(t *testing.T) {
+	p := func(topic string, size int64) logDirPartition {
+		return logDirPartition{topic: topic, size: size}
+	}
+	tests := []struct {
+		name  string
+		usage []logDirUsage
+		moves []logDirMove
+	}{
+		{
+			name:  "one log dir",
+			usage: []logDirUsage{{dir: "a", free: 0, partitions: []logDirPartition{p("t", 10)}}},
+		},
+		{
+			name: "balanced",
+			usage: []logDirUsage{
+				{dir: "a", free: 100, partitions: []logDirPartition{p("t", 10)}},
+				{dir: "b", free: 105},
+			},
+		},
+		{
+			name: "largest logs that narrow the gap",
+			usage: []logDirUsage{
+				{dir: "a", free: 0, partitions: []logDirPartition{p("small", 10), p("huge", 200), p("large", 60), p("medium", 30)}},
+				{dir: "b", free: 100},
+			},
+			moves: []logDirMove{{logDirPartition: p("large", 60), from: "a", to: "b"}},
+		},
+		{
+			name: "three log dirs",
+			usage: []logDirUsage{
+				{dir: "a", free: 10, partitions: []logDirPartition{p("x", 40), p("y", 10)}},
+				{dir: "b", free: 90},
+				{dir: "c", free: 70, partitions: []logDirPartition{p("z", 20)}},
+			},
+			moves: []logDirMove{
+				{logDirPartition: p("x", 40), from: "a", to: "b"},
+				{logDirPartition: p("y", 10), from: "a", to: "c"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.moves, planLogDirMoves(test.usage))
+		})
+	}
+}
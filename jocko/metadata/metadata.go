@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/hashicorp/serf/serf"
 )
@@ -28,6 +29,15 @@ type Broker struct {
 	RaftAddr    string
 	SerfLANAddr string
 	BrokerAddr  string
+	// Rack is the rack the broker's in, empty if it isn't in one.
+	Rack string
+	// StartedAt is when the broker started, Version the version of jocko it's running,
+	// PreviousShutdown how its previous run ended and RecoveryDuration how long it took to
+	// recover its state on starting. They're zero for brokers that don't advertise them.
+	StartedAt        time.Time
+	Version          string
+	PreviousShutdown string
+	RecoveryDuration time.Duration
 }
 
 func (b Broker) Host() string {
@@ -75,6 +85,10 @@ func IsBroker(m serf.Member) (*Broker, bool) {
 		return nil, false
 	}
 
+	// the lifecycle tags are only informational so brokers with bad ones are still brokers
+	startedAt, _ := time.Parse(time.RFC3339Nano, m.Tags["started_at"])
+	recovery, _ := time.ParseDuration(m.Tags["recovery"])
+
 	return &Broker{
 		ID:          NodeID(id),
 		Name:        m.Tags["name"],
@@ -85,5 +99,11 @@ func IsBroker(m serf.Member) (*Broker, bool) {
 		RaftAddr:    m.Tags["raft_addr"],
 		SerfLANAddr: m.Tags["serf_lan_addr"],
 		BrokerAddr:  m.Tags["broker_addr"],
+		Rack:        m.Tags["rack"],
+
+		StartedAt:        startedAt,
+		Version:          m.Tags["version"],
+		PreviousShutdown: m.Tags["previous_shutdown"],
+		RecoveryDuration: recovery,
 	}, true
 }
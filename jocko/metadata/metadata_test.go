@@ -2,6 +2,7 @@ package metadata
 
 import (
 	"testing"
+	"time"
 
 	"github.com/hashicorp/serf/serf"
 )
@@ -15,6 +16,10 @@ func TestIsBroker(t *testing.T) {
 			name:     "minumum config",
 			function: testMinimum,
 		},
+		{
+			name:     "lifecycle",
+			function: testLifecycle,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, test.function)
@@ -30,3 +35,21 @@ func testMinimum(t *testing.T) {
 		t.Fatal("broker id is not 1")
 	}
 }
+
+func testLifecycle(t *testing.T) {
+	started := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	b, ok := IsBroker(serf.Member{Tags: map[string]string{
+		"id":                "1",
+		"role":              "jocko",
+		"started_at":        started.Format(time.RFC3339Nano),
+		"version":           "1.2.3",
+		"previous_shutdown": "unclean",
+		"recovery":          "1.5s",
+	}})
+	if !ok {
+		t.Fatal("is broker not ok")
+	}
+	if !b.StartedAt.Equal(started) || b.Version != "1.2.3" || b.PreviousShutdown != "unclean" || b.RecoveryDuration != 1500*time.Millisecond {
+		t.Fatalf("bad lifecycle: %v", b)
+	}
+}
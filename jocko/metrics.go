@@ -1,11 +1,343 @@
 package jocko
 
-import "github.com/go-kit/kit/metrics/prometheus"
+import (
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/protocol"
+)
 
 // Alias prometheus' counter, probably only need to use Inc() though.
 type Counter = prometheus.Counter
 
+// Alias prometheus' histogram, used to track latencies.
+type Histogram = prometheus.Histogram
+
+// Alias prometheus' gauge, used to track values that go up and down.
+type Gauge = prometheus.Gauge
+
 // Metrics is used for tracking metrics.
 type Metrics struct {
-	RequestsHandled Counter
+	// Request metrics are labeled with the API and version, like Kafka's RequestMetrics, and
+	// the error counts with the error too.
+	RequestsHandled *Counter
+	RequestLatency  *Histogram
+	ResponseErrors  *Counter
+
+	ActiveConnections *Gauge
+
+	// Log dir metrics are labeled with the log dir so slow or failing disks stand out.
+	LogFlushLatency  *Histogram
+	LogRecoveryTime  *Histogram
+	LogIndexRebuilds *Counter
+
+	// Consistency metrics are the results of the last check of the log dirs.
+	OrphanedLogs    *Gauge
+	MissingReplicas *Gauge
+
+	// Producer metrics are labeled with the topic and partition.
+	ActiveProducers          *Gauge
+	ProducerStateExpirations *Counter
+	// The vectors behind the producer metrics, kept to delete a partition's series once its
+	// replica's gone, which go-kit's wrappers can't.
+	activeProducers          *stdprometheus.GaugeVec
+	producerStateExpirations *stdprometheus.CounterVec
+
+	// ReconcileFailures counts the controller's failed reconciles of the cluster's membership.
+	ReconcileFailures *Counter
+
+	// Shadow metrics are labeled with the topic, the record sets with whether they were
+	// forwarded, dropped or failed, and the mismatches with the partition too.
+	ShadowRecordSets *Counter
+	ShadowMismatches *Counter
+
+	// Group metrics are labeled with the group, the rebalances with why they started too.
+	GroupRebalances    *Counter
+	GroupRebalanceTime *Histogram
+	// DeadGroupsRemoved counts the groups deleted after going empty for their retention.
+	DeadGroupsRemoved *Counter
+
+	// Produce metrics break the latency of produces down, labeled with the topic and partition,
+	// so slow acks=all produces can be put down to the broker, its disk or its followers.
+	ProduceQueueTime       *Histogram
+	ProduceAppendTime      *Histogram
+	ProduceFlushTime       *Histogram
+	ProduceReplicationTime *Histogram
+
+	// OrderingViolations counts the appends and high watermarks the ordering audit flagged,
+	// labeled with the topic, partition and the check that failed.
+	OrderingViolations *Counter
+
+	// Raft metrics time committing entries to the raft log, applying them to the FSM and
+	// snapshotting it, labeled with the phase, and track the broker's contact with the leader
+	// and how often it changes, so an unstable controller shows up.
+	RaftCommitLatency *Histogram
+	RaftFSMApplyTime  *Histogram
+	RaftSnapshotTime  *Histogram
+	RaftLastContact   *Gauge
+	RaftLeaderChanges *Counter
+}
+
+// NewMetrics creates the metrics and registers them with Prometheus' default registry.
+func NewMetrics() *Metrics {
+	activeProducers := stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{
+		Namespace: "jocko",
+		Subsystem: "producer",
+		Name:      "active",
+		Help:      "Number of idempotent producers with state in a partition.",
+	}, []string{"topic", "partition"})
+	producerStateExpirations := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+		Namespace: "jocko",
+		Subsystem: "producer",
+		Name:      "state_expirations_total",
+		Help:      "Number of producers' states expired by operators.",
+	}, []string{"topic", "partition"})
+	stdprometheus.MustRegister(activeProducers, producerStateExpirations)
+	return &Metrics{
+		RequestsHandled: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Name:      "requests_handled_total",
+			Help:      "Number of requests handled.",
+		}, []string{"api", "version"}),
+		RequestLatency: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Name:      "request_latency_seconds",
+			Help:      "Time taken from reading a request to writing its response.",
+		}, []string{"api", "version"}),
+		ResponseErrors: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Name:      "response_errors_total",
+			Help:      "Number of error codes in responses, including none, counted per topic, partition, group, etc.",
+		}, []string{"api", "version", "error"}),
+		ActiveConnections: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "jocko",
+			Name:      "active_connections",
+			Help:      "Number of open client connections.",
+		}, nil),
+		LogFlushLatency: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "log",
+			Name:      "flush_latency_seconds",
+			Help:      "Time taken to fsync a partition's segment.",
+		}, []string{"log_dir"}),
+		LogRecoveryTime: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "log",
+			Name:      "recovery_time_seconds",
+			Help:      "Time taken to load a partition's log at startup.",
+		}, []string{"log_dir"}),
+		LogIndexRebuilds: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Subsystem: "log",
+			Name:      "index_rebuilds_total",
+			Help:      "Number of segment indexes rebuilt from their logs.",
+		}, []string{"log_dir"}),
+		OrphanedLogs: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "jocko",
+			Subsystem: "log",
+			Name:      "orphaned",
+			Help:      "Number of logs in the log dirs of partitions the broker isn't assigned a replica of.",
+		}, nil),
+		MissingReplicas: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "jocko",
+			Subsystem: "log",
+			Name:      "missing_replicas",
+			Help:      "Number of replicas the broker's assigned that have no log in its log dirs.",
+		}, nil),
+		ActiveProducers:          prometheus.NewGauge(activeProducers),
+		ProducerStateExpirations: prometheus.NewCounter(producerStateExpirations),
+		activeProducers:          activeProducers,
+		producerStateExpirations: producerStateExpirations,
+		ReconcileFailures: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Subsystem: "controller",
+			Name:      "reconcile_failures_total",
+			Help:      "Number of times the controller failed to reconcile the cluster's membership.",
+		}, nil),
+		ShadowRecordSets: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Subsystem: "shadow",
+			Name:      "record_sets_total",
+			Help:      "Number of produced record sets forwarded to, dropped before, or failed to be written to the shadowed cluster.",
+		}, []string{"topic", "result"}),
+		ShadowMismatches: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Subsystem: "shadow",
+			Name:      "mismatches_total",
+			Help:      "Number of record sets the shadowed cluster stored with different checksums than jocko.",
+		}, []string{"topic", "partition"}),
+		GroupRebalances: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Subsystem: "group",
+			Name:      "rebalances_total",
+			Help:      "Number of rebalances of the groups the broker coordinates.",
+		}, []string{"group", "reason"}),
+		GroupRebalanceTime: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "group",
+			Name:      "rebalance_time_seconds",
+			Help:      "Time taken from a member joining or leaving a group to its leader syncing the new assignments.",
+		}, []string{"group"}),
+		DeadGroupsRemoved: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Subsystem: "group",
+			Name:      "dead_groups_removed_total",
+			Help:      "Number of groups the broker coordinated that it deleted after they went empty for their retention.",
+		}, nil),
+		ProduceQueueTime: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "produce",
+			Name:      "queue_time_seconds",
+			Help:      "Time produce requests waited from being read to being handled.",
+		}, []string{"topic", "partition"}),
+		ProduceAppendTime: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "produce",
+			Name:      "append_time_seconds",
+			Help:      "Time taken to write produced batches to the leader's log, not counting syncs.",
+		}, []string{"topic", "partition"}),
+		ProduceFlushTime: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "produce",
+			Name:      "flush_time_seconds",
+			Help:      "Time taken to fsync the leader's log after writing produced batches, when it was due.",
+		}, []string{"topic", "partition"}),
+		ProduceReplicationTime: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "produce",
+			Name:      "replication_wait_seconds",
+			Help:      "Time acks=all batches waited from being written to the leader's log to being replicated to the isr.",
+		}, []string{"topic", "partition"}),
+		OrderingViolations: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Name:      "ordering_violations_total",
+			Help:      "Number of non-monotonic offsets, producer sequences and high watermarks the ordering audit found.",
+		}, []string{"topic", "partition", "check"}),
+		RaftCommitLatency: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "raft",
+			Name:      "commit_latency_seconds",
+			Help:      "Time taken from the broker applying an entry to the raft log to it being committed and applied to the FSM.",
+		}, nil),
+		RaftFSMApplyTime: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "raft",
+			Name:      "fsm_apply_seconds",
+			Help:      "Time taken to apply a committed raft log entry to the FSM.",
+		}, nil),
+		RaftSnapshotTime: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "jocko",
+			Subsystem: "raft",
+			Name:      "snapshot_duration_seconds",
+			Help:      "Time taken to create, persist or restore a snapshot of the FSM.",
+		}, []string{"phase"}),
+		RaftLastContact: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "jocko",
+			Subsystem: "raft",
+			Name:      "last_contact_seconds",
+			Help:      "Time since the broker last heard from the raft leader, 0 on the leader.",
+		}, nil),
+		RaftLeaderChanges: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "jocko",
+			Subsystem: "raft",
+			Name:      "leader_changes_total",
+			Help:      "Number of times the broker's seen the raft leader change, including to none.",
+		}, nil),
+	}
+}
+
+// commitLogMetrics returns the commit log metrics for the given log dir, nil if metrics
+// aren't being tracked.
+func (m *Metrics) commitLogMetrics(dir string) *commitlog.Metrics {
+	if m == nil {
+		return nil
+	}
+	return &commitlog.Metrics{
+		FlushLatency:  m.LogFlushLatency.With("log_dir", dir),
+		RecoveryTime:  m.LogRecoveryTime.With("log_dir", dir),
+		IndexRebuilds: m.LogIndexRebuilds.With("log_dir", dir),
+	}
+}
+
+// removeProducerMetrics deletes the partition's producer series so a replica that's gone from
+// the broker doesn't leave them exported.
+func (m *Metrics) removeProducerMetrics(topic string, partition int32) {
+	if m == nil {
+		return
+	}
+	p := strconv.Itoa(int(partition))
+	if m.activeProducers != nil {
+		m.activeProducers.DeleteLabelValues(topic, p)
+	}
+	if m.producerStateExpirations != nil {
+		m.producerStateExpirations.DeleteLabelValues(topic, p)
+	}
+}
+
+// requestHandled records the request was handled, with its latency and the error codes in its
+// response, if metrics are being tracked.
+func (m *Metrics) requestHandled(header *protocol.RequestHeader, response interface{}, latency time.Duration) {
+	if m == nil || header == nil {
+		return
+	}
+	api, version := protocol.APIName(header.APIKey), strconv.Itoa(int(header.APIVersion))
+	m.RequestsHandled.With("api", api, "version", version).Add(1)
+	m.RequestLatency.With("api", api, "version", version).Observe(latency.Seconds())
+	for code, n := range errorCodes(response) {
+		m.ResponseErrors.With("api", api, "version", version, "error", errorName(code)).Add(float64(n))
+	}
+}
+
+// errorCodes counts the error codes in the response, which are the ErrorCode fields of its
+// structs however deeply they're nested.
+func errorCodes(response interface{}) map[int16]int {
+	codes := make(map[int16]int)
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Struct:
+			t := v.Type()
+			for i := 0; i < v.NumField(); i++ {
+				if t.Field(i).PkgPath != "" {
+					// unexported, like time.Time's
+					continue
+				}
+				f := v.Field(i)
+				if t.Field(i).Name == "ErrorCode" && f.Kind() == reflect.Int16 {
+					codes[int16(f.Int())]++
+					continue
+				}
+				walk(f)
+			}
+		case reflect.Slice, reflect.Array:
+			// skip bytes, like record sets, rather than walking every one
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				return
+			}
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		case reflect.Map:
+			for _, k := range v.MapKeys() {
+				walk(v.MapIndex(k))
+			}
+		}
+	}
+	walk(reflect.ValueOf(response))
+	return codes
+}
+
+func errorName(code int16) string {
+	if err, ok := protocol.Errs[code]; ok {
+		return err.String()
+	}
+	return strconv.Itoa(int(code))
 }
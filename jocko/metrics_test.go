@@ -0,0 +1,67 @@
+package jocko
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestErrorCodes(t *testing.T) {
+	resp := &protocol.ProduceResponse{Responses: []*protocol.ProduceTopicResponse{{
+		Topic: "the-topic",
+		PartitionResponses: []*protocol.ProducePartitionResponse{
+			{Partition: 0, ErrorCode: protocol.ErrNone.Code()},
+			{Partition: 1, ErrorCode: protocol.ErrNotLeaderForPartition.Code()},
+			{Partition: 2, ErrorCode: protocol.ErrNotLeaderForPartition.Code()},
+		},
+	}}}
+	require.Equal(t, map[int16]int{
+		protocol.ErrNone.Code():                  1,
+		protocol.ErrNotLeaderForPartition.Code(): 2,
+	}, errorCodes(resp))
+
+	group := &protocol.DescribeGroupsResponse{Groups: []protocol.Group{{
+		ErrorCode:    protocol.ErrNotCoordinator.Code(),
+		GroupMembers: map[string]*protocol.GroupMember{"the-member": {GroupMemberMetadata: []byte{1}}},
+	}}}
+	require.Equal(t, map[int16]int{protocol.ErrNotCoordinator.Code(): 1}, errorCodes(group))
+	require.Empty(t, errorCodes(&protocol.APIVersionsRequest{}))
+
+	require.Equal(t, "not coordinator", errorName(protocol.ErrNotCoordinator.Code()))
+	require.Equal(t, "1000", errorName(1000))
+}
+
+func TestRemoveProducerMetrics(t *testing.T) {
+	gv := stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{Name: "active"}, []string{"topic", "partition"})
+	cv := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "state_expirations_total"}, []string{"topic", "partition"})
+	m := &Metrics{
+		ActiveProducers:          prometheus.NewGauge(gv),
+		ProducerStateExpirations: prometheus.NewCounter(cv),
+		activeProducers:          gv,
+		producerStateExpirations: cv,
+	}
+	series := func(c stdprometheus.Collector) int {
+		ch := make(chan stdprometheus.Metric, 10)
+		c.Collect(ch)
+		close(ch)
+		return len(ch)
+	}
+
+	ps := newProducerStates(m)
+	ps.setActive(topicPartition{topic: "the-topic", partition: 0}, 2)
+	ps.setActive(topicPartition{topic: "the-topic", partition: 1}, 1)
+	m.ProducerStateExpirations.With("topic", "the-topic", "partition", "0").Add(1)
+	require.Equal(t, 2, series(gv))
+	require.Equal(t, 1, series(cv))
+
+	// the removed partition's series are gone rather than left at zero
+	ps.remove("the-topic", 0)
+	require.Equal(t, 1, series(gv))
+	require.Equal(t, 0, series(cv))
+
+	// nothing's tracked without metrics
+	newProducerStates(nil).remove("the-topic", 1)
+}
@@ -0,0 +1,41 @@
+package jocko
+
+import (
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+)
+
+// minInsyncReplicas returns the topic's min.insync.replicas, the fewest replicas, the leader
+// included, that have to be in sync for acks=all produces to its partitions to be appended.
+func minInsyncReplicas(t *structs.Topic) int {
+	n, ok := configInt(t.Config.GetValue("min.insync.replicas"))
+	if !ok || n < 1 {
+		return 1
+	}
+	return int(n)
+}
+
+// inSyncReplicas returns how many of the partition's isr are in sync as its leader sees them
+// now, less the followers that have fallen behind for longer than the max lag and that the
+// leader has yet to have the controller take out of the isr. acks=all produces are checked
+// against it before and after they're appended.
+func (b *Broker) inSyncReplicas(replica *Replica, now time.Time) int {
+	p := replica.Partition
+	if b.config.ReplicaLagTimeMax <= 0 || replica.Log == nil {
+		return len(p.ISR)
+	}
+	hw := b.followers.highWatermark(p, replica.Log.NewestOffset())
+	isr, changed := b.followers.isr(p, hw, b.config.ReplicaLagTimeMax, now)
+	if !changed {
+		return len(p.ISR)
+	}
+	n := 0
+	for _, id := range isr {
+		// followers that have caught up aren't in sync until they're back in the isr
+		if containsInt32(p.ISR, id) {
+			n++
+		}
+	}
+	return n
+}
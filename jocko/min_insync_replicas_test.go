@@ -0,0 +1,80 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/testutil/retry"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestMinInsyncReplicas(t *testing.T) {
+	topic := &structs.Topic{Topic: "the-topic", Config: structs.NewTopicConfig()}
+	require.Equal(t, 1, minInsyncReplicas(topic))
+	topic.Config.SetValue("min.insync.replicas", 3)
+	require.Equal(t, 3, minInsyncReplicas(topic))
+	topic.Config.SetValue("min.insync.replicas", 0)
+	require.Equal(t, 1, minInsyncReplicas(topic))
+}
+
+func TestBroker_MinInsyncReplicas(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.ReplicaLagTimeMax = 500 * time.Millisecond
+	})
+	defer teardown()
+	reqCtx := &Context{parent: context.Background()}
+	create := b.handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	require.Equal(t, protocol.ErrNone, b.alterTopicConfigs("the-topic", []protocol.AlterConfigsEntry{{Name: "min.insync.replicas", Value: strPtr("2")}}, false))
+	produce := func(acks int16) int16 {
+		resp := b.handleProduce(reqCtx, &protocol.ProduceRequest{Acks: acks, TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{RecordSet: testRecordBatch(-1, -1, -1, 0)}},
+		}}})
+		return resp.Responses[0].PartitionResponses[0].ErrorCode
+	}
+	// the replica's replaced whenever the isr changes
+	newestOffset := func() int64 {
+		replica, err := b.replicaLookup.Replica("the-topic", 0)
+		require.NoError(t, err)
+		return replica.Log.NewestOffset()
+	}
+
+	// the isr is too small for acks=all produces, which aren't appended
+	require.Equal(t, protocol.ErrNotEnoughReplicas.Code(), produce(-1))
+	require.Equal(t, int64(0), newestOffset())
+	require.Equal(t, protocol.ErrNone.Code(), produce(1))
+	require.Equal(t, int64(1), newestOffset())
+
+	addTestFollower(t, b, "the-topic", 0, 100)
+	require.Equal(t, protocol.ErrNone.Code(), produce(-1))
+	require.Equal(t, int64(2), newestOffset())
+
+	// a follower that's stopped fetching isn't in sync once it's lagged for longer than the max
+	time.Sleep(time.Second)
+	require.Equal(t, protocol.ErrNotEnoughReplicas.Code(), produce(-1))
+	require.Equal(t, int64(2), newestOffset())
+
+	// once it's caught up, and back in the isr if the leader had it taken out, they're accepted
+	retry.Run(t, func(r *retry.R) {
+		fetch := b.handleFetch(reqCtx, &protocol.FetchRequest{ReplicaID: 100, Topics: []*protocol.FetchTopic{{
+			Topic:      "the-topic",
+			Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: newestOffset(), MaxBytes: 100}},
+		}}})
+		if code := fetch.Responses[0].PartitionResponses[0].ErrorCode; code != protocol.ErrNone.Code() {
+			r.Fatalf("fetch failed: %d", code)
+		}
+		if code := produce(-1); code != protocol.ErrNone.Code() {
+			r.Fatalf("produce failed: %d", code)
+		}
+	})
+	require.Equal(t, int64(3), newestOffset())
+}
@@ -0,0 +1,90 @@
+package jocko
+
+import (
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// offsetExpired returns whether the offset the group committed has expired. Offsets only expire
+// once their group is empty, so its members don't lose offsets of partitions they're slow to
+// commit. Offsets committed before commit times were kept never expire.
+func (b *Broker) offsetExpired(group *structs.Group, offset structs.GroupOffset, now time.Time) bool {
+	if len(group.Members) > 0 || offset.CommitTime.IsZero() {
+		return false
+	}
+	expireTime := offset.ExpireTime
+	if expireTime.IsZero() {
+		expireTime = offset.CommitTime.Add(b.config.OffsetsRetention)
+	}
+	return now.After(expireTime)
+}
+
+// expiredOffsets returns the group's expired offsets' partitions by topic.
+func (b *Broker) expiredOffsets(group *structs.Group, now time.Time) map[string][]int32 {
+	expired := make(map[string][]int32)
+	for topic, partitions := range group.Offsets {
+		for partition, offset := range partitions {
+			if b.offsetExpired(group, offset, now) {
+				expired[topic] = append(expired[topic], partition)
+			}
+		}
+	}
+	return expired
+}
+
+// removeExpiredOffsets removes the expired offsets of the groups the broker coordinates every
+// interval, so the offsets of groups that are gone don't pile up. They're tombstoned in the
+// offsets topic and then deleted from the store, like deleting them with OffsetDelete.
+func (b *Broker) removeExpiredOffsets(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.coordinatorsShutdownCh:
+			return
+		case <-ticker.C:
+		}
+		if b.readOnly() {
+			continue
+		}
+		_, groups, err := b.fsm.State().GetGroups()
+		if err != nil {
+			b.logger.Error("failed to get groups", log.Error("error", err))
+			continue
+		}
+		now := time.Now()
+		for _, group := range groups {
+			if b.groupCoordinator(group.Group, group) != protocol.ErrNone {
+				continue
+			}
+			expired := b.expiredOffsets(group, now)
+			if len(expired) == 0 {
+				continue
+			}
+			if err := b.removeOffsets(group.Group, expired); err != protocol.ErrNone {
+				b.logger.Error("failed to remove expired offsets", log.String("group", group.Group), log.Error("error", err))
+				continue
+			}
+			b.logger.Info("removed expired offsets", log.String("group", group.Group), log.Int("topics", len(expired)))
+		}
+	}
+}
+
+// removeOffsets tombstones the group's offsets of the partitions in the offsets topic and then
+// deletes them from the store.
+func (b *Broker) removeOffsets(group string, partitions map[string][]int32) protocol.Error {
+	if err := b.writeOffsetTombstones(group, partitions); err != protocol.ErrNone {
+		return err
+	}
+	res, err := b.raftApply(structs.DeleteOffsetsRequestType, structs.DeleteOffsetsRequest{Group: group, Offsets: partitions})
+	if err == nil {
+		err, _ = res.(error)
+	}
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	return protocol.ErrNone
+}
@@ -0,0 +1,64 @@
+package jocko
+
+import (
+	"io"
+	"math"
+
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// timeIndexedLog is implemented by commit logs that can look up offsets by timestamp.
+type timeIndexedLog interface {
+	OffsetForTimestamp(timestamp int64) (commitlog.TimeEntry, bool)
+}
+
+// offsetForTimestamp returns the earliest offset of the replica's log whose timestamp is at least
+// the given one, in milliseconds, along with the timestamp, or false if there's no such offset.
+// The log's time index finds the batch, then the batch's records are read to find the offset
+// within it, except for batches compressed with codecs that aren't supported and older message
+// sets whose first offset's returned.
+func offsetForTimestamp(replica *Replica, timestamp int64) (offset int64, ts int64, ok bool) {
+	l, ok := replica.Log.(timeIndexedLog)
+	if !ok {
+		return -1, -1, false
+	}
+	e, ok := l.OffsetForTimestamp(timestamp)
+	if !ok {
+		return -1, -1, false
+	}
+	r, err := replica.Log.NewReader(e.Offset, math.MaxInt32)
+	if err != nil {
+		return e.Offset, e.Timestamp, true
+	}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return e.Offset, e.Timestamp, true
+	}
+	size := int32(protocol.Encoding.Uint32(header[8:]))
+	if size < 0 {
+		return e.Offset, e.Timestamp, true
+	}
+	entry := make([]byte, 12+int(size))
+	copy(entry, header)
+	if _, err := io.ReadFull(r, entry[12:]); err != nil {
+		return e.Offset, e.Timestamp, true
+	}
+	batches, err := protocol.ReadRecordBatches(entry)
+	if err != nil || len(batches) == 0 {
+		return e.Offset, e.Timestamp, true
+	}
+	batch := batches[0]
+	for _, rec := range batch.Records {
+		offset := batch.BaseOffset + int64(rec.OffsetDelta)
+		ts := batch.FirstTimestamp + rec.TimestampDelta
+		if batch.LogAppendTime() {
+			ts = batch.MaxTimestamp
+		}
+		// the batch the reader started at may hold offsets before the log start offset
+		if offset >= e.Offset && ts >= timestamp {
+			return offset, ts, true
+		}
+	}
+	return e.Offset, e.Timestamp, true
+}
@@ -0,0 +1,145 @@
+package jocko
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// Checks the ordering audit flags violations of.
+const (
+	// auditOffset is a follower appending a record set at a different offset than the leader's.
+	auditOffset = "offset"
+	// auditSequence is an idempotent producer's batch not following its last in the partition.
+	auditSequence = "sequence"
+	// auditEpoch is an idempotent producer's batch with an older epoch than its last.
+	auditEpoch = "epoch"
+	// auditHighWatermark is a partition's high watermark going down.
+	auditHighWatermark = "high_watermark"
+)
+
+// maxAuditedProducers bounds the producers audited per partition, they're forgotten past it so
+// producers that have come and gone aren't held on to forever.
+const maxAuditedProducers = 10000
+
+// orderingAudit checks what's appended to the partitions this broker replicates only ever goes
+// forward: the producers' sequences, the offsets followers append at and the high watermarks of
+// the partitions it leads. Violations are logged and counted rather than refused, it's there to
+// validate the replication and idempotence code paths in staging. A nil audit checks nothing,
+// like when it isn't turned on.
+type orderingAudit struct {
+	logger  log.Logger
+	metrics *Metrics
+
+	mu         sync.Mutex
+	partitions map[topicPartition]*auditedPartition
+}
+
+type auditedPartition struct {
+	// highWatermark is the last high watermark seen, -1 until there's been one.
+	highWatermark int64
+	producers     map[int64]auditedProducer
+}
+
+// auditedProducer is the epoch and sequence of a producer's last batch appended to a partition.
+type auditedProducer struct {
+	epoch    int16
+	sequence int32
+}
+
+func newOrderingAudit(logger log.Logger, metrics *Metrics) *orderingAudit {
+	return &orderingAudit{
+		logger:     logger,
+		metrics:    metrics,
+		partitions: make(map[topicPartition]*auditedPartition),
+	}
+}
+
+// appended checks the idempotent producers' batches in the record set appended to the partition
+// follow their last. Unlike the checks before producing, which refuse the batches, this sees what
+// was actually appended, followers' appends included.
+func (a *orderingAudit) appended(topic string, partition int32, recordSet []byte) {
+	if a == nil {
+		return
+	}
+	batches := protocol.RecordBatchProducers(recordSet)
+	if len(batches) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := a.partition(topic, partition)
+	if len(p.producers) >= maxAuditedProducers {
+		p.producers = make(map[int64]auditedProducer)
+	}
+	for _, batch := range batches {
+		last, ok := p.producers[batch.ProducerID]
+		switch {
+		case !ok:
+		case batch.ProducerEpoch < last.epoch:
+			a.violation(topic, partition, auditEpoch, log.Int64("offset", batch.BaseOffset), log.Int64("producer id", batch.ProducerID), log.Int16("epoch", batch.ProducerEpoch), log.Int16("last epoch", last.epoch))
+		case batch.ProducerEpoch == last.epoch && batch.BaseSequence != nextSequence(last.sequence):
+			a.violation(topic, partition, auditSequence, log.Int64("offset", batch.BaseOffset), log.Int64("producer id", batch.ProducerID), log.Int32("sequence", batch.BaseSequence), log.Int32("last sequence", last.sequence))
+		}
+		p.producers[batch.ProducerID] = auditedProducer{epoch: batch.ProducerEpoch, sequence: batch.LastSequence()}
+	}
+}
+
+// replicated checks a follower appended the record set at the offset the leader had it at,
+// otherwise the follower's log has diverged from the leader's.
+func (a *orderingAudit) replicated(topic string, partition int32, leaderOffset, offset int64) {
+	if a == nil || leaderOffset == offset {
+		return
+	}
+	a.violation(topic, partition, auditOffset, log.Int64("offset", offset), log.Int64("leader offset", leaderOffset))
+}
+
+// committed checks the high watermark of a partition this broker leads hasn't gone down. It's
+// passed a func getting it, called with the audit's lock held, so high watermarks got by
+// concurrent produces and fetches are checked in order.
+func (a *orderingAudit) committed(topic string, partition int32, highWatermark func() int64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := a.partition(topic, partition)
+	hw := highWatermark()
+	if hw < p.highWatermark {
+		a.violation(topic, partition, auditHighWatermark, log.Int64("high watermark", hw), log.Int64("last high watermark", p.highWatermark))
+	}
+	p.highWatermark = hw
+}
+
+// remove forgets what's been seen of the partition, when this broker becomes its leader or a
+// follower, or its replica's gone, since its log can be truncated and its high watermark start
+// over then.
+func (a *orderingAudit) remove(topic string, partition int32) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.partitions, topicPartition{topic: topic, partition: partition})
+}
+
+// partition returns what's been seen of the partition. It's called with the lock held.
+func (a *orderingAudit) partition(topic string, partition int32) *auditedPartition {
+	key := topicPartition{topic: topic, partition: partition}
+	p, ok := a.partitions[key]
+	if !ok {
+		p = &auditedPartition{highWatermark: -1, producers: make(map[int64]auditedProducer)}
+		a.partitions[key] = p
+	}
+	return p
+}
+
+func (a *orderingAudit) violation(topic string, partition int32, check string, fields ...log.Field) {
+	fields = append([]log.Field{log.String("topic", topic), log.Int32("partition", partition), log.String("check", check)}, fields...)
+	a.logger.Error("ordering audit: violation", fields...)
+	if a.metrics != nil {
+		a.metrics.OrderingViolations.With("topic", topic, "partition", strconv.Itoa(int(partition)), "check", check).Add(1)
+	}
+}
@@ -0,0 +1,71 @@
+package jocko
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/log"
+)
+
+func TestOrderingAudit(t *testing.T) {
+	logger := &auditLogger{Logger: log.New()}
+	a := newOrderingAudit(logger, nil)
+	violations := func(check string) int {
+		n := 0
+		for _, c := range logger.checks {
+			if c == check {
+				n++
+			}
+		}
+		return n
+	}
+
+	// producers' batches follow their last, or start a new epoch
+	a.appended("the-topic", 0, append(testRecordBatch(7, 0, 0, 4), testRecordBatch(7, 0, 5, 0)...))
+	a.appended("the-topic", 0, testRecordBatch(7, 1, 0, 0))
+	require.Equal(t, 0, violations(auditSequence))
+	a.appended("the-topic", 0, testRecordBatch(7, 1, 2, 0))
+	require.Equal(t, 1, violations(auditSequence))
+	a.appended("the-topic", 0, testRecordBatch(7, 0, 6, 0))
+	require.Equal(t, 1, violations(auditEpoch))
+	// other partitions' producers are their own
+	a.appended("the-topic", 1, testRecordBatch(7, 0, 10, 0))
+	require.Equal(t, 1, violations(auditSequence))
+
+	a.replicated("the-topic", 0, 5, 5)
+	require.Equal(t, 0, violations(auditOffset))
+	a.replicated("the-topic", 0, 5, 6)
+	require.Equal(t, 1, violations(auditOffset))
+
+	hw := func(offset int64) func() int64 { return func() int64 { return offset } }
+	a.committed("the-topic", 0, hw(3))
+	a.committed("the-topic", 0, hw(3))
+	a.committed("the-topic", 0, hw(5))
+	require.Equal(t, 0, violations(auditHighWatermark))
+	a.committed("the-topic", 0, hw(4))
+	require.Equal(t, 1, violations(auditHighWatermark))
+	// the high watermark starts over once the partition's changed leaders
+	a.remove("the-topic", 0)
+	a.committed("the-topic", 0, hw(0))
+	require.Equal(t, 1, violations(auditHighWatermark))
+
+	// a nil audit, when it isn't turned on, checks nothing
+	var off *orderingAudit
+	off.appended("the-topic", 0, testRecordBatch(7, 0, 0, 0))
+	off.committed("the-topic", 0, hw(0))
+	off.remove("the-topic", 0)
+}
+
+// auditLogger records the checks of the violations the audit logs.
+type auditLogger struct {
+	log.Logger
+	checks []string
+}
+
+func (l *auditLogger) Error(msg string, fields ...log.Field) {
+	for _, f := range fields {
+		if f.Key == "check" {
+			l.checks = append(l.checks, f.String)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package jocko
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/travisjeffery/jocko/protocol"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// assignmentSpecVersion is the version of the assignment spec's format.
+const assignmentSpecVersion = 1
+
+// AssignmentSpec declares where the cluster's partitions' replicas are placed. It's exported from
+// the cluster and applied back to it after being edited, so partition placement can be kept and
+// reviewed in version control.
+type AssignmentSpec struct {
+	Version    int                   `json:"version" yaml:"version"`
+	Partitions []PartitionAssignment `json:"partitions" yaml:"partitions"`
+}
+
+// PartitionAssignment is the replicas of a partition in an assignment spec.
+type PartitionAssignment struct {
+	Topic     string  `json:"topic" yaml:"topic"`
+	Partition int32   `json:"partition" yaml:"partition"`
+	Replicas  []int32 `json:"replicas" yaml:"replicas,flow"`
+	// Leader is the partition's leader when the spec was exported. It's ignored when the spec is
+	// applied, the first replica being the preferred leader.
+	Leader int32 `json:"leader" yaml:"leader"`
+}
+
+// ParseAssignmentSpec parses a JSON or YAML assignment spec.
+func ParseAssignmentSpec(b []byte) (*AssignmentSpec, error) {
+	spec := new(AssignmentSpec)
+	// JSON is YAML too
+	if err := yaml.UnmarshalStrict(b, spec); err != nil {
+		return nil, err
+	}
+	if spec.Version != assignmentSpecVersion {
+		return nil, fmt.Errorf("unsupported assignment spec version %d", spec.Version)
+	}
+	return spec, nil
+}
+
+// Marshal encodes the spec in the format, json or yaml.
+func (s *AssignmentSpec) Marshal(format string) ([]byte, error) {
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	case "yaml":
+		return yaml.Marshal(s)
+	default:
+		return nil, fmt.Errorf("unknown assignment spec format %q", format)
+	}
+}
+
+// assignmentSpec returns the spec of the partitions in the metadata, sorted by topic and
+// partition. Topics the metadata has errors for are left out.
+func assignmentSpec(resp *protocol.MetadataResponse) *AssignmentSpec {
+	spec := &AssignmentSpec{Version: assignmentSpecVersion, Partitions: []PartitionAssignment{}}
+	for _, t := range resp.TopicMetadata {
+		if t.TopicErrorCode != protocol.ErrNone.Code() {
+			continue
+		}
+		for _, p := range t.PartitionMetadata {
+			spec.Partitions = append(spec.Partitions, PartitionAssignment{
+				Topic:     t.Topic,
+				Partition: p.PartitionID,
+				Replicas:  p.Replicas,
+				Leader:    p.Leader,
+			})
+		}
+	}
+	sort.Slice(spec.Partitions, func(i, j int) bool {
+		pi, pj := spec.Partitions[i], spec.Partitions[j]
+		if pi.Topic != pj.Topic {
+			return pi.Topic < pj.Topic
+		}
+		return pi.Partition < pj.Partition
+	})
+	return spec
+}
+
+// AssignmentChange is a partition whose replicas an applied spec changes, and the error moving
+// them if it failed.
+type AssignmentChange struct {
+	Topic     string
+	Partition int32
+	From      []int32
+	To        []int32
+	Err       error
+}
+
+func (c AssignmentChange) String() string {
+	return fmt.Sprintf("%s-%d: %v -> %v", c.Topic, c.Partition, c.From, c.To)
+}
+
+// diffAssignments returns the partitions whose replicas differ between the current and desired
+// specs, in the desired spec's order. Partitions the desired spec leaves out stay where they are,
+// while ones the cluster doesn't have, duplicated or with invalid replicas are errors since
+// reassignments can't create partitions.
+func diffAssignments(current, desired *AssignmentSpec) ([]AssignmentChange, error) {
+	replicas := make(map[topicPartition][]int32, len(current.Partitions))
+	for _, p := range current.Partitions {
+		replicas[topicPartition{topic: p.Topic, partition: p.Partition}] = p.Replicas
+	}
+	seen := make(map[topicPartition]bool, len(desired.Partitions))
+	var changes []AssignmentChange
+	for _, p := range desired.Partitions {
+		tp := topicPartition{topic: p.Topic, partition: p.Partition}
+		if seen[tp] {
+			return nil, fmt.Errorf("%s-%d is in the spec more than once", p.Topic, p.Partition)
+		}
+		seen[tp] = true
+		from, ok := replicas[tp]
+		if !ok {
+			return nil, fmt.Errorf("%s-%d doesn't exist", p.Topic, p.Partition)
+		}
+		if len(p.Replicas) == 0 {
+			return nil, fmt.Errorf("%s-%d has no replicas", p.Topic, p.Partition)
+		}
+		ids := make(map[int32]bool, len(p.Replicas))
+		for _, id := range p.Replicas {
+			if ids[id] {
+				return nil, fmt.Errorf("%s-%d has broker %d as a replica more than once", p.Topic, p.Partition, id)
+			}
+			ids[id] = true
+		}
+		if !equalReplicas(from, p.Replicas) {
+			changes = append(changes, AssignmentChange{Topic: p.Topic, Partition: p.Partition, From: from, To: p.Replicas})
+		}
+	}
+	return changes, nil
+}
+
+// equalReplicas returns whether the replicas are the same in the same order, the order deciding
+// the preferred leader.
+func equalReplicas(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// alterReassignmentsRequest returns the request reassigning the changed partitions, grouped by topic.
+func alterReassignmentsRequest(changes []AssignmentChange, timeout time.Duration) *protocol.AlterPartitionReassignmentsRequest {
+	req := &protocol.AlterPartitionReassignmentsRequest{Timeout: timeout}
+	topics := make(map[string]int)
+	for _, c := range changes {
+		i, ok := topics[c.Topic]
+		if !ok {
+			i = len(req.Topics)
+			topics[c.Topic] = i
+			req.Topics = append(req.Topics, protocol.AlterPartitionReassignmentsTopic{Topic: c.Topic})
+		}
+		req.Topics[i].Partitions = append(req.Topics[i].Partitions, protocol.AlterPartitionReassignmentsPartition{
+			Partition: c.Partition,
+			Replicas:  c.To,
+		})
+	}
+	return req
+}
+
+// ExportAssignments returns the spec of where every partition in the cluster is placed, looked
+// up from the broker at addr.
+func ExportAssignments(dialer *Dialer, addr string, timeout time.Duration) (*AssignmentSpec, error) {
+	client := newClusterClient([]string{addr}, dialer, timeout)
+	defer client.close()
+	resp, err := client.allMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return assignmentSpec(resp), nil
+}
+
+// ApplyAssignments diffs the spec against where the cluster's partitions are placed and has the
+// controller reassign the ones that differ, returning the changes with the errors of the ones it
+// couldn't start. With dryRun the changes are only returned, to preview them.
+func ApplyAssignments(dialer *Dialer, addr string, spec *AssignmentSpec, dryRun bool, timeout time.Duration) ([]AssignmentChange, error) {
+	client := newClusterClient([]string{addr}, dialer, timeout)
+	defer client.close()
+	resp, err := client.allMetadata()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := diffAssignments(assignmentSpec(resp), spec)
+	if err != nil || dryRun || len(changes) == 0 {
+		return changes, err
+	}
+	conn, err := client.controllerConn()
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.AlterPartitionReassignments(alterReassignmentsRequest(changes, timeout))
+	if err != nil {
+		return nil, err
+	}
+	if res.ErrorCode != protocol.ErrNone.Code() {
+		return nil, protocol.Errs[res.ErrorCode]
+	}
+	errs := make(map[topicPartition]error)
+	for _, t := range res.Topics {
+		for _, p := range t.Partitions {
+			if p.ErrorCode == protocol.ErrNone.Code() {
+				continue
+			}
+			err := error(protocol.Errs[p.ErrorCode])
+			if p.ErrorMessage != nil {
+				err = fmt.Errorf("%v: %s", err, *p.ErrorMessage)
+			}
+			errs[topicPartition{topic: t.Topic, partition: p.Partition}] = err
+		}
+	}
+	for i, c := range changes {
+		changes[i].Err = errs[topicPartition{topic: c.Topic, partition: c.Partition}]
+	}
+	return changes, nil
+}
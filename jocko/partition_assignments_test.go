@@ -0,0 +1,66 @@
+package jocko
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestAssignmentSpec(t *testing.T) {
+	req := require.New(t)
+	current := assignmentSpec(&protocol.MetadataResponse{TopicMetadata: []*protocol.TopicMetadata{
+		{Topic: "b", PartitionMetadata: []*protocol.PartitionMetadata{
+			{PartitionID: 1, Leader: 2, Replicas: []int32{2, 3}},
+			{PartitionID: 0, Leader: 1, Replicas: []int32{1, 2}},
+		}},
+		{Topic: "a", PartitionMetadata: []*protocol.PartitionMetadata{{PartitionID: 0, Leader: 3, Replicas: []int32{3, 1}}}},
+		{Topic: "unknown", TopicErrorCode: protocol.ErrUnknownTopicOrPartition.Code()},
+	}})
+	req.Equal([]PartitionAssignment{
+		{Topic: "a", Partition: 0, Replicas: []int32{3, 1}, Leader: 3},
+		{Topic: "b", Partition: 0, Replicas: []int32{1, 2}, Leader: 1},
+		{Topic: "b", Partition: 1, Replicas: []int32{2, 3}, Leader: 2},
+	}, current.Partitions)
+
+	// specs round trip through both formats
+	for _, format := range []string{"json", "yaml"} {
+		b, err := current.Marshal(format)
+		req.NoError(err)
+		parsed, err := ParseAssignmentSpec(b)
+		req.NoError(err)
+		req.Equal(current, parsed)
+	}
+	_, err := ParseAssignmentSpec([]byte(`{"version": 2, "partitions": []}`))
+	req.Error(err)
+	_, err = ParseAssignmentSpec([]byte(`{"version": 1, "partitions": [{"topic": "a", "replica": [1]}]}`))
+	req.Error(err)
+
+	// the leaders are ignored, reordered replicas change the preferred leader
+	desired, err := ParseAssignmentSpec([]byte(`
+version: 1
+partitions:
+- {topic: a, partition: 0, replicas: [1, 3], leader: 1}
+- {topic: b, partition: 1, replicas: [2, 3], leader: 3}
+`))
+	req.NoError(err)
+	changes, err := diffAssignments(current, desired)
+	req.NoError(err)
+	req.Equal([]AssignmentChange{{Topic: "a", Partition: 0, From: []int32{3, 1}, To: []int32{1, 3}}}, changes)
+	req.Equal("a-0: [3 1] -> [1 3]", changes[0].String())
+	reassign := alterReassignmentsRequest(append(changes, AssignmentChange{Topic: "b", Partition: 0, To: []int32{3}}, AssignmentChange{Topic: "a", Partition: 1, To: []int32{2}}), 0)
+	req.Equal([]protocol.AlterPartitionReassignmentsTopic{
+		{Topic: "a", Partitions: []protocol.AlterPartitionReassignmentsPartition{{Partition: 0, Replicas: []int32{1, 3}}, {Partition: 1, Replicas: []int32{2}}}},
+		{Topic: "b", Partitions: []protocol.AlterPartitionReassignmentsPartition{{Partition: 0, Replicas: []int32{3}}}},
+	}, reassign.Topics)
+
+	for _, partitions := range [][]PartitionAssignment{
+		{{Topic: "a", Partition: 1, Replicas: []int32{1}}},
+		{{Topic: "a", Partition: 0, Replicas: []int32{1}}, {Topic: "a", Partition: 0, Replicas: []int32{2}}},
+		{{Topic: "a", Partition: 0}},
+		{{Topic: "a", Partition: 0, Replicas: []int32{1, 1}}},
+	} {
+		_, err = diffAssignments(current, &AssignmentSpec{Version: 1, Partitions: partitions})
+		req.Error(err)
+	}
+}
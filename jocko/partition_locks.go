@@ -0,0 +1,29 @@
+package jocko
+
+import "sync"
+
+// partitionLocks holds the locks of this broker's partitions. Handlers run concurrently, so
+// those that read and change a partition's replica, log and producers, like produces and
+// LeaderAndISR requests, hold its lock while they do. They're kept since a lock that's removed
+// while it's held would let the next handler in.
+type partitionLocks struct {
+	mu    sync.Mutex
+	locks map[topicPartition]*sync.Mutex
+}
+
+func newPartitionLocks() *partitionLocks {
+	return &partitionLocks{locks: make(map[topicPartition]*sync.Mutex)}
+}
+
+// get returns the partition's lock.
+func (pl *partitionLocks) get(topic string, partition int32) *sync.Mutex {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	l, ok := pl.locks[key]
+	if !ok {
+		l = new(sync.Mutex)
+		pl.locks[key] = l
+	}
+	return l
+}
@@ -0,0 +1,15 @@
+package jocko
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionLocks(t *testing.T) {
+	locks := newPartitionLocks()
+	mu := locks.get("the-topic", 0)
+	require.True(t, mu == locks.get("the-topic", 0))
+	require.False(t, mu == locks.get("the-topic", 1))
+	require.False(t, mu == locks.get("another-topic", 0))
+}
@@ -0,0 +1,399 @@
+package jocko
+
+import (
+	"context"
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// reassignmentsRequest is an AlterPartitionReassignments request passed to the leader loop,
+// which sends the partitions' results on result.
+type reassignmentsRequest struct {
+	topics []protocol.AlterPartitionReassignmentsTopic
+	result chan reassignmentsResult
+}
+
+// reassignmentsResult is the results of an AlterPartitionReassignments request, err is set if
+// the reassignments couldn't be submitted at all.
+type reassignmentsResult struct {
+	topics []protocol.AlterPartitionReassignmentsTopicResponse
+	err    protocol.Error
+}
+
+// reassignmentsTimeout bounds how long AlterPartitionReassignments requests wait for their
+// reassignments to be submitted, they're moved in the background after that.
+const reassignmentsTimeout = 30 * time.Second
+
+// alterPartitionReassignments passes the reassignments to the leader loop and waits for them to
+// be submitted. Every partition gets the error if they weren't, e.g. because this broker isn't
+// the controller or lost leadership while they were waiting.
+func (b *Broker) alterPartitionReassignments(req *protocol.AlterPartitionReassignmentsRequest) ([]protocol.AlterPartitionReassignmentsTopicResponse, protocol.Error) {
+	stopCh := b.leaderStop()
+	if stopCh == nil {
+		return reassignmentsErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	}
+	r := &reassignmentsRequest{
+		topics: req.Topics,
+		result: make(chan reassignmentsResult, 1),
+	}
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > reassignmentsTimeout {
+		timeout = reassignmentsTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case b.reassignmentsCh <- r:
+	case <-timer.C:
+		return reassignmentsErrors(req.Topics, protocol.ErrRequestTimedOut), protocol.ErrRequestTimedOut
+	case <-stopCh:
+		return reassignmentsErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	case <-b.shutdownCh:
+		return reassignmentsErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	}
+	select {
+	case res := <-r.result:
+		return res.topics, res.err
+	case <-timer.C:
+		return reassignmentsErrors(req.Topics, protocol.ErrRequestTimedOut), protocol.ErrRequestTimedOut
+	case <-stopCh:
+		return reassignmentsErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	case <-b.shutdownCh:
+		return reassignmentsErrors(req.Topics, protocol.ErrNotController), protocol.ErrNotController
+	}
+}
+
+// reassignmentsErrors returns results with err for every partition.
+func reassignmentsErrors(topics []protocol.AlterPartitionReassignmentsTopic, err protocol.Error) []protocol.AlterPartitionReassignmentsTopicResponse {
+	results := make([]protocol.AlterPartitionReassignmentsTopicResponse, len(topics))
+	for i, t := range topics {
+		results[i] = protocol.AlterPartitionReassignmentsTopicResponse{
+			Topic:      t.Topic,
+			Partitions: make([]protocol.AlterPartitionReassignmentsPartitionResponse, len(t.Partitions)),
+		}
+		for j, p := range t.Partitions {
+			results[i].Partitions[j] = protocol.AlterPartitionReassignmentsPartitionResponse{Partition: p.Partition, ErrorCode: err.Code()}
+		}
+	}
+	return results
+}
+
+// runReassignments runs in the leader loop, submitting the reassignments: the partitions are
+// assigned both their current and target replicas so the new replicas start following their
+// leaders, and once they've caught up the reassignments are completed by reconciling. Replicas
+// reassigned again are moved to their new targets, and cancelled reassignments move the
+// partitions back to the replicas they had before.
+func (b *Broker) runReassignments(r *reassignmentsRequest) reassignmentsResult {
+	state := b.fsm.State()
+	_, nodes, err := state.GetNodes()
+	if err != nil {
+		b.logger.Error("leader: failed to get nodes", log.Error("error", err))
+		return reassignmentsResult{topics: reassignmentsErrors(r.topics, protocol.ErrUnknown), err: protocol.ErrUnknown}
+	}
+	registered := make(map[int32]bool, len(nodes))
+	for _, n := range nodes {
+		registered[n.Node] = true
+	}
+	passing, err := b.passingNodes()
+	if err != nil {
+		b.logger.Error("leader: failed to get passing nodes", log.Error("error", err))
+		return reassignmentsResult{topics: reassignmentsErrors(r.topics, protocol.ErrUnknown), err: protocol.ErrUnknown}
+	}
+	results := reassignmentsErrors(r.topics, protocol.ErrNone)
+	var changed []structs.Partition
+	var submitted []*protocol.AlterPartitionReassignmentsPartitionResponse
+	stops := make(map[int32]*protocol.StopReplicaRequest)
+	for i, t := range r.topics {
+		for j, rp := range t.Partitions {
+			res := &results[i].Partitions[j]
+			_, p, err := state.GetPartition(t.Topic, rp.Partition)
+			if err != nil {
+				b.logger.Error("leader: failed to get partition", log.Error("error", err))
+				res.ErrorCode = protocol.ErrUnknown.Code()
+				continue
+			}
+			if p == nil {
+				res.ErrorCode = protocol.ErrUnknownTopicOrPartition.Code()
+				continue
+			}
+			target := rp.Replicas
+			if target == nil {
+				if !reassigning(p) {
+					res.ErrorCode = protocol.ErrNoReassignmentInProgress.Code()
+					continue
+				}
+				target = originalReplicas(p)
+			} else if !validReplicas(target, registered) {
+				res.ErrorCode = protocol.ErrInvalidReplicaAssignment.Code()
+				continue
+			}
+			pp, perr := reassignPartition(p, target, passing)
+			if perr != protocol.ErrNone {
+				res.ErrorCode = perr.Code()
+				continue
+			}
+			changed = append(changed, pp)
+			submitted = append(submitted, res)
+			// replicas that were being added and no longer are can be stopped right away
+			for _, id := range p.AR {
+				if !containsInt32(pp.AR, id) {
+					addStopReplica(stops, b.config.ID, id, p)
+				}
+			}
+		}
+	}
+	if err := b.updatePartitions(changed); err != nil {
+		b.logger.Error("leader: failed to update reassigned partitions", log.Error("error", err))
+		for _, res := range submitted {
+			res.ErrorCode = protocol.ErrUnknown.Code()
+		}
+		return reassignmentsResult{topics: results, err: protocol.ErrNone}
+	}
+	b.stopReplicas(&Context{parent: context.Background()}, stops)
+	if len(changed) != 0 {
+		b.logger.Info("leader: submitted partition reassignments", log.Int("partitions", len(changed)))
+	}
+	// reassignments that only remove replicas can be completed already
+	if err := b.completeReassignments(); err != nil {
+		b.logger.Error("leader: failed to complete reassignments", log.Error("error", err))
+	}
+	return reassignmentsResult{topics: results, err: protocol.ErrNone}
+}
+
+// reassignPartition returns the partition being reassigned to the target replicas, assigned the
+// target replicas followed by the original replicas being removed. Its leader's moved to a
+// passing in-sync replica if it was a replica being added that no longer is.
+func reassignPartition(p *structs.Partition, target []int32, passing map[int32]bool) (structs.Partition, protocol.Error) {
+	original := originalReplicas(p)
+	pp := *p
+	pp.AddingReplicas = nil
+	pp.RemovingReplicas = nil
+	pp.AR = append([]int32(nil), target...)
+	for _, id := range target {
+		if !containsInt32(original, id) {
+			pp.AddingReplicas = append(pp.AddingReplicas, id)
+		}
+	}
+	for _, id := range original {
+		if !containsInt32(target, id) {
+			pp.RemovingReplicas = append(pp.RemovingReplicas, id)
+			pp.AR = append(pp.AR, id)
+		}
+	}
+	pp.ISR = nil
+	for _, id := range p.ISR {
+		if containsInt32(pp.AR, id) {
+			pp.ISR = append(pp.ISR, id)
+		}
+	}
+	if containsInt32(pp.ISR, p.Leader) {
+		return pp, protocol.ErrNone
+	}
+	for _, id := range pp.ISR {
+		if passing[id] {
+			pp.Leader = id
+			pp.LeaderEpoch++
+			return pp, protocol.ErrNone
+		}
+	}
+	return pp, protocol.ErrEligibleLeadersNotAvailable
+}
+
+// completeReassignments completes the reassignments whose replicas being added are all in sync:
+// the partitions are assigned their target replicas, their leadership's moved off the replicas
+// being removed, and the removed replicas are stopped and their logs deleted. It's run by the
+// leader loop as part of reconciling.
+func (b *Broker) completeReassignments() error {
+	state := b.fsm.State()
+	_, partitions, err := state.GetPartitions()
+	if err != nil {
+		return err
+	}
+	var passing map[int32]bool
+	topics := make(map[string]*structs.Topic)
+	var changed []structs.Partition
+	stops := make(map[int32]*protocol.StopReplicaRequest)
+	for _, p := range partitions {
+		if !reassigning(p) {
+			continue
+		}
+		caughtUp := true
+		for _, id := range p.AddingReplicas {
+			if !containsInt32(p.ISR, id) {
+				caughtUp = false
+				break
+			}
+		}
+		if !caughtUp {
+			continue
+		}
+		if passing == nil {
+			if passing, err = b.passingNodes(); err != nil {
+				return err
+			}
+		}
+		pp := *p
+		pp.AR = nil
+		for _, id := range p.AR {
+			if !containsInt32(p.RemovingReplicas, id) {
+				pp.AR = append(pp.AR, id)
+			}
+		}
+		pp.ISR = nil
+		for _, id := range p.ISR {
+			if containsInt32(pp.AR, id) {
+				pp.ISR = append(pp.ISR, id)
+			}
+		}
+		if !containsInt32(pp.ISR, p.Leader) || !passing[p.Leader] {
+			pp.Leader = -1
+			for _, id := range pp.ISR {
+				if passing[id] {
+					pp.Leader = id
+					pp.LeaderEpoch++
+					break
+				}
+			}
+			if pp.Leader == -1 {
+				// wait for a target replica to be in sync to lead the partition
+				continue
+			}
+		}
+		pp.AddingReplicas = nil
+		pp.RemovingReplicas = nil
+		topic, ok := topics[p.Topic]
+		if !ok {
+			_, t, err := state.GetTopic(p.Topic)
+			if err != nil {
+				return err
+			}
+			if t == nil {
+				continue
+			}
+			// copy the topic and its assignments since they're the store's
+			tt := *t
+			tt.Partitions = make(map[int32][]int32, len(t.Partitions))
+			for k, v := range t.Partitions {
+				tt.Partitions[k] = v
+			}
+			topic = &tt
+			topics[p.Topic] = topic
+		}
+		topic.Partitions[p.ID] = pp.AR
+		changed = append(changed, pp)
+		for _, id := range p.RemovingReplicas {
+			addStopReplica(stops, b.config.ID, id, p)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	for _, t := range topics {
+		if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: *t}); err != nil {
+			return err
+		}
+	}
+	if err := b.updatePartitions(changed); err != nil {
+		return err
+	}
+	b.stopReplicas(&Context{parent: context.Background()}, stops)
+	b.logger.Info("leader: completed partition reassignments", log.Int("partitions", len(changed)))
+	return nil
+}
+
+// listPartitionReassignments returns the ongoing reassignments of the partitions, or of every
+// partition if topics is nil. Partitions that aren't being reassigned are left out.
+func (b *Broker) listPartitionReassignments(topics []protocol.ListPartitionReassignmentsTopic) ([]protocol.ListPartitionReassignmentsTopicResponse, protocol.Error) {
+	if !b.isController() {
+		return nil, protocol.ErrNotController
+	}
+	state := b.fsm.State()
+	var partitions []*structs.Partition
+	if topics == nil {
+		_, ps, err := state.GetPartitions()
+		if err != nil {
+			return nil, protocol.ErrUnknown.WithErr(err)
+		}
+		partitions = ps
+	}
+	for _, t := range topics {
+		for _, id := range t.Partitions {
+			_, p, err := state.GetPartition(t.Topic, id)
+			if err != nil {
+				return nil, protocol.ErrUnknown.WithErr(err)
+			}
+			if p != nil {
+				partitions = append(partitions, p)
+			}
+		}
+	}
+	var results []protocol.ListPartitionReassignmentsTopicResponse
+	index := make(map[string]int)
+	for _, p := range partitions {
+		if !reassigning(p) {
+			continue
+		}
+		i, ok := index[p.Topic]
+		if !ok {
+			i = len(results)
+			index[p.Topic] = i
+			results = append(results, protocol.ListPartitionReassignmentsTopicResponse{Topic: p.Topic})
+		}
+		results[i].Partitions = append(results[i].Partitions, protocol.OngoingPartitionReassignment{
+			Partition:        p.ID,
+			Replicas:         p.AR,
+			AddingReplicas:   p.AddingReplicas,
+			RemovingReplicas: p.RemovingReplicas,
+		})
+	}
+	return results, protocol.ErrNone
+}
+
+// reassigning returns whether the partition's being reassigned.
+func reassigning(p *structs.Partition) bool {
+	return len(p.AddingReplicas) != 0 || len(p.RemovingReplicas) != 0
+}
+
+// originalReplicas returns the replicas the partition had before it started being reassigned.
+func originalReplicas(p *structs.Partition) []int32 {
+	var ids []int32
+	for _, id := range p.AR {
+		if !containsInt32(p.AddingReplicas, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// validReplicas returns whether the replicas can be assigned: there's at least one, they're
+// registered brokers and none are repeated.
+func validReplicas(ids []int32, registered map[int32]bool) bool {
+	if len(ids) == 0 {
+		return false
+	}
+	for i, id := range ids {
+		if !registered[id] || indexOfInt32(ids[:i], id) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// addStopReplica adds the partition to the broker's StopReplica request, deleting its log.
+func addStopReplica(reqs map[int32]*protocol.StopReplicaRequest, controllerID, id int32, p *structs.Partition) {
+	req, ok := reqs[id]
+	if !ok {
+		req = &protocol.StopReplicaRequest{
+			ControllerID:     controllerID,
+			DeletePartitions: true,
+		}
+		reqs[id] = req
+	}
+	req.Partitions = append(req.Partitions, &protocol.StopReplicaPartition{
+		Topic:     p.Topic,
+		Partition: p.ID,
+	})
+}
@@ -0,0 +1,114 @@
+package jocko
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/commitlog"
+)
+
+// timedAppendLog is implemented by commit logs that can time their appends' writes and syncs.
+type timedAppendLog interface {
+	AppendTimed(b []byte) (int64, commitlog.AppendTiming, error)
+}
+
+// appendTimed appends the record set to the log, timing the append. Logs that can't time their
+// syncs have the whole append put down to writing.
+func appendTimed(l CommitLog, recordSet []byte) (int64, commitlog.AppendTiming, error) {
+	if tl, ok := l.(timedAppendLog); ok {
+		return tl.AppendTimed(recordSet)
+	}
+	start := time.Now()
+	offset, err := l.Append(recordSet)
+	return offset, commitlog.AppendTiming{Write: time.Since(start)}, err
+}
+
+// produceAppended records how long the produce to the partition waited to be handled and took
+// to append, if metrics are being tracked. queue is zero if it isn't known.
+func (m *Metrics) produceAppended(topic string, partition int32, queue time.Duration, timing commitlog.AppendTiming) {
+	if m == nil {
+		return
+	}
+	p := strconv.Itoa(int(partition))
+	if queue > 0 {
+		m.ProduceQueueTime.With("topic", topic, "partition", p).Observe(queue.Seconds())
+	}
+	m.ProduceAppendTime.With("topic", topic, "partition", p).Observe(timing.Write.Seconds())
+	if timing.Sync > 0 {
+		m.ProduceFlushTime.With("topic", topic, "partition", p).Observe(timing.Sync.Seconds())
+	}
+}
+
+// maxReplicationWaits bounds the batches timed per partition while they wait to be replicated,
+// the oldest are dropped past it, like when the followers have stopped fetching.
+const maxReplicationWaits = 1024
+
+// replicationWaits holds when acks=all batches were appended to the logs of the partitions this
+// broker leads until the partitions' high watermarks pass them, to time how long they wait on the
+// followers. The producers have been answered by then, see produceToPartition.
+type replicationWaits struct {
+	mu         sync.Mutex
+	metrics    *Metrics
+	partitions map[topicPartition][]replicationWait
+}
+
+type replicationWait struct {
+	// end is the offset after the batch's last record.
+	end      int64
+	appended time.Time
+}
+
+func newReplicationWaits(metrics *Metrics) *replicationWaits {
+	return &replicationWaits{
+		metrics:    metrics,
+		partitions: make(map[topicPartition][]replicationWait),
+	}
+}
+
+// add records the batch ending before end was appended to the partition at appended. Batches
+// aren't timed if metrics aren't being tracked.
+func (w *replicationWaits) add(topic string, partition int32, end int64, appended time.Time) {
+	if w.metrics == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	waits := w.partitions[key]
+	if len(waits) >= maxReplicationWaits {
+		waits = waits[1:]
+	}
+	w.partitions[key] = append(waits, replicationWait{end: end, appended: appended})
+}
+
+// committed records the partition's high watermark has reached hw, timing the batches it's
+// passed.
+func (w *replicationWaits) committed(topic string, partition int32, hw int64) {
+	if w.metrics == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	waits := w.partitions[key]
+	i := 0
+	for ; i < len(waits) && waits[i].end <= hw; i++ {
+		w.metrics.ProduceReplicationTime.With("topic", topic, "partition", strconv.Itoa(int(partition))).Observe(time.Since(waits[i].appended).Seconds())
+	}
+	if i == 0 {
+		return
+	}
+	if i == len(waits) {
+		delete(w.partitions, key)
+		return
+	}
+	w.partitions[key] = waits[i:]
+}
+
+// remove forgets the partition's batches once its replica's gone from this broker.
+func (w *replicationWaits) remove(topic string, partition int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.partitions, topicPartition{topic: topic, partition: partition})
+}
@@ -0,0 +1,39 @@
+package jocko
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicationWaits(t *testing.T) {
+	hv := stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{Name: "replication_wait_seconds"}, []string{"topic", "partition"})
+	w := newReplicationWaits(&Metrics{ProduceReplicationTime: prometheus.NewHistogram(hv)})
+	tp := topicPartition{topic: "the-topic", partition: 0}
+
+	appended := time.Now()
+	w.add("the-topic", 0, 2, appended)
+	w.add("the-topic", 0, 5, appended)
+	// the high watermark's passed the first batch but not the second
+	w.committed("the-topic", 0, 3)
+	require.Equal(t, []replicationWait{{end: 5, appended: appended}}, w.partitions[tp])
+	w.committed("the-topic", 0, 5)
+	require.NotContains(t, w.partitions, tp)
+
+	// the oldest batches are dropped once too many are waiting
+	for i := 0; i <= maxReplicationWaits; i++ {
+		w.add("the-topic", 0, int64(i+1), appended)
+	}
+	require.Len(t, w.partitions[tp], maxReplicationWaits)
+	require.Equal(t, int64(2), w.partitions[tp][0].end)
+	w.remove("the-topic", 0)
+	require.NotContains(t, w.partitions, tp)
+
+	// batches aren't timed without metrics
+	w = newReplicationWaits(nil)
+	w.add("the-topic", 0, 2, appended)
+	require.Empty(t, w.partitions)
+}
@@ -0,0 +1,70 @@
+package jocko
+
+import (
+	"sync"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// producerIDBlockSize is how many producer ids brokers are allocated at a time, like Kafka's.
+const producerIDBlockSize = 1000
+
+// producerIDs hands out the ids of the block of producer ids last allocated to the broker.
+type producerIDs struct {
+	mu    sync.Mutex
+	block *structs.ProducerIDBlock
+	next  int64
+}
+
+// nextProducerID returns a producer id no other producer has been given, allocating the broker
+// the next block of ids through raft once it's handed out all of its block's.
+func (b *Broker) nextProducerID() (int64, error) {
+	p := &b.producerIDs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.block == nil || p.next > p.block.Last {
+		res, err := b.raftApply(structs.AllocateProducerIDsRequestType, structs.AllocateProducerIDsRequest{
+			Broker: b.config.ID,
+			Size:   producerIDBlockSize,
+		})
+		if err != nil {
+			return 0, err
+		}
+		if err, ok := res.(error); ok {
+			return 0, err
+		}
+		p.block = res.(*structs.ProducerIDBlock)
+		p.next = p.block.First
+		b.logger.Info("allocated producer ids", log.Int64("first", p.block.First), log.Int64("last", p.block.Last))
+	}
+	id := p.next
+	p.next++
+	return id, nil
+}
+
+func (b *Broker) handleInitProducerID(ctx *Context, req *protocol.InitProducerIDRequest) *protocol.InitProducerIDResponse {
+	sp := span(ctx, b.tracer, "init producer id")
+	defer sp.Finish()
+	resp := &protocol.InitProducerIDResponse{ProducerID: -1, ProducerEpoch: -1}
+	resp.APIVersion = req.Version()
+	// transactional producers are given their ids by their transaction coordinators, which fence
+	// their old instances
+	if req.TransactionalID != nil && *req.TransactionalID != "" {
+		b.initTransactionalProducer(ctx, req, resp)
+		return resp
+	}
+	// idempotent producers get a new id each time, even those that send the one they have, so
+	// their sequence numbers start over without being checked against the old id's.
+	id, err := b.nextProducerID()
+	if err != nil {
+		b.logger.Error("failed to allocate producer id", log.Error("error", err))
+		// retriable, the controller the ids are allocated through may be changing
+		resp.ErrorCode = protocol.ErrCoordinatorNotAvailable.Code()
+		return resp
+	}
+	resp.ProducerID = id
+	resp.ProducerEpoch = 0
+	return resp
+}
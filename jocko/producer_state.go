@@ -0,0 +1,241 @@
+package jocko
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// producerState is what a partition's replica knows of an idempotent producer from the last
+// batch it appended.
+type producerState struct {
+	ProducerID    int64     `json:"producer_id"`
+	ProducerEpoch int16     `json:"producer_epoch"`
+	LastSequence  int32     `json:"last_sequence"`
+	LastOffset    int64     `json:"last_offset"`
+	LastTimestamp int64     `json:"last_timestamp"`
+	LastUpdate    time.Time `json:"last_update"`
+
+	// batches are the producer's last batches appended to the partition, oldest first, to answer
+	// retries of them without appending them again.
+	batches []producerBatch
+}
+
+// producerBatchesKept is how many of a producer's last batches are kept, enough for the most
+// requests a producer has in flight at once while idempotent.
+const producerBatchesKept = 5
+
+// producerBatch is a batch an idempotent producer appended to the partition.
+type producerBatch struct {
+	epoch         int16
+	firstSequence int32
+	lastSequence  int32
+	offset        int64
+}
+
+// duplicate returns the offset the batch was appended at if it's one of the producer's last
+// batches.
+func (p *producerState) duplicate(batch protocol.RecordBatchProducer) (int64, bool) {
+	for _, b := range p.batches {
+		if b.epoch == batch.ProducerEpoch && b.firstSequence == batch.BaseSequence && b.lastSequence == batch.LastSequence() {
+			return b.offset, true
+		}
+	}
+	return 0, false
+}
+
+// nextSequence returns the sequence number following seq, they wrap around to 0.
+func nextSequence(seq int32) int32 {
+	if seq == math.MaxInt32 {
+		return 0
+	}
+	return seq + 1
+}
+
+// topicPartition identifies a partition. It keys the state the broker keeps about its
+// partitions outside of their replicas, like their producers, followers and breakers, which has
+// to be kept apart since the replicas are replaced whenever the controller sends their state.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// producerStates holds the idempotent producer state of this broker's partitions, to check
+// the sequences of their batches and answer retries.
+type producerStates struct {
+	metrics *Metrics
+
+	mu         sync.Mutex
+	partitions map[topicPartition]map[int64]*producerState
+}
+
+func newProducerStates(metrics *Metrics) *producerStates {
+	return &producerStates{
+		metrics:    metrics,
+		partitions: make(map[topicPartition]map[int64]*producerState),
+	}
+}
+
+// update records the producers of the batches in the record set appended to the partition at
+// offset, whose batches have been given their offsets.
+func (ps *producerStates) update(topic string, partition int32, offset int64, recordSet []byte) {
+	batches := protocol.RecordBatchProducers(recordSet)
+	if len(batches) == 0 {
+		return
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	producers, ok := ps.partitions[key]
+	if !ok {
+		producers = make(map[int64]*producerState)
+		ps.partitions[key] = producers
+	}
+	now := time.Now()
+	for _, batch := range batches {
+		p := &producerState{
+			ProducerID:    batch.ProducerID,
+			ProducerEpoch: batch.ProducerEpoch,
+			LastSequence:  batch.LastSequence(),
+			LastOffset:    batch.BaseOffset + int64(batch.LastOffsetDelta),
+			LastTimestamp: batch.MaxTimestamp,
+			LastUpdate:    now,
+		}
+		if prev, ok := producers[batch.ProducerID]; ok && prev.ProducerEpoch == batch.ProducerEpoch {
+			p.batches = prev.batches
+			if len(p.batches) == producerBatchesKept {
+				p.batches = p.batches[1:]
+			}
+		}
+		p.batches = append(p.batches[:len(p.batches):len(p.batches)], producerBatch{
+			epoch:         batch.ProducerEpoch,
+			firstSequence: batch.BaseSequence,
+			lastSequence:  batch.LastSequence(),
+			offset:        offset,
+		})
+		producers[batch.ProducerID] = p
+	}
+	ps.setActive(key, len(producers))
+}
+
+// check checks the sequence numbers of the idempotent producers' batches in the record set
+// against the batches they've appended to the partition. Each batch must follow the producer's
+// last, or be the first of a new epoch, unless the producer has no state in the partition, like
+// after it's expired, when any sequence number is taken as its first. If every batch is a retry
+// of one already appended it returns the offset it was appended at, and -1 otherwise.
+func (ps *producerStates) check(topic string, partition int32, recordSet []byte) (int64, protocol.Error) {
+	batches := protocol.RecordBatchProducers(recordSet)
+	if len(batches) == 0 {
+		return -1, protocol.ErrNone
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	producers := ps.partitions[topicPartition{topic: topic, partition: partition}]
+	type last struct {
+		epoch    int16
+		sequence int32
+	}
+	// the last batch of each producer, including the ones earlier in the record set
+	lasts := make(map[int64]last)
+	duplicates, duplicateOffset := 0, int64(-1)
+	for _, batch := range batches {
+		l, ok := lasts[batch.ProducerID]
+		p, known := producers[batch.ProducerID]
+		if !ok && known {
+			if offset, dup := p.duplicate(batch); dup {
+				if duplicates == 0 {
+					duplicateOffset = offset
+				}
+				duplicates++
+				continue
+			}
+			l, ok = last{p.ProducerEpoch, p.LastSequence}, true
+		}
+		if ok {
+			switch {
+			case batch.ProducerEpoch < l.epoch:
+				return -1, protocol.ErrInvalidProducerEpoch
+			case batch.ProducerEpoch > l.epoch:
+				if batch.BaseSequence != 0 {
+					return -1, protocol.ErrOutOfOrderSequenceNumber
+				}
+			case batch.BaseSequence != nextSequence(l.sequence):
+				return -1, protocol.ErrOutOfOrderSequenceNumber
+			}
+		}
+		lasts[batch.ProducerID] = last{batch.ProducerEpoch, batch.LastSequence()}
+	}
+	switch duplicates {
+	case 0:
+		return -1, protocol.ErrNone
+	case len(batches):
+		return duplicateOffset, protocol.ErrNone
+	default:
+		// some of the batches were appended and some weren't, they can't be appended as a set
+		return -1, protocol.ErrDuplicateSequenceNumber
+	}
+}
+
+// get returns the producer's state in the partition, nil if it hasn't produced to it.
+func (ps *producerStates) get(topic string, partition int32, producerID int64) *producerState {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.partitions[topicPartition{topic: topic, partition: partition}][producerID]
+	if !ok {
+		return nil
+	}
+	state := *p
+	return &state
+}
+
+// describe returns the states of the partition's producers ordered by producer id.
+func (ps *producerStates) describe(topic string, partition int32) []producerState {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	producers := ps.partitions[topicPartition{topic: topic, partition: partition}]
+	states := make([]producerState, 0, len(producers))
+	for _, p := range producers {
+		states = append(states, *p)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ProducerID < states[j].ProducerID })
+	return states
+}
+
+// expire forgets the producer's state in the partition so its next batch is taken as its first,
+// which unsticks a producer whose sequence numbers no longer line up with the partition's. It
+// returns false if the producer hasn't produced to the partition.
+func (ps *producerStates) expire(topic string, partition int32, producerID int64) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	producers := ps.partitions[key]
+	if _, ok := producers[producerID]; !ok {
+		return false
+	}
+	delete(producers, producerID)
+	ps.setActive(key, len(producers))
+	if ps.metrics != nil {
+		ps.metrics.ProducerStateExpirations.With("topic", topic, "partition", strconv.Itoa(int(partition))).Add(1)
+	}
+	return true
+}
+
+// remove forgets the partition's producers once its replica's gone from this broker.
+func (ps *producerStates) remove(topic string, partition int32) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	delete(ps.partitions, key)
+	ps.metrics.removeProducerMetrics(topic, partition)
+}
+
+func (ps *producerStates) setActive(key topicPartition, n int) {
+	if ps.metrics == nil {
+		return
+	}
+	ps.metrics.ActiveProducers.With("topic", key.topic, "partition", strconv.Itoa(int(key.partition))).Set(float64(n))
+}
@@ -0,0 +1,334 @@
+package jocko
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// Dynamic broker configs, set with AlterConfigs, for client quotas. Quotas are set for themes,
+// e.g. quota.free.producer.byte.rate, and clients are assigned a theme by rules matching their
+// principal or client id, e.g. quota.rule.tenants.principal.prefix=tenant- with
+// quota.rule.tenants.theme=free, so new tenants get sane limits without quotas being set for each
+// of them. Clients no rule matches get the default theme's quotas, if it has any.
+const (
+	quotaConfigPrefix      = "quota."
+	quotaRuleConfigPrefix  = "quota.rule."
+	producerByteRateConfig = "producer.byte.rate"
+	consumerByteRateConfig = "consumer.byte.rate"
+	// A rule's matchers: the prefix of the principals and the path.Match pattern of the client
+	// ids it matches. Both have to match if both are set.
+	quotaRulePrincipalPrefixConfig = "principal.prefix"
+	quotaRuleClientIDConfig        = "client.id"
+	quotaRuleThemeConfig           = "theme"
+	defaultQuotaTheme              = "default"
+)
+
+const (
+	// quotaSampleInterval and quotaSamples are the windows clients' byte rates are measured
+	// over, quotaSamples of quotaSampleInterval each.
+	quotaSampleInterval = time.Second
+	quotaSamples        = 11
+)
+
+// clientQuota is the byte rates a client can produce and fetch at, zero if it's unlimited.
+type clientQuota struct {
+	ProducerByteRate int64 `json:"producer_byte_rate"`
+	ConsumerByteRate int64 `json:"consumer_byte_rate"`
+}
+
+// quotaRule assigns the clients it matches a quota theme.
+type quotaRule struct {
+	name            string
+	principalPrefix string
+	clientID        string
+	theme           string
+}
+
+func (r quotaRule) matches(principal, clientID string) bool {
+	if r.principalPrefix != "" && !strings.HasPrefix(principal, r.principalPrefix) {
+		return false
+	}
+	if r.clientID != "" {
+		if ok, _ := path.Match(r.clientID, clientID); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// quotaConfig is the parsed quota configs. It's cached as the configs change, rather than looked
+// up for each request.
+type quotaConfig struct {
+	themes map[string]clientQuota
+	// rules are tried in the order of their names, the first that matches assigns the theme.
+	rules []quotaRule
+}
+
+// quota returns the theme the client's assigned and its quotas.
+func (c *quotaConfig) quota(principal, clientID string) (string, clientQuota) {
+	for _, r := range c.rules {
+		if r.matches(principal, clientID) {
+			return r.theme, c.themes[r.theme]
+		}
+	}
+	return defaultQuotaTheme, c.themes[defaultQuotaTheme]
+}
+
+// parseQuotaConfigName returns the theme and quota of quota.<theme>.<quota> configs, or the rule
+// and field of quota.rule.<rule>.<field> configs. It returns false for other configs.
+func parseQuotaConfigName(name string) (theme, rule, field string, ok bool) {
+	if s := strings.TrimPrefix(name, quotaRuleConfigPrefix); s != name {
+		for _, f := range []string{quotaRulePrincipalPrefixConfig, quotaRuleClientIDConfig, quotaRuleThemeConfig} {
+			if r := strings.TrimSuffix(s, "."+f); r != s && r != "" {
+				return "", r, f, true
+			}
+		}
+		return "", "", "", false
+	}
+	if s := strings.TrimPrefix(name, quotaConfigPrefix); s != name {
+		for _, f := range []string{producerByteRateConfig, consumerByteRateConfig} {
+			if t := strings.TrimSuffix(s, "."+f); t != s && t != "" {
+				return t, "", f, true
+			}
+		}
+	}
+	return "", "", "", false
+}
+
+// validateQuotaConfig checks the quota config's value parses, it returns false if the config
+// isn't a quota config.
+func validateQuotaConfig(name, value string) (protocol.Error, bool) {
+	theme, _, field, ok := parseQuotaConfigName(name)
+	if !ok {
+		return protocol.ErrNone, false
+	}
+	if theme != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err != nil || n <= 0 {
+			return protocol.ErrInvalidConfig, true
+		}
+		return protocol.ErrNone, true
+	}
+	switch field {
+	case quotaRuleClientIDConfig:
+		if _, err := path.Match(value, ""); err != nil {
+			return protocol.ErrInvalidConfig.WithErr(err), true
+		}
+	case quotaRuleThemeConfig:
+		if value == "" {
+			return protocol.ErrInvalidConfig, true
+		}
+	}
+	return protocol.ErrNone, true
+}
+
+// parseQuotaConfig parses the quota configs out of the dynamic broker configs. Rules without a
+// theme are ignored.
+func parseQuotaConfig(entries map[string]string) *quotaConfig {
+	c := &quotaConfig{themes: make(map[string]clientQuota)}
+	rules := make(map[string]*quotaRule)
+	for name, value := range entries {
+		theme, rule, field, ok := parseQuotaConfigName(name)
+		if !ok {
+			continue
+		}
+		if theme != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				continue
+			}
+			q := c.themes[theme]
+			if field == producerByteRateConfig {
+				q.ProducerByteRate = n
+			} else {
+				q.ConsumerByteRate = n
+			}
+			c.themes[theme] = q
+			continue
+		}
+		r, ok := rules[rule]
+		if !ok {
+			r = &quotaRule{name: rule}
+			rules[rule] = r
+		}
+		switch field {
+		case quotaRulePrincipalPrefixConfig:
+			r.principalPrefix = value
+		case quotaRuleClientIDConfig:
+			r.clientID = value
+		case quotaRuleThemeConfig:
+			r.theme = value
+		}
+	}
+	for _, r := range rules {
+		if r.theme != "" {
+			c.rules = append(c.rules, *r)
+		}
+	}
+	sort.Slice(c.rules, func(i, j int) bool { return c.rules[i].name < c.rules[j].name })
+	return c
+}
+
+// loadQuotaConfig caches the broker's quota configs, those set for this broker overriding those
+// set for all brokers.
+func (b *Broker) loadQuotaConfig() {
+	entries := b.configEntries(structs.BrokerConfigResource, "")
+	for k, v := range b.configEntries(structs.BrokerConfigResource, strconv.Itoa(int(b.config.ID))) {
+		entries[k] = v
+	}
+	b.quotaConfig.Store(parseQuotaConfig(entries))
+}
+
+// clientQuota returns the theme the request's client is assigned and its quotas.
+func (b *Broker) clientQuota(ctx *Context) (string, clientQuota) {
+	qc, ok := b.quotaConfig.Load().(*quotaConfig)
+	if !ok {
+		return defaultQuotaTheme, clientQuota{}
+	}
+	var clientID string
+	if ctx.header != nil {
+		clientID = ctx.header.ClientID
+	}
+	return qc.quota(requestPrincipal(ctx).name, clientID)
+}
+
+// throttleProduce records the bytes the request's client produced and returns how long it should
+// wait before its next request to keep within its quota.
+func (b *Broker) throttleProduce(ctx *Context, req *protocol.ProduceRequest) time.Duration {
+	_, q := b.clientQuota(ctx)
+	if q.ProducerByteRate <= 0 {
+		return 0
+	}
+	var n int64
+	for _, td := range req.TopicData {
+		for _, d := range td.Data {
+			n += int64(len(d.RecordSet))
+		}
+	}
+	return b.clientRates.record(ctx, true, n, q.ProducerByteRate, time.Now())
+}
+
+// throttleFetch records the bytes the consumer fetched and returns how long it should wait before
+// its next request to keep within its quota.
+func (b *Broker) throttleFetch(ctx *Context, resp *protocol.FetchResponse) time.Duration {
+	_, q := b.clientQuota(ctx)
+	if q.ConsumerByteRate <= 0 {
+		return 0
+	}
+	var n int64
+	for _, t := range resp.Responses {
+		for _, p := range t.PartitionResponses {
+			n += int64(len(p.RecordSet))
+		}
+	}
+	return b.clientRates.record(ctx, false, n, q.ConsumerByteRate, time.Now())
+}
+
+// clientRates measures the byte rates clients produce and fetch at.
+type clientRates struct {
+	mu    sync.Mutex
+	rates map[clientRateKey]*byteRate
+	// pruned is when rates of clients that have gone quiet were last dropped.
+	pruned time.Time
+}
+
+type clientRateKey struct {
+	principal string
+	clientID  string
+	produce   bool
+}
+
+// byteRate is the bytes counted in each of the last samples, by the sample's number.
+type byteRate struct {
+	samples [quotaSamples]int64
+	numbers [quotaSamples]int64
+	last    int64
+}
+
+func newClientRates() *clientRates {
+	return &clientRates{rates: make(map[clientRateKey]*byteRate)}
+}
+
+// record counts the bytes against the request's client and returns how long the client has to
+// wait for its rate over the last samples to come down to the limit, in bytes a second. Clients
+// are trusted to wait, like Kafka clients do with the response's throttle time.
+func (c *clientRates) record(ctx *Context, produce bool, n, limit int64, now time.Time) time.Duration {
+	key := clientRateKey{principal: requestPrincipal(ctx).name, produce: produce}
+	if ctx.header != nil {
+		key.clientID = ctx.header.ClientID
+	}
+	number := now.UnixNano() / int64(quotaSampleInterval)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(number, now)
+	r, ok := c.rates[key]
+	if !ok {
+		r = &byteRate{}
+		c.rates[key] = r
+	}
+	i := number % quotaSamples
+	if r.numbers[i] != number {
+		r.numbers[i] = number
+		r.samples[i] = 0
+	}
+	r.samples[i] += n
+	r.last = number
+	var total int64
+	for j, s := range r.samples {
+		if number-r.numbers[j] < quotaSamples {
+			total += s
+		}
+	}
+	window := quotaSamples * quotaSampleInterval
+	over := total - limit*int64(window/time.Second)
+	if over <= 0 {
+		return 0
+	}
+	throttle := time.Duration(float64(over) / float64(limit) * float64(time.Second))
+	if throttle > window {
+		throttle = window
+	}
+	return throttle
+}
+
+// prune drops the rates of clients that haven't produced or fetched for a window, once a
+// window. c.mu must be held.
+func (c *clientRates) prune(number int64, now time.Time) {
+	if now.Sub(c.pruned) < quotaSamples*quotaSampleInterval {
+		return
+	}
+	c.pruned = now
+	for k, r := range c.rates {
+		if number-r.last >= quotaSamples {
+			delete(c.rates, k)
+		}
+	}
+}
+
+type adminQuotaResponse struct {
+	Theme string      `json:"theme"`
+	Quota clientQuota `json:"quota"`
+}
+
+// adminQuotas returns the quota theme the client with the principal and client id is assigned
+// by the quota rules, and the theme's quotas, zero for quotas that aren't set.
+//
+//	GET /v1/quotas?principal=<principal>&client_id=<client id>
+func (b *Broker) adminQuotas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	ctx := &Context{parent: withPrincipal(r.Context(), q.Get("principal"), ""), header: &protocol.RequestHeader{ClientID: q.Get("client_id")}}
+	theme, quota := b.clientQuota(ctx)
+	writeAdminJSON(w, adminQuotaResponse{Theme: theme, Quota: quota})
+}
@@ -0,0 +1,114 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestParseQuotaConfig(t *testing.T) {
+	c := parseQuotaConfig(map[string]string{
+		"quota.default.producer.byte.rate":        "1024",
+		"quota.free.producer.byte.rate":           "100",
+		"quota.free.consumer.byte.rate":           "200",
+		"quota.paid.tier.consumer.byte.rate":      "5000",
+		"quota.rule.b-tenants.principal.prefix":   "tenant-",
+		"quota.rule.b-tenants.theme":              "free",
+		"quota.rule.a-paid.principal.prefix":      "tenant-",
+		"quota.rule.a-paid.client.id":             "paid-*",
+		"quota.rule.a-paid.theme":                 "paid.tier",
+		"quota.rule.no-theme.principal.prefix":    "",
+		"num.partitions":                          "3",
+		"quota.rule.b-tenants.producer.byte.rate": "1",
+	})
+	require.Equal(t, map[string]clientQuota{
+		"default":   {ProducerByteRate: 1024},
+		"free":      {ProducerByteRate: 100, ConsumerByteRate: 200},
+		"paid.tier": {ConsumerByteRate: 5000},
+	}, c.themes)
+
+	// rules are tried in the order of their names
+	theme, q := c.quota("tenant-1", "paid-app")
+	require.Equal(t, "paid.tier", theme)
+	require.Equal(t, clientQuota{ConsumerByteRate: 5000}, q)
+	theme, q = c.quota("tenant-1", "free-app")
+	require.Equal(t, "free", theme)
+	require.Equal(t, clientQuota{ProducerByteRate: 100, ConsumerByteRate: 200}, q)
+	// clients no rule matches get the default theme's quotas
+	theme, q = c.quota("admin", "paid-app")
+	require.Equal(t, defaultQuotaTheme, theme)
+	require.Equal(t, clientQuota{ProducerByteRate: 1024}, q)
+}
+
+func TestValidateQuotaConfig(t *testing.T) {
+	for name, value := range map[string]string{
+		"quota.free.producer.byte.rate":       "100",
+		"quota.rule.tenants.client.id":        "tenant-*",
+		"quota.rule.tenants.theme":            "free",
+		"quota.rule.tenants.principal.prefix": "",
+	} {
+		require.Equal(t, protocol.ErrNone, validateBrokerConfig(name, value), name)
+	}
+	for name, value := range map[string]string{
+		"quota.free.producer.byte.rate": "0",
+		"quota.free.consumer.byte.rate": "lots",
+		"quota.producer.byte.rate":      "100",
+		"quota.rule.tenants.client.id":  "tenant-[",
+		"quota.rule.tenants.theme":      "",
+		"quota.rule.tenants.other":      "x",
+		"quota.rule.principal.prefix":   "tenant-",
+	} {
+		require.NotEqual(t, protocol.ErrNone.Code(), validateBrokerConfig(name, value).Code(), name)
+	}
+}
+
+func TestClientRates(t *testing.T) {
+	c := newClientRates()
+	ctx := &Context{parent: context.Background(), header: &protocol.RequestHeader{ClientID: "the-client"}}
+	now := time.Unix(1000, 0)
+	// the client can use up its rate over the window before it's throttled
+	require.Equal(t, time.Duration(0), c.record(ctx, true, 100*quotaSamples, 100, now))
+	require.Equal(t, 2*time.Second, c.record(ctx, true, 200, 100, now.Add(time.Second)))
+	// produces and fetches are measured apart
+	require.Equal(t, time.Duration(0), c.record(ctx, false, 200, 100, now.Add(time.Second)))
+	// bytes stop counting once their samples are out of the window
+	require.Equal(t, time.Duration(0), c.record(ctx, true, 0, 100, now.Add(quotaSamples*quotaSampleInterval)))
+
+	// the throttle's capped at the window
+	require.Equal(t, quotaSamples*quotaSampleInterval, c.record(ctx, true, 100*quotaSamples*10, 100, now.Add(time.Hour)))
+}
+
+func TestBroker_Quotas(t *testing.T) {
+	b, teardown := newTestLeader(t, nil)
+	defer teardown()
+	ctx := &Context{parent: withPrincipal(context.Background(), "tenant-1", ""), header: &protocol.RequestHeader{ClientID: "the-client"}}
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	produce := func() *protocol.ProduceResponse {
+		return b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{RecordSet: testRecordBatch(-1, -1, -1, 0)}},
+		}}})
+	}
+	require.Equal(t, time.Duration(0), produce().ThrottleTime)
+
+	// new tenants are assigned the theme's quota by the rule
+	require.Equal(t, protocol.ErrNone, b.alterBrokerConfigs("", []protocol.AlterConfigsEntry{
+		{Name: "quota.free.producer.byte.rate", Value: strPtr("1")},
+		{Name: "quota.rule.tenants.principal.prefix", Value: strPtr("tenant-")},
+		{Name: "quota.rule.tenants.theme", Value: strPtr("free")},
+	}, false))
+	theme, q := b.clientQuota(ctx)
+	require.Equal(t, "free", theme)
+	require.Equal(t, clientQuota{ProducerByteRate: 1}, q)
+	resp := produce()
+	require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	require.True(t, resp.ThrottleTime > 0)
+}
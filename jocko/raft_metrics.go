@@ -0,0 +1,160 @@
+package jocko
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/travisjeffery/jocko/jocko/metadata"
+)
+
+// timedFSM wraps the broker's FSM to time applying the raft log's entries to it and snapshotting
+// and restoring it.
+type timedFSM struct {
+	raft.FSM
+	metrics *Metrics
+}
+
+func (f *timedFSM) Apply(l *raft.Log) interface{} {
+	start := time.Now()
+	defer func() {
+		f.metrics.RaftFSMApplyTime.Observe(time.Since(start).Seconds())
+	}()
+	return f.FSM.Apply(l)
+}
+
+func (f *timedFSM) Snapshot() (raft.FSMSnapshot, error) {
+	start := time.Now()
+	snap, err := f.FSM.Snapshot()
+	f.metrics.RaftSnapshotTime.With("phase", "create").Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return &timedSnapshot{FSMSnapshot: snap, metrics: f.metrics}, nil
+}
+
+func (f *timedFSM) Restore(rc io.ReadCloser) error {
+	start := time.Now()
+	defer func() {
+		f.metrics.RaftSnapshotTime.With("phase", "restore").Observe(time.Since(start).Seconds())
+	}()
+	return f.FSM.Restore(rc)
+}
+
+// timedSnapshot times writing the FSM's snapshot out to the snapshot store.
+type timedSnapshot struct {
+	raft.FSMSnapshot
+	metrics *Metrics
+}
+
+func (s *timedSnapshot) Persist(sink raft.SnapshotSink) error {
+	start := time.Now()
+	defer func() {
+		s.metrics.RaftSnapshotTime.With("phase", "persist").Observe(time.Since(start).Seconds())
+	}()
+	return s.FSMSnapshot.Persist(sink)
+}
+
+// raftFSM returns the FSM the broker's raft applies its log to, timed if metrics are tracked.
+func (b *Broker) raftFSM() raft.FSM {
+	if b.metrics == nil {
+		return b.fsm
+	}
+	return &timedFSM{FSM: b.fsm, metrics: b.metrics}
+}
+
+// raftCommitted records how long the raft log entry took from being applied to being committed
+// and applied to the FSM, if metrics are tracked.
+func (b *Broker) raftCommitted(start time.Time) {
+	if b.metrics != nil {
+		b.metrics.RaftCommitLatency.Observe(time.Since(start).Seconds())
+	}
+}
+
+// raftLeaderChanged counts the change of the cluster's leader.
+func (b *Broker) raftLeaderChanged() {
+	atomic.AddInt64(&b.raftLeaderChanges, 1)
+	if b.metrics != nil {
+		b.metrics.RaftLeaderChanges.Add(1)
+	}
+}
+
+// raftLastContact returns how long it's been since the broker last heard from the cluster's
+// leader, 0 if it's the leader or has never heard from one.
+func (b *Broker) raftLastContact() time.Duration {
+	if b.raft.State() == raft.Leader {
+		return 0
+	}
+	lastContact := b.raft.LastContact()
+	if lastContact.IsZero() {
+		return 0
+	}
+	return time.Since(lastContact)
+}
+
+// raftServer is a server in the raft cluster's configuration and the health of its broker.
+type raftServer struct {
+	ID       int32  `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader"`
+	// Status is the broker's serf member status, like alive or failed, "unknown" if it isn't a
+	// member.
+	Status string `json:"status"`
+}
+
+// adminRaft describes the broker's view of the raft cluster to diagnose an unstable controller:
+// its raft state, the leader, how long since it heard from it and how many times it's changed,
+// raft's stats, and the servers in the cluster's configuration with the health of their brokers.
+//
+//	GET /v1/raft
+func (b *Broker) adminRaft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	future := b.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := make(map[int32]string)
+	for _, m := range b.LANMembers() {
+		if meta, ok := metadata.IsBroker(m); ok {
+			status[meta.ID.Int32()] = m.Status.String()
+		}
+	}
+	leader := b.raft.Leader()
+	servers := []raftServer{}
+	for _, s := range future.Configuration().Servers {
+		server := raftServer{
+			ID:       raftServerBrokerID(s.ID),
+			Address:  string(s.Address),
+			Suffrage: s.Suffrage.String(),
+			Leader:   s.Address == leader,
+			Status:   "unknown",
+		}
+		if st, ok := status[server.ID]; ok {
+			server.Status = st
+		}
+		servers = append(servers, server)
+	}
+	writeAdminJSON(w, struct {
+		State         string            `json:"state"`
+		Leader        RaftPeer          `json:"leader"`
+		LastContact   string            `json:"last_contact"`
+		LeaderChanges int64             `json:"leader_changes"`
+		Servers       []raftServer      `json:"servers"`
+		Stats         map[string]string `json:"stats"`
+	}{
+		State:         b.raft.State().String(),
+		Leader:        b.raftPeer(leader),
+		LastContact:   b.raftLastContact().String(),
+		LeaderChanges: atomic.LoadInt64(&b.raftLeaderChanges),
+		Servers:       servers,
+		Stats:         b.raft.Stats(),
+	})
+}
@@ -0,0 +1,228 @@
+package jocko
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/raft"
+	"github.com/travisjeffery/jocko/log"
+)
+
+// raftObservationsBuffer is how many raft observations are buffered for the broker's observers,
+// raft drops observations rather than blocking on them once it's full.
+const raftObservationsBuffer = 64
+
+// RaftObservationType is the type of a RaftObservation.
+type RaftObservationType int
+
+const (
+	// RaftLeaderChanged is observed when the cluster's leader changes, including when it's lost
+	// one and Leader's ID is -1.
+	RaftLeaderChanged RaftObservationType = iota
+	// RaftPeerAdded and RaftPeerRemoved are observed by the leader when it adds or removes the
+	// broker Peer from the cluster's configuration, and when it starts or stops replicating to
+	// it on becoming or losing leadership.
+	RaftPeerAdded
+	RaftPeerRemoved
+	// RaftHeartbeatFailed is observed by followers that haven't heard from the leader Peer in
+	// the raft heartbeat timeout, LastContact being when they last did. It's observed once each
+	// time the leader's heartbeats lapse.
+	RaftHeartbeatFailed
+)
+
+func (t RaftObservationType) String() string {
+	switch t {
+	case RaftLeaderChanged:
+		return "leader changed"
+	case RaftPeerAdded:
+		return "peer added"
+	case RaftPeerRemoved:
+		return "peer removed"
+	case RaftHeartbeatFailed:
+		return "heartbeat failed"
+	}
+	return fmt.Sprintf("RaftObservationType(%d)", int(t))
+}
+
+// RaftPeer is a broker in the raft cluster.
+type RaftPeer struct {
+	// ID is the broker's ID, -1 if there's no broker.
+	ID      int32
+	Address string
+}
+
+// RaftObservation is a change to the raft cluster the broker's part of.
+type RaftObservation struct {
+	Type RaftObservationType
+	// Leader is the cluster's leader, set for all observations.
+	Leader RaftPeer
+	// Peer is the broker that was added or removed, or whose heartbeats failed.
+	Peer        RaftPeer
+	LastContact time.Time
+}
+
+// RaftObserver is called with the changes to the broker's raft cluster, so applications
+// embedding the broker can build their own HA logic on the cluster's consensus, like running a
+// singleton task on the controller. Observers are called one at a time in the order the changes
+// happened, and should be quick since changes are dropped while they're being called once the
+// broker's buffer of them is full.
+type RaftObserver interface {
+	OnRaftObservation(o RaftObservation)
+}
+
+// RaftObserverFunc adapts a function to a RaftObserver.
+type RaftObserverFunc func(o RaftObservation)
+
+func (f RaftObserverFunc) OnRaftObservation(o RaftObservation) {
+	f(o)
+}
+
+// raftObservers holds the broker's registered raft observers.
+type raftObservers struct {
+	mu        sync.Mutex
+	next      int
+	observers map[int]RaftObserver
+}
+
+// RegisterRaftObserver registers the observer for the broker's raft changes and returns a func
+// deregistering it. Observers aren't called with the changes made before they're registered,
+// RaftLeader returns the cluster's current leader.
+func (b *Broker) RegisterRaftObserver(o RaftObserver) (deregister func()) {
+	obs := &b.raftObservers
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if obs.observers == nil {
+		obs.observers = make(map[int]RaftObserver)
+	}
+	id := obs.next
+	obs.next++
+	obs.observers[id] = o
+	return func() {
+		obs.mu.Lock()
+		defer obs.mu.Unlock()
+		delete(obs.observers, id)
+	}
+}
+
+// RaftLeader returns the raft cluster's current leader, the controller.
+func (b *Broker) RaftLeader() RaftPeer {
+	return b.raftPeer(b.raft.Leader())
+}
+
+// observeRaft translates raft's observations and the broker's failed heartbeats from the leader
+// for the broker's observers until it's shut down.
+func (b *Broker) observeRaft(observations <-chan raft.Observation) {
+	heartbeatTimeout := b.config.RaftConfig.HeartbeatTimeout
+	ticker := time.NewTicker(heartbeatTimeout / 2)
+	defer ticker.Stop()
+	leader := b.RaftLeader()
+	// a leader elected before raft's observer was registered, like a broker started as the
+	// leader, has no observation so it's counted here
+	if leader.Address != "" {
+		b.raftLeaderChanged()
+	}
+	// lastLeader is the leader the broker last had, whose heartbeats fail once it's lost it
+	lastLeader := leader
+	var reportedContact time.Time
+	for {
+		select {
+		case <-b.shutdownCh:
+			return
+		case o := <-observations:
+			switch data := o.Data.(type) {
+			case raft.LeaderObservation:
+				// the observation's leader isn't exported, raft's set it as its leader by now
+				current := b.raftPeer(b.raft.Leader())
+				if current == leader {
+					continue
+				}
+				leader = current
+				if leader.Address != "" {
+					lastLeader = leader
+				}
+				b.raftLeaderChanged()
+				b.notifyRaftObservers(RaftObservation{Type: RaftLeaderChanged, Leader: leader})
+			case raft.PeerObservation:
+				typ := RaftPeerAdded
+				if data.Removed {
+					typ = RaftPeerRemoved
+				}
+				b.notifyRaftObservers(RaftObservation{
+					Type:   typ,
+					Leader: leader,
+					Peer:   RaftPeer{ID: raftServerBrokerID(data.Peer.ID), Address: string(data.Peer.Address)},
+				})
+			}
+		case <-ticker.C:
+			if b.metrics != nil {
+				b.metrics.RaftLastContact.Set(b.raftLastContact().Seconds())
+			}
+			// followers become candidates once the leader's heartbeats lapse
+			if state := b.raft.State(); state == raft.Leader || state == raft.Shutdown {
+				continue
+			}
+			lastContact := b.raft.LastContact()
+			if lastContact.IsZero() || time.Since(lastContact) < heartbeatTimeout || !lastContact.After(reportedContact) {
+				continue
+			}
+			reportedContact = lastContact
+			b.notifyRaftObservers(RaftObservation{
+				Type:        RaftHeartbeatFailed,
+				Leader:      leader,
+				Peer:        lastLeader,
+				LastContact: lastContact,
+			})
+		}
+	}
+}
+
+// notifyRaftObservers calls the broker's raft observers with the observation. An observer that
+// panics is logged rather than stopping the others being called.
+func (b *Broker) notifyRaftObservers(o RaftObservation) {
+	obs := &b.raftObservers
+	obs.mu.Lock()
+	observers := make([]RaftObserver, 0, len(obs.observers))
+	for id := 0; id < obs.next; id++ {
+		if observer, ok := obs.observers[id]; ok {
+			observers = append(observers, observer)
+		}
+	}
+	obs.mu.Unlock()
+	for _, observer := range observers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					b.logger.Error("raft observer panicked", log.String("observation", o.Type.String()), log.Error("error", fmt.Errorf("%v", r)))
+				}
+			}()
+			observer.OnRaftObservation(o)
+		}()
+	}
+}
+
+// raftPeer returns the broker with the raft address, its ID being -1 if there's no address or
+// the broker's unknown.
+func (b *Broker) raftPeer(addr raft.ServerAddress) RaftPeer {
+	peer := RaftPeer{ID: -1, Address: string(addr)}
+	if addr == "" {
+		return peer
+	}
+	if broker := b.brokerLookup.BrokerByAddr(addr); broker != nil {
+		peer.ID = broker.ID.Int32()
+	} else if addr == raft.ServerAddress(b.config.RaftAddr) {
+		peer.ID = b.config.ID
+	}
+	return peer
+}
+
+// raftServerBrokerID returns the ID of the broker with the raft server ID. Brokers' server IDs
+// are their IDs converted to strings as runes.
+func raftServerBrokerID(id raft.ServerID) int32 {
+	r, size := utf8.DecodeRuneInString(string(id))
+	if size == 0 {
+		return -1
+	}
+	return int32(r)
+}
@@ -0,0 +1,108 @@
+package jocko
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/testutil/retry"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+)
+
+// testRaftObserver records the observations it's called with.
+type testRaftObserver struct {
+	mu           sync.Mutex
+	observations []RaftObservation
+}
+
+func (o *testRaftObserver) OnRaftObservation(obs RaftObservation) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.observations = append(o.observations, obs)
+}
+
+// find returns the last observation of the type, or false if there's none.
+func (o *testRaftObserver) find(typ RaftObservationType) (RaftObservation, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := len(o.observations) - 1; i >= 0; i-- {
+		if o.observations[i].Type == typ {
+			return o.observations[i], true
+		}
+	}
+	return RaftObservation{}, false
+}
+
+func TestBroker_RaftObservers(t *testing.T) {
+	s1, t1 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	b1 := s1.broker()
+	defer t1()
+	defer b1.Shutdown()
+	retry.Run(t, func(r *retry.R) {
+		if b1.raft.State() != raft.Leader {
+			r.Fatal("not leader")
+		}
+	})
+	require.Equal(t, RaftPeer{ID: b1.config.ID, Address: b1.config.RaftAddr}, b1.RaftLeader())
+	o1 := new(testRaftObserver)
+	b1.RegisterRaftObserver(o1)
+	// panicking observers don't stop the others being called
+	b1.RegisterRaftObserver(RaftObserverFunc(func(RaftObservation) { panic("observer panicked") }))
+
+	s2, t2 := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = false
+	}, nil)
+	b2 := s2.broker()
+	defer t2()
+	defer b2.Shutdown()
+	o2 := new(testRaftObserver)
+	b2.RegisterRaftObserver(o2)
+	deregistered := new(testRaftObserver)
+	b2.RegisterRaftObserver(deregistered)()
+
+	joinLAN(t, b2, b1)
+
+	retry.Run(t, func(r *retry.R) {
+		o, ok := o1.find(RaftPeerAdded)
+		if !ok {
+			r.Fatal("peer not added")
+		}
+		require.Equal(t, b2.config.ID, o.Peer.ID)
+		require.Equal(t, b2.config.RaftAddr, o.Peer.Address)
+		require.Equal(t, b1.config.ID, o.Leader.ID)
+	})
+	retry.Run(t, func(r *retry.R) {
+		o, ok := o2.find(RaftLeaderChanged)
+		if !ok {
+			r.Fatal("leader not observed")
+		}
+		require.Equal(t, b1.config.RaftAddr, o.Leader.Address)
+		// there's only contact to report once the follower's taken the leader's entries
+		if b2.raft.LastContact().IsZero() {
+			r.Fatal("leader not contacted")
+		}
+	})
+
+	// the follower observes the leader's heartbeats failing once it's gone
+	b1.Shutdown()
+	retry.Run(t, func(r *retry.R) {
+		o, ok := o2.find(RaftHeartbeatFailed)
+		if !ok {
+			r.Fatal("heartbeat failure not observed")
+		}
+		require.Equal(t, b1.config.RaftAddr, o.Peer.Address)
+		require.False(t, o.LastContact.IsZero())
+	})
+	require.Empty(t, deregistered.observations)
+}
+
+func TestRaftServerBrokerID(t *testing.T) {
+	require.Equal(t, int32(1), raftServerBrokerID(raft.ServerID(int32(1))))
+	require.Equal(t, int32(1000), raftServerBrokerID(raft.ServerID(int32(1000))))
+	require.Equal(t, int32(-1), raftServerBrokerID(""))
+}
@@ -0,0 +1,54 @@
+package jocko
+
+import (
+	"strconv"
+
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// readOnlyConfig is the dynamic config, set for all brokers through the admin API, that puts the
+// cluster in read-only mode for maintenance like migrations or before cutting over to another
+// cluster. Produces, deleting records and changes to topics, configs and groups are rejected.
+// Fetches, and consumers committing their offsets, are still served.
+const readOnlyConfig = "read.only"
+
+// errReadOnly is returned for requests rejected in read-only mode. It's retriable so clients back
+// off and retry until the maintenance is done rather than failing.
+var errReadOnly = protocol.ErrNotEnoughReplicas
+
+// readOnly returns whether the cluster is in read-only mode. It's read from the state store, rather
+// than cached as the config changes, so brokers restored from a snapshot see it too.
+func (b *Broker) readOnly() bool {
+	_, config, err := b.fsm.State().GetConfig(structs.BrokerConfigResource, "")
+	if err != nil || config == nil {
+		return false
+	}
+	readOnly, _ := strconv.ParseBool(config.Entries[readOnlyConfig])
+	return readOnly
+}
+
+// setReadOnly turns read-only mode on or off for the cluster, keeping the other dynamic configs
+// set for all brokers.
+func (b *Broker) setReadOnly(readOnly bool) error {
+	_, current, err := b.fsm.State().GetConfig(structs.BrokerConfigResource, "")
+	if err != nil {
+		return err
+	}
+	config := structs.Config{
+		ResourceType: structs.BrokerConfigResource,
+		Entries:      make(map[string]string),
+	}
+	if current != nil {
+		for k, v := range current.Entries {
+			config.Entries[k] = v
+		}
+	}
+	if readOnly {
+		config.Entries[readOnlyConfig] = "true"
+	} else {
+		delete(config.Entries, readOnlyConfig)
+	}
+	_, err = b.raftApply(structs.RegisterConfigRequestType, structs.RegisterConfigRequest{Config: config})
+	return err
+}
@@ -0,0 +1,222 @@
+package jocko
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// replicaFetcherIdleInterval is how long a fetcher without partitions ready to fetch waits
+// before checking again, unless it's woken by one getting ready.
+const replicaFetcherIdleInterval = 100 * time.Millisecond
+
+// replicaFetcherConfig configures the fetches of a broker's replica fetchers.
+type replicaFetcherConfig struct {
+	MinBytes int32
+	// MaxWaitTime is how long, in milliseconds, the leader waits for messages to fetch before
+	// answering a fetch without them.
+	MaxWaitTime int32
+	// Backoff is how long a fetcher waits to fetch again after a fetch failed, doubled each
+	// time its fetches fail in a row up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// replicaFetchers runs a replica fetcher for each leader the broker follows partitions from,
+// which fetches all of them in the same requests over one connection, rather than each partition
+// taking its own connection and fetches.
+type replicaFetchers struct {
+	mu       sync.Mutex
+	fetchers map[int32]*replicaFetcher
+	config   replicaFetcherConfig
+	// dial connects to the leader with the id.
+	dial   func(leader int32) (client, error)
+	logger log.Logger
+}
+
+func newReplicaFetchers(config replicaFetcherConfig, dial func(leader int32) (client, error), logger log.Logger) *replicaFetchers {
+	return &replicaFetchers{
+		fetchers: make(map[int32]*replicaFetcher),
+		config:   config,
+		dial:     dial,
+		logger:   logger,
+	}
+}
+
+// add has the fetcher for the leader fetch the replicator's partition, starting the fetcher if
+// it's the first partition the broker follows from the leader.
+func (m *replicaFetchers) add(leader int32, r *Replicator) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.fetchers[leader]
+	if !ok {
+		f = &replicaFetcher{
+			leader:     leader,
+			fetchers:   m,
+			partitions: make(map[topicPartition]*Replicator),
+			wake:       make(chan struct{}, 1),
+			done:       make(chan struct{}),
+			logger:     m.logger.With(log.Int32("leader", leader)),
+		}
+		m.fetchers[leader] = f
+		go f.run()
+	}
+	f.mu.Lock()
+	f.partitions[topicPartition{r.replica.Partition.Topic, r.replica.Partition.ID}] = r
+	f.mu.Unlock()
+	r.replicateWith(f)
+}
+
+// replicaFetcher fetches the partitions the broker follows from a leader.
+type replicaFetcher struct {
+	leader   int32
+	fetchers *replicaFetchers
+	mu       sync.Mutex
+	// partitions are the replicators of the partitions fetched, though only those that are ready
+	// are fetched.
+	partitions map[topicPartition]*Replicator
+	wake       chan struct{}
+	done       chan struct{}
+	logger     log.Logger
+	// conn and backoff are only used by the fetcher's goroutine.
+	conn    client
+	backoff time.Duration
+}
+
+// remove stops the partition being fetched, and stops the fetcher if it was the last partition.
+func (f *replicaFetcher) remove(r *Replicator) {
+	m := f.fetchers
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f.mu.Lock()
+	tp := topicPartition{r.replica.Partition.Topic, r.replica.Partition.ID}
+	if f.partitions[tp] == r {
+		delete(f.partitions, tp)
+	}
+	empty := len(f.partitions) == 0
+	f.mu.Unlock()
+	if empty && m.fetchers[f.leader] == f {
+		delete(m.fetchers, f.leader)
+		close(f.done)
+	}
+}
+
+// wakeup has the fetcher check for partitions ready to fetch, like one that just got ready.
+func (f *replicaFetcher) wakeup() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *replicaFetcher) run() {
+	defer f.closeConn()
+	for {
+		select {
+		case <-f.done:
+			return
+		default:
+		}
+		req, replicators := f.request()
+		if req == nil {
+			select {
+			case <-f.done:
+				return
+			case <-f.wake:
+			case <-time.After(replicaFetcherIdleInterval):
+			}
+			continue
+		}
+		if f.conn == nil {
+			conn, err := f.fetchers.dial(f.leader)
+			if err != nil {
+				f.failed(err)
+				continue
+			}
+			f.conn = conn
+		}
+		resp, err := f.conn.Fetch(req)
+		if err != nil {
+			// the connection may be broken, fetch over a new one
+			f.closeConn()
+			f.failed(err)
+			continue
+		}
+		f.backoff = 0
+		for _, t := range resp.Responses {
+			for _, p := range t.PartitionResponses {
+				r, ok := replicators[topicPartition{t.Topic, p.Partition}]
+				if !ok {
+					continue
+				}
+				if !r.fetched(p) && r.config.Backoff > 0 {
+					r.retryAt = time.Now().Add(r.config.Backoff)
+				}
+			}
+		}
+	}
+}
+
+// request returns the next fetch of the partitions ready to be fetched and their replicators, nil
+// if there aren't any.
+func (f *replicaFetcher) request() (*protocol.FetchRequest, map[topicPartition]*Replicator) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var req *protocol.FetchRequest
+	replicators := make(map[topicPartition]*Replicator)
+	topics := make(map[string]*protocol.FetchTopic)
+	for tp, r := range f.partitions {
+		if atomic.LoadInt32(&r.ready) == 0 || now.Before(r.retryAt) {
+			continue
+		}
+		if req == nil {
+			req = &protocol.FetchRequest{
+				// v5 carries the leader's log start offset, brokers handle it though it isn't
+				// advertised to clients
+				APIVersion:  5,
+				ReplicaID:   r.replica.BrokerID,
+				MaxWaitTime: f.fetchers.config.MaxWaitTime,
+				MinBytes:    f.fetchers.config.MinBytes,
+			}
+		}
+		topic, ok := topics[tp.topic]
+		if !ok {
+			topic = &protocol.FetchTopic{Topic: tp.topic}
+			topics[tp.topic] = topic
+			req.Topics = append(req.Topics, topic)
+		}
+		topic.Partitions = append(topic.Partitions, r.fetchPartition())
+		replicators[tp] = r
+	}
+	return req, replicators
+}
+
+// failed logs the fetch failure and waits before fetching again, longer each time in a row.
+func (f *replicaFetcher) failed(err error) {
+	config := f.fetchers.config
+	if f.backoff == 0 {
+		f.backoff = config.Backoff
+	} else if f.backoff *= 2; config.MaxBackoff > 0 && f.backoff > config.MaxBackoff {
+		f.backoff = config.MaxBackoff
+	}
+	f.logger.Error("failed to fetch from leader", log.Error("error", err), log.Duration("backoff", f.backoff))
+	if f.backoff <= 0 {
+		return
+	}
+	select {
+	case <-f.done:
+	case <-time.After(f.backoff):
+	}
+}
+
+func (f *replicaFetcher) closeConn() {
+	if c, ok := f.conn.(io.Closer); ok {
+		c.Close()
+	}
+	f.conn = nil
+}
@@ -0,0 +1,140 @@
+package jocko
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/testutil/retry"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/mock"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestReplicaFetchers(t *testing.T) {
+	leader := &fetcherLeader{batches: 3, failures: 1}
+	var mu sync.Mutex
+	dials := 0
+	m := newReplicaFetchers(replicaFetcherConfig{
+		MinBytes: 1,
+		Backoff:  10 * time.Millisecond,
+	}, func(id int32) (client, error) {
+		require.Equal(t, int32(2), id)
+		mu.Lock()
+		dials++
+		mu.Unlock()
+		return leader, nil
+	}, log.New())
+
+	appended := make(map[int32][]int64)
+	follow := func(partition int32) *Replicator {
+		l := &mock.CommitLog{
+			AppendFunc: func(b []byte) (int64, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				appended[partition] = append(appended[partition], int64(protocol.Encoding.Uint64(b)))
+				return 0, nil
+			},
+			NewestOffsetFunc: func() int64 { return 0 },
+			OldestOffsetFunc: func() int64 { return 0 },
+		}
+		replica := &Replica{
+			Partition: structs.Partition{Topic: "the-topic", ID: partition, Leader: 2},
+			BrokerID:  1,
+			Log:       l,
+		}
+		r := NewReplicator(ReplicatorConfig{Backoff: 10 * time.Millisecond}, replica, leader, log.New())
+		m.add(2, r)
+		return r
+	}
+	r0, r1 := follow(0), follow(1)
+
+	// both partitions are fetched from the offsets after the batches they've appended, on the
+	// connection made again after the fetch that failed
+	retry.Run(t, func(r *retry.R) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(appended[0]) != 3 || len(appended[1]) != 3 {
+			r.Fatalf("got %v appended", appended)
+		}
+	})
+	mu.Lock()
+	require.Equal(t, map[int32][]int64{0: {0, 1, 2}, 1: {0, 1, 2}}, appended)
+	require.Equal(t, 2, dials)
+	mu.Unlock()
+	require.True(t, leader.fetchedTogether())
+
+	// the fetcher's stopped with its last partition
+	require.NoError(t, r0.Close())
+	m.mu.Lock()
+	require.Equal(t, 1, len(m.fetchers))
+	m.mu.Unlock()
+	require.NoError(t, r1.Close())
+	m.mu.Lock()
+	require.Equal(t, 0, len(m.fetchers))
+	m.mu.Unlock()
+}
+
+// fetcherLeader is a leader with the number of batches in each partition, whose first fetches
+// fail.
+type fetcherLeader struct {
+	mu       sync.Mutex
+	batches  int64
+	failures int
+	// together is whether a fetch had more than one partition.
+	together bool
+}
+
+func (c *fetcherLeader) Fetch(req *protocol.FetchRequest) (*protocol.FetchResponse, error) {
+	time.Sleep(5 * time.Millisecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return nil, errors.New("connection reset by peer")
+	}
+	resp := &protocol.FetchResponse{APIVersion: req.APIVersion}
+	for _, t := range req.Topics {
+		tr := &protocol.FetchTopicResponse{Topic: t.Topic}
+		if len(t.Partitions) > 1 {
+			c.together = true
+		}
+		for _, p := range t.Partitions {
+			pr := &protocol.FetchPartitionResponse{Partition: p.Partition}
+			if p.FetchOffset < c.batches {
+				batch := &protocol.RecordBatch{
+					BaseOffset:    p.FetchOffset,
+					ProducerID:    -1,
+					ProducerEpoch: -1,
+					BaseSequence:  -1,
+					Records:       []protocol.Record{{Value: []byte("value")}},
+				}
+				pr.RecordSet = batch.Bytes()
+			}
+			tr.PartitionResponses = append(tr.PartitionResponses, pr)
+		}
+		resp.Responses = append(resp.Responses, tr)
+	}
+	return resp, nil
+}
+
+func (c *fetcherLeader) fetchedTogether() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.together
+}
+
+func (c *fetcherLeader) CreateTopics(*protocol.CreateTopicRequests) (*protocol.CreateTopicsResponse, error) {
+	return nil, nil
+}
+
+func (c *fetcherLeader) LeaderAndISR(*protocol.LeaderAndISRRequest) (*protocol.LeaderAndISRResponse, error) {
+	return nil, nil
+}
+
+func (c *fetcherLeader) OffsetForLeaderEpoch(*protocol.OffsetForLeaderEpochRequest) (*protocol.OffsetForLeaderEpochResponse, error) {
+	return nil, nil
+}
@@ -44,3 +44,16 @@ func (rl *replicaLookup) RemoveReplica(replica *Replica) {
 	defer rl.lock.Unlock()
 	delete(rl.replica[replica.Partition.Topic], replica.Partition.ID)
 }
+
+// Replicas returns all the replicas.
+func (rl *replicaLookup) Replicas() []*Replica {
+	rl.lock.RLock()
+	defer rl.lock.RUnlock()
+	var replicas []*Replica
+	for _, partitions := range rl.replica {
+		for _, r := range partitions {
+			replicas = append(replicas, r)
+		}
+	}
+	return replicas
+}
@@ -1,6 +1,11 @@
 package jocko
 
 import (
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+
 	"github.com/travisjeffery/jocko/log"
 	"github.com/travisjeffery/jocko/protocol"
 )
@@ -10,26 +15,54 @@ type client interface {
 	Fetch(fetchRequest *protocol.FetchRequest) (*protocol.FetchResponse, error)
 	CreateTopics(createRequest *protocol.CreateTopicRequests) (*protocol.CreateTopicsResponse, error)
 	LeaderAndISR(request *protocol.LeaderAndISRRequest) (*protocol.LeaderAndISRResponse, error)
+	OffsetForLeaderEpoch(request *protocol.OffsetForLeaderEpochRequest) (*protocol.OffsetForLeaderEpochResponse, error)
 	// others
 }
 
 // Replicator fetches from the partition's leader producing to itself the follower, thereby replicating the partition.
 type Replicator struct {
+	// highwaterMarkOffset is accessed atomically. It's first so it's 64-bit aligned on 32-bit
+	// platforms.
+	highwaterMarkOffset int64
 	config              ReplicatorConfig
 	logger              log.Logger
 	replica             *Replica
 	fetchSize           int32
-	highwaterMarkOffset int64
 	offset              int64
 	msgs                chan []byte
 	done                chan struct{}
 	leader              client
+	// fetcher, if set, is the replica fetcher fetching the partition with the others followed
+	// from the leader, which fetches it once ready's set. retryAt is when it fetches it again
+	// after the leader returned an error for it.
+	fetcher *replicaFetcher
+	ready   int32
+	retryAt time.Time
 }
 
 type ReplicatorConfig struct {
-	MinBytes int32
+	// LeaderEpoch is the epoch of the leader being followed.
+	LeaderEpoch int32
+	MinBytes    int32
 	// todo: make this a time.Duration
 	MaxWaitTime int32
+	// MaxBytes is how much of the partition to fetch at a time. It's raised while the leader
+	// has a batch bigger than it and reset once the batch's fetched. Zero leaves it to the leader.
+	MaxBytes int32
+	// Backoff is how long to wait before fetching again after a fetch failed.
+	Backoff time.Duration
+	// Appended, if set, is called with each record set appended from the leader and its offset.
+	Appended func(offset int64, recordSet []byte)
+	// SnapshotMinBytes is how far behind, in bytes of the leader's sealed segments, the follower
+	// must be to copy them whole rather than fetch their messages. Zero disables it.
+	SnapshotMinBytes int64
+
+	// breaker, if set, is the partition's circuit breaker. Appends wait while it's open and
+	// their outcomes are recorded to it, failures through failed, which must be set with it.
+	breaker *circuitBreaker
+	failed  func(err error)
+	// audit, if set, checks the follower appends the record sets at the leader's offsets.
+	audit *orderingAudit
 }
 
 // NewReplicator returns a new replicator instance.
@@ -38,61 +71,77 @@ func NewReplicator(config ReplicatorConfig, replica *Replica, leader client, log
 		config.MinBytes = 1
 	}
 	r := &Replicator{
-		config:  config,
-		logger:  logger,
-		replica: replica,
-		leader:  leader,
-		done:    make(chan struct{}, 2),
-		msgs:    make(chan []byte, 2),
+		// the leader's high watermark isn't known until the first fetch
+		highwaterMarkOffset: -1,
+		config:              config,
+		logger:              logger,
+		replica:             replica,
+		leader:              leader,
+		fetchSize:           config.MaxBytes,
+		done:                make(chan struct{}, 2),
+		msgs:                make(chan []byte, 2),
 	}
 	return r
 }
 
 // Replicate start fetching messages from the leader and appending them to the local commit log.
+// The replicator fetches the partition by itself, brokers have their replica fetchers fetch it
+// along with the other partitions they follow from the leader.
 func (r *Replicator) Replicate() {
 	go r.fetchMessages()
 	go r.appendMessages()
 }
 
+// replicateWith has the fetcher fetch the partition once the follower's truncated to the leader,
+// then closes the replicator's own client to the leader.
+func (r *Replicator) replicateWith(f *replicaFetcher) {
+	r.fetcher = f
+	go r.appendMessages()
+	go func() {
+		ok := r.prepare()
+		if c, isCloser := r.leader.(io.Closer); isCloser {
+			c.Close()
+		}
+		if !ok {
+			return
+		}
+		atomic.StoreInt32(&r.ready, 1)
+		f.wakeup()
+	}()
+}
+
 func (r *Replicator) fetchMessages() {
+	if !r.prepare() {
+		return
+	}
 	for {
 		select {
 		case <-r.done:
 			return
 		default:
 			fetchRequest := &protocol.FetchRequest{
+				// v5 carries the leader's log start offset, brokers handle it though it isn't
+				// advertised to clients
+				APIVersion:  5,
 				ReplicaID:   r.replica.BrokerID,
 				MaxWaitTime: r.config.MaxWaitTime,
 				MinBytes:    r.config.MinBytes,
 				Topics: []*protocol.FetchTopic{{
-					Topic: r.replica.Partition.Topic,
-					Partitions: []*protocol.FetchPartition{{
-						Partition:   r.replica.Partition.ID,
-						FetchOffset: r.offset,
-					}},
+					Topic:      r.replica.Partition.Topic,
+					Partitions: []*protocol.FetchPartition{r.fetchPartition()},
 				}},
 			}
 			fetchResponse, err := r.leader.Fetch(fetchRequest)
 			// TODO: probably shouldn't panic. just let this replica fall out of ISR.
 			if err != nil {
 				r.logger.Error("failed to fetch messages", log.Error("error", err))
+				r.backoff()
 				continue
 			}
 			for _, resp := range fetchResponse.Responses {
 				for _, p := range resp.PartitionResponses {
-					if p.ErrorCode != protocol.ErrNone.Code() {
-						r.logger.Error("partition response error", log.Int16("error code", p.ErrorCode), log.Any("response", p))
-						continue
-					}
-					if p.RecordSet == nil {
-						// r.logger.Debug("replicator: fetch messages: record set is nil")
-						continue
-					}
-					offset := int64(protocol.Encoding.Uint64(p.RecordSet[:8]))
-					if offset > r.offset {
-						r.msgs <- p.RecordSet
-						r.highwaterMarkOffset = p.HighWatermark
-						r.offset = offset
+					if !r.fetched(p) {
+						r.backoff()
 					}
 				}
 			}
@@ -100,22 +149,273 @@ func (r *Replicator) fetchMessages() {
 	}
 }
 
+// prepare truncates the follower to the leader and copies the leader's sealed segments if it's
+// far enough behind, then has the partition fetched from the follower's newest offset. It
+// returns false if the replicator's closed first.
+func (r *Replicator) prepare() bool {
+	if !r.truncateToLeader() {
+		return false
+	}
+	r.offset = r.replica.Log.NewestOffset()
+	r.snapshotFromLeader()
+	return true
+}
+
+// fetchPartition returns the partition's part of the next fetch from the leader.
+func (r *Replicator) fetchPartition() *protocol.FetchPartition {
+	return &protocol.FetchPartition{
+		Partition:      r.replica.Partition.ID,
+		FetchOffset:    r.offset,
+		LogStartOffset: r.replica.Log.OldestOffset(),
+		MaxBytes:       r.fetchSize,
+	}
+}
+
+// fetched handles the partition's response to a fetch from the leader, handing its record set to
+// be appended and fetching past it next. It returns false if the leader returned an error.
+func (r *Replicator) fetched(p *protocol.FetchPartitionResponse) bool {
+	if p.ErrorCode == protocol.ErrMessageTooLarge.Code() && r.fetchSize > 0 {
+		r.raiseFetchSize()
+		return true
+	}
+	if p.ErrorCode != protocol.ErrNone.Code() {
+		r.logger.Error("partition response error", log.Int16("error code", p.ErrorCode), log.Any("response", p))
+		return false
+	}
+	r.deleteRecords(p.LogStartOffset)
+	atomic.StoreInt64(&r.highwaterMarkOffset, p.HighWatermark)
+	if p.RecordSet == nil {
+		// r.logger.Debug("replicator: fetch messages: record set is nil")
+		return true
+	}
+	// the batch that was too large has been fetched
+	r.fetchSize = r.config.MaxBytes
+	next, ok := recordSetNextOffset(p.RecordSet)
+	if !ok || next <= r.offset {
+		return true
+	}
+	select {
+	case r.msgs <- p.RecordSet:
+	case <-r.done:
+		return true
+	}
+	r.offset = next
+	return true
+}
+
+// recordSetNextOffset returns the offset after the record set's last message, false if it has
+// none.
+func recordSetNextOffset(recordSet []byte) (int64, bool) {
+	entries := protocol.RecordSetEntries(recordSet)
+	if len(entries) == 0 {
+		return 0, false
+	}
+	last := entries[len(entries)-1]
+	next := last.Offset + 1
+	if last.Magic >= 2 {
+		if batches, err := protocol.ReadRecordBatches(last.Bytes); err == nil && len(batches) > 0 {
+			next = batches[0].BaseOffset + int64(batches[0].LastOffsetDelta) + 1
+		}
+	}
+	return next, true
+}
+
+// raiseFetchSize doubles the partition's fetch size so the batch at the offset being fetched,
+// which the leader said is bigger than it, fits. Replication would stall on the batch otherwise.
+func (r *Replicator) raiseFetchSize() {
+	if r.fetchSize > math.MaxInt32/2 {
+		r.fetchSize = math.MaxInt32
+	} else {
+		r.fetchSize *= 2
+	}
+	r.logger.Info("raising fetch size for batch too large to fetch", log.Int64("offset", r.offset), log.Int32("fetch size", r.fetchSize))
+}
+
+// backoff waits before fetching again after a fetch failed, so a leader that's failing isn't
+// hammered with fetches.
+func (r *Replicator) backoff() {
+	if r.config.Backoff <= 0 {
+		return
+	}
+	select {
+	case <-r.done:
+	case <-time.After(r.config.Backoff):
+	}
+}
+
 func (r *Replicator) appendMessages() {
 	for {
 		select {
 		case <-r.done:
 			return
 		case msg := <-r.msgs:
-			_, err := r.replica.Log.Append(msg)
-			if err != nil {
-				panic(err)
+			// the log gives the record set its own offsets as it's appended
+			leaderOffset := int64(-1)
+			if len(msg) >= 8 {
+				leaderOffset = int64(protocol.Encoding.Uint64(msg))
+			}
+			offset, ok := r.append(msg)
+			if !ok {
+				return
+			}
+			if leaderOffset >= 0 {
+				r.config.audit.replicated(r.replica.Partition.Topic, r.replica.Partition.ID, leaderOffset, offset)
+			}
+			r.assignEpoch(offset, msg)
+			if r.config.Appended != nil {
+				r.config.Appended(offset, msg)
+			}
+		}
+	}
+}
+
+// appendRetryInterval is how long the replicator waits to try appending again after an append
+// failed or while the partition's circuit breaker is open.
+const appendRetryInterval = 100 * time.Millisecond
+
+// append appends the record set to the follower's log, trying again until it's appended so the
+// messages after it aren't appended out of order. It returns false if the replicator's closed
+// first.
+func (r *Replicator) append(msg []byte) (int64, bool) {
+	for {
+		if r.config.breaker == nil || r.config.breaker.allow() {
+			offset, err := r.replica.Log.Append(msg)
+			if err == nil {
+				if r.config.breaker != nil {
+					r.config.breaker.success()
+				}
+				return offset, true
 			}
+			r.logger.Error("failed to append messages", log.Error("error", err))
+			if r.config.failed != nil {
+				r.config.failed(err)
+			}
+		}
+		select {
+		case <-r.done:
+			return 0, false
+		case <-time.After(appendRetryInterval):
+		}
+	}
+}
+
+// truncateToLeader truncates the messages the follower has past where its log diverges from the
+// leader's, the end offset on the leader of the latest epoch the follower appended messages in,
+// trying again until it's truncated so it doesn't fetch onto the diverging messages. Followers
+// without epochs keep their messages. It returns false if the replicator's closed first.
+func (r *Replicator) truncateToLeader() bool {
+	for {
+		err := r.truncate()
+		if err == nil {
+			return true
+		}
+		r.logger.Error("failed to truncate to leader", log.Error("error", err))
+		select {
+		case <-r.done:
+			return false
+		case <-time.After(appendRetryInterval):
+		}
+	}
+}
+
+func (r *Replicator) truncate() error {
+	el, ok := r.replica.Log.(epochLog)
+	if !ok {
+		return nil
+	}
+	tl, ok := r.replica.Log.(truncatableLog)
+	if !ok {
+		return nil
+	}
+	epoch := el.LatestEpoch()
+	if epoch < 0 {
+		return nil
+	}
+	resp, err := r.leader.OffsetForLeaderEpoch(&protocol.OffsetForLeaderEpochRequest{
+		APIVersion: 2,
+		Topics: []protocol.OffsetForLeaderEpochTopic{{
+			Topic: r.replica.Partition.Topic,
+			Partitions: []protocol.OffsetForLeaderEpochPartition{{
+				Partition:          r.replica.Partition.ID,
+				CurrentLeaderEpoch: r.config.LeaderEpoch,
+				LeaderEpoch:        epoch,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Topics) == 0 || len(resp.Topics[0].Partitions) == 0 {
+		return protocol.ErrUnknown
+	}
+	p := resp.Topics[0].Partitions[0]
+	if p.ErrorCode != protocol.ErrNone.Code() {
+		return protocol.Errs[p.ErrorCode]
+	}
+	if p.EndOffset < 0 {
+		// the leader doesn't know the epoch either so there's nothing to compare
+		return nil
+	}
+	offset := p.EndOffset
+	if p.LeaderEpoch < epoch {
+		// the leader didn't lead in the follower's latest epoch, the logs diverge from the end of
+		// the epoch both have
+		if _, end := el.EndOffsetForEpoch(p.LeaderEpoch); end >= 0 && end < offset {
+			offset = end
 		}
 	}
+	if offset >= r.replica.Log.NewestOffset() {
+		return nil
+	}
+	r.logger.Info("truncating to leader", log.Int32("epoch", epoch), log.Int64("offset", offset))
+	return tl.TruncateTo(offset)
+}
+
+// assignEpoch records the epoch the leader appended the record set in, that of its last v2 batch,
+// to the follower's leader epoch cache. v0/v1 message sets don't carry the epoch and are skipped.
+func (r *Replicator) assignEpoch(offset int64, msg []byte) {
+	l, ok := r.replica.Log.(epochLog)
+	if !ok {
+		return
+	}
+	epoch := protocol.PartitionLeaderEpoch(msg)
+	if epoch < 0 {
+		return
+	}
+	if err := l.AssignEpoch(epoch, offset); err != nil {
+		r.logger.Error("failed to assign epoch", log.Error("error", err), log.Int32("epoch", epoch))
+	}
+}
+
+// deleteRecords deletes the messages before the leader's log start offset from the follower's
+// log, up to the messages it has.
+func (r *Replicator) deleteRecords(logStartOffset int64) {
+	l, ok := r.replica.Log.(recordDeleter)
+	if !ok {
+		return
+	}
+	if newest := r.replica.Log.NewestOffset(); logStartOffset > newest {
+		logStartOffset = newest
+	}
+	if logStartOffset <= r.replica.Log.OldestOffset() {
+		return
+	}
+	if err := l.DeleteRecords(logStartOffset); err != nil {
+		r.logger.Error("failed to delete records", log.Error("error", err), log.Int64("log start offset", logStartOffset))
+	}
+}
+
+// HighWatermark returns the leader's high watermark as of the last fetch, the offset of its last
+// committed message, -1 before the first fetch.
+func (r *Replicator) HighWatermark() int64 {
+	return atomic.LoadInt64(&r.highwaterMarkOffset)
 }
 
 // Close the replicator object when we are no longer following
 func (r *Replicator) Close() error {
+	if r.fetcher != nil {
+		r.fetcher.remove(r)
+	}
 	close(r.done)
 	return nil
 }
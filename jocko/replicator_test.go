@@ -3,16 +3,21 @@ package jocko_test
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
+	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/travisjeffery/jocko/commitlog"
 	"github.com/travisjeffery/jocko/jocko"
 	"github.com/travisjeffery/jocko/jocko/structs"
 	"github.com/travisjeffery/jocko/log"
 	"github.com/travisjeffery/jocko/mock"
+	"github.com/travisjeffery/jocko/protocol"
 	"github.com/travisjeffery/jocko/testutil"
 )
 
@@ -55,6 +60,380 @@ func TestBroker_Replicate(t *testing.T) {
 	require.NoError(t, replicator.Close())
 }
 
+func TestReplicator_DeleteRecords(t *testing.T) {
+	deleted := make(chan int64, 8)
+	c := newCommitLog()
+	c.NewestOffsetFunc = func() int64 { return 3 }
+	replica := &jocko.Replica{
+		Partition: structs.Partition{Topic: "test", ID: 0, Leader: 0, AR: []int32{0}},
+		BrokerID:  1,
+		Log:       &deletableCommitLog{commitLog: c, deleted: deleted},
+	}
+	replicator := jocko.NewReplicator(jocko.ReplicatorConfig{}, replica, logStartOffsetClient(5), log.New())
+	replicator.Replicate()
+	defer replicator.Close()
+
+	// the follower deletes up to the leader's log start offset, as far as the messages it has
+	select {
+	case offset := <-deleted:
+		require.Equal(t, int64(3), offset)
+	case <-time.After(time.Second):
+		t.Fatal("records not deleted")
+	}
+}
+
+func TestReplicator_TruncateToLeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicator")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c, err := commitlog.New(commitlog.Options{Path: dir, MaxSegmentBytes: 1024, MaxLogBytes: -1})
+	require.NoError(t, err)
+	require.NoError(t, c.AssignEpoch(1, 0))
+	for i := 0; i < 2; i++ {
+		_, err = c.Append(commitlog.NewMessageSet(0, commitlog.NewMessage([]byte("epoch 1"))))
+		require.NoError(t, err)
+	}
+	// the follower led in epoch 2 but its messages never made it to the new leader
+	require.NoError(t, c.AssignEpoch(2, 2))
+	for i := 0; i < 2; i++ {
+		_, err = c.Append(commitlog.NewMessageSet(0, commitlog.NewMessage([]byte("epoch 2"))))
+		require.NoError(t, err)
+	}
+
+	replica := &jocko.Replica{
+		Partition: structs.Partition{Topic: "test", ID: 0, Leader: 0, AR: []int32{0, 1}},
+		BrokerID:  1,
+		Log:       c,
+	}
+	// the leader's batch, appended in its epoch 3, goes after the messages the logs share
+	batch := make([]byte, 61)
+	protocol.Encoding.PutUint64(batch, 2)
+	protocol.Encoding.PutUint32(batch[8:], uint32(len(batch)-12))
+	batch[16] = 2
+	protocol.SetPartitionLeaderEpoch(batch, 3)
+	leader := &epochClient{requests: make(chan protocol.OffsetForLeaderEpochPartition, 1), batch: batch}
+	replicator := jocko.NewReplicator(jocko.ReplicatorConfig{LeaderEpoch: 3}, replica, leader, log.New())
+	replicator.Replicate()
+	defer replicator.Close()
+
+	select {
+	case p := <-leader.requests:
+		require.Equal(t, int32(3), p.CurrentLeaderEpoch)
+		require.Equal(t, int32(2), p.LeaderEpoch)
+	case <-time.After(time.Second):
+		t.Fatal("offset for leader epoch not requested")
+	}
+	testutil.WaitForResult(func() (bool, error) {
+		return c.LatestEpoch() == 3, nil
+	}, func(err error) {
+		t.Fatal("epoch not assigned")
+	})
+	// the follower's epoch 2 messages were truncated and the leader's appended in their place
+	require.Equal(t, int64(3), c.NewestOffset())
+	epoch, offset := c.EndOffsetForEpoch(1)
+	require.Equal(t, int32(1), epoch)
+	require.Equal(t, int64(2), offset)
+}
+
+func TestReplicator_MessageTooLarge(t *testing.T) {
+	c := newCommitLog()
+	replica := &jocko.Replica{
+		Partition: structs.Partition{Topic: "test", ID: 0, Leader: 0, AR: []int32{0, 1}},
+		BrokerID:  1,
+		Log:       c,
+	}
+	batch := make([]byte, 61)
+	protocol.Encoding.PutUint64(batch, 1)
+	protocol.Encoding.PutUint32(batch[8:], uint32(len(batch)-12))
+	leader := &tooLargeClient{batch: batch, sizes: make(chan int32, 16)}
+	replicator := jocko.NewReplicator(jocko.ReplicatorConfig{MaxBytes: 16}, replica, leader, log.New())
+	replicator.Replicate()
+	defer replicator.Close()
+
+	// the fetch size's doubled until the batch fits, then reset
+	for _, exp := range []int32{16, 32, 64, 16} {
+		select {
+		case size := <-leader.sizes:
+			require.Equal(t, exp, size)
+		case <-time.After(time.Second):
+			t.Fatal("fetch not sent")
+		}
+	}
+	testutil.WaitForResult(func() (bool, error) {
+		return len(c.Log()) == 1, nil
+	}, func(err error) {
+		t.Fatal("batch not appended")
+	})
+	require.Equal(t, batch, c.Log()[0])
+}
+
+// tooLargeClient is a leader with one batch, which it says is too large for fetches smaller than
+// it and otherwise returns once.
+type tooLargeClient struct {
+	batch   []byte
+	sizes   chan int32
+	fetched int32
+}
+
+func (c *tooLargeClient) Fetch(req *protocol.FetchRequest) (*protocol.FetchResponse, error) {
+	time.Sleep(10 * time.Millisecond)
+	fp := req.Topics[0].Partitions[0]
+	select {
+	case c.sizes <- fp.MaxBytes:
+	default:
+	}
+	p := &protocol.FetchPartitionResponse{Partition: fp.Partition}
+	if fp.MaxBytes < int32(len(c.batch)) {
+		p.ErrorCode = protocol.ErrMessageTooLarge.Code()
+	} else if atomic.CompareAndSwapInt32(&c.fetched, 0, 1) {
+		p.RecordSet = c.batch
+	}
+	return &protocol.FetchResponse{
+		APIVersion: req.APIVersion,
+		Responses: protocol.FetchTopicResponses{{
+			Topic:              req.Topics[0].Topic,
+			PartitionResponses: []*protocol.FetchPartitionResponse{p},
+		}},
+	}, nil
+}
+
+func (c *tooLargeClient) CreateTopics(*protocol.CreateTopicRequests) (*protocol.CreateTopicsResponse, error) {
+	return nil, nil
+}
+
+func (c *tooLargeClient) LeaderAndISR(*protocol.LeaderAndISRRequest) (*protocol.LeaderAndISRResponse, error) {
+	return nil, nil
+}
+
+func (c *tooLargeClient) OffsetForLeaderEpoch(*protocol.OffsetForLeaderEpochRequest) (*protocol.OffsetForLeaderEpochResponse, error) {
+	return nil, nil
+}
+
+// epochClient is a leader that ended epoch 1 at offset 3 and has led since epoch 3, whose fetch
+// responses carry the batch once.
+type epochClient struct {
+	requests chan protocol.OffsetForLeaderEpochPartition
+	batch    []byte
+	fetched  int32
+}
+
+func (c *epochClient) Fetch(req *protocol.FetchRequest) (*protocol.FetchResponse, error) {
+	time.Sleep(10 * time.Millisecond)
+	p := &protocol.FetchPartitionResponse{Partition: req.Topics[0].Partitions[0].Partition}
+	if atomic.CompareAndSwapInt32(&c.fetched, 0, 1) {
+		p.RecordSet = c.batch
+	}
+	return &protocol.FetchResponse{
+		APIVersion: req.APIVersion,
+		Responses: protocol.FetchTopicResponses{{
+			Topic:              req.Topics[0].Topic,
+			PartitionResponses: []*protocol.FetchPartitionResponse{p},
+		}},
+	}, nil
+}
+
+func (c *epochClient) CreateTopics(*protocol.CreateTopicRequests) (*protocol.CreateTopicsResponse, error) {
+	return nil, nil
+}
+
+func (c *epochClient) LeaderAndISR(*protocol.LeaderAndISRRequest) (*protocol.LeaderAndISRResponse, error) {
+	return nil, nil
+}
+
+func (c *epochClient) OffsetForLeaderEpoch(req *protocol.OffsetForLeaderEpochRequest) (*protocol.OffsetForLeaderEpochResponse, error) {
+	p := req.Topics[0].Partitions[0]
+	c.requests <- p
+	return &protocol.OffsetForLeaderEpochResponse{
+		APIVersion: req.APIVersion,
+		Topics: []protocol.OffsetForLeaderEpochTopicResponse{{
+			Topic: req.Topics[0].Topic,
+			Partitions: []protocol.OffsetForLeaderEpochPartitionResponse{{
+				Partition:   p.Partition,
+				LeaderEpoch: 1,
+				EndOffset:   3,
+			}},
+		}},
+	}, nil
+}
+
+// logStartOffsetClient is a leader whose fetch responses carry its log start offset and no
+// messages.
+type logStartOffsetClient int64
+
+func (c logStartOffsetClient) Fetch(req *protocol.FetchRequest) (*protocol.FetchResponse, error) {
+	time.Sleep(10 * time.Millisecond)
+	return &protocol.FetchResponse{
+		APIVersion: req.APIVersion,
+		Responses: protocol.FetchTopicResponses{{
+			Topic: req.Topics[0].Topic,
+			PartitionResponses: []*protocol.FetchPartitionResponse{{
+				Partition:      req.Topics[0].Partitions[0].Partition,
+				LogStartOffset: int64(c),
+			}},
+		}},
+	}, nil
+}
+
+func (c logStartOffsetClient) CreateTopics(*protocol.CreateTopicRequests) (*protocol.CreateTopicsResponse, error) {
+	return nil, nil
+}
+
+func (c logStartOffsetClient) LeaderAndISR(*protocol.LeaderAndISRRequest) (*protocol.LeaderAndISRResponse, error) {
+	return nil, nil
+}
+
+func (c logStartOffsetClient) OffsetForLeaderEpoch(*protocol.OffsetForLeaderEpochRequest) (*protocol.OffsetForLeaderEpochResponse, error) {
+	return nil, nil
+}
+
+func TestReplicator_Snapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicator")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	leaderLog, err := commitlog.New(commitlog.Options{Path: dir + "/leader", MaxSegmentBytes: 128, MaxLogBytes: -1})
+	require.NoError(t, err)
+	// each batch has one record, so takes one offset
+	batch := func() []byte {
+		b := make([]byte, 61)
+		protocol.Encoding.PutUint32(b[8:], uint32(len(b)-12))
+		b[16] = 2
+		return b
+	}
+	require.NoError(t, leaderLog.AssignEpoch(1, 0))
+	for i := 0; i < 10; i++ {
+		_, err = leaderLog.Append(batch())
+		require.NoError(t, err)
+	}
+	require.NoError(t, leaderLog.AssignEpoch(2, 10))
+	for i := 0; i < 10; i++ {
+		_, err = leaderLog.Append(batch())
+		require.NoError(t, err)
+	}
+	sealed, err := leaderLog.SealedSegments()
+	require.NoError(t, err)
+	require.True(t, len(sealed) > 1)
+	end := sealed[len(sealed)-1].NextOffset
+
+	c, err := commitlog.New(commitlog.Options{Path: dir + "/follower", MaxSegmentBytes: 256, MaxLogBytes: -1})
+	require.NoError(t, err)
+	replica := &jocko.Replica{
+		Partition: structs.Partition{Topic: "test", ID: 0, Leader: 0, AR: []int32{0, 1}},
+		BrokerID:  1,
+		Log:       c,
+	}
+	var mu sync.Mutex
+	var appended []int64
+	replicator := jocko.NewReplicator(jocko.ReplicatorConfig{
+		LeaderEpoch:      2,
+		MaxBytes:         100,
+		SnapshotMinBytes: 1,
+		Appended: func(offset int64, recordSet []byte) {
+			mu.Lock()
+			appended = append(appended, offset)
+			mu.Unlock()
+		},
+	}, replica, &snapshotClient{leaderLog}, log.New())
+	replicator.Replicate()
+	defer replicator.Close()
+
+	// the epochs are assigned once the segments are copied
+	testutil.WaitForResult(func() (bool, error) {
+		return c.NewestOffset() == end && c.LatestEpoch() == 2, nil
+	}, func(err error) {
+		t.Fatal("segments not copied")
+	})
+	// the segments are copied as they are, with their epochs
+	copied, err := c.SealedSegments()
+	require.NoError(t, err)
+	require.Equal(t, sealed, copied)
+	require.Equal(t, int32(2), c.LatestEpoch())
+	epoch, offset := c.EndOffsetForEpoch(1)
+	require.Equal(t, int32(1), epoch)
+	require.Equal(t, int64(10), offset)
+	// the copied record sets are replayed as if they'd been fetched
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, int(end), len(appended))
+	for i, offset := range appended {
+		require.Equal(t, int64(i), offset)
+	}
+}
+
+// snapshotClient is a leader whose sealed segments can be copied and which has no messages to
+// fetch.
+type snapshotClient struct {
+	*commitlog.CommitLog
+}
+
+func (c *snapshotClient) FetchSegment(req *protocol.FetchSegmentRequest) (*protocol.FetchSegmentResponse, error) {
+	resp := &protocol.FetchSegmentResponse{}
+	if req.BaseOffset < 0 {
+		sealed, err := c.SealedSegments()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sealed {
+			resp.Segments = append(resp.Segments, protocol.SealedSegment{
+				BaseOffset: s.BaseOffset,
+				NextOffset: s.NextOffset,
+				Size:       s.Size,
+				Checksum:   s.Checksum,
+			})
+		}
+		for _, e := range c.Epochs() {
+			resp.Epochs = append(resp.Epochs, protocol.SegmentEpoch{Epoch: e.Epoch, StartOffset: e.StartOffset})
+		}
+		return resp, nil
+	}
+	p := make([]byte, req.MaxBytes)
+	n, err := c.ReadSegment(req.BaseOffset, req.Position, p)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	resp.Bytes = p[:n]
+	return resp, nil
+}
+
+func (c *snapshotClient) Fetch(req *protocol.FetchRequest) (*protocol.FetchResponse, error) {
+	time.Sleep(10 * time.Millisecond)
+	return &protocol.FetchResponse{
+		APIVersion: req.APIVersion,
+		Responses: protocol.FetchTopicResponses{{
+			Topic:              req.Topics[0].Topic,
+			PartitionResponses: []*protocol.FetchPartitionResponse{{Partition: req.Topics[0].Partitions[0].Partition}},
+		}},
+	}, nil
+}
+
+func (c *snapshotClient) CreateTopics(*protocol.CreateTopicRequests) (*protocol.CreateTopicsResponse, error) {
+	return nil, nil
+}
+
+func (c *snapshotClient) LeaderAndISR(*protocol.LeaderAndISRRequest) (*protocol.LeaderAndISRResponse, error) {
+	return nil, nil
+}
+
+func (c *snapshotClient) OffsetForLeaderEpoch(*protocol.OffsetForLeaderEpochRequest) (*protocol.OffsetForLeaderEpochResponse, error) {
+	return nil, nil
+}
+
+type deletableCommitLog struct {
+	// oldest is first so it's 64-bit aligned for atomic access on 32-bit platforms.
+	oldest int64
+	*commitLog
+	deleted chan int64
+}
+
+func (c *deletableCommitLog) OldestOffset() int64 {
+	return atomic.LoadInt64(&c.oldest)
+}
+
+func (c *deletableCommitLog) DeleteRecords(offset int64) error {
+	atomic.StoreInt64(&c.oldest, offset)
+	c.deleted <- offset
+	return nil
+}
+
 type commitLog struct {
 	*mock.CommitLog
 	sync.RWMutex
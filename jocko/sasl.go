@@ -0,0 +1,352 @@
+package jocko
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// SASLMechanism authenticates clients connecting to the Kafka listener with a SASL mechanism.
+type SASLMechanism interface {
+	// Name is the mechanism's name clients ask for in their SaslHandshake request, like PLAIN.
+	Name() string
+	// Start starts authenticating a client.
+	Start() SASLAuthenticator
+}
+
+// SASLAuthenticator authenticates a client through its SaslAuthenticate requests.
+type SASLAuthenticator interface {
+	// Next is passed the auth bytes of the client's request and returns the auth bytes to send
+	// back and whether the client is authenticated. Returning an error fails authentication.
+	Next(authBytes []byte) (challenge []byte, done bool, err error)
+	// Principal is who the client authenticated as, once it's authenticated.
+	Principal() string
+}
+
+// ErrSASLAuthenticationFailed is returned by authenticators when the client's credentials
+// aren't valid.
+var ErrSASLAuthenticationFailed = errors.New("authentication failed: invalid credentials")
+
+// PlainMechanism returns the PLAIN SASL mechanism, authenticating clients' usernames and
+// passwords with authenticate.
+func PlainMechanism(authenticate func(username, password string) bool) SASLMechanism {
+	return plainMechanism(authenticate)
+}
+
+type plainMechanism func(username, password string) bool
+
+func (m plainMechanism) Name() string {
+	return "PLAIN"
+}
+
+func (m plainMechanism) Start() SASLAuthenticator {
+	return &plainAuthenticator{authenticate: m}
+}
+
+type plainAuthenticator struct {
+	authenticate func(username, password string) bool
+	username     string
+}
+
+// Next authenticates the client in one step from its [authzid] NUL authcid NUL passwd message.
+func (a *plainAuthenticator) Next(authBytes []byte) ([]byte, bool, error) {
+	parts := bytes.Split(authBytes, []byte{0})
+	if len(parts) != 3 {
+		return nil, false, fmt.Errorf("invalid PLAIN message: expected 3 parts, got %d", len(parts))
+	}
+	username, password := string(parts[1]), string(parts[2])
+	if authzid := string(parts[0]); authzid != "" && authzid != username {
+		return nil, false, errors.New("authentication failed: authorization id must match the username")
+	}
+	if !a.authenticate(username, password) {
+		return nil, false, ErrSASLAuthenticationFailed
+	}
+	a.username = username
+	return nil, true, nil
+}
+
+func (a *plainAuthenticator) Principal() string {
+	return a.username
+}
+
+// scramIterations is the PBKDF2 iteration count of the SCRAM credentials derived from passwords.
+const scramIterations = 4096
+
+// ScramLookup is passed the username of a client authenticating with SCRAM and whether it's
+// authenticating with a delegation token, the tokenauth extension, and returns its password and
+// the principal it authenticates as, or false if it's unknown.
+type ScramLookup func(username string, tokenAuth bool) (password, principal string, ok bool)
+
+// ScramMechanism returns the SCRAM-SHA-256 or SCRAM-SHA-512 SASL mechanism, authenticating
+// clients with the passwords lookup returns.
+func ScramMechanism(name string, lookup ScramLookup) (SASLMechanism, error) {
+	var h func() hash.Hash
+	switch name {
+	case "SCRAM-SHA-256":
+		h = sha256.New
+	case "SCRAM-SHA-512":
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported SCRAM mechanism: %s", name)
+	}
+	return &scramMechanism{name: name, hash: h, lookup: lookup}, nil
+}
+
+type scramMechanism struct {
+	name   string
+	hash   func() hash.Hash
+	lookup ScramLookup
+}
+
+func (m *scramMechanism) Name() string {
+	return m.name
+}
+
+func (m *scramMechanism) Start() SASLAuthenticator {
+	return &scramAuthenticator{mechanism: m}
+}
+
+// scramAuthenticator authenticates a client with the SCRAM exchange of RFC 5802: the client's
+// first message, the server's challenge, the client's proof and the server's signature.
+type scramAuthenticator struct {
+	mechanism *scramMechanism
+	step      int
+
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	salt            []byte
+	password        string
+	principal       string
+	tokenID         string
+}
+
+func (a *scramAuthenticator) Next(authBytes []byte) ([]byte, bool, error) {
+	a.step++
+	switch a.step {
+	case 1:
+		return a.first(string(authBytes))
+	case 2:
+		return a.final(string(authBytes))
+	}
+	return nil, false, errors.New("invalid SCRAM message: exchange is done")
+}
+
+// first handles the client's gs2-header,n=username,r=nonce[,extensions] message.
+func (a *scramAuthenticator) first(msg string) ([]byte, bool, error) {
+	parts := strings.SplitN(msg, ",", 3)
+	if len(parts) != 3 || (parts[0] != "n" && parts[0] != "y") {
+		return nil, false, errors.New("invalid SCRAM message: bad gs2 header")
+	}
+	a.gs2Header = parts[0] + "," + parts[1] + ","
+	a.clientFirstBare = parts[2]
+	var username, clientNonce string
+	tokenAuth := false
+	for _, attr := range strings.Split(a.clientFirstBare, ",") {
+		switch {
+		case strings.HasPrefix(attr, "n="):
+			username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attr[2:])
+		case strings.HasPrefix(attr, "r="):
+			clientNonce = attr[2:]
+		case attr == "tokenauth=true":
+			tokenAuth = true
+		}
+	}
+	if username == "" || clientNonce == "" {
+		return nil, false, errors.New("invalid SCRAM message: missing username or nonce")
+	}
+	password, principal, ok := a.mechanism.lookup(username, tokenAuth)
+	if !ok {
+		return nil, false, ErrSASLAuthenticationFailed
+	}
+	a.password, a.principal = password, principal
+	if tokenAuth {
+		a.tokenID = username
+	}
+	serverNonce := make([]byte, 18)
+	a.salt = make([]byte, 18)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, false, err
+	}
+	if _, err := rand.Read(a.salt); err != nil {
+		return nil, false, err
+	}
+	a.nonce = clientNonce + base64.RawStdEncoding.EncodeToString(serverNonce)
+	a.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", a.nonce, base64.StdEncoding.EncodeToString(a.salt), scramIterations)
+	return []byte(a.serverFirst), false, nil
+}
+
+// final handles the client's c=channel-binding,r=nonce,p=proof message.
+func (a *scramAuthenticator) final(msg string) ([]byte, bool, error) {
+	i := strings.LastIndex(msg, ",p=")
+	if i < 0 {
+		return nil, false, errors.New("invalid SCRAM message: missing proof")
+	}
+	withoutProof := msg[:i]
+	proof, err := base64.StdEncoding.DecodeString(msg[i+3:])
+	if err != nil {
+		return nil, false, errors.New("invalid SCRAM message: bad proof")
+	}
+	if withoutProof != "c="+base64.StdEncoding.EncodeToString([]byte(a.gs2Header))+",r="+a.nonce {
+		return nil, false, errors.New("invalid SCRAM message: bad channel binding or nonce")
+	}
+	h := a.mechanism.hash
+	salted := pbkdf2(h, []byte(a.password), a.salt, scramIterations)
+	clientKey := hmacSum(h, salted, []byte("Client Key"))
+	storedKey := h()
+	storedKey.Write(clientKey)
+	authMessage := []byte(a.clientFirstBare + "," + a.serverFirst + "," + withoutProof)
+	clientSignature := hmacSum(h, storedKey.Sum(nil), authMessage)
+	if len(proof) != len(clientSignature) {
+		return nil, false, ErrSASLAuthenticationFailed
+	}
+	for i := range proof {
+		proof[i] ^= clientSignature[i]
+	}
+	if !hmac.Equal(proof, clientKey) {
+		return nil, false, ErrSASLAuthenticationFailed
+	}
+	serverSignature := hmacSum(h, hmacSum(h, salted, []byte("Server Key")), authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), true, nil
+}
+
+func (a *scramAuthenticator) Principal() string {
+	return a.principal
+}
+
+// TokenID returns the ID of the delegation token the client authenticated with, if it did.
+func (a *scramAuthenticator) TokenID() string {
+	return a.tokenID
+}
+
+func hmacSum(h func() hash.Hash, key, msg []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// pbkdf2 derives a key the size of the hash from the password as PBKDF2 does with HMAC.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	u := hmacSum(h, password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	key := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = hmacSum(h, password, u)
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+var principalKey = contextKey("principal key")
+
+// principal is who sent a request, the client's empty name if it didn't authenticate.
+type principal struct {
+	name string
+	// tokenID is the ID of the delegation token the client authenticated with, if it did.
+	tokenID string
+}
+
+// requestPrincipal returns who sent the request.
+func requestPrincipal(ctx context.Context) principal {
+	p, _ := ctx.Value(principalKey).(principal)
+	return p
+}
+
+// withPrincipal returns the context of a request sent by the principal.
+func withPrincipal(ctx context.Context, name, tokenID string) context.Context {
+	return context.WithValue(ctx, principalKey, principal{name: name, tokenID: tokenID})
+}
+
+// saslState is the SASL state of a connection. Until the client's authenticated, the only
+// requests it can send are ApiVersions, SaslHandshake and SaslAuthenticate.
+type saslState struct {
+	mechanisms    []SASLMechanism
+	authenticator SASLAuthenticator
+	authenticated bool
+	// principal is who the client authenticated as.
+	principal string
+	// tokenID is the ID of the delegation token the client authenticated with, if it did.
+	tokenID string
+}
+
+func newSASLState(mechanisms []SASLMechanism) *saslState {
+	return &saslState{
+		mechanisms: mechanisms,
+		// connections don't authenticate when there aren't any mechanisms enabled
+		authenticated: len(mechanisms) == 0,
+	}
+}
+
+// allowed returns whether the connection can send the request in its state.
+func (s *saslState) allowed(req interface{}) bool {
+	if s.authenticated {
+		return true
+	}
+	switch req.(type) {
+	case *protocol.APIVersionsRequest, *protocol.SaslHandshakeRequest, *protocol.SaslAuthenticateRequest:
+		return true
+	}
+	return false
+}
+
+func (s *saslState) handshake(req *protocol.SaslHandshakeRequest) *protocol.SaslHandshakeResponse {
+	resp := &protocol.SaslHandshakeResponse{APIVersion: req.Version()}
+	for _, m := range s.mechanisms {
+		resp.EnabledMechanisms = append(resp.EnabledMechanisms, m.Name())
+	}
+	if s.authenticated || s.authenticator != nil {
+		resp.ErrorCode = protocol.ErrIllegalSaslState.Code()
+		return resp
+	}
+	for _, m := range s.mechanisms {
+		if m.Name() == req.Mechanism {
+			s.authenticator = m.Start()
+			resp.ErrorCode = protocol.ErrNone.Code()
+			return resp
+		}
+	}
+	resp.ErrorCode = protocol.ErrUnsupportedSaslMechanism.Code()
+	return resp
+}
+
+// authenticate returns the response to the request and whether the conn should be closed,
+// which it is once authentication fails.
+func (s *saslState) authenticate(req *protocol.SaslAuthenticateRequest) (*protocol.SaslAuthenticateResponse, bool) {
+	resp := &protocol.SaslAuthenticateResponse{APIVersion: req.Version()}
+	if s.authenticated || s.authenticator == nil {
+		msg := "authenticate request sent without a handshake, or after authenticating"
+		resp.ErrorCode = protocol.ErrIllegalSaslState.Code()
+		resp.ErrorMessage = &msg
+		return resp, true
+	}
+	challenge, done, err := s.authenticator.Next(req.AuthBytes)
+	if err != nil {
+		msg := err.Error()
+		resp.ErrorCode = protocol.ErrSaslAuthenticationFailed.Code()
+		resp.ErrorMessage = &msg
+		return resp, true
+	}
+	resp.ErrorCode = protocol.ErrNone.Code()
+	resp.AuthBytes = challenge
+	if done {
+		s.principal = s.authenticator.Principal()
+		if a, ok := s.authenticator.(interface{ TokenID() string }); ok {
+			s.tokenID = a.TokenID()
+		}
+		s.authenticator = nil
+		s.authenticated = true
+	}
+	return resp, false
+}
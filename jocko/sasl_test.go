@@ -0,0 +1,99 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func testPlainMechanism() SASLMechanism {
+	return PlainMechanism(func(username, password string) bool {
+		return username == "alice" && password == "secret"
+	})
+}
+
+func TestPlainMechanism(t *testing.T) {
+	m := testPlainMechanism()
+	require.Equal(t, "PLAIN", m.Name())
+
+	a := m.Start()
+	_, done, err := a.Next([]byte("\x00alice\x00secret"))
+	require.NoError(t, err)
+	require.True(t, done)
+	require.Equal(t, "alice", a.Principal())
+
+	_, _, err = m.Start().Next([]byte("alice\x00alice\x00secret"))
+	require.NoError(t, err)
+
+	_, _, err = m.Start().Next([]byte("\x00alice\x00wrong"))
+	require.Equal(t, ErrSASLAuthenticationFailed, err)
+
+	_, _, err = m.Start().Next([]byte("bob\x00alice\x00secret"))
+	require.Error(t, err)
+
+	_, _, err = m.Start().Next([]byte("alice"))
+	require.Error(t, err)
+}
+
+func TestServer_SASL(t *testing.T) {
+	s, teardown := NewTestServer(t, func(cfg *config.Config) {
+		cfg.Bootstrap = true
+		cfg.BootstrapExpect = 1
+		cfg.StartAsLeader = true
+	}, nil)
+	s.EnableSASL(testPlainMechanism())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.Start(ctx))
+	defer teardown()
+	defer s.Shutdown()
+
+	dial := func() *Conn {
+		conn, err := Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		return conn
+	}
+	handshake := func(conn *Conn, mechanism string) *protocol.SaslHandshakeResponse {
+		resp, err := conn.SaslHandshake(&protocol.SaslHandshakeRequest{APIVersion: 1, Mechanism: mechanism})
+		require.NoError(t, err)
+		require.Equal(t, []string{"PLAIN"}, resp.EnabledMechanisms)
+		return resp
+	}
+
+	// requests other than api versions are rejected until the client authenticates
+	conn := dial()
+	_, err := conn.APIVersions(&protocol.APIVersionsRequest{})
+	require.NoError(t, err)
+	_, err = conn.DescribeLogDirs(&protocol.DescribeLogDirsRequest{})
+	require.Error(t, err)
+	conn.Close()
+
+	conn = dial()
+	require.Equal(t, protocol.ErrUnsupportedSaslMechanism.Code(), handshake(conn, "SCRAM-SHA-256").ErrorCode)
+	conn.Close()
+
+	// failing to authenticate closes the conn once the error's sent
+	conn = dial()
+	require.Equal(t, protocol.ErrNone.Code(), handshake(conn, "PLAIN").ErrorCode)
+	authResp, err := conn.SaslAuthenticate(&protocol.SaslAuthenticateRequest{AuthBytes: []byte("\x00alice\x00wrong")})
+	require.NoError(t, err)
+	require.Equal(t, protocol.ErrSaslAuthenticationFailed.Code(), authResp.ErrorCode)
+	require.NotNil(t, authResp.ErrorMessage)
+	_, err = conn.APIVersions(&protocol.APIVersionsRequest{})
+	require.Error(t, err)
+	conn.Close()
+
+	conn = dial()
+	defer conn.Close()
+	require.Equal(t, protocol.ErrNone.Code(), handshake(conn, "PLAIN").ErrorCode)
+	authResp, err = conn.SaslAuthenticate(&protocol.SaslAuthenticateRequest{APIVersion: 1, AuthBytes: []byte("\x00alice\x00secret")})
+	require.NoError(t, err)
+	require.Equal(t, protocol.ErrNone.Code(), authResp.ErrorCode)
+	_, err = conn.DescribeLogDirs(&protocol.DescribeLogDirsRequest{})
+	require.NoError(t, err)
+	// the conn can't authenticate again
+	require.Equal(t, protocol.ErrIllegalSaslState.Code(), handshake(conn, "PLAIN").ErrorCode)
+}
@@ -0,0 +1,292 @@
+package jocko
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/travisjeffery/jocko/commitlog"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+const (
+	// segmentReadMaxBytes caps how much of a segment's log a fetch segment request reads.
+	segmentReadMaxBytes = 8 * 1024 * 1024
+	// segmentReadDefaultBytes is how much of a segment's log followers read at a time if their
+	// fetch size is left to the leader.
+	segmentReadDefaultBytes = 1024 * 1024
+)
+
+// errReplicatorClosed is returned copying the leader's segments if the replicator's closed first.
+var errReplicatorClosed = errors.New("replicator closed")
+
+// snapshotLog is implemented by commit logs whose sealed segments followers far behind can copy
+// whole, and that can append the segments they copy from their leader.
+type snapshotLog interface {
+	SealedSegments() ([]commitlog.SealedSegment, error)
+	ReadSegment(baseOffset, position int64, p []byte) (int, error)
+	Epochs() []commitlog.EpochEntry
+	SnapshotDir() (string, error)
+	AppendSegments(dir string, sealed []commitlog.SealedSegment) error
+	TruncateTo(offset int64) error
+}
+
+// segmentClient is implemented by clients to leaders that followers can copy segments from.
+type segmentClient interface {
+	FetchSegment(req *protocol.FetchSegmentRequest) (*protocol.FetchSegmentResponse, error)
+}
+
+func (b *Broker) handleFetchSegment(ctx *Context, req *protocol.FetchSegmentRequest) *protocol.FetchSegmentResponse {
+	sp := span(ctx, b.tracer, "fetch segment")
+	defer sp.Finish()
+	resp := &protocol.FetchSegmentResponse{APIVersion: req.Version()}
+	replica, l, err := b.snapshotReplica(req.Topic, req.Partition, req.CurrentLeaderEpoch)
+	if err != protocol.ErrNone {
+		sp.LogKV("topic", req.Topic, "partition", req.Partition, "err", err)
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	if req.BaseOffset < 0 {
+		sealed, err := l.SealedSegments()
+		if err != nil {
+			b.logFailed(req.Topic, req.Partition, err)
+			resp.ErrorCode = protocol.ErrKafkaStorageError.Code()
+			return resp
+		}
+		resp.LogStartOffset = replica.Log.OldestOffset()
+		for _, s := range sealed {
+			resp.Segments = append(resp.Segments, protocol.SealedSegment{
+				BaseOffset: s.BaseOffset,
+				NextOffset: s.NextOffset,
+				Size:       s.Size,
+				Checksum:   s.Checksum,
+			})
+		}
+		for _, e := range l.Epochs() {
+			resp.Epochs = append(resp.Epochs, protocol.SegmentEpoch{Epoch: e.Epoch, StartOffset: e.StartOffset})
+		}
+		return resp
+	}
+	maxBytes := req.MaxBytes
+	if maxBytes <= 0 || maxBytes > segmentReadMaxBytes {
+		maxBytes = segmentReadMaxBytes
+	}
+	p := make([]byte, maxBytes)
+	n, rerr := l.ReadSegment(req.BaseOffset, req.Position, p)
+	switch {
+	case rerr == commitlog.ErrSegmentNotFound:
+		// the segment's been deleted or compacted since it was listed
+		resp.ErrorCode = protocol.ErrOffsetOutOfRange.Code()
+	case rerr != nil && rerr != io.EOF:
+		b.logFailed(req.Topic, req.Partition, rerr)
+		resp.ErrorCode = protocol.ErrKafkaStorageError.Code()
+	default:
+		resp.Bytes = p[:n]
+	}
+	return resp
+}
+
+// snapshotReplica returns the partition's replica and its log, which this broker must lead,
+// after checking the current leader epoch.
+func (b *Broker) snapshotReplica(topic string, partition, currentEpoch int32) (*Replica, snapshotLog, protocol.Error) {
+	replica, err := b.replicaLookup.Replica(topic, partition)
+	if err != nil {
+		return nil, nil, protocol.ErrUnknownTopicOrPartition
+	}
+	if replica.Partition.Leader != b.config.ID {
+		return nil, nil, protocol.ErrNotLeaderForPartition
+	}
+	if err := checkLeaderEpoch(currentEpoch, replica.Partition.LeaderEpoch); err != protocol.ErrNone {
+		return nil, nil, err
+	}
+	if replica.Log == nil {
+		return nil, nil, protocol.ErrReplicaNotAvailable
+	}
+	if b.breakers.get(topic, partition).isOpen() {
+		return nil, nil, protocol.ErrKafkaStorageError
+	}
+	l, ok := replica.Log.(snapshotLog)
+	if !ok {
+		return nil, nil, protocol.ErrUnsupportedVersion
+	}
+	return replica, l, protocol.ErrNone
+}
+
+// snapshotFromLeader copies the leader's sealed segments from the one with the follower's newest
+// offset on whole, rather than fetching their messages, if they're at least SnapshotMinBytes, like
+// for a new replica of a big partition. The follower's messages in the first of them are
+// truncated and replaced by the leader's. The segments' checksums are checked before they're
+// appended, and the follower fetches the messages instead if copying them fails.
+func (r *Replicator) snapshotFromLeader() {
+	if r.config.SnapshotMinBytes <= 0 {
+		return
+	}
+	leader, ok := r.leader.(segmentClient)
+	if !ok {
+		return
+	}
+	l, ok := r.replica.Log.(snapshotLog)
+	if !ok {
+		return
+	}
+	if err := r.snapshot(leader, l); err != nil && err != errReplicatorClosed {
+		r.logger.Error("failed to copy leader's segments, fetching their messages instead", log.Error("error", err))
+	}
+}
+
+func (r *Replicator) snapshot(leader segmentClient, l snapshotLog) error {
+	listed, err := r.fetchSegment(leader, -1, 0)
+	if err != nil {
+		return err
+	}
+	newest := r.replica.Log.NewestOffset()
+	var sealed []commitlog.SealedSegment
+	var behind int64
+	for _, s := range listed.Segments {
+		if s.NextOffset <= newest {
+			continue
+		}
+		sealed = append(sealed, commitlog.SealedSegment{
+			BaseOffset: s.BaseOffset,
+			NextOffset: s.NextOffset,
+			Size:       s.Size,
+			Checksum:   s.Checksum,
+		})
+		behind += s.Size
+	}
+	if len(sealed) == 0 || behind < r.config.SnapshotMinBytes {
+		return nil
+	}
+	start := time.Now()
+	r.logger.Info("copying leader's segments", log.Int64("offset", sealed[0].BaseOffset), log.Int("segments", len(sealed)), log.Int64("bytes", behind))
+	dir, err := l.SnapshotDir()
+	if err != nil {
+		return err
+	}
+	for _, s := range sealed {
+		if err := r.downloadSegment(leader, dir, s); err != nil {
+			return err
+		}
+	}
+	if sealed[0].BaseOffset < newest {
+		if err := l.TruncateTo(sealed[0].BaseOffset); err != nil {
+			return err
+		}
+	}
+	if err := l.AppendSegments(dir, sealed); err != nil {
+		return err
+	}
+	// the epochs the copied messages were appended in, which appending batches assigns
+	if el, ok := r.replica.Log.(epochLog); ok {
+		end := sealed[len(sealed)-1].NextOffset
+		for _, e := range listed.Epochs {
+			if e.StartOffset >= end {
+				break
+			}
+			offset := e.StartOffset
+			if offset < sealed[0].BaseOffset {
+				offset = sealed[0].BaseOffset
+			}
+			if err := el.AssignEpoch(e.Epoch, offset); err != nil {
+				return err
+			}
+		}
+	}
+	r.deleteRecords(listed.LogStartOffset)
+	if err := r.replay(sealed[0].BaseOffset); err != nil {
+		return err
+	}
+	r.offset = r.replica.Log.NewestOffset()
+	r.logger.Info("copied leader's segments", log.Int64("offset", r.offset), log.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// downloadSegment copies the log of the leader's segment to dir.
+func (r *Replicator) downloadSegment(leader segmentClient, dir string, s commitlog.SealedSegment) error {
+	f, err := os.Create(commitlog.SegmentLogPath(dir, s.BaseOffset))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for position := int64(0); position < s.Size; {
+		select {
+		case <-r.done:
+			return errReplicatorClosed
+		default:
+		}
+		resp, err := r.fetchSegment(leader, s.BaseOffset, position)
+		if err != nil {
+			return err
+		}
+		if len(resp.Bytes) == 0 {
+			return fmt.Errorf("segment %d ended at position %d, expected %d bytes", s.BaseOffset, position, s.Size)
+		}
+		b := resp.Bytes
+		if rest := s.Size - position; int64(len(b)) > rest {
+			b = b[:rest]
+		}
+		if _, err := f.Write(b); err != nil {
+			return err
+		}
+		position += int64(len(b))
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// fetchSegment lists the leader's sealed segments if the base offset is -1, otherwise it reads
+// the log of the segment with it from the position.
+func (r *Replicator) fetchSegment(leader segmentClient, baseOffset, position int64) (*protocol.FetchSegmentResponse, error) {
+	maxBytes := r.config.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = segmentReadDefaultBytes
+	}
+	resp, err := leader.FetchSegment(&protocol.FetchSegmentRequest{
+		ReplicaID:          r.replica.BrokerID,
+		Topic:              r.replica.Partition.Topic,
+		Partition:          r.replica.Partition.ID,
+		CurrentLeaderEpoch: r.config.LeaderEpoch,
+		BaseOffset:         baseOffset,
+		Position:           position,
+		MaxBytes:           maxBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != protocol.ErrNone.Code() {
+		return nil, protocol.Errs[resp.ErrorCode]
+	}
+	return resp, nil
+}
+
+// replay calls Appended with the record sets from the offset on, which were copied from the
+// leader rather than appended, so what's kept about the record sets followers append, like the
+// state of their producers, covers them.
+func (r *Replicator) replay(offset int64) error {
+	if r.config.Appended == nil {
+		return nil
+	}
+	reader, err := r.replica.Log.NewReader(offset, 0)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		recordSet := make([]byte, 12+int(protocol.Encoding.Uint32(header[8:])))
+		copy(recordSet, header)
+		if _, err := io.ReadFull(reader, recordSet[12:]); err != nil {
+			return err
+		}
+		r.config.Appended(int64(protocol.Encoding.Uint64(recordSet)), recordSet)
+	}
+}
@@ -33,9 +33,13 @@ func (b *Broker) setupSerf(config *serf.Config, ch chan serf.Event, path string)
 	config.Tags["raft_addr"] = b.config.RaftAddr
 	config.Tags["serf_lan_addr"] = fmt.Sprintf("%s:%d", b.config.SerfLANConfig.MemberlistConfig.BindAddr, b.config.SerfLANConfig.MemberlistConfig.BindPort)
 	config.Tags["broker_addr"] = b.config.Addr
+	if b.config.Rack != "" {
+		config.Tags["rack"] = b.config.Rack
+	}
+	b.setLifecycleTags(config.Tags)
 	config.EventCh = ch
 	config.EnableNameConflictResolution = false
-	if !b.config.DevMode {
+	if !b.config.DevMode && config.SnapshotPath == "" {
 		config.SnapshotPath = filepath.Join(b.config.DataDir, path)
 	}
 	if err := ensurePath(config.SnapshotPath, false); err != nil {
@@ -57,6 +61,8 @@ func (b *Broker) lanEventHandler() {
 			case serf.EventMemberLeave, serf.EventMemberFailed:
 				b.lanNodeFailed(e.(serf.MemberEvent))
 				b.localMemberEvent(e.(serf.MemberEvent))
+			case serf.EventUser:
+				b.localUserEvent(e.(serf.UserEvent))
 			}
 		case <-b.shutdownCh:
 			return
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	opentracing "github.com/opentracing/opentracing-go"
@@ -22,6 +23,8 @@ var (
 	serverVerboseLogs    bool
 	requestQueueSpanKey  = contextKey("request queue span key")
 	responseQueueSpanKey = contextKey("response queue span key")
+	requestStartKey      = contextKey("request start key")
+	responseWrittenKey   = contextKey("response written key")
 )
 
 func init() {
@@ -54,6 +57,24 @@ type Server struct {
 	responseCh   chan *Context
 	tracer       opentracing.Tracer
 	close        func() error
+
+	// stopAcceptingCh is closed when the server stops accepting conns, before the handler's
+	// shut down and shutdownCh is closed.
+	stopAcceptingCh chan struct{}
+
+	// controlRequestCh queues inter-broker requests (replica fetches, leader and isr, etc.)
+	// which are passed on to the handler before any queued client requests.
+	controlRequestCh chan *Context
+	clientRequestCh  chan *Context
+
+	// saslMechanisms are the mechanisms clients authenticate with, none if they don't.
+	saslMechanisms []SASLMechanism
+	// writeLock serializes writing responses, which are written by the response loop and,
+	// for the SASL exchange, by the conns' request loops.
+	writeLock sync.Mutex
+
+	// conns are the open client conns, tracked for the admin API.
+	conns *connections
 }
 
 func NewServer(config *config.Config, handler Handler, metrics *Metrics, tracer opentracing.Tracer, close func() error, logger log.Logger) *Server {
@@ -63,15 +84,28 @@ func NewServer(config *config.Config, handler Handler, metrics *Metrics, tracer
 		logger:     logger.With(log.Int32("server id", config.ID), log.String("addr", config.Addr)),
 		metrics:    metrics,
 		shutdownCh: make(chan struct{}),
-		requestCh:  make(chan *Context, 32),
+		requestCh:  make(chan *Context),
 		responseCh: make(chan *Context, 32),
 		tracer:     tracer,
 		close:      close,
+
+		controlRequestCh: make(chan *Context, 32),
+		clientRequestCh:  make(chan *Context, 32),
+		stopAcceptingCh:  make(chan struct{}),
+
+		conns: newConnections(),
 	}
 	s.logger.Info("hello")
 	return s
 }
 
+// EnableSASL makes clients authenticate with one of the mechanisms before they can send
+// requests other than ApiVersions. It must be called before Start. Brokers don't authenticate
+// their connections to each other yet.
+func (s *Server) EnableSASL(mechanisms ...SASLMechanism) {
+	s.saslMechanisms = mechanisms
+}
+
 // Start starts the service.
 func (s *Server) Start(ctx context.Context) error {
 	protocolAddr, err := net.ResolveTCPAddr("tcp", s.config.Addr)
@@ -86,15 +120,24 @@ func (s *Server) Start(ctx context.Context) error {
 		for {
 			select {
 			case <-ctx.Done():
-				break
-			case <-s.shutdownCh:
-				break
+				return
+			case <-s.stopAcceptingCh:
+				return
 			default:
 				conn, err := s.protocolLn.Accept()
 				if err != nil {
+					select {
+					case <-s.stopAcceptingCh:
+						// the listener's been closed
+						return
+					default:
+					}
 					s.logger.Error("listener accept failed", log.Error("error", err))
 					continue
 				}
+				if err := setSocketOptions(conn, s.config.ClientSocket); err != nil {
+					s.logger.Error("failed to set socket options", log.Error("error", err))
+				}
 
 				go s.handleRequest(conn)
 			}
@@ -105,9 +148,9 @@ func (s *Server) Start(ctx context.Context) error {
 		for {
 			select {
 			case <-ctx.Done():
-				break
+				return
 			case <-s.shutdownCh:
-				break
+				return
 			case respCtx := <-s.responseCh:
 				if queueSpan, ok := respCtx.Value(responseQueueSpanKey).(opentracing.Span); ok {
 					queueSpan.Finish()
@@ -119,32 +162,116 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 	}()
 
+	go s.dispatchRequests(ctx)
+
 	go s.handler.Run(ctx, s.requestCh, s.responseCh)
 
 	return nil
 }
 
-// Shutdown closes the service.
-func (s *Server) Shutdown() {
+// controlRequestsPerClientRequest is how many control requests in a row dispatchRequests
+// passes on before letting a waiting client request through.
+const controlRequestsPerClientRequest = 8
+
+// dispatchRequests passes queued requests on to the handler, preferring control requests
+// over client requests so a flood of client traffic can't starve replication. The preference
+// is bounded so a busy replica fetch loop can't starve clients either.
+func (s *Server) dispatchRequests(ctx context.Context) {
+	var controlRun int
+	for {
+		var reqCtx *Context
+		if controlRun >= controlRequestsPerClientRequest {
+			select {
+			case reqCtx = <-s.clientRequestCh:
+			default:
+			}
+		}
+		if reqCtx == nil {
+			select {
+			case reqCtx = <-s.controlRequestCh:
+			default:
+				select {
+				case <-ctx.Done():
+					return
+				case <-s.shutdownCh:
+					return
+				case reqCtx = <-s.controlRequestCh:
+				case reqCtx = <-s.clientRequestCh:
+				}
+			}
+		}
+		if isControlRequest(reqCtx.req) {
+			controlRun++
+		} else {
+			controlRun = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdownCh:
+			return
+		case s.requestCh <- reqCtx:
+		}
+	}
+}
+
+// isControlRequest returns whether the request is inter-broker, control plane traffic.
+func isControlRequest(req interface{}) bool {
+	switch r := req.(type) {
+	case *protocol.LeaderAndISRRequest, *protocol.StopReplicaRequest, *protocol.UpdateMetadataRequest, *protocol.ControlledShutdownRequest, *protocol.AlterPartitionRequest:
+		return true
+	case *protocol.FetchRequest:
+		// replica id is -1 for clients
+		return r.ReplicaID >= 0
+	}
+	return false
+}
+
+// isInterBroker returns whether the request was sent by another broker. It's best effort: past
+// control requests it goes by the client ID, which any client can take, so it only picks the
+// socket options, priority and draining of the conn and mustn't be used to authorize anything.
+func isInterBroker(header *protocol.RequestHeader, req interface{}) bool {
+	if isControlRequest(req) {
+		return true
+	}
+	return strings.HasPrefix(header.ClientID, replicatorClientIDPrefix) || strings.HasPrefix(header.ClientID, brokerClientIDPrefix)
+}
+
+// Shutdown closes the service. It stops accepting conns first and keeps writing responses
+// until the handler's shut down, so the requests it drains while shutting down are responded to.
+// It returns the handler's error shutting down.
+func (s *Server) Shutdown() error {
 	s.shutdownLock.Lock()
 	defer s.shutdownLock.Unlock()
 
 	if s.shutdown {
-		return
+		return nil
 	}
 
 	s.shutdown = true
-	close(s.shutdownCh)
-
-	s.handler.Shutdown()
+	close(s.stopAcceptingCh)
 	s.protocolLn.Close()
 
+	err := s.handler.Shutdown()
+	close(s.shutdownCh)
+
 	s.close()
+	return err
 }
 
 func (s *Server) handleRequest(conn net.Conn) {
 	defer conn.Close()
+	c := s.conns.add(conn)
+	s.setActiveConnections()
+	defer func() {
+		s.conns.remove(c)
+		s.setActiveConnections()
+	}()
 
+	// conns are accepted with the client socket options and switched to the replica socket
+	// options once they turn out to be from another broker
+	interBroker := false
+	sasl := newSASLState(s.saslMechanisms)
 	p := make([]byte, 4)
 	for {
 		_, err := io.ReadFull(conn, p[:])
@@ -156,6 +283,7 @@ func (s *Server) handleRequest(conn net.Conn) {
 			break
 		}
 
+		start := time.Now()
 		span := s.tracer.StartSpan("request")
 		decodeSpan := s.tracer.StartSpan("server: decode request", opentracing.ChildOf(span.Context()))
 
@@ -163,6 +291,13 @@ func (s *Server) handleRequest(conn net.Conn) {
 		if size == 0 {
 			break // TODO: should this even happen?
 		}
+		if s.config.SocketRequestMaxBytes > 0 && int64(size) > int64(s.config.SocketRequestMaxBytes) {
+			// check before allocating, the size can't be trusted
+			s.logger.Error("request larger than max request size, closing conn", log.Uint32("size", size), log.Int("max size", s.config.SocketRequestMaxBytes))
+			span.LogKV("msg", "request too large", "size", size)
+			span.Finish()
+			break
+		}
 
 		b := make([]byte, size+4) //+4 since we're going to copy the size into b
 		copy(b, p)
@@ -183,6 +318,8 @@ func (s *Server) handleRequest(conn net.Conn) {
 			panic(err)
 		}
 
+		c.request(header, len(b), sasl.principal)
+
 		span.SetTag("api_key", header.APIKey)
 		span.SetTag("correlation_id", header.CorrelationID)
 		span.SetTag("client_id", header.ClientID)
@@ -190,65 +327,76 @@ func (s *Server) handleRequest(conn net.Conn) {
 		span.SetTag("node_id", s.config.ID) // can I set this globally for the tracer?
 		span.SetTag("addr", s.config.Addr)
 
-		var req protocol.VersionedDecoder
-
-		switch header.APIKey {
-		case protocol.ProduceKey:
-			req = &protocol.ProduceRequest{}
-		case protocol.FetchKey:
-			req = &protocol.FetchRequest{}
-		case protocol.OffsetsKey:
-			req = &protocol.OffsetsRequest{}
-		case protocol.MetadataKey:
-			req = &protocol.MetadataRequest{}
-		case protocol.LeaderAndISRKey:
-			req = &protocol.LeaderAndISRRequest{}
-		case protocol.StopReplicaKey:
-			req = &protocol.StopReplicaRequest{}
-		case protocol.UpdateMetadataKey:
-			req = &protocol.UpdateMetadataRequest{}
-		case protocol.ControlledShutdownKey:
-			req = &protocol.ControlledShutdownRequest{}
-		case protocol.OffsetCommitKey:
-			req = &protocol.OffsetCommitRequest{}
-		case protocol.OffsetFetchKey:
-			req = &protocol.OffsetFetchRequest{}
-		case protocol.FindCoordinatorKey:
-			req = &protocol.FindCoordinatorRequest{}
-		case protocol.JoinGroupKey:
-			req = &protocol.JoinGroupRequest{}
-		case protocol.HeartbeatKey:
-			req = &protocol.HeartbeatRequest{}
-		case protocol.LeaveGroupKey:
-			req = &protocol.LeaveGroupRequest{}
-		case protocol.SyncGroupKey:
-			req = &protocol.SyncGroupRequest{}
-		case protocol.DescribeGroupsKey:
-			req = &protocol.DescribeGroupsRequest{}
-		case protocol.ListGroupsKey:
-			req = &protocol.ListGroupsRequest{}
-		case protocol.SaslHandshakeKey:
-			req = &protocol.SaslHandshakeRequest{}
-		case protocol.APIVersionsKey:
-			req = &protocol.APIVersionsRequest{}
-		case protocol.CreateTopicsKey:
-			req = &protocol.CreateTopicRequests{}
-		case protocol.DeleteTopicsKey:
-			req = &protocol.DeleteTopicsRequest{}
+		req, perr := protocol.NewRequest(header.APIKey, header.APIVersion)
+		if perr == protocol.ErrUnsupportedVersion && header.APIKey == protocol.APIVersionsKey {
+			// clients send their newest ApiVersions request first and expect an error
+			// response, rather than a closed conn, if it's newer than we implement
+			req, perr = &protocol.APIVersionsRequest{}, protocol.ErrNone
+		}
+		if perr != protocol.ErrNone {
+			s.logger.Error("unsupported request, closing conn", log.Any("header", header))
+			span.LogKV("msg", "unsupported request", "api_key", header.APIKey, "api_version", header.APIVersion)
+			span.Finish()
+			break
 		}
 
 		if err := req.Decode(d, header.APIVersion); err != nil {
-			s.logger.Error("failed to decode request", log.Error("err", err), log.Any("header", header))
+			s.logger.Error("failed to decode request, closing conn", log.Error("err", err), log.Any("header", header))
 			span.LogKV("msg", "failed to decode request", "err", err)
 			span.Finish()
-			panic(err)
+			break
 		}
 
 		decodeSpan.Finish()
 
-		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		if !sasl.allowed(req) {
+			s.logger.Error("request sent before authenticating, closing conn", log.Any("header", header))
+			span.LogKV("msg", "request sent before authenticating")
+			span.Finish()
+			break
+		}
+		// the SASL exchange is handled here rather than by the handler since it changes the
+		// conn's state, which has to be done before reading the next request.
+		var saslResp protocol.ResponseBody
+		closeConn := false
+		switch r := req.(type) {
+		case *protocol.SaslHandshakeRequest:
+			saslResp = sasl.handshake(r)
+		case *protocol.SaslAuthenticateRequest:
+			saslResp, closeConn = sasl.authenticate(r)
+		}
+		if saslResp != nil {
+			respCtx := &Context{
+				parent: s.requestContext(span, c, start),
+				conn:   conn,
+				header: header,
+				res:    &protocol.Response{CorrelationID: header.CorrelationID, Body: saslResp},
+			}
+			if err := s.handleResponse(respCtx); err != nil {
+				s.logger.Error("failed to write response", log.Error("error", err))
+				break
+			}
+			if closeConn {
+				s.logger.Info("sasl authentication failed, closing conn", log.String("client id", header.ClientID))
+				break
+			}
+			continue
+		}
+
+		if !interBroker && isInterBroker(header, req) {
+			interBroker = true
+			c.setInterBroker()
+			if err := setSocketOptions(conn, s.config.ReplicaSocket); err != nil {
+				s.logger.Error("failed to set socket options", log.Error("error", err))
+			}
+		}
+
+		ctx := s.requestContext(span, c, start)
+		ctx = withPrincipal(ctx, sasl.principal, sasl.tokenID)
 		queueSpan := s.tracer.StartSpan("server: queue request", opentracing.ChildOf(span.Context()))
 		ctx = context.WithValue(ctx, requestQueueSpanKey, queueSpan)
+		written := make(chan struct{})
+		ctx = context.WithValue(ctx, responseWrittenKey, written)
 
 		reqCtx := &Context{
 			parent: ctx,
@@ -259,7 +407,18 @@ func (s *Server) handleRequest(conn net.Conn) {
 
 		s.vlog(span, "handling request", "request", reqCtx)
 
-		s.requestCh <- reqCtx
+		if isControlRequest(req) {
+			s.controlRequestCh <- reqCtx
+		} else {
+			s.clientRequestCh <- reqCtx
+		}
+		// the conn's next request is read once this one's responded to, so its responses are
+		// written in order while the handler handles requests concurrently
+		select {
+		case <-written:
+		case <-s.shutdownCh:
+			return
+		}
 	}
 }
 
@@ -269,14 +428,40 @@ func (s *Server) handleResponse(respCtx *Context) error {
 	s.vlog(sp, "handling response", "response", respCtx)
 	defer psp.Finish()
 	defer sp.Finish()
-	b, err := protocol.Encode(respCtx.res.(protocol.Encoder))
+	if written, ok := respCtx.Value(responseWrittenKey).(chan struct{}); ok {
+		defer close(written)
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	// record sets are written from where they are rather than copied into one big response
+	bufs, err := protocol.EncodeBuffers(respCtx.res.(protocol.Encoder))
 	if err != nil {
 		return err
 	}
-	_, err = respCtx.conn.Write(b)
+	n, err := bufs.WriteTo(respCtx.conn)
+	if c, ok := respCtx.Value(connectionKey).(*connection); ok {
+		c.response(n)
+	}
+	if start, ok := respCtx.Value(requestStartKey).(time.Time); ok {
+		s.metrics.requestHandled(respCtx.header, respCtx.res.(*protocol.Response).Body, time.Since(start))
+	}
 	return err
 }
 
+// requestContext returns the context of a request read from the conn at start.
+func (s *Server) requestContext(span opentracing.Span, c *connection, start time.Time) context.Context {
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	ctx = context.WithValue(ctx, connectionKey, c)
+	return context.WithValue(ctx, requestStartKey, start)
+}
+
+// setActiveConnections updates the active connections gauge, if metrics are being tracked.
+func (s *Server) setActiveConnections() {
+	if s.metrics != nil && s.metrics.ActiveConnections != nil {
+		s.metrics.ActiveConnections.Set(float64(s.conns.len()))
+	}
+}
+
 // Addr returns the address on which the Server is listening
 func (s *Server) Addr() net.Addr {
 	return s.protocolLn.Addr()
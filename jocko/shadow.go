@@ -0,0 +1,394 @@
+package jocko
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// shadowFetchMaxBytes is the most read back from a partition of the shadowed cluster at a time.
+const shadowFetchMaxBytes = 1024 * 1024
+
+// ShadowConfig configures a Shadow.
+type ShadowConfig struct {
+	// Brokers are the bootstrap addresses of the external cluster.
+	Brokers []string
+	// QueueSize is how many record sets can wait to be forwarded before more are dropped.
+	QueueSize int
+	// VerifyInterval is how often forwarded record sets are read back and compared.
+	VerifyInterval time.Duration
+	// Timeout bounds each request to the external cluster.
+	Timeout time.Duration
+	// Topics, if set, only forwards the record sets produced to topics whose names it matches,
+	// including topics created later. Otherwise every topic's are forwarded.
+	Topics *regexp.Regexp
+	// OffsetSyncsTopic, if set, is the topic on the cluster offset syncs are written to, so
+	// consumers' offsets can be translated for them to fail over to the cluster.
+	OffsetSyncsTopic string
+}
+
+// Shadow is a RecordSetInterceptor dual-writing the record sets produced to the broker to the
+// same partitions of an external Kafka cluster, so jocko can be validated on production traffic
+// before cutting over. Record sets are forwarded in the background in the order they're
+// appended, and dropped rather than holding up produces when the cluster falls behind. Those the
+// cluster acks are periodically read back and their CRCs compared with the ones jocko wrote.
+//
+// The CRCs only match if the cluster stores record sets as they're produced, so its topics need
+// compression.type=producer, CreateTime timestamps and a message format no older than the
+// producers'. The topics must exist on the cluster with at least as many partitions, or be auto
+// created.
+type Shadow struct {
+	config  ShadowConfig
+	metrics *Metrics
+	logger  log.Logger
+
+	queue      chan shadowRecordSet
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+
+	// only used by run
+	cluster *clusterClient
+
+	mu         sync.Mutex
+	partitions map[topicPartition]*shadowPartition
+	// offsetSyncs are the syncs waiting to be written to the offset syncs topic.
+	offsetSyncs []ShadowOffsetSync
+}
+
+type shadowRecordSet struct {
+	topicPartition
+	recordSet []byte
+}
+
+// shadowChunk is a record set the cluster acked that hasn't been verified yet, with the offset
+// the cluster appended it at and the CRCs of its entries.
+type shadowChunk struct {
+	offset int64
+	crcs   []uint32
+}
+
+type shadowPartition struct {
+	pending []shadowChunk
+	status  ShadowPartitionStatus
+	// syncDelta is the difference between the cluster's and jocko's offsets as of the
+	// partition's last offset sync, if it's synced.
+	synced    bool
+	syncDelta int64
+}
+
+// ShadowPartitionStatus describes the shadowing of a partition. The counts are of record sets,
+// and the checksums are running CRCs of the entries verified so far, as jocko wrote them and as
+// the cluster stored them, so they're equal as long as nothing's mismatched.
+type ShadowPartitionStatus struct {
+	Topic          string `json:"topic"`
+	Partition      int32  `json:"partition"`
+	Forwarded      int64  `json:"forwarded"`
+	Dropped        int64  `json:"dropped"`
+	Failed         int64  `json:"failed"`
+	Pending        int    `json:"pending"`
+	Verified       int64  `json:"verified"`
+	Mismatched     int64  `json:"mismatched"`
+	Checksum       uint32 `json:"checksum"`
+	RemoteChecksum uint32 `json:"remote_checksum"`
+}
+
+// NewShadow returns a Shadow forwarding to the cluster, running until it's closed. Metrics may
+// be nil.
+func NewShadow(config ShadowConfig, dialer *Dialer, metrics *Metrics, logger log.Logger) *Shadow {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10000
+	}
+	if config.VerifyInterval <= 0 {
+		config.VerifyInterval = time.Minute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	s := &Shadow{
+		config:     config,
+		metrics:    metrics,
+		logger:     logger,
+		queue:      make(chan shadowRecordSet, config.QueueSize),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+		cluster:    newClusterClient(config.Brokers, dialer, config.Timeout),
+		partitions: make(map[topicPartition]*shadowPartition),
+	}
+	go s.run()
+	return s
+}
+
+// OnAppend isn't called since the shadow's a RecordSetInterceptor.
+func (s *Shadow) OnAppend(topic string, partition int32, batches []*protocol.RecordBatch) {}
+
+// OnAppendRecordSet queues a copy of the record set to be forwarded, or drops it if the queue's
+// full. Record sets of topics that aren't shadowed are ignored.
+func (s *Shadow) OnAppendRecordSet(topic string, partition int32, recordSet []byte) {
+	if s.config.Topics != nil && !s.config.Topics.MatchString(topic) {
+		return
+	}
+	tp := topicPartition{topic: topic, partition: partition}
+	select {
+	case s.queue <- shadowRecordSet{topicPartition: tp, recordSet: append([]byte(nil), recordSet...)}:
+	default:
+		s.mu.Lock()
+		s.partition(tp).status.Dropped++
+		s.mu.Unlock()
+		s.count(topic, "dropped")
+	}
+}
+
+// Status returns the status of the partitions record sets have been produced to, ordered by
+// topic and partition.
+func (s *Shadow) Status() []ShadowPartitionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]ShadowPartitionStatus, 0, len(s.partitions))
+	for _, p := range s.partitions {
+		status := p.status
+		status.Pending = len(p.pending)
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Topic != statuses[j].Topic {
+			return statuses[i].Topic < statuses[j].Topic
+		}
+		return statuses[i].Partition < statuses[j].Partition
+	})
+	return statuses
+}
+
+// Close stops forwarding, dropping the queued record sets, and closes the conns to the cluster.
+func (s *Shadow) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.shutdownCh)
+	})
+	<-s.doneCh
+	return nil
+}
+
+func (s *Shadow) run() {
+	defer close(s.doneCh)
+	ticker := time.NewTicker(s.config.VerifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdownCh:
+			s.cluster.close()
+			return
+		case rs := <-s.queue:
+			s.forward(rs)
+		case <-ticker.C:
+			s.writeOffsetSyncs()
+			s.verify()
+		}
+	}
+}
+
+// forward produces the record set to the cluster, trying again once with fresh metadata in case
+// the partition's leader moved.
+func (s *Shadow) forward(rs shadowRecordSet) {
+	entries := protocol.RecordSetEntries(rs.recordSet)
+	version := produceVersion(rs.recordSet)
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var offset int64
+		if offset, err = s.produce(rs, version); err == nil {
+			s.acked(rs.topicPartition, offset, entries)
+			return
+		}
+		s.cluster.failed(rs.topicPartition, err)
+	}
+	s.mu.Lock()
+	s.partition(rs.topicPartition).status.Failed++
+	s.mu.Unlock()
+	s.count(rs.topic, "failed")
+	s.logger.Error("shadow: failed to forward record set", log.String("topic", rs.topic), log.Int32("partition", rs.partition), log.Error("error", err))
+}
+
+func (s *Shadow) produce(rs shadowRecordSet, version int16) (int64, error) {
+	conn, err := s.cluster.leader(rs.topicPartition)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := conn.Produce(&protocol.ProduceRequest{
+		APIVersion: version,
+		Acks:       -1,
+		Timeout:    s.config.Timeout,
+		TopicData: []*protocol.TopicData{{
+			Topic: rs.topic,
+			Data:  []*protocol.Data{{Partition: rs.partition, RecordSet: rs.recordSet}},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Responses) != 1 || len(resp.Responses[0].PartitionResponses) != 1 {
+		return 0, protocol.ErrUnknown
+	}
+	p := resp.Responses[0].PartitionResponses[0]
+	if p.ErrorCode != protocol.ErrNone.Code() {
+		return 0, protocol.Errs[p.ErrorCode]
+	}
+	return p.BaseOffset, nil
+}
+
+// acked records the record set the cluster appended at offset to be verified.
+func (s *Shadow) acked(tp topicPartition, offset int64, entries []protocol.RecordSetEntry) {
+	chunk := shadowChunk{offset: offset, crcs: make([]uint32, len(entries))}
+	for i, e := range entries {
+		chunk.crcs[i] = e.CRC
+	}
+	s.mu.Lock()
+	p := s.partition(tp)
+	p.status.Forwarded++
+	p.pending = append(p.pending, chunk)
+	if len(entries) > 0 {
+		s.recordOffsetSync(tp, entries[0].Offset, offset)
+	}
+	s.mu.Unlock()
+	s.count(tp.topic, "forwarded")
+}
+
+// verify reads back the record sets pending verification in each partition and compares them.
+func (s *Shadow) verify() {
+	s.mu.Lock()
+	var tps []topicPartition
+	for tp, p := range s.partitions {
+		if len(p.pending) > 0 {
+			tps = append(tps, tp)
+		}
+	}
+	s.mu.Unlock()
+	for _, tp := range tps {
+		if err := s.verifyPartition(tp); err != nil {
+			s.cluster.failed(tp, err)
+			s.logger.Error("shadow: failed to verify partition", log.String("topic", tp.topic), log.Int32("partition", tp.partition), log.Error("error", err))
+		}
+	}
+}
+
+// verifyPartition fetches from the partition's first pending record set until there are no
+// more pending or a fetch doesn't get any further.
+func (s *Shadow) verifyPartition(tp topicPartition) error {
+	for {
+		s.mu.Lock()
+		p := s.partitions[tp]
+		if len(p.pending) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		offset := p.pending[0].offset
+		s.mu.Unlock()
+
+		conn, err := s.cluster.leader(tp)
+		if err != nil {
+			return err
+		}
+		resp, err := conn.Fetch(&protocol.FetchRequest{
+			APIVersion: 4,
+			ReplicaID:  -1,
+			// the record sets were acked so they're there to read without waiting
+			MinBytes:    1,
+			MaxWaitTime: 100,
+			MaxBytes:    shadowFetchMaxBytes,
+			Topics: []*protocol.FetchTopic{{
+				Topic:      tp.topic,
+				Partitions: []*protocol.FetchPartition{{Partition: tp.partition, FetchOffset: offset, MaxBytes: shadowFetchMaxBytes}},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		if len(resp.Responses) != 1 || len(resp.Responses[0].PartitionResponses) != 1 {
+			return protocol.ErrUnknown
+		}
+		fp := resp.Responses[0].PartitionResponses[0]
+		switch fp.ErrorCode {
+		case protocol.ErrNone.Code():
+		case protocol.ErrOffsetOutOfRange.Code():
+			// removed by the cluster's retention before they could be verified
+			s.mu.Lock()
+			n := len(p.pending)
+			p.pending = nil
+			s.mu.Unlock()
+			s.logger.Info("shadow: record sets removed before they were verified", log.String("topic", tp.topic), log.Int32("partition", tp.partition), log.Int("record sets", n))
+			return nil
+		default:
+			return protocol.Errs[fp.ErrorCode]
+		}
+		if !s.compare(tp, protocol.RecordSetEntries(fp.RecordSet)) {
+			return nil
+		}
+	}
+}
+
+// compare verifies the pending record sets of the partition the fetched entries cover,
+// returning whether any were.
+func (s *Shadow) compare(tp topicPartition, entries []protocol.RecordSetEntry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partitions[tp]
+	compared := false
+	for len(p.pending) > 0 {
+		chunk := p.pending[0]
+		// the first entry at or past the chunk's offset is its first, compressed v0/v1 messages
+		// have the offset of the last message they wrap
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].Offset >= chunk.offset })
+		if len(entries)-i < len(chunk.crcs) {
+			break
+		}
+		remote := entries[i : i+len(chunk.crcs)]
+		entries = entries[i+len(chunk.crcs):]
+		matched := true
+		for j, crc := range chunk.crcs {
+			p.status.Checksum = updateShadowChecksum(p.status.Checksum, crc)
+			p.status.RemoteChecksum = updateShadowChecksum(p.status.RemoteChecksum, remote[j].CRC)
+			if remote[j].CRC != crc {
+				matched = false
+			}
+		}
+		if matched {
+			p.status.Verified++
+		} else {
+			p.status.Mismatched++
+			if s.metrics != nil {
+				s.metrics.ShadowMismatches.With("topic", tp.topic, "partition", strconv.Itoa(int(tp.partition))).Add(1)
+			}
+			s.logger.Error("shadow: record set checksums mismatched", log.String("topic", tp.topic), log.Int32("partition", tp.partition), log.Int64("offset", chunk.offset))
+		}
+		p.pending = p.pending[1:]
+		compared = true
+	}
+	return compared
+}
+
+func updateShadowChecksum(sum, crc uint32) uint32 {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], crc)
+	return crc32.Update(sum, crc32.IEEETable, b[:])
+}
+
+// partition returns the partition's state, adding it if it's new. s.mu must be held.
+func (s *Shadow) partition(tp topicPartition) *shadowPartition {
+	p, ok := s.partitions[tp]
+	if !ok {
+		p = &shadowPartition{status: ShadowPartitionStatus{Topic: tp.topic, Partition: tp.partition}}
+		s.partitions[tp] = p
+	}
+	return p
+}
+
+func (s *Shadow) count(topic, result string) {
+	if s.metrics != nil {
+		s.metrics.ShadowRecordSets.With("topic", topic, "result", result).Add(1)
+	}
+}
@@ -0,0 +1,312 @@
+package jocko
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// ShadowOffsetSync maps an offset of a partition on jocko to the offset its record set was
+// forwarded to on the shadowed cluster. The partition's later offsets map with the same
+// difference up to its next sync, which is only written once the difference changes, like when
+// record sets are dropped rather than forwarded.
+type ShadowOffsetSync struct {
+	Topic        string `json:"topic"`
+	Partition    int32  `json:"partition"`
+	Offset       int64  `json:"offset"`
+	ShadowOffset int64  `json:"shadow_offset"`
+}
+
+// recordOffsetSync records a sync for the record set forwarded from offset to shadowOffset if
+// it's the partition's first or the difference between the offsets changed. The caller must
+// hold s.mu.
+func (s *Shadow) recordOffsetSync(tp topicPartition, offset, shadowOffset int64) {
+	if s.config.OffsetSyncsTopic == "" {
+		return
+	}
+	p := s.partition(tp)
+	delta := shadowOffset - offset
+	if p.synced && p.syncDelta == delta {
+		return
+	}
+	p.synced = true
+	p.syncDelta = delta
+	s.offsetSyncs = append(s.offsetSyncs, ShadowOffsetSync{Topic: tp.topic, Partition: tp.partition, Offset: offset, ShadowOffset: shadowOffset})
+}
+
+// writeOffsetSyncs produces the recorded syncs to the offset syncs topic on the cluster, keeping
+// them to try again if it fails.
+func (s *Shadow) writeOffsetSyncs() {
+	s.mu.Lock()
+	syncs := s.offsetSyncs
+	s.offsetSyncs = nil
+	s.mu.Unlock()
+	if len(syncs) == 0 {
+		return
+	}
+	if err := s.produceOffsetSyncs(syncs); err != nil {
+		s.cluster.failed(topicPartition{topic: s.config.OffsetSyncsTopic}, err)
+		s.mu.Lock()
+		s.offsetSyncs = append(syncs, s.offsetSyncs...)
+		s.mu.Unlock()
+		s.logger.Error("shadow: failed to write offset syncs", log.String("topic", s.config.OffsetSyncsTopic), log.Error("error", err))
+	}
+}
+
+func (s *Shadow) produceOffsetSyncs(syncs []ShadowOffsetSync) error {
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+	batch := &protocol.RecordBatch{
+		LastOffsetDelta: int32(len(syncs) - 1),
+		FirstTimestamp:  timestamp,
+		MaxTimestamp:    timestamp,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+		BaseSequence:    -1,
+	}
+	for i, sync := range syncs {
+		value, err := json.Marshal(sync)
+		if err != nil {
+			return err
+		}
+		batch.Records = append(batch.Records, protocol.Record{
+			OffsetDelta: int32(i),
+			// keyed by partition so the topic can be compacted
+			Key:   []byte(fmt.Sprintf("%s-%d", sync.Topic, sync.Partition)),
+			Value: value,
+		})
+	}
+	_, err := s.produce(shadowRecordSet{
+		topicPartition: topicPartition{topic: s.config.OffsetSyncsTopic},
+		recordSet:      batch.Bytes(),
+	}, 3)
+	return err
+}
+
+// ShadowOffset is an offset of a partition on jocko translated to the shadowed cluster.
+// Translated is false if there aren't any syncs for the partition from before the offset, so
+// consumers failing over would have to start from the shadowed partition's earliest or latest
+// offset.
+type ShadowOffset struct {
+	Topic        string `json:"topic"`
+	Partition    int32  `json:"partition"`
+	Offset       int64  `json:"offset"`
+	ShadowOffset int64  `json:"shadow_offset"`
+	Translated   bool   `json:"translated"`
+}
+
+// ShadowOffsetTranslator translates offsets on jocko to the offsets on the shadowed cluster
+// consumers can fail over to without reprocessing what they've consumed, with the offset syncs
+// the shadowing brokers write to the cluster.
+type ShadowOffsetTranslator struct {
+	cluster *clusterClient
+	topic   string
+}
+
+// NewShadowOffsetTranslator returns a translator reading the offset syncs topic from the
+// cluster at the bootstrap addresses.
+func NewShadowOffsetTranslator(brokers []string, topic string, dialer *Dialer, timeout time.Duration) *ShadowOffsetTranslator {
+	return &ShadowOffsetTranslator{cluster: newClusterClient(brokers, dialer, timeout), topic: topic}
+}
+
+// Translate translates the offsets, by topic and partition, with the cluster's offset syncs. The
+// translated offsets are ordered by topic and partition.
+func (t *ShadowOffsetTranslator) Translate(offsets map[string]map[int32]int64) ([]ShadowOffset, error) {
+	syncs, err := t.readOffsetSyncs()
+	if err != nil {
+		return nil, err
+	}
+	var translated []ShadowOffset
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			o := ShadowOffset{Topic: topic, Partition: partition, Offset: offset}
+			o.ShadowOffset, o.Translated = translateShadowOffset(syncs[topicPartition{topic: topic, partition: partition}], offset)
+			translated = append(translated, o)
+		}
+	}
+	sort.Slice(translated, func(i, j int) bool {
+		if translated[i].Topic != translated[j].Topic {
+			return translated[i].Topic < translated[j].Topic
+		}
+		return translated[i].Partition < translated[j].Partition
+	})
+	return translated, nil
+}
+
+// TranslateGroup translates the offsets the group committed on the jocko cluster at the
+// bootstrap addresses.
+func (t *ShadowOffsetTranslator) TranslateGroup(brokers []string, group string) ([]ShadowOffset, error) {
+	c := newClusterClient(brokers, t.cluster.dialer, t.cluster.timeout)
+	defer c.close()
+	md, err := c.allMetadata()
+	if err != nil {
+		return nil, err
+	}
+	req := &protocol.OffsetFetchRequest{APIVersion: 1, GroupID: group}
+	for _, topic := range md.TopicMetadata {
+		if topic.IsInternal {
+			continue
+		}
+		ft := protocol.OffsetFetchTopicRequest{Topic: topic.Topic}
+		for _, p := range topic.PartitionMetadata {
+			ft.Partitions = append(ft.Partitions, p.PartitionID)
+		}
+		req.Topics = append(req.Topics, ft)
+	}
+	conn, err := c.coordinator(group)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := conn.OffsetFetch(req)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make(map[string]map[int32]int64)
+	for _, topic := range resp.Responses {
+		for _, p := range topic.Partitions {
+			if p.ErrorCode != protocol.ErrNone.Code() {
+				return nil, protocol.Errs[p.ErrorCode]
+			}
+			// the group hasn't committed an offset for the partition
+			if p.Offset < 0 {
+				continue
+			}
+			if offsets[topic.Topic] == nil {
+				offsets[topic.Topic] = make(map[int32]int64)
+			}
+			offsets[topic.Topic][p.Partition] = p.Offset
+		}
+	}
+	return t.Translate(offsets)
+}
+
+// Commit commits the translated offsets for the group on the shadowed cluster, so its consumers
+// carry on from them once they fail over. The group mustn't have members on the cluster.
+func (t *ShadowOffsetTranslator) Commit(group string, offsets []ShadowOffset) error {
+	req := &protocol.OffsetCommitRequest{APIVersion: 2, GroupID: group, GenerationID: -1, RetentionTime: -1}
+	topics := make(map[string]int)
+	for _, o := range offsets {
+		if !o.Translated {
+			continue
+		}
+		i, ok := topics[o.Topic]
+		if !ok {
+			i = len(req.Topics)
+			topics[o.Topic] = i
+			req.Topics = append(req.Topics, protocol.OffsetCommitTopicRequest{Topic: o.Topic})
+		}
+		req.Topics[i].Partitions = append(req.Topics[i].Partitions, protocol.OffsetCommitPartitionRequest{Partition: o.Partition, Offset: o.ShadowOffset})
+	}
+	if len(req.Topics) == 0 {
+		return nil
+	}
+	conn, err := t.cluster.coordinator(group)
+	if err != nil {
+		return err
+	}
+	resp, err := conn.OffsetCommit(req)
+	if err != nil {
+		return err
+	}
+	for _, topic := range resp.Responses {
+		for _, p := range topic.PartitionResponses {
+			if p.ErrorCode != protocol.ErrNone.Code() {
+				return fmt.Errorf("committing %s-%d: %v", topic.Topic, p.Partition, protocol.Errs[p.ErrorCode])
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the conns to the cluster.
+func (t *ShadowOffsetTranslator) Close() error {
+	t.cluster.close()
+	return nil
+}
+
+// translateShadowOffset translates the offset with the partition's syncs, ordered by offset:
+// by the difference of the last sync at or before it, up to the shadow offset of the sync after
+// it so record sets that weren't forwarded aren't skipped past.
+func translateShadowOffset(syncs []ShadowOffsetSync, offset int64) (int64, bool) {
+	i := sort.Search(len(syncs), func(i int) bool { return syncs[i].Offset > offset })
+	if i == 0 {
+		return 0, false
+	}
+	translated := offset + syncs[i-1].ShadowOffset - syncs[i-1].Offset
+	if i < len(syncs) && translated > syncs[i].ShadowOffset {
+		translated = syncs[i].ShadowOffset
+	}
+	return translated, true
+}
+
+// readOffsetSyncs reads the offset syncs topic, returning each partition's syncs ordered by
+// offset.
+func (t *ShadowOffsetTranslator) readOffsetSyncs() (map[topicPartition][]ShadowOffsetSync, error) {
+	tp := topicPartition{topic: t.topic}
+	syncs := make(map[topicPartition][]ShadowOffsetSync)
+	var offset int64
+	for {
+		conn, err := t.cluster.leader(tp)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := conn.Fetch(&protocol.FetchRequest{
+			APIVersion:  4,
+			ReplicaID:   -1,
+			MinBytes:    1,
+			MaxWaitTime: 100,
+			MaxBytes:    shadowFetchMaxBytes,
+			Topics: []*protocol.FetchTopic{{
+				Topic:      tp.topic,
+				Partitions: []*protocol.FetchPartition{{Partition: tp.partition, FetchOffset: offset, MaxBytes: shadowFetchMaxBytes}},
+			}},
+		})
+		if err != nil {
+			t.cluster.failed(tp, err)
+			return nil, err
+		}
+		if len(resp.Responses) != 1 || len(resp.Responses[0].PartitionResponses) != 1 {
+			return nil, protocol.ErrUnknown
+		}
+		p := resp.Responses[0].PartitionResponses[0]
+		if p.ErrorCode != protocol.ErrNone.Code() {
+			t.cluster.failed(tp, protocol.Errs[p.ErrorCode])
+			return nil, protocol.Errs[p.ErrorCode]
+		}
+		fetched := offset
+		for _, e := range protocol.RecordSetEntries(p.RecordSet) {
+			// the syncs are written as v2 batches
+			if e.Magic < 2 {
+				continue
+			}
+			batches, err := protocol.ReadRecordBatches(e.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			batch := batches[0]
+			for _, r := range batch.Records {
+				if batch.BaseOffset+int64(r.OffsetDelta) < offset || r.Value == nil {
+					continue
+				}
+				var sync ShadowOffsetSync
+				if err := json.Unmarshal(r.Value, &sync); err != nil {
+					return nil, err
+				}
+				stp := topicPartition{topic: sync.Topic, partition: sync.Partition}
+				syncs[stp] = append(syncs[stp], sync)
+			}
+			offset = batch.BaseOffset + int64(batch.LastOffsetDelta) + 1
+		}
+		if offset == fetched || offset >= p.HighWatermark {
+			break
+		}
+	}
+	for tp, s := range syncs {
+		sort.SliceStable(s, func(i, j int) bool { return s[i].Offset < s[j].Offset })
+		syncs[tp] = s
+	}
+	return syncs, nil
+}
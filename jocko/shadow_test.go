@@ -0,0 +1,133 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/testutil/retry"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_Shadow(t *testing.T) {
+	// another broker stands in for the Kafka cluster being shadowed
+	shadowed, shadowedTeardown := startTestLeader(t, nil)
+	defer shadowedTeardown()
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.ShadowBrokers = []string{shadowed.Addr().String()}
+		cfg.ShadowVerifyInterval = 50 * time.Millisecond
+		cfg.ShadowTopics = "^the-"
+	})
+	defer teardown()
+	reqCtx := &Context{parent: context.Background()}
+	for _, b := range []*Broker{b, shadowed.broker()} {
+		create := b.handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+			Topic:             "the-topic",
+			NumPartitions:     1,
+			ReplicationFactor: 1,
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	}
+	create := shadowed.broker().handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "jocko-offset-syncs",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	// a topic that isn't shadowed, created later
+	create = b.handleCreateTopic(reqCtx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "other-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	resp := b.handleProduce(reqCtx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "other-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: testRecordBatchWithHeaders("type", "other")}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+
+	for _, value := range []string{"one", "two"} {
+		resp := b.handleProduce(reqCtx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: testRecordBatchWithHeaders("type", value)}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		status := b.shadow.Status()
+		if len(status) != 1 || status[0].Verified != 2 {
+			r.Fatalf("record sets not verified: %+v", status)
+		}
+	})
+	status := b.shadow.Status()[0]
+	require.Equal(t, int64(2), status.Forwarded)
+	require.Equal(t, int64(0), status.Mismatched)
+	require.Equal(t, 0, status.Pending)
+	require.NotZero(t, status.Checksum)
+	require.Equal(t, status.Checksum, status.RemoteChecksum)
+
+	// the record sets were written to the shadowed broker as they were produced
+	replica, err := shadowed.broker().replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), replica.Log.NewestOffset())
+
+	// the offsets were synced to the shadowed broker to translate consumers' offsets with
+	translator := NewShadowOffsetTranslator([]string{shadowed.Addr().String()}, "jocko-offset-syncs", NewDialer("jocko-test"), time.Second)
+	defer translator.Close()
+	retry.Run(t, func(r *retry.R) {
+		offsets, err := translator.Translate(map[string]map[int32]int64{"the-topic": {0: 1}})
+		if err != nil {
+			r.Fatal(err)
+		}
+		if len(offsets) != 1 || !offsets[0].Translated || offsets[0].ShadowOffset != 1 {
+			r.Fatalf("offsets not translated: %+v", offsets)
+		}
+	})
+}
+
+func TestTranslateShadowOffset(t *testing.T) {
+	// record sets from offset 20 weren't forwarded, so the cluster's offsets fall behind
+	syncs := []ShadowOffsetSync{
+		{Offset: 10, ShadowOffset: 100},
+		{Offset: 30, ShadowOffset: 110},
+	}
+	_, ok := translateShadowOffset(syncs, 5)
+	require.False(t, ok)
+	for offset, want := range map[int64]int64{10: 100, 15: 105, 25: 110, 30: 110, 40: 120} {
+		got, ok := translateShadowOffset(syncs, offset)
+		require.True(t, ok)
+		require.Equal(t, want, got, "offset %d", offset)
+	}
+}
+
+func TestShadow_Compare(t *testing.T) {
+	s := &Shadow{logger: log.New(), partitions: make(map[topicPartition]*shadowPartition)}
+	tp := topicPartition{topic: "the-topic", partition: 0}
+	s.partition(tp).pending = []shadowChunk{
+		{offset: 10, crcs: []uint32{1, 2}},
+		{offset: 12, crcs: []uint32{3}},
+		{offset: 13, crcs: []uint32{4}},
+	}
+
+	// the last chunk's entry wasn't fetched
+	require.True(t, s.compare(tp, []protocol.RecordSetEntry{
+		{Offset: 9, CRC: 9},
+		{Offset: 10, CRC: 1},
+		{Offset: 11, CRC: 2},
+		{Offset: 12, CRC: 5},
+	}))
+	status := s.Status()[0]
+	require.Equal(t, int64(1), status.Verified)
+	require.Equal(t, int64(1), status.Mismatched)
+	require.Equal(t, 1, status.Pending)
+	require.NotEqual(t, status.Checksum, status.RemoteChecksum)
+
+	require.False(t, s.compare(tp, nil))
+	require.True(t, s.compare(tp, []protocol.RecordSetEntry{{Offset: 13, CRC: 4}}))
+	require.Equal(t, int64(2), s.Status()[0].Verified)
+}
@@ -0,0 +1,161 @@
+package jocko
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/log"
+)
+
+// requestsInFlight tracks the requests the broker's handling so shutting down can stop it taking
+// new ones and wait for the ones it's handling to finish.
+type requestsInFlight struct {
+	mu       sync.Mutex
+	stopped  bool
+	inFlight sync.WaitGroup
+}
+
+// start returns whether the request can be handled, false once the broker's stopped taking
+// requests. done must be called once it's handled.
+func (r *requestsInFlight) start() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return false
+	}
+	r.inFlight.Add(1)
+	return true
+}
+
+func (r *requestsInFlight) done() {
+	r.inFlight.Done()
+}
+
+// stop stops new requests from being handled.
+func (r *requestsInFlight) stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+}
+
+// wait waits for the requests being handled to finish. It's called once stopped so the requests
+// can't be added to while waiting.
+func (r *requestsInFlight) wait() {
+	r.inFlight.Wait()
+}
+
+// shutdownStage is a step of the broker's shutdown.
+type shutdownStage struct {
+	name string
+	run  func()
+}
+
+// shutdownStages returns the stages the broker shuts down in, each only starting once the ones
+// before it have finished so subsystems aren't torn down while others still use them: requests
+// stop being taken and the ones being handled finish before the coordinators stop, leadership's
+// moved off the broker before its logs are closed, and raft, serf and their stores go last.
+func (b *Broker) shutdownStages() []shutdownStage {
+	return []shutdownStage{
+		{name: "stop accepting requests", run: b.requests.stop},
+		{name: "drain requests", run: b.requests.wait},
+		{name: "stop coordinators", run: b.stopCoordinators},
+		{name: "demote leadership", run: b.demoteLeadership},
+		{name: "flush logs", run: b.flushLogs},
+		{name: "close raft, serf and stores", run: b.closeStores},
+	}
+}
+
+// runShutdownStages runs the stages in order, giving each the configured stage timeout so one
+// that's stuck can't keep the others from running. Stages that time out are left running. It
+// returns whether all of them finished in time.
+func (b *Broker) runShutdownStages(stages []shutdownStage) bool {
+	clean := true
+	for _, stage := range stages {
+		logger := b.logger.With(log.String("stage", stage.name))
+		logger.Info("shutdown stage starting")
+		start := time.Now()
+		done := make(chan struct{})
+		go func(run func()) {
+			defer close(done)
+			run()
+		}(stage.run)
+		timeout := time.NewTimer(b.config.ShutdownStageTimeout)
+		select {
+		case <-done:
+			logger.Info("shutdown stage finished", log.Duration("duration", time.Since(start)))
+		case <-timeout.C:
+			logger.Error("shutdown stage timed out, moving on", log.Duration("timeout", b.config.ShutdownStageTimeout))
+			clean = false
+		}
+		timeout.Stop()
+	}
+	return clean
+}
+
+// stopCoordinators stops the group and transaction coordinators' background work, like aborting
+// timed out transactions and removing expired offsets, and the shadow cluster's dual-writes.
+func (b *Broker) stopCoordinators() {
+	close(b.coordinatorsShutdownCh)
+	if b.shadow != nil {
+		b.shadow.Close()
+	}
+}
+
+// demoteLeadership moves the leadership of the partitions the broker leads to other replicas if
+// controlled shutdowns are on. Raft's leadership isn't transferred, the raft version the broker
+// uses can't, so the cluster elects a new controller once the broker's raft shuts down.
+func (b *Broker) demoteLeadership() {
+	if b.config.ControlledShutdown {
+		b.shutDownControlled()
+	}
+}
+
+// flushLogs stops the broker's background tasks and replicators, which write to its logs, and
+// then flushes and closes the logs. The partitions' high watermarks are checkpointed first.
+func (b *Broker) flushLogs() {
+	close(b.shutdownCh)
+	// while the followers still know their leaders' high watermarks
+	if !b.config.DevMode {
+		if err := b.writeHighWatermarks(); err != nil {
+			b.logger.Error("failed to checkpoint high watermarks", log.Error("error", err))
+		}
+	}
+	b.Lock()
+	for _, replica := range b.replicaLookup.Replicas() {
+		if replica.Replicator != nil {
+			if err := replica.Replicator.Close(); err != nil {
+				b.logger.Error("failed to stop replicator", log.Any("replica", replica), log.Error("error", err))
+			}
+			replica.Replicator = nil
+		}
+	}
+	b.Unlock()
+	for _, replica := range b.replicaLookup.Replicas() {
+		// closing the log flushes it
+		l, ok := replica.Log.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := l.Close(); err != nil {
+			b.logger.Error("failed to close replica log", log.Any("replica", replica), log.Error("error", err))
+		}
+	}
+}
+
+// closeStores shuts down serf and raft and closes raft's store.
+func (b *Broker) closeStores() {
+	if b.serf != nil {
+		b.serf.Shutdown()
+	}
+	if b.raft != nil {
+		b.raftTransport.Close()
+		future := b.raft.Shutdown()
+		if err := future.Error(); err != nil {
+			b.logger.Error("failed to shutdown raft", log.Error("error", err))
+		}
+		if b.raftStore != nil {
+			b.raftStore.Close()
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package jocko
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/log"
+)
+
+func TestBroker_ShutdownStages(t *testing.T) {
+	b := &Broker{config: &config.Config{ShutdownStageTimeout: 50 * time.Millisecond}, logger: log.New()}
+
+	var ran []string
+	stage := func(name string) shutdownStage {
+		return shutdownStage{name: name, run: func() { ran = append(ran, name) }}
+	}
+	require.True(t, b.runShutdownStages([]shutdownStage{stage("a"), stage("b")}))
+	require.Equal(t, []string{"a", "b"}, ran)
+
+	// a stuck stage times out and the ones after it still run
+	ran = nil
+	stuck := make(chan struct{})
+	defer close(stuck)
+	require.False(t, b.runShutdownStages([]shutdownStage{{name: "stuck", run: func() { <-stuck }}, stage("c")}))
+	require.Equal(t, []string{"c"}, ran)
+}
+
+func TestRequestsInFlight(t *testing.T) {
+	var r requestsInFlight
+	require.True(t, r.start())
+	r.stop()
+	require.False(t, r.start())
+
+	drained := make(chan struct{})
+	go func() {
+		r.wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("drained with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	r.done()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("not drained")
+	}
+}
@@ -0,0 +1,74 @@
+package jocko
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+)
+
+func TestSetSocketOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	// the zero config leaves TCP_NODELAY set
+	require.NoError(t, setSocketOptions(conn, config.SocketConfig{}))
+	_, _, _, noDelay := socketOptions(t, conn)
+	require.Equal(t, 1, noDelay)
+
+	clientSocket := config.SocketConfig{DisableNoDelay: true, SendBufferBytes: 32 << 10, ReceiveBufferBytes: 32 << 10}
+	replicaSocket := config.SocketConfig{SendBufferBytes: 256 << 10, ReceiveBufferBytes: 256 << 10, KeepAlive: time.Minute}
+	require.NoError(t, setSocketOptions(conn, clientSocket))
+	sndbuf, rcvbuf, _, noDelay := socketOptions(t, conn)
+	// linux doubles the sizes to leave room for its bookkeeping
+	require.Equal(t, 2*clientSocket.SendBufferBytes, sndbuf)
+	require.Equal(t, 2*clientSocket.ReceiveBufferBytes, rcvbuf)
+	require.Equal(t, 0, noDelay)
+
+	// switching an accepted conn to the replica options once it's found to be inter-broker
+	require.NoError(t, setSocketOptions(conn, replicaSocket))
+	sndbuf, rcvbuf, keepAlive, noDelay := socketOptions(t, conn)
+	require.Equal(t, 2*replicaSocket.SendBufferBytes, sndbuf)
+	require.Equal(t, 2*replicaSocket.ReceiveBufferBytes, rcvbuf)
+	require.Equal(t, int(replicaSocket.KeepAlive/time.Second), keepAlive)
+	require.Equal(t, 1, noDelay)
+}
+
+// socketOptions returns the conn's buffer sizes, keep-alive idle time in seconds and whether
+// TCP_NODELAY is set.
+func socketOptions(t *testing.T, conn net.Conn) (sndbuf, rcvbuf, keepAlive, noDelay int) {
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	require.NoError(t, err)
+	var errs []error
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		var err error
+		sndbuf, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		errs = append(errs, err)
+		rcvbuf, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		errs = append(errs, err)
+		keepAlive, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+		errs = append(errs, err)
+		noDelay, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+		errs = append(errs, err)
+	}))
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	return sndbuf, rcvbuf, keepAlive, noDelay
+}
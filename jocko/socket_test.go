@@ -0,0 +1,29 @@
+package jocko
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestIsInterBroker(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		req      interface{}
+		want     bool
+	}{
+		{"client produce", "my-app", &protocol.ProduceRequest{}, false},
+		{"client fetch", "my-app", &protocol.FetchRequest{ReplicaID: -1}, false},
+		{"replica fetch", "my-app", &protocol.FetchRequest{ReplicaID: 2}, true},
+		{"leader and isr", "", &protocol.LeaderAndISRRequest{}, true},
+		{"replicator conn", replicatorClientIDPrefix + "2", &protocol.APIVersionsRequest{}, true},
+		{"broker conn", brokerClientIDPrefix + "2", &protocol.OffsetsRequest{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.want, isInterBroker(&protocol.RequestHeader{ClientID: test.clientID}, test.req))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package jocko
+
+import (
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// groupInstanceID returns the group.instance.id a member sent, empty for dynamic members.
+func groupInstanceID(id *string) string {
+	if id == nil {
+		return ""
+	}
+	return *id
+}
+
+// instanceIDOf returns the member's instance id as it's sent to clients, nil for dynamic members.
+func instanceIDOf(m structs.Member) *string {
+	if m.InstanceID == "" {
+		return nil
+	}
+	id := m.InstanceID
+	return &id
+}
+
+// staticMember returns the id of the group's member with the instance id.
+func staticMember(group *structs.Group, instanceID string) (string, bool) {
+	for id, m := range group.Members {
+		if m.InstanceID == instanceID {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// checkMember returns the error for a request from the member with the instance id, empty if it's
+// dynamic: FENCED_INSTANCE_ID if another member has the instance id, since a newer instance of the
+// static member rejoined, or UNKNOWN_MEMBER_ID if the member isn't in the group.
+func checkMember(group *structs.Group, memberID, instanceID string) protocol.Error {
+	if instanceID != "" {
+		if id, ok := staticMember(group, instanceID); ok && id != memberID {
+			return protocol.ErrFencedInstanceId
+		}
+	}
+	if _, ok := group.Members[memberID]; !ok {
+		return protocol.ErrUnknownMemberId
+	}
+	return protocol.ErrNone
+}
+
+// leavingMember returns the id of the member leaving the group. Static members can be removed by
+// their instance id alone, as admin clients do to remove ones that won't be back.
+func leavingMember(group *structs.Group, m protocol.LeaveGroupMember) (string, protocol.Error) {
+	instanceID := groupInstanceID(m.GroupInstanceID)
+	if m.MemberID == "" && instanceID != "" {
+		id, ok := staticMember(group, instanceID)
+		if !ok {
+			return "", protocol.ErrUnknownMemberId
+		}
+		return id, protocol.ErrNone
+	}
+	return m.MemberID, checkMember(group, m.MemberID, instanceID)
+}
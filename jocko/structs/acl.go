@@ -0,0 +1,97 @@
+package structs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The ACL enums use Kafka's IDs, these are the values filters match on.
+const (
+	// AclAny is the ID of any resource type, pattern type, operation or permission type.
+	AclAny int8 = 1
+	// AclPatternMatch matches the ACLs applying to the filter's resource name.
+	AclPatternMatch    int8 = 2
+	AclPatternLiteral  int8 = 3
+	AclPatternPrefixed int8 = 4
+)
+
+// AclWildcard is the literal resource name of ACLs applying to all resources of their type.
+const AclWildcard = "*"
+
+// Acl allows or denies the principal from the host the operation on the resources matched by the
+// resource name and pattern type.
+type Acl struct {
+	// ID identifies the ACL. Is made by AclID from its other fields.
+	ID             string
+	ResourceType   int8
+	ResourceName   string
+	PatternType    int8
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+
+	RaftIndex
+}
+
+// AclID returns the ID of the ACL, the same ACL being created twice is stored once.
+func AclID(a Acl) string {
+	return fmt.Sprintf("%d/%d/%s/%s/%s/%d/%d", a.ResourceType, a.PatternType, a.ResourceName, a.Principal, a.Host, a.Operation, a.PermissionType)
+}
+
+// AclFilter matches ACLs. Nil names, principals and hosts match any.
+type AclFilter struct {
+	ResourceType   int8
+	ResourceName   *string
+	PatternType    int8
+	Principal      *string
+	Host           *string
+	Operation      int8
+	PermissionType int8
+}
+
+// Matches returns whether the filter matches the ACL.
+func (f AclFilter) Matches(a *Acl) bool {
+	if f.ResourceType != AclAny && f.ResourceType != a.ResourceType {
+		return false
+	}
+	if f.Principal != nil && *f.Principal != a.Principal {
+		return false
+	}
+	if f.Host != nil && *f.Host != a.Host {
+		return false
+	}
+	if f.Operation != AclAny && f.Operation != a.Operation {
+		return false
+	}
+	if f.PermissionType != AclAny && f.PermissionType != a.PermissionType {
+		return false
+	}
+	switch f.PatternType {
+	case AclAny:
+		return f.ResourceName == nil || *f.ResourceName == a.ResourceName
+	case AclPatternMatch:
+		if f.ResourceName == nil {
+			return true
+		}
+		switch a.PatternType {
+		case AclPatternLiteral:
+			return a.ResourceName == *f.ResourceName || a.ResourceName == AclWildcard
+		case AclPatternPrefixed:
+			return strings.HasPrefix(*f.ResourceName, a.ResourceName)
+		}
+		return false
+	default:
+		return f.PatternType == a.PatternType && (f.ResourceName == nil || *f.ResourceName == a.ResourceName)
+	}
+}
+
+// CreateAclsRequest creates the ACLs.
+type CreateAclsRequest struct {
+	Acls []Acl
+}
+
+// DeleteAclsRequest deletes the ACLs matching the filters.
+type DeleteAclsRequest struct {
+	Filters []AclFilter
+}
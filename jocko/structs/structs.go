@@ -2,6 +2,8 @@ package structs
 
 import (
 	"bytes"
+	"fmt"
+	"time"
 
 	"github.com/ugorji/go/codec"
 )
@@ -9,13 +11,26 @@ import (
 type MessageType uint8
 
 const (
-	RegisterNodeRequestType        MessageType = 0
-	DeregisterNodeRequestType                  = 1
-	RegisterTopicRequestType                   = 2
-	DeregisterTopicRequestType                 = 3
-	RegisterPartitionRequestType               = 4
-	DeregisterPartitionRequestType             = 5
-	RegisterGroupRequestType                   = 6
+	RegisterNodeRequestType              MessageType = 0
+	DeregisterNodeRequestType                        = 1
+	RegisterTopicRequestType                         = 2
+	DeregisterTopicRequestType                       = 3
+	RegisterPartitionRequestType                     = 4
+	DeregisterPartitionRequestType                   = 5
+	RegisterGroupRequestType                         = 6
+	RegisterConfigRequestType                        = 7
+	CommitOffsetsRequestType                         = 8
+	DeregisterGroupRequestType                       = 9
+	DeleteOffsetsRequestType                         = 10
+	AllocateProducerIDsRequestType                   = 11
+	CommitTxnOffsetsRequestType                      = 12
+	CompleteTxnOffsetsRequestType                    = 13
+	CreateAclsRequestType                            = 14
+	DeleteAclsRequestType                            = 15
+	RegisterDelegationTokenRequestType               = 16
+	DeregisterDelegationTokenRequestType             = 17
+	AddBrokerLifecycleEventsRequestType              = 18
+	AddConfigChangeRequestType                       = 19
 )
 
 type CheckID string
@@ -41,6 +56,49 @@ type RegisterGroupRequest struct {
 	Group Group
 }
 
+// CommitOffsetsRequest commits offsets for a group, registering the group with the coordinator
+// if it doesn't exist.
+type CommitOffsetsRequest struct {
+	Group       string
+	Coordinator int32
+	Offsets     map[string]map[int32]GroupOffset
+}
+
+// DeregisterGroupRequest deletes a group and its committed offsets, if it has no members.
+type DeregisterGroupRequest struct {
+	Group string
+}
+
+// DeleteOffsetsRequest deletes a group's committed offsets of the given topics' partitions.
+type DeleteOffsetsRequest struct {
+	Group   string
+	Offsets map[string][]int32
+}
+
+// CommitTxnOffsetsRequest commits offsets for a group as part of the producer's transaction,
+// they're pending until the transaction ends. The group's registered with the coordinator if it
+// doesn't exist.
+type CommitTxnOffsetsRequest struct {
+	Group       string
+	Coordinator int32
+	ProducerID  int64
+	Offsets     map[string]map[int32]GroupOffset
+}
+
+// CompleteTxnOffsetsRequest commits or drops the offsets the producer's transaction committed for
+// the group once the transaction's committed or aborted.
+type CompleteTxnOffsetsRequest struct {
+	Group      string
+	ProducerID int64
+	Committed  bool
+}
+
+// AllocateProducerIDsRequest allocates the broker the next block of producer ids of the given size.
+type AllocateProducerIDsRequest struct {
+	Broker int32
+	Size   int64
+}
+
 type RegisterNodeRequest struct {
 	Node Node
 }
@@ -65,8 +123,18 @@ type DeregisterPartitionRequest struct {
 	Partition Partition
 }
 
-// msgpackHandle is a shared handle for encoding/decoding of structs
-var msgpackHandle = &codec.MsgpackHandle{}
+type RegisterConfigRequest struct {
+	Config Config
+}
+
+// msgpackHandle is a shared handle for encoding/decoding of structs. It
+// decodes raw strings as strings so interface{} values, like topic config values, keep
+// their types.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.RawToString = true
+	return h
+}()
 
 // Decode is used to encode a MsgPack object with type prefix.
 func Decode(buf []byte, out interface{}) error {
@@ -113,6 +181,8 @@ type HealthCheck struct {
 	Name    string  // check name
 	Status  string  // current check stauts
 	Output  string  // output of script runs
+	// Since is when the check's status changed to its current status.
+	Since time.Time
 	RaftIndex
 }
 
@@ -147,24 +217,255 @@ type Partition struct {
 	// the leader and ISR info. TODO: this will probably have to change to fit better.
 	ControllerEpoch int32
 	LeaderEpoch     int32
+	// AddingReplicas and RemovingReplicas are set while the partition's being reassigned: the
+	// replicas are moving from AR without the adding replicas to AR without the removing replicas.
+	AddingReplicas   []int32
+	RemovingReplicas []int32
 
 	RaftIndex
 }
 
 // Member
 type Member struct {
-	ID         string
-	Metadata   []byte
+	ID string
+	// InstanceID is the member's group.instance.id if it's a static member, which keeps its
+	// place in the group when it restarts and rejoins with a new member id.
+	InstanceID string
+	// ClientID and ClientHost identify the client the member joined from.
+	ClientID   string
+	ClientHost string
+	// Metadata is the member's metadata for the group's protocol.
+	Metadata []byte
+	// Protocols are the protocols the member supports, most preferred first, with its metadata
+	// for each.
+	Protocols []MemberProtocol
+	// Assignment is the member's assignment from the group's leader.
 	Assignment []byte
+	// SessionTimeout is how long the member's kept in the group without heartbeating, and
+	// RebalanceTimeout how long it has to rejoin and sync while the group's rebalancing. Members
+	// that joined before they were kept never time out.
+	SessionTimeout   time.Duration
+	RebalanceTimeout time.Duration
+}
+
+// MemberProtocol is a protocol a group member supports with its metadata for it.
+type MemberProtocol struct {
+	Name     string
+	Metadata []byte
 }
 
+// Group states, named as in Kafka.
+const (
+	GroupStatePreparingRebalance  = "PreparingRebalance"
+	GroupStateCompletingRebalance = "CompletingRebalance"
+	GroupStateStable              = "Stable"
+	GroupStateEmpty               = "Empty"
+	GroupStateDead                = "Dead"
+)
+
 // Group
 type Group struct {
 	ID          string
 	Group       string
 	Coordinator int32
 	LeaderID    string
-	Members     map[string]Member
+	// State is the group's state, one of the GroupState constants. Groups with no state are
+	// Empty.
+	State string
+	// ProtocolType is the type of protocol the members use, like consumer, and Protocol is
+	// the protocol of that type they've agreed on.
+	ProtocolType string
+	Protocol     string
+	Members      map[string]Member
+	// Offsets are the group's committed offsets by topic and partition.
+	Offsets map[string]map[int32]GroupOffset
+	// PendingOffsets are the offsets committed in producers' ongoing transactions by producer id,
+	// they're committed or dropped when the transactions end.
+	PendingOffsets map[int64]map[string]map[int32]GroupOffset
+
+	RaftIndex
+}
+
+// Copy returns a copy of the group with its own members, so the copy can be changed without
+// changing the group in the store.
+func (g *Group) Copy() *Group {
+	c := *g
+	c.Members = make(map[string]Member, len(g.Members))
+	for id, m := range g.Members {
+		c.Members[id] = m
+	}
+	return &c
+}
+
+// GroupOffset is an offset committed by a group.
+type GroupOffset struct {
+	Offset int64
+	// CommitTime is when the offset was committed. ExpireTime is when it expires if it was
+	// committed with a retention time of its own, zero if the broker's retention applies.
+	CommitTime time.Time
+	ExpireTime time.Time
+	// Metadata is the string committed with the offset, like the state some clients keep
+	// alongside it. It's nil if none was committed.
+	Metadata *string
+}
+
+// ProducerIDBlock is the block of producer ids, First to Last inclusive, last allocated to a
+// broker to hand out to idempotent producers. Blocks are allocated through raft so brokers never
+// hand out the same ids.
+type ProducerIDBlock struct {
+	Broker int32
+	First  int64
+	Last   int64
+
+	RaftIndex
+}
+
+// ConfigResourceType is the type of resource a config applies to, using Kafka's resource type IDs.
+type ConfigResourceType int8
+
+const (
+	TopicConfigResource  ConfigResourceType = 2
+	BrokerConfigResource ConfigResourceType = 4
+)
+
+// Config holds the config entries set on a resource, keyed by Kafka's config names. Topic configs
+// live on the topic itself so this is used for broker configs.
+type Config struct {
+	// ID identifies the config. Is made by ConfigID from the resource type and name.
+	ID           string
+	ResourceType ConfigResourceType
+	Resource     string
+	Entries      map[string]string
 
 	RaftIndex
 }
+
+// ConfigID returns the ID of the config for the given resource.
+func ConfigID(resourceType ConfigResourceType, resource string) string {
+	return fmt.Sprintf("%d/%s", resourceType, resource)
+}
+
+// DelegationToken is a token clients authenticate with as its owner, using its ID and HMAC as
+// their SCRAM credentials, until it expires. Its owner and renewers can renew it, up to its max
+// time, or expire it early.
+type DelegationToken struct {
+	ID       string
+	Owner    string
+	Renewers []string
+	// HMAC is the token's HMAC of its ID made with the brokers' secret key.
+	HMAC       []byte
+	IssueTime  time.Time
+	ExpiryTime time.Time
+	MaxTime    time.Time
+
+	RaftIndex
+}
+
+type RegisterDelegationTokenRequest struct {
+	Token DelegationToken
+}
+
+type DeregisterDelegationTokenRequest struct {
+	ID string
+}
+
+// Types of broker lifecycle events.
+const (
+	// BrokerStarted is a broker starting, seen once it's joined the cluster.
+	BrokerStarted = "started"
+	// BrokerLeft is a broker leaving the cluster.
+	BrokerLeft = "left"
+	// BrokerFailed is a broker failing, which is how brokers that are shut down without leaving
+	// the cluster are seen too.
+	BrokerFailed = "failed"
+)
+
+// How a broker's previous run ended, as it saw on starting again.
+const (
+	ShutdownClean   = "clean"
+	ShutdownUnclean = "unclean"
+)
+
+// BrokerLifecycle is the history of a broker's startups and shutdowns, kept so which brokers
+// restarted uncleanly, and when, can be worked out after an incident.
+type BrokerLifecycle struct {
+	Broker int32
+	// Events are the broker's last events, oldest first.
+	Events []BrokerLifecycleEvent
+
+	RaftIndex
+}
+
+// BrokerLifecycleEvent is a broker starting, leaving or failing.
+type BrokerLifecycleEvent struct {
+	Type string
+	Time time.Time
+	// The rest are set for startups. Version is the version of jocko the broker's running.
+	// PreviousShutdown is whether its previous run shut down cleanly, empty if it's the
+	// broker's first start with its data dir. RecoveryDuration is how long it took to recover
+	// its state before joining the cluster.
+	Version          string
+	PreviousShutdown string
+	RecoveryDuration time.Duration
+}
+
+// AddBrokerLifecycleEventsRequest adds the events to their brokers' lifecycle histories.
+type AddBrokerLifecycleEventsRequest struct {
+	// Events are keyed by broker ID.
+	Events map[int32]BrokerLifecycleEvent
+}
+
+// Kinds of resources whose changes are kept in config histories.
+const (
+	TopicConfigChange  = "topic"
+	BrokerConfigChange = "broker"
+	// AclsChange is the ACLs' changes, kept in one history.
+	AclsChange = "acls"
+)
+
+// ConfigHistory is the last changes to a resource's configs, or to the ACLs, kept so drifted
+// configs and bad changes can be traced to who made them and when, and reverted.
+type ConfigHistory struct {
+	// ID identifies the history. Is made by ConfigHistoryID from its kind and resource.
+	ID       string
+	Kind     string
+	Resource string
+	// Changes are the last changes, oldest first.
+	Changes []ConfigChange
+
+	RaftIndex
+}
+
+// ConfigHistoryID returns the ID of the history of the resource's changes.
+func ConfigHistoryID(kind, resource string) string {
+	return kind + "/" + resource
+}
+
+// ConfigChange is a change to a resource's configs or to the ACLs.
+type ConfigChange struct {
+	Time time.Time
+	// Principal is who made the change, empty if the client didn't authenticate.
+	Principal string
+	// Operation is the API of the request that made the change, like AlterConfigs.
+	Operation string
+	// Entries are the configs the change set or unset.
+	Entries []ConfigChangeEntry
+	// AclsCreated and AclsDeleted are the ACLs the change created and deleted.
+	AclsCreated []Acl
+	AclsDeleted []Acl
+}
+
+// ConfigChangeEntry is a config's values before and after a change, nil if it wasn't set and
+// its default applied.
+type ConfigChangeEntry struct {
+	Name   string
+	Before *string
+	After  *string
+}
+
+// AddConfigChangeRequest adds the change to the history of the resource's changes.
+type AddConfigChangeRequest struct {
+	Kind     string
+	Resource string
+	Change   ConfigChange
+}
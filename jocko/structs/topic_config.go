@@ -7,20 +7,50 @@ func NewTopicConfig() TopicConfig {
 
 	cfg.Set(TopicConfigEntry{
 		ConfigEntry: ConfigEntry{
-			Name:    "cleanup.policy",
-			Default: "delete",
+			Name:        "cleanup.policy",
+			Default:     "delete",
+			ValidValues: []interface{}{"delete", "compact"},
 		},
 		ServerDefault: "log.cleanup.policy",
 	})
 
 	cfg.Set(TopicConfigEntry{
 		ConfigEntry: ConfigEntry{
-			Name:    "compression.type",
-			Default: "producer",
+			Name:        "compression.type",
+			Default:     "producer",
+			ValidValues: []interface{}{"uncompressed", "zstd", "lz4", "snappy", "gzip", "producer"},
 		},
 		ServerDefault: "compression.type",
 	})
 
+	// -1 is gzip's default level
+	cfg.Set(TopicConfigEntry{
+		ConfigEntry: ConfigEntry{
+			Name:        "compression.gzip.level",
+			Default:     -1,
+			ValidValues: []interface{}{-1, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		},
+		ServerDefault: "compression.gzip.level",
+	})
+
+	cfg.Set(TopicConfigEntry{
+		ConfigEntry: ConfigEntry{
+			Name:    "compression.zstd.level",
+			Default: 3,
+		},
+		ServerDefault: "compression.zstd.level",
+	})
+
+	// recompress re-encodes every batch with the compression.type codec, accept keeps the
+	// batches producers compressed as they are and only compresses the uncompressed ones.
+	cfg.Set(TopicConfigEntry{
+		ConfigEntry: ConfigEntry{
+			Name:        "compression.recompression.policy",
+			Default:     "recompress",
+			ValidValues: []interface{}{"recompress", "accept"},
+		},
+	})
+
 	cfg.Set(TopicConfigEntry{
 		ConfigEntry: ConfigEntry{
 			Name:    "delete.retention.ms",
@@ -56,6 +86,7 @@ func NewTopicConfig() TopicConfig {
 	cfg.Set(TopicConfigEntry{
 		ConfigEntry: ConfigEntry{
 			Name: "follower.replication.throttled.replicas",
+			List: true,
 		},
 		ServerDefault: "follower.replication.throttled.replicas",
 	})
@@ -71,6 +102,7 @@ func NewTopicConfig() TopicConfig {
 	cfg.Set(TopicConfigEntry{
 		ConfigEntry: ConfigEntry{
 			Name: "leader.replication.throttled.replicas",
+			List: true,
 		},
 		ServerDefault: "leader.replication.throttled.replicas",
 	})
@@ -101,8 +133,9 @@ func NewTopicConfig() TopicConfig {
 
 	cfg.Set(TopicConfigEntry{
 		ConfigEntry: ConfigEntry{
-			Name:    "message.timestamp.type",
-			Default: "CreateTime",
+			Name:        "message.timestamp.type",
+			Default:     "CreateTime",
+			ValidValues: []interface{}{"CreateTime", "LogAppendTime"},
 		},
 	})
 
@@ -231,6 +264,8 @@ type ConfigEntry struct {
 	Name        string
 	ValidValues []interface{}
 	Value       interface{}
+	// List is whether the value is a comma separated list.
+	List bool
 }
 
 type TopicConfigEntry struct {
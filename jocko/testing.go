@@ -71,6 +71,10 @@ func NewTestServer(t testing.T, cbBroker func(cfg *config.Config), cbServer func
 	config.SerfLANConfig.MemberlistConfig.BindPort = ports[2]
 	config.LeaveDrainTime = 100 * time.Millisecond
 	config.ReconcileInterval = 300 * time.Millisecond
+	config.ReplicaFetchBackoff = 50 * time.Millisecond
+	// tests shut brokers down to fail them, those testing controlled shutdowns turn it back on
+	config.ControlledShutdown = false
+	config.ControlledShutdownRetryBackoff = 50 * time.Millisecond
 
 	// Tighten the Serf timing
 	config.SerfLANConfig.MemberlistConfig.BindAddr = "127.0.0.1"
@@ -89,7 +93,7 @@ func NewTestServer(t testing.T, cbBroker func(cfg *config.Config), cbServer func
 		cbBroker(config)
 	}
 
-	b, err := NewBroker(config, tracer, logger)
+	b, err := NewBroker(config, nil, tracer, logger)
 	if err != nil {
 		t.Fatalf("err != nil: %s", err)
 	}
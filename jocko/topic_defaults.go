@@ -0,0 +1,63 @@
+package jocko
+
+import (
+	"math"
+	"strings"
+
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// Dynamic broker configs, set with AlterConfigs, for the number of partitions and replication
+// factor of topics created without them. They override the broker's flags and can be set for
+// the topics in a namespace, the part of their names before the first dot, by prefixing them
+// with "namespace.<namespace>.", e.g. namespace.payments.num.partitions.
+const (
+	numPartitionsConfig            = "num.partitions"
+	defaultReplicationFactorConfig = "default.replication.factor"
+	namespaceConfigPrefix          = "namespace."
+)
+
+// topicNamespace returns the namespace of the topic, empty if it isn't in one.
+func topicNamespace(topic string) string {
+	if i := strings.Index(topic, "."); i > 0 {
+		return topic[:i]
+	}
+	return ""
+}
+
+// topicConfigDefault returns the value of the dynamic config set for the topic's namespace, or
+// else for all topics.
+func (b *Broker) topicConfigDefault(topic, name string) (int64, bool) {
+	if ns := topicNamespace(topic); ns != "" {
+		if v, ok := b.dynamicConfig(namespaceConfigPrefix + ns + "." + name); ok {
+			return configInt(v)
+		}
+	}
+	if v, ok := b.dynamicConfig(name); ok {
+		return configInt(v)
+	}
+	return 0, false
+}
+
+// withTopicDefaults returns the request with the default number of partitions and replication
+// factor filled in where it has -1. Dynamic configs out of range of the request's fields, set
+// before they were checked, are ignored.
+func (b *Broker) withTopicDefaults(req *protocol.CreateTopicRequest) *protocol.CreateTopicRequest {
+	if req.NumPartitions != -1 && req.ReplicationFactor != -1 {
+		return req
+	}
+	r := *req
+	if r.NumPartitions == -1 {
+		r.NumPartitions = b.config.NumPartitions
+		if n, ok := b.topicConfigDefault(r.Topic, numPartitionsConfig); ok && n > 0 && n <= math.MaxInt32 {
+			r.NumPartitions = int32(n)
+		}
+	}
+	if r.ReplicationFactor == -1 {
+		r.ReplicationFactor = int16(b.config.DefaultReplicationFactor)
+		if n, ok := b.topicConfigDefault(r.Topic, defaultReplicationFactorConfig); ok && n > 0 && n <= math.MaxInt16 {
+			r.ReplicationFactor = int16(n)
+		}
+	}
+	return &r
+}
@@ -0,0 +1,241 @@
+package jocko
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// The states of topic deletions.
+const (
+	topicDeletionQueued   = "queued"
+	topicDeletionDeleting = "deleting"
+	topicDeletionDeleted  = "deleted"
+	topicDeletionFailed   = "failed"
+)
+
+// topicDeletionsKept is how many completed topic deletions the controller keeps to describe.
+const topicDeletionsKept = 1024
+
+// topicDeletion is the progress of the controller deleting a topic.
+type topicDeletion struct {
+	Topic      string `json:"topic"`
+	State      string `json:"state"`
+	Partitions int    `json:"partitions"`
+	// Replicas is how many replicas the topic's partitions had, ReplicasStopped how many of them
+	// their brokers have been told to stop and delete.
+	Replicas        int       `json:"replicas"`
+	ReplicasStopped int       `json:"replicas_stopped"`
+	Error           string    `json:"error,omitempty"`
+	QueuedAt        time.Time `json:"queued_at"`
+	// CompletedAt is zero until the topic's deleted or its deletion failed.
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// topicDeletions is the controller's queue of topics to delete. DeleteTopics requests queue
+// their topics and return right away, and the controller deletes them one at a time in the
+// background so deleting many topics doesn't time out the request. The deletions are kept in
+// memory, those still queued when the controller loses leadership fail and have to be retried
+// on the new controller.
+type topicDeletions struct {
+	mu sync.Mutex
+	// running is whether this broker's the controller running the deletions, topics are only
+	// queued while it is.
+	running   bool
+	queue     []string
+	deletions map[string]*topicDeletion
+	// completed are the completed deletions' topics, oldest first.
+	completed []string
+	// queued is signalled when topics are queued.
+	queued chan struct{}
+}
+
+func newTopicDeletions() *topicDeletions {
+	return &topicDeletions{
+		deletions: make(map[string]*topicDeletion),
+		queued:    make(chan struct{}, 1),
+	}
+}
+
+// add queues the topic to be deleted, it's fine if it's already queued or being deleted. It
+// returns false if this broker isn't running the deletions.
+func (d *topicDeletions) add(topic string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.running {
+		return false
+	}
+	if td, ok := d.deletions[topic]; ok && (td.State == topicDeletionQueued || td.State == topicDeletionDeleting) {
+		return true
+	}
+	d.forget(topic)
+	d.deletions[topic] = &topicDeletion{Topic: topic, State: topicDeletionQueued, QueuedAt: time.Now()}
+	d.queue = append(d.queue, topic)
+	select {
+	case d.queued <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// next starts deleting the next queued topic, it returns false if none are queued.
+func (d *topicDeletions) next() (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.queue) == 0 {
+		return "", false
+	}
+	topic := d.queue[0]
+	d.queue = d.queue[1:]
+	d.deletions[topic].State = topicDeletionDeleting
+	return topic, true
+}
+
+// progress records the topic's replicas that have been stopped, out of all of its replicas.
+func (d *topicDeletions) progress(topic string, partitions, replicas, stopped int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	td := d.deletions[topic]
+	td.Partitions = partitions
+	td.Replicas = replicas
+	td.ReplicasStopped = stopped
+}
+
+// complete records the topic's deletion completed, failed if err's set, and forgets the oldest
+// completed deletion if too many are kept.
+func (d *topicDeletions) complete(topic string, err protocol.Error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.completeLocked(d.deletions[topic], err)
+}
+
+func (d *topicDeletions) completeLocked(td *topicDeletion, err protocol.Error) {
+	td.State = topicDeletionDeleted
+	if err != protocol.ErrNone {
+		td.State = topicDeletionFailed
+		td.Error = err.Error()
+	}
+	td.CompletedAt = time.Now()
+	d.completed = append(d.completed, td.Topic)
+	if len(d.completed) > topicDeletionsKept {
+		delete(d.deletions, d.completed[0])
+		d.completed = d.completed[1:]
+	}
+}
+
+// forget removes the topic's completed deletion, if it has one.
+func (d *topicDeletions) forget(topic string) {
+	if _, ok := d.deletions[topic]; !ok {
+		return
+	}
+	delete(d.deletions, topic)
+	for i, t := range d.completed {
+		if t == topic {
+			d.completed = append(d.completed[:i], d.completed[i+1:]...)
+			break
+		}
+	}
+}
+
+// start marks the deletions running as this broker's become the controller.
+func (d *topicDeletions) start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = true
+}
+
+// stop marks the deletions stopped as this broker's no longer the controller, failing the
+// queued ones.
+func (d *topicDeletions) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = false
+	for _, topic := range d.queue {
+		d.completeLocked(d.deletions[topic], protocol.ErrNotController)
+	}
+	d.queue = nil
+}
+
+// describe returns the deletions, queued and running ones first in the order they were queued,
+// then the completed ones most recent first. If topic's set only its deletion's returned.
+func (d *topicDeletions) describe(topic string) []topicDeletion {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deletions := []topicDeletion{}
+	add := func(t string) {
+		if topic == "" || t == topic {
+			deletions = append(deletions, *d.deletions[t])
+		}
+	}
+	for _, td := range d.deletions {
+		if td.State == topicDeletionDeleting {
+			add(td.Topic)
+		}
+	}
+	for _, t := range d.queue {
+		add(t)
+	}
+	for i := len(d.completed) - 1; i >= 0; i-- {
+		add(d.completed[i])
+	}
+	return deletions
+}
+
+// runTopicDeletions runs while this broker's the controller, once the leader loop's started the
+// deletions, deleting the queued topics one at a time until stopCh is closed.
+func (b *Broker) runTopicDeletions(stopCh chan struct{}) {
+	defer b.topicDeletions.stop()
+	ctx := &Context{parent: context.Background()}
+	for {
+		topic, ok := b.topicDeletions.next()
+		if !ok {
+			select {
+			case <-b.topicDeletions.queued:
+				continue
+			case <-stopCh:
+				return
+			case <-b.shutdownCh:
+				return
+			}
+		}
+		err := b.deleteTopic(ctx, topic, func(partitions, replicas, stopped int) {
+			b.topicDeletions.progress(topic, partitions, replicas, stopped)
+		})
+		if err != protocol.ErrNone {
+			b.logger.Error("leader: failed to delete topic", log.String("topic", topic), log.Error("error", err))
+		} else {
+			b.logger.Info("leader: deleted topic", log.String("topic", topic))
+		}
+		b.topicDeletions.complete(topic, err)
+	}
+}
+
+// adminTopicDeletions describes the topics the controller's deleting and has deleted: the
+// queued and running deletions in the order they were requested, then the completed ones most
+// recent first, or only the topic's deletion.
+//
+//	GET /v1/topics/deletions[?topic=<topic>]
+func (b *Broker) adminTopicDeletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !b.isController() {
+		http.Error(w, "broker isn't the controller", http.StatusServiceUnavailable)
+		return
+	}
+	topic := r.URL.Query().Get("topic")
+	deletions := b.topicDeletions.describe(topic)
+	if topic != "" && len(deletions) == 0 {
+		http.Error(w, "topic deletion not found", http.StatusNotFound)
+		return
+	}
+	writeAdminJSON(w, struct {
+		Deletions []topicDeletion `json:"deletions"`
+	}{deletions})
+}
@@ -0,0 +1,143 @@
+package jocko
+
+import (
+	"strconv"
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/jocko/util"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// Dynamic broker configs, set with AlterConfigs, to trace and log the requests of particular
+// clients or for particular topics without turning on verbose logging for every request. The
+// values are comma separated lists.
+const (
+	traceClientIDsConfig = "trace.client.ids"
+	traceTopicsConfig    = "trace.topics"
+)
+
+// dynamicConfig returns the value of the dynamic broker config set for this broker, or else for
+// all brokers.
+func (b *Broker) dynamicConfig(name string) (string, bool) {
+	state := b.fsm.State()
+	for _, resource := range []string{strconv.Itoa(int(b.config.ID)), ""} {
+		_, config, err := state.GetConfig(structs.BrokerConfigResource, resource)
+		if err != nil || config == nil {
+			continue
+		}
+		if v, ok := config.Entries[name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// traceConfig is the parsed trace configs. It's cached as the configs change, rather than looked
+// up for each request.
+type traceConfig struct {
+	clientIDs map[string]bool
+	topics    map[string]bool
+}
+
+// loadTraceConfig caches the broker's trace configs.
+func (b *Broker) loadTraceConfig() {
+	ids, _ := b.dynamicConfig(traceClientIDsConfig)
+	topics, _ := b.dynamicConfig(traceTopicsConfig)
+	b.traceConfig.Store(&traceConfig{clientIDs: listSet(ids), topics: listSet(topics)})
+}
+
+// traceRequest returns whether the request's client or any of its topics are set to be traced.
+func (b *Broker) traceRequest(ctx *Context) bool {
+	tc, ok := b.traceConfig.Load().(*traceConfig)
+	if !ok {
+		return false
+	}
+	if ctx.header != nil && tc.clientIDs[ctx.header.ClientID] {
+		return true
+	}
+	if len(tc.topics) == 0 {
+		return false
+	}
+	for _, topic := range requestTopics(ctx.req) {
+		if tc.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// traceLog logs the traced request's metadata and adds its value to the request's span, which is
+// set to be sampled regardless of the tracer's sampler. The value itself, which can hold whole
+// record sets, is only logged at debug.
+func (b *Broker) traceLog(ctx *Context, msg, k string, v interface{}) {
+	if sp := opentracing.SpanFromContext(ctx); sp != nil {
+		ext.SamplingPriority.Set(sp, 1)
+		sp.LogKV(k, util.Dump(v))
+	}
+	fields := []log.Field{log.Any("topics", requestTopics(ctx.req))}
+	if ctx.header != nil {
+		fields = append(fields,
+			log.Int16("api key", ctx.header.APIKey),
+			log.Int16("api version", ctx.header.APIVersion),
+			log.Int32("correlation id", ctx.header.CorrelationID),
+			log.String("client id", ctx.header.ClientID),
+		)
+	}
+	b.logger.Info(msg, fields...)
+	b.logger.Debug(msg, log.Any(k, v))
+}
+
+// requestTopics returns the topics the request is for.
+func requestTopics(req interface{}) []string {
+	var topics []string
+	switch req := req.(type) {
+	case *protocol.ProduceRequest:
+		for _, t := range req.TopicData {
+			topics = append(topics, t.Topic)
+		}
+	case *protocol.FetchRequest:
+		for _, t := range req.Topics {
+			topics = append(topics, t.Topic)
+		}
+	case *protocol.OffsetsRequest:
+		for _, t := range req.Topics {
+			topics = append(topics, t.Topic)
+		}
+	case *protocol.OffsetCommitRequest:
+		for _, t := range req.Topics {
+			topics = append(topics, t.Topic)
+		}
+	case *protocol.OffsetFetchRequest:
+		for _, t := range req.Topics {
+			topics = append(topics, t.Topic)
+		}
+	case *protocol.MetadataRequest:
+		topics = req.Topics
+	case *protocol.CreateTopicRequests:
+		for _, t := range req.Requests {
+			topics = append(topics, t.Topic)
+		}
+	case *protocol.DeleteTopicsRequest:
+		topics = req.Topics
+	case *protocol.CreatePartitionsRequest:
+		for _, t := range req.Topics {
+			topics = append(topics, t.Topic)
+		}
+	}
+	return topics
+}
+
+// listSet returns the set of values in the comma separated list, ignoring empty values.
+func listSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			set[s] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,908 @@
+package jocko
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/travisjeffery/jocko/jocko/structs"
+	"github.com/travisjeffery/jocko/jocko/util"
+	"github.com/travisjeffery/jocko/log"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// TransactionStateTopicName is the internal topic transaction coordinators keep the state of
+// their transactions in. A transactional id's coordinator is the leader of the partition the id
+// hashes to, which loads the partition's transactions when it becomes its leader. Each record is
+// a v1 message keyed by the transactional id whose value is the id's transactionMetadata as JSON,
+// the latest record for an id being its current state.
+const TransactionStateTopicName = "__transaction_state"
+
+// transactionStateReplicationFactor is the most replicas the transaction state topic's created
+// with.
+const transactionStateReplicationFactor = 3
+
+// txnPartition is a partition in a transaction.
+type txnPartition struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+}
+
+// transactionMetadata is the state of a transactional id's transactions.
+type transactionMetadata struct {
+	TransactionalID string        `json:"transactional_id"`
+	ProducerID      int64         `json:"producer_id"`
+	ProducerEpoch   int16         `json:"producer_epoch"`
+	Timeout         time.Duration `json:"timeout"`
+	// State is one of the protocol's transaction states.
+	State string `json:"state"`
+	// Partitions are the partitions added to the transaction and Groups the groups whose offsets
+	// it commits.
+	Partitions []txnPartition `json:"partitions,omitempty"`
+	Groups     []string       `json:"groups,omitempty"`
+	// StartTime is when the ongoing transaction added its first partition or group, zero if
+	// there's no ongoing transaction.
+	StartTime  time.Time `json:"start_time"`
+	LastUpdate time.Time `json:"last_update"`
+
+	// partition is the transaction state partition the id hashes to.
+	partition int32
+}
+
+// transactions holds the transactions this broker coordinates, those of the transaction state
+// partitions it leads.
+type transactions struct {
+	mu sync.Mutex
+	// loaded are the transaction state partitions whose transactions have been loaded.
+	loaded map[int32]bool
+	byID   map[string]*transactionMetadata
+}
+
+func newTransactions() *transactions {
+	return &transactions{
+		loaded: make(map[int32]bool),
+		byID:   make(map[string]*transactionMetadata),
+	}
+}
+
+// unload forgets the transactions of the partition once this broker stops leading it.
+func (t *transactions) unload(partition int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.loaded, partition)
+	for id, m := range t.byID {
+		if m.partition == partition {
+			delete(t.byID, id)
+		}
+	}
+}
+
+// loadTransactions reads the transactions of the transaction state partition this broker's
+// become the leader of.
+func (b *Broker) loadTransactions(replica *Replica) {
+	partition := replica.Partition.ID
+	loaded := make(map[string]*transactionMetadata)
+	err := readMessages(replica, func(msg *protocol.Message) {
+		m := new(transactionMetadata)
+		if err := json.Unmarshal(msg.Value, m); err != nil {
+			b.logger.Error("failed to decode transaction", log.String("transactional id", string(msg.Key)), log.Error("error", err))
+			return
+		}
+		m.partition = partition
+		loaded[m.TransactionalID] = m
+	})
+	if err != nil {
+		b.logger.Error("failed to read transaction state", log.Int32("partition", partition), log.Error("error", err))
+		return
+	}
+	t := b.transactions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, m := range loaded {
+		t.byID[id] = m
+	}
+	t.loaded[partition] = true
+	b.logger.Info("loaded transactions", log.Int32("partition", partition), log.Int("transactions", len(loaded)))
+}
+
+// readMessages calls fn with each of the v1 messages in the replica's log, oldest first. It's
+// used to load the state internal topics keep, whose records are all v1 messages.
+func readMessages(replica *Replica, fn func(*protocol.Message)) error {
+	if replica.Log.NewestOffset() <= replica.Log.OldestOffset() {
+		return nil
+	}
+	r, err := replica.Log.NewReader(replica.Log.OldestOffset(), math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	for len(buf) >= 12 {
+		size := 12 + int(int32(protocol.Encoding.Uint32(buf[8:])))
+		if size < 12 || size > len(buf) {
+			break
+		}
+		var ms protocol.MessageSet
+		if err := ms.Decode(protocol.NewDecoder(buf[:size])); err != nil {
+			return err
+		}
+		buf = buf[size:]
+		for _, msg := range ms.Messages {
+			fn(msg)
+		}
+	}
+	return nil
+}
+
+// transactionStateTopic returns the transaction state topic, creating it if it doesn't exist and
+// this broker's the controller.
+func (b *Broker) transactionStateTopic(ctx *Context) (*structs.Topic, protocol.Error) {
+	state := b.fsm.State()
+	_, topic, err := state.GetTopic(TransactionStateTopicName)
+	if err != nil {
+		return nil, protocol.ErrUnknown.WithErr(err)
+	}
+	if topic != nil {
+		return topic, protocol.ErrNone
+	}
+	if !b.isController() {
+		return nil, protocol.ErrCoordinatorNotAvailable
+	}
+	replicationFactor := len(b.LANMembers())
+	if replicationFactor > transactionStateReplicationFactor {
+		replicationFactor = transactionStateReplicationFactor
+	}
+	ps := b.buildPartitions(TransactionStateTopicName, b.config.TransactionStateNumPartitions, int16(replicationFactor))
+	topic = &structs.Topic{
+		Topic:      TransactionStateTopicName,
+		Partitions: make(map[int32][]int32, len(ps)),
+	}
+	for _, p := range ps {
+		topic.Partitions[p.ID] = p.AR
+	}
+	if _, err := b.raftApply(structs.RegisterTopicRequestType, structs.RegisterTopicRequest{Topic: *topic}); err != nil {
+		return nil, protocol.ErrUnknown.WithErr(err)
+	}
+	for _, p := range ps {
+		if err := b.createPartition(p); err != nil {
+			return nil, protocol.ErrUnknown.WithErr(err)
+		}
+	}
+	if err := b.sendLeaderAndISR(ctx, ps); err != protocol.ErrNone {
+		return nil, err
+	}
+	return topic, protocol.ErrNone
+}
+
+// transactionStatePartition returns the partition of the transaction state topic the
+// transactional id hashes to.
+func transactionStatePartition(id string, partitions int) int32 {
+	return int32(util.Hash(id) % uint64(partitions))
+}
+
+// findTransactionCoordinator sets the response's coordinator to the leader of the transactional
+// id's transaction state partition, creating the topic if it doesn't exist yet.
+func (b *Broker) findTransactionCoordinator(ctx *Context, resp *protocol.FindCoordinatorResponse, id string) *protocol.FindCoordinatorResponse {
+	topic, perr := b.transactionStateTopic(ctx)
+	if perr != protocol.ErrNone {
+		resp.ErrorCode = perr.Code()
+		return resp
+	}
+	partition := transactionStatePartition(id, len(topic.Partitions))
+	_, p, err := b.fsm.State().GetPartition(TransactionStateTopicName, partition)
+	if err != nil {
+		b.logger.Error("find coordinator failed", log.Error("error", err), log.String("transactional id", id))
+		resp.ErrorCode = protocol.ErrUnknown.Code()
+		return resp
+	}
+	if p == nil {
+		resp.ErrorCode = protocol.ErrCoordinatorNotAvailable.Code()
+		return resp
+	}
+	broker := b.brokerLookup.BrokerByID(raft.ServerID(p.Leader))
+	if broker == nil {
+		resp.ErrorCode = protocol.ErrCoordinatorNotAvailable.Code()
+		return resp
+	}
+	resp.Coordinator.NodeID = broker.ID.Int32()
+	resp.Coordinator.Host = broker.Host()
+	resp.Coordinator.Port = broker.Port()
+	return resp
+}
+
+// transactionCoordinator returns the leader of the transactional id's transaction state
+// partition, erroring unless it's this broker and it's loaded the partition's transactions.
+func (b *Broker) transactionCoordinator(ctx *Context, id string) (*Replica, protocol.Error) {
+	topic, err := b.transactionStateTopic(ctx)
+	if err != protocol.ErrNone {
+		return nil, err
+	}
+	partition := transactionStatePartition(id, len(topic.Partitions))
+	replica, rerr := b.replicaLookup.Replica(TransactionStateTopicName, partition)
+	if rerr != nil || replica == nil || replica.Log == nil || replica.Partition.Leader != b.config.ID {
+		return nil, protocol.ErrNotCoordinator
+	}
+	b.transactions.mu.Lock()
+	loaded := b.transactions.loaded[partition]
+	b.transactions.mu.Unlock()
+	if !loaded {
+		return nil, protocol.ErrCoordinatorLoadInProgress
+	}
+	return replica, protocol.ErrNone
+}
+
+// writeTransaction appends the transaction's state to its transaction state partition. It's
+// called with the transactions' lock held.
+func (b *Broker) writeTransaction(replica *Replica, m *transactionMetadata) protocol.Error {
+	if replica.Partition.Leader != b.config.ID {
+		return protocol.ErrNotCoordinator
+	}
+	m.LastUpdate = time.Now()
+	value, err := json.Marshal(m)
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	recordSet, err := protocol.Encode(&protocol.MessageSet{Messages: []*protocol.Message{{
+		MagicByte: 1,
+		Timestamp: m.LastUpdate,
+		Key:       []byte(m.TransactionalID),
+		Value:     value,
+	}}})
+	if err != nil {
+		return protocol.ErrUnknown.WithErr(err)
+	}
+	protocol.SetPartitionLeaderEpoch(recordSet, replica.Partition.LeaderEpoch)
+	if _, err := replica.Log.Append(recordSet); err != nil {
+		b.logFailed(replica.Partition.Topic, replica.Partition.ID, err)
+		return protocol.ErrKafkaStorageError.WithErr(err)
+	}
+	return protocol.ErrNone
+}
+
+// checkProducer checks the request's producer is the transactional id's current one.
+func checkProducer(m *transactionMetadata, producerID int64, producerEpoch int16) protocol.Error {
+	switch {
+	case m == nil || m.ProducerID != producerID:
+		return protocol.ErrInvalidProducerIdMapping
+	case m.ProducerEpoch != producerEpoch:
+		return protocol.ErrInvalidProducerEpoch
+	case m.State == protocol.TransactionStatePrepareCommit || m.State == protocol.TransactionStatePrepareAbort:
+		// the last transaction's markers are still being written
+		return protocol.ErrConcurrentTransactions
+	}
+	return protocol.ErrNone
+}
+
+// beginTransaction moves the transaction to ongoing if it isn't already.
+func beginTransaction(m *transactionMetadata) {
+	if m.State != protocol.TransactionStateOngoing {
+		m.State = protocol.TransactionStateOngoing
+		m.StartTime = time.Now()
+	}
+}
+
+// initTransactionalProducer handles InitProducerId for transactional producers. A new
+// transactional id's given a producer id, an existing one's epoch is bumped to fence its
+// previous producer, aborting the transaction it had ongoing.
+func (b *Broker) initTransactionalProducer(ctx *Context, req *protocol.InitProducerIDRequest, resp *protocol.InitProducerIDResponse) {
+	id := *req.TransactionalID
+	if req.TransactionTimeout <= 0 || req.TransactionTimeout > b.config.TransactionMaxTimeout {
+		resp.ErrorCode = protocol.ErrInvalidTransactionTimeout.Code()
+		return
+	}
+	replica, err := b.transactionCoordinator(ctx, id)
+	if err != protocol.ErrNone {
+		resp.ErrorCode = err.Code()
+		return
+	}
+	t := b.transactions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.byID[id]
+	if ok && req.ProducerID >= 0 && (req.ProducerID != m.ProducerID || req.ProducerEpoch != m.ProducerEpoch) {
+		// the producer's been fenced by another with the same transactional id
+		resp.ErrorCode = protocol.ErrInvalidProducerEpoch.Code()
+		return
+	}
+	if ok && (m.State == protocol.TransactionStatePrepareCommit || m.State == protocol.TransactionStatePrepareAbort) {
+		resp.ErrorCode = protocol.ErrConcurrentTransactions.Code()
+		return
+	}
+	if !ok || m.ProducerEpoch >= math.MaxInt16-1 {
+		pid, err := b.nextProducerID()
+		if err != nil {
+			b.logger.Error("failed to allocate producer id", log.Error("error", err))
+			resp.ErrorCode = protocol.ErrCoordinatorNotAvailable.Code()
+			return
+		}
+		if !ok {
+			m = &transactionMetadata{
+				TransactionalID: id,
+				partition:       replica.Partition.ID,
+				ProducerEpoch:   -1,
+			}
+		}
+		m.ProducerID = pid
+		m.ProducerEpoch = -1
+	}
+	m.ProducerEpoch++
+	m.Timeout = req.TransactionTimeout
+	if m.State == protocol.TransactionStateOngoing {
+		// the new epoch fences the previous producer before its transaction's aborted
+		if err := b.endTransaction(ctx, replica, m, false); err != protocol.ErrNone {
+			resp.ErrorCode = err.Code()
+			return
+		}
+	} else {
+		m.State = protocol.TransactionStateEmpty
+		if err := b.writeTransaction(replica, m); err != protocol.ErrNone {
+			resp.ErrorCode = err.Code()
+			return
+		}
+	}
+	t.byID[id] = m
+	resp.ProducerID = m.ProducerID
+	resp.ProducerEpoch = m.ProducerEpoch
+}
+
+func (b *Broker) handleAddPartitionsToTxn(ctx *Context, req *protocol.AddPartitionsToTxnRequest) *protocol.AddPartitionsToTxnResponse {
+	sp := span(ctx, b.tracer, "add partitions to txn")
+	defer sp.Finish()
+	resp := new(protocol.AddPartitionsToTxnResponse)
+	resp.APIVersion = req.Version()
+	// every partition gets the same error unless some are unknown, then the rest aren't added
+	errs := make(map[txnPartition]protocol.Error)
+	setErrs := func(err protocol.Error) {
+		for _, t := range req.Topics {
+			for _, p := range t.Partitions {
+				tp := txnPartition{Topic: t.Topic, Partition: p}
+				if _, ok := errs[tp]; !ok {
+					errs[tp] = err
+				}
+			}
+		}
+	}
+	defer func() {
+		resp.Results = make([]protocol.AddPartitionsToTxnTopicResult, len(req.Topics))
+		for i, t := range req.Topics {
+			resp.Results[i].Topic = t.Topic
+			resp.Results[i].Partitions = make([]protocol.AddPartitionsToTxnPartitionResult, len(t.Partitions))
+			for j, p := range t.Partitions {
+				resp.Results[i].Partitions[j] = protocol.AddPartitionsToTxnPartitionResult{
+					Partition: p,
+					ErrorCode: errs[txnPartition{Topic: t.Topic, Partition: p}].Code(),
+				}
+			}
+		}
+	}()
+
+	replica, err := b.transactionCoordinator(ctx, req.TransactionalID)
+	if err != protocol.ErrNone {
+		setErrs(err)
+		return resp
+	}
+	state := b.fsm.State()
+	for _, t := range req.Topics {
+		for _, p := range t.Partitions {
+			if _, partition, err := state.GetPartition(t.Topic, p); err != nil || partition == nil {
+				errs[txnPartition{Topic: t.Topic, Partition: p}] = protocol.ErrUnknownTopicOrPartition
+			}
+		}
+	}
+	if len(errs) > 0 {
+		setErrs(protocol.ErrOperationNotAttempted)
+		return resp
+	}
+
+	txns := b.transactions
+	txns.mu.Lock()
+	defer txns.mu.Unlock()
+	m := txns.byID[req.TransactionalID]
+	if err := checkProducer(m, req.ProducerID, req.ProducerEpoch); err != protocol.ErrNone {
+		setErrs(err)
+		return resp
+	}
+	added := *m
+	added.Partitions = append([]txnPartition(nil), m.Partitions...)
+	for _, t := range req.Topics {
+		for _, p := range t.Partitions {
+			added.Partitions = addTxnPartition(added.Partitions, txnPartition{Topic: t.Topic, Partition: p})
+		}
+	}
+	beginTransaction(&added)
+	if err := b.writeTransaction(replica, &added); err != protocol.ErrNone {
+		setErrs(err)
+		return resp
+	}
+	*m = added
+	setErrs(protocol.ErrNone)
+	return resp
+}
+
+// addTxnPartition adds the partition to the sorted partitions if it isn't in them.
+func addTxnPartition(ps []txnPartition, p txnPartition) []txnPartition {
+	i := sort.Search(len(ps), func(i int) bool {
+		return ps[i].Topic > p.Topic || (ps[i].Topic == p.Topic && ps[i].Partition >= p.Partition)
+	})
+	if i < len(ps) && ps[i] == p {
+		return ps
+	}
+	ps = append(ps, txnPartition{})
+	copy(ps[i+1:], ps[i:])
+	ps[i] = p
+	return ps
+}
+
+func (b *Broker) handleAddOffsetsToTxn(ctx *Context, req *protocol.AddOffsetsToTxnRequest) *protocol.AddOffsetsToTxnResponse {
+	sp := span(ctx, b.tracer, "add offsets to txn")
+	defer sp.Finish()
+	resp := new(protocol.AddOffsetsToTxnResponse)
+	resp.APIVersion = req.Version()
+	replica, err := b.transactionCoordinator(ctx, req.TransactionalID)
+	if err != protocol.ErrNone {
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	txns := b.transactions
+	txns.mu.Lock()
+	defer txns.mu.Unlock()
+	m := txns.byID[req.TransactionalID]
+	if err := checkProducer(m, req.ProducerID, req.ProducerEpoch); err != protocol.ErrNone {
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	added := *m
+	added.Groups = append([]string(nil), m.Groups...)
+	if i := sort.SearchStrings(added.Groups, req.GroupID); i == len(added.Groups) || added.Groups[i] != req.GroupID {
+		added.Groups = append(added.Groups, "")
+		copy(added.Groups[i+1:], added.Groups[i:])
+		added.Groups[i] = req.GroupID
+	}
+	beginTransaction(&added)
+	if err := b.writeTransaction(replica, &added); err != protocol.ErrNone {
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	*m = added
+	resp.ErrorCode = protocol.ErrNone.Code()
+	return resp
+}
+
+func (b *Broker) handleEndTxn(ctx *Context, req *protocol.EndTxnRequest) *protocol.EndTxnResponse {
+	sp := span(ctx, b.tracer, "end txn")
+	defer sp.Finish()
+	resp := new(protocol.EndTxnResponse)
+	resp.APIVersion = req.Version()
+	replica, err := b.transactionCoordinator(ctx, req.TransactionalID)
+	if err != protocol.ErrNone {
+		resp.ErrorCode = err.Code()
+		return resp
+	}
+	txns := b.transactions
+	txns.mu.Lock()
+	defer txns.mu.Unlock()
+	m := txns.byID[req.TransactionalID]
+	switch {
+	case m == nil || m.ProducerID != req.ProducerID:
+		err = protocol.ErrInvalidProducerIdMapping
+	case m.ProducerEpoch != req.ProducerEpoch:
+		err = protocol.ErrInvalidProducerEpoch
+	case m.State == protocol.TransactionStateOngoing:
+		err = b.endTransaction(ctx, replica, m, req.Committed)
+	case m.State == protocol.TransactionStatePrepareCommit && req.Committed,
+		m.State == protocol.TransactionStatePrepareAbort && !req.Committed:
+		// retried after the markers failed to be written
+		err = b.completeTransaction(ctx, replica, m)
+	case m.State == protocol.TransactionStateCompleteCommit && req.Committed,
+		m.State == protocol.TransactionStateCompleteAbort && !req.Committed:
+		// retried after the transaction ended
+		err = protocol.ErrNone
+	default:
+		err = protocol.ErrInvalidTxnState
+	}
+	resp.ErrorCode = err.Code()
+	return resp
+}
+
+// endTransaction prepares to commit or abort the ongoing transaction and then completes it. It's
+// called with the transactions' lock held.
+func (b *Broker) endTransaction(ctx *Context, replica *Replica, m *transactionMetadata, committed bool) protocol.Error {
+	if committed {
+		m.State = protocol.TransactionStatePrepareCommit
+	} else {
+		m.State = protocol.TransactionStatePrepareAbort
+	}
+	if err := b.writeTransaction(replica, m); err != protocol.ErrNone {
+		return err
+	}
+	return b.completeTransaction(ctx, replica, m)
+}
+
+// completeTransaction writes the markers ending the prepared transaction to its partitions and
+// commits or drops its groups' offsets. If that fails the transaction stays prepared and it's
+// retried by the producer retrying, or once it's timed out. It's called with the transactions'
+// lock held.
+func (b *Broker) completeTransaction(ctx *Context, replica *Replica, m *transactionMetadata) protocol.Error {
+	committed := m.State == protocol.TransactionStatePrepareCommit
+	if err := b.writeTxnMarkers(ctx, m, committed, replica.Partition.LeaderEpoch); err != protocol.ErrNone {
+		b.logger.Error("failed to write txn markers", log.String("transactional id", m.TransactionalID), log.Error("error", err))
+		return protocol.ErrConcurrentTransactions
+	}
+	for _, group := range m.Groups {
+		if _, err := b.raftApply(structs.CompleteTxnOffsetsRequestType, structs.CompleteTxnOffsetsRequest{
+			Group:      group,
+			ProducerID: m.ProducerID,
+			Committed:  committed,
+		}); err != nil {
+			b.logger.Error("failed to complete txn offsets", log.String("transactional id", m.TransactionalID), log.String("group", group), log.Error("error", err))
+			return protocol.ErrConcurrentTransactions
+		}
+	}
+	if committed {
+		m.State = protocol.TransactionStateCompleteCommit
+	} else {
+		m.State = protocol.TransactionStateCompleteAbort
+	}
+	m.Partitions = nil
+	m.Groups = nil
+	m.StartTime = time.Time{}
+	return b.writeTransaction(replica, m)
+}
+
+// writeTxnMarkers sends the markers ending the transaction to the leaders of its partitions.
+// Partitions that have been deleted are skipped.
+func (b *Broker) writeTxnMarkers(ctx *Context, m *transactionMetadata, committed bool, coordinatorEpoch int32) protocol.Error {
+	state := b.fsm.State()
+	reqs := make(map[int32]*protocol.WriteTxnMarkersRequest)
+	for _, tp := range m.Partitions {
+		_, p, err := state.GetPartition(tp.Topic, tp.Partition)
+		if err != nil {
+			return protocol.ErrUnknown.WithErr(err)
+		}
+		if p == nil {
+			continue
+		}
+		req, ok := reqs[p.Leader]
+		if !ok {
+			req = &protocol.WriteTxnMarkersRequest{Markers: []protocol.WritableTxnMarker{{
+				ProducerID:       m.ProducerID,
+				ProducerEpoch:    m.ProducerEpoch,
+				Committed:        committed,
+				CoordinatorEpoch: coordinatorEpoch,
+			}}}
+			reqs[p.Leader] = req
+		}
+		marker := &req.Markers[0]
+		if n := len(marker.Topics); n > 0 && marker.Topics[n-1].Topic == tp.Topic {
+			marker.Topics[n-1].Partitions = append(marker.Topics[n-1].Partitions, tp.Partition)
+		} else {
+			marker.Topics = append(marker.Topics, protocol.WritableTxnMarkerTopic{Topic: tp.Topic, Partitions: []int32{tp.Partition}})
+		}
+	}
+	for id, req := range reqs {
+		var resp *protocol.WriteTxnMarkersResponse
+		if id == b.config.ID {
+			resp = b.handleWriteTxnMarkers(ctx, req)
+		} else {
+			broker := b.brokerLookup.BrokerByID(raft.ServerID(id))
+			if broker == nil {
+				return protocol.ErrBrokerNotAvailable
+			}
+			conn, err := b.dialer().Dial("tcp", broker.BrokerAddr)
+			if err != nil {
+				return protocol.ErrUnknown.WithErr(err)
+			}
+			resp, err = conn.WriteTxnMarkers(req)
+			conn.Close()
+			if err != nil {
+				return protocol.ErrUnknown.WithErr(err)
+			}
+		}
+		for _, marker := range resp.Markers {
+			for _, t := range marker.Topics {
+				for _, p := range t.Partitions {
+					switch p.ErrorCode {
+					case protocol.ErrNone.Code(), protocol.ErrUnknownTopicOrPartition.Code():
+					default:
+						return protocol.Errs[p.ErrorCode]
+					}
+				}
+			}
+		}
+	}
+	return protocol.ErrNone
+}
+
+func (b *Broker) handleWriteTxnMarkers(ctx *Context, req *protocol.WriteTxnMarkersRequest) *protocol.WriteTxnMarkersResponse {
+	sp := span(ctx, b.tracer, "write txn markers")
+	defer sp.Finish()
+	resp := new(protocol.WriteTxnMarkersResponse)
+	resp.APIVersion = req.Version()
+	resp.Markers = make([]protocol.WritableTxnMarkerResult, len(req.Markers))
+	now := time.Now()
+	for i, m := range req.Markers {
+		resp.Markers[i].ProducerID = m.ProducerID
+		resp.Markers[i].Topics = make([]protocol.WritableTxnMarkerTopicResult, len(m.Topics))
+		marker := protocol.EndTxnMarker(m.ProducerID, m.ProducerEpoch, m.CoordinatorEpoch, m.Committed, now)
+		for j, t := range m.Topics {
+			resp.Markers[i].Topics[j].Topic = t.Topic
+			resp.Markers[i].Topics[j].Partitions = make([]protocol.WritableTxnMarkerPartitionResult, len(t.Partitions))
+			for k, p := range t.Partitions {
+				resp.Markers[i].Topics[j].Partitions[k] = protocol.WritableTxnMarkerPartitionResult{
+					Partition: p,
+					ErrorCode: b.appendTxnMarker(t.Topic, p, marker).Code(),
+				}
+			}
+		}
+	}
+	return resp
+}
+
+// appendTxnMarker appends the marker to the partition this broker leads. The partition's locked
+// like it is for produces to it.
+func (b *Broker) appendTxnMarker(topic string, partition int32, marker []byte) protocol.Error {
+	mu := b.partitionLocks.get(topic, partition)
+	mu.Lock()
+	defer mu.Unlock()
+	replica, err := b.replicaLookup.Replica(topic, partition)
+	if err != nil || replica == nil || replica.Log == nil {
+		if _, p, _ := b.fsm.State().GetPartition(topic, partition); p == nil {
+			return protocol.ErrUnknownTopicOrPartition
+		}
+		return protocol.ErrNotLeaderForPartition
+	}
+	if replica.Partition.Leader != b.config.ID {
+		return protocol.ErrNotLeaderForPartition
+	}
+	cb := b.breakers.get(topic, partition)
+	if !cb.allow() {
+		return protocol.ErrKafkaStorageError
+	}
+	// each partition gets its own copy since its leader epoch's stamped into it
+	recordSet := append([]byte(nil), marker...)
+	protocol.SetPartitionLeaderEpoch(recordSet, replica.Partition.LeaderEpoch)
+	if _, err := replica.Log.Append(recordSet); err != nil {
+		b.logger.Error("failed to append txn marker", log.String("topic", topic), log.Int32("partition", partition), log.Error("error", err))
+		b.logFailed(topic, partition, err)
+		return protocol.ErrKafkaStorageError
+	}
+	cb.success()
+	b.txnIndexes.update(topic, partition, recordSet)
+	return protocol.ErrNone
+}
+
+func (b *Broker) handleTxnOffsetCommit(ctx *Context, req *protocol.TxnOffsetCommitRequest) *protocol.TxnOffsetCommitResponse {
+	sp := span(ctx, b.tracer, "txn offset commit")
+	defer sp.Finish()
+	resp := new(protocol.TxnOffsetCommitResponse)
+	resp.APIVersion = req.Version()
+
+	state := b.fsm.State()
+	_, group, err := state.GetGroup(req.GroupID)
+	perr := b.groupCoordinator(req.GroupID, group)
+	if err != nil {
+		perr = protocol.ErrUnknown.WithErr(err)
+	}
+	errs := make(map[txnPartition]protocol.Error)
+	now := time.Now()
+	offsets := make(map[string]map[int32]structs.GroupOffset, len(req.Topics))
+	for _, t := range req.Topics {
+		for _, p := range t.Partitions {
+			if _, partition, err := state.GetPartition(t.Topic, p.Partition); err != nil || partition == nil {
+				errs[txnPartition{Topic: t.Topic, Partition: p.Partition}] = protocol.ErrUnknownTopicOrPartition
+				continue
+			}
+			if b.offsetMetadataTooLarge(p.Metadata) {
+				errs[txnPartition{Topic: t.Topic, Partition: p.Partition}] = protocol.ErrOffsetMetadataTooLarge
+				continue
+			}
+			if offsets[t.Topic] == nil {
+				offsets[t.Topic] = make(map[int32]structs.GroupOffset, len(t.Partitions))
+			}
+			offsets[t.Topic][p.Partition] = structs.GroupOffset{Offset: p.Offset, CommitTime: now, Metadata: p.Metadata}
+		}
+	}
+	if perr == protocol.ErrNone && len(offsets) > 0 {
+		// the offsets are pending until the producer's transaction coordinator ends the
+		// transaction
+		if _, err := b.raftApply(structs.CommitTxnOffsetsRequestType, structs.CommitTxnOffsetsRequest{
+			Group:       req.GroupID,
+			Coordinator: b.config.ID,
+			ProducerID:  req.ProducerID,
+			Offsets:     offsets,
+		}); err != nil {
+			b.logger.Error("failed to commit txn offsets", log.Error("error", err))
+			perr = protocol.ErrUnknown.WithErr(err)
+		}
+	}
+
+	resp.Topics = make([]protocol.TxnOffsetCommitTopicResponse, len(req.Topics))
+	for i, t := range req.Topics {
+		resp.Topics[i].Topic = t.Topic
+		resp.Topics[i].Partitions = make([]protocol.TxnOffsetCommitPartitionResponse, len(t.Partitions))
+		for j, p := range t.Partitions {
+			err, ok := errs[txnPartition{Topic: t.Topic, Partition: p.Partition}]
+			if !ok {
+				err = perr
+			}
+			resp.Topics[i].Partitions[j] = protocol.TxnOffsetCommitPartitionResponse{
+				Partition: p.Partition,
+				ErrorCode: err.Code(),
+			}
+		}
+	}
+	return resp
+}
+
+// abortTimedOutTransactions aborts the ongoing transactions that have run past their timeouts
+// every interval, bumping their producers' epochs to fence them, and retries completing the
+// transactions whose markers failed to be written.
+func (b *Broker) abortTimedOutTransactions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ctx := &Context{parent: context.Background()}
+	for {
+		select {
+		case <-b.coordinatorsShutdownCh:
+			return
+		case <-ticker.C:
+		}
+		t := b.transactions
+		t.mu.Lock()
+		now := time.Now()
+		for _, m := range t.byID {
+			replica, err := b.replicaLookup.Replica(TransactionStateTopicName, m.partition)
+			if err != nil || replica == nil {
+				continue
+			}
+			switch m.State {
+			case protocol.TransactionStateOngoing:
+				if now.Sub(m.StartTime) < m.Timeout {
+					continue
+				}
+				b.logger.Info("aborting timed out transaction", log.String("transactional id", m.TransactionalID))
+				if m.ProducerEpoch < math.MaxInt16-1 {
+					m.ProducerEpoch++
+				}
+				err := b.endTransaction(ctx, replica, m, false)
+				if err != protocol.ErrNone {
+					b.logger.Error("failed to abort timed out transaction", log.String("transactional id", m.TransactionalID), log.Error("error", err))
+				}
+			case protocol.TransactionStatePrepareCommit, protocol.TransactionStatePrepareAbort:
+				if err := b.completeTransaction(ctx, replica, m); err != protocol.ErrNone {
+					b.logger.Error("failed to complete transaction", log.String("transactional id", m.TransactionalID), log.Error("error", err))
+				}
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// abortTransaction aborts the transactional id's ongoing transaction for an operator, like it
+// timing out does, bumping its producer's epoch to fence it. A transaction that's already being
+// aborted but whose markers failed to be written is completed.
+func (b *Broker) abortTransaction(ctx *Context, id string) protocol.Error {
+	replica, err := b.transactionCoordinator(ctx, id)
+	if err != protocol.ErrNone {
+		return err
+	}
+	t := b.transactions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.byID[id]
+	if m == nil {
+		return protocol.ErrTransactionalIdNotFound
+	}
+	switch m.State {
+	case protocol.TransactionStateOngoing:
+		if m.ProducerEpoch < math.MaxInt16-1 {
+			m.ProducerEpoch++
+		}
+		return b.endTransaction(ctx, replica, m, false)
+	case protocol.TransactionStatePrepareAbort:
+		return b.completeTransaction(ctx, replica, m)
+	}
+	return protocol.ErrInvalidTxnState
+}
+
+// adminTransactions lists the transactions this broker coordinates on GET and aborts one on
+// DELETE, so a hung transaction that's blocking read committed consumers can be ended without
+// waiting for it to time out.
+//
+//	GET /v1/transactions
+//	DELETE /v1/transactions?transactional_id=<transactional id>
+func (b *Broker) adminTransactions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, struct {
+			Transactions []transactionMetadata `json:"transactions"`
+		}{b.transactionsState()})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("transactional_id")
+		if id == "" {
+			http.Error(w, "transactional_id is required", http.StatusBadRequest)
+			return
+		}
+		switch err := b.abortTransaction(&Context{parent: r.Context()}, id); err {
+		case protocol.ErrNone:
+			b.logger.Info("aborted transaction", log.String("transactional id", id))
+			w.WriteHeader(http.StatusNoContent)
+		case protocol.ErrTransactionalIdNotFound:
+			http.Error(w, "transaction not found", http.StatusNotFound)
+		case protocol.ErrNotCoordinator, protocol.ErrCoordinatorLoadInProgress:
+			http.Error(w, "broker isn't the transaction's coordinator", http.StatusServiceUnavailable)
+		case protocol.ErrInvalidTxnState:
+			http.Error(w, "transaction isn't ongoing", http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// transactionsState returns copies of the transactions this broker coordinates.
+func (b *Broker) transactionsState() []transactionMetadata {
+	t := b.transactions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	states := make([]transactionMetadata, 0, len(t.byID))
+	for _, m := range t.byID {
+		states = append(states, *m)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].TransactionalID < states[j].TransactionalID })
+	return states
+}
+
+// matchesTransactionFilters returns whether the transaction's listed by the ListTransactions
+// request, those with no filters listing every transaction.
+func matchesTransactionFilters(req *protocol.ListTransactionsRequest, m transactionMetadata) bool {
+	if len(req.StateFilters) > 0 {
+		matched := false
+		for _, state := range req.StateFilters {
+			matched = matched || state == m.State
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(req.ProducerIDFilters) == 0 {
+		return true
+	}
+	for _, id := range req.ProducerIDFilters {
+		if id == m.ProducerID {
+			return true
+		}
+	}
+	return false
+}
+
+// describeTransaction returns the DescribeTransactions state of the transaction.
+func describeTransaction(m transactionMetadata) protocol.DescribeTransactionState {
+	s := protocol.DescribeTransactionState{
+		ErrorCode:            protocol.ErrNone.Code(),
+		TransactionalID:      m.TransactionalID,
+		TransactionState:     m.State,
+		TransactionTimeout:   m.Timeout,
+		TransactionStartTime: m.StartTime,
+		ProducerID:           m.ProducerID,
+		ProducerEpoch:        m.ProducerEpoch,
+	}
+	for _, p := range m.Partitions {
+		if n := len(s.Topics); n > 0 && s.Topics[n-1].Topic == p.Topic {
+			s.Topics[n-1].Partitions = append(s.Topics[n-1].Partitions, p.Partition)
+			continue
+		}
+		s.Topics = append(s.Topics, protocol.DescribeTransactionTopic{Topic: p.Topic, Partitions: []int32{p.Partition}})
+	}
+	return s
+}
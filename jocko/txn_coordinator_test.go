@@ -0,0 +1,162 @@
+package jocko
+
+import (
+	"context"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_TransactionCoordinator(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.TransactionStateNumPartitions = 1
+	})
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+
+	transactionalID := "the-txn"
+	init := b.handleInitProducerID(ctx, &protocol.InitProducerIDRequest{TransactionalID: &transactionalID, TransactionTimeout: time.Minute})
+	require.Equal(t, protocol.ErrNone.Code(), init.ErrorCode)
+	require.Equal(t, int16(0), init.ProducerEpoch)
+	pid := init.ProducerID
+	_, topic, err := b.fsm.State().GetTopic(TransactionStateTopicName)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(topic.Partitions))
+
+	addPartitions := func(epoch int16, topics ...protocol.AddPartitionsToTxnTopic) *protocol.AddPartitionsToTxnResponse {
+		return b.handleAddPartitionsToTxn(ctx, &protocol.AddPartitionsToTxnRequest{
+			TransactionalID: transactionalID,
+			ProducerID:      pid,
+			ProducerEpoch:   epoch,
+			Topics:          topics,
+		})
+	}
+	endTxn := func(epoch int16, committed bool) int16 {
+		return b.handleEndTxn(ctx, &protocol.EndTxnRequest{
+			TransactionalID: transactionalID,
+			ProducerID:      pid,
+			ProducerEpoch:   epoch,
+			Committed:       committed,
+		}).ErrorCode
+	}
+	theTopic := protocol.AddPartitionsToTxnTopic{Topic: "the-topic", Partitions: []int32{0}}
+
+	// none are added if any are unknown
+	resp := addPartitions(0, theTopic, protocol.AddPartitionsToTxnTopic{Topic: "unknown-topic", Partitions: []int32{0}})
+	require.Equal(t, protocol.ErrOperationNotAttempted.Code(), resp.Results[0].Partitions[0].ErrorCode)
+	require.Equal(t, protocol.ErrUnknownTopicOrPartition.Code(), resp.Results[1].Partitions[0].ErrorCode)
+	resp = addPartitions(0, theTopic)
+	require.Equal(t, protocol.ErrNone.Code(), resp.Results[0].Partitions[0].ErrorCode)
+
+	produce := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+		Topic: "the-topic",
+		Data:  []*protocol.Data{{Partition: 0, RecordSet: testTransactionalBatch(pid, 0, 0)}},
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), produce.Responses[0].PartitionResponses[0].ErrorCode)
+
+	// the group's offsets are committed with the transaction
+	offsets := b.handleAddOffsetsToTxn(ctx, &protocol.AddOffsetsToTxnRequest{
+		TransactionalID: transactionalID,
+		ProducerID:      pid,
+		GroupID:         "the-group",
+	})
+	require.Equal(t, protocol.ErrNone.Code(), offsets.ErrorCode)
+	commit := b.handleTxnOffsetCommit(ctx, &protocol.TxnOffsetCommitRequest{
+		TransactionalID: transactionalID,
+		GroupID:         "the-group",
+		ProducerID:      pid,
+		Topics: []protocol.TxnOffsetCommitTopic{{
+			Topic:      "the-topic",
+			Partitions: []protocol.TxnOffsetCommitPartition{{Partition: 0, Offset: 1}},
+		}},
+	})
+	require.Equal(t, protocol.ErrNone.Code(), commit.Topics[0].Partitions[0].ErrorCode)
+	_, group, err := b.fsm.State().GetGroup("the-group")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(group.Offsets["the-topic"]))
+
+	describe := b.handleDescribeTransactions(ctx, &protocol.DescribeTransactionsRequest{TransactionalIDs: []string{transactionalID}})
+	require.Equal(t, protocol.ErrNone.Code(), describe.TransactionStates[0].ErrorCode)
+	require.Equal(t, protocol.TransactionStateOngoing, describe.TransactionStates[0].TransactionState)
+	require.Equal(t, []protocol.DescribeTransactionTopic{{Topic: "the-topic", Partitions: []int32{0}}}, describe.TransactionStates[0].Topics)
+
+	require.Equal(t, protocol.ErrNone.Code(), endTxn(0, true))
+	// retries are answered as if they'd ended the transaction
+	require.Equal(t, protocol.ErrNone.Code(), endTxn(0, true))
+	require.Equal(t, protocol.ErrInvalidTxnState.Code(), endTxn(0, false))
+	_, group, err = b.fsm.State().GetGroup("the-group")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), group.Offsets["the-topic"][0].Offset)
+
+	requireMarker := func(markerType int16) {
+		replica, err := b.replicaLookup.Replica("the-topic", 0)
+		require.NoError(t, err)
+		r, err := replica.Log.NewReader(0, math.MaxInt32)
+		require.NoError(t, err)
+		buf, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		batches, err := protocol.ReadRecordBatches(buf)
+		require.NoError(t, err)
+		last := batches[len(batches)-1]
+		require.True(t, last.Control())
+		require.Equal(t, pid, last.ProducerID)
+		typ, ok := protocol.ControlRecordType(last.Records[0])
+		require.True(t, ok)
+		require.Equal(t, markerType, typ)
+	}
+	requireMarker(protocol.ControlRecordCommit)
+
+	list := b.handleListTransactions(ctx, &protocol.ListTransactionsRequest{StateFilters: []string{protocol.TransactionStateCompleteCommit}})
+	require.Equal(t, []protocol.ListTransactionsState{{
+		TransactionalID:  transactionalID,
+		ProducerID:       pid,
+		TransactionState: protocol.TransactionStateCompleteCommit,
+	}}, list.TransactionStates)
+
+	resp = addPartitions(0, theTopic)
+	require.Equal(t, protocol.ErrNone.Code(), resp.Results[0].Partitions[0].ErrorCode)
+	require.Equal(t, protocol.ErrNone.Code(), endTxn(0, false))
+	requireMarker(protocol.ControlRecordAbort)
+
+	// a new instance of the producer fences the old one, aborting its ongoing transaction
+	resp = addPartitions(0, theTopic)
+	require.Equal(t, protocol.ErrNone.Code(), resp.Results[0].Partitions[0].ErrorCode)
+	init = b.handleInitProducerID(ctx, &protocol.InitProducerIDRequest{TransactionalID: &transactionalID, TransactionTimeout: time.Minute})
+	require.Equal(t, protocol.ErrNone.Code(), init.ErrorCode)
+	require.Equal(t, pid, init.ProducerID)
+	require.Equal(t, int16(1), init.ProducerEpoch)
+	requireMarker(protocol.ControlRecordAbort)
+	resp = addPartitions(0, theTopic)
+	require.Equal(t, protocol.ErrInvalidProducerEpoch.Code(), resp.Results[0].Partitions[0].ErrorCode)
+	require.Equal(t, protocol.ErrInvalidProducerEpoch.Code(), endTxn(0, true))
+
+	// the coordinator's transactions are loaded from the state topic when it becomes its leader
+	b.transactions.unload(0)
+	state, err := b.replicaLookup.Replica(TransactionStateTopicName, 0)
+	require.NoError(t, err)
+	b.loadTransactions(state)
+	describe = b.handleDescribeTransactions(ctx, &protocol.DescribeTransactionsRequest{TransactionalIDs: []string{transactionalID}})
+	require.Equal(t, protocol.TransactionStateCompleteAbort, describe.TransactionStates[0].TransactionState)
+	require.Equal(t, int16(1), describe.TransactionStates[0].ProducerEpoch)
+}
+
+// testTransactionalBatch returns a record batch the producer appends in its transaction.
+func testTransactionalBatch(producerID int64, epoch int16, baseSequence int32) []byte {
+	b := testRecordBatch(producerID, epoch, baseSequence, 0)
+	protocol.Encoding.PutUint16(b[21:], protocol.RecordBatchTransactional)
+	protocol.Encoding.PutUint32(b[17:], crc32.Checksum(b[21:], crc32.MakeTable(crc32.Castagnoli)))
+	return b
+}
@@ -0,0 +1,216 @@
+package jocko
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+// abortedTxn is a transaction aborted in a partition, read committed consumers skip the
+// producer's batches from its first offset up to the abort marker at its last.
+type abortedTxn struct {
+	producerID  int64
+	firstOffset int64
+	lastOffset  int64
+}
+
+// partitionTxns is what a partition's replica knows of the transactions written to it.
+type partitionTxns struct {
+	// ongoing are the offsets of the first batches of the producers' ongoing transactions.
+	ongoing map[int64]int64
+	// aborted are the aborted transactions ordered by their last offsets.
+	aborted []abortedTxn
+}
+
+// txnIndexes holds the transactions of this broker's partitions, to find their last stable
+// offsets and the aborted transactions read committed fetches return.
+type txnIndexes struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionTxns
+}
+
+func newTxnIndexes() *txnIndexes {
+	return &txnIndexes{
+		partitions: make(map[topicPartition]*partitionTxns),
+	}
+}
+
+// update records the transactional batches and markers in the record set appended to the
+// partition, whose batches have been given their offsets.
+func (t *txnIndexes) update(topic string, partition int32, recordSet []byte) {
+	batches, err := protocol.ReadRecordBatches(recordSet)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(topicPartition{topic: topic, partition: partition}, batches)
+}
+
+// add records the appended batches. It's called with the lock held.
+func (t *txnIndexes) add(key topicPartition, batches []*protocol.RecordBatch) {
+	for _, batch := range batches {
+		if !batch.Transactional() {
+			continue
+		}
+		txns, ok := t.partitions[key]
+		if !ok {
+			txns = &partitionTxns{ongoing: make(map[int64]int64)}
+			t.partitions[key] = txns
+		}
+		if !batch.Control() {
+			if _, ok := txns.ongoing[batch.ProducerID]; !ok {
+				txns.ongoing[batch.ProducerID] = batch.BaseOffset
+			}
+			continue
+		}
+		if len(batch.Records) == 0 {
+			continue
+		}
+		typ, ok := protocol.ControlRecordType(batch.Records[0])
+		if !ok {
+			continue
+		}
+		first, ok := txns.ongoing[batch.ProducerID]
+		if !ok {
+			// the transaction wrote nothing to the partition
+			continue
+		}
+		delete(txns.ongoing, batch.ProducerID)
+		if typ == protocol.ControlRecordAbort {
+			txns.aborted = append(txns.aborted, abortedTxn{
+				producerID:  batch.ProducerID,
+				firstOffset: first,
+				lastOffset:  batch.BaseOffset,
+			})
+		}
+	}
+}
+
+// load rebuilds the partition's transactions from its log, like when its replica's opened after
+// the broker restarts. Batches appended together before each was given its own offset keep the
+// zero base offsets producers send, they're taken to follow the batch before them.
+func (t *txnIndexes) load(topic string, partition int32, l CommitLog) error {
+	key := topicPartition{topic: topic, partition: partition}
+	t.mu.Lock()
+	delete(t.partitions, key)
+	t.mu.Unlock()
+	if l.NewestOffset() <= l.OldestOffset() {
+		return nil
+	}
+	r, err := l.NewReader(l.OldestOffset(), math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	offset := int64(0)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		size := int32(protocol.Encoding.Uint32(header[8:]))
+		if size < 0 {
+			return nil
+		}
+		entry := make([]byte, 12+int(size))
+		copy(entry, header)
+		if _, err := io.ReadFull(br, entry[12:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		batches, err := protocol.ReadRecordBatches(entry)
+		if err != nil {
+			continue
+		}
+		for _, batch := range batches {
+			if batch.BaseOffset < offset {
+				batch.BaseOffset = offset
+			}
+			offset = batch.BaseOffset + int64(batch.LastOffsetDelta) + 1
+		}
+		t.mu.Lock()
+		t.add(key, batches)
+		t.mu.Unlock()
+	}
+}
+
+// firstUnstableOffset returns the first offset of the partition's earliest ongoing transaction,
+// or false if it has none.
+func (t *txnIndexes) firstUnstableOffset(topic string, partition int32) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	txns, ok := t.partitions[topicPartition{topic: topic, partition: partition}]
+	if !ok || len(txns.ongoing) == 0 {
+		return 0, false
+	}
+	first := int64(math.MaxInt64)
+	for _, offset := range txns.ongoing {
+		if offset < first {
+			first = offset
+		}
+	}
+	return first, true
+}
+
+// abortedTransactions returns the partition's aborted transactions with batches between the
+// offsets, from inclusive and to exclusive.
+func (t *txnIndexes) abortedTransactions(topic string, partition int32, from, to int64) []*protocol.AbortedTransaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	txns, ok := t.partitions[topicPartition{topic: topic, partition: partition}]
+	if !ok {
+		return nil
+	}
+	var aborted []*protocol.AbortedTransaction
+	i := sort.Search(len(txns.aborted), func(i int) bool { return txns.aborted[i].lastOffset >= from })
+	for _, txn := range txns.aborted[i:] {
+		if txn.firstOffset >= to {
+			continue
+		}
+		aborted = append(aborted, &protocol.AbortedTransaction{ProducerID: txn.producerID, FirstOffset: txn.firstOffset})
+	}
+	return aborted
+}
+
+// remove forgets the partition's transactions once its replica's gone from this broker.
+func (t *txnIndexes) remove(topic string, partition int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.partitions, topicPartition{topic: topic, partition: partition})
+}
+
+// lastStableOffset returns the offset following the partition's stable messages, those that
+// aren't part of ongoing transactions, given its log end offset.
+func (b *Broker) lastStableOffset(topic string, partition int32, logEndOffset int64) int64 {
+	if first, ok := b.txnIndexes.firstUnstableOffset(topic, partition); ok && first < logEndOffset {
+		return first
+	}
+	return logEndOffset
+}
+
+// truncateRecordSet returns the record set read from a log without its entries at or after the
+// offset.
+func truncateRecordSet(recordSet []byte, offset int64) []byte {
+	n := 0
+	for n+12 <= len(recordSet) {
+		if int64(protocol.Encoding.Uint64(recordSet[n:])) >= offset {
+			break
+		}
+		size := int(int32(protocol.Encoding.Uint32(recordSet[n+8:])))
+		if size < 0 || n+12+size > len(recordSet) {
+			break
+		}
+		n += 12 + size
+	}
+	return recordSet[:n]
+}
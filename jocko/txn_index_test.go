@@ -0,0 +1,126 @@
+package jocko
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/jocko/jocko/config"
+	"github.com/travisjeffery/jocko/protocol"
+)
+
+func TestBroker_FetchReadCommitted(t *testing.T) {
+	b, teardown := newTestLeader(t, func(cfg *config.Config) {
+		cfg.TransactionStateNumPartitions = 1
+	})
+	defer teardown()
+	ctx := &Context{parent: context.Background()}
+
+	create := b.handleCreateTopic(ctx, &protocol.CreateTopicRequests{Requests: []*protocol.CreateTopicRequest{{
+		Topic:             "the-topic",
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}})
+	require.Equal(t, protocol.ErrNone.Code(), create.TopicErrorCodes[0].ErrorCode)
+	produce := func(recordSet []byte) {
+		resp := b.handleProduce(ctx, &protocol.ProduceRequest{TopicData: []*protocol.TopicData{{
+			Topic: "the-topic",
+			Data:  []*protocol.Data{{Partition: 0, RecordSet: recordSet}},
+		}}})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Responses[0].PartitionResponses[0].ErrorCode)
+	}
+	fetch := func(offset int64, isolation protocol.IsolationLevel) *protocol.FetchPartitionResponse {
+		resp := b.handleFetch(ctx, &protocol.FetchRequest{
+			ReplicaID:      -1,
+			MinBytes:       1,
+			IsolationLevel: isolation,
+			Topics: []*protocol.FetchTopic{{
+				Topic:      "the-topic",
+				Partitions: []*protocol.FetchPartition{{Partition: 0, FetchOffset: offset, MaxBytes: 1 << 20}},
+			}},
+		})
+		p := resp.Responses[0].PartitionResponses[0]
+		require.Equal(t, protocol.ErrNone.Code(), p.ErrorCode)
+		return p
+	}
+	latest := func(isolation protocol.IsolationLevel) int64 {
+		resp := b.handleOffsets(ctx, &protocol.OffsetsRequest{
+			APIVersion:     1,
+			ReplicaID:      -1,
+			IsolationLevel: int8(isolation),
+			Topics:         []*protocol.OffsetsTopic{{Topic: "the-topic", Partitions: []*protocol.OffsetsPartition{{Partition: 0, Timestamp: -1}}}},
+		})
+		return resp.Responses[0].PartitionResponses[0].Offset
+	}
+	batches := func(recordSet []byte) int {
+		bs, err := protocol.ReadRecordBatches(recordSet)
+		require.NoError(t, err)
+		return len(bs)
+	}
+
+	transactionalID := "the-txn"
+	init := b.handleInitProducerID(ctx, &protocol.InitProducerIDRequest{TransactionalID: &transactionalID, TransactionTimeout: time.Minute})
+	require.Equal(t, protocol.ErrNone.Code(), init.ErrorCode)
+	pid := init.ProducerID
+	begin := func() {
+		resp := b.handleAddPartitionsToTxn(ctx, &protocol.AddPartitionsToTxnRequest{
+			TransactionalID: transactionalID,
+			ProducerID:      pid,
+			Topics:          []protocol.AddPartitionsToTxnTopic{{Topic: "the-topic", Partitions: []int32{0}}},
+		})
+		require.Equal(t, protocol.ErrNone.Code(), resp.Results[0].Partitions[0].ErrorCode)
+	}
+	end := func(committed bool) {
+		resp := b.handleEndTxn(ctx, &protocol.EndTxnRequest{TransactionalID: transactionalID, ProducerID: pid, Committed: committed})
+		require.Equal(t, protocol.ErrNone.Code(), resp.ErrorCode)
+	}
+
+	produce(testRecordBatch(-1, -1, -1, 0))
+	begin()
+	produce(testTransactionalBatch(pid, 0, 0))
+
+	// the ongoing transaction's batch isn't stable
+	p := fetch(0, protocol.ReadCommitted)
+	require.Equal(t, int64(1), p.HighWatermark)
+	require.Equal(t, int64(0), p.LastStableOffset)
+	require.Equal(t, 1, batches(p.RecordSet))
+	require.Equal(t, 2, batches(fetch(0, protocol.ReadUncommitted).RecordSet))
+	require.Equal(t, int64(1), latest(protocol.ReadCommitted))
+	require.Equal(t, int64(2), latest(protocol.ReadUncommitted))
+
+	end(false)
+	p = fetch(0, protocol.ReadCommitted)
+	require.Equal(t, int64(2), p.LastStableOffset)
+	require.Equal(t, 3, batches(p.RecordSet))
+	require.Equal(t, []*protocol.AbortedTransaction{{ProducerID: pid, FirstOffset: 1}}, p.AbortedTransactions)
+
+	begin()
+	produce(testTransactionalBatch(pid, 0, 1))
+	end(true)
+	p = fetch(3, protocol.ReadCommitted)
+	require.Equal(t, int64(4), p.LastStableOffset)
+	require.Equal(t, 2, batches(p.RecordSet))
+	require.Empty(t, p.AbortedTransactions)
+
+	// the transactions are rebuilt from the log when its replica's opened
+	replica, err := b.replicaLookup.Replica("the-topic", 0)
+	require.NoError(t, err)
+	b.txnIndexes.remove("the-topic", 0)
+	require.NoError(t, b.txnIndexes.load("the-topic", 0, replica.Log))
+	require.Equal(t, []*protocol.AbortedTransaction{{ProducerID: pid, FirstOffset: 1}}, b.txnIndexes.abortedTransactions("the-topic", 0, 0, 5))
+	_, ongoing := b.txnIndexes.firstUnstableOffset("the-topic", 0)
+	require.False(t, ongoing)
+}
+
+func TestTruncateRecordSet(t *testing.T) {
+	var recordSet []byte
+	for offset := int64(0); offset < 3; offset++ {
+		batch := testRecordBatch(-1, -1, -1, 0)
+		protocol.Encoding.PutUint64(batch, uint64(offset))
+		recordSet = append(recordSet, batch...)
+	}
+	require.Equal(t, recordSet[:2*61], truncateRecordSet(recordSet, 2))
+	require.Equal(t, recordSet, truncateRecordSet(recordSet, 3))
+	require.Empty(t, truncateRecordSet(recordSet, 0))
+}
@@ -58,6 +58,10 @@ func Int(key string, val int) Field {
 	return z.Int(key, val)
 }
 
+func Bool(key string, val bool) Field {
+	return z.Bool(key, val)
+}
+
 func Int16(key string, val int16) Field {
 	return z.Int16(key, val)
 }
@@ -74,6 +78,10 @@ func Uint32(key string, val uint32) Field {
 	return z.Uint32(key, val)
 }
 
+func Uint64(key string, val uint64) Field {
+	return z.Uint64(key, val)
+}
+
 func Duration(key string, val time.Duration) Field {
 	return z.Duration(key, val)
 }
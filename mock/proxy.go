@@ -27,9 +27,15 @@ func (p *Client) Fetch(fetchRequest *protocol.FetchRequest) (*protocol.FetchResp
 	if len(p.msgs) >= p.msgCount {
 		return &protocol.FetchResponse{}, nil
 	}
-	msgs := [][]byte{
-		[]byte("msg " + strconv.Itoa(len(p.msgs))),
+	// each batch is at the offset after the last
+	batch := &protocol.RecordBatch{
+		BaseOffset:    int64(len(p.msgs)),
+		ProducerID:    -1,
+		ProducerEpoch: -1,
+		BaseSequence:  -1,
+		Records:       []protocol.Record{{Value: []byte("msg " + strconv.Itoa(len(p.msgs)))}},
 	}
+	msgs := [][]byte{batch.Bytes()}
 	response := &protocol.FetchResponse{
 		Responses: protocol.FetchTopicResponses{{
 			Topic: fetchRequest.Topics[0].Topic,
@@ -51,3 +57,7 @@ func (p *Client) CreateTopics(createRequest *protocol.CreateTopicRequests) (*pro
 func (p *Client) LeaderAndISR(request *protocol.LeaderAndISRRequest) (*protocol.LeaderAndISRResponse, error) {
 	return nil, nil
 }
+
+func (p *Client) OffsetForLeaderEpoch(request *protocol.OffsetForLeaderEpochRequest) (*protocol.OffsetForLeaderEpochResponse, error) {
+	return nil, nil
+}
@@ -0,0 +1,106 @@
+package protocol
+
+// Resource types of ACLs.
+const (
+	ResourceTypeUnknown         int8 = 0
+	ResourceTypeAny             int8 = 1
+	ResourceTypeTopic           int8 = 2
+	ResourceTypeGroup           int8 = 3
+	ResourceTypeCluster         int8 = 4
+	ResourceTypeTransactionalID int8 = 5
+	ResourceTypeDelegationToken int8 = 6
+)
+
+// Pattern types of ACLs' resource names. Literal names match the resource with the name, or any
+// resource if it's the wildcard "*", and prefixed names match the resources whose names start
+// with them. Filters' any matches ACLs with any pattern type and match matches the ACLs that
+// apply to the filter's resource name.
+const (
+	PatternTypeUnknown  int8 = 0
+	PatternTypeAny      int8 = 1
+	PatternTypeMatch    int8 = 2
+	PatternTypeLiteral  int8 = 3
+	PatternTypePrefixed int8 = 4
+)
+
+// Operations ACLs allow or deny.
+const (
+	AclOperationUnknown         int8 = 0
+	AclOperationAny             int8 = 1
+	AclOperationAll             int8 = 2
+	AclOperationRead            int8 = 3
+	AclOperationWrite           int8 = 4
+	AclOperationCreate          int8 = 5
+	AclOperationDelete          int8 = 6
+	AclOperationAlter           int8 = 7
+	AclOperationDescribe        int8 = 8
+	AclOperationClusterAction   int8 = 9
+	AclOperationDescribeConfigs int8 = 10
+	AclOperationAlterConfigs    int8 = 11
+	AclOperationIdempotentWrite int8 = 12
+)
+
+// Permission types of ACLs.
+const (
+	AclPermissionUnknown int8 = 0
+	AclPermissionAny     int8 = 1
+	AclPermissionDeny    int8 = 2
+	AclPermissionAllow   int8 = 3
+)
+
+// AclFilter matches ACLs, nil names, principals and hosts match any.
+type AclFilter struct {
+	ResourceType   int8
+	ResourceName   *string
+	PatternType    int8
+	Principal      *string
+	Host           *string
+	Operation      int8
+	PermissionType int8
+}
+
+func (f *AclFilter) encode(e PacketEncoder, version int16) (err error) {
+	e.PutInt8(f.ResourceType)
+	if err = e.PutNullableString(f.ResourceName); err != nil {
+		return err
+	}
+	if version >= 1 {
+		e.PutInt8(f.PatternType)
+	}
+	if err = e.PutNullableString(f.Principal); err != nil {
+		return err
+	}
+	if err = e.PutNullableString(f.Host); err != nil {
+		return err
+	}
+	e.PutInt8(f.Operation)
+	e.PutInt8(f.PermissionType)
+	return nil
+}
+
+func (f *AclFilter) decode(d PacketDecoder, version int16) (err error) {
+	if f.ResourceType, err = d.Int8(); err != nil {
+		return err
+	}
+	if f.ResourceName, err = d.NullableString(); err != nil {
+		return err
+	}
+	// v0 only has literal resource names
+	f.PatternType = PatternTypeLiteral
+	if version >= 1 {
+		if f.PatternType, err = d.Int8(); err != nil {
+			return err
+		}
+	}
+	if f.Principal, err = d.NullableString(); err != nil {
+		return err
+	}
+	if f.Host, err = d.NullableString(); err != nil {
+		return err
+	}
+	if f.Operation, err = d.Int8(); err != nil {
+		return err
+	}
+	f.PermissionType, err = d.Int8()
+	return err
+}
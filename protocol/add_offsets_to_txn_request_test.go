@@ -0,0 +1,24 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddOffsetsToTxnRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &AddOffsetsToTxnRequest{
+		APIVersion:      1,
+		TransactionalID: "the-txn",
+		ProducerID:      7,
+		ProducerEpoch:   2,
+		GroupID:         "the-group",
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AddOffsetsToTxnRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type AddOffsetsToTxnResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	ErrorCode    int16
+}
+
+func (r *AddOffsetsToTxnResponse) Encode(e PacketEncoder) error {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	e.PutInt16(r.ErrorCode)
+	return nil
+}
+
+func (r *AddOffsetsToTxnResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	r.ErrorCode, err = d.Int16()
+	return err
+}
+
+func (r *AddOffsetsToTxnResponse) Key() int16 {
+	return AddOffsetsToTxnKey
+}
+
+func (r *AddOffsetsToTxnResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *AddOffsetsToTxnResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	return nil
+}
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddOffsetsToTxnResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &AddOffsetsToTxnResponse{
+		APIVersion:   1,
+		ThrottleTime: time.Second,
+		ErrorCode:    ErrConcurrentTransactions.Code(),
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AddOffsetsToTxnResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_AddPartitionsToTxn
+
+type AddPartitionsToTxnRequest struct {
+	APIVersion int16
+
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+	Topics          []AddPartitionsToTxnTopic
+}
+
+type AddPartitionsToTxnTopic struct {
+	Topic      string
+	Partitions []int32
+}
+
+func (r *AddPartitionsToTxnRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutString(r.TransactionalID); err != nil {
+		return err
+	}
+	e.PutInt64(r.ProducerID)
+	e.PutInt16(r.ProducerEpoch)
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutInt32Array(t.Partitions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *AddPartitionsToTxnRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.TransactionalID, err = d.String(); err != nil {
+		return err
+	}
+	if r.ProducerID, err = d.Int64(); err != nil {
+		return err
+	}
+	if r.ProducerEpoch, err = d.Int16(); err != nil {
+		return err
+	}
+	topicCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]AddPartitionsToTxnTopic, topicCount)
+	for i := range r.Topics {
+		t := AddPartitionsToTxnTopic{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		if t.Partitions, err = d.Int32Array(); err != nil {
+			return err
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *AddPartitionsToTxnRequest) Key() int16 {
+	return AddPartitionsToTxnKey
+}
+
+func (r *AddPartitionsToTxnRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *AddPartitionsToTxnRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddString("transactional id", r.TransactionalID)
+	e.AddInt64("producer id", r.ProducerID)
+	e.AddInt16("producer epoch", r.ProducerEpoch)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
@@ -0,0 +1,27 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddPartitionsToTxnRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &AddPartitionsToTxnRequest{
+		APIVersion:      1,
+		TransactionalID: "the-txn",
+		ProducerID:      7,
+		ProducerEpoch:   2,
+		Topics: []AddPartitionsToTxnTopic{
+			{Topic: "the-topic", Partitions: []int32{0, 2}},
+			{Topic: "another-topic", Partitions: []int32{1}},
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AddPartitionsToTxnRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
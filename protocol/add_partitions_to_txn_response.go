@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type AddPartitionsToTxnResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	Results      []AddPartitionsToTxnTopicResult
+}
+
+type AddPartitionsToTxnTopicResult struct {
+	Topic      string
+	Partitions []AddPartitionsToTxnPartitionResult
+}
+
+type AddPartitionsToTxnPartitionResult struct {
+	Partition int32
+	ErrorCode int16
+}
+
+func (r *AddPartitionsToTxnResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Results)); err != nil {
+		return err
+	}
+	for _, t := range r.Results {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt16(p.ErrorCode)
+		}
+	}
+	return nil
+}
+
+func (r *AddPartitionsToTxnResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	topicCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Results = make([]AddPartitionsToTxnTopicResult, topicCount)
+	for i := range r.Results {
+		t := AddPartitionsToTxnTopicResult{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]AddPartitionsToTxnPartitionResult, partitionCount)
+		for j := range t.Partitions {
+			p := AddPartitionsToTxnPartitionResult{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Results[i] = t
+	}
+	return nil
+}
+
+func (r *AddPartitionsToTxnResponse) Key() int16 {
+	return AddPartitionsToTxnKey
+}
+
+func (r *AddPartitionsToTxnResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *AddPartitionsToTxnResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("results", len(r.Results))
+	return nil
+}
@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddPartitionsToTxnResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &AddPartitionsToTxnResponse{
+		APIVersion:   1,
+		ThrottleTime: time.Second,
+		Results: []AddPartitionsToTxnTopicResult{{
+			Topic: "the-topic",
+			Partitions: []AddPartitionsToTxnPartitionResult{
+				{Partition: 0, ErrorCode: ErrNone.Code()},
+				{Partition: 2, ErrorCode: ErrUnknownTopicOrPartition.Code()},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AddPartitionsToTxnResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_AlterPartitionReassignments
+
+type AlterPartitionReassignmentsRequest struct {
+	APIVersion int16
+
+	Timeout time.Duration
+	Topics  []AlterPartitionReassignmentsTopic
+}
+
+type AlterPartitionReassignmentsTopic struct {
+	Topic      string
+	Partitions []AlterPartitionReassignmentsPartition
+}
+
+type AlterPartitionReassignmentsPartition struct {
+	Partition int32
+	// Replicas are the partition's target replicas, nil cancels its ongoing reassignment.
+	Replicas []int32
+}
+
+func (r *AlterPartitionReassignmentsRequest) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.Timeout / time.Millisecond))
+	if err = e.PutCompactArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutCompactString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutCompactArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			if p.Replicas == nil {
+				err = e.PutCompactArrayLength(-1)
+			} else {
+				err = e.PutCompactInt32Array(p.Replicas)
+			}
+			if err != nil {
+				return err
+			}
+			e.PutEmptyTaggedFields()
+		}
+		e.PutEmptyTaggedFields()
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *AlterPartitionReassignmentsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	timeout, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.Timeout = time.Duration(timeout) * time.Millisecond
+	n, err := d.CompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Topics = make([]AlterPartitionReassignmentsTopic, n)
+	}
+	for i := range r.Topics {
+		t := AlterPartitionReassignmentsTopic{}
+		if t.Topic, err = d.CompactString(); err != nil {
+			return err
+		}
+		pn, err := d.CompactArrayLength()
+		if err != nil {
+			return err
+		}
+		if pn > 0 {
+			t.Partitions = make([]AlterPartitionReassignmentsPartition, pn)
+		}
+		for j := range t.Partitions {
+			p := AlterPartitionReassignmentsPartition{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			// the replicas are nullable, unlike other compact arrays, so null and empty differ
+			rn, err := d.CompactArrayLength()
+			if err != nil {
+				return err
+			}
+			if rn >= 0 {
+				p.Replicas = make([]int32, rn)
+			}
+			for k := range p.Replicas {
+				if p.Replicas[k], err = d.Int32(); err != nil {
+					return err
+				}
+			}
+			if err = d.TaggedFields(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		if err = d.TaggedFields(); err != nil {
+			return err
+		}
+		r.Topics[i] = t
+	}
+	return d.TaggedFields()
+}
+
+func (r *AlterPartitionReassignmentsRequest) Key() int16 {
+	return AlterPartitionReassignmentsKey
+}
+
+func (r *AlterPartitionReassignmentsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *AlterPartitionReassignmentsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddDuration("timeout", r.Timeout)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlterPartitionReassignmentsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &AlterPartitionReassignmentsRequest{
+		Timeout: time.Minute,
+		Topics: []AlterPartitionReassignmentsTopic{{
+			Topic: "the-topic",
+			Partitions: []AlterPartitionReassignmentsPartition{
+				{Partition: 0, Replicas: []int32{2, 3}},
+				// cancels the reassignment
+				{Partition: 1},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AlterPartitionReassignmentsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type AlterPartitionReassignmentsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	ErrorCode    int16
+	ErrorMessage *string
+	Topics       []AlterPartitionReassignmentsTopicResponse
+}
+
+type AlterPartitionReassignmentsTopicResponse struct {
+	Topic      string
+	Partitions []AlterPartitionReassignmentsPartitionResponse
+}
+
+type AlterPartitionReassignmentsPartitionResponse struct {
+	Partition    int32
+	ErrorCode    int16
+	ErrorMessage *string
+}
+
+func (r *AlterPartitionReassignmentsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	e.PutInt16(r.ErrorCode)
+	if err = e.PutCompactNullableString(r.ErrorMessage); err != nil {
+		return err
+	}
+	if err = e.PutCompactArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutCompactString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutCompactArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt16(p.ErrorCode)
+			if err = e.PutCompactNullableString(p.ErrorMessage); err != nil {
+				return err
+			}
+			e.PutEmptyTaggedFields()
+		}
+		e.PutEmptyTaggedFields()
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *AlterPartitionReassignmentsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	if r.ErrorMessage, err = d.CompactNullableString(); err != nil {
+		return err
+	}
+	n, err := d.CompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Topics = make([]AlterPartitionReassignmentsTopicResponse, n)
+	}
+	for i := range r.Topics {
+		t := AlterPartitionReassignmentsTopicResponse{}
+		if t.Topic, err = d.CompactString(); err != nil {
+			return err
+		}
+		pn, err := d.CompactArrayLength()
+		if err != nil {
+			return err
+		}
+		if pn > 0 {
+			t.Partitions = make([]AlterPartitionReassignmentsPartitionResponse, pn)
+		}
+		for j := range t.Partitions {
+			p := AlterPartitionReassignmentsPartitionResponse{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			if p.ErrorMessage, err = d.CompactNullableString(); err != nil {
+				return err
+			}
+			if err = d.TaggedFields(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		if err = d.TaggedFields(); err != nil {
+			return err
+		}
+		r.Topics[i] = t
+	}
+	return d.TaggedFields()
+}
+
+func (r *AlterPartitionReassignmentsResponse) Key() int16 {
+	return AlterPartitionReassignmentsKey
+}
+
+func (r *AlterPartitionReassignmentsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *AlterPartitionReassignmentsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
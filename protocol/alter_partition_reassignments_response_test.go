@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlterPartitionReassignmentsResponse(t *testing.T) {
+	req := require.New(t)
+	msg := "no reassignment in progress"
+	exp := &AlterPartitionReassignmentsResponse{
+		ThrottleTime: time.Millisecond,
+		Topics: []AlterPartitionReassignmentsTopicResponse{{
+			Topic: "the-topic",
+			Partitions: []AlterPartitionReassignmentsPartitionResponse{
+				{Partition: 0},
+				{Partition: 1, ErrorCode: ErrNoReassignmentInProgress.Code(), ErrorMessage: &msg},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AlterPartitionReassignmentsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
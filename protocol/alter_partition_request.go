@@ -0,0 +1,125 @@
+package protocol
+
+import "go.uber.org/zap/zapcore"
+
+// https://kafka.apache.org/protocol#The_Messages_AlterPartition
+
+// AlterPartitionRequest is sent by a partition's leader to the controller to change its isr, as
+// followers fall behind or catch up.
+type AlterPartitionRequest struct {
+	APIVersion int16
+
+	BrokerID    int32
+	BrokerEpoch int64
+	Topics      []AlterPartitionTopic
+}
+
+type AlterPartitionTopic struct {
+	Topic      string
+	Partitions []AlterPartitionPartition
+}
+
+type AlterPartitionPartition struct {
+	Partition   int32
+	LeaderEpoch int32
+	NewISR      []int32
+	// PartitionEpoch is the version of the partition's state the leader has, -1 if it doesn't
+	// know it.
+	PartitionEpoch int32
+}
+
+func (r *AlterPartitionRequest) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(r.BrokerID)
+	e.PutInt64(r.BrokerEpoch)
+	if err = e.PutCompactArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutCompactString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutCompactArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt32(p.LeaderEpoch)
+			if err = e.PutCompactInt32Array(p.NewISR); err != nil {
+				return err
+			}
+			e.PutInt32(p.PartitionEpoch)
+			e.PutEmptyTaggedFields()
+		}
+		e.PutEmptyTaggedFields()
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *AlterPartitionRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.BrokerID, err = d.Int32(); err != nil {
+		return err
+	}
+	if r.BrokerEpoch, err = d.Int64(); err != nil {
+		return err
+	}
+	n, err := d.CompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Topics = make([]AlterPartitionTopic, n)
+	}
+	for i := range r.Topics {
+		t := AlterPartitionTopic{}
+		if t.Topic, err = d.CompactString(); err != nil {
+			return err
+		}
+		pn, err := d.CompactArrayLength()
+		if err != nil {
+			return err
+		}
+		if pn > 0 {
+			t.Partitions = make([]AlterPartitionPartition, pn)
+		}
+		for j := range t.Partitions {
+			p := AlterPartitionPartition{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.LeaderEpoch, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.NewISR, err = d.CompactInt32Array(); err != nil {
+				return err
+			}
+			if p.PartitionEpoch, err = d.Int32(); err != nil {
+				return err
+			}
+			if err = d.TaggedFields(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		if err = d.TaggedFields(); err != nil {
+			return err
+		}
+		r.Topics[i] = t
+	}
+	return d.TaggedFields()
+}
+
+func (r *AlterPartitionRequest) Key() int16 {
+	return AlterPartitionKey
+}
+
+func (r *AlterPartitionRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *AlterPartitionRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt32("broker id", r.BrokerID)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
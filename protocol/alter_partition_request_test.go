@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlterPartitionRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &AlterPartitionRequest{
+		BrokerID:    1,
+		BrokerEpoch: -1,
+		Topics: []AlterPartitionTopic{{
+			Topic: "the-topic",
+			Partitions: []AlterPartitionPartition{
+				{Partition: 0, LeaderEpoch: 2, NewISR: []int32{1, 2}, PartitionEpoch: -1},
+				{Partition: 1, LeaderEpoch: 3, NewISR: []int32{1}, PartitionEpoch: -1},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AlterPartitionRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlterPartitionResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &AlterPartitionResponse{
+		ThrottleTime: time.Millisecond,
+		Topics: []AlterPartitionTopicResponse{{
+			Topic: "the-topic",
+			Partitions: []AlterPartitionPartitionResponse{
+				{Partition: 0, LeaderID: 1, LeaderEpoch: 2, ISR: []int32{1, 2}, PartitionEpoch: -1},
+				{Partition: 1, ErrorCode: ErrFencedLeaderEpoch.Code(), LeaderID: 2, LeaderEpoch: 4, ISR: []int32{2}, PartitionEpoch: -1},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AlterPartitionResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
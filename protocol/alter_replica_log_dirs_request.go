@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_AlterReplicaLogDirs
+
+type AlterReplicaLogDirsRequest struct {
+	APIVersion int16
+
+	Dirs []AlterReplicaLogDir
+}
+
+// AlterReplicaLogDir lists the partitions to move to the log dir at Path.
+type AlterReplicaLogDir struct {
+	Path   string
+	Topics []AlterReplicaLogDirTopic
+}
+
+type AlterReplicaLogDirTopic struct {
+	Topic      string
+	Partitions []int32
+}
+
+func (r *AlterReplicaLogDirsRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutArrayLength(len(r.Dirs)); err != nil {
+		return err
+	}
+	for _, dir := range r.Dirs {
+		if err = e.PutString(dir.Path); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(dir.Topics)); err != nil {
+			return err
+		}
+		for _, t := range dir.Topics {
+			if err = e.PutString(t.Topic); err != nil {
+				return err
+			}
+			if err = e.PutInt32Array(t.Partitions); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *AlterReplicaLogDirsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Dirs = make([]AlterReplicaLogDir, n)
+	for i := range r.Dirs {
+		dir := AlterReplicaLogDir{}
+		if dir.Path, err = d.String(); err != nil {
+			return err
+		}
+		topicCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		dir.Topics = make([]AlterReplicaLogDirTopic, topicCount)
+		for j := range dir.Topics {
+			t := AlterReplicaLogDirTopic{}
+			if t.Topic, err = d.String(); err != nil {
+				return err
+			}
+			if t.Partitions, err = d.Int32Array(); err != nil {
+				return err
+			}
+			dir.Topics[j] = t
+		}
+		r.Dirs[i] = dir
+	}
+	return nil
+}
+
+func (r *AlterReplicaLogDirsRequest) Key() int16 {
+	return AlterReplicaLogDirsKey
+}
+
+func (r *AlterReplicaLogDirsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *AlterReplicaLogDirsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("dirs", len(r.Dirs))
+	return nil
+}
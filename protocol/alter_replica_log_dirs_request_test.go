@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlterReplicaLogDirsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &AlterReplicaLogDirsRequest{
+		Dirs: []AlterReplicaLogDir{{
+			Path:   "/mnt/disk2/jocko",
+			Topics: []AlterReplicaLogDirTopic{{Topic: "the-topic", Partitions: []int32{0, 1}}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AlterReplicaLogDirsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type AlterReplicaLogDirsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	Topics       []AlterReplicaLogDirTopicResult
+}
+
+type AlterReplicaLogDirTopicResult struct {
+	Topic      string
+	Partitions []AlterReplicaLogDirPartitionResult
+}
+
+type AlterReplicaLogDirPartitionResult struct {
+	Partition int32
+	ErrorCode int16
+}
+
+func (r *AlterReplicaLogDirsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt16(p.ErrorCode)
+		}
+	}
+	return nil
+}
+
+func (r *AlterReplicaLogDirsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]AlterReplicaLogDirTopicResult, n)
+	for i := range r.Topics {
+		t := AlterReplicaLogDirTopicResult{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]AlterReplicaLogDirPartitionResult, partitionCount)
+		for j := range t.Partitions {
+			p := AlterReplicaLogDirPartitionResult{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *AlterReplicaLogDirsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *AlterReplicaLogDirsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlterReplicaLogDirsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &AlterReplicaLogDirsResponse{
+		ThrottleTime: time.Millisecond,
+		Topics: []AlterReplicaLogDirTopicResult{{
+			Topic: "the-topic",
+			Partitions: []AlterReplicaLogDirPartitionResult{
+				{Partition: 0, ErrorCode: ErrNone.Code()},
+				{Partition: 1, ErrorCode: ErrLogDirNotFound.Code()},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act AlterReplicaLogDirsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -1,48 +1,130 @@
 package protocol
 
+import "strconv"
+
 // Protocol API keys. See: https://kafka.apache.org/protocol#protocol_api_keys
 const (
-	ProduceKey                 = 0
-	FetchKey                   = 1
-	OffsetsKey                 = 2
-	MetadataKey                = 3
-	LeaderAndISRKey            = 4
-	StopReplicaKey             = 5
-	UpdateMetadataKey          = 6
-	ControlledShutdownKey      = 7
-	OffsetCommitKey            = 8
-	OffsetFetchKey             = 9
-	FindCoordinatorKey         = 10
-	JoinGroupKey               = 11
-	HeartbeatKey               = 12
-	LeaveGroupKey              = 13
-	SyncGroupKey               = 14
-	DescribeGroupsKey          = 15
-	ListGroupsKey              = 16
-	SaslHandshakeKey           = 17
-	APIVersionsKey             = 18
-	CreateTopicsKey            = 19
-	DeleteTopicsKey            = 20
-	DeleteRecordsKey           = 21
-	InitProducerIDKey          = 22
-	OffsetForLeaderEpochKey    = 23
-	AddPartitionsToTxnKey      = 24
-	AddOffsetsToTxnKey         = 25
-	EndTxnKey                  = 26
-	WriteTxnMarkersKey         = 27
-	TxnOffsetCommitKey         = 28
-	DescribeAclsKey            = 29
-	CreateAclsKey              = 30
-	DeleteAclsKey              = 31
-	DescribeConfigsKey         = 32
-	AlterConfigsKey            = 33
-	AlterReplicaLogDirsKey     = 34
-	DescribeLogDirsKey         = 35
-	SaslAuthenticateKey        = 36
-	CreatePartitionsKey        = 37
-	CreateDelegationTokenKey   = 38
-	RenewDelegationTokenKey    = 39
-	ExpireDelegationTokenKey   = 40
-	DescribeDelegationTokenKey = 41
-	DeleteGroupsKey            = 42
+	ProduceKey                     = 0
+	FetchKey                       = 1
+	OffsetsKey                     = 2
+	MetadataKey                    = 3
+	LeaderAndISRKey                = 4
+	StopReplicaKey                 = 5
+	UpdateMetadataKey              = 6
+	ControlledShutdownKey          = 7
+	OffsetCommitKey                = 8
+	OffsetFetchKey                 = 9
+	FindCoordinatorKey             = 10
+	JoinGroupKey                   = 11
+	HeartbeatKey                   = 12
+	LeaveGroupKey                  = 13
+	SyncGroupKey                   = 14
+	DescribeGroupsKey              = 15
+	ListGroupsKey                  = 16
+	SaslHandshakeKey               = 17
+	APIVersionsKey                 = 18
+	CreateTopicsKey                = 19
+	DeleteTopicsKey                = 20
+	DeleteRecordsKey               = 21
+	InitProducerIDKey              = 22
+	OffsetForLeaderEpochKey        = 23
+	AddPartitionsToTxnKey          = 24
+	AddOffsetsToTxnKey             = 25
+	EndTxnKey                      = 26
+	WriteTxnMarkersKey             = 27
+	TxnOffsetCommitKey             = 28
+	DescribeAclsKey                = 29
+	CreateAclsKey                  = 30
+	DeleteAclsKey                  = 31
+	DescribeConfigsKey             = 32
+	AlterConfigsKey                = 33
+	AlterReplicaLogDirsKey         = 34
+	DescribeLogDirsKey             = 35
+	SaslAuthenticateKey            = 36
+	CreatePartitionsKey            = 37
+	CreateDelegationTokenKey       = 38
+	RenewDelegationTokenKey        = 39
+	ExpireDelegationTokenKey       = 40
+	DescribeDelegationTokenKey     = 41
+	DeleteGroupsKey                = 42
+	ElectLeadersKey                = 43
+	IncrementalAlterConfigsKey     = 44
+	AlterPartitionReassignmentsKey = 45
+	ListPartitionReassignmentsKey  = 46
+	OffsetDeleteKey                = 47
+	AlterPartitionKey              = 56
+	DescribeTransactionsKey        = 65
+	ListTransactionsKey            = 66
+
+	// FetchSegmentKey is jocko's own API, between brokers, for followers to copy their leader's
+	// sealed segments whole. It's well past Kafka's keys so it won't clash with theirs.
+	FetchSegmentKey = 1000
+	// LeaderLeaseKey is jocko's own API for brokers to renew their leases on the partitions they
+	// lead with the controller.
+	LeaderLeaseKey = 1001
 )
+
+// APINames are the APIs' names in the Kafka protocol guide by their keys.
+var APINames = map[int16]string{
+	ProduceKey:                     "Produce",
+	FetchKey:                       "Fetch",
+	OffsetsKey:                     "ListOffsets",
+	MetadataKey:                    "Metadata",
+	LeaderAndISRKey:                "LeaderAndIsr",
+	StopReplicaKey:                 "StopReplica",
+	UpdateMetadataKey:              "UpdateMetadata",
+	ControlledShutdownKey:          "ControlledShutdown",
+	OffsetCommitKey:                "OffsetCommit",
+	OffsetFetchKey:                 "OffsetFetch",
+	FindCoordinatorKey:             "FindCoordinator",
+	JoinGroupKey:                   "JoinGroup",
+	HeartbeatKey:                   "Heartbeat",
+	LeaveGroupKey:                  "LeaveGroup",
+	SyncGroupKey:                   "SyncGroup",
+	DescribeGroupsKey:              "DescribeGroups",
+	ListGroupsKey:                  "ListGroups",
+	SaslHandshakeKey:               "SaslHandshake",
+	APIVersionsKey:                 "ApiVersions",
+	CreateTopicsKey:                "CreateTopics",
+	DeleteTopicsKey:                "DeleteTopics",
+	DeleteRecordsKey:               "DeleteRecords",
+	InitProducerIDKey:              "InitProducerId",
+	OffsetForLeaderEpochKey:        "OffsetForLeaderEpoch",
+	AddPartitionsToTxnKey:          "AddPartitionsToTxn",
+	AddOffsetsToTxnKey:             "AddOffsetsToTxn",
+	EndTxnKey:                      "EndTxn",
+	WriteTxnMarkersKey:             "WriteTxnMarkers",
+	TxnOffsetCommitKey:             "TxnOffsetCommit",
+	DescribeAclsKey:                "DescribeAcls",
+	CreateAclsKey:                  "CreateAcls",
+	DeleteAclsKey:                  "DeleteAcls",
+	DescribeConfigsKey:             "DescribeConfigs",
+	AlterConfigsKey:                "AlterConfigs",
+	AlterReplicaLogDirsKey:         "AlterReplicaLogDirs",
+	DescribeLogDirsKey:             "DescribeLogDirs",
+	SaslAuthenticateKey:            "SaslAuthenticate",
+	CreatePartitionsKey:            "CreatePartitions",
+	CreateDelegationTokenKey:       "CreateDelegationToken",
+	RenewDelegationTokenKey:        "RenewDelegationToken",
+	ExpireDelegationTokenKey:       "ExpireDelegationToken",
+	DescribeDelegationTokenKey:     "DescribeDelegationToken",
+	DeleteGroupsKey:                "DeleteGroups",
+	ElectLeadersKey:                "ElectLeaders",
+	IncrementalAlterConfigsKey:     "IncrementalAlterConfigs",
+	AlterPartitionReassignmentsKey: "AlterPartitionReassignments",
+	ListPartitionReassignmentsKey:  "ListPartitionReassignments",
+	OffsetDeleteKey:                "OffsetDelete",
+	AlterPartitionKey:              "AlterPartition",
+	DescribeTransactionsKey:        "DescribeTransactions",
+	ListTransactionsKey:            "ListTransactions",
+	FetchSegmentKey:                "FetchSegment",
+	LeaderLeaseKey:                 "LeaderLease",
+}
+
+// APIName returns the name of the API with the key, or its key if it isn't known.
+func APIName(key int16) string {
+	if name, ok := APINames[key]; ok {
+		return name
+	}
+	return strconv.Itoa(int(key))
+}
@@ -1,20 +1,130 @@
 package protocol
 
-var APIVersions = []APIVersion{
-	{APIKey: ProduceKey, MinVersion: 0, MaxVersion: 5},
-	{APIKey: FetchKey, MinVersion: 0, MaxVersion: 3},
-	{APIKey: OffsetsKey, MinVersion: 0, MaxVersion: 2},
-	{APIKey: MetadataKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: LeaderAndISRKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: StopReplicaKey, MinVersion: 0, MaxVersion: 0},
-	{APIKey: FindCoordinatorKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: JoinGroupKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: HeartbeatKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: LeaveGroupKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: SyncGroupKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: DescribeGroupsKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: ListGroupsKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: APIVersionsKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: CreateTopicsKey, MinVersion: 0, MaxVersion: 1},
-	{APIKey: DeleteTopicsKey, MinVersion: 0, MaxVersion: 1},
+// api is an API brokers implement: the versions of it they decode requests and encode
+// responses for, and a new request to decode into.
+type api struct {
+	APIVersion
+	request func() VersionedDecoder
+}
+
+// apis is the registry of the APIs brokers implement. ApiVersions responses advertise exactly
+// these versions, and requests for other APIs or versions are rejected before they're decoded
+// rather than read with the wrong layout.
+var apis = []api{
+	{APIVersion{APIKey: ProduceKey, MinVersion: 0, MaxVersion: 7}, func() VersionedDecoder { return &ProduceRequest{} }},
+	{APIVersion{APIKey: FetchKey, MinVersion: 0, MaxVersion: 11}, func() VersionedDecoder { return &FetchRequest{} }},
+	{APIVersion{APIKey: OffsetsKey, MinVersion: 0, MaxVersion: 2}, func() VersionedDecoder { return &OffsetsRequest{} }},
+	{APIVersion{APIKey: MetadataKey, MinVersion: 0, MaxVersion: 8}, func() VersionedDecoder { return &MetadataRequest{} }},
+	{APIVersion{APIKey: LeaderAndISRKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &LeaderAndISRRequest{} }},
+	{APIVersion{APIKey: StopReplicaKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &StopReplicaRequest{} }},
+	// version 0 requests are sent with a header without a client id, so only versions 1 and up
+	// are supported.
+	{APIVersion{APIKey: ControlledShutdownKey, MinVersion: 1, MaxVersion: 2}, func() VersionedDecoder { return &ControlledShutdownRequest{} }},
+	{APIVersion{APIKey: OffsetCommitKey, MinVersion: 0, MaxVersion: 2}, func() VersionedDecoder { return &OffsetCommitRequest{} }},
+	{APIVersion{APIKey: OffsetFetchKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &OffsetFetchRequest{} }},
+	{APIVersion{APIKey: FindCoordinatorKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &FindCoordinatorRequest{} }},
+	{APIVersion{APIKey: JoinGroupKey, MinVersion: 0, MaxVersion: 5}, func() VersionedDecoder { return &JoinGroupRequest{} }},
+	{APIVersion{APIKey: HeartbeatKey, MinVersion: 0, MaxVersion: 3}, func() VersionedDecoder { return &HeartbeatRequest{} }},
+	{APIVersion{APIKey: LeaveGroupKey, MinVersion: 0, MaxVersion: 3}, func() VersionedDecoder { return &LeaveGroupRequest{} }},
+	{APIVersion{APIKey: SyncGroupKey, MinVersion: 0, MaxVersion: 3}, func() VersionedDecoder { return &SyncGroupRequest{} }},
+	{APIVersion{APIKey: DescribeGroupsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &DescribeGroupsRequest{} }},
+	{APIVersion{APIKey: ListGroupsKey, MinVersion: 0, MaxVersion: 4}, func() VersionedDecoder { return &ListGroupsRequest{} }},
+	{APIVersion{APIKey: DeleteGroupsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &DeleteGroupsRequest{} }},
+	// version 0 handshakes are followed by raw SASL tokens rather than SaslAuthenticate
+	// requests, so only version 1 is supported.
+	{APIVersion{APIKey: SaslHandshakeKey, MinVersion: 1, MaxVersion: 1}, func() VersionedDecoder { return &SaslHandshakeRequest{} }},
+	{APIVersion{APIKey: APIVersionsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &APIVersionsRequest{} }},
+	{APIVersion{APIKey: CreateTopicsKey, MinVersion: 0, MaxVersion: 2}, func() VersionedDecoder { return &CreateTopicRequests{} }},
+	{APIVersion{APIKey: DeleteTopicsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &DeleteTopicsRequest{} }},
+	{APIVersion{APIKey: SaslAuthenticateKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &SaslAuthenticateRequest{} }},
+	{APIVersion{APIKey: CreatePartitionsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &CreatePartitionsRequest{} }},
+	{APIVersion{APIKey: CreateDelegationTokenKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &CreateDelegationTokenRequest{} }},
+	{APIVersion{APIKey: RenewDelegationTokenKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &RenewDelegationTokenRequest{} }},
+	{APIVersion{APIKey: ExpireDelegationTokenKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &ExpireDelegationTokenRequest{} }},
+	{APIVersion{APIKey: DescribeDelegationTokenKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &DescribeDelegationTokenRequest{} }},
+	{APIVersion{APIKey: DeleteRecordsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &DeleteRecordsRequest{} }},
+	{APIVersion{APIKey: InitProducerIDKey, MinVersion: 0, MaxVersion: 3}, func() VersionedDecoder { return &InitProducerIDRequest{} }},
+	{APIVersion{APIKey: OffsetForLeaderEpochKey, MinVersion: 0, MaxVersion: 2}, func() VersionedDecoder { return &OffsetForLeaderEpochRequest{} }},
+	{APIVersion{APIKey: AddPartitionsToTxnKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &AddPartitionsToTxnRequest{} }},
+	{APIVersion{APIKey: AddOffsetsToTxnKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &AddOffsetsToTxnRequest{} }},
+	{APIVersion{APIKey: EndTxnKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &EndTxnRequest{} }},
+	{APIVersion{APIKey: WriteTxnMarkersKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &WriteTxnMarkersRequest{} }},
+	{APIVersion{APIKey: TxnOffsetCommitKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &TxnOffsetCommitRequest{} }},
+	{APIVersion{APIKey: DescribeAclsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &DescribeAclsRequest{} }},
+	{APIVersion{APIKey: CreateAclsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &CreateAclsRequest{} }},
+	{APIVersion{APIKey: DeleteAclsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &DeleteAclsRequest{} }},
+	{APIVersion{APIKey: ElectLeadersKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &ElectLeadersRequest{} }},
+	{APIVersion{APIKey: DescribeConfigsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &DescribeConfigsRequest{} }},
+	{APIVersion{APIKey: AlterConfigsKey, MinVersion: 0, MaxVersion: 1}, func() VersionedDecoder { return &AlterConfigsRequest{} }},
+	{APIVersion{APIKey: IncrementalAlterConfigsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &IncrementalAlterConfigsRequest{} }},
+	{APIVersion{APIKey: AlterPartitionReassignmentsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &AlterPartitionReassignmentsRequest{} }},
+	{APIVersion{APIKey: ListPartitionReassignmentsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &ListPartitionReassignmentsRequest{} }},
+	{APIVersion{APIKey: OffsetDeleteKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &OffsetDeleteRequest{} }},
+	{APIVersion{APIKey: AlterPartitionKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &AlterPartitionRequest{} }},
+	{APIVersion{APIKey: AlterReplicaLogDirsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &AlterReplicaLogDirsRequest{} }},
+	{APIVersion{APIKey: DescribeLogDirsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &DescribeLogDirsRequest{} }},
+	{APIVersion{APIKey: DescribeTransactionsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &DescribeTransactionsRequest{} }},
+	{APIVersion{APIKey: ListTransactionsKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &ListTransactionsRequest{} }},
+	{APIVersion{APIKey: FetchSegmentKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &FetchSegmentRequest{} }},
+	{APIVersion{APIKey: LeaderLeaseKey, MinVersion: 0, MaxVersion: 0}, func() VersionedDecoder { return &LeaderLeaseRequest{} }},
+}
+
+// flexibleVersions are the first versions of APIs that are flexible: their requests and responses
+// use compact lengths and tagged fields, and so do their headers.
+var flexibleVersions = map[int16]int16{
+	ListGroupsKey:                  3,
+	InitProducerIDKey:              2,
+	AlterPartitionReassignmentsKey: 0,
+	ListPartitionReassignmentsKey:  0,
+	AlterPartitionKey:              0,
+	DescribeTransactionsKey:        0,
+	ListTransactionsKey:            0,
+}
+
+// FlexibleVersion returns whether the version of the API is flexible.
+func FlexibleVersion(key, version int16) bool {
+	first, ok := flexibleVersions[key]
+	return ok && version >= first
+}
+
+// flexibleResponseHeader returns whether responses to the version of the API have tagged fields
+// in their headers. ApiVersions responses never do so clients can read them before they know
+// which versions the broker supports.
+func flexibleResponseHeader(key, version int16) bool {
+	return key != APIVersionsKey && FlexibleVersion(key, version)
+}
+
+// APIVersions are the versions of the APIs brokers implement, advertised in ApiVersions
+// responses.
+var APIVersions = func() []APIVersion {
+	versions := make([]APIVersion, len(apis))
+	for i, a := range apis {
+		versions[i] = a.APIVersion
+	}
+	return versions
+}()
+
+// SupportedVersion returns whether brokers implement the version of the API.
+func SupportedVersion(key, version int16) bool {
+	for _, a := range apis {
+		if a.APIKey == key {
+			return version >= a.MinVersion && version <= a.MaxVersion
+		}
+	}
+	return false
+}
+
+// NewRequest returns a new request of the API to decode the version into, or
+// ErrUnsupportedVersion if brokers don't implement the API or version.
+func NewRequest(key, version int16) (VersionedDecoder, Error) {
+	for _, a := range apis {
+		if a.APIKey != key {
+			continue
+		}
+		if version < a.MinVersion || version > a.MaxVersion {
+			return nil, ErrUnsupportedVersion
+		}
+		return a.request(), ErrNone
+	}
+	return nil, ErrUnsupportedVersion
 }
@@ -1,5 +1,7 @@
 package protocol
 
+import "go.uber.org/zap/zapcore"
+
 type APIVersionsRequest struct {
 	APIVersion int16
 }
@@ -20,3 +22,8 @@ func (c *APIVersionsRequest) Key() int16 {
 func (r *APIVersionsRequest) Version() int16 {
 	return r.APIVersion
 }
+
+func (r *APIVersionsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("api version", r.APIVersion)
+	return nil
+}
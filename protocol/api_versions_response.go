@@ -37,8 +37,11 @@ func (c *APIVersionsResponse) Encode(e PacketEncoder) error {
 	return nil
 }
 
-func (c *APIVersionsResponse) Decode(d PacketDecoder, version int16) error {
+func (c *APIVersionsResponse) Decode(d PacketDecoder, version int16) (err error) {
 	c.APIVersion = version
+	if c.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
 	l, err := d.ArrayLength()
 	if err != nil {
 		return err
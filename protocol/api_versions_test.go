@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportedVersion(t *testing.T) {
+	req := require.New(t)
+	req.True(SupportedVersion(FetchKey, 0))
+	req.True(SupportedVersion(FetchKey, 11))
+	req.False(SupportedVersion(FetchKey, 12))
+	req.False(SupportedVersion(FetchKey, -1))
+	req.False(SupportedVersion(UpdateMetadataKey, 0))
+}
+
+func TestNewRequest(t *testing.T) {
+	req := require.New(t)
+	for _, v := range APIVersions {
+		for version := v.MinVersion; version <= v.MaxVersion; version++ {
+			r, err := NewRequest(v.APIKey, version)
+			req.Equal(ErrNone, err)
+			req.Equal(v.APIKey, r.(Body).Key())
+		}
+		_, err := NewRequest(v.APIKey, v.MaxVersion+1)
+		req.Equal(ErrUnsupportedVersion, err)
+	}
+	_, err := NewRequest(UpdateMetadataKey, 0)
+	req.Equal(ErrUnsupportedVersion, err)
+}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"net"
+)
+
+// minChunkBytes is the size at which EncodeBuffers stops copying a byte slice into its buffer
+// and references it instead.
+const minChunkBytes = 4 << 10
+
+// EncodeBuffers encodes e like Encode, except that byte slices of at least minChunkBytes, such as
+// fetched record sets, aren't copied. Everything else is encoded into a single buffer sized up
+// front and the result is that buffer's pieces interleaved with the caller's slices, ready to be
+// written with writev. The caller must not change the slices until the buffers are written.
+//
+// The slices are still in memory, fetched record sets are read from the log by the broker, so
+// this saves copying them into the response rather than reading them. Writing them straight
+// from the log's files would need the fetch path to hand over file regions instead.
+func EncodeBuffers(e Encoder) (net.Buffers, error) {
+	lenEnc := new(chunkLenEncoder)
+	if err := e.Encode(lenEnc); err != nil {
+		return nil, err
+	}
+
+	bufEnc := &chunkEncoder{
+		ByteEncoder: ByteEncoder{b: make([]byte, lenEnc.Length)},
+		bufs:        make(net.Buffers, 0, 2*lenEnc.chunks+1),
+	}
+	if err := e.Encode(bufEnc); err != nil {
+		return nil, err
+	}
+	if bufEnc.mark < bufEnc.off {
+		bufEnc.bufs = append(bufEnc.bufs, bufEnc.b[bufEnc.mark:bufEnc.off])
+	}
+
+	return bufEnc.bufs, nil
+}
+
+// chunker tracks the pushed encoders to decide which byte slices can be referenced rather than
+// copied. Only size fields can be filled in without the bytes they cover, so a slice under any
+// other push encoder, like a crc, is always copied.
+type chunker struct {
+	pushes  []chunkPush
+	skipped int
+}
+
+type chunkPush struct {
+	pe      PushEncoder
+	skipped int
+}
+
+func (c *chunker) chunk(in []byte) bool {
+	if len(in) < minChunkBytes {
+		return false
+	}
+	for _, p := range c.pushes {
+		if _, ok := p.pe.(*SizeField); !ok {
+			return false
+		}
+	}
+	c.skipped += len(in)
+	return true
+}
+
+func (c *chunker) push(pe PushEncoder) {
+	c.pushes = append(c.pushes, chunkPush{pe: pe, skipped: c.skipped})
+}
+
+func (c *chunker) pop() chunkPush {
+	p := c.pushes[len(c.pushes)-1]
+	c.pushes = c.pushes[:len(c.pushes)-1]
+	return p
+}
+
+// chunkLenEncoder computes the length of the buffer needed by chunkEncoder.
+type chunkLenEncoder struct {
+	LenEncoder
+	chunker
+	chunks int
+}
+
+func (e *chunkLenEncoder) PutRawBytes(in []byte) error {
+	if e.chunk(in) {
+		e.chunks++
+		return nil
+	}
+	return e.LenEncoder.PutRawBytes(in)
+}
+
+func (e *chunkLenEncoder) PutBytes(in []byte) error {
+	e.Length += 4
+	if in == nil {
+		return nil
+	}
+	return e.PutRawBytes(in)
+}
+
+func (e *chunkLenEncoder) Push(pe PushEncoder) {
+	e.push(pe)
+	e.LenEncoder.Push(pe)
+}
+
+func (e *chunkLenEncoder) Pop() {
+	e.pop()
+	e.LenEncoder.Pop()
+}
+
+// chunkEncoder encodes into its buffer and cuts it wherever it references a slice.
+type chunkEncoder struct {
+	ByteEncoder
+	chunker
+	bufs net.Buffers
+	// mark is the start of the buffer not yet added to bufs.
+	mark int
+}
+
+func (e *chunkEncoder) PutRawBytes(in []byte) error {
+	if !e.chunk(in) {
+		return e.ByteEncoder.PutRawBytes(in)
+	}
+	if e.mark < e.off {
+		e.bufs = append(e.bufs, e.b[e.mark:e.off])
+	}
+	e.bufs = append(e.bufs, in)
+	e.mark = e.off
+	return nil
+}
+
+func (e *chunkEncoder) PutBytes(in []byte) error {
+	if in == nil {
+		e.PutInt32(-1)
+		return nil
+	}
+	e.PutInt32(int32(len(in)))
+	return e.PutRawBytes(in)
+}
+
+func (e *chunkEncoder) Push(pe PushEncoder) {
+	e.push(pe)
+	e.ByteEncoder.Push(pe)
+}
+
+func (e *chunkEncoder) Pop() {
+	p := e.pop()
+	e.stack = e.stack[:len(e.stack)-1]
+	// offsets are into our buffer so add back the referenced bytes the field covers
+	p.pe.Fill(e.off+e.skipped-p.skipped, e.b)
+}
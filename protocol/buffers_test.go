@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newFetchResponse(partitions, recordSetBytes int) *Response {
+	ps := make([]*FetchPartitionResponse, partitions)
+	for i := range ps {
+		ps[i] = &FetchPartitionResponse{
+			Partition:     int32(i),
+			HighWatermark: int64(i),
+			RecordSet:     bytes.Repeat([]byte{byte(i)}, recordSetBytes),
+		}
+	}
+	return &Response{CorrelationID: 1, Body: &FetchResponse{
+		APIVersion: 1,
+		Responses:  []*FetchTopicResponse{{Topic: "test_topic", PartitionResponses: ps}},
+	}}
+}
+
+func TestEncodeBuffers(t *testing.T) {
+	req := require.New(t)
+	tests := []Encoder{
+		newFetchResponse(3, 1<<20),
+		newFetchResponse(2, minChunkBytes-1),
+		newFetchResponse(0, 0),
+		// record sets are under a crc so must be copied
+		&MessageSet{Messages: []*Message{{Value: bytes.Repeat([]byte("v"), 2*minChunkBytes)}}},
+	}
+	for _, e := range tests {
+		exp, err := Encode(e)
+		req.NoError(err)
+		bufs, err := EncodeBuffers(e)
+		req.NoError(err)
+		var act bytes.Buffer
+		_, err = bufs.WriteTo(&act)
+		req.NoError(err)
+		req.Equal(exp, act.Bytes())
+	}
+
+	// the record sets are referenced, not copied
+	bufs, err := EncodeBuffers(newFetchResponse(3, 1<<20))
+	req.NoError(err)
+	req.Equal(6, len(bufs))
+	for _, i := range []int{1, 3, 5} {
+		req.Equal(1<<20, len(bufs[i]))
+	}
+}
+
+func TestEncodeBuffersAllocs(t *testing.T) {
+	// the number of allocations mustn't grow with the number of partitions
+	for _, partitions := range []int{1, 64} {
+		resp := newFetchResponse(partitions, minChunkBytes)
+		allocs := testing.AllocsPerRun(10, func() {
+			if _, err := EncodeBuffers(resp); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs > 10 {
+			t.Fatalf("got %v allocs encoding %d partitions, want at most 10", allocs, partitions)
+		}
+	}
+
+	// nor with the size of the record sets, which are referenced so only the headers are encoded
+	resp := newFetchResponse(8, 1<<20)
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, err := EncodeBuffers(resp); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 10 {
+		t.Fatalf("got %v allocs encoding an 8MiB fetch response, want at most 10", allocs)
+	}
+	bufs, err := EncodeBuffers(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded int
+	for i, buf := range bufs {
+		// the even buffers are the encoded headers between the record sets
+		if i%2 == 0 {
+			encoded += len(buf)
+		}
+	}
+	if encoded > 4<<10 {
+		t.Fatalf("got %d bytes encoded for an 8MiB fetch response, want at most 4KiB", encoded)
+	}
+}
+
+func BenchmarkEncodeFetchResponse(b *testing.B) {
+	for _, size := range []int{1 << 10, 1 << 20} {
+		resp := newFetchResponse(4, size)
+		b.Run(fmt.Sprintf("Encode/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Encode(resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("EncodeBuffers/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := EncodeBuffers(resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/pierrec/xxHash/xxHash32"
+)
+
+// Compression codecs, in the low bits of v2 record batches' attributes.
+const (
+	CompressionNone   int8 = 0
+	CompressionGZIP   int8 = 1
+	CompressionSnappy int8 = 2
+	CompressionLZ4    int8 = 3
+	CompressionZstd   int8 = 4
+)
+
+// compressionNames are the codecs as they're named in the compression.type config.
+var compressionNames = map[string]int8{
+	"uncompressed": CompressionNone,
+	"gzip":         CompressionGZIP,
+	"snappy":       CompressionSnappy,
+	"lz4":          CompressionLZ4,
+	"zstd":         CompressionZstd,
+}
+
+// CompressionCodec returns the codec named as in the compression.type config, or false if it
+// doesn't name one, like "producer" for keeping the producer's codec.
+func CompressionCodec(name string) (int8, bool) {
+	c, ok := compressionNames[name]
+	return c, ok
+}
+
+// codec compresses and decompresses v2 record batches' records. Codecs without levels ignore
+// the level they're compressed at.
+type codec struct {
+	compress   func(b []byte, level int) []byte
+	decompress func(b []byte) ([]byte, error)
+}
+
+// codecs are the codecs whose batches' records can be decoded and encoded, those compressed with
+// others are passed through as they are.
+var codecs = map[int8]codec{
+	CompressionGZIP:   {compress: gzipEncode, decompress: gzipDecode},
+	CompressionSnappy: {compress: snappyEncode, decompress: snappyDecode},
+	CompressionLZ4:    {compress: lz4Encode, decompress: lz4Decode},
+	CompressionZstd:   {compress: zstdEncode, decompress: zstdDecode},
+}
+
+// The oldest Produce and Fetch versions of clients that can read zstd compressed batches,
+// older clients can't produce or fetch them.
+const (
+	ZstdMinProduceVersion = 7
+	ZstdMinFetchVersion   = 10
+)
+
+// HasCompression returns whether any of the v2 record batches in b are compressed with the codec.
+func HasCompression(b []byte, c int8) bool {
+	for _, e := range RecordSetEntries(b) {
+		if e.Magic >= 2 && len(e.Bytes) >= recordBatchAttributesOffset+2 && int8(Encoding.Uint16(e.Bytes[recordBatchAttributesOffset:])&recordBatchCompressionMask) == c {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportedCompression returns whether records compressed with the codec can be decoded and
+// encoded.
+func SupportedCompression(c int8) bool {
+	if c == CompressionNone {
+		return true
+	}
+	_, ok := codecs[c]
+	return ok
+}
+
+// gzipEncode compresses b in a gzip stream at the gzip level, 0 is the default level rather than
+// no compression.
+func gzipEncode(b []byte, level int) []byte {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		// levels out of range are compressed at the default level
+		w = gzip.NewWriter(&buf)
+	}
+	// writing to a buffer doesn't fail
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+// gzipDecode decompresses the gzip stream in b.
+func gzipDecode(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+var (
+	// xerialHeader starts the xerial framing Java clients wrap snappy compressed records in,
+	// followed by the framing's version and the oldest version compatible with it.
+	xerialHeader = []byte{130, 'S', 'N', 'A', 'P', 'P', 'Y', 0}
+
+	errSnappyFraming = errors.New("bad xerial snappy framing")
+)
+
+const (
+	xerialHeaderLen = 16
+	xerialVersion   = 1
+	// xerialBlockSize is how much is compressed into each of the framing's blocks, as Java
+	// clients do.
+	xerialBlockSize = 32 * 1024
+)
+
+// snappyEncode compresses b in xerial framed blocks, which Java clients need and other clients
+// read too.
+func snappyEncode(b []byte, _ int) []byte {
+	buf := make([]byte, xerialHeaderLen, xerialHeaderLen+snappy.MaxEncodedLen(len(b))+4)
+	copy(buf, xerialHeader)
+	Encoding.PutUint32(buf[8:], xerialVersion)
+	Encoding.PutUint32(buf[12:], xerialVersion)
+	for len(b) > 0 {
+		n := xerialBlockSize
+		if n > len(b) {
+			n = len(b)
+		}
+		block := snappy.Encode(nil, b[:n])
+		var size [4]byte
+		Encoding.PutUint32(size[:], uint32(len(block)))
+		buf = append(append(buf, size[:]...), block...)
+		b = b[n:]
+	}
+	return buf
+}
+
+// snappyDecode decompresses b, either xerial framed blocks or a plain snappy block like some
+// clients send.
+func snappyDecode(b []byte) ([]byte, error) {
+	if len(b) < xerialHeaderLen || !bytes.Equal(b[:len(xerialHeader)], xerialHeader) {
+		return snappy.Decode(nil, b)
+	}
+	b = b[xerialHeaderLen:]
+	var decoded []byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errSnappyFraming
+		}
+		size := int(int32(Encoding.Uint32(b)))
+		b = b[4:]
+		if size < 0 || size > len(b) {
+			return nil, errSnappyFraming
+		}
+		block, err := snappy.Decode(nil, b[:size])
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, block...)
+		b = b[size:]
+	}
+	return decoded, nil
+}
+
+// lz4Encode compresses b in an lz4 frame.
+func lz4Encode(b []byte, _ int) []byte {
+	var buf bytes.Buffer
+	w := lz4.NewWriter(&buf)
+	// writing to a buffer doesn't fail
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+// lz4Decode decompresses the lz4 frame in b.
+func lz4Decode(b []byte) ([]byte, error) {
+	return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(b)))
+}
+
+var (
+	lz4Magic = []byte{0x04, 0x22, 0x4d, 0x18}
+
+	errLZ4Framing = errors.New("bad lz4 frame")
+)
+
+// lz4DecodeLegacy decompresses the lz4 frame of a v0 message. Kafka before 0.10 checksummed
+// the frame's descriptor along with the magic number before it, which clients like librdkafka
+// still do for v0 messages, so the checksum's fixed up before the frame's decoded.
+func lz4DecodeLegacy(b []byte) ([]byte, error) {
+	// magic, flags, block descriptor, then the content size if the flags say it's there
+	n := len(lz4Magic) + 2
+	if len(b) < n || !bytes.Equal(b[:len(lz4Magic)], lz4Magic) {
+		return nil, errLZ4Framing
+	}
+	if b[len(lz4Magic)]&0x08 != 0 {
+		n += 8
+	}
+	if len(b) < n+1 {
+		return nil, errLZ4Framing
+	}
+	fixed := append([]byte(nil), b...)
+	fixed[n] = byte(xxHash32.Checksum(fixed[len(lz4Magic):n], 0) >> 8)
+	return lz4Decode(fixed)
+}
+
+var (
+	// the encoders and decoder are safe to use concurrently, they only fail to be created with
+	// bad options.
+	zstdDecoder, _ = zstd.NewReader(nil)
+
+	// zstdEncoders are the encoders of the levels batches have been compressed at.
+	zstdEncoders     = make(map[zstd.EncoderLevel]*zstd.Encoder)
+	zstdEncodersLock sync.Mutex
+)
+
+// zstdEncode compresses b in a zstd frame at the zstd level.
+func zstdEncode(b []byte, level int) []byte {
+	return zstdEncoder(level).EncodeAll(b, nil)
+}
+
+// zstdEncoder returns the encoder for the zstd level, 0 is the default level. The encoder's
+// levels are coarser than zstd's, so several zstd levels share an encoder.
+func zstdEncoder(level int) *zstd.Encoder {
+	l := zstd.SpeedDefault
+	if level != 0 {
+		l = zstd.EncoderLevelFromZstd(level)
+	}
+	zstdEncodersLock.Lock()
+	defer zstdEncodersLock.Unlock()
+	e, ok := zstdEncoders[l]
+	if !ok {
+		e, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(l))
+		zstdEncoders[l] = e
+	}
+	return e
+}
+
+// zstdDecode decompresses the zstd frames in b.
+func zstdDecode(b []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(b, nil)
+}
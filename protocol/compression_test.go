@@ -0,0 +1,249 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/pierrec/xxHash/xxHash32"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnappy(t *testing.T) {
+	req := require.New(t)
+	// spans several of the framing's blocks
+	b := bytes.Repeat([]byte("snappy compressed records "), 3*xerialBlockSize/10)
+	encoded := snappyEncode(b, 0)
+	req.Equal(xerialHeader, encoded[:len(xerialHeader)])
+	decoded, err := snappyDecode(encoded)
+	req.NoError(err)
+	req.Equal(b, decoded)
+
+	// unframed blocks are read too
+	decoded, err = snappyDecode(snappy.Encode(nil, b))
+	req.NoError(err)
+	req.Equal(b, decoded)
+
+	// a truncated block
+	_, err = snappyDecode(encoded[:len(encoded)-1])
+	req.Error(err)
+}
+
+func TestRecordBatchSnappy(t *testing.T) {
+	req := require.New(t)
+	exp := &RecordBatch{
+		BaseOffset:      3,
+		Attributes:      int16(CompressionSnappy),
+		LastOffsetDelta: 1,
+		FirstTimestamp:  1000,
+		MaxTimestamp:    1005,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+		BaseSequence:    -1,
+		Records: []Record{
+			{Key: []byte("key"), Value: []byte("value"), Headers: []RecordHeader{{Key: "type", Value: []byte("order")}}},
+			{TimestampDelta: 5, OffsetDelta: 1, Value: []byte("another value")},
+		},
+	}
+	b := exp.Bytes()
+	req.Equal(ErrNone, ValidateRecordSet(b))
+	act, err := ReadRecordBatches(b)
+	req.NoError(err)
+	req.Equal([]*RecordBatch{exp}, act)
+	req.Equal(CompressionSnappy, act[0].Compression())
+
+	// batches with codecs that aren't known are encoded uncompressed
+	unknown := *exp
+	unknown.Attributes = 5
+	act, err = ReadRecordBatches(unknown.Bytes())
+	req.NoError(err)
+	req.Equal(CompressionNone, act[0].Compression())
+	req.Equal(exp.Records, act[0].Records)
+
+	// compressed records with a block longer than the batch
+	b = exp.Bytes()
+	Encoding.PutUint32(b[recordBatchHeaderLen+xerialHeaderLen:], uint32(len(b)))
+	_, err = ReadRecordBatches(b)
+	req.Error(err)
+}
+
+func TestRecompress(t *testing.T) {
+	req := require.New(t)
+	batch := func(c int8) []byte {
+		b := &RecordBatch{Attributes: int16(c), ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1, Records: []Record{{Value: []byte("value")}}}
+		return b.Bytes()
+	}
+	ms := mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{Value: []byte("v0")}}})
+	uncompressed := batch(CompressionNone)
+	b := append(append(append([]byte{}, ms...), uncompressed...), batch(CompressionSnappy)...)
+
+	// the uncompressed batch is compressed, the message set and snappy batch are kept as they are
+	recompressed, err := Recompress(b, Recompression{Codec: CompressionSnappy})
+	req.NoError(err)
+	req.Equal(ErrNone, ValidateRecordSet(recompressed))
+	req.Equal(ms, recompressed[:len(ms)])
+	batches, err := ReadRecordBatches(recompressed)
+	req.NoError(err)
+	req.Equal(2, len(batches))
+	for _, batch := range batches {
+		req.Equal(CompressionSnappy, batch.Compression())
+		req.Equal([]Record{{Value: []byte("value")}}, batch.Records)
+	}
+
+	// and decompressed again
+	decompressed, err := Recompress(recompressed, Recompression{Codec: CompressionNone})
+	req.NoError(err)
+	req.Equal(append(append(append([]byte{}, ms...), uncompressed...), uncompressed...), decompressed)
+
+	// batches compressed by their producers can be kept as they are
+	lz4 := batch(CompressionLZ4)
+	recompressed, err = Recompress(append(append([]byte{}, uncompressed...), lz4...), Recompression{Codec: CompressionSnappy, KeepCompressed: true})
+	req.NoError(err)
+	req.Equal(lz4, recompressed[len(recompressed)-len(lz4):])
+	batches, err = ReadRecordBatches(recompressed)
+	req.NoError(err)
+	req.Equal(CompressionSnappy, batches[0].Compression())
+	req.Equal(CompressionLZ4, batches[1].Compression())
+
+	// nothing's re-encoded for codecs that aren't known
+	recompressed, err = Recompress(b, Recompression{Codec: 5})
+	req.NoError(err)
+	req.Equal(b, recompressed)
+}
+
+func TestRecordBatchGZIP(t *testing.T) {
+	req := require.New(t)
+	exp := &RecordBatch{
+		Attributes:      int16(CompressionGZIP),
+		LastOffsetDelta: 1,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+		BaseSequence:    -1,
+		Records: []Record{
+			{Key: []byte("key"), Value: bytes.Repeat([]byte("gzip "), 1000)},
+			{OffsetDelta: 1, Value: []byte("another value")},
+		},
+	}
+	b := exp.Bytes()
+	req.Equal(ErrNone, ValidateRecordSet(b))
+	act, err := ReadRecordBatches(b)
+	req.NoError(err)
+	req.Equal([]*RecordBatch{exp}, act)
+
+	// batches already compressed with the codec aren't re-encoded at another level
+	recompressed, err := Recompress(b, Recompression{Codec: CompressionGZIP, Level: gzip.BestSpeed})
+	req.NoError(err)
+	req.Equal(b, recompressed)
+	best := exp.encode(gzip.BestCompression)
+	req.True(len(best) <= len(b))
+	act, err = ReadRecordBatches(best)
+	req.NoError(err)
+	req.Equal(exp.Records, act[0].Records)
+
+	// a corrupt stream
+	b[len(b)-1]++
+	_, err = ReadRecordBatches(b)
+	req.Error(err)
+}
+
+func TestRecordBatchLZ4(t *testing.T) {
+	req := require.New(t)
+	exp := &RecordBatch{
+		Attributes:      int16(CompressionLZ4),
+		LastOffsetDelta: 1,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+		BaseSequence:    -1,
+		Records: []Record{
+			{Key: []byte("key"), Value: bytes.Repeat([]byte("lz4 "), 1000)},
+			{OffsetDelta: 1, Value: []byte("another value")},
+		},
+	}
+	b := exp.Bytes()
+	req.Equal(ErrNone, ValidateRecordSet(b))
+	act, err := ReadRecordBatches(b)
+	req.NoError(err)
+	req.Equal([]*RecordBatch{exp}, act)
+
+	// and recompressed with snappy
+	recompressed, err := Recompress(b, Recompression{Codec: CompressionSnappy})
+	req.NoError(err)
+	act, err = ReadRecordBatches(recompressed)
+	req.NoError(err)
+	req.Equal(CompressionSnappy, act[0].Compression())
+	req.Equal(exp.Records, act[0].Records)
+}
+
+func TestLZ4LegacyMessages(t *testing.T) {
+	req := require.New(t)
+	wrapped := mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{Value: []byte("v0")}, {Value: []byte("v1")}}})
+	frame := lz4Encode(wrapped, 0)
+	// the checksum of the flags and block descriptor, and the broken one over the magic too
+	legacy := append([]byte(nil), frame...)
+	legacy[6] = byte(xxHash32.Checksum(legacy[:6], 0) >> 8)
+	_, err := lz4Decode(legacy)
+	req.Error(err)
+	decoded, err := lz4DecodeLegacy(legacy)
+	req.NoError(err)
+	req.Equal(wrapped, decoded)
+
+	wrapper := func(magic int8, value []byte) []byte {
+		return mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{MagicByte: magic, Attributes: CompressionLZ4, Value: value}}})
+	}
+	req.Equal(ErrNone, ValidateRecordSet(wrapper(0, legacy)))
+	req.Equal(ErrNone, ValidateRecordSet(wrapper(1, frame)))
+	// v1 messages checksum the frame properly
+	req.Equal(ErrCorruptMessage.Code(), ValidateRecordSet(wrapper(1, legacy)).Code())
+
+	// wrapped messages with a bad CRC
+	corrupt := append([]byte(nil), wrapped...)
+	corrupt[len(corrupt)-1]++
+	req.Equal(ErrCorruptMessage, ValidateRecordSet(wrapper(1, lz4Encode(corrupt, 0))))
+}
+
+func TestRecordBatchZstd(t *testing.T) {
+	req := require.New(t)
+	exp := &RecordBatch{
+		Attributes:    int16(CompressionZstd),
+		ProducerID:    -1,
+		ProducerEpoch: -1,
+		BaseSequence:  -1,
+		Records:       []Record{{Key: []byte("key"), Value: bytes.Repeat([]byte("zstd "), 1000)}},
+	}
+	b := exp.Bytes()
+	act, err := ReadRecordBatches(b)
+	req.NoError(err)
+	req.Equal([]*RecordBatch{exp}, act)
+
+	ms := mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{Value: []byte("v0")}}})
+	req.True(HasCompression(append(ms, b...), CompressionZstd))
+	req.False(HasCompression(append(ms, b...), CompressionSnappy))
+	req.False(HasCompression(ms, CompressionNone))
+}
+
+func TestZstdLevels(t *testing.T) {
+	req := require.New(t)
+	b := bytes.Repeat([]byte("zstd compressed at different levels "), 1000)
+	fastest := zstdEncode(b, 1)
+	best := zstdEncode(b, 19)
+	req.True(len(best) <= len(fastest))
+	for _, encoded := range [][]byte{fastest, best, zstdEncode(b, 0)} {
+		decoded, err := zstdDecode(encoded)
+		req.NoError(err)
+		req.Equal(b, decoded)
+	}
+	req.True(zstdEncoder(0) == zstdEncoder(3))
+}
+
+func TestCompressionCodec(t *testing.T) {
+	req := require.New(t)
+	c, ok := CompressionCodec("snappy")
+	req.True(ok)
+	req.Equal(CompressionSnappy, c)
+	c, ok = CompressionCodec("uncompressed")
+	req.True(ok)
+	req.Equal(CompressionNone, c)
+	_, ok = CompressionCodec("producer")
+	req.False(ok)
+}
@@ -0,0 +1,33 @@
+package protocol
+
+// ConsumerProtocolType is the protocol type of consumer groups, whose members' metadata are
+// ConsumerProtocolSubscriptions.
+const ConsumerProtocolType = "consumer"
+
+// ConsumerProtocolSubscription is the metadata consumers join their groups with, the topics
+// they subscribe to. Later versions' fields, like the partitions the consumer owns, follow
+// these and aren't decoded.
+type ConsumerProtocolSubscription struct {
+	Version  int16
+	Topics   []string
+	UserData []byte
+}
+
+func (s *ConsumerProtocolSubscription) Encode(e PacketEncoder) (err error) {
+	e.PutInt16(s.Version)
+	if err = e.PutStringArray(s.Topics); err != nil {
+		return err
+	}
+	return e.PutBytes(s.UserData)
+}
+
+func (s *ConsumerProtocolSubscription) Decode(d PacketDecoder) (err error) {
+	if s.Version, err = d.Int16(); err != nil {
+		return err
+	}
+	if s.Topics, err = d.StringArray(); err != nil {
+		return err
+	}
+	s.UserData, err = d.Bytes()
+	return err
+}
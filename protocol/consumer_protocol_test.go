@@ -0,0 +1,20 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerProtocolSubscription(t *testing.T) {
+	req := require.New(t)
+	exp := &ConsumerProtocolSubscription{Version: 1, Topics: []string{"the-topic", "another-topic"}, UserData: []byte{1}}
+	b, err := Encode(exp)
+	req.NoError(err)
+	// a later version's owned partitions are left undecoded
+	b = append(b, 0, 0, 0, 0)
+	var act ConsumerProtocolSubscription
+	err = act.Decode(NewDecoder(b))
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
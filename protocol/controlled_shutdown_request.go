@@ -4,18 +4,45 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// ControlledShutdownRequest is sent by a broker that's shutting down to the controller, asking
+// it to move the leadership of the partitions it leads to other replicas first.
 type ControlledShutdownRequest struct {
-	Version int16
+	APIVersion int16
+
+	BrokerID int32
+	// BrokerEpoch is sent from version 2, -1 if the broker doesn't have one.
+	BrokerEpoch int64
 }
 
 func (r *ControlledShutdownRequest) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(r.BrokerID)
+	if r.APIVersion >= 2 {
+		e.PutInt64(r.BrokerEpoch)
+	}
 	return nil
 }
 
 func (r *ControlledShutdownRequest) Decode(d PacketDecoder, version int16) (err error) {
-	return nil
+	r.APIVersion = version
+	if r.BrokerID, err = d.Int32(); err != nil {
+		return err
+	}
+	r.BrokerEpoch = -1
+	if version >= 2 {
+		r.BrokerEpoch, err = d.Int64()
+	}
+	return err
+}
+
+func (r *ControlledShutdownRequest) Key() int16 {
+	return ControlledShutdownKey
+}
+
+func (r *ControlledShutdownRequest) Version() int16 {
+	return r.APIVersion
 }
 
 func (r *ControlledShutdownRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt32("broker id", r.BrokerID)
 	return nil
 }
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlledShutdownRequest(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{1, 2} {
+		exp := &ControlledShutdownRequest{APIVersion: version, BrokerID: 2, BrokerEpoch: -1}
+		if version >= 2 {
+			exp.BrokerEpoch = 5
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act ControlledShutdownRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
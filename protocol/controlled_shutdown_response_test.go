@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlledShutdownResponse(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{1, 2} {
+		exp := &ControlledShutdownResponse{
+			APIVersion: version,
+			ErrorCode:  ErrNone.Code(),
+			PartitionsRemaining: []*ControlledShutdownPartition{
+				{Topic: "the-topic", Partition: 1},
+			},
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act ControlledShutdownResponse
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_CreateAcls
+
+type CreateAclsRequest struct {
+	APIVersion int16
+
+	Creations []AclCreation
+}
+
+type AclCreation struct {
+	ResourceType int8
+	ResourceName string
+	// PatternType is sent from version 1, earlier versions' names are literal.
+	PatternType    int8
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+func (r *CreateAclsRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutArrayLength(len(r.Creations)); err != nil {
+		return err
+	}
+	for _, c := range r.Creations {
+		e.PutInt8(c.ResourceType)
+		if err = e.PutString(c.ResourceName); err != nil {
+			return err
+		}
+		if r.APIVersion >= 1 {
+			e.PutInt8(c.PatternType)
+		}
+		if err = e.PutString(c.Principal); err != nil {
+			return err
+		}
+		if err = e.PutString(c.Host); err != nil {
+			return err
+		}
+		e.PutInt8(c.Operation)
+		e.PutInt8(c.PermissionType)
+	}
+	return nil
+}
+
+func (r *CreateAclsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	creationCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Creations = make([]AclCreation, creationCount)
+	for i := range r.Creations {
+		c := AclCreation{PatternType: PatternTypeLiteral}
+		if c.ResourceType, err = d.Int8(); err != nil {
+			return err
+		}
+		if c.ResourceName, err = d.String(); err != nil {
+			return err
+		}
+		if version >= 1 {
+			if c.PatternType, err = d.Int8(); err != nil {
+				return err
+			}
+		}
+		if c.Principal, err = d.String(); err != nil {
+			return err
+		}
+		if c.Host, err = d.String(); err != nil {
+			return err
+		}
+		if c.Operation, err = d.Int8(); err != nil {
+			return err
+		}
+		if c.PermissionType, err = d.Int8(); err != nil {
+			return err
+		}
+		r.Creations[i] = c
+	}
+	return nil
+}
+
+func (r *CreateAclsRequest) Key() int16 {
+	return CreateAclsKey
+}
+
+func (r *CreateAclsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *CreateAclsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("creations", len(r.Creations))
+	return nil
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAclsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &CreateAclsRequest{
+		APIVersion: 1,
+		Creations: []AclCreation{{
+			ResourceType:   ResourceTypeTopic,
+			ResourceName:   "the-",
+			PatternType:    PatternTypePrefixed,
+			Principal:      "User:alice",
+			Host:           "*",
+			Operation:      AclOperationRead,
+			PermissionType: AclPermissionAllow,
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act CreateAclsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
+
+func TestCreateAclsRequest_V0(t *testing.T) {
+	req := require.New(t)
+	exp := &CreateAclsRequest{
+		Creations: []AclCreation{{
+			ResourceType:   ResourceTypeGroup,
+			ResourceName:   "the-group",
+			PatternType:    PatternTypeLiteral,
+			Principal:      "User:alice",
+			Host:           "*",
+			Operation:      AclOperationRead,
+			PermissionType: AclPermissionDeny,
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act CreateAclsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
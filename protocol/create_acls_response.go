@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type CreateAclsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	Results      []AclCreationResult
+}
+
+type AclCreationResult struct {
+	ErrorCode    int16
+	ErrorMessage *string
+}
+
+func (r *CreateAclsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Results)); err != nil {
+		return err
+	}
+	for _, result := range r.Results {
+		e.PutInt16(result.ErrorCode)
+		if err = e.PutNullableString(result.ErrorMessage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *CreateAclsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	resultCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Results = make([]AclCreationResult, resultCount)
+	for i := range r.Results {
+		result := AclCreationResult{}
+		if result.ErrorCode, err = d.Int16(); err != nil {
+			return err
+		}
+		if result.ErrorMessage, err = d.NullableString(); err != nil {
+			return err
+		}
+		r.Results[i] = result
+	}
+	return nil
+}
+
+func (r *CreateAclsResponse) Key() int16 {
+	return CreateAclsKey
+}
+
+func (r *CreateAclsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *CreateAclsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("results", len(r.Results))
+	return nil
+}
@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAclsResponse(t *testing.T) {
+	req := require.New(t)
+	msg := "invalid"
+	exp := &CreateAclsResponse{
+		ThrottleTime: time.Second,
+		Results: []AclCreationResult{
+			{ErrorCode: ErrNone.Code()},
+			{ErrorCode: ErrInvalidRequest.Code(), ErrorMessage: &msg},
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act CreateAclsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_CreateDelegationToken
+
+type CreateDelegationTokenRequest struct {
+	APIVersion int16
+
+	Renewers []DelegationTokenPrincipal
+	// MaxLifetime is -1 to use the broker's max lifetime.
+	MaxLifetime time.Duration
+}
+
+func (r *CreateDelegationTokenRequest) Encode(e PacketEncoder) (err error) {
+	if err = encodeDelegationTokenPrincipals(e, r.Renewers); err != nil {
+		return err
+	}
+	e.PutInt64(int64(r.MaxLifetime / time.Millisecond))
+	return nil
+}
+
+func (r *CreateDelegationTokenRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.Renewers, err = decodeDelegationTokenPrincipals(d); err != nil {
+		return err
+	}
+	maxLifetime, err := d.Int64()
+	if err != nil {
+		return err
+	}
+	r.MaxLifetime = time.Duration(maxLifetime) * time.Millisecond
+	return nil
+}
+
+func (r *CreateDelegationTokenRequest) Key() int16 {
+	return CreateDelegationTokenKey
+}
+
+func (r *CreateDelegationTokenRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *CreateDelegationTokenRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("renewers", len(r.Renewers))
+	e.AddDuration("max lifetime", r.MaxLifetime)
+	return nil
+}
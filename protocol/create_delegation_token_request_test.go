@@ -0,0 +1,22 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateDelegationTokenRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &CreateDelegationTokenRequest{
+		Renewers:    []DelegationTokenPrincipal{{PrincipalType: "User", PrincipalName: "bob"}},
+		MaxLifetime: time.Hour,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act CreateDelegationTokenRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type CreateDelegationTokenResponse struct {
+	APIVersion int16
+
+	ErrorCode int16
+	Owner     DelegationTokenPrincipal
+	// IssueTime, ExpiryTime and MaxTime are sent in milliseconds.
+	IssueTime    time.Time
+	ExpiryTime   time.Time
+	MaxTime      time.Time
+	TokenID      string
+	HMAC         []byte
+	ThrottleTime time.Duration
+}
+
+func (r *CreateDelegationTokenResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt16(r.ErrorCode)
+	if err = r.Owner.encode(e); err != nil {
+		return err
+	}
+	putMillis(e, r.IssueTime)
+	putMillis(e, r.ExpiryTime)
+	putMillis(e, r.MaxTime)
+	if err = e.PutString(r.TokenID); err != nil {
+		return err
+	}
+	if err = e.PutBytes(r.HMAC); err != nil {
+		return err
+	}
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	return nil
+}
+
+func (r *CreateDelegationTokenResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	if err = r.Owner.decode(d); err != nil {
+		return err
+	}
+	if r.IssueTime, err = millis(d); err != nil {
+		return err
+	}
+	if r.ExpiryTime, err = millis(d); err != nil {
+		return err
+	}
+	if r.MaxTime, err = millis(d); err != nil {
+		return err
+	}
+	if r.TokenID, err = d.String(); err != nil {
+		return err
+	}
+	if r.HMAC, err = d.Bytes(); err != nil {
+		return err
+	}
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	return nil
+}
+
+func (r *CreateDelegationTokenResponse) Key() int16 {
+	return CreateDelegationTokenKey
+}
+
+func (r *CreateDelegationTokenResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *CreateDelegationTokenResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddString("token id", r.TokenID)
+	return nil
+}
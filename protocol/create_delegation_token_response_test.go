@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateDelegationTokenResponse(t *testing.T) {
+	req := require.New(t)
+	issued := time.Unix(1600000000, 0)
+	exp := &CreateDelegationTokenResponse{
+		Owner:        DelegationTokenPrincipal{PrincipalType: "User", PrincipalName: "alice"},
+		IssueTime:    issued,
+		ExpiryTime:   issued.Add(time.Hour),
+		MaxTime:      issued.Add(24 * time.Hour),
+		TokenID:      "the-token",
+		HMAC:         []byte("hmac"),
+		ThrottleTime: time.Second,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act CreateDelegationTokenResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+type CreatePartitionsTopic struct {
+	Topic string
+	// Count is the total number of partitions the topic should have after the request.
+	Count int32
+	// Assignment is the replica assignment for each new partition. If nil the
+	// broker assigns the replicas.
+	Assignment [][]int32
+}
+
+type CreatePartitionsRequest struct {
+	APIVersion int16
+
+	Topics       []*CreatePartitionsTopic
+	Timeout      int32
+	ValidateOnly bool
+}
+
+func (r *CreatePartitionsRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		e.PutInt32(t.Count)
+		if t.Assignment == nil {
+			e.PutInt32(-1)
+			continue
+		}
+		if err = e.PutArrayLength(len(t.Assignment)); err != nil {
+			return err
+		}
+		for _, a := range t.Assignment {
+			if err = e.PutInt32Array(a); err != nil {
+				return err
+			}
+		}
+	}
+	e.PutInt32(r.Timeout)
+	e.PutBool(r.ValidateOnly)
+	return nil
+}
+
+func (r *CreatePartitionsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	topicCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]*CreatePartitionsTopic, topicCount)
+	for i := range r.Topics {
+		t := new(CreatePartitionsTopic)
+		r.Topics[i] = t
+		t.Topic, err = d.String()
+		if err != nil {
+			return err
+		}
+		t.Count, err = d.Int32()
+		if err != nil {
+			return err
+		}
+		// assignment is a nullable array
+		assignmentCount, err := d.Int32()
+		if err != nil {
+			return err
+		}
+		if assignmentCount < 0 {
+			continue
+		}
+		t.Assignment = make([][]int32, assignmentCount)
+		for j := range t.Assignment {
+			t.Assignment[j], err = d.Int32Array()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	r.Timeout, err = d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ValidateOnly, err = d.Bool()
+	return err
+}
+
+func (r *CreatePartitionsRequest) Key() int16 {
+	return CreatePartitionsKey
+}
+
+func (r *CreatePartitionsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *CreatePartitionsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddArray("topics", CreatePartitionsTopics(r.Topics))
+	e.AddBool("validate only", r.ValidateOnly)
+	return nil
+}
+
+type CreatePartitionsTopics []*CreatePartitionsTopic
+
+func (r CreatePartitionsTopics) MarshalLogArray(e zapcore.ArrayEncoder) error {
+	for _, t := range r {
+		e.AppendObject(t)
+	}
+	return nil
+}
+
+func (r *CreatePartitionsTopic) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddString("topic", r.Topic)
+	e.AddInt32("count", r.Count)
+	return nil
+}
@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePartitionsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &CreatePartitionsRequest{
+		Topics: []*CreatePartitionsTopic{{
+			Topic:      "assigned",
+			Count:      3,
+			Assignment: [][]int32{{1, 2}, {2, 3}},
+		}, {
+			Topic: "unassigned",
+			Count: 2,
+		}},
+		Timeout:      100,
+		ValidateOnly: true,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act CreatePartitionsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
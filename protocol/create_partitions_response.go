@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type CreatePartitionsResponse struct {
+	APIVersion int16
+
+	ThrottleTime    time.Duration
+	TopicErrorCodes []*TopicErrorCode
+}
+
+func (r *CreatePartitionsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.TopicErrorCodes)); err != nil {
+		return err
+	}
+	for _, t := range r.TopicErrorCodes {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		e.PutInt16(t.ErrorCode)
+		if err = e.PutNullableString(t.ErrorMessage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *CreatePartitionsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	l, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.TopicErrorCodes = make([]*TopicErrorCode, l)
+	for i := range r.TopicErrorCodes {
+		t := new(TopicErrorCode)
+		r.TopicErrorCodes[i] = t
+		t.Topic, err = d.String()
+		if err != nil {
+			return err
+		}
+		t.ErrorCode, err = d.Int16()
+		if err != nil {
+			return err
+		}
+		t.ErrorMessage, err = d.NullableString()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *CreatePartitionsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *CreatePartitionsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePartitionsResponse(t *testing.T) {
+	req := require.New(t)
+	msg := "partitions can only be increased"
+	exp := &CreatePartitionsResponse{
+		ThrottleTime: time.Millisecond,
+		TopicErrorCodes: []*TopicErrorCode{{
+			Topic:     "test",
+			ErrorCode: ErrNone.Code(),
+		}, {
+			Topic:        "other",
+			ErrorCode:    ErrInvalidPartitions.Code(),
+			ErrorMessage: &msg,
+		}}}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act CreatePartitionsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
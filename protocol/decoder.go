@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"encoding/binary"
 	"errors"
 	"math"
 )
@@ -9,6 +10,7 @@ var ErrInsufficientData = errors.New("kafka: insufficient data to decode packet,
 var ErrInvalidStringLength = errors.New("kafka: invalid string length")
 var ErrInvalidArrayLength = errors.New("kafka: invalid array length")
 var ErrInvalidByteSliceLength = errors.New("invalid byteslice length")
+var ErrInvalidVarint = errors.New("kafka: invalid varint")
 
 type PacketDecoder interface {
 	Bool() (bool, error)
@@ -23,6 +25,19 @@ type PacketDecoder interface {
 	Int32Array() ([]int32, error)
 	Int64Array() ([]int64, error)
 	StringArray() ([]string, error)
+
+	// Flexible versions of APIs decode arrays, strings and bytes with compact, varint lengths,
+	// and end structs with tagged fields. No tagged fields are known so they're skipped.
+	UVarint() (uint64, error)
+	CompactArrayLength() (int, error)
+	CompactBytes() ([]byte, error)
+	CompactString() (string, error)
+	CompactNullableString() (*string, error)
+	CompactStringArray() ([]string, error)
+	CompactInt32Array() ([]int32, error)
+	CompactInt64Array() ([]int64, error)
+	TaggedFields() error
+
 	Push(pd PushDecoder) error
 	Pop() error
 	remaining() int
@@ -263,10 +278,11 @@ func (d *ByteDecoder) StringArray() ([]string, error) {
 		d.off = len(d.b)
 		return nil, ErrInsufficientData
 	}
-	n := int(Encoding.Uint32(d.b[d.off:]))
+	n := int(int32(Encoding.Uint32(d.b[d.off:])))
 	d.off += 4
 
-	if n == 0 {
+	// -1 is a null array
+	if n == 0 || n == -1 {
 		return nil, nil
 	}
 
@@ -274,6 +290,12 @@ func (d *ByteDecoder) StringArray() ([]string, error) {
 		return nil, ErrInvalidArrayLength
 	}
 
+	// every string has at least its length, so don't allocate for more than could be sent
+	if 2*n > d.remaining() {
+		d.off = len(d.b)
+		return nil, ErrInsufficientData
+	}
+
 	ret := make([]string, n)
 	for i := range ret {
 		if str, err := d.String(); err != nil {
@@ -285,6 +307,135 @@ func (d *ByteDecoder) StringArray() ([]string, error) {
 	return ret, nil
 }
 
+// flexible versions
+
+func (d *ByteDecoder) UVarint() (uint64, error) {
+	tmp, n := binary.Uvarint(d.b[d.off:])
+	if n == 0 {
+		d.off = len(d.b)
+		return 0, ErrInsufficientData
+	}
+	if n < 0 {
+		return 0, ErrInvalidVarint
+	}
+	d.off += n
+	return tmp, nil
+}
+
+// CompactArrayLength returns the length of the array, or -1 if it's null.
+func (d *ByteDecoder) CompactArrayLength() (int, error) {
+	tmp, err := d.UVarint()
+	if err != nil {
+		return -1, err
+	}
+	n := int(tmp) - 1
+	if tmp > math.MaxInt32 || n > d.remaining() {
+		d.off = len(d.b)
+		return -1, ErrInsufficientData
+	}
+	return n, nil
+}
+
+func (d *ByteDecoder) CompactBytes() ([]byte, error) {
+	n, err := d.CompactArrayLength()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	tmp := d.b[d.off : d.off+n]
+	d.off += n
+	return tmp, nil
+}
+
+func (d *ByteDecoder) CompactString() (string, error) {
+	s, err := d.CompactNullableString()
+	if err != nil || s == nil {
+		return "", err
+	}
+	return *s, nil
+}
+
+func (d *ByteDecoder) CompactNullableString() (*string, error) {
+	n, err := d.CompactArrayLength()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	tmp := string(d.b[d.off : d.off+n])
+	d.off += n
+	return &tmp, nil
+}
+
+func (d *ByteDecoder) CompactStringArray() ([]string, error) {
+	n, err := d.CompactArrayLength()
+	if err != nil || n <= 0 {
+		return nil, err
+	}
+	ret := make([]string, n)
+	for i := range ret {
+		if ret[i], err = d.CompactString(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (d *ByteDecoder) CompactInt32Array() ([]int32, error) {
+	n, err := d.CompactArrayLength()
+	if err != nil || n <= 0 {
+		return nil, err
+	}
+	if d.remaining() < 4*n {
+		d.off = len(d.b)
+		return nil, ErrInsufficientData
+	}
+	ret := make([]int32, n)
+	for i := range ret {
+		ret[i] = int32(Encoding.Uint32(d.b[d.off:]))
+		d.off += 4
+	}
+	return ret, nil
+}
+
+func (d *ByteDecoder) CompactInt64Array() ([]int64, error) {
+	n, err := d.CompactArrayLength()
+	if err != nil || n <= 0 {
+		return nil, err
+	}
+	if d.remaining() < 8*n {
+		d.off = len(d.b)
+		return nil, ErrInsufficientData
+	}
+	ret := make([]int64, n)
+	for i := range ret {
+		ret[i] = int64(Encoding.Uint64(d.b[d.off:]))
+		d.off += 8
+	}
+	return ret, nil
+}
+
+// TaggedFields skips the tagged fields ending a struct.
+func (d *ByteDecoder) TaggedFields() error {
+	n, err := d.UVarint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		// each field is its tag then its size and data
+		if _, err := d.UVarint(); err != nil {
+			return err
+		}
+		size, err := d.UVarint()
+		if err != nil {
+			return err
+		}
+		if size > uint64(d.remaining()) {
+			d.off = len(d.b)
+			return ErrInsufficientData
+		}
+		d.off += int(size)
+	}
+	return nil
+}
+
 func (d *ByteDecoder) Push(pd PushDecoder) error {
 	pd.SaveOffset(d.off)
 	reserved := pd.ReserveSize()
@@ -0,0 +1,62 @@
+package protocol
+
+import "time"
+
+// DelegationTokenPrincipal is the owner or a renewer of a delegation token, like User:alice.
+type DelegationTokenPrincipal struct {
+	PrincipalType string
+	PrincipalName string
+}
+
+func (p *DelegationTokenPrincipal) encode(e PacketEncoder) (err error) {
+	if err = e.PutString(p.PrincipalType); err != nil {
+		return err
+	}
+	return e.PutString(p.PrincipalName)
+}
+
+func (p *DelegationTokenPrincipal) decode(d PacketDecoder) (err error) {
+	if p.PrincipalType, err = d.String(); err != nil {
+		return err
+	}
+	p.PrincipalName, err = d.String()
+	return err
+}
+
+func encodeDelegationTokenPrincipals(e PacketEncoder, ps []DelegationTokenPrincipal) (err error) {
+	if err = e.PutArrayLength(len(ps)); err != nil {
+		return err
+	}
+	for i := range ps {
+		if err = ps[i].encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeDelegationTokenPrincipals(d PacketDecoder) ([]DelegationTokenPrincipal, error) {
+	n, err := d.ArrayLength()
+	if err != nil {
+		return nil, err
+	}
+	ps := make([]DelegationTokenPrincipal, n)
+	for i := range ps {
+		if err = ps[i].decode(d); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+func putMillis(e PacketEncoder, t time.Time) {
+	e.PutInt64(t.UnixNano() / int64(time.Millisecond))
+}
+
+func millis(d PacketDecoder) (time.Time, error) {
+	ms, err := d.Int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)), nil
+}
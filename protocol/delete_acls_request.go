@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_DeleteAcls
+
+type DeleteAclsRequest struct {
+	APIVersion int16
+
+	Filters []AclFilter
+}
+
+func (r *DeleteAclsRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutArrayLength(len(r.Filters)); err != nil {
+		return err
+	}
+	for i := range r.Filters {
+		if err = r.Filters[i].encode(e, r.APIVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *DeleteAclsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	filterCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Filters = make([]AclFilter, filterCount)
+	for i := range r.Filters {
+		if err = r.Filters[i].decode(d, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *DeleteAclsRequest) Key() int16 {
+	return DeleteAclsKey
+}
+
+func (r *DeleteAclsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DeleteAclsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("filters", len(r.Filters))
+	return nil
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteAclsRequest(t *testing.T) {
+	req := require.New(t)
+	principal := "User:alice"
+	exp := &DeleteAclsRequest{
+		APIVersion: 1,
+		Filters: []AclFilter{{
+			ResourceType:   ResourceTypeAny,
+			PatternType:    PatternTypeAny,
+			Principal:      &principal,
+			Operation:      AclOperationAny,
+			PermissionType: AclPermissionAny,
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DeleteAclsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type DeleteAclsResponse struct {
+	APIVersion int16
+
+	ThrottleTime  time.Duration
+	FilterResults []DeleteAclsFilterResult
+}
+
+type DeleteAclsFilterResult struct {
+	ErrorCode    int16
+	ErrorMessage *string
+	MatchingAcls []DeleteAclsMatchingAcl
+}
+
+// DeleteAclsMatchingAcl is an ACL the filter matched and deleted.
+type DeleteAclsMatchingAcl struct {
+	ErrorCode    int16
+	ErrorMessage *string
+	ResourceType int8
+	ResourceName string
+	// PatternType is sent from version 1, earlier versions' names are literal.
+	PatternType    int8
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+func (r *DeleteAclsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.FilterResults)); err != nil {
+		return err
+	}
+	for _, result := range r.FilterResults {
+		e.PutInt16(result.ErrorCode)
+		if err = e.PutNullableString(result.ErrorMessage); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(result.MatchingAcls)); err != nil {
+			return err
+		}
+		for _, acl := range result.MatchingAcls {
+			e.PutInt16(acl.ErrorCode)
+			if err = e.PutNullableString(acl.ErrorMessage); err != nil {
+				return err
+			}
+			e.PutInt8(acl.ResourceType)
+			if err = e.PutString(acl.ResourceName); err != nil {
+				return err
+			}
+			if r.APIVersion >= 1 {
+				e.PutInt8(acl.PatternType)
+			}
+			if err = e.PutString(acl.Principal); err != nil {
+				return err
+			}
+			if err = e.PutString(acl.Host); err != nil {
+				return err
+			}
+			e.PutInt8(acl.Operation)
+			e.PutInt8(acl.PermissionType)
+		}
+	}
+	return nil
+}
+
+func (r *DeleteAclsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	resultCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.FilterResults = make([]DeleteAclsFilterResult, resultCount)
+	for i := range r.FilterResults {
+		result := DeleteAclsFilterResult{}
+		if result.ErrorCode, err = d.Int16(); err != nil {
+			return err
+		}
+		if result.ErrorMessage, err = d.NullableString(); err != nil {
+			return err
+		}
+		aclCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		result.MatchingAcls = make([]DeleteAclsMatchingAcl, aclCount)
+		for j := range result.MatchingAcls {
+			acl := DeleteAclsMatchingAcl{PatternType: PatternTypeLiteral}
+			if acl.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			if acl.ErrorMessage, err = d.NullableString(); err != nil {
+				return err
+			}
+			if acl.ResourceType, err = d.Int8(); err != nil {
+				return err
+			}
+			if acl.ResourceName, err = d.String(); err != nil {
+				return err
+			}
+			if version >= 1 {
+				if acl.PatternType, err = d.Int8(); err != nil {
+					return err
+				}
+			}
+			if acl.Principal, err = d.String(); err != nil {
+				return err
+			}
+			if acl.Host, err = d.String(); err != nil {
+				return err
+			}
+			if acl.Operation, err = d.Int8(); err != nil {
+				return err
+			}
+			if acl.PermissionType, err = d.Int8(); err != nil {
+				return err
+			}
+			result.MatchingAcls[j] = acl
+		}
+		r.FilterResults[i] = result
+	}
+	return nil
+}
+
+func (r *DeleteAclsResponse) Key() int16 {
+	return DeleteAclsKey
+}
+
+func (r *DeleteAclsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DeleteAclsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("filter results", len(r.FilterResults))
+	return nil
+}
@@ -0,0 +1,35 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteAclsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &DeleteAclsResponse{
+		APIVersion:   1,
+		ThrottleTime: time.Second,
+		FilterResults: []DeleteAclsFilterResult{{
+			ErrorCode: ErrNone.Code(),
+			MatchingAcls: []DeleteAclsMatchingAcl{{
+				ErrorCode:      ErrNone.Code(),
+				ResourceType:   ResourceTypeTopic,
+				ResourceName:   "the-topic",
+				PatternType:    PatternTypeLiteral,
+				Principal:      "User:alice",
+				Host:           "*",
+				Operation:      AclOperationRead,
+				PermissionType: AclPermissionAllow,
+			}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DeleteAclsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
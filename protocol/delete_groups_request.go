@@ -0,0 +1,36 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_DeleteGroups
+
+type DeleteGroupsRequest struct {
+	APIVersion int16
+
+	GroupsNames []string
+}
+
+func (r *DeleteGroupsRequest) Encode(e PacketEncoder) error {
+	return e.PutStringArray(r.GroupsNames)
+}
+
+func (r *DeleteGroupsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	r.GroupsNames, err = d.StringArray()
+	return err
+}
+
+func (r *DeleteGroupsRequest) Key() int16 {
+	return DeleteGroupsKey
+}
+
+func (r *DeleteGroupsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DeleteGroupsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddArray("groups names", Strings(r.GroupsNames))
+	return nil
+}
@@ -0,0 +1,18 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteGroupsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &DeleteGroupsRequest{APIVersion: 1, GroupsNames: []string{"the-group", "another-group"}}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DeleteGroupsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
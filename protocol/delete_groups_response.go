@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type DeleteGroupsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	Results      []DeleteGroupsResult
+}
+
+type DeleteGroupsResult struct {
+	GroupID   string
+	ErrorCode int16
+}
+
+func (r *DeleteGroupsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Results)); err != nil {
+		return err
+	}
+	for _, result := range r.Results {
+		if err = e.PutString(result.GroupID); err != nil {
+			return err
+		}
+		e.PutInt16(result.ErrorCode)
+	}
+	return nil
+}
+
+func (r *DeleteGroupsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Results = make([]DeleteGroupsResult, n)
+	for i := range r.Results {
+		result := DeleteGroupsResult{}
+		if result.GroupID, err = d.String(); err != nil {
+			return err
+		}
+		if result.ErrorCode, err = d.Int16(); err != nil {
+			return err
+		}
+		r.Results[i] = result
+	}
+	return nil
+}
+
+func (r *DeleteGroupsResponse) Key() int16 {
+	return DeleteGroupsKey
+}
+
+func (r *DeleteGroupsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DeleteGroupsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("results", len(r.Results))
+	return nil
+}
@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteGroupsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &DeleteGroupsResponse{
+		APIVersion:   1,
+		ThrottleTime: time.Millisecond,
+		Results: []DeleteGroupsResult{
+			{GroupID: "the-group", ErrorCode: ErrNone.Code()},
+			{GroupID: "another-group", ErrorCode: ErrNonEmptyGroup.Code()},
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DeleteGroupsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_DeleteRecords
+
+type DeleteRecordsRequest struct {
+	APIVersion int16
+
+	Topics  []DeleteRecordsTopic
+	Timeout time.Duration
+}
+
+type DeleteRecordsTopic struct {
+	Topic      string
+	Partitions []DeleteRecordsPartition
+}
+
+type DeleteRecordsPartition struct {
+	Partition int32
+	// Offset is the offset the partition's messages are deleted up to, -1 deletes up to the
+	// high watermark.
+	Offset int64
+}
+
+func (r *DeleteRecordsRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt64(p.Offset)
+		}
+	}
+	e.PutInt32(int32(r.Timeout / time.Millisecond))
+	return nil
+}
+
+func (r *DeleteRecordsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]DeleteRecordsTopic, n)
+	for i := range r.Topics {
+		t := DeleteRecordsTopic{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]DeleteRecordsPartition, partitionCount)
+		for j := range t.Partitions {
+			p := DeleteRecordsPartition{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.Offset, err = d.Int64(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	timeout, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.Timeout = time.Duration(timeout) * time.Millisecond
+	return nil
+}
+
+func (r *DeleteRecordsRequest) Key() int16 {
+	return DeleteRecordsKey
+}
+
+func (r *DeleteRecordsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DeleteRecordsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
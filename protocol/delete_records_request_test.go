@@ -0,0 +1,25 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteRecordsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &DeleteRecordsRequest{
+		Topics: []DeleteRecordsTopic{{
+			Topic:      "the-topic",
+			Partitions: []DeleteRecordsPartition{{Partition: 0, Offset: 10}, {Partition: 1, Offset: -1}},
+		}},
+		Timeout: time.Second,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DeleteRecordsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type DeleteRecordsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	Topics       []DeleteRecordsTopicResponse
+}
+
+type DeleteRecordsTopicResponse struct {
+	Topic      string
+	Partitions []DeleteRecordsPartitionResponse
+}
+
+type DeleteRecordsPartitionResponse struct {
+	Partition int32
+	// LowWatermark is the partition's log start offset after the delete.
+	LowWatermark int64
+	ErrorCode    int16
+}
+
+func (r *DeleteRecordsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt64(p.LowWatermark)
+			e.PutInt16(p.ErrorCode)
+		}
+	}
+	return nil
+}
+
+func (r *DeleteRecordsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]DeleteRecordsTopicResponse, n)
+	for i := range r.Topics {
+		t := DeleteRecordsTopicResponse{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]DeleteRecordsPartitionResponse, partitionCount)
+		for j := range t.Partitions {
+			p := DeleteRecordsPartitionResponse{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.LowWatermark, err = d.Int64(); err != nil {
+				return err
+			}
+			if p.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *DeleteRecordsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DeleteRecordsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteRecordsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &DeleteRecordsResponse{
+		ThrottleTime: time.Millisecond,
+		Topics: []DeleteRecordsTopicResponse{{
+			Topic: "the-topic",
+			Partitions: []DeleteRecordsPartitionResponse{
+				{Partition: 0, LowWatermark: 10, ErrorCode: ErrNone.Code()},
+				{Partition: 1, LowWatermark: -1, ErrorCode: ErrOffsetOutOfRange.Code()},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DeleteRecordsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
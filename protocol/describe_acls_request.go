@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_DescribeAcls
+
+type DescribeAclsRequest struct {
+	APIVersion int16
+
+	Filter AclFilter
+}
+
+func (r *DescribeAclsRequest) Encode(e PacketEncoder) (err error) {
+	return r.Filter.encode(e, r.APIVersion)
+}
+
+func (r *DescribeAclsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	return r.Filter.decode(d, version)
+}
+
+func (r *DescribeAclsRequest) Key() int16 {
+	return DescribeAclsKey
+}
+
+func (r *DescribeAclsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DescribeAclsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt8("resource type", r.Filter.ResourceType)
+	if r.Filter.ResourceName != nil {
+		e.AddString("resource name", *r.Filter.ResourceName)
+	}
+	return nil
+}
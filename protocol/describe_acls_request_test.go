@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeAclsRequest(t *testing.T) {
+	req := require.New(t)
+	name := "the-topic"
+	exp := &DescribeAclsRequest{
+		APIVersion: 1,
+		Filter: AclFilter{
+			ResourceType:   ResourceTypeTopic,
+			ResourceName:   &name,
+			PatternType:    PatternTypeMatch,
+			Operation:      AclOperationAny,
+			PermissionType: AclPermissionAny,
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DescribeAclsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
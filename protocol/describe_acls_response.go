@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type DescribeAclsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	ErrorCode    int16
+	ErrorMessage *string
+	Resources    []AclResource
+}
+
+// AclResource is a resource pattern and its ACLs.
+type AclResource struct {
+	ResourceType int8
+	ResourceName string
+	// PatternType is sent from version 1, earlier versions' names are literal.
+	PatternType int8
+	Acls        []AclDescription
+}
+
+type AclDescription struct {
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+func (r *DescribeAclsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	e.PutInt16(r.ErrorCode)
+	if err = e.PutNullableString(r.ErrorMessage); err != nil {
+		return err
+	}
+	if err = e.PutArrayLength(len(r.Resources)); err != nil {
+		return err
+	}
+	for _, resource := range r.Resources {
+		e.PutInt8(resource.ResourceType)
+		if err = e.PutString(resource.ResourceName); err != nil {
+			return err
+		}
+		if r.APIVersion >= 1 {
+			e.PutInt8(resource.PatternType)
+		}
+		if err = e.PutArrayLength(len(resource.Acls)); err != nil {
+			return err
+		}
+		for _, acl := range resource.Acls {
+			if err = e.PutString(acl.Principal); err != nil {
+				return err
+			}
+			if err = e.PutString(acl.Host); err != nil {
+				return err
+			}
+			e.PutInt8(acl.Operation)
+			e.PutInt8(acl.PermissionType)
+		}
+	}
+	return nil
+}
+
+func (r *DescribeAclsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	if r.ErrorMessage, err = d.NullableString(); err != nil {
+		return err
+	}
+	resourceCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Resources = make([]AclResource, resourceCount)
+	for i := range r.Resources {
+		resource := AclResource{PatternType: PatternTypeLiteral}
+		if resource.ResourceType, err = d.Int8(); err != nil {
+			return err
+		}
+		if resource.ResourceName, err = d.String(); err != nil {
+			return err
+		}
+		if version >= 1 {
+			if resource.PatternType, err = d.Int8(); err != nil {
+				return err
+			}
+		}
+		aclCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		resource.Acls = make([]AclDescription, aclCount)
+		for j := range resource.Acls {
+			acl := AclDescription{}
+			if acl.Principal, err = d.String(); err != nil {
+				return err
+			}
+			if acl.Host, err = d.String(); err != nil {
+				return err
+			}
+			if acl.Operation, err = d.Int8(); err != nil {
+				return err
+			}
+			if acl.PermissionType, err = d.Int8(); err != nil {
+				return err
+			}
+			resource.Acls[j] = acl
+		}
+		r.Resources[i] = resource
+	}
+	return nil
+}
+
+func (r *DescribeAclsResponse) Key() int16 {
+	return DescribeAclsKey
+}
+
+func (r *DescribeAclsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DescribeAclsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddInt("resources", len(r.Resources))
+	return nil
+}
@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeAclsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &DescribeAclsResponse{
+		APIVersion:   1,
+		ThrottleTime: time.Second,
+		Resources: []AclResource{{
+			ResourceType: ResourceTypeTopic,
+			ResourceName: "the-",
+			PatternType:  PatternTypePrefixed,
+			Acls: []AclDescription{{
+				Principal:      "User:alice",
+				Host:           "*",
+				Operation:      AclOperationWrite,
+				PermissionType: AclPermissionAllow,
+			}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DescribeAclsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -30,6 +30,16 @@ type DescribeConfigsEntry struct {
 	Synonyms    []DescribeConfigsSynonym
 }
 
+// Config sources used in synonyms, in order of precedence.
+const (
+	ConfigSourceUnknown                    int8 = 0
+	ConfigSourceTopicConfig                int8 = 1
+	ConfigSourceDynamicBrokerConfig        int8 = 2
+	ConfigSourceDynamicDefaultBrokerConfig int8 = 3
+	ConfigSourceStaticBrokerConfig         int8 = 4
+	ConfigSourceDefaultConfig              int8 = 5
+)
+
 type DescribeConfigsSynonym struct {
 	Name   string
 	Value  *string
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_DescribeDelegationToken
+
+type DescribeDelegationTokenRequest struct {
+	APIVersion int16
+
+	// Owners is nil to describe every token.
+	Owners []DelegationTokenPrincipal
+}
+
+func (r *DescribeDelegationTokenRequest) Encode(e PacketEncoder) (err error) {
+	if r.Owners == nil {
+		return e.PutArrayLength(-1)
+	}
+	return encodeDelegationTokenPrincipals(e, r.Owners)
+}
+
+func (r *DescribeDelegationTokenRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	n, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	switch {
+	case n == -1:
+		r.Owners = nil
+		return nil
+	case n < 0:
+		return ErrInvalidArrayLength
+	case 4*int(n) > d.remaining():
+		return ErrInsufficientData
+	}
+	r.Owners = make([]DelegationTokenPrincipal, n)
+	for i := range r.Owners {
+		if err = r.Owners[i].decode(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *DescribeDelegationTokenRequest) Key() int16 {
+	return DescribeDelegationTokenKey
+}
+
+func (r *DescribeDelegationTokenRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DescribeDelegationTokenRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("owners", len(r.Owners))
+	return nil
+}
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeDelegationTokenRequest(t *testing.T) {
+	req := require.New(t)
+	for _, exp := range []*DescribeDelegationTokenRequest{
+		{Owners: []DelegationTokenPrincipal{{PrincipalType: "User", PrincipalName: "alice"}}},
+		// nil owners describes every token
+		{},
+	} {
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act DescribeDelegationTokenRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type DescribeDelegationTokenResponse struct {
+	APIVersion int16
+
+	ErrorCode    int16
+	Tokens       []DescribedDelegationToken
+	ThrottleTime time.Duration
+}
+
+type DescribedDelegationToken struct {
+	Owner DelegationTokenPrincipal
+	// IssueTime, ExpiryTime and MaxTime are sent in milliseconds.
+	IssueTime  time.Time
+	ExpiryTime time.Time
+	MaxTime    time.Time
+	TokenID    string
+	HMAC       []byte
+	Renewers   []DelegationTokenPrincipal
+}
+
+func (r *DescribeDelegationTokenResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt16(r.ErrorCode)
+	if err = e.PutArrayLength(len(r.Tokens)); err != nil {
+		return err
+	}
+	for i := range r.Tokens {
+		t := &r.Tokens[i]
+		if err = t.Owner.encode(e); err != nil {
+			return err
+		}
+		putMillis(e, t.IssueTime)
+		putMillis(e, t.ExpiryTime)
+		putMillis(e, t.MaxTime)
+		if err = e.PutString(t.TokenID); err != nil {
+			return err
+		}
+		if err = e.PutBytes(t.HMAC); err != nil {
+			return err
+		}
+		if err = encodeDelegationTokenPrincipals(e, t.Renewers); err != nil {
+			return err
+		}
+	}
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	return nil
+}
+
+func (r *DescribeDelegationTokenResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	tokenCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Tokens = make([]DescribedDelegationToken, tokenCount)
+	for i := range r.Tokens {
+		t := &r.Tokens[i]
+		if err = t.Owner.decode(d); err != nil {
+			return err
+		}
+		if t.IssueTime, err = millis(d); err != nil {
+			return err
+		}
+		if t.ExpiryTime, err = millis(d); err != nil {
+			return err
+		}
+		if t.MaxTime, err = millis(d); err != nil {
+			return err
+		}
+		if t.TokenID, err = d.String(); err != nil {
+			return err
+		}
+		if t.HMAC, err = d.Bytes(); err != nil {
+			return err
+		}
+		if t.Renewers, err = decodeDelegationTokenPrincipals(d); err != nil {
+			return err
+		}
+	}
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	return nil
+}
+
+func (r *DescribeDelegationTokenResponse) Key() int16 {
+	return DescribeDelegationTokenKey
+}
+
+func (r *DescribeDelegationTokenResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DescribeDelegationTokenResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddInt("tokens", len(r.Tokens))
+	return nil
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeDelegationTokenResponse(t *testing.T) {
+	req := require.New(t)
+	issued := time.Unix(1600000000, 0)
+	exp := &DescribeDelegationTokenResponse{
+		Tokens: []DescribedDelegationToken{{
+			Owner:      DelegationTokenPrincipal{PrincipalType: "User", PrincipalName: "alice"},
+			IssueTime:  issued,
+			ExpiryTime: issued.Add(time.Hour),
+			MaxTime:    issued.Add(24 * time.Hour),
+			TokenID:    "the-token",
+			HMAC:       []byte("hmac"),
+			Renewers:   []DelegationTokenPrincipal{{PrincipalType: "User", PrincipalName: "bob"}},
+		}},
+		ThrottleTime: time.Second,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DescribeDelegationTokenResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
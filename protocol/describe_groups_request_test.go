@@ -0,0 +1,18 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeGroupsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &DescribeGroupsRequest{GroupIDs: []string{"the-group", "another-group"}}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DescribeGroupsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -52,7 +52,11 @@ func (r *DescribeGroupsResponse) Decode(d PacketDecoder, version int16) (err err
 }
 
 func (r *DescribeGroupsResponse) Key() int16 {
-	return 15
+	return DescribeGroupsKey
+}
+
+func (r *DescribeGroupsResponse) Version() int16 {
+	return r.APIVersion
 }
 
 type Group struct {
@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeGroupsResponse(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{0, 1} {
+		exp := &DescribeGroupsResponse{
+			APIVersion: version,
+			Groups: []Group{{
+				GroupID:      "the-group",
+				State:        "Stable",
+				ProtocolType: "consumer",
+				Protocol:     "range",
+				GroupMembers: map[string]*GroupMember{
+					"the-member": {
+						ClientID:              "the-client",
+						ClientHost:            "/127.0.0.1",
+						GroupMemberMetadata:   []byte{0x01},
+						GroupMemberAssignment: []byte{0x02},
+					},
+				},
+			}, {
+				GroupID: "dead-group",
+				State:   "Dead",
+			}},
+		}
+		if version >= 1 {
+			exp.ThrottleTime = time.Millisecond
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act DescribeGroupsResponse
+		err = Decode(b, &act, version)
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
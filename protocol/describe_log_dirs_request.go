@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_DescribeLogDirs
+
+type DescribeLogDirsRequest struct {
+	APIVersion int16
+
+	// Topics to describe, nil describes every partition on the broker.
+	Topics []DescribeLogDirsTopic
+}
+
+type DescribeLogDirsTopic struct {
+	Topic      string
+	Partitions []int32
+}
+
+func (r *DescribeLogDirsRequest) Encode(e PacketEncoder) (err error) {
+	if r.Topics == nil {
+		e.PutInt32(-1)
+		return nil
+	}
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutInt32Array(t.Partitions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *DescribeLogDirsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	n, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	if n == -1 {
+		r.Topics = nil
+		return nil
+	}
+	r.Topics = make([]DescribeLogDirsTopic, n)
+	for i := range r.Topics {
+		t := DescribeLogDirsTopic{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		if t.Partitions, err = d.Int32Array(); err != nil {
+			return err
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *DescribeLogDirsRequest) Key() int16 {
+	return DescribeLogDirsKey
+}
+
+func (r *DescribeLogDirsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DescribeLogDirsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
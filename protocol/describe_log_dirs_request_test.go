@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeLogDirsRequest(t *testing.T) {
+	req := require.New(t)
+	for _, exp := range []*DescribeLogDirsRequest{
+		{Topics: []DescribeLogDirsTopic{{Topic: "the-topic", Partitions: []int32{0, 1}}}},
+		// all topics
+		{},
+	} {
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act DescribeLogDirsRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
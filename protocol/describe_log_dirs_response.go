@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type DescribeLogDirsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	Results      []DescribeLogDirsResult
+}
+
+type DescribeLogDirsResult struct {
+	ErrorCode int16
+	LogDir    string
+	Topics    []DescribeLogDirsTopicResult
+}
+
+type DescribeLogDirsTopicResult struct {
+	Topic      string
+	Partitions []DescribeLogDirsPartition
+}
+
+type DescribeLogDirsPartition struct {
+	Partition int32
+	// Size is the size of the partition's log in bytes.
+	Size int64
+	// OffsetLag is how far the log is behind the partition's high watermark.
+	OffsetLag int64
+	// IsFuture is whether the log is being moved to this log dir and will replace the current one.
+	IsFuture bool
+}
+
+func (r *DescribeLogDirsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Results)); err != nil {
+		return err
+	}
+	for _, res := range r.Results {
+		e.PutInt16(res.ErrorCode)
+		if err = e.PutString(res.LogDir); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(res.Topics)); err != nil {
+			return err
+		}
+		for _, t := range res.Topics {
+			if err = e.PutString(t.Topic); err != nil {
+				return err
+			}
+			if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+				return err
+			}
+			for _, p := range t.Partitions {
+				e.PutInt32(p.Partition)
+				e.PutInt64(p.Size)
+				e.PutInt64(p.OffsetLag)
+				e.PutBool(p.IsFuture)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *DescribeLogDirsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Results = make([]DescribeLogDirsResult, n)
+	for i := range r.Results {
+		res := DescribeLogDirsResult{}
+		if res.ErrorCode, err = d.Int16(); err != nil {
+			return err
+		}
+		if res.LogDir, err = d.String(); err != nil {
+			return err
+		}
+		topicCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		res.Topics = make([]DescribeLogDirsTopicResult, topicCount)
+		for j := range res.Topics {
+			t := DescribeLogDirsTopicResult{}
+			if t.Topic, err = d.String(); err != nil {
+				return err
+			}
+			partitionCount, err := d.ArrayLength()
+			if err != nil {
+				return err
+			}
+			t.Partitions = make([]DescribeLogDirsPartition, partitionCount)
+			for k := range t.Partitions {
+				p := DescribeLogDirsPartition{}
+				if p.Partition, err = d.Int32(); err != nil {
+					return err
+				}
+				if p.Size, err = d.Int64(); err != nil {
+					return err
+				}
+				if p.OffsetLag, err = d.Int64(); err != nil {
+					return err
+				}
+				if p.IsFuture, err = d.Bool(); err != nil {
+					return err
+				}
+				t.Partitions[k] = p
+			}
+			res.Topics[j] = t
+		}
+		r.Results[i] = res
+	}
+	return nil
+}
+
+func (r *DescribeLogDirsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DescribeLogDirsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("results", len(r.Results))
+	return nil
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeLogDirsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &DescribeLogDirsResponse{
+		ThrottleTime: time.Millisecond,
+		Results: []DescribeLogDirsResult{{
+			LogDir: "/var/lib/jocko/data",
+			Topics: []DescribeLogDirsTopicResult{{
+				Topic: "the-topic",
+				Partitions: []DescribeLogDirsPartition{
+					{Partition: 0, Size: 1024, OffsetLag: 0},
+					{Partition: 1, Size: 512, OffsetLag: 3, IsFuture: true},
+				},
+			}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DescribeLogDirsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_DescribeTransactions
+
+type DescribeTransactionsRequest struct {
+	APIVersion int16
+
+	TransactionalIDs []string
+}
+
+func (r *DescribeTransactionsRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutCompactStringArray(r.TransactionalIDs); err != nil {
+		return err
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *DescribeTransactionsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.TransactionalIDs, err = d.CompactStringArray(); err != nil {
+		return err
+	}
+	return d.TaggedFields()
+}
+
+func (r *DescribeTransactionsRequest) Key() int16 {
+	return DescribeTransactionsKey
+}
+
+func (r *DescribeTransactionsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DescribeTransactionsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddArray("transactional ids", Strings(r.TransactionalIDs))
+	return nil
+}
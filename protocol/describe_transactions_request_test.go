@@ -0,0 +1,18 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeTransactionsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &DescribeTransactionsRequest{TransactionalIDs: []string{"the-txn", "another-txn"}}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DescribeTransactionsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,155 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type DescribeTransactionsResponse struct {
+	APIVersion int16
+
+	ThrottleTime      time.Duration
+	TransactionStates []DescribeTransactionState
+}
+
+type DescribeTransactionState struct {
+	ErrorCode          int16
+	TransactionalID    string
+	TransactionState   string
+	TransactionTimeout time.Duration
+	// TransactionStartTime is zero if there's no ongoing transaction.
+	TransactionStartTime time.Time
+	ProducerID           int64
+	ProducerEpoch        int16
+	// Topics are the partitions added to the ongoing transaction.
+	Topics []DescribeTransactionTopic
+}
+
+type DescribeTransactionTopic struct {
+	Topic      string
+	Partitions []int32
+}
+
+func (r *DescribeTransactionsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutCompactArrayLength(len(r.TransactionStates)); err != nil {
+		return err
+	}
+	for _, s := range r.TransactionStates {
+		e.PutInt16(s.ErrorCode)
+		if err = e.PutCompactString(s.TransactionalID); err != nil {
+			return err
+		}
+		if err = e.PutCompactString(s.TransactionState); err != nil {
+			return err
+		}
+		e.PutInt32(int32(s.TransactionTimeout / time.Millisecond))
+		if s.TransactionStartTime.IsZero() {
+			e.PutInt64(-1)
+		} else {
+			e.PutInt64(s.TransactionStartTime.UnixNano() / int64(time.Millisecond))
+		}
+		e.PutInt64(s.ProducerID)
+		e.PutInt16(s.ProducerEpoch)
+		if err = e.PutCompactArrayLength(len(s.Topics)); err != nil {
+			return err
+		}
+		for _, t := range s.Topics {
+			if err = e.PutCompactString(t.Topic); err != nil {
+				return err
+			}
+			if err = e.PutCompactInt32Array(t.Partitions); err != nil {
+				return err
+			}
+			e.PutEmptyTaggedFields()
+		}
+		e.PutEmptyTaggedFields()
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *DescribeTransactionsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	n, err := d.CompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.TransactionStates = make([]DescribeTransactionState, n)
+	}
+	for i := range r.TransactionStates {
+		s := DescribeTransactionState{}
+		if s.ErrorCode, err = d.Int16(); err != nil {
+			return err
+		}
+		if s.TransactionalID, err = d.CompactString(); err != nil {
+			return err
+		}
+		if s.TransactionState, err = d.CompactString(); err != nil {
+			return err
+		}
+		timeout, err := d.Int32()
+		if err != nil {
+			return err
+		}
+		s.TransactionTimeout = time.Duration(timeout) * time.Millisecond
+		start, err := d.Int64()
+		if err != nil {
+			return err
+		}
+		if start != -1 {
+			s.TransactionStartTime = time.Unix(start/1000, (start%1000)*int64(time.Millisecond))
+		}
+		if s.ProducerID, err = d.Int64(); err != nil {
+			return err
+		}
+		if s.ProducerEpoch, err = d.Int16(); err != nil {
+			return err
+		}
+		tn, err := d.CompactArrayLength()
+		if err != nil {
+			return err
+		}
+		if tn > 0 {
+			s.Topics = make([]DescribeTransactionTopic, tn)
+		}
+		for j := range s.Topics {
+			t := DescribeTransactionTopic{}
+			if t.Topic, err = d.CompactString(); err != nil {
+				return err
+			}
+			if t.Partitions, err = d.CompactInt32Array(); err != nil {
+				return err
+			}
+			if err = d.TaggedFields(); err != nil {
+				return err
+			}
+			s.Topics[j] = t
+		}
+		if err = d.TaggedFields(); err != nil {
+			return err
+		}
+		r.TransactionStates[i] = s
+	}
+	return d.TaggedFields()
+}
+
+func (r *DescribeTransactionsResponse) Key() int16 {
+	return DescribeTransactionsKey
+}
+
+func (r *DescribeTransactionsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *DescribeTransactionsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("transaction states", len(r.TransactionStates))
+	return nil
+}
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeTransactionsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &DescribeTransactionsResponse{
+		ThrottleTime: time.Millisecond,
+		TransactionStates: []DescribeTransactionState{
+			{
+				TransactionalID:      "the-txn",
+				TransactionState:     TransactionStateOngoing,
+				TransactionTimeout:   time.Minute,
+				TransactionStartTime: time.Unix(1500000000, int64(123*time.Millisecond)),
+				ProducerID:           1,
+				ProducerEpoch:        2,
+				Topics:               []DescribeTransactionTopic{{Topic: "the-topic", Partitions: []int32{0, 1}}},
+			},
+			{
+				ErrorCode:        ErrTransactionalIdNotFound.Code(),
+				TransactionalID:  "unknown-txn",
+				TransactionState: TransactionStateDead,
+				ProducerID:       -1,
+				ProducerEpoch:    -1,
+			},
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act DescribeTransactionsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
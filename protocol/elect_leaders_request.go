@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_ElectLeaders
+
+// Election types.
+const (
+	// PreferredElection moves leadership to the partition's preferred replica, the first it's
+	// assigned, if it's in sync.
+	PreferredElection int8 = 0
+	// UncleanElection elects a replica that may not be in sync if the partition has no leader
+	// and no in-sync replica to lead it, which can lose messages.
+	UncleanElection int8 = 1
+)
+
+type ElectLeadersRequest struct {
+	APIVersion int16
+
+	// ElectionType is sent from version 1, version 0 requests are preferred elections.
+	ElectionType int8
+	// Topics to elect leaders for, nil elects leaders for every partition.
+	Topics  []ElectLeadersTopic
+	Timeout time.Duration
+}
+
+type ElectLeadersTopic struct {
+	Topic      string
+	Partitions []int32
+}
+
+func (r *ElectLeadersRequest) Encode(e PacketEncoder) (err error) {
+	if r.APIVersion >= 1 {
+		e.PutInt8(r.ElectionType)
+	}
+	if r.Topics == nil {
+		e.PutInt32(-1)
+	} else {
+		if err = e.PutArrayLength(len(r.Topics)); err != nil {
+			return err
+		}
+		for _, t := range r.Topics {
+			if err = e.PutString(t.Topic); err != nil {
+				return err
+			}
+			if err = e.PutInt32Array(t.Partitions); err != nil {
+				return err
+			}
+		}
+	}
+	e.PutInt32(int32(r.Timeout / time.Millisecond))
+	return nil
+}
+
+func (r *ElectLeadersRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if version >= 1 {
+		if r.ElectionType, err = d.Int8(); err != nil {
+			return err
+		}
+	}
+	n, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	if n != -1 {
+		r.Topics = make([]ElectLeadersTopic, n)
+		for i := range r.Topics {
+			t := ElectLeadersTopic{}
+			if t.Topic, err = d.String(); err != nil {
+				return err
+			}
+			if t.Partitions, err = d.Int32Array(); err != nil {
+				return err
+			}
+			r.Topics[i] = t
+		}
+	}
+	timeout, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.Timeout = time.Duration(timeout) * time.Millisecond
+	return nil
+}
+
+func (r *ElectLeadersRequest) Key() int16 {
+	return ElectLeadersKey
+}
+
+func (r *ElectLeadersRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *ElectLeadersRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt8("election type", r.ElectionType)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
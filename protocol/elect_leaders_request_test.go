@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestElectLeadersRequest(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{0, 1} {
+		exp := &ElectLeadersRequest{
+			APIVersion: version,
+			Topics: []ElectLeadersTopic{
+				{Topic: "the-topic", Partitions: []int32{0, 1}},
+				{Topic: "another-topic", Partitions: []int32{2}},
+			},
+			Timeout: 30 * time.Second,
+		}
+		if version >= 1 {
+			exp.ElectionType = UncleanElection
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act ElectLeadersRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
+
+func TestElectLeadersRequestAllPartitions(t *testing.T) {
+	req := require.New(t)
+	exp := &ElectLeadersRequest{APIVersion: 1, Timeout: time.Second}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act ElectLeadersRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+	req.Nil(act.Topics)
+}
@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type ElectLeadersResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	// ErrorCode is sent from version 1.
+	ErrorCode int16
+	Topics    []ElectLeadersTopicResult
+}
+
+type ElectLeadersTopicResult struct {
+	Topic      string
+	Partitions []ElectLeadersPartitionResult
+}
+
+type ElectLeadersPartitionResult struct {
+	Partition    int32
+	ErrorCode    int16
+	ErrorMessage *string
+}
+
+func (r *ElectLeadersResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if r.APIVersion >= 1 {
+		e.PutInt16(r.ErrorCode)
+	}
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt16(p.ErrorCode)
+			if err = e.PutNullableString(p.ErrorMessage); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *ElectLeadersResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	if version >= 1 {
+		if r.ErrorCode, err = d.Int16(); err != nil {
+			return err
+		}
+	}
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]ElectLeadersTopicResult, n)
+	for i := range r.Topics {
+		t := ElectLeadersTopicResult{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]ElectLeadersPartitionResult, partitionCount)
+		for j := range t.Partitions {
+			p := ElectLeadersPartitionResult{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			if p.ErrorMessage, err = d.NullableString(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *ElectLeadersResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *ElectLeadersResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
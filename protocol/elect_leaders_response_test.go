@@ -0,0 +1,35 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestElectLeadersResponse(t *testing.T) {
+	req := require.New(t)
+	msg := "not in sync"
+	for _, version := range []int16{0, 1} {
+		exp := &ElectLeadersResponse{
+			APIVersion:   version,
+			ThrottleTime: time.Second,
+			Topics: []ElectLeadersTopicResult{{
+				Topic: "the-topic",
+				Partitions: []ElectLeadersPartitionResult{
+					{Partition: 0, ErrorCode: ErrNone.Code()},
+					{Partition: 1, ErrorCode: ErrPreferredLeaderNotAvailable.Code(), ErrorMessage: &msg},
+				},
+			}},
+		}
+		if version >= 1 {
+			exp.ErrorCode = ErrNotController.Code()
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act ElectLeadersResponse
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
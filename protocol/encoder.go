@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"encoding/binary"
 	"math"
 )
 
@@ -18,6 +19,19 @@ type PacketEncoder interface {
 	PutStringArray(in []string) error
 	PutInt32Array(in []int32) error
 	PutInt64Array(in []int64) error
+
+	// Flexible versions of APIs encode arrays, strings and bytes with compact, varint lengths,
+	// and end structs with tagged fields.
+	PutUVarint(in uint64)
+	PutCompactArrayLength(in int) error
+	PutCompactBytes(in []byte) error
+	PutCompactString(in string) error
+	PutCompactNullableString(in *string) error
+	PutCompactStringArray(in []string) error
+	PutCompactInt32Array(in []int32) error
+	PutCompactInt64Array(in []int64) error
+	PutEmptyTaggedFields()
+
 	Push(pe PushEncoder)
 	Pop()
 }
@@ -150,6 +164,81 @@ func (e *LenEncoder) PutInt64Array(in []int64) error {
 	return nil
 }
 
+// flexible versions
+
+func (e *LenEncoder) PutUVarint(in uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Length += binary.PutUvarint(b[:], in)
+}
+
+func (e *LenEncoder) PutCompactArrayLength(in int) error {
+	if in > math.MaxInt32 {
+		return ErrInvalidArrayLength
+	}
+	// lengths are one more than the array's, zero is null
+	e.PutUVarint(uint64(in + 1))
+	return nil
+}
+
+func (e *LenEncoder) PutCompactBytes(in []byte) error {
+	if in == nil {
+		return e.PutCompactArrayLength(-1)
+	}
+	if err := e.PutCompactArrayLength(len(in)); err != nil {
+		return err
+	}
+	return e.PutRawBytes(in)
+}
+
+func (e *LenEncoder) PutCompactString(in string) error {
+	if len(in) > math.MaxInt16 {
+		return ErrInvalidStringLength
+	}
+	e.PutUVarint(uint64(len(in) + 1))
+	e.Length += len(in)
+	return nil
+}
+
+func (e *LenEncoder) PutCompactNullableString(in *string) error {
+	if in == nil {
+		e.PutUVarint(0)
+		return nil
+	}
+	return e.PutCompactString(*in)
+}
+
+func (e *LenEncoder) PutCompactStringArray(in []string) error {
+	if err := e.PutCompactArrayLength(len(in)); err != nil {
+		return err
+	}
+	for _, str := range in {
+		if err := e.PutCompactString(str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *LenEncoder) PutCompactInt32Array(in []int32) error {
+	if err := e.PutCompactArrayLength(len(in)); err != nil {
+		return err
+	}
+	e.Length += 4 * len(in)
+	return nil
+}
+
+func (e *LenEncoder) PutCompactInt64Array(in []int64) error {
+	if err := e.PutCompactArrayLength(len(in)); err != nil {
+		return err
+	}
+	e.Length += 8 * len(in)
+	return nil
+}
+
+func (e *LenEncoder) PutEmptyTaggedFields() {
+	e.PutUVarint(0)
+}
+
 func (e *LenEncoder) Push(pe PushEncoder) {
 	e.Length += pe.ReserveSize()
 }
@@ -269,6 +358,70 @@ func (e *ByteEncoder) PutInt64Array(in []int64) error {
 	return nil
 }
 
+// flexible versions
+
+func (e *ByteEncoder) PutUVarint(in uint64) {
+	e.off += binary.PutUvarint(e.b[e.off:], in)
+}
+
+func (e *ByteEncoder) PutCompactArrayLength(in int) error {
+	e.PutUVarint(uint64(in + 1))
+	return nil
+}
+
+func (e *ByteEncoder) PutCompactBytes(in []byte) error {
+	if in == nil {
+		return e.PutCompactArrayLength(-1)
+	}
+	e.PutCompactArrayLength(len(in))
+	return e.PutRawBytes(in)
+}
+
+func (e *ByteEncoder) PutCompactString(in string) error {
+	e.PutUVarint(uint64(len(in) + 1))
+	copy(e.b[e.off:], in)
+	e.off += len(in)
+	return nil
+}
+
+func (e *ByteEncoder) PutCompactNullableString(in *string) error {
+	if in == nil {
+		e.PutUVarint(0)
+		return nil
+	}
+	return e.PutCompactString(*in)
+}
+
+func (e *ByteEncoder) PutCompactStringArray(in []string) error {
+	e.PutCompactArrayLength(len(in))
+	for _, val := range in {
+		if err := e.PutCompactString(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *ByteEncoder) PutCompactInt32Array(in []int32) error {
+	e.PutCompactArrayLength(len(in))
+	for _, val := range in {
+		e.PutInt32(val)
+	}
+	return nil
+}
+
+func (e *ByteEncoder) PutCompactInt64Array(in []int64) error {
+	e.PutCompactArrayLength(len(in))
+	for _, val := range in {
+		e.PutInt64(val)
+	}
+	return nil
+}
+
+func (e *ByteEncoder) PutEmptyTaggedFields() {
+	e.PutUVarint(0)
+}
+
 func (e *ByteEncoder) Push(pe PushEncoder) {
 	pe.SaveOffset(e.off)
 	e.off += pe.ReserveSize()
@@ -0,0 +1,24 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndTxnRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &EndTxnRequest{
+		APIVersion:      1,
+		TransactionalID: "the-txn",
+		ProducerID:      7,
+		ProducerEpoch:   2,
+		Committed:       true,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act EndTxnRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndTxnResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &EndTxnResponse{
+		APIVersion:   1,
+		ThrottleTime: time.Second,
+		ErrorCode:    ErrInvalidTxnState.Code(),
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act EndTxnResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -60,66 +60,114 @@ var (
 	ErrTransactionalIdAuthorizationFailed = Error{code: 53, msg: "transactional id authorization failed"}
 	ErrSecurityDisabled                   = Error{code: 54, msg: "security disabled"}
 	ErrOperationNotAttempted              = Error{code: 55, msg: "operation not attempted"}
+	ErrKafkaStorageError                  = Error{code: 56, msg: "kafka storage error"}
+	ErrLogDirNotFound                     = Error{code: 57, msg: "log dir not found"}
+	ErrSaslAuthenticationFailed           = Error{code: 58, msg: "sasl authentication failed"}
+	ErrDelegationTokenAuthDisabled        = Error{code: 61, msg: "delegation token auth disabled"}
+	ErrDelegationTokenNotFound            = Error{code: 62, msg: "delegation token not found"}
+	ErrDelegationTokenOwnerMismatch       = Error{code: 63, msg: "delegation token owner mismatch"}
+	ErrDelegationTokenRequestNotAllowed   = Error{code: 64, msg: "delegation token request not allowed"}
+	ErrDelegationTokenAuthorizationFailed = Error{code: 65, msg: "delegation token authorization failed"}
+	ErrDelegationTokenExpired             = Error{code: 66, msg: "delegation token expired"}
+	ErrNonEmptyGroup                      = Error{code: 68, msg: "non empty group"}
+	ErrGroupIdNotFound                    = Error{code: 69, msg: "group id not found"}
+	ErrFetchSessionIDNotFound             = Error{code: 70, msg: "fetch session id not found"}
+	ErrInvalidFetchSessionEpoch           = Error{code: 71, msg: "invalid fetch session epoch"}
+	ErrFencedLeaderEpoch                  = Error{code: 74, msg: "fenced leader epoch"}
+	ErrUnknownLeaderEpoch                 = Error{code: 75, msg: "unknown leader epoch"}
+	ErrUnsupportedCompressionType         = Error{code: 76, msg: "unsupported compression type"}
+	ErrPreferredLeaderNotAvailable        = Error{code: 80, msg: "preferred leader not available"}
+	ErrGroupMaxSizeReached                = Error{code: 81, msg: "group max size reached"}
+	ErrFencedInstanceId                   = Error{code: 82, msg: "fenced instance id"}
+	ErrEligibleLeadersNotAvailable        = Error{code: 83, msg: "eligible leaders not available"}
+	ErrElectionNotNeeded                  = Error{code: 84, msg: "election not needed"}
+	ErrNoReassignmentInProgress           = Error{code: 85, msg: "no reassignment in progress"}
+	ErrGroupSubscribedToTopic             = Error{code: 86, msg: "group subscribed to topic"}
+	ErrTransactionalIdNotFound            = Error{code: 105, msg: "transactional id not found"}
 
 	// Errs maps err codes to their errs.
 	Errs = map[int16]Error{
-		-1: ErrUnknown,
-		0:  ErrNone,
-		1:  ErrOffsetOutOfRange,
-		2:  ErrCorruptMessage,
-		3:  ErrUnknownTopicOrPartition,
-		4:  ErrInvalidFetchSize,
-		5:  ErrLeaderNotAvailable,
-		6:  ErrNotLeaderForPartition,
-		7:  ErrRequestTimedOut,
-		8:  ErrBrokerNotAvailable,
-		9:  ErrReplicaNotAvailable,
-		10: ErrMessageTooLarge,
-		11: ErrStaleControllerEpoch,
-		12: ErrOffsetMetadataTooLarge,
-		13: ErrNetworkException,
-		14: ErrCoordinatorLoadInProgress,
-		15: ErrCoordinatorNotAvailable,
-		16: ErrNotCoordinator,
-		17: ErrInvalidTopicException,
-		18: ErrRecordListTooLarge,
-		19: ErrNotEnoughReplicas,
-		20: ErrNotEnoughReplicasAfterAppend,
-		21: ErrInvalidRequiredAcks,
-		22: ErrIllegalGeneration,
-		23: ErrInconsistentGroupProtocol,
-		24: ErrInvalidGroupId,
-		25: ErrUnknownMemberId,
-		26: ErrInvalidSessionTimeout,
-		27: ErrRebalanceInProgress,
-		28: ErrInvalidCommitOffsetSize,
-		29: ErrTopicAuthorizationFailed,
-		30: ErrGroupAuthorizationFailed,
-		31: ErrClusterAuthorizationFailed,
-		32: ErrInvalidTimestamp,
-		33: ErrUnsupportedSaslMechanism,
-		34: ErrIllegalSaslState,
-		35: ErrUnsupportedVersion,
-		36: ErrTopicAlreadyExists,
-		37: ErrInvalidPartitions,
-		38: ErrInvalidReplicationFactor,
-		39: ErrInvalidReplicaAssignment,
-		40: ErrInvalidConfig,
-		41: ErrNotController,
-		42: ErrInvalidRequest,
-		43: ErrUnsupportedForMessageFormat,
-		44: ErrPolicyViolation,
-		45: ErrOutOfOrderSequenceNumber,
-		46: ErrDuplicateSequenceNumber,
-		47: ErrInvalidProducerEpoch,
-		48: ErrInvalidTxnState,
-		49: ErrInvalidProducerIdMapping,
-		50: ErrInvalidTransactionTimeout,
-		51: ErrConcurrentTransactions,
-		52: ErrTransactionCoordinatorFenced,
-		53: ErrTransactionalIdAuthorizationFailed,
-		54: ErrSecurityDisabled,
-		55: ErrOperationNotAttempted,
+		-1:  ErrUnknown,
+		0:   ErrNone,
+		1:   ErrOffsetOutOfRange,
+		2:   ErrCorruptMessage,
+		3:   ErrUnknownTopicOrPartition,
+		4:   ErrInvalidFetchSize,
+		5:   ErrLeaderNotAvailable,
+		6:   ErrNotLeaderForPartition,
+		7:   ErrRequestTimedOut,
+		8:   ErrBrokerNotAvailable,
+		9:   ErrReplicaNotAvailable,
+		10:  ErrMessageTooLarge,
+		11:  ErrStaleControllerEpoch,
+		12:  ErrOffsetMetadataTooLarge,
+		13:  ErrNetworkException,
+		14:  ErrCoordinatorLoadInProgress,
+		15:  ErrCoordinatorNotAvailable,
+		16:  ErrNotCoordinator,
+		17:  ErrInvalidTopicException,
+		18:  ErrRecordListTooLarge,
+		19:  ErrNotEnoughReplicas,
+		20:  ErrNotEnoughReplicasAfterAppend,
+		21:  ErrInvalidRequiredAcks,
+		22:  ErrIllegalGeneration,
+		23:  ErrInconsistentGroupProtocol,
+		24:  ErrInvalidGroupId,
+		25:  ErrUnknownMemberId,
+		26:  ErrInvalidSessionTimeout,
+		27:  ErrRebalanceInProgress,
+		28:  ErrInvalidCommitOffsetSize,
+		29:  ErrTopicAuthorizationFailed,
+		30:  ErrGroupAuthorizationFailed,
+		31:  ErrClusterAuthorizationFailed,
+		32:  ErrInvalidTimestamp,
+		33:  ErrUnsupportedSaslMechanism,
+		34:  ErrIllegalSaslState,
+		35:  ErrUnsupportedVersion,
+		36:  ErrTopicAlreadyExists,
+		37:  ErrInvalidPartitions,
+		38:  ErrInvalidReplicationFactor,
+		39:  ErrInvalidReplicaAssignment,
+		40:  ErrInvalidConfig,
+		41:  ErrNotController,
+		42:  ErrInvalidRequest,
+		43:  ErrUnsupportedForMessageFormat,
+		44:  ErrPolicyViolation,
+		45:  ErrOutOfOrderSequenceNumber,
+		46:  ErrDuplicateSequenceNumber,
+		47:  ErrInvalidProducerEpoch,
+		48:  ErrInvalidTxnState,
+		49:  ErrInvalidProducerIdMapping,
+		50:  ErrInvalidTransactionTimeout,
+		51:  ErrConcurrentTransactions,
+		52:  ErrTransactionCoordinatorFenced,
+		53:  ErrTransactionalIdAuthorizationFailed,
+		54:  ErrSecurityDisabled,
+		55:  ErrOperationNotAttempted,
+		56:  ErrKafkaStorageError,
+		57:  ErrLogDirNotFound,
+		58:  ErrSaslAuthenticationFailed,
+		61:  ErrDelegationTokenAuthDisabled,
+		62:  ErrDelegationTokenNotFound,
+		63:  ErrDelegationTokenOwnerMismatch,
+		64:  ErrDelegationTokenRequestNotAllowed,
+		65:  ErrDelegationTokenAuthorizationFailed,
+		66:  ErrDelegationTokenExpired,
+		68:  ErrNonEmptyGroup,
+		69:  ErrGroupIdNotFound,
+		70:  ErrFetchSessionIDNotFound,
+		71:  ErrInvalidFetchSessionEpoch,
+		74:  ErrFencedLeaderEpoch,
+		75:  ErrUnknownLeaderEpoch,
+		76:  ErrUnsupportedCompressionType,
+		80:  ErrPreferredLeaderNotAvailable,
+		81:  ErrGroupMaxSizeReached,
+		82:  ErrFencedInstanceId,
+		83:  ErrEligibleLeadersNotAvailable,
+		84:  ErrElectionNotNeeded,
+		85:  ErrNoReassignmentInProgress,
+		86:  ErrGroupSubscribedToTopic,
+		105: ErrTransactionalIdNotFound,
 	}
 )
 
@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_ExpireDelegationToken
+
+type ExpireDelegationTokenRequest struct {
+	APIVersion int16
+
+	HMAC []byte
+	// ExpiryPeriod is how long until the token expires, negative to expire it immediately.
+	ExpiryPeriod time.Duration
+}
+
+func (r *ExpireDelegationTokenRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutBytes(r.HMAC); err != nil {
+		return err
+	}
+	e.PutInt64(int64(r.ExpiryPeriod / time.Millisecond))
+	return nil
+}
+
+func (r *ExpireDelegationTokenRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.HMAC, err = d.Bytes(); err != nil {
+		return err
+	}
+	period, err := d.Int64()
+	if err != nil {
+		return err
+	}
+	r.ExpiryPeriod = time.Duration(period) * time.Millisecond
+	return nil
+}
+
+func (r *ExpireDelegationTokenRequest) Key() int16 {
+	return ExpireDelegationTokenKey
+}
+
+func (r *ExpireDelegationTokenRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *ExpireDelegationTokenRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddDuration("expiry period", r.ExpiryPeriod)
+	return nil
+}
@@ -0,0 +1,22 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpireDelegationTokenRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &ExpireDelegationTokenRequest{
+		HMAC:         []byte("hmac"),
+		ExpiryPeriod: time.Hour,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act ExpireDelegationTokenRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type ExpireDelegationTokenResponse struct {
+	APIVersion int16
+
+	ErrorCode int16
+	// ExpiryTime is sent in milliseconds.
+	ExpiryTime   time.Time
+	ThrottleTime time.Duration
+}
+
+func (r *ExpireDelegationTokenResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt16(r.ErrorCode)
+	putMillis(e, r.ExpiryTime)
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	return nil
+}
+
+func (r *ExpireDelegationTokenResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	if r.ExpiryTime, err = millis(d); err != nil {
+		return err
+	}
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	return nil
+}
+
+func (r *ExpireDelegationTokenResponse) Key() int16 {
+	return ExpireDelegationTokenKey
+}
+
+func (r *ExpireDelegationTokenResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *ExpireDelegationTokenResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddTime("expiry time", r.ExpiryTime)
+	return nil
+}
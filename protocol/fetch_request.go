@@ -12,9 +12,14 @@ const (
 )
 
 type FetchPartition struct {
-	Partition   int32
-	FetchOffset int64
-	MaxBytes    int32
+	Partition int32
+	// CurrentLeaderEpoch fences fetches from clients with a stale or newer view of the
+	// partition's leadership, from v9 on. -1 skips the check.
+	CurrentLeaderEpoch int32
+	FetchOffset        int64
+	// LogStartOffset is the follower's log start offset, it's only set by followers.
+	LogStartOffset int64
+	MaxBytes       int32
 }
 
 type FetchTopic struct {
@@ -22,6 +27,12 @@ type FetchTopic struct {
 	Partitions []*FetchPartition
 }
 
+// FetchForgottenTopic is the partitions of a topic an incremental fetch removes from its session.
+type FetchForgottenTopic struct {
+	Topic      string
+	Partitions []int32
+}
+
 type FetchRequest struct {
 	APIVersion int16
 
@@ -30,7 +41,14 @@ type FetchRequest struct {
 	MinBytes       int32
 	MaxBytes       int32
 	IsolationLevel IsolationLevel
-	Topics         []*FetchTopic
+	// SessionID and SessionEpoch identify the fetch session of incremental fetches, from v7 on.
+	// Full fetches have a session id of 0.
+	SessionID       int32
+	SessionEpoch    int32
+	Topics          []*FetchTopic
+	ForgottenTopics []FetchForgottenTopic
+	// RackID is the rack of the client, from v11 on.
+	RackID string
 }
 
 func (r *FetchRequest) Encode(e PacketEncoder) (err error) {
@@ -47,6 +65,10 @@ func (r *FetchRequest) Encode(e PacketEncoder) (err error) {
 	if r.APIVersion >= 4 {
 		e.PutInt8(int8(r.IsolationLevel))
 	}
+	if r.APIVersion >= 7 {
+		e.PutInt32(r.SessionID)
+		e.PutInt32(r.SessionEpoch)
+	}
 	if err = e.PutArrayLength(len(r.Topics)); err != nil {
 		return err
 	}
@@ -59,10 +81,34 @@ func (r *FetchRequest) Encode(e PacketEncoder) (err error) {
 		}
 		for _, p := range t.Partitions {
 			e.PutInt32(p.Partition)
+			if r.APIVersion >= 9 {
+				e.PutInt32(p.CurrentLeaderEpoch)
+			}
 			e.PutInt64(p.FetchOffset)
+			if r.APIVersion >= 5 {
+				e.PutInt64(p.LogStartOffset)
+			}
 			e.PutInt32(p.MaxBytes)
 		}
 	}
+	if r.APIVersion >= 7 {
+		if err = e.PutArrayLength(len(r.ForgottenTopics)); err != nil {
+			return err
+		}
+		for _, t := range r.ForgottenTopics {
+			if err = e.PutString(t.Topic); err != nil {
+				return err
+			}
+			if err = e.PutInt32Array(t.Partitions); err != nil {
+				return err
+			}
+		}
+	}
+	if r.APIVersion >= 11 {
+		if err = e.PutString(r.RackID); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -93,6 +139,14 @@ func (r *FetchRequest) Decode(d PacketDecoder, version int16) (err error) {
 		}
 		r.IsolationLevel = IsolationLevel(isolationLevel)
 	}
+	if r.APIVersion >= 7 {
+		if r.SessionID, err = d.Int32(); err != nil {
+			return err
+		}
+		if r.SessionEpoch, err = d.Int32(); err != nil {
+			return err
+		}
+	}
 	topicCount, err := d.ArrayLength()
 	if err != nil {
 		return err
@@ -115,10 +169,22 @@ func (r *FetchRequest) Decode(d PacketDecoder, version int16) (err error) {
 			if err != nil {
 				return err
 			}
+			if r.APIVersion >= 9 {
+				p.CurrentLeaderEpoch, err = d.Int32()
+				if err != nil {
+					return err
+				}
+			}
 			p.FetchOffset, err = d.Int64()
 			if err != nil {
 				return err
 			}
+			if r.APIVersion >= 5 {
+				p.LogStartOffset, err = d.Int64()
+				if err != nil {
+					return err
+				}
+			}
 			p.MaxBytes, err = d.Int32()
 			if err != nil {
 				return err
@@ -129,6 +195,27 @@ func (r *FetchRequest) Decode(d PacketDecoder, version int16) (err error) {
 		topics[i] = t
 	}
 	r.Topics = topics
+	if r.APIVersion >= 7 {
+		forgottenCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		r.ForgottenTopics = make([]FetchForgottenTopic, forgottenCount)
+		for i := range r.ForgottenTopics {
+			t := &r.ForgottenTopics[i]
+			if t.Topic, err = d.String(); err != nil {
+				return err
+			}
+			if t.Partitions, err = d.Int32Array(); err != nil {
+				return err
+			}
+		}
+	}
+	if r.APIVersion >= 11 {
+		if r.RackID, err = d.String(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
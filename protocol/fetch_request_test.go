@@ -28,3 +28,62 @@ func TestFetchRequest(t *testing.T) {
 	req.NoError(err)
 	req.Equal(exp, &act)
 }
+
+func TestFetchRequestV5(t *testing.T) {
+	req := require.New(t)
+	exp := &FetchRequest{
+		APIVersion:     5,
+		ReplicaID:      1,
+		MaxWaitTime:    2,
+		MinBytes:       3,
+		MaxBytes:       4,
+		IsolationLevel: ReadCommitted,
+		Topics: []*FetchTopic{{
+			Topic: "test_topic",
+			Partitions: []*FetchPartition{{
+				Partition:      1,
+				FetchOffset:    2,
+				LogStartOffset: 1,
+				MaxBytes:       3,
+			}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act FetchRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
+
+func TestFetchRequestV11(t *testing.T) {
+	req := require.New(t)
+	exp := &FetchRequest{
+		APIVersion:     11,
+		ReplicaID:      1,
+		MaxWaitTime:    2,
+		MinBytes:       3,
+		MaxBytes:       4,
+		IsolationLevel: ReadCommitted,
+		SessionID:      5,
+		SessionEpoch:   6,
+		Topics: []*FetchTopic{{
+			Topic: "test_topic",
+			Partitions: []*FetchPartition{{
+				Partition:          1,
+				CurrentLeaderEpoch: 7,
+				FetchOffset:        2,
+				LogStartOffset:     1,
+				MaxBytes:           3,
+			}},
+		}},
+		ForgottenTopics: []FetchForgottenTopic{{Topic: "forgotten_topic", Partitions: []int32{0, 1}}},
+		RackID:          "rack",
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act FetchRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
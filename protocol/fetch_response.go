@@ -28,12 +28,17 @@ func (t *AbortedTransaction) Encode(e PacketEncoder) (err error) {
 }
 
 type FetchPartitionResponse struct {
-	Partition           int32
-	ErrorCode           int16
-	HighWatermark       int64
-	LastStableOffset    int64
+	Partition        int32
+	ErrorCode        int16
+	HighWatermark    int64
+	LastStableOffset int64
+	// LogStartOffset is the leader's log start offset, followers delete the messages before it.
+	LogStartOffset      int64
 	AbortedTransactions []*AbortedTransaction
-	RecordSet           []byte
+	// PreferredReadReplica is the replica the client should fetch from instead, from v11 on. -1
+	// if it should keep fetching from the leader.
+	PreferredReadReplica int32
+	RecordSet            []byte
 }
 
 func (r *FetchPartitionResponse) Decode(d PacketDecoder, version int16) (err error) {
@@ -51,6 +56,11 @@ func (r *FetchPartitionResponse) Decode(d PacketDecoder, version int16) (err err
 		if r.LastStableOffset, err = d.Int64(); err != nil {
 			return err
 		}
+		if version >= 5 {
+			if r.LogStartOffset, err = d.Int64(); err != nil {
+				return err
+			}
+		}
 
 		transactionCount, err := d.ArrayLength()
 		if err != nil {
@@ -67,6 +77,12 @@ func (r *FetchPartitionResponse) Decode(d PacketDecoder, version int16) (err err
 		}
 	}
 
+	if version >= 11 {
+		if r.PreferredReadReplica, err = d.Int32(); err != nil {
+			return err
+		}
+	}
+
 	if r.RecordSet, err = d.Bytes(); err != nil {
 		return err
 	}
@@ -81,6 +97,9 @@ func (r *FetchPartitionResponse) Encode(e PacketEncoder, version int16) (err err
 
 	if version >= 4 {
 		e.PutInt64(r.LastStableOffset)
+		if version >= 5 {
+			e.PutInt64(r.LogStartOffset)
+		}
 
 		if err = e.PutArrayLength(len(r.AbortedTransactions)); err != nil {
 			return err
@@ -90,6 +109,10 @@ func (r *FetchPartitionResponse) Encode(e PacketEncoder, version int16) (err err
 		}
 	}
 
+	if version >= 11 {
+		e.PutInt32(r.PreferredReadReplica)
+	}
+
 	if err = e.PutBytes(r.RecordSet); err != nil {
 		return err
 	}
@@ -108,7 +131,11 @@ type FetchResponse struct {
 	APIVersion int16
 
 	ThrottleTime time.Duration
-	Responses    FetchTopicResponses
+	// ErrorCode and SessionID are the top level error and the fetch session of the response,
+	// from v7 on. A session id of 0 is a full fetch outside any session.
+	ErrorCode int16
+	SessionID int32
+	Responses FetchTopicResponses
 }
 
 type FetchTopicResponses []*FetchTopicResponse
@@ -117,6 +144,10 @@ func (r *FetchResponse) Encode(e PacketEncoder) (err error) {
 	if r.APIVersion >= 1 {
 		e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
 	}
+	if r.APIVersion >= 7 {
+		e.PutInt16(r.ErrorCode)
+		e.PutInt32(r.SessionID)
+	}
 
 	if err = e.PutArrayLength(len(r.Responses)); err != nil {
 		return err
@@ -147,6 +178,14 @@ func (r *FetchResponse) Decode(d PacketDecoder, version int16) (err error) {
 		}
 		r.ThrottleTime = time.Duration(throttle) * time.Millisecond
 	}
+	if r.APIVersion >= 7 {
+		if r.ErrorCode, err = d.Int16(); err != nil {
+			return err
+		}
+		if r.SessionID, err = d.Int32(); err != nil {
+			return err
+		}
+	}
 
 	responseCount, err := d.ArrayLength()
 	if err != nil {
@@ -29,3 +29,58 @@ func TestFetchResponse(t *testing.T) {
 	req.NoError(err)
 	req.Equal(exp, &act)
 }
+
+func TestFetchResponseV5(t *testing.T) {
+	req := require.New(t)
+	exp := &FetchResponse{
+		APIVersion:   5,
+		ThrottleTime: time.Millisecond,
+		Responses: []*FetchTopicResponse{{
+			Topic: "test_topic",
+			PartitionResponses: []*FetchPartitionResponse{{
+				Partition:           1,
+				ErrorCode:           ErrNone.Code(),
+				HighWatermark:       2,
+				LastStableOffset:    2,
+				LogStartOffset:      1,
+				AbortedTransactions: []*AbortedTransaction{{ProducerID: 3, FirstOffset: 1}},
+				RecordSet:           []byte("sup"),
+			}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act FetchResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
+
+func TestFetchResponseV11(t *testing.T) {
+	req := require.New(t)
+	exp := &FetchResponse{
+		APIVersion:   11,
+		ThrottleTime: time.Millisecond,
+		ErrorCode:    ErrNone.Code(),
+		SessionID:    4,
+		Responses: []*FetchTopicResponse{{
+			Topic: "test_topic",
+			PartitionResponses: []*FetchPartitionResponse{{
+				Partition:            1,
+				ErrorCode:            ErrNone.Code(),
+				HighWatermark:        2,
+				LastStableOffset:     2,
+				LogStartOffset:       1,
+				AbortedTransactions:  []*AbortedTransaction{{ProducerID: 3, FirstOffset: 1}},
+				PreferredReadReplica: -1,
+				RecordSet:            []byte("sup"),
+			}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act FetchResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
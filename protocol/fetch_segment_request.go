@@ -0,0 +1,76 @@
+package protocol
+
+import "go.uber.org/zap/zapcore"
+
+// FetchSegmentRequest is jocko's own request, between brokers, for a follower far behind to copy
+// its leader's sealed segments whole rather than fetching their messages. It either lists the
+// partition's sealed segments, if BaseOffset is -1, or reads from the log of one.
+type FetchSegmentRequest struct {
+	APIVersion int16
+
+	ReplicaID int32
+	Topic     string
+	Partition int32
+	// CurrentLeaderEpoch is the epoch the follower thinks the partition's leader has, -1 skips
+	// the check.
+	CurrentLeaderEpoch int32
+	// BaseOffset is the base offset of the segment to read, or -1 to list the segments.
+	BaseOffset int64
+	// Position is where in the segment's log to read from.
+	Position int64
+	MaxBytes int32
+}
+
+func (r *FetchSegmentRequest) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(r.ReplicaID)
+	if err = e.PutString(r.Topic); err != nil {
+		return err
+	}
+	e.PutInt32(r.Partition)
+	e.PutInt32(r.CurrentLeaderEpoch)
+	e.PutInt64(r.BaseOffset)
+	e.PutInt64(r.Position)
+	e.PutInt32(r.MaxBytes)
+	return nil
+}
+
+func (r *FetchSegmentRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.ReplicaID, err = d.Int32(); err != nil {
+		return err
+	}
+	if r.Topic, err = d.String(); err != nil {
+		return err
+	}
+	if r.Partition, err = d.Int32(); err != nil {
+		return err
+	}
+	if r.CurrentLeaderEpoch, err = d.Int32(); err != nil {
+		return err
+	}
+	if r.BaseOffset, err = d.Int64(); err != nil {
+		return err
+	}
+	if r.Position, err = d.Int64(); err != nil {
+		return err
+	}
+	r.MaxBytes, err = d.Int32()
+	return err
+}
+
+func (r *FetchSegmentRequest) Key() int16 {
+	return FetchSegmentKey
+}
+
+func (r *FetchSegmentRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *FetchSegmentRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt32("replica id", r.ReplicaID)
+	e.AddString("topic", r.Topic)
+	e.AddInt32("partition", r.Partition)
+	e.AddInt64("base offset", r.BaseOffset)
+	e.AddInt64("position", r.Position)
+	return nil
+}
@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchSegmentRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &FetchSegmentRequest{
+		ReplicaID:          1,
+		Topic:              "the-topic",
+		Partition:          2,
+		CurrentLeaderEpoch: 3,
+		BaseOffset:         100,
+		Position:           4096,
+		MaxBytes:           1 << 20,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act FetchSegmentRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
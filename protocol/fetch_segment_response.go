@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type FetchSegmentResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	ErrorCode    int16
+	// LogStartOffset, Segments and Epochs are returned listing the segments.
+	LogStartOffset int64
+	Segments       []SealedSegment
+	// Epochs is the leader's leader epoch cache.
+	Epochs []SegmentEpoch
+	// Bytes is what was read from the segment's log, empty once it's been read to its end.
+	Bytes []byte
+}
+
+// SealedSegment describes a segment of a leader's log that's no longer appended to.
+type SealedSegment struct {
+	BaseOffset int64
+	// NextOffset is the base offset of the segment after it.
+	NextOffset int64
+	Size       int64
+	// Checksum is the CRC-32C of the segment's log.
+	Checksum uint32
+}
+
+// SegmentEpoch is the offset of the first message a partition's leader appended in an epoch.
+type SegmentEpoch struct {
+	Epoch       int32
+	StartOffset int64
+}
+
+func (r *FetchSegmentResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	e.PutInt16(r.ErrorCode)
+	e.PutInt64(r.LogStartOffset)
+	if err = e.PutArrayLength(len(r.Segments)); err != nil {
+		return err
+	}
+	for _, s := range r.Segments {
+		e.PutInt64(s.BaseOffset)
+		e.PutInt64(s.NextOffset)
+		e.PutInt64(s.Size)
+		e.PutInt32(int32(s.Checksum))
+	}
+	if err = e.PutArrayLength(len(r.Epochs)); err != nil {
+		return err
+	}
+	for _, epoch := range r.Epochs {
+		e.PutInt32(epoch.Epoch)
+		e.PutInt64(epoch.StartOffset)
+	}
+	return e.PutBytes(r.Bytes)
+}
+
+func (r *FetchSegmentResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	if r.LogStartOffset, err = d.Int64(); err != nil {
+		return err
+	}
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Segments = make([]SealedSegment, n)
+	}
+	for i := range r.Segments {
+		s := SealedSegment{}
+		if s.BaseOffset, err = d.Int64(); err != nil {
+			return err
+		}
+		if s.NextOffset, err = d.Int64(); err != nil {
+			return err
+		}
+		if s.Size, err = d.Int64(); err != nil {
+			return err
+		}
+		checksum, err := d.Int32()
+		if err != nil {
+			return err
+		}
+		s.Checksum = uint32(checksum)
+		r.Segments[i] = s
+	}
+	if n, err = d.ArrayLength(); err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Epochs = make([]SegmentEpoch, n)
+	}
+	for i := range r.Epochs {
+		epoch := SegmentEpoch{}
+		if epoch.Epoch, err = d.Int32(); err != nil {
+			return err
+		}
+		if epoch.StartOffset, err = d.Int64(); err != nil {
+			return err
+		}
+		r.Epochs[i] = epoch
+	}
+	r.Bytes, err = d.Bytes()
+	return err
+}
+
+func (r *FetchSegmentResponse) Key() int16 {
+	return FetchSegmentKey
+}
+
+func (r *FetchSegmentResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *FetchSegmentResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddInt("segments", len(r.Segments))
+	e.AddInt("bytes", len(r.Bytes))
+	return nil
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchSegmentResponse(t *testing.T) {
+	req := require.New(t)
+	for _, exp := range []*FetchSegmentResponse{{
+		ThrottleTime:   time.Millisecond,
+		LogStartOffset: 10,
+		Segments: []SealedSegment{
+			{BaseOffset: 0, NextOffset: 100, Size: 4096, Checksum: 0xdeadbeef},
+			{BaseOffset: 100, NextOffset: 250, Size: 8192, Checksum: 1},
+		},
+		Epochs: []SegmentEpoch{{Epoch: 1, StartOffset: 0}, {Epoch: 3, StartOffset: 120}},
+	}, {
+		Bytes: []byte("the segment's log"),
+	}, {
+		ErrorCode: ErrNotLeaderForPartition.Code(),
+	}} {
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act FetchSegmentResponse
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
@@ -10,7 +10,7 @@ type CoordinatorType int8
 
 const (
 	CoordinatorGroup       CoordinatorType = 0
-	CoordinatorTransaction CoordinatorType = 0
+	CoordinatorTransaction CoordinatorType = 1
 )
 
 type FindCoordinatorRequest struct {
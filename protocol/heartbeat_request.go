@@ -10,6 +10,8 @@ type HeartbeatRequest struct {
 	GroupID           string
 	GroupGenerationID int32
 	MemberID          string
+	// GroupInstanceID is sent from version 3.
+	GroupInstanceID *string
 }
 
 func (r *HeartbeatRequest) Encode(e PacketEncoder) (err error) {
@@ -17,7 +19,13 @@ func (r *HeartbeatRequest) Encode(e PacketEncoder) (err error) {
 		return err
 	}
 	e.PutInt32(r.GroupGenerationID)
-	return e.PutString(r.MemberID)
+	if err = e.PutString(r.MemberID); err != nil {
+		return err
+	}
+	if r.APIVersion >= 3 {
+		return e.PutNullableString(r.GroupInstanceID)
+	}
+	return nil
 }
 
 func (r *HeartbeatRequest) Decode(d PacketDecoder, version int16) (err error) {
@@ -32,6 +40,11 @@ func (r *HeartbeatRequest) Decode(d PacketDecoder, version int16) (err error) {
 	if r.MemberID, err = d.String(); err != nil {
 		return
 	}
+	if r.APIVersion >= 3 {
+		if r.GroupInstanceID, err = d.NullableString(); err != nil {
+			return
+		}
+	}
 	return nil
 }
 
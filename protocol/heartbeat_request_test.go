@@ -19,4 +19,19 @@ func TestHeartbeatRequest(t *testing.T) {
 	err = Decode(b, &act, exp.Version())
 	req.NoError(err)
 	req.Equal(exp, &act)
+
+	instanceID := "instance"
+	exp = &HeartbeatRequest{
+		APIVersion:        3,
+		GroupID:           "group",
+		GroupGenerationID: 1,
+		MemberID:          "member",
+		GroupInstanceID:   &instanceID,
+	}
+	b, err = Encode(exp)
+	req.NoError(err)
+	act = HeartbeatRequest{}
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
 }
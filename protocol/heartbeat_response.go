@@ -14,6 +14,9 @@ type HeartbeatResponse struct {
 }
 
 func (r *HeartbeatResponse) Encode(e PacketEncoder) error {
+	if r.APIVersion >= 1 {
+		e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	}
 	e.PutInt16(r.ErrorCode)
 	return nil
 }
@@ -26,7 +29,7 @@ func (r *HeartbeatResponse) Decode(d PacketDecoder, version int16) (err error) {
 		if err != nil {
 			return err
 		}
-		r.ThrottleTime = time.Duration(throttle) / time.Millisecond
+		r.ThrottleTime = time.Duration(throttle) * time.Millisecond
 	}
 	r.ErrorCode, err = d.Int16()
 	return err
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// Config operations of incremental alter configs requests. Append and subtract are only for
+// configs whose values are lists.
+const (
+	ConfigOperationSet      int8 = 0
+	ConfigOperationDelete   int8 = 1
+	ConfigOperationAppend   int8 = 2
+	ConfigOperationSubtract int8 = 3
+)
+
+type IncrementalAlterConfigsRequest struct {
+	APIVersion int16
+
+	Resources    []IncrementalAlterConfigsResource
+	ValidateOnly bool
+}
+
+type IncrementalAlterConfigsResource struct {
+	Type    int8
+	Name    string
+	Entries []IncrementalAlterConfigsEntry
+}
+
+type IncrementalAlterConfigsEntry struct {
+	Name      string
+	Operation int8
+	Value     *string
+}
+
+func (r *IncrementalAlterConfigsRequest) Encode(e PacketEncoder) (err error) {
+	if err := e.PutArrayLength(len(r.Resources)); err != nil {
+		return err
+	}
+	for _, resource := range r.Resources {
+		e.PutInt8(resource.Type)
+		if err := e.PutString(resource.Name); err != nil {
+			return err
+		}
+		if err := e.PutArrayLength(len(resource.Entries)); err != nil {
+			return err
+		}
+		for _, entry := range resource.Entries {
+			if err := e.PutString(entry.Name); err != nil {
+				return err
+			}
+			e.PutInt8(entry.Operation)
+			if err := e.PutNullableString(entry.Value); err != nil {
+				return err
+			}
+		}
+	}
+	e.PutBool(r.ValidateOnly)
+	return nil
+}
+
+func (r *IncrementalAlterConfigsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+
+	resourceCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Resources = make([]IncrementalAlterConfigsResource, resourceCount)
+	for i := 0; i < resourceCount; i++ {
+		resource := IncrementalAlterConfigsResource{}
+		if resource.Type, err = d.Int8(); err != nil {
+			return err
+		}
+		if resource.Name, err = d.String(); err != nil {
+			return err
+		}
+		entryCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		resource.Entries = make([]IncrementalAlterConfigsEntry, entryCount)
+		for j := 0; j < entryCount; j++ {
+			entry := IncrementalAlterConfigsEntry{}
+			if entry.Name, err = d.String(); err != nil {
+				return err
+			}
+			if entry.Operation, err = d.Int8(); err != nil {
+				return err
+			}
+			if entry.Value, err = d.NullableString(); err != nil {
+				return err
+			}
+			resource.Entries[j] = entry
+		}
+		r.Resources[i] = resource
+	}
+	if r.ValidateOnly, err = d.Bool(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *IncrementalAlterConfigsRequest) Key() int16 {
+	return IncrementalAlterConfigsKey
+}
+
+func (r *IncrementalAlterConfigsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *IncrementalAlterConfigsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("resources", len(r.Resources))
+	e.AddBool("validate only", r.ValidateOnly)
+	return nil
+}
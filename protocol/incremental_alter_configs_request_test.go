@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrementalAlterConfigsRequest(t *testing.T) {
+	req := require.New(t)
+	val := "compact"
+	exp := &IncrementalAlterConfigsRequest{
+		Resources: []IncrementalAlterConfigsResource{{
+			Type: 2,
+			Name: "the-topic",
+			Entries: []IncrementalAlterConfigsEntry{
+				{Name: "cleanup.policy", Operation: ConfigOperationSet, Value: &val},
+				{Name: "retention.ms", Operation: ConfigOperationDelete},
+			},
+		}},
+		ValidateOnly: true,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act IncrementalAlterConfigsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// IncrementalAlterConfigsResponse has the same resource responses as AlterConfigsResponse.
+type IncrementalAlterConfigsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	Resources    []AlterConfigResourceResponse
+}
+
+func (r *IncrementalAlterConfigsResponse) Encode(e PacketEncoder) error {
+	return (&AlterConfigsResponse{ThrottleTime: r.ThrottleTime, Resources: r.Resources}).Encode(e)
+}
+
+func (r *IncrementalAlterConfigsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	var resp AlterConfigsResponse
+	if err = resp.Decode(d, version); err != nil {
+		return err
+	}
+	r.APIVersion = version
+	r.ThrottleTime = resp.ThrottleTime
+	r.Resources = resp.Resources
+	return nil
+}
+
+func (r *IncrementalAlterConfigsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *IncrementalAlterConfigsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("resources", len(r.Resources))
+	return nil
+}
@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrementalAlterConfigsResponse(t *testing.T) {
+	req := require.New(t)
+	msg := "invalid config"
+	exp := &IncrementalAlterConfigsResponse{
+		ThrottleTime: time.Millisecond,
+		Resources: []AlterConfigResourceResponse{
+			{Type: 2, Name: "the-topic"},
+			{ErrorCode: ErrInvalidConfig.Code(), ErrorMessage: &msg, Type: 4, Name: "1"},
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act IncrementalAlterConfigsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_InitProducerId
+
+type InitProducerIDRequest struct {
+	APIVersion int16
+
+	// TransactionalID is nil for idempotent producers that aren't transactional.
+	TransactionalID    *string
+	TransactionTimeout time.Duration
+	// ProducerID and ProducerEpoch are the producer's current id and epoch, or -1 if it has
+	// none. They're sent from version 3.
+	ProducerID    int64
+	ProducerEpoch int16
+}
+
+func (r *InitProducerIDRequest) Encode(e PacketEncoder) (err error) {
+	flexible := r.APIVersion >= 2
+	if flexible {
+		err = e.PutCompactNullableString(r.TransactionalID)
+	} else {
+		err = e.PutNullableString(r.TransactionalID)
+	}
+	if err != nil {
+		return err
+	}
+	e.PutInt32(int32(r.TransactionTimeout / time.Millisecond))
+	if r.APIVersion >= 3 {
+		e.PutInt64(r.ProducerID)
+		e.PutInt16(r.ProducerEpoch)
+	}
+	if flexible {
+		e.PutEmptyTaggedFields()
+	}
+	return nil
+}
+
+func (r *InitProducerIDRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	flexible := version >= 2
+	if flexible {
+		r.TransactionalID, err = d.CompactNullableString()
+	} else {
+		r.TransactionalID, err = d.NullableString()
+	}
+	if err != nil {
+		return err
+	}
+	timeout, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.TransactionTimeout = time.Duration(timeout) * time.Millisecond
+	r.ProducerID, r.ProducerEpoch = -1, -1
+	if version >= 3 {
+		if r.ProducerID, err = d.Int64(); err != nil {
+			return err
+		}
+		if r.ProducerEpoch, err = d.Int16(); err != nil {
+			return err
+		}
+	}
+	if flexible {
+		return d.TaggedFields()
+	}
+	return nil
+}
+
+func (r *InitProducerIDRequest) Key() int16 {
+	return InitProducerIDKey
+}
+
+func (r *InitProducerIDRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *InitProducerIDRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	if r.TransactionalID != nil {
+		e.AddString("transactional id", *r.TransactionalID)
+	}
+	e.AddInt64("producer id", r.ProducerID)
+	return nil
+}
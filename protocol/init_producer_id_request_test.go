@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitProducerIDRequest(t *testing.T) {
+	transactionalID := "the-txn"
+	for _, exp := range []*InitProducerIDRequest{
+		{APIVersion: 0, TransactionTimeout: time.Minute, ProducerID: -1, ProducerEpoch: -1},
+		{APIVersion: 1, TransactionalID: &transactionalID, TransactionTimeout: time.Minute, ProducerID: -1, ProducerEpoch: -1},
+		{APIVersion: 2, TransactionalID: &transactionalID, TransactionTimeout: time.Minute, ProducerID: -1, ProducerEpoch: -1},
+		{APIVersion: 3, TransactionTimeout: time.Minute, ProducerID: 7, ProducerEpoch: 2},
+	} {
+		req := require.New(t)
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act InitProducerIDRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitProducerIDResponse(t *testing.T) {
+	for _, version := range []int16{0, 2} {
+		req := require.New(t)
+		exp := &InitProducerIDResponse{
+			APIVersion:    version,
+			ThrottleTime:  10 * time.Millisecond,
+			ProducerID:    1000,
+			ProducerEpoch: 0,
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act InitProducerIDResponse
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
@@ -16,8 +16,11 @@ type JoinGroupRequest struct {
 	SessionTimeout   int32
 	RebalanceTimeout int32
 	MemberID         string
-	ProtocolType     string
-	GroupProtocols   []*GroupProtocol
+	// GroupInstanceID is the member's group.instance.id if it's a static member, sent from
+	// version 5.
+	GroupInstanceID *string
+	ProtocolType    string
+	GroupProtocols  []*GroupProtocol
 }
 
 func (r *JoinGroupRequest) Encode(e PacketEncoder) (err error) {
@@ -31,9 +34,17 @@ func (r *JoinGroupRequest) Encode(e PacketEncoder) (err error) {
 	if err = e.PutString(r.MemberID); err != nil {
 		return err
 	}
+	if r.APIVersion >= 5 {
+		if err = e.PutNullableString(r.GroupInstanceID); err != nil {
+			return err
+		}
+	}
 	if err = e.PutString(r.ProtocolType); err != nil {
 		return err
 	}
+	if err = e.PutArrayLength(len(r.GroupProtocols)); err != nil {
+		return err
+	}
 	for _, groupProtocol := range r.GroupProtocols {
 		if err = e.PutString(groupProtocol.ProtocolName); err != nil {
 			return err
@@ -62,6 +73,11 @@ func (r *JoinGroupRequest) Decode(d PacketDecoder, version int16) (err error) {
 	if r.MemberID, err = d.String(); err != nil {
 		return err
 	}
+	if r.APIVersion >= 5 {
+		if r.GroupInstanceID, err = d.NullableString(); err != nil {
+			return err
+		}
+	}
 	if r.ProtocolType, err = d.String(); err != nil {
 		return err
 	}
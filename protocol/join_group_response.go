@@ -7,8 +7,10 @@ import (
 import "time"
 
 type Member struct {
-	MemberID       string
-	MemberMetadata []byte
+	MemberID string
+	// GroupInstanceID is sent from version 5.
+	GroupInstanceID *string
+	MemberMetadata  []byte
 }
 
 type JoinGroupResponse struct {
@@ -24,7 +26,7 @@ type JoinGroupResponse struct {
 }
 
 func (r *JoinGroupResponse) Encode(e PacketEncoder) (err error) {
-	if r.APIVersion >= 1 {
+	if r.APIVersion >= 2 {
 		e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
 	}
 	e.PutInt16(r.ErrorCode)
@@ -45,6 +47,11 @@ func (r *JoinGroupResponse) Encode(e PacketEncoder) (err error) {
 		if err = e.PutString(member.MemberID); err != nil {
 			return err
 		}
+		if r.APIVersion >= 5 {
+			if err = e.PutNullableString(member.GroupInstanceID); err != nil {
+				return err
+			}
+		}
 		if err = e.PutBytes(member.MemberMetadata); err != nil {
 			return err
 		}
@@ -88,11 +95,17 @@ func (r *JoinGroupResponse) Decode(d PacketDecoder, version int16) (err error) {
 		if err != nil {
 			return err
 		}
+		var instanceID *string
+		if version >= 5 {
+			if instanceID, err = d.NullableString(); err != nil {
+				return err
+			}
+		}
 		metadata, err := d.Bytes()
 		if err != nil {
 			return err
 		}
-		r.Members[i] = Member{MemberID: id, MemberMetadata: metadata}
+		r.Members[i] = Member{MemberID: id, GroupInstanceID: instanceID, MemberMetadata: metadata}
 	}
 	return nil
 }
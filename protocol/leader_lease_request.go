@@ -0,0 +1,35 @@
+package protocol
+
+import "go.uber.org/zap/zapcore"
+
+// LeaderLeaseRequest is jocko's own request, from brokers to the controller, renewing the lease
+// a broker needs to serve the partitions it leads.
+type LeaderLeaseRequest struct {
+	APIVersion int16
+
+	BrokerID int32
+}
+
+func (r *LeaderLeaseRequest) Encode(e PacketEncoder) error {
+	e.PutInt32(r.BrokerID)
+	return nil
+}
+
+func (r *LeaderLeaseRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	r.BrokerID, err = d.Int32()
+	return err
+}
+
+func (r *LeaderLeaseRequest) Key() int16 {
+	return LeaderLeaseKey
+}
+
+func (r *LeaderLeaseRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *LeaderLeaseRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt32("broker id", r.BrokerID)
+	return nil
+}
@@ -0,0 +1,18 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderLeaseRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &LeaderLeaseRequest{BrokerID: 3}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act LeaderLeaseRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
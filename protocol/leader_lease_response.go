@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// LeaderLeaseResponse grants the broker a lease on the partitions the controller has it leading,
+// in their leader epochs, for the duration from when the broker sent the request.
+type LeaderLeaseResponse struct {
+	APIVersion int16
+
+	ErrorCode     int16
+	LeaseDuration time.Duration
+	Partitions    []LeasedPartition
+}
+
+// LeasedPartition is a partition the lease covers and the leader epoch it covers it in.
+type LeasedPartition struct {
+	Topic       string
+	Partition   int32
+	LeaderEpoch int32
+}
+
+func (r *LeaderLeaseResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt16(r.ErrorCode)
+	e.PutInt64(int64(r.LeaseDuration / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Partitions)); err != nil {
+		return err
+	}
+	for _, p := range r.Partitions {
+		if err = e.PutString(p.Topic); err != nil {
+			return err
+		}
+		e.PutInt32(p.Partition)
+		e.PutInt32(p.LeaderEpoch)
+	}
+	return nil
+}
+
+func (r *LeaderLeaseResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	duration, err := d.Int64()
+	if err != nil {
+		return err
+	}
+	r.LeaseDuration = time.Duration(duration) * time.Millisecond
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Partitions = make([]LeasedPartition, n)
+	}
+	for i := range r.Partitions {
+		p := LeasedPartition{}
+		if p.Topic, err = d.String(); err != nil {
+			return err
+		}
+		if p.Partition, err = d.Int32(); err != nil {
+			return err
+		}
+		if p.LeaderEpoch, err = d.Int32(); err != nil {
+			return err
+		}
+		r.Partitions[i] = p
+	}
+	return nil
+}
+
+func (r *LeaderLeaseResponse) Key() int16 {
+	return LeaderLeaseKey
+}
+
+func (r *LeaderLeaseResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *LeaderLeaseResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddDuration("lease duration", r.LeaseDuration)
+	e.AddInt("partitions", len(r.Partitions))
+	return nil
+}
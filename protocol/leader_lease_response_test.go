@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderLeaseResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &LeaderLeaseResponse{
+		ErrorCode:     ErrNone.Code(),
+		LeaseDuration: 10 * time.Second,
+		Partitions: []LeasedPartition{
+			{Topic: "the-topic", Partition: 0, LeaderEpoch: 2},
+			{Topic: "the-topic", Partition: 3, LeaderEpoch: 5},
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act LeaderLeaseResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -4,18 +4,42 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// LeaveGroupMember is a member leaving the group, or being removed from it, in a version 3
+// request.
+type LeaveGroupMember struct {
+	MemberID        string
+	GroupInstanceID *string
+}
+
 type LeaveGroupRequest struct {
 	APIVersion int16
 
-	GroupID  string
+	GroupID string
+	// MemberID is the member leaving the group up to version 2.
 	MemberID string
+	// Members are the members leaving the group from version 3.
+	Members []LeaveGroupMember
 }
 
 func (r *LeaveGroupRequest) Encode(e PacketEncoder) error {
 	if err := e.PutString(r.GroupID); err != nil {
 		return err
 	}
-	return e.PutString(r.MemberID)
+	if r.APIVersion < 3 {
+		return e.PutString(r.MemberID)
+	}
+	if err := e.PutArrayLength(len(r.Members)); err != nil {
+		return err
+	}
+	for _, m := range r.Members {
+		if err := e.PutString(m.MemberID); err != nil {
+			return err
+		}
+		if err := e.PutNullableString(m.GroupInstanceID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *LeaveGroupRequest) Decode(d PacketDecoder, version int16) (err error) {
@@ -23,8 +47,24 @@ func (r *LeaveGroupRequest) Decode(d PacketDecoder, version int16) (err error) {
 	if r.GroupID, err = d.String(); err != nil {
 		return err
 	}
-	r.MemberID, err = d.String()
-	return err
+	if version < 3 {
+		r.MemberID, err = d.String()
+		return err
+	}
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Members = make([]LeaveGroupMember, n)
+	for i := range r.Members {
+		if r.Members[i].MemberID, err = d.String(); err != nil {
+			return err
+		}
+		if r.Members[i].GroupInstanceID, err = d.NullableString(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *LeaveGroupRequest) Key() int16 {
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaveGroupRequest(t *testing.T) {
+	req := require.New(t)
+	instanceID := "instance"
+	for _, exp := range []*LeaveGroupRequest{
+		{APIVersion: 1, GroupID: "group", MemberID: "member"},
+		{APIVersion: 3, GroupID: "group", Members: []LeaveGroupMember{{MemberID: "member"}, {GroupInstanceID: &instanceID}}},
+	} {
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act LeaveGroupRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
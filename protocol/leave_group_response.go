@@ -6,11 +6,20 @@ import (
 
 import "time"
 
+// LeaveGroupMemberResponse is the result of a member leaving the group in a version 3 request.
+type LeaveGroupMemberResponse struct {
+	MemberID        string
+	GroupInstanceID *string
+	ErrorCode       int16
+}
+
 type LeaveGroupResponse struct {
 	APIVersion int16
 
 	ThrottleTime time.Duration
 	ErrorCode    int16
+	// Members are sent from version 3.
+	Members []LeaveGroupMemberResponse
 }
 
 func (r *LeaveGroupResponse) Encode(e PacketEncoder) error {
@@ -18,6 +27,21 @@ func (r *LeaveGroupResponse) Encode(e PacketEncoder) error {
 		e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
 	}
 	e.PutInt16(r.ErrorCode)
+	if r.APIVersion < 3 {
+		return nil
+	}
+	if err := e.PutArrayLength(len(r.Members)); err != nil {
+		return err
+	}
+	for _, m := range r.Members {
+		if err := e.PutString(m.MemberID); err != nil {
+			return err
+		}
+		if err := e.PutNullableString(m.GroupInstanceID); err != nil {
+			return err
+		}
+		e.PutInt16(m.ErrorCode)
+	}
 	return nil
 }
 
@@ -30,8 +54,29 @@ func (r *LeaveGroupResponse) Decode(d PacketDecoder, version int16) (err error)
 		}
 		r.ThrottleTime = time.Duration(throttle) * time.Millisecond
 	}
-	r.ErrorCode, err = d.Int16()
-	return err
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	if version < 3 {
+		return nil
+	}
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Members = make([]LeaveGroupMemberResponse, n)
+	for i := range r.Members {
+		if r.Members[i].MemberID, err = d.String(); err != nil {
+			return err
+		}
+		if r.Members[i].GroupInstanceID, err = d.NullableString(); err != nil {
+			return err
+		}
+		if r.Members[i].ErrorCode, err = d.Int16(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *LeaveGroupResponse) Key() int16 {
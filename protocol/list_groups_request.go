@@ -4,16 +4,38 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// https://kafka.apache.org/protocol#The_Messages_ListGroups
+
 type ListGroupsRequest struct {
 	APIVersion int16
+
+	// StatesFilter lists only groups in these states, empty lists every group. It's sent from
+	// version 4.
+	StatesFilter []string
 }
 
 func (r *ListGroupsRequest) Encode(e PacketEncoder) error {
+	if r.APIVersion >= 4 {
+		if err := e.PutCompactStringArray(r.StatesFilter); err != nil {
+			return err
+		}
+	}
+	if r.APIVersion >= 3 {
+		e.PutEmptyTaggedFields()
+	}
 	return nil
 }
 
 func (r *ListGroupsRequest) Decode(d PacketDecoder, version int16) (err error) {
 	r.APIVersion = version
+	if version >= 4 {
+		if r.StatesFilter, err = d.CompactStringArray(); err != nil {
+			return err
+		}
+	}
+	if version >= 3 {
+		return d.TaggedFields()
+	}
 	return nil
 }
 
@@ -26,5 +48,6 @@ func (r *ListGroupsRequest) Version() int16 {
 }
 
 func (r *ListGroupsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddArray("states filter", Strings(r.StatesFilter))
 	return nil
 }
@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListGroupsRequest(t *testing.T) {
+	req := require.New(t)
+	for _, exp := range []*ListGroupsRequest{
+		{APIVersion: 0},
+		{APIVersion: 3},
+		{APIVersion: 4, StatesFilter: []string{"Stable", "Empty"}},
+	} {
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act ListGroupsRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
+
+func TestListGroupsRequest_FlexibleHeader(t *testing.T) {
+	req := require.New(t)
+	exp := &ListGroupsRequest{APIVersion: 4, StatesFilter: []string{"Stable"}}
+	b, err := Encode(&Request{CorrelationID: 1, ClientID: "the-client", Body: exp})
+	req.NoError(err)
+
+	// flexible versions' headers end with tagged fields, which must be read before the body
+	d := NewDecoder(b)
+	header := new(RequestHeader)
+	req.NoError(header.Decode(d))
+	req.Equal("the-client", header.ClientID)
+	var act ListGroupsRequest
+	req.NoError(act.Decode(d, header.APIVersion))
+	req.Equal(exp, &act)
+	req.Equal(0, d.remaining())
+}
@@ -9,6 +9,8 @@ import "time"
 type ListGroup struct {
 	GroupID      string
 	ProtocolType string
+	// GroupState is sent from version 4.
+	GroupState string
 }
 
 type ListGroupsResponse struct {
@@ -24,6 +26,27 @@ func (r *ListGroupsResponse) Encode(e PacketEncoder) error {
 		e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
 	}
 	e.PutInt16(r.ErrorCode)
+	if r.APIVersion >= 3 {
+		if err := e.PutCompactArrayLength(len(r.Groups)); err != nil {
+			return err
+		}
+		for _, group := range r.Groups {
+			if err := e.PutCompactString(group.GroupID); err != nil {
+				return err
+			}
+			if err := e.PutCompactString(group.ProtocolType); err != nil {
+				return err
+			}
+			if r.APIVersion >= 4 {
+				if err := e.PutCompactString(group.GroupState); err != nil {
+					return err
+				}
+			}
+			e.PutEmptyTaggedFields()
+		}
+		e.PutEmptyTaggedFields()
+		return nil
+	}
 	if err := e.PutArrayLength(len(r.Groups)); err != nil {
 		return err
 	}
@@ -50,6 +73,34 @@ func (r *ListGroupsResponse) Decode(d PacketDecoder, version int16) (err error)
 	if r.ErrorCode, err = d.Int16(); err != nil {
 		return err
 	}
+	if version >= 3 {
+		groupCount, err := d.CompactArrayLength()
+		if err != nil {
+			return err
+		}
+		if groupCount > 0 {
+			r.Groups = make([]ListGroup, groupCount)
+		}
+		for i := range r.Groups {
+			group := ListGroup{}
+			if group.GroupID, err = d.CompactString(); err != nil {
+				return err
+			}
+			if group.ProtocolType, err = d.CompactString(); err != nil {
+				return err
+			}
+			if version >= 4 {
+				if group.GroupState, err = d.CompactString(); err != nil {
+					return err
+				}
+			}
+			if err = d.TaggedFields(); err != nil {
+				return err
+			}
+			r.Groups[i] = group
+		}
+		return d.TaggedFields()
+	}
 	groupCount, err := d.ArrayLength()
 	if err != nil {
 		return err
@@ -70,7 +121,7 @@ func (r *ListGroupsResponse) Decode(d PacketDecoder, version int16) (err error)
 }
 
 func (r *ListGroupsResponse) Key() int16 {
-	return 16
+	return ListGroupsKey
 }
 
 func (r *ListGroupsResponse) Version() int16 {
@@ -78,5 +129,7 @@ func (r *ListGroupsResponse) Version() int16 {
 }
 
 func (r *ListGroupsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddInt("groups", len(r.Groups))
 	return nil
 }
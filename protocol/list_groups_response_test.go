@@ -0,0 +1,34 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListGroupsResponse(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{0, 1, 3, 4} {
+		exp := &ListGroupsResponse{
+			APIVersion: version,
+			Groups: []ListGroup{
+				{GroupID: "the-group", ProtocolType: "consumer"},
+				{GroupID: "another-group", ProtocolType: "connect"},
+			},
+		}
+		if version >= 1 {
+			exp.ThrottleTime = time.Millisecond
+		}
+		if version >= 4 {
+			exp.Groups[0].GroupState = "Stable"
+			exp.Groups[1].GroupState = "Empty"
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act ListGroupsResponse
+		err = Decode(b, &act, version)
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_ListPartitionReassignments
+
+type ListPartitionReassignmentsRequest struct {
+	APIVersion int16
+
+	Timeout time.Duration
+	// Topics is nil to list every ongoing reassignment.
+	Topics []ListPartitionReassignmentsTopic
+}
+
+type ListPartitionReassignmentsTopic struct {
+	Topic      string
+	Partitions []int32
+}
+
+func (r *ListPartitionReassignmentsRequest) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.Timeout / time.Millisecond))
+	if r.Topics == nil {
+		err = e.PutCompactArrayLength(-1)
+	} else {
+		err = e.PutCompactArrayLength(len(r.Topics))
+	}
+	if err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutCompactString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutCompactInt32Array(t.Partitions); err != nil {
+			return err
+		}
+		e.PutEmptyTaggedFields()
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *ListPartitionReassignmentsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	timeout, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.Timeout = time.Duration(timeout) * time.Millisecond
+	n, err := d.CompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n >= 0 {
+		r.Topics = make([]ListPartitionReassignmentsTopic, n)
+	}
+	for i := range r.Topics {
+		t := ListPartitionReassignmentsTopic{}
+		if t.Topic, err = d.CompactString(); err != nil {
+			return err
+		}
+		if t.Partitions, err = d.CompactInt32Array(); err != nil {
+			return err
+		}
+		if err = d.TaggedFields(); err != nil {
+			return err
+		}
+		r.Topics[i] = t
+	}
+	return d.TaggedFields()
+}
+
+func (r *ListPartitionReassignmentsRequest) Key() int16 {
+	return ListPartitionReassignmentsKey
+}
+
+func (r *ListPartitionReassignmentsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *ListPartitionReassignmentsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddDuration("timeout", r.Timeout)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPartitionReassignmentsRequest(t *testing.T) {
+	req := require.New(t)
+	for _, exp := range []*ListPartitionReassignmentsRequest{
+		{Timeout: time.Minute},
+		{Timeout: time.Minute, Topics: []ListPartitionReassignmentsTopic{{Topic: "the-topic", Partitions: []int32{0, 1}}}},
+	} {
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act ListPartitionReassignmentsRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
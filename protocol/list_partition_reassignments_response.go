@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type ListPartitionReassignmentsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	ErrorCode    int16
+	ErrorMessage *string
+	Topics       []ListPartitionReassignmentsTopicResponse
+}
+
+type ListPartitionReassignmentsTopicResponse struct {
+	Topic      string
+	Partitions []OngoingPartitionReassignment
+}
+
+// OngoingPartitionReassignment is a partition being reassigned. Replicas are all the replicas
+// it's assigned while it's moved, the adding replicas are those it's moving to and the removing
+// replicas those it's moving off.
+type OngoingPartitionReassignment struct {
+	Partition        int32
+	Replicas         []int32
+	AddingReplicas   []int32
+	RemovingReplicas []int32
+}
+
+func (r *ListPartitionReassignmentsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	e.PutInt16(r.ErrorCode)
+	if err = e.PutCompactNullableString(r.ErrorMessage); err != nil {
+		return err
+	}
+	if err = e.PutCompactArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutCompactString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutCompactArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			if err = e.PutCompactInt32Array(p.Replicas); err != nil {
+				return err
+			}
+			if err = e.PutCompactInt32Array(p.AddingReplicas); err != nil {
+				return err
+			}
+			if err = e.PutCompactInt32Array(p.RemovingReplicas); err != nil {
+				return err
+			}
+			e.PutEmptyTaggedFields()
+		}
+		e.PutEmptyTaggedFields()
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *ListPartitionReassignmentsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	if r.ErrorMessage, err = d.CompactNullableString(); err != nil {
+		return err
+	}
+	n, err := d.CompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Topics = make([]ListPartitionReassignmentsTopicResponse, n)
+	}
+	for i := range r.Topics {
+		t := ListPartitionReassignmentsTopicResponse{}
+		if t.Topic, err = d.CompactString(); err != nil {
+			return err
+		}
+		pn, err := d.CompactArrayLength()
+		if err != nil {
+			return err
+		}
+		if pn > 0 {
+			t.Partitions = make([]OngoingPartitionReassignment, pn)
+		}
+		for j := range t.Partitions {
+			p := OngoingPartitionReassignment{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.Replicas, err = d.CompactInt32Array(); err != nil {
+				return err
+			}
+			if p.AddingReplicas, err = d.CompactInt32Array(); err != nil {
+				return err
+			}
+			if p.RemovingReplicas, err = d.CompactInt32Array(); err != nil {
+				return err
+			}
+			if err = d.TaggedFields(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		if err = d.TaggedFields(); err != nil {
+			return err
+		}
+		r.Topics[i] = t
+	}
+	return d.TaggedFields()
+}
+
+func (r *ListPartitionReassignmentsResponse) Key() int16 {
+	return ListPartitionReassignmentsKey
+}
+
+func (r *ListPartitionReassignmentsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *ListPartitionReassignmentsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
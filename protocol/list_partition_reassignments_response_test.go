@@ -0,0 +1,27 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPartitionReassignmentsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &ListPartitionReassignmentsResponse{
+		ThrottleTime: time.Millisecond,
+		Topics: []ListPartitionReassignmentsTopicResponse{{
+			Topic: "the-topic",
+			Partitions: []OngoingPartitionReassignment{
+				{Partition: 0, Replicas: []int32{2, 3, 1}, AddingReplicas: []int32{2, 3}, RemovingReplicas: []int32{1}},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act ListPartitionReassignmentsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_ListTransactions
+
+// Transaction states, as listed and described by the transactions admin APIs.
+const (
+	TransactionStateEmpty             = "Empty"
+	TransactionStateOngoing           = "Ongoing"
+	TransactionStatePrepareCommit     = "PrepareCommit"
+	TransactionStatePrepareAbort      = "PrepareAbort"
+	TransactionStateCompleteCommit    = "CompleteCommit"
+	TransactionStateCompleteAbort     = "CompleteAbort"
+	TransactionStateDead              = "Dead"
+	TransactionStatePrepareEpochFence = "PrepareEpochFence"
+)
+
+// TransactionStates are the states transactions can be in.
+var TransactionStates = []string{
+	TransactionStateEmpty,
+	TransactionStateOngoing,
+	TransactionStatePrepareCommit,
+	TransactionStatePrepareAbort,
+	TransactionStateCompleteCommit,
+	TransactionStateCompleteAbort,
+	TransactionStateDead,
+	TransactionStatePrepareEpochFence,
+}
+
+type ListTransactionsRequest struct {
+	APIVersion int16
+
+	// StateFilters lists only transactions in these states, empty lists every state.
+	StateFilters []string
+	// ProducerIDFilters lists only transactions of these producers, empty lists every producer.
+	ProducerIDFilters []int64
+}
+
+func (r *ListTransactionsRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutCompactStringArray(r.StateFilters); err != nil {
+		return err
+	}
+	if err = e.PutCompactInt64Array(r.ProducerIDFilters); err != nil {
+		return err
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *ListTransactionsRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.StateFilters, err = d.CompactStringArray(); err != nil {
+		return err
+	}
+	if r.ProducerIDFilters, err = d.CompactInt64Array(); err != nil {
+		return err
+	}
+	return d.TaggedFields()
+}
+
+func (r *ListTransactionsRequest) Key() int16 {
+	return ListTransactionsKey
+}
+
+func (r *ListTransactionsRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *ListTransactionsRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddArray("state filters", Strings(r.StateFilters))
+	e.AddInt("producer id filters", len(r.ProducerIDFilters))
+	return nil
+}
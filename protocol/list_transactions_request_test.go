@@ -0,0 +1,21 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListTransactionsRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &ListTransactionsRequest{
+		StateFilters:      []string{TransactionStateOngoing, TransactionStatePrepareCommit},
+		ProducerIDFilters: []int64{1, 2},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act ListTransactionsRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type ListTransactionsResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	ErrorCode    int16
+	// UnknownStateFilters are the state filters that aren't transaction states.
+	UnknownStateFilters []string
+	TransactionStates   []ListTransactionsState
+}
+
+type ListTransactionsState struct {
+	TransactionalID  string
+	ProducerID       int64
+	TransactionState string
+}
+
+func (r *ListTransactionsResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	e.PutInt16(r.ErrorCode)
+	if err = e.PutCompactStringArray(r.UnknownStateFilters); err != nil {
+		return err
+	}
+	if err = e.PutCompactArrayLength(len(r.TransactionStates)); err != nil {
+		return err
+	}
+	for _, s := range r.TransactionStates {
+		if err = e.PutCompactString(s.TransactionalID); err != nil {
+			return err
+		}
+		e.PutInt64(s.ProducerID)
+		if err = e.PutCompactString(s.TransactionState); err != nil {
+			return err
+		}
+		e.PutEmptyTaggedFields()
+	}
+	e.PutEmptyTaggedFields()
+	return nil
+}
+
+func (r *ListTransactionsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	if r.UnknownStateFilters, err = d.CompactStringArray(); err != nil {
+		return err
+	}
+	n, err := d.CompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.TransactionStates = make([]ListTransactionsState, n)
+	}
+	for i := range r.TransactionStates {
+		s := ListTransactionsState{}
+		if s.TransactionalID, err = d.CompactString(); err != nil {
+			return err
+		}
+		if s.ProducerID, err = d.Int64(); err != nil {
+			return err
+		}
+		if s.TransactionState, err = d.CompactString(); err != nil {
+			return err
+		}
+		if err = d.TaggedFields(); err != nil {
+			return err
+		}
+		r.TransactionStates[i] = s
+	}
+	return d.TaggedFields()
+}
+
+func (r *ListTransactionsResponse) Key() int16 {
+	return ListTransactionsKey
+}
+
+func (r *ListTransactionsResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *ListTransactionsResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddInt("transaction states", len(r.TransactionStates))
+	return nil
+}
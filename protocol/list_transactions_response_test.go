@@ -0,0 +1,25 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListTransactionsResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &ListTransactionsResponse{
+		ThrottleTime:        time.Millisecond,
+		UnknownStateFilters: []string{"Stuck"},
+		TransactionStates: []ListTransactionsState{
+			{TransactionalID: "the-txn", ProducerID: 1, TransactionState: TransactionStateOngoing},
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act ListTransactionsResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -1,5 +1,10 @@
 package protocol
 
+import (
+	"hash/crc32"
+	"time"
+)
+
 type MessageSet struct {
 	Offset                  int64
 	Size                    int32
@@ -42,3 +47,342 @@ func (ms *MessageSet) Decode(d PacketDecoder) error {
 	}
 	return nil
 }
+
+const (
+	// offset, size, then the crc and magic byte for v0/v1 message sets, or the partition
+	// leader epoch and magic byte for v2 record batches. Either way the magic byte is at 16.
+	recordSetMagicOffset = 16
+	// v2 record batches put the crc after the magic byte and checksum from the attributes on.
+	recordBatchCRCOffset = 17
+	// v2 record batches put the partition leader epoch before the magic byte, outside the crc.
+	recordBatchPartitionLeaderEpochOffset = 12
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ValidateRecordSet checks the sizes and CRCs of the message sets or record batches in b
+// without decoding their records, so the bytes can be appended to the log as they are.
+func ValidateRecordSet(b []byte) Error {
+	for len(b) > 0 {
+		if len(b) < recordSetMagicOffset+1 {
+			return ErrCorruptMessage
+		}
+		size := int(int32(Encoding.Uint32(b[8:])))
+		if size < recordSetMagicOffset+1-12 || size > len(b)-12 {
+			return ErrCorruptMessage
+		}
+		entry := b[:12+size]
+		b = b[12+size:]
+		if magic := int8(entry[recordSetMagicOffset]); magic < 2 {
+			if err := validateMessages(entry[12:]); err != ErrNone {
+				return err
+			}
+			continue
+		}
+		if len(entry) < recordBatchCRCOffset+4 {
+			return ErrCorruptMessage
+		}
+		if crc32.Checksum(entry[recordBatchCRCOffset+4:], castagnoliTable) != Encoding.Uint32(entry[recordBatchCRCOffset:]) {
+			return ErrCorruptMessage
+		}
+	}
+	return ErrNone
+}
+
+// RecordSetEntry is a v0/v1 message or v2 record batch at the top level of a record set.
+type RecordSetEntry struct {
+	// Offset is the base offset of record batches and the offset of messages, which for
+	// compressed messages is the offset of the last message they wrap.
+	Offset int64
+	Magic  int8
+	CRC    uint32
+	// Bytes is the entry in the record set.
+	Bytes []byte
+}
+
+// RecordSetEntries returns the entries of the record set in b, without checking their CRCs or
+// decoding their records. A partial trailing entry, like fetches can end with, is left out.
+func RecordSetEntries(b []byte) []RecordSetEntry {
+	var entries []RecordSetEntry
+	for len(b) >= recordBatchCRCOffset+4 {
+		size := int(int32(Encoding.Uint32(b[8:])))
+		if size < recordBatchCRCOffset+4-12 || size > len(b)-12 {
+			break
+		}
+		entry := RecordSetEntry{
+			Offset: int64(Encoding.Uint64(b)),
+			Magic:  int8(b[recordSetMagicOffset]),
+			Bytes:  b[:12+size],
+		}
+		if entry.Magic < 2 {
+			entry.CRC = Encoding.Uint32(b[12:])
+		} else {
+			entry.CRC = Encoding.Uint32(b[recordBatchCRCOffset:])
+		}
+		entries = append(entries, entry)
+		b = b[12+size:]
+	}
+	return entries
+}
+
+// validateMessages checks the CRC of each v0/v1 message in b, and the messages wrapped in those
+// compressed with a supported codec.
+func validateMessages(b []byte) Error {
+	for len(b) > 0 {
+		// crc, magic byte, attributes
+		n := 6
+		if len(b) < n {
+			return ErrCorruptMessage
+		}
+		if int8(b[4]) > 0 {
+			// timestamp
+			n += 8
+		}
+		// key then value, -1 lengths are nulls
+		var value []byte
+		for i := 0; i < 2; i++ {
+			if len(b) < n+4 {
+				return ErrCorruptMessage
+			}
+			l := int(int32(Encoding.Uint32(b[n:])))
+			n += 4
+			if l < -1 || l > len(b)-n {
+				return ErrCorruptMessage
+			}
+			if l > 0 {
+				value = b[n : n+l]
+				n += l
+			}
+		}
+		if crc32.ChecksumIEEE(b[4:n]) != Encoding.Uint32(b) {
+			return ErrCorruptMessage
+		}
+		if err := validateWrappedMessages(int8(b[4]), int8(b[5]), value); err != ErrNone {
+			return err
+		}
+		b = b[n:]
+	}
+	return ErrNone
+}
+
+// validateWrappedMessages checks the messages wrapped in a compressed v0/v1 message's value.
+// Those compressed with codecs that aren't supported are only checked by their wrapper's CRC.
+func validateWrappedMessages(magic, attributes int8, value []byte) Error {
+	c := attributes & recordBatchCompressionMask
+	codec, ok := codecs[c]
+	if c == CompressionNone || !ok {
+		return ErrNone
+	}
+	decompress := codec.decompress
+	if c == CompressionLZ4 && magic == 0 {
+		decompress = lz4DecodeLegacy
+	}
+	wrapped, err := decompress(value)
+	if err != nil {
+		return ErrCorruptMessage.WithErr(err)
+	}
+	return ValidateRecordSet(wrapped)
+}
+
+// Offsets of the producer fields in v2 record batch headers.
+const (
+	recordBatchAttributesOffset      = 21
+	recordBatchLastOffsetDeltaOffset = 23
+	recordBatchMaxTimestampOffset    = 35
+	recordBatchProducerIDOffset      = 43
+	recordBatchProducerEpochOffset   = 51
+	recordBatchBaseSequenceOffset    = 53
+	recordBatchHeaderLen             = 61
+
+	// timestampTypeAttribute is set in the attributes of messages and record batches whose
+	// timestamps are the time the broker appended them.
+	timestampTypeAttribute = 0x08
+)
+
+// RecordBatchProducer is the producer fields of a v2 record batch's header.
+type RecordBatchProducer struct {
+	BaseOffset      int64
+	ProducerID      int64
+	ProducerEpoch   int16
+	BaseSequence    int32
+	LastOffsetDelta int32
+	MaxTimestamp    int64
+}
+
+// LastSequence returns the sequence number of the batch's last record.
+func (p RecordBatchProducer) LastSequence() int32 {
+	return p.BaseSequence + p.LastOffsetDelta
+}
+
+// RecordBatchProducers returns the producer fields of the v2 record batches in b that were
+// written by idempotent producers, those with producer ids. b should have been validated.
+func RecordBatchProducers(b []byte) []RecordBatchProducer {
+	var producers []RecordBatchProducer
+	for len(b) >= recordBatchHeaderLen {
+		size := int(int32(Encoding.Uint32(b[8:])))
+		if size < 0 || size > len(b)-12 {
+			break
+		}
+		entry := b[:12+size]
+		b = b[12+size:]
+		if int8(entry[recordSetMagicOffset]) < 2 || len(entry) < recordBatchHeaderLen {
+			continue
+		}
+		// control batches, like transaction markers, carry no producer sequences
+		if Encoding.Uint16(entry[recordBatchAttributesOffset:])&RecordBatchControl != 0 {
+			continue
+		}
+		p := RecordBatchProducer{
+			BaseOffset:      int64(Encoding.Uint64(entry)),
+			ProducerID:      int64(Encoding.Uint64(entry[recordBatchProducerIDOffset:])),
+			ProducerEpoch:   int16(Encoding.Uint16(entry[recordBatchProducerEpochOffset:])),
+			BaseSequence:    int32(Encoding.Uint32(entry[recordBatchBaseSequenceOffset:])),
+			LastOffsetDelta: int32(Encoding.Uint32(entry[recordBatchLastOffsetDeltaOffset:])),
+			MaxTimestamp:    int64(Encoding.Uint64(entry[recordBatchMaxTimestampOffset:])),
+		}
+		if p.ProducerID < 0 {
+			continue
+		}
+		producers = append(producers, p)
+	}
+	return producers
+}
+
+// SetLogAppendTime stamps the message sets and record batches in b with t as their timestamp
+// and marks them as using the log append time, updating their CRCs. v0 messages have no
+// timestamps and are left alone. b should have been validated.
+func SetLogAppendTime(b []byte, t time.Time) {
+	millis := t.UnixNano() / int64(time.Millisecond)
+	for len(b) >= recordSetMagicOffset+1 {
+		size := int(int32(Encoding.Uint32(b[8:])))
+		if size < 0 || size > len(b)-12 {
+			return
+		}
+		entry := b[:12+size]
+		b = b[12+size:]
+		switch magic := int8(entry[recordSetMagicOffset]); {
+		case magic == 1 && len(entry) >= 12+14:
+			// crc, magic byte, attributes, then the timestamp
+			m := entry[12:]
+			m[5] |= timestampTypeAttribute
+			Encoding.PutUint64(m[6:], uint64(millis))
+			Encoding.PutUint32(m, crc32.ChecksumIEEE(m[4:]))
+		case magic >= 2 && len(entry) >= recordBatchHeaderLen:
+			attributes := Encoding.Uint16(entry[recordBatchAttributesOffset:])
+			Encoding.PutUint16(entry[recordBatchAttributesOffset:], attributes|timestampTypeAttribute)
+			Encoding.PutUint64(entry[recordBatchMaxTimestampOffset:], uint64(millis))
+			Encoding.PutUint32(entry[recordBatchCRCOffset:], crc32.Checksum(entry[recordBatchCRCOffset+4:], castagnoliTable))
+		}
+	}
+}
+
+// Recompression is how record sets are re-encoded with a topic's codec.
+type Recompression struct {
+	Codec int8
+	// Level is the codec's compression level, 0 for its default. Only gzip and zstd have levels.
+	Level int
+	// KeepCompressed keeps the batches producers compressed as they are, only uncompressed
+	// batches are compressed with the codec.
+	KeepCompressed bool
+}
+
+// Recompress re-encodes the v2 record batches in b compressed with another codec than r's with
+// it, like a topic's compression.type asks for. Batches whose codec isn't supported are left as
+// they are, as are v0/v1 message sets and everything if r's codec isn't supported. b is returned
+// if no batch is re-encoded. b should have been validated.
+func Recompress(b []byte, r Recompression) ([]byte, error) {
+	c := r.Codec
+	if !SupportedCompression(c) {
+		return b, nil
+	}
+	var out []byte
+	// how much of b is in out
+	copied := 0
+	for pos := 0; len(b)-pos >= recordSetMagicOffset+1; {
+		size := int(int32(Encoding.Uint32(b[pos+8:])))
+		if size < 0 || size > len(b)-pos-12 {
+			break
+		}
+		entry := b[pos : pos+12+size]
+		start := pos
+		pos += 12 + size
+		if int8(entry[recordSetMagicOffset]) < 2 || len(entry) < recordBatchHeaderLen {
+			continue
+		}
+		current := int8(Encoding.Uint16(entry[recordBatchAttributesOffset:]) & recordBatchCompressionMask)
+		if current == c || !SupportedCompression(current) || r.KeepCompressed && current != CompressionNone {
+			continue
+		}
+		batches, err := ReadRecordBatches(entry)
+		if err != nil {
+			return nil, err
+		}
+		batch := batches[0]
+		batch.Attributes = batch.Attributes&^recordBatchCompressionMask | int16(c)
+		out = append(append(out, b[copied:start]...), batch.encode(r.Level)...)
+		copied = pos
+	}
+	if out == nil {
+		return b, nil
+	}
+	return append(out, b[copied:]...), nil
+}
+
+// ClearProducer removes the producer ids, epochs and sequences from the v2 record batches in b
+// and unmarks them as transactional, updating their CRCs, so they can be appended without the
+// producer's state, like when they're copied from another cluster. b should have been validated.
+func ClearProducer(b []byte) {
+	for len(b) >= recordSetMagicOffset+1 {
+		size := int(int32(Encoding.Uint32(b[8:])))
+		if size < 0 || size > len(b)-12 {
+			return
+		}
+		entry := b[:12+size]
+		b = b[12+size:]
+		if int8(entry[recordSetMagicOffset]) < 2 || len(entry) < recordBatchHeaderLen {
+			continue
+		}
+		attributes := Encoding.Uint16(entry[recordBatchAttributesOffset:])
+		Encoding.PutUint16(entry[recordBatchAttributesOffset:], attributes&^RecordBatchTransactional)
+		// -1s for no producer
+		Encoding.PutUint64(entry[recordBatchProducerIDOffset:], ^uint64(0))
+		Encoding.PutUint16(entry[recordBatchProducerEpochOffset:], ^uint16(0))
+		Encoding.PutUint32(entry[recordBatchBaseSequenceOffset:], ^uint32(0))
+		Encoding.PutUint32(entry[recordBatchCRCOffset:], crc32.Checksum(entry[recordBatchCRCOffset+4:], castagnoliTable))
+	}
+}
+
+// SetPartitionLeaderEpoch stamps the v2 record batches in b with the epoch of the leader that
+// appended them. The epoch isn't covered by the batches' CRCs so they're left as they are. b
+// should have been validated.
+func SetPartitionLeaderEpoch(b []byte, epoch int32) {
+	for len(b) >= recordSetMagicOffset+1 {
+		size := int(int32(Encoding.Uint32(b[8:])))
+		if size < 0 || size > len(b)-12 {
+			return
+		}
+		entry := b[:12+size]
+		b = b[12+size:]
+		if int8(entry[recordSetMagicOffset]) >= 2 {
+			Encoding.PutUint32(entry[recordBatchPartitionLeaderEpochOffset:], uint32(epoch))
+		}
+	}
+}
+
+// PartitionLeaderEpoch returns the epoch of the leader that appended the last v2 record batch in
+// b, -1 if there's none since v0/v1 message sets don't carry the epoch.
+func PartitionLeaderEpoch(b []byte) int32 {
+	epoch := int32(-1)
+	for len(b) >= recordSetMagicOffset+1 {
+		size := int(int32(Encoding.Uint32(b[8:])))
+		if size < 0 || size > len(b)-12 {
+			break
+		}
+		entry := b[:12+size]
+		b = b[12+size:]
+		if int8(entry[recordSetMagicOffset]) >= 2 {
+			epoch = int32(Encoding.Uint32(entry[recordBatchPartitionLeaderEpochOffset:]))
+		}
+	}
+	return epoch
+}
@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRecordSet(t *testing.T) {
+	req := require.New(t)
+	ms, err := Encode(&MessageSet{Offset: 0, Messages: []*Message{{Value: []byte("The message.")}}})
+	req.NoError(err)
+	req.Equal(ErrNone, ValidateRecordSet(ms))
+
+	// multiple messages in a message set
+	req.Equal(ErrNone, ValidateRecordSet(mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{Value: []byte("one")}, {MagicByte: 1, Key: []byte("key"), Value: []byte("two")}}})))
+
+	// multiple message sets back to back
+	req.Equal(ErrNone, ValidateRecordSet(append(append([]byte{}, ms...), ms...)))
+
+	// corrupted value
+	corrupt := append([]byte{}, ms...)
+	corrupt[len(corrupt)-1]++
+	req.Equal(ErrCorruptMessage, ValidateRecordSet(corrupt))
+
+	// truncated message set
+	req.Equal(ErrCorruptMessage, ValidateRecordSet(ms[:len(ms)-1]))
+	req.Equal(ErrCorruptMessage, ValidateRecordSet(ms[:10]))
+
+	// v2 record batch: offset, size, partition leader epoch, magic, crc, then the checksummed rest
+	batch := make([]byte, 25)
+	Encoding.PutUint32(batch[8:], uint32(len(batch)-12))
+	batch[16] = 2
+	copy(batch[21:], "data")
+	Encoding.PutUint32(batch[17:], crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)))
+	req.Equal(ErrNone, ValidateRecordSet(batch))
+	batch[24]++
+	req.Equal(ErrCorruptMessage, ValidateRecordSet(batch))
+}
+
+func mustEncodeMessageSet(t *testing.T, ms *MessageSet) []byte {
+	b, err := Encode(ms)
+	require.NoError(t, err)
+	return b
+}
+
+func TestRecordBatchProducers(t *testing.T) {
+	req := require.New(t)
+	ms := mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{Value: []byte("v0")}}})
+	idempotent := recordBatchHeader(7, 2, 10, 4, 1000)
+	plain := recordBatchHeader(-1, -1, -1, 0, 1000)
+	b := append(append(append([]byte{}, ms...), idempotent...), plain...)
+	req.Equal([]RecordBatchProducer{
+		{ProducerID: 7, ProducerEpoch: 2, BaseSequence: 10, LastOffsetDelta: 4, MaxTimestamp: 1000},
+	}, RecordBatchProducers(b))
+	req.Equal(int32(14), RecordBatchProducers(b)[0].LastSequence())
+}
+
+func TestRecordSetEntries(t *testing.T) {
+	req := require.New(t)
+	ms := mustEncodeMessageSet(t, &MessageSet{Offset: 3, Messages: []*Message{{Value: []byte("v0")}}})
+	batch := recordBatchHeader(7, 2, 10, 4, 1000)
+	Encoding.PutUint64(batch, 4)
+	b := append(append([]byte{}, ms...), batch...)
+	entries := RecordSetEntries(b)
+	req.Equal([]RecordSetEntry{
+		{Offset: 3, Magic: 0, CRC: Encoding.Uint32(ms[12:]), Bytes: ms},
+		{Offset: 4, Magic: 2, CRC: Encoding.Uint32(batch[recordBatchCRCOffset:]), Bytes: batch},
+	}, entries)
+
+	// a partial trailing entry is left out
+	req.Equal(entries[:1], RecordSetEntries(b[:len(b)-1]))
+}
+
+func TestClearProducer(t *testing.T) {
+	req := require.New(t)
+	ms := mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{Value: []byte("v0")}}})
+	batch := recordBatchHeader(7, 2, 10, 4, 1000)
+	Encoding.PutUint16(batch[recordBatchAttributesOffset:], RecordBatchTransactional)
+	Encoding.PutUint32(batch[recordBatchCRCOffset:], crc32.Checksum(batch[recordBatchCRCOffset+4:], castagnoliTable))
+	b := append(append([]byte{}, ms...), batch...)
+	ClearProducer(b)
+	req.Equal(ms, b[:len(ms)])
+	req.Equal(ErrNone, ValidateRecordSet(b))
+	batches, err := ReadRecordBatches(b)
+	req.NoError(err)
+	req.Equal(int64(-1), batches[0].ProducerID)
+	req.Equal(int16(-1), batches[0].ProducerEpoch)
+	req.Equal(int32(-1), batches[0].BaseSequence)
+	req.False(batches[0].Transactional())
+	req.Empty(RecordBatchProducers(b))
+}
+
+// recordBatchHeader returns a v2 record batch without records with the given producer fields.
+func recordBatchHeader(producerID int64, epoch int16, baseSequence, lastOffsetDelta int32, maxTimestamp int64) []byte {
+	b := make([]byte, recordBatchHeaderLen)
+	Encoding.PutUint32(b[8:], uint32(len(b)-12))
+	b[recordSetMagicOffset] = 2
+	Encoding.PutUint32(b[recordBatchLastOffsetDeltaOffset:], uint32(lastOffsetDelta))
+	Encoding.PutUint64(b[recordBatchMaxTimestampOffset:], uint64(maxTimestamp))
+	Encoding.PutUint64(b[recordBatchProducerIDOffset:], uint64(producerID))
+	Encoding.PutUint16(b[recordBatchProducerEpochOffset:], uint16(epoch))
+	Encoding.PutUint32(b[recordBatchBaseSequenceOffset:], uint32(baseSequence))
+	Encoding.PutUint32(b[recordBatchCRCOffset:], crc32.Checksum(b[recordBatchCRCOffset+4:], castagnoliTable))
+	return b
+}
+
+func TestSetLogAppendTime(t *testing.T) {
+	req := require.New(t)
+	v0 := mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{Value: []byte("v0")}}})
+	v1 := mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{MagicByte: 1, Timestamp: time.Unix(1, 0), Value: []byte("v1")}}})
+	v2 := recordBatchHeader(7, 2, 10, 4, 1000)
+	b := append(append(append([]byte{}, v0...), v1...), v2...)
+	appended := time.Unix(1500000000, 0)
+	SetLogAppendTime(b, appended)
+	req.Equal(ErrNone, ValidateRecordSet(b))
+
+	// v0 messages don't have timestamps
+	req.Equal(v0, b[:len(v0)])
+
+	ms := new(MessageSet)
+	req.NoError(ms.Decode(NewDecoder(b[len(v0) : len(v0)+len(v1)])))
+	req.Equal(appended, ms.Messages[0].Timestamp)
+	req.Equal(int8(timestampTypeAttribute), ms.Messages[0].Attributes)
+
+	batch := b[len(v0)+len(v1):]
+	req.Equal(uint16(timestampTypeAttribute), Encoding.Uint16(batch[recordBatchAttributesOffset:]))
+	req.Equal(appended.UnixNano()/int64(time.Millisecond), RecordBatchProducers(batch)[0].MaxTimestamp)
+}
+
+func TestSetPartitionLeaderEpoch(t *testing.T) {
+	req := require.New(t)
+	v1 := mustEncodeMessageSet(t, &MessageSet{Messages: []*Message{{MagicByte: 1, Timestamp: time.Unix(1, 0), Value: []byte("v1")}}})
+	req.Equal(int32(-1), PartitionLeaderEpoch(v1))
+
+	b := append(append(append([]byte{}, v1...), recordBatchHeader(7, 2, 10, 4, 1000)...), recordBatchHeader(-1, -1, -1, 0, 1000)...)
+	SetPartitionLeaderEpoch(b, 3)
+	req.Equal(ErrNone, ValidateRecordSet(b))
+	req.Equal(v1, b[:len(v1)])
+	req.Equal(int32(3), PartitionLeaderEpoch(b))
+	req.Equal(int32(3), PartitionLeaderEpoch(b[len(v1):len(v1)+recordBatchHeaderLen]))
+}
@@ -7,29 +7,66 @@ import (
 type MetadataRequest struct {
 	APIVersion int16
 
+	// Topics is nil to ask for every topic. From version 1 an empty array asks for none,
+	// while in version 0 it too asks for every topic.
 	Topics                 []string
 	AllowAutoTopicCreation bool
+	// IncludeClusterAuthorizedOperations and IncludeTopicAuthorizedOperations are sent from
+	// version 8.
+	IncludeClusterAuthorizedOperations bool
+	IncludeTopicAuthorizedOperations   bool
 }
 
 func (r *MetadataRequest) Encode(e PacketEncoder) (err error) {
-	err = e.PutStringArray(r.Topics)
+	if r.APIVersion >= 1 && r.Topics == nil {
+		err = e.PutArrayLength(-1)
+	} else {
+		err = e.PutStringArray(r.Topics)
+	}
 	if err != nil {
 		return err
 	}
 	if r.APIVersion >= 4 {
 		e.PutBool(r.AllowAutoTopicCreation)
 	}
+	if r.APIVersion >= 8 {
+		e.PutBool(r.IncludeClusterAuthorizedOperations)
+		e.PutBool(r.IncludeTopicAuthorizedOperations)
+	}
 	return nil
 }
 
 func (r *MetadataRequest) Decode(d PacketDecoder, version int16) (err error) {
 	r.APIVersion = version
-	r.Topics, err = d.StringArray()
+	n, err := d.Int32()
 	if err != nil {
 		return err
 	}
+	switch {
+	case n == -1 || (n == 0 && version == 0):
+		r.Topics = nil
+	case n < 0:
+		return ErrInvalidArrayLength
+	case 2*int(n) > d.remaining():
+		return ErrInsufficientData
+	default:
+		r.Topics = make([]string, n)
+		for i := range r.Topics {
+			if r.Topics[i], err = d.String(); err != nil {
+				return err
+			}
+		}
+	}
 	if version >= 4 {
-		r.AllowAutoTopicCreation, err = d.Bool()
+		if r.AllowAutoTopicCreation, err = d.Bool(); err != nil {
+			return err
+		}
+	}
+	if version >= 8 {
+		if r.IncludeClusterAuthorizedOperations, err = d.Bool(); err != nil {
+			return err
+		}
+		r.IncludeTopicAuthorizedOperations, err = d.Bool()
 	}
 	return err
 }
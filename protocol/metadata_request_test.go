@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataRequest(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{0, 1} {
+		exp := &MetadataRequest{APIVersion: version, Topics: []string{"the-topic", "another-topic"}}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act MetadataRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
+
+func TestMetadataRequestV8(t *testing.T) {
+	req := require.New(t)
+	exp := &MetadataRequest{
+		APIVersion:                       8,
+		Topics:                           []string{"the-topic"},
+		AllowAutoTopicCreation:           true,
+		IncludeTopicAuthorizedOperations: true,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act MetadataRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
+
+func TestMetadataRequestAllTopics(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{0, 1} {
+		exp := &MetadataRequest{APIVersion: version}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act MetadataRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Nil(act.Topics)
+	}
+}
+
+func TestMetadataRequestNoTopics(t *testing.T) {
+	req := require.New(t)
+	exp := &MetadataRequest{APIVersion: 1, Topics: []string{}}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act MetadataRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.NotNil(act.Topics)
+	req.Empty(act.Topics)
+}
+
+func TestMetadataRequestTooManyTopics(t *testing.T) {
+	req := require.New(t)
+	b := []byte{0x7f, 0xff, 0xff, 0xff, 0, 1, 'a'}
+	for _, version := range []int16{0, 1} {
+		var act MetadataRequest
+		err := Decode(b, &act, version)
+		req.Equal(ErrInsufficientData, err)
+	}
+}
@@ -1,39 +1,64 @@
 package protocol
 
 import (
+	"math"
+	"time"
+
 	"go.uber.org/zap/zapcore"
 )
 
+// AuthorizedOperationsOmitted is sent in place of the authorized operations of clusters and topics
+// when they weren't asked for.
+const AuthorizedOperationsOmitted int32 = math.MinInt32
+
 type Broker struct {
 	NodeID int32
 	Host   string
 	Port   int32
-	// unsupported: Rack *string
+	// Rack is sent from version 1, nil if the broker isn't in one.
+	Rack *string
 }
 
 type PartitionMetadata struct {
 	PartitionErrorCode int16
 	PartitionID        int32
 	Leader             int32
-	Replicas           []int32
-	ISR                []int32
+	// LeaderEpoch is sent from version 7.
+	LeaderEpoch int32
+	Replicas    []int32
+	ISR         []int32
+	// OfflineReplicas, the replicas on brokers that aren't alive, are sent from version 5.
+	OfflineReplicas []int32
 }
 
 type TopicMetadata struct {
-	TopicErrorCode    int16
-	Topic             string
+	TopicErrorCode int16
+	Topic          string
+	// IsInternal is sent from version 1.
+	IsInternal        bool
 	PartitionMetadata []*PartitionMetadata
+	// TopicAuthorizedOperations is sent from version 8.
+	TopicAuthorizedOperations int32
 }
 
 type MetadataResponse struct {
 	APIVersion int16
 
-	Brokers       []*Broker
+	// ThrottleTime is sent from version 3.
+	ThrottleTime time.Duration
+	Brokers      []*Broker
+	// ClusterID is sent from version 2, nil if the cluster doesn't have one.
+	ClusterID     *string
 	ControllerID  int32
 	TopicMetadata []*TopicMetadata
+	// ClusterAuthorizedOperations is sent from version 8.
+	ClusterAuthorizedOperations int32
 }
 
 func (r *MetadataResponse) Encode(e PacketEncoder) (err error) {
+	if r.APIVersion >= 3 {
+		e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	}
 	if err = e.PutArrayLength(len(r.Brokers)); err != nil {
 		return err
 	}
@@ -43,6 +68,16 @@ func (r *MetadataResponse) Encode(e PacketEncoder) (err error) {
 			return err
 		}
 		e.PutInt32(b.Port)
+		if r.APIVersion >= 1 {
+			if err = e.PutNullableString(b.Rack); err != nil {
+				return err
+			}
+		}
+	}
+	if r.APIVersion >= 2 {
+		if err = e.PutNullableString(r.ClusterID); err != nil {
+			return err
+		}
 	}
 	if r.APIVersion >= 1 {
 		e.PutInt32(r.ControllerID)
@@ -55,6 +90,9 @@ func (r *MetadataResponse) Encode(e PacketEncoder) (err error) {
 		if err = e.PutString(t.Topic); err != nil {
 			return err
 		}
+		if r.APIVersion >= 1 {
+			e.PutBool(t.IsInternal)
+		}
 		if err = e.PutArrayLength(len(t.PartitionMetadata)); err != nil {
 			return err
 		}
@@ -62,20 +100,41 @@ func (r *MetadataResponse) Encode(e PacketEncoder) (err error) {
 			e.PutInt16(p.PartitionErrorCode)
 			e.PutInt32(p.PartitionID)
 			e.PutInt32(p.Leader)
+			if r.APIVersion >= 7 {
+				e.PutInt32(p.LeaderEpoch)
+			}
 			if err = e.PutInt32Array(p.Replicas); err != nil {
 				return err
 			}
 			if err = e.PutInt32Array(p.ISR); err != nil {
 				return err
 			}
+			if r.APIVersion >= 5 {
+				if err = e.PutInt32Array(p.OfflineReplicas); err != nil {
+					return err
+				}
+			}
+		}
+		if r.APIVersion >= 8 {
+			e.PutInt32(t.TopicAuthorizedOperations)
 		}
 	}
+	if r.APIVersion >= 8 {
+		e.PutInt32(r.ClusterAuthorizedOperations)
+	}
 	return nil
 }
 
 func (r *MetadataResponse) Decode(d PacketDecoder, version int16) (err error) {
 	r.APIVersion = version
 
+	if version >= 3 {
+		throttle, err := d.Int32()
+		if err != nil {
+			return err
+		}
+		r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	}
 	brokerCount, err := d.ArrayLength()
 	if err != nil {
 		return err
@@ -99,6 +158,16 @@ func (r *MetadataResponse) Decode(d PacketDecoder, version int16) (err error) {
 			Host:   host,
 			Port:   port,
 		}
+		if version >= 1 {
+			if r.Brokers[i].Rack, err = d.NullableString(); err != nil {
+				return err
+			}
+		}
+	}
+	if version >= 2 {
+		if r.ClusterID, err = d.NullableString(); err != nil {
+			return err
+		}
 	}
 	if version >= 1 {
 		r.ControllerID, err = d.Int32()
@@ -121,6 +190,11 @@ func (r *MetadataResponse) Decode(d PacketDecoder, version int16) (err error) {
 		if err != nil {
 			return err
 		}
+		if version >= 1 {
+			if m.IsInternal, err = d.Bool(); err != nil {
+				return err
+			}
+		}
 		partitionCount, err := d.ArrayLength()
 		if err != nil {
 			return err
@@ -140,16 +214,39 @@ func (r *MetadataResponse) Decode(d PacketDecoder, version int16) (err error) {
 			if err != nil {
 				return err
 			}
+			if version >= 7 {
+				if p.LeaderEpoch, err = d.Int32(); err != nil {
+					return err
+				}
+			}
 			p.Replicas, err = d.Int32Array()
 			if err != nil {
 				return err
 			}
 			p.ISR, err = d.Int32Array()
+			if err != nil {
+				return err
+			}
+			if version >= 5 {
+				if p.OfflineReplicas, err = d.Int32Array(); err != nil {
+					return err
+				}
+			}
 			partitions[i] = p
 		}
 		m.PartitionMetadata = partitions
+		if version >= 8 {
+			if m.TopicAuthorizedOperations, err = d.Int32(); err != nil {
+				return err
+			}
+		}
 		r.TopicMetadata[i] = m
 	}
+	if version >= 8 {
+		if r.ClusterAuthorizedOperations, err = d.Int32(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -211,5 +308,6 @@ func (r *PartitionMetadata) MarshalLogObject(e zapcore.ObjectEncoder) error {
 	e.AddInt32("leader", r.Leader)
 	e.AddArray("replicas", Int32s(r.Replicas))
 	e.AddArray("isr", Int32s(r.ISR))
+	e.AddArray("offline replicas", Int32s(r.OfflineReplicas))
 	return nil
 }
@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataResponse(t *testing.T) {
+	req := require.New(t)
+	rack := "rack-1"
+	for version := int16(0); version <= 8; version++ {
+		exp := &MetadataResponse{
+			APIVersion: version,
+			Brokers: []*Broker{
+				{NodeID: 1, Host: "localhost", Port: 9092},
+			},
+			TopicMetadata: []*TopicMetadata{{
+				Topic: "the-topic",
+				PartitionMetadata: []*PartitionMetadata{
+					{PartitionID: 0, Leader: 1, Replicas: []int32{1}, ISR: []int32{1}},
+				},
+			}},
+		}
+		if version >= 1 {
+			exp.Brokers[0].Rack = &rack
+			exp.ControllerID = 1
+			exp.TopicMetadata[0].IsInternal = true
+		}
+		if version >= 2 {
+			clusterID := "the-cluster"
+			exp.ClusterID = &clusterID
+		}
+		if version >= 3 {
+			exp.ThrottleTime = 10 * time.Millisecond
+		}
+		if version >= 5 {
+			exp.TopicMetadata[0].PartitionMetadata[0].Replicas = []int32{1, 2}
+			exp.TopicMetadata[0].PartitionMetadata[0].OfflineReplicas = []int32{2}
+		}
+		if version >= 7 {
+			exp.TopicMetadata[0].PartitionMetadata[0].LeaderEpoch = 3
+		}
+		if version >= 8 {
+			exp.TopicMetadata[0].TopicAuthorizedOperations = AuthorizedOperationsOmitted
+			exp.ClusterAuthorizedOperations = AuthorizedOperationsOmitted
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act MetadataResponse
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// https://kafka.apache.org/protocol#The_Messages_OffsetCommit
+
 type OffsetCommitRequest struct {
 	APIVersion int16
 
-	GroupID       string
-	GenerationID  int32
-	MemberID      string
+	GroupID string
+	// GenerationID and MemberID are sent from version 1. Consumers that aren't members of the
+	// group, like admin tools resetting its offsets, send -1 and an empty member id.
+	GenerationID int32
+	MemberID     string
+	// RetentionTime is sent from version 2.
 	RetentionTime int64
 	Topics        []OffsetCommitTopicRequest
 }
@@ -22,6 +27,7 @@ type OffsetCommitTopicRequest struct {
 type OffsetCommitPartitionRequest struct {
 	Partition int32
 	Offset    int64
+	// Timestamp is only sent in version 1.
 	Timestamp int64
 	Metadata  *string
 }
@@ -30,17 +36,32 @@ func (r *OffsetCommitRequest) Encode(e PacketEncoder) (err error) {
 	if err = e.PutString(r.GroupID); err != nil {
 		return err
 	}
-	if err := e.PutArrayLength(len(r.Topics)); err != nil {
+	if r.APIVersion >= 1 {
+		e.PutInt32(r.GenerationID)
+		if err = e.PutString(r.MemberID); err != nil {
+			return err
+		}
+	}
+	if r.APIVersion >= 2 {
+		e.PutInt64(r.RetentionTime)
+	}
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
 		return err
 	}
 	for _, t := range r.Topics {
-		if err := e.PutArrayLength(len(t.Partitions)); err != nil {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
 			return err
 		}
 		for _, p := range t.Partitions {
 			e.PutInt32(p.Partition)
 			e.PutInt64(p.Offset)
-			if err := e.PutNullableString(p.Metadata); err != nil {
+			if r.APIVersion == 1 {
+				e.PutInt64(p.Timestamp)
+			}
+			if err = e.PutNullableString(p.Metadata); err != nil {
 				return err
 			}
 		}
@@ -61,6 +82,8 @@ func (r *OffsetCommitRequest) Decode(d PacketDecoder, version int16) (err error)
 		if r.MemberID, err = d.String(); err != nil {
 			return err
 		}
+	} else {
+		r.GenerationID = -1
 	}
 	if version >= 2 {
 		if r.RetentionTime, err = d.Int64(); err != nil {
@@ -72,20 +95,25 @@ func (r *OffsetCommitRequest) Decode(d PacketDecoder, version int16) (err error)
 		return err
 	}
 	r.Topics = make([]OffsetCommitTopicRequest, topicCount)
-	for _, t := range r.Topics {
+	for i := range r.Topics {
+		t := OffsetCommitTopicRequest{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
 		partitionCount, err := d.ArrayLength()
 		if err != nil {
 			return err
 		}
 		t.Partitions = make([]OffsetCommitPartitionRequest, partitionCount)
-		for _, p := range t.Partitions {
+		for j := range t.Partitions {
+			p := OffsetCommitPartitionRequest{}
 			if p.Partition, err = d.Int32(); err != nil {
 				return err
 			}
 			if p.Offset, err = d.Int64(); err != nil {
 				return err
 			}
-			if version >= 1 {
+			if version == 1 {
 				if p.Timestamp, err = d.Int64(); err != nil {
 					return err
 				}
@@ -93,16 +121,25 @@ func (r *OffsetCommitRequest) Decode(d PacketDecoder, version int16) (err error)
 			if p.Metadata, err = d.NullableString(); err != nil {
 				return err
 			}
+			t.Partitions[j] = p
 		}
+		r.Topics[i] = t
 	}
 	return nil
 }
 
+func (r *OffsetCommitRequest) Key() int16 {
+	return OffsetCommitKey
+}
+
 func (r *OffsetCommitRequest) Version() int16 {
 	return r.APIVersion
-
 }
 
 func (r *OffsetCommitRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddString("group id", r.GroupID)
+	e.AddInt32("generation id", r.GenerationID)
+	e.AddString("member id", r.MemberID)
+	e.AddInt("topics", len(r.Topics))
 	return nil
 }
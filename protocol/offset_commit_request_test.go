@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetCommitRequest(t *testing.T) {
+	req := require.New(t)
+	metadata := "metadata"
+	for _, version := range []int16{0, 1, 2} {
+		exp := &OffsetCommitRequest{
+			APIVersion: version,
+			GroupID:    "the-group",
+			Topics: []OffsetCommitTopicRequest{{
+				Topic: "the-topic",
+				Partitions: []OffsetCommitPartitionRequest{
+					{Partition: 0, Offset: 10, Metadata: &metadata},
+					{Partition: 1, Offset: 20},
+				},
+			}},
+		}
+		if version == 0 {
+			// only members commit from version 1
+			exp.GenerationID = -1
+		} else {
+			exp.GenerationID = 3
+			exp.MemberID = "the-member"
+		}
+		if version == 1 {
+			exp.Topics[0].Partitions[0].Timestamp = 1500000000000
+		}
+		if version >= 2 {
+			exp.RetentionTime = 60000
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act OffsetCommitRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
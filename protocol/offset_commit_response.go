@@ -61,7 +61,8 @@ func (r *OffsetCommitResponse) Decode(d PacketDecoder, version int16) (err error
 		return err
 	}
 	r.Responses = make([]OffsetCommitTopicResponse, topicCount)
-	for _, t := range r.Responses {
+	for i := range r.Responses {
+		t := OffsetCommitTopicResponse{}
 		if t.Topic, err = d.String(); err != nil {
 			return err
 		}
@@ -70,21 +71,29 @@ func (r *OffsetCommitResponse) Decode(d PacketDecoder, version int16) (err error
 			return err
 		}
 		t.PartitionResponses = make([]OffsetCommitPartitionResponse, partitionCount)
-		for _, p := range t.PartitionResponses {
-			p.Partition, err = d.Int32()
-			if err != nil {
+		for j := range t.PartitionResponses {
+			p := OffsetCommitPartitionResponse{}
+			if p.Partition, err = d.Int32(); err != nil {
 				return err
 			}
-			p.ErrorCode, err = d.Int16()
-			if err != nil {
+			if p.ErrorCode, err = d.Int16(); err != nil {
 				return err
 			}
+			t.PartitionResponses[j] = p
 		}
-
+		r.Responses[i] = t
 	}
 	return nil
 }
 
+func (r *OffsetCommitResponse) Key() int16 {
+	return OffsetCommitKey
+}
+
+func (r *OffsetCommitResponse) Version() int16 {
+	return r.APIVersion
+}
+
 func (r *OffsetCommitResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
 	return nil
 }
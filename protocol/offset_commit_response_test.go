@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetCommitResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &OffsetCommitResponse{
+		Responses: []OffsetCommitTopicResponse{{
+			Topic: "the-topic",
+			PartitionResponses: []OffsetCommitPartitionResponse{
+				{Partition: 0, ErrorCode: ErrNone.Code()},
+				{Partition: 1, ErrorCode: ErrNonEmptyGroup.Code()},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act OffsetCommitResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
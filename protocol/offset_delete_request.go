@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_OffsetDelete
+
+type OffsetDeleteRequest struct {
+	APIVersion int16
+
+	GroupID string
+	Topics  []OffsetDeleteTopicRequest
+}
+
+type OffsetDeleteTopicRequest struct {
+	Topic      string
+	Partitions []int32
+}
+
+func (r *OffsetDeleteRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutString(r.GroupID); err != nil {
+		return err
+	}
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutInt32Array(t.Partitions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *OffsetDeleteRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.GroupID, err = d.String(); err != nil {
+		return err
+	}
+	topicCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]OffsetDeleteTopicRequest, topicCount)
+	for i := range r.Topics {
+		t := OffsetDeleteTopicRequest{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		if t.Partitions, err = d.Int32Array(); err != nil {
+			return err
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *OffsetDeleteRequest) Key() int16 {
+	return OffsetDeleteKey
+}
+
+func (r *OffsetDeleteRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *OffsetDeleteRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddString("group id", r.GroupID)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
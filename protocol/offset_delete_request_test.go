@@ -0,0 +1,24 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetDeleteRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &OffsetDeleteRequest{
+		GroupID: "the-group",
+		Topics: []OffsetDeleteTopicRequest{
+			{Topic: "the-topic", Partitions: []int32{0, 2}},
+			{Topic: "another-topic", Partitions: []int32{1}},
+		},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act OffsetDeleteRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
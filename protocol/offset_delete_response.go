@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type OffsetDeleteResponse struct {
+	APIVersion int16
+
+	ErrorCode    int16
+	ThrottleTime time.Duration
+	Topics       []OffsetDeleteTopicResponse
+}
+
+type OffsetDeleteTopicResponse struct {
+	Topic      string
+	Partitions []OffsetDeletePartitionResponse
+}
+
+type OffsetDeletePartitionResponse struct {
+	Partition int32
+	ErrorCode int16
+}
+
+func (r *OffsetDeleteResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt16(r.ErrorCode)
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt16(p.ErrorCode)
+		}
+	}
+	return nil
+}
+
+func (r *OffsetDeleteResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	topicCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]OffsetDeleteTopicResponse, topicCount)
+	for i := range r.Topics {
+		t := OffsetDeleteTopicResponse{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]OffsetDeletePartitionResponse, partitionCount)
+		for j := range t.Partitions {
+			p := OffsetDeletePartitionResponse{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *OffsetDeleteResponse) Key() int16 {
+	return OffsetDeleteKey
+}
+
+func (r *OffsetDeleteResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *OffsetDeleteResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetDeleteResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &OffsetDeleteResponse{
+		ThrottleTime: 10 * time.Millisecond,
+		Topics: []OffsetDeleteTopicResponse{{
+			Topic: "the-topic",
+			Partitions: []OffsetDeletePartitionResponse{
+				{Partition: 0, ErrorCode: ErrNone.Code()},
+				{Partition: 2, ErrorCode: ErrGroupSubscribedToTopic.Code()},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act OffsetDeleteResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
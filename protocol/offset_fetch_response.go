@@ -11,7 +11,8 @@ type OffsetFetchTopicResponse struct {
 
 type OffsetFetchPartition struct {
 	Partition int32
-	Offset    int16
+	// Offset is -1 if the group hasn't committed one for the partition.
+	Offset    int64
 	Metadata  *string
 	ErrorCode int16
 }
@@ -35,7 +36,7 @@ func (r *OffsetFetchResponse) Encode(e PacketEncoder) (err error) {
 		}
 		for _, p := range resp.Partitions {
 			e.PutInt32(p.Partition)
-			e.PutInt16(p.Offset)
+			e.PutInt64(p.Offset)
 			if err := e.PutNullableString(p.Metadata); err != nil {
 				return err
 			}
@@ -53,7 +54,8 @@ func (r *OffsetFetchResponse) Decode(d PacketDecoder, version int16) (err error)
 		return err
 	}
 	r.Responses = make([]OffsetFetchTopicResponse, responses)
-	for _, resp := range r.Responses {
+	for i := range r.Responses {
+		resp := OffsetFetchTopicResponse{}
 		if resp.Topic, err = d.String(); err != nil {
 			return err
 		}
@@ -62,11 +64,12 @@ func (r *OffsetFetchResponse) Decode(d PacketDecoder, version int16) (err error)
 			return err
 		}
 		resp.Partitions = make([]OffsetFetchPartition, partitions)
-		for _, p := range resp.Partitions {
+		for j := range resp.Partitions {
+			p := OffsetFetchPartition{}
 			if p.Partition, err = d.Int32(); err != nil {
 				return err
 			}
-			if p.Offset, err = d.Int16(); err != nil {
+			if p.Offset, err = d.Int64(); err != nil {
 				return err
 			}
 			if p.Metadata, err = d.NullableString(); err != nil {
@@ -75,11 +78,17 @@ func (r *OffsetFetchResponse) Decode(d PacketDecoder, version int16) (err error)
 			if p.ErrorCode, err = d.Int16(); err != nil {
 				return err
 			}
+			resp.Partitions[j] = p
 		}
+		r.Responses[i] = resp
 	}
 	return nil
 }
 
+func (r *OffsetFetchResponse) Key() int16 {
+	return OffsetFetchKey
+}
+
 func (r *OffsetFetchResponse) Version() int16 {
 	return r.APIVersion
 }
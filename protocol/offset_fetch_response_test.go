@@ -0,0 +1,27 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetFetchResponse(t *testing.T) {
+	req := require.New(t)
+	metadata := "metadata"
+	exp := &OffsetFetchResponse{
+		Responses: []OffsetFetchTopicResponse{{
+			Topic: "the-topic",
+			Partitions: []OffsetFetchPartition{
+				{Partition: 0, Offset: 1 << 40, Metadata: &metadata, ErrorCode: ErrNone.Code()},
+				{Partition: 1, Offset: -1, ErrorCode: ErrNone.Code()},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act OffsetFetchResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,97 @@
+package protocol
+
+import "go.uber.org/zap/zapcore"
+
+// https://kafka.apache.org/protocol#The_Messages_OffsetForLeaderEpoch
+
+type OffsetForLeaderEpochRequest struct {
+	APIVersion int16
+
+	Topics []OffsetForLeaderEpochTopic
+}
+
+type OffsetForLeaderEpochTopic struct {
+	Topic      string
+	Partitions []OffsetForLeaderEpochPartition
+}
+
+type OffsetForLeaderEpochPartition struct {
+	Partition int32
+	// CurrentLeaderEpoch is the epoch the client thinks the partition's leader has, brokers with
+	// a different epoch reject the request. -1 skips the check. Sent from version 2.
+	CurrentLeaderEpoch int32
+	// LeaderEpoch is the epoch to find the end offset of.
+	LeaderEpoch int32
+}
+
+func (r *OffsetForLeaderEpochRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			if r.APIVersion >= 2 {
+				e.PutInt32(p.CurrentLeaderEpoch)
+			}
+			e.PutInt32(p.LeaderEpoch)
+		}
+	}
+	return nil
+}
+
+func (r *OffsetForLeaderEpochRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]OffsetForLeaderEpochTopic, n)
+	for i := range r.Topics {
+		t := OffsetForLeaderEpochTopic{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]OffsetForLeaderEpochPartition, partitionCount)
+		for j := range t.Partitions {
+			p := OffsetForLeaderEpochPartition{CurrentLeaderEpoch: -1}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if version >= 2 {
+				if p.CurrentLeaderEpoch, err = d.Int32(); err != nil {
+					return err
+				}
+			}
+			if p.LeaderEpoch, err = d.Int32(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *OffsetForLeaderEpochRequest) Key() int16 {
+	return OffsetForLeaderEpochKey
+}
+
+func (r *OffsetForLeaderEpochRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *OffsetForLeaderEpochRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetForLeaderEpochRequest(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{0, 2} {
+		exp := &OffsetForLeaderEpochRequest{
+			APIVersion: version,
+			Topics: []OffsetForLeaderEpochTopic{{
+				Topic: "the-topic",
+				Partitions: []OffsetForLeaderEpochPartition{
+					{Partition: 0, CurrentLeaderEpoch: -1, LeaderEpoch: 3},
+					{Partition: 1, CurrentLeaderEpoch: -1, LeaderEpoch: 0},
+				},
+			}},
+		}
+		if version >= 2 {
+			exp.Topics[0].Partitions[0].CurrentLeaderEpoch = 4
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act OffsetForLeaderEpochRequest
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
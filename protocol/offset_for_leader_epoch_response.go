@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type OffsetForLeaderEpochResponse struct {
+	APIVersion int16
+
+	// ThrottleTime is sent from version 2.
+	ThrottleTime time.Duration
+	Topics       []OffsetForLeaderEpochTopicResponse
+}
+
+type OffsetForLeaderEpochTopicResponse struct {
+	Topic      string
+	Partitions []OffsetForLeaderEpochPartitionResponse
+}
+
+type OffsetForLeaderEpochPartitionResponse struct {
+	ErrorCode int16
+	Partition int32
+	// LeaderEpoch is the largest epoch up to the one requested the leader has, sent from
+	// version 1.
+	LeaderEpoch int32
+	// EndOffset is the offset after the last message of the epoch.
+	EndOffset int64
+}
+
+func (r *OffsetForLeaderEpochResponse) Encode(e PacketEncoder) (err error) {
+	if r.APIVersion >= 2 {
+		e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	}
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt16(p.ErrorCode)
+			e.PutInt32(p.Partition)
+			if r.APIVersion >= 1 {
+				e.PutInt32(p.LeaderEpoch)
+			}
+			e.PutInt64(p.EndOffset)
+		}
+	}
+	return nil
+}
+
+func (r *OffsetForLeaderEpochResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if version >= 2 {
+		throttle, err := d.Int32()
+		if err != nil {
+			return err
+		}
+		r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	}
+	n, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]OffsetForLeaderEpochTopicResponse, n)
+	for i := range r.Topics {
+		t := OffsetForLeaderEpochTopicResponse{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]OffsetForLeaderEpochPartitionResponse, partitionCount)
+		for j := range t.Partitions {
+			p := OffsetForLeaderEpochPartitionResponse{}
+			if p.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if version >= 1 {
+				if p.LeaderEpoch, err = d.Int32(); err != nil {
+					return err
+				}
+			}
+			if p.EndOffset, err = d.Int64(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *OffsetForLeaderEpochResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *OffsetForLeaderEpochResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
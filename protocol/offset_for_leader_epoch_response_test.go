@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetForLeaderEpochResponse(t *testing.T) {
+	req := require.New(t)
+	for _, version := range []int16{0, 1, 2} {
+		exp := &OffsetForLeaderEpochResponse{
+			APIVersion: version,
+			Topics: []OffsetForLeaderEpochTopicResponse{{
+				Topic: "the-topic",
+				Partitions: []OffsetForLeaderEpochPartitionResponse{
+					{ErrorCode: ErrNone.Code(), Partition: 0, EndOffset: 10},
+					{ErrorCode: ErrNotLeaderForPartition.Code(), Partition: 1, EndOffset: -1},
+				},
+			}},
+		}
+		if version >= 1 {
+			exp.Topics[0].Partitions[0].LeaderEpoch = 2
+			exp.Topics[0].Partitions[1].LeaderEpoch = -1
+		}
+		if version >= 2 {
+			exp.ThrottleTime = time.Millisecond
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act OffsetForLeaderEpochResponse
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
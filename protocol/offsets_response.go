@@ -9,6 +9,7 @@ import "time"
 type PartitionResponse struct {
 	Partition int32
 	ErrorCode int16
+	// Timestamp is sent from version 1, zero if the offset wasn't looked up by timestamp.
 	Timestamp time.Time
 	Offsets   []int64
 	Offset    int64
@@ -49,7 +50,11 @@ func (r *OffsetsResponse) Encode(e PacketEncoder) (err error) {
 				}
 			}
 			if r.APIVersion >= 1 {
-				e.PutInt64(p.Timestamp.UnixNano() / int64(time.Millisecond))
+				if p.Timestamp.IsZero() {
+					e.PutInt64(-1)
+				} else {
+					e.PutInt64(p.Timestamp.UnixNano() / int64(time.Millisecond))
+				}
 				e.PutInt64(p.Offset)
 			}
 		}
@@ -58,6 +63,7 @@ func (r *OffsetsResponse) Encode(e PacketEncoder) (err error) {
 }
 
 func (r *OffsetsResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
 	if version >= 2 {
 		throttle, err := d.Int32()
 		if err != nil {
@@ -104,7 +110,9 @@ func (r *OffsetsResponse) Decode(d PacketDecoder, version int16) (err error) {
 				if err != nil {
 					return err
 				}
-				p.Timestamp = time.Unix(t/1000, (t%1000)*int64(time.Millisecond))
+				if t != -1 {
+					p.Timestamp = time.Unix(t/1000, (t%1000)*int64(time.Millisecond))
+				}
 				p.Offset, err = d.Int64()
 				if err != nil {
 					return err
@@ -40,9 +40,14 @@ func (r *ProduceResponse) Encode(e PacketEncoder) (err error) {
 		for _, p := range resp.PartitionResponses {
 			e.PutInt32(p.Partition)
 			e.PutInt16(p.ErrorCode)
+			e.PutInt64(p.BaseOffset)
 			if r.APIVersion >= 2 {
-				e.PutInt64(p.BaseOffset)
-				e.PutInt64(int64(p.LogAppendTime.UnixNano() / int64(time.Millisecond)))
+				// -1 means the broker didn't assign the timestamp, i.e. the topic uses CreateTime.
+				if p.LogAppendTime.IsZero() {
+					e.PutInt64(-1)
+				} else {
+					e.PutInt64(p.LogAppendTime.UnixNano() / int64(time.Millisecond))
+				}
 			}
 			if r.APIVersion >= 5 {
 				e.PutInt64(p.LogStartOffset)
@@ -95,7 +100,9 @@ func (r *ProduceResponse) Decode(d PacketDecoder, version int16) (err error) {
 				if err != nil {
 					return err
 				}
-				p.LogAppendTime = time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond))
+				if millis != -1 {
+					p.LogAppendTime = time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond))
+				}
 			}
 			if r.APIVersion >= 5 {
 				p.LogStartOffset, err = d.Int64()
@@ -114,7 +121,10 @@ func (r *ProduceResponse) Decode(d PacketDecoder, version int16) (err error) {
 		r.ThrottleTime = time.Duration(throttle) * time.Millisecond
 	}
 	return nil
+}
 
+func (r *ProduceResponse) Version() int16 {
+	return r.APIVersion
 }
 
 func (r *ProduceResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
@@ -150,5 +160,7 @@ func (r *ProducePartitionResponse) MarshalLogObject(e zapcore.ObjectEncoder) err
 	e.AddInt32("partition", r.Partition)
 	e.AddInt16("error code", r.ErrorCode)
 	e.AddInt64("base offset", r.BaseOffset)
+	e.AddTime("log append time", r.LogAppendTime)
+	e.AddInt64("log start offset", r.LogStartOffset)
 	return nil
 }
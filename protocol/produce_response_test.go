@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProduceResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &ProduceResponse{
+		APIVersion:   5,
+		ThrottleTime: time.Millisecond,
+		Responses: []*ProduceTopicResponse{{
+			Topic: "test_topic",
+			PartitionResponses: []*ProducePartitionResponse{{
+				Partition:      1,
+				ErrorCode:      ErrNone.Code(),
+				BaseOffset:     10,
+				LogAppendTime:  time.Unix(1500000000, 123*int64(time.Millisecond)),
+				LogStartOffset: 2,
+			}, {
+				Partition:      2,
+				ErrorCode:      ErrNone.Code(),
+				BaseOffset:     20,
+				LogStartOffset: 0,
+			}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act ProduceResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
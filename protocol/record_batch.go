@@ -0,0 +1,312 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// RecordHeader is a key/value pair of a v2 record.
+type RecordHeader struct {
+	Key   string
+	Value []byte
+}
+
+// Record is a record of a v2 record batch.
+type Record struct {
+	Attributes     int8
+	TimestampDelta int64
+	OffsetDelta    int32
+	Key            []byte
+	Value          []byte
+	Headers        []RecordHeader
+}
+
+// Header returns the value of the record's first header with the key, or false if it doesn't
+// have one.
+func (r Record) Header(key string) ([]byte, bool) {
+	for _, h := range r.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return nil, false
+}
+
+// RecordBatch is a v2 record batch.
+type RecordBatch struct {
+	BaseOffset           int64
+	PartitionLeaderEpoch int32
+	Attributes           int16
+	LastOffsetDelta      int32
+	FirstTimestamp       int64
+	MaxTimestamp         int64
+	ProducerID           int64
+	ProducerEpoch        int16
+	BaseSequence         int32
+	// Records are nil for batches compressed with codecs that aren't supported.
+	Records []Record
+}
+
+const (
+	// recordBatchCompressionMask masks the compression codec in v2 record batches' attributes.
+	recordBatchCompressionMask = 0x07
+	// RecordBatchTransactional is set in the attributes of batches in transactions.
+	RecordBatchTransactional = 0x10
+	// RecordBatchControl is set in the attributes of batches of control records, like the
+	// markers ending transactions.
+	RecordBatchControl = 0x20
+)
+
+// The types of control records, in their keys.
+const (
+	ControlRecordAbort  int16 = 0
+	ControlRecordCommit int16 = 1
+)
+
+// Compressed returns whether the batch's records are compressed.
+func (b *RecordBatch) Compressed() bool {
+	return b.Attributes&recordBatchCompressionMask != 0
+}
+
+// Compression returns the codec the batch's records are compressed with.
+func (b *RecordBatch) Compression() int8 {
+	return int8(b.Attributes & recordBatchCompressionMask)
+}
+
+// Transactional returns whether the batch is part of a transaction.
+func (b *RecordBatch) Transactional() bool {
+	return b.Attributes&RecordBatchTransactional != 0
+}
+
+// LogAppendTime returns whether the batch's timestamp is the time the broker appended it, rather
+// than its records' create times.
+func (b *RecordBatch) LogAppendTime() bool {
+	return b.Attributes&timestampTypeAttribute != 0
+}
+
+// Control returns whether the batch's records are control records.
+func (b *RecordBatch) Control() bool {
+	return b.Attributes&RecordBatchControl != 0
+}
+
+// ControlRecordType returns the type of the control record, or false if its key isn't one.
+func ControlRecordType(r Record) (int16, bool) {
+	// version then type
+	if len(r.Key) < 4 {
+		return 0, false
+	}
+	return int16(Encoding.Uint16(r.Key[2:])), true
+}
+
+// EndTxnMarker returns a control batch with the marker committing or aborting the producer's
+// transaction, written by the partitions' leaders when the coordinator ends it.
+func EndTxnMarker(producerID int64, producerEpoch int16, coordinatorEpoch int32, committed bool, t time.Time) []byte {
+	typ := ControlRecordAbort
+	if committed {
+		typ = ControlRecordCommit
+	}
+	// version and type
+	key := make([]byte, 4)
+	Encoding.PutUint16(key[2:], uint16(typ))
+	// version and coordinator epoch
+	value := make([]byte, 6)
+	Encoding.PutUint32(value[2:], uint32(coordinatorEpoch))
+	timestamp := t.UnixNano() / int64(time.Millisecond)
+	batch := &RecordBatch{
+		Attributes:     RecordBatchTransactional | RecordBatchControl,
+		FirstTimestamp: timestamp,
+		MaxTimestamp:   timestamp,
+		ProducerID:     producerID,
+		ProducerEpoch:  producerEpoch,
+		BaseSequence:   -1,
+		Records:        []Record{{Key: key, Value: value}},
+	}
+	return batch.Bytes()
+}
+
+// Bytes encodes the batch with its records compressed with its codec, or uncompressed if the
+// codec isn't supported.
+func (b *RecordBatch) Bytes() []byte {
+	return b.encode(0)
+}
+
+// encode encodes the batch with its records compressed at the level, 0 for the codec's default.
+func (b *RecordBatch) encode(level int) []byte {
+	attributes := b.Attributes
+	c, compressed := codecs[b.Compression()]
+	if !compressed {
+		attributes &^= recordBatchCompressionMask
+	}
+	buf := make([]byte, recordBatchHeaderLen)
+	Encoding.PutUint64(buf, uint64(b.BaseOffset))
+	Encoding.PutUint32(buf[recordBatchPartitionLeaderEpochOffset:], uint32(b.PartitionLeaderEpoch))
+	buf[recordSetMagicOffset] = 2
+	Encoding.PutUint16(buf[recordBatchAttributesOffset:], uint16(attributes))
+	Encoding.PutUint32(buf[recordBatchLastOffsetDeltaOffset:], uint32(b.LastOffsetDelta))
+	Encoding.PutUint64(buf[recordBatchLastOffsetDeltaOffset+4:], uint64(b.FirstTimestamp))
+	Encoding.PutUint64(buf[recordBatchMaxTimestampOffset:], uint64(b.MaxTimestamp))
+	Encoding.PutUint64(buf[recordBatchProducerIDOffset:], uint64(b.ProducerID))
+	Encoding.PutUint16(buf[recordBatchProducerEpochOffset:], uint16(b.ProducerEpoch))
+	Encoding.PutUint32(buf[recordBatchBaseSequenceOffset:], uint32(b.BaseSequence))
+	Encoding.PutUint32(buf[recordBatchHeaderLen-4:], uint32(len(b.Records)))
+	for _, r := range b.Records {
+		rec := []byte{byte(r.Attributes)}
+		rec = putVarint(rec, r.TimestampDelta)
+		rec = putVarint(rec, int64(r.OffsetDelta))
+		rec = putVarintBytes(rec, r.Key)
+		rec = putVarintBytes(rec, r.Value)
+		rec = putVarint(rec, int64(len(r.Headers)))
+		for _, h := range r.Headers {
+			rec = putVarintBytes(rec, []byte(h.Key))
+			rec = putVarintBytes(rec, h.Value)
+		}
+		buf = append(putVarint(buf, int64(len(rec))), rec...)
+	}
+	if compressed {
+		buf = append(buf[:recordBatchHeaderLen], c.compress(buf[recordBatchHeaderLen:], level)...)
+	}
+	Encoding.PutUint32(buf[8:], uint32(len(buf)-12))
+	Encoding.PutUint32(buf[recordBatchCRCOffset:], crc32.Checksum(buf[recordBatchAttributesOffset:], castagnoliTable))
+	return buf
+}
+
+func putVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+// putVarintBytes appends varint length prefixed bytes, nil is written as null.
+func putVarintBytes(b []byte, v []byte) []byte {
+	if v == nil {
+		return putVarint(b, -1)
+	}
+	return append(putVarint(b, int64(len(v))), v...)
+}
+
+// ReadRecordBatches returns the v2 record batches in b, skipping v0/v1 message sets. The records
+// of batches compressed with codecs that aren't supported aren't decoded. b should have been
+// validated, and the records of uncompressed batches reference it rather than being copied.
+func ReadRecordBatches(b []byte) ([]*RecordBatch, error) {
+	var batches []*RecordBatch
+	for len(b) >= recordSetMagicOffset+1 {
+		size := int(int32(Encoding.Uint32(b[8:])))
+		if size < 0 || size > len(b)-12 {
+			return nil, ErrInsufficientData
+		}
+		entry := b[:12+size]
+		b = b[12+size:]
+		if int8(entry[recordSetMagicOffset]) < 2 {
+			continue
+		}
+		if len(entry) < recordBatchHeaderLen {
+			return nil, ErrInsufficientData
+		}
+		batch := &RecordBatch{
+			BaseOffset:           int64(Encoding.Uint64(entry)),
+			PartitionLeaderEpoch: int32(Encoding.Uint32(entry[recordBatchPartitionLeaderEpochOffset:])),
+			Attributes:           int16(Encoding.Uint16(entry[recordBatchAttributesOffset:])),
+			LastOffsetDelta:      int32(Encoding.Uint32(entry[recordBatchLastOffsetDeltaOffset:])),
+			FirstTimestamp:       int64(Encoding.Uint64(entry[recordBatchLastOffsetDeltaOffset+4:])),
+			MaxTimestamp:         int64(Encoding.Uint64(entry[recordBatchMaxTimestampOffset:])),
+			ProducerID:           int64(Encoding.Uint64(entry[recordBatchProducerIDOffset:])),
+			ProducerEpoch:        int16(Encoding.Uint16(entry[recordBatchProducerEpochOffset:])),
+			BaseSequence:         int32(Encoding.Uint32(entry[recordBatchBaseSequenceOffset:])),
+		}
+		records := entry[recordBatchHeaderLen:]
+		if batch.Compressed() {
+			c, ok := codecs[batch.Compression()]
+			if !ok {
+				batches = append(batches, batch)
+				continue
+			}
+			decompressed, err := c.decompress(records)
+			if err != nil {
+				return nil, ErrCorruptMessage.WithErr(err)
+			}
+			records = decompressed
+		}
+		decoded, err := readRecords(records, int(int32(Encoding.Uint32(entry[recordBatchHeaderLen-4:]))))
+		if err != nil {
+			return nil, err
+		}
+		batch.Records = decoded
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+// recordReader reads the varint encoded fields of v2 records.
+type recordReader struct {
+	b   []byte
+	err error
+}
+
+func (r *recordReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = ErrInsufficientData
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// bytes reads varint length prefixed bytes, a -1 length is null.
+func (r *recordReader) bytes() []byte {
+	l := r.varint()
+	if r.err != nil || l < 0 {
+		return nil
+	}
+	if l > int64(len(r.b)) {
+		r.err = ErrInsufficientData
+		return nil
+	}
+	v := r.b[:l:l]
+	r.b = r.b[l:]
+	return v
+}
+
+func readRecords(b []byte, count int) ([]Record, error) {
+	if count < 0 {
+		return nil, ErrInsufficientData
+	}
+	r := &recordReader{b: b}
+	var records []Record
+	for i := 0; i < count; i++ {
+		length := r.varint()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if length < 0 || length > int64(len(r.b)) {
+			return nil, ErrInsufficientData
+		}
+		rec := &recordReader{b: r.b[:length]}
+		r.b = r.b[length:]
+		if len(rec.b) < 1 {
+			return nil, ErrInsufficientData
+		}
+		record := Record{Attributes: int8(rec.b[0])}
+		rec.b = rec.b[1:]
+		record.TimestampDelta = rec.varint()
+		record.OffsetDelta = int32(rec.varint())
+		record.Key = rec.bytes()
+		record.Value = rec.bytes()
+		headers := rec.varint()
+		if rec.err == nil && (headers < 0 || headers > int64(len(rec.b))) {
+			return nil, ErrInsufficientData
+		}
+		for j := int64(0); j < headers && rec.err == nil; j++ {
+			key := rec.bytes()
+			record.Headers = append(record.Headers, RecordHeader{Key: string(key), Value: rec.bytes()})
+		}
+		if rec.err != nil {
+			return nil, rec.err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,156 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// encodeTestRecordBatch encodes the batch in the v2 format, with its records uncompressed.
+func encodeTestRecordBatch(batch *RecordBatch) []byte {
+	var records []byte
+	putVarint := func(b []byte, v int64) []byte {
+		var buf [binary.MaxVarintLen64]byte
+		return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+	}
+	putBytes := func(b, v []byte) []byte {
+		if v == nil {
+			return putVarint(b, -1)
+		}
+		return append(putVarint(b, int64(len(v))), v...)
+	}
+	for _, r := range batch.Records {
+		rec := []byte{byte(r.Attributes)}
+		rec = putVarint(rec, r.TimestampDelta)
+		rec = putVarint(rec, int64(r.OffsetDelta))
+		rec = putBytes(rec, r.Key)
+		rec = putBytes(rec, r.Value)
+		rec = putVarint(rec, int64(len(r.Headers)))
+		for _, h := range r.Headers {
+			rec = putBytes(rec, []byte(h.Key))
+			rec = putBytes(rec, h.Value)
+		}
+		records = append(putVarint(records, int64(len(rec))), rec...)
+	}
+	b := make([]byte, recordBatchHeaderLen, recordBatchHeaderLen+len(records))
+	Encoding.PutUint64(b, uint64(batch.BaseOffset))
+	Encoding.PutUint32(b[8:], uint32(recordBatchHeaderLen+len(records)-12))
+	Encoding.PutUint32(b[recordBatchPartitionLeaderEpochOffset:], uint32(batch.PartitionLeaderEpoch))
+	b[recordSetMagicOffset] = 2
+	Encoding.PutUint16(b[recordBatchAttributesOffset:], uint16(batch.Attributes))
+	Encoding.PutUint32(b[recordBatchLastOffsetDeltaOffset:], uint32(batch.LastOffsetDelta))
+	Encoding.PutUint64(b[recordBatchLastOffsetDeltaOffset+4:], uint64(batch.FirstTimestamp))
+	Encoding.PutUint64(b[recordBatchMaxTimestampOffset:], uint64(batch.MaxTimestamp))
+	Encoding.PutUint64(b[recordBatchProducerIDOffset:], uint64(batch.ProducerID))
+	Encoding.PutUint16(b[recordBatchProducerEpochOffset:], uint16(batch.ProducerEpoch))
+	Encoding.PutUint32(b[recordBatchBaseSequenceOffset:], uint32(batch.BaseSequence))
+	Encoding.PutUint32(b[recordBatchHeaderLen-4:], uint32(len(batch.Records)))
+	b = append(b, records...)
+	Encoding.PutUint32(b[recordBatchCRCOffset:], crc32.Checksum(b[recordBatchCRCOffset+4:], castagnoliTable))
+	return b
+}
+
+func TestReadRecordBatches(t *testing.T) {
+	req := require.New(t)
+	exp := []*RecordBatch{{
+		BaseOffset:      3,
+		LastOffsetDelta: 1,
+		FirstTimestamp:  1000,
+		MaxTimestamp:    1005,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+		BaseSequence:    -1,
+		Records: []Record{
+			{Key: []byte("key"), Value: []byte("value"), Headers: []RecordHeader{{Key: "type", Value: []byte("order")}}},
+			{TimestampDelta: 5, OffsetDelta: 1, Value: []byte("another value"), Headers: []RecordHeader{{Key: "empty"}}},
+		},
+	}, {
+		// records compressed with codecs that aren't known are left undecoded
+		Attributes:    5,
+		ProducerID:    7,
+		ProducerEpoch: 1,
+	}}
+	var b []byte
+	for _, batch := range exp {
+		b = append(b, encodeTestRecordBatch(batch)...)
+	}
+	req.Equal(ErrNone, ValidateRecordSet(b))
+	act, err := ReadRecordBatches(b)
+	req.NoError(err)
+	req.Equal(exp, act)
+	req.False(act[0].Compressed())
+	req.True(act[1].Compressed())
+
+	// truncated records
+	b = encodeTestRecordBatch(exp[0])
+	Encoding.PutUint32(b[recordBatchHeaderLen-4:], 3)
+	_, err = ReadRecordBatches(b)
+	req.Equal(ErrInsufficientData, err)
+}
+
+func TestRecordBatchBytes(t *testing.T) {
+	req := require.New(t)
+	exp := &RecordBatch{
+		BaseOffset:      10,
+		Attributes:      RecordBatchTransactional,
+		LastOffsetDelta: 1,
+		FirstTimestamp:  1000,
+		MaxTimestamp:    1002,
+		ProducerID:      7,
+		ProducerEpoch:   2,
+		BaseSequence:    4,
+		Records: []Record{
+			{Key: []byte("key"), Value: []byte("value")},
+			{TimestampDelta: 2, OffsetDelta: 1, Headers: []RecordHeader{{Key: "type", Value: []byte("order")}}},
+		},
+	}
+	b := exp.Bytes()
+	req.Equal(encodeTestRecordBatch(exp), b)
+	req.Equal(ErrNone, ValidateRecordSet(b))
+	act, err := ReadRecordBatches(b)
+	req.NoError(err)
+	req.Equal([]*RecordBatch{exp}, act)
+}
+
+func TestRecordHeader(t *testing.T) {
+	req := require.New(t)
+	r := Record{Headers: []RecordHeader{{Key: "type", Value: []byte("order")}, {Key: "null"}, {Key: "type", Value: []byte("refund")}}}
+	v, ok := r.Header("type")
+	req.True(ok)
+	req.Equal([]byte("order"), v)
+	v, ok = r.Header("null")
+	req.True(ok)
+	req.Nil(v)
+	_, ok = r.Header("missing")
+	req.False(ok)
+}
+
+func TestEndTxnMarker(t *testing.T) {
+	req := require.New(t)
+	for _, committed := range []bool{true, false} {
+		b := EndTxnMarker(7, 2, 3, committed, time.Unix(1, 0))
+		req.Equal(ErrNone, ValidateRecordSet(b))
+		batches, err := ReadRecordBatches(b)
+		req.NoError(err)
+		req.Equal(1, len(batches))
+		batch := batches[0]
+		req.True(batch.Control())
+		req.True(batch.Transactional())
+		req.Equal(int64(7), batch.ProducerID)
+		req.Equal(int16(2), batch.ProducerEpoch)
+		req.Equal(int64(1000), batch.FirstTimestamp)
+		req.Equal(1, len(batch.Records))
+		typ, ok := ControlRecordType(batch.Records[0])
+		req.True(ok)
+		if committed {
+			req.Equal(ControlRecordCommit, typ)
+		} else {
+			req.Equal(ControlRecordAbort, typ)
+		}
+		// the coordinator epoch follows the value's version
+		req.Equal(uint32(3), Encoding.Uint32(batch.Records[0].Value[2:]))
+	}
+}
@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_RenewDelegationToken
+
+type RenewDelegationTokenRequest struct {
+	APIVersion int16
+
+	HMAC []byte
+	// RenewPeriod is -1 to renew the token for the broker's default expiry time.
+	RenewPeriod time.Duration
+}
+
+func (r *RenewDelegationTokenRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutBytes(r.HMAC); err != nil {
+		return err
+	}
+	e.PutInt64(int64(r.RenewPeriod / time.Millisecond))
+	return nil
+}
+
+func (r *RenewDelegationTokenRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.HMAC, err = d.Bytes(); err != nil {
+		return err
+	}
+	period, err := d.Int64()
+	if err != nil {
+		return err
+	}
+	r.RenewPeriod = time.Duration(period) * time.Millisecond
+	return nil
+}
+
+func (r *RenewDelegationTokenRequest) Key() int16 {
+	return RenewDelegationTokenKey
+}
+
+func (r *RenewDelegationTokenRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *RenewDelegationTokenRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddDuration("renew period", r.RenewPeriod)
+	return nil
+}
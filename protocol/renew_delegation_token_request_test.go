@@ -0,0 +1,22 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewDelegationTokenRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &RenewDelegationTokenRequest{
+		HMAC:        []byte("hmac"),
+		RenewPeriod: time.Hour,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act RenewDelegationTokenRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewDelegationTokenResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &RenewDelegationTokenResponse{
+		ErrorCode:    ErrDelegationTokenExpired.Code(),
+		ExpiryTime:   time.Unix(1600000000, 0),
+		ThrottleTime: time.Second,
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act RenewDelegationTokenResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -22,6 +22,9 @@ func (r *Request) Encode(pe PacketEncoder) (err error) {
 	if err = pe.PutString(r.ClientID); err != nil {
 		return err
 	}
+	if FlexibleVersion(r.Body.Key(), r.Body.Version()) {
+		pe.PutEmptyTaggedFields()
+	}
 	if err = r.Body.Encode(pe); err != nil {
 		return err
 	}
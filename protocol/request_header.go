@@ -24,6 +24,9 @@ func (r *RequestHeader) Encode(e PacketEncoder) {
 		// TODO: better err handling
 		panic(err)
 	}
+	if FlexibleVersion(r.APIKey, r.APIVersion) {
+		e.PutEmptyTaggedFields()
+	}
 }
 
 func (r *RequestHeader) Decode(d PacketDecoder) error {
@@ -44,8 +47,14 @@ func (r *RequestHeader) Decode(d PacketDecoder) error {
 	if err != nil {
 		return err
 	}
-	r.ClientID, err = d.String()
-	return err
+	// the client id isn't compact, even in flexible versions' headers
+	if r.ClientID, err = d.String(); err != nil {
+		return err
+	}
+	if FlexibleVersion(r.APIKey, r.APIVersion) {
+		return d.TaggedFields()
+	}
+	return nil
 }
 
 func (r *RequestHeader) MarshalLogObject(e zapcore.ObjectEncoder) error {
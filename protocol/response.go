@@ -16,8 +16,8 @@ type Response struct {
 func (r *Response) Encode(pe PacketEncoder) (err error) {
 	pe.Push(&SizeField{})
 	pe.PutInt32(r.CorrelationID)
-	if err != nil {
-		return err
+	if b, ok := r.Body.(Body); ok && flexibleResponseHeader(b.Key(), b.Version()) {
+		pe.PutEmptyTaggedFields()
 	}
 	err = r.Body.Encode(pe)
 	if err != nil {
@@ -35,8 +35,20 @@ func (r *Response) Decode(pd PacketDecoder, version int16) (err error) {
 	if r.CorrelationID, err = pd.Int32(); err != nil {
 		return err
 	}
-	if r.Body != nil {
-		return r.Body.Decode(pd, version)
+	if r.Body == nil {
+		return nil
+	}
+	if err := DecodeResponseHeader(pd, r.Body, version); err != nil {
+		return err
+	}
+	return r.Body.Decode(pd, version)
+}
+
+// DecodeResponseHeader decodes what's left of the header of a response to decode into body after
+// its correlation id, which is the tagged fields of flexible versions' headers.
+func DecodeResponseHeader(pd PacketDecoder, body VersionedDecoder, version int16) error {
+	if b, ok := body.(interface{ Key() int16 }); ok && flexibleResponseHeader(b.Key(), version) {
+		return pd.TaggedFields()
 	}
 	return nil
 }
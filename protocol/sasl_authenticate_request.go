@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_SaslAuthenticate
+
+type SaslAuthenticateRequest struct {
+	APIVersion int16
+
+	// AuthBytes are the SASL mechanism's bytes from the client.
+	AuthBytes []byte
+}
+
+func (r *SaslAuthenticateRequest) Encode(e PacketEncoder) (err error) {
+	return e.PutBytes(r.AuthBytes)
+}
+
+func (r *SaslAuthenticateRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	r.AuthBytes, err = d.Bytes()
+	return err
+}
+
+func (r *SaslAuthenticateRequest) Key() int16 {
+	return SaslAuthenticateKey
+}
+
+func (r *SaslAuthenticateRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *SaslAuthenticateRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	// leave the auth bytes out since they can hold credentials
+	e.AddInt("auth bytes", len(r.AuthBytes))
+	return nil
+}
@@ -0,0 +1,18 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaslAuthenticateRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &SaslAuthenticateRequest{APIVersion: 1, AuthBytes: []byte("\x00alice\x00secret")}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act SaslAuthenticateRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
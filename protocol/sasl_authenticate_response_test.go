@@ -0,0 +1,30 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaslAuthenticateResponse(t *testing.T) {
+	req := require.New(t)
+	msg := "authentication failed"
+	for _, version := range []int16{0, 1} {
+		exp := &SaslAuthenticateResponse{
+			APIVersion:   version,
+			ErrorCode:    ErrSaslAuthenticationFailed.Code(),
+			ErrorMessage: &msg,
+			AuthBytes:    []byte("challenge"),
+		}
+		if version >= 1 {
+			exp.SessionLifetime = time.Hour
+		}
+		b, err := Encode(exp)
+		req.NoError(err)
+		var act SaslAuthenticateResponse
+		err = Decode(b, &act, exp.Version())
+		req.NoError(err)
+		req.Equal(exp, &act)
+	}
+}
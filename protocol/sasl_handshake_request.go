@@ -4,16 +4,34 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-type SaslHandshakeRequest struct{}
+// https://kafka.apache.org/protocol#The_Messages_SaslHandshake
+
+type SaslHandshakeRequest struct {
+	APIVersion int16
+
+	// Mechanism is the SASL mechanism the client wants to authenticate with, like PLAIN.
+	Mechanism string
+}
 
 func (r *SaslHandshakeRequest) Encode(e PacketEncoder) (err error) {
-	return nil
+	return e.PutString(r.Mechanism)
 }
 
 func (r *SaslHandshakeRequest) Decode(d PacketDecoder, version int16) (err error) {
-	return nil
+	r.APIVersion = version
+	r.Mechanism, err = d.String()
+	return err
+}
+
+func (r *SaslHandshakeRequest) Key() int16 {
+	return SaslHandshakeKey
+}
+
+func (r *SaslHandshakeRequest) Version() int16 {
+	return r.APIVersion
 }
 
 func (r *SaslHandshakeRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddString("mechanism", r.Mechanism)
 	return nil
 }
@@ -0,0 +1,18 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaslHandshakeRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &SaslHandshakeRequest{APIVersion: 1, Mechanism: "PLAIN"}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act SaslHandshakeRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -4,16 +4,38 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-type SaslHandshakeResponse struct{}
+type SaslHandshakeResponse struct {
+	APIVersion int16
+
+	ErrorCode int16
+	// EnabledMechanisms are the SASL mechanisms the broker authenticates clients with.
+	EnabledMechanisms []string
+}
 
 func (r *SaslHandshakeResponse) Encode(e PacketEncoder) (err error) {
-	return nil
+	e.PutInt16(r.ErrorCode)
+	return e.PutStringArray(r.EnabledMechanisms)
 }
 
 func (r *SaslHandshakeResponse) Decode(d PacketDecoder, version int16) (err error) {
-	return nil
+	r.APIVersion = version
+	if r.ErrorCode, err = d.Int16(); err != nil {
+		return err
+	}
+	r.EnabledMechanisms, err = d.StringArray()
+	return err
+}
+
+func (r *SaslHandshakeResponse) Key() int16 {
+	return SaslHandshakeKey
+}
+
+func (r *SaslHandshakeResponse) Version() int16 {
+	return r.APIVersion
 }
 
 func (r *SaslHandshakeResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt16("error code", r.ErrorCode)
+	e.AddArray("enabled mechanisms", Strings(r.EnabledMechanisms))
 	return nil
 }
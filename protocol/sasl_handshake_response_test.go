@@ -0,0 +1,22 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaslHandshakeResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &SaslHandshakeResponse{
+		APIVersion:        1,
+		ErrorCode:         ErrUnsupportedSaslMechanism.Code(),
+		EnabledMechanisms: []string{"PLAIN", "SCRAM-SHA-256"},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act SaslHandshakeResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -40,6 +40,7 @@ func (r *StopReplicaResponse) Decode(d PacketDecoder, version int16) (err error)
 	}
 	r.Partitions = make([]*StopReplicaResponsePartition, partitionCount)
 	for i := range r.Partitions {
+		r.Partitions[i] = new(StopReplicaResponsePartition)
 		if r.Partitions[i].Topic, err = d.String(); err != nil {
 			return err
 		}
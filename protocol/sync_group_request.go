@@ -12,9 +12,11 @@ type GroupAssignment struct {
 type SyncGroupRequest struct {
 	APIVersion int16
 
-	GroupID          string
-	GenerationID     int32
-	MemberID         string
+	GroupID      string
+	GenerationID int32
+	MemberID     string
+	// GroupInstanceID is sent from version 3.
+	GroupInstanceID  *string
 	GroupAssignments []GroupAssignment
 }
 
@@ -26,6 +28,11 @@ func (r *SyncGroupRequest) Encode(e PacketEncoder) error {
 	if err := e.PutString(r.MemberID); err != nil {
 		return err
 	}
+	if r.APIVersion >= 3 {
+		if err := e.PutNullableString(r.GroupInstanceID); err != nil {
+			return err
+		}
+	}
 	if err := e.PutArrayLength(len(r.GroupAssignments)); err != nil {
 		return err
 	}
@@ -51,6 +58,11 @@ func (r *SyncGroupRequest) Decode(d PacketDecoder, version int16) (err error) {
 	if r.MemberID, err = d.String(); err != nil {
 		return
 	}
+	if r.APIVersion >= 3 {
+		if r.GroupInstanceID, err = d.NullableString(); err != nil {
+			return
+		}
+	}
 	groupAssignmentCount, err := d.ArrayLength()
 	if err != nil {
 		return err
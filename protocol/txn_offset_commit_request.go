@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// https://kafka.apache.org/protocol#The_Messages_TxnOffsetCommit
+
+// TxnOffsetCommitRequest commits a group's offsets as part of a transaction, they're only
+// visible once the transaction's committed.
+type TxnOffsetCommitRequest struct {
+	APIVersion int16
+
+	TransactionalID string
+	GroupID         string
+	ProducerID      int64
+	ProducerEpoch   int16
+	Topics          []TxnOffsetCommitTopic
+}
+
+type TxnOffsetCommitTopic struct {
+	Topic      string
+	Partitions []TxnOffsetCommitPartition
+}
+
+type TxnOffsetCommitPartition struct {
+	Partition int32
+	Offset    int64
+	Metadata  *string
+}
+
+func (r *TxnOffsetCommitRequest) Encode(e PacketEncoder) (err error) {
+	if err = e.PutString(r.TransactionalID); err != nil {
+		return err
+	}
+	if err = e.PutString(r.GroupID); err != nil {
+		return err
+	}
+	e.PutInt64(r.ProducerID)
+	e.PutInt16(r.ProducerEpoch)
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt64(p.Offset)
+			if err = e.PutNullableString(p.Metadata); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *TxnOffsetCommitRequest) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	if r.TransactionalID, err = d.String(); err != nil {
+		return err
+	}
+	if r.GroupID, err = d.String(); err != nil {
+		return err
+	}
+	if r.ProducerID, err = d.Int64(); err != nil {
+		return err
+	}
+	if r.ProducerEpoch, err = d.Int16(); err != nil {
+		return err
+	}
+	topicCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]TxnOffsetCommitTopic, topicCount)
+	for i := range r.Topics {
+		t := TxnOffsetCommitTopic{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]TxnOffsetCommitPartition, partitionCount)
+		for j := range t.Partitions {
+			p := TxnOffsetCommitPartition{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.Offset, err = d.Int64(); err != nil {
+				return err
+			}
+			if p.Metadata, err = d.NullableString(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *TxnOffsetCommitRequest) Key() int16 {
+	return TxnOffsetCommitKey
+}
+
+func (r *TxnOffsetCommitRequest) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *TxnOffsetCommitRequest) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddString("transactional id", r.TransactionalID)
+	e.AddString("group id", r.GroupID)
+	e.AddInt64("producer id", r.ProducerID)
+	e.AddInt16("producer epoch", r.ProducerEpoch)
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
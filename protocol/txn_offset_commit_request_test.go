@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnOffsetCommitRequest(t *testing.T) {
+	req := require.New(t)
+	metadata := "metadata"
+	exp := &TxnOffsetCommitRequest{
+		APIVersion:      1,
+		TransactionalID: "the-txn",
+		GroupID:         "the-group",
+		ProducerID:      7,
+		ProducerEpoch:   2,
+		Topics: []TxnOffsetCommitTopic{{
+			Topic: "the-topic",
+			Partitions: []TxnOffsetCommitPartition{
+				{Partition: 0, Offset: 10, Metadata: &metadata},
+				{Partition: 1, Offset: 20},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act TxnOffsetCommitRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type TxnOffsetCommitResponse struct {
+	APIVersion int16
+
+	ThrottleTime time.Duration
+	Topics       []TxnOffsetCommitTopicResponse
+}
+
+type TxnOffsetCommitTopicResponse struct {
+	Topic      string
+	Partitions []TxnOffsetCommitPartitionResponse
+}
+
+type TxnOffsetCommitPartitionResponse struct {
+	Partition int32
+	ErrorCode int16
+}
+
+func (r *TxnOffsetCommitResponse) Encode(e PacketEncoder) (err error) {
+	e.PutInt32(int32(r.ThrottleTime / time.Millisecond))
+	if err = e.PutArrayLength(len(r.Topics)); err != nil {
+		return err
+	}
+	for _, t := range r.Topics {
+		if err = e.PutString(t.Topic); err != nil {
+			return err
+		}
+		if err = e.PutArrayLength(len(t.Partitions)); err != nil {
+			return err
+		}
+		for _, p := range t.Partitions {
+			e.PutInt32(p.Partition)
+			e.PutInt16(p.ErrorCode)
+		}
+	}
+	return nil
+}
+
+func (r *TxnOffsetCommitResponse) Decode(d PacketDecoder, version int16) (err error) {
+	r.APIVersion = version
+	throttle, err := d.Int32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttle) * time.Millisecond
+	topicCount, err := d.ArrayLength()
+	if err != nil {
+		return err
+	}
+	r.Topics = make([]TxnOffsetCommitTopicResponse, topicCount)
+	for i := range r.Topics {
+		t := TxnOffsetCommitTopicResponse{}
+		if t.Topic, err = d.String(); err != nil {
+			return err
+		}
+		partitionCount, err := d.ArrayLength()
+		if err != nil {
+			return err
+		}
+		t.Partitions = make([]TxnOffsetCommitPartitionResponse, partitionCount)
+		for j := range t.Partitions {
+			p := TxnOffsetCommitPartitionResponse{}
+			if p.Partition, err = d.Int32(); err != nil {
+				return err
+			}
+			if p.ErrorCode, err = d.Int16(); err != nil {
+				return err
+			}
+			t.Partitions[j] = p
+		}
+		r.Topics[i] = t
+	}
+	return nil
+}
+
+func (r *TxnOffsetCommitResponse) Key() int16 {
+	return TxnOffsetCommitKey
+}
+
+func (r *TxnOffsetCommitResponse) Version() int16 {
+	return r.APIVersion
+}
+
+func (r *TxnOffsetCommitResponse) MarshalLogObject(e zapcore.ObjectEncoder) error {
+	e.AddInt("topics", len(r.Topics))
+	return nil
+}
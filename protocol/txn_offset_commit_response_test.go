@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnOffsetCommitResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &TxnOffsetCommitResponse{
+		APIVersion:   1,
+		ThrottleTime: time.Second,
+		Topics: []TxnOffsetCommitTopicResponse{{
+			Topic: "the-topic",
+			Partitions: []TxnOffsetCommitPartitionResponse{
+				{Partition: 0, ErrorCode: ErrNone.Code()},
+				{Partition: 1, ErrorCode: ErrUnknownTopicOrPartition.Code()},
+			},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act TxnOffsetCommitResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
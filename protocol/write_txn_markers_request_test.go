@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTxnMarkersRequest(t *testing.T) {
+	req := require.New(t)
+	exp := &WriteTxnMarkersRequest{
+		Markers: []WritableTxnMarker{{
+			ProducerID:    7,
+			ProducerEpoch: 2,
+			Committed:     true,
+			Topics: []WritableTxnMarkerTopic{
+				{Topic: "the-topic", Partitions: []int32{0, 2}},
+				{Topic: "another-topic", Partitions: []int32{1}},
+			},
+			CoordinatorEpoch: 3,
+		}, {
+			ProducerID:       8,
+			Topics:           []WritableTxnMarkerTopic{{Topic: "the-topic", Partitions: []int32{1}}},
+			CoordinatorEpoch: 3,
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act WriteTxnMarkersRequest
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}
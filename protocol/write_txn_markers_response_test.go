@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTxnMarkersResponse(t *testing.T) {
+	req := require.New(t)
+	exp := &WriteTxnMarkersResponse{
+		Markers: []WritableTxnMarkerResult{{
+			ProducerID: 7,
+			Topics: []WritableTxnMarkerTopicResult{{
+				Topic: "the-topic",
+				Partitions: []WritableTxnMarkerPartitionResult{
+					{Partition: 0, ErrorCode: ErrNone.Code()},
+					{Partition: 2, ErrorCode: ErrNotLeaderForPartition.Code()},
+				},
+			}},
+		}},
+	}
+	b, err := Encode(exp)
+	req.NoError(err)
+	var act WriteTxnMarkersResponse
+	err = Decode(b, &act, exp.Version())
+	req.NoError(err)
+	req.Equal(exp, &act)
+}